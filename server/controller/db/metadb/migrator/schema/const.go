@@ -20,5 +20,5 @@ const (
 	RAW_SQL_ROOT_DIR = "/etc/metadb/schema/rawsql"
 
 	DB_VERSION_TABLE    = "db_version"
-	DB_VERSION_EXPECTED = "7.1.0.39"
+	DB_VERSION_EXPECTED = "7.1.0.41"
 )
@@ -1629,6 +1629,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_service (
     `team_id`            INTEGER,
     `domain_id`          INTEGER,
     `sub_domain_id`      INTEGER,
+    `deleted_at`         DATETIME DEFAULT NULL,
     `updated_at`         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1644,6 +1645,7 @@ CREATE TABLE IF NOT EXISTS ch_chost (
     `hostname`        VARCHAR(256),
     `team_id`         INTEGER,
     `domain_id`       INTEGER,
+    `deleted_at`      DATETIME DEFAULT NULL,
     `updated_at`      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1783,6 +1785,7 @@ CREATE TABLE IF NOT EXISTS ch_gprocess (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1916,6 +1919,7 @@ CREATE TABLE IF NOT EXISTS ch_az (
     icon_id                 INTEGER,
     team_id                 INTEGER,
     domain_id               INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1928,6 +1932,7 @@ CREATE TABLE IF NOT EXISTS ch_l3_epc (
     icon_id                 INTEGER,
     team_id                 INTEGER,
     domain_id               INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1941,6 +1946,7 @@ CREATE TABLE IF NOT EXISTS ch_subnet (
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
     l3_epc_id               INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1953,6 +1959,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_cluster (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1966,6 +1973,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_node (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1979,6 +1987,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_ns (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -1994,6 +2003,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_group (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -2011,6 +2021,7 @@ CREATE TABLE IF NOT EXISTS ch_pod (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -2027,6 +2038,7 @@ CREATE TABLE IF NOT EXISTS ch_device (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     PRIMARY KEY (devicetype, deviceid),
     INDEX updated_at_index(`updated_at`)
@@ -2180,12 +2192,25 @@ TRUNCATE TABLE ch_ip_resource;
 CREATE TABLE IF NOT EXISTS ch_lb_listener (
     id                      INTEGER NOT NULL PRIMARY KEY,
     name                    VARCHAR(256),
+    lb_id                   INTEGER DEFAULT 0,
+    port                    INTEGER DEFAULT 0,
+    protocol                VARCHAR(64) DEFAULT '',
     team_id                 INTEGER,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
 TRUNCATE TABLE ch_lb_listener;
 
+CREATE TABLE IF NOT EXISTS ch_pod_ingress_rule (
+    id                      INTEGER NOT NULL PRIMARY KEY,
+    name                    VARCHAR(256),
+    pod_ingress_id          INTEGER DEFAULT 0,
+    team_id                 INTEGER,
+    updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX updated_at_index(`updated_at`)
+)ENGINE=innodb DEFAULT CHARSET=utf8;
+TRUNCATE TABLE ch_pod_ingress_rule;
+
 CREATE TABLE IF NOT EXISTS ch_pod_ingress (
     id                      INTEGER NOT NULL PRIMARY KEY,
     name                    VARCHAR(256),
@@ -2194,6 +2219,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_ingress (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              DATETIME DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX updated_at_index(`updated_at`)
 )ENGINE=innodb DEFAULT CHARSET=utf8;
@@ -0,0 +1,55 @@
+-- ColumnExists procedure
+DROP PROCEDURE IF EXISTS ColumnExists;
+
+CREATE PROCEDURE ColumnExists(
+    IN  p_table_name VARCHAR(255),
+    IN  p_col_name   VARCHAR(255),
+    OUT p_exists     TINYINT(1)
+)
+BEGIN
+    SELECT COUNT(*) > 0
+    INTO p_exists
+    FROM information_schema.columns
+    WHERE TABLE_SCHEMA = DATABASE()
+      AND TABLE_NAME   = p_table_name
+      AND COLUMN_NAME  = p_col_name;
+END;
+
+-- AddColumnIfNotExists procedure
+DROP PROCEDURE IF EXISTS AddColumnIfNotExists;
+
+CREATE PROCEDURE AddColumnIfNotExists(
+    IN tableName VARCHAR(255),
+    IN colName VARCHAR(255),
+    IN colType VARCHAR(255),
+    IN afterCol VARCHAR(255)
+)
+BEGIN
+    CALL ColumnExists(tableName, colName, @exists);
+    IF NOT @exists THEN
+        SET @sql = CONCAT('ALTER TABLE ', tableName, ' ADD COLUMN ', colName, ' ', colType, ' AFTER ', afterCol);
+        PREPARE stmt FROM @sql;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END;
+
+CALL AddColumnIfNotExists('ch_lb_listener', 'lb_id', 'INTEGER DEFAULT 0', 'name');
+CALL AddColumnIfNotExists('ch_lb_listener', 'port', 'INTEGER DEFAULT 0', 'lb_id');
+CALL AddColumnIfNotExists('ch_lb_listener', 'protocol', "VARCHAR(64) DEFAULT ''", 'port');
+
+-- Cleanup
+DROP PROCEDURE IF EXISTS ColumnExists;
+DROP PROCEDURE IF EXISTS AddColumnIfNotExists;
+
+CREATE TABLE IF NOT EXISTS ch_pod_ingress_rule (
+    id                      INTEGER NOT NULL PRIMARY KEY,
+    name                    VARCHAR(256),
+    pod_ingress_id          INTEGER DEFAULT 0,
+    team_id                 INTEGER,
+    updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX updated_at_index(`updated_at`)
+)ENGINE=innodb DEFAULT CHARSET=utf8;
+
+-- Update DB version
+UPDATE db_version SET version='7.1.0.40';
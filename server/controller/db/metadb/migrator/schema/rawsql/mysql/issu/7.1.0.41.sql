@@ -0,0 +1,56 @@
+-- ColumnExists procedure
+DROP PROCEDURE IF EXISTS ColumnExists;
+
+CREATE PROCEDURE ColumnExists(
+    IN  p_table_name VARCHAR(255),
+    IN  p_col_name   VARCHAR(255),
+    OUT p_exists     TINYINT(1)
+)
+BEGIN
+    SELECT COUNT(*) > 0
+    INTO p_exists
+    FROM information_schema.columns
+    WHERE TABLE_SCHEMA = DATABASE()
+      AND TABLE_NAME   = p_table_name
+      AND COLUMN_NAME  = p_col_name;
+END;
+
+-- AddColumnIfNotExists procedure
+DROP PROCEDURE IF EXISTS AddColumnIfNotExists;
+
+CREATE PROCEDURE AddColumnIfNotExists(
+    IN tableName VARCHAR(255),
+    IN colName VARCHAR(255),
+    IN colType VARCHAR(255),
+    IN afterCol VARCHAR(255)
+)
+BEGIN
+    CALL ColumnExists(tableName, colName, @exists);
+    IF NOT @exists THEN
+        SET @sql = CONCAT('ALTER TABLE ', tableName, ' ADD COLUMN ', colName, ' ', colType, ' AFTER ', afterCol);
+        PREPARE stmt FROM @sql;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END;
+
+CALL AddColumnIfNotExists('ch_az', 'deleted_at', 'DATETIME DEFAULT NULL', 'domain_id');
+CALL AddColumnIfNotExists('ch_chost', 'deleted_at', 'DATETIME DEFAULT NULL', 'domain_id');
+CALL AddColumnIfNotExists('ch_l3_epc', 'deleted_at', 'DATETIME DEFAULT NULL', 'domain_id');
+CALL AddColumnIfNotExists('ch_subnet', 'deleted_at', 'DATETIME DEFAULT NULL', 'l3_epc_id');
+CALL AddColumnIfNotExists('ch_pod_cluster', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+CALL AddColumnIfNotExists('ch_pod_node', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+CALL AddColumnIfNotExists('ch_pod_ns', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+CALL AddColumnIfNotExists('ch_pod_ingress', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+CALL AddColumnIfNotExists('ch_pod_service', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+CALL AddColumnIfNotExists('ch_pod_group', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+CALL AddColumnIfNotExists('ch_pod', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+CALL AddColumnIfNotExists('ch_gprocess', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+CALL AddColumnIfNotExists('ch_device', 'deleted_at', 'DATETIME DEFAULT NULL', 'sub_domain_id');
+
+-- Cleanup
+DROP PROCEDURE IF EXISTS ColumnExists;
+DROP PROCEDURE IF EXISTS AddColumnIfNotExists;
+
+-- Update DB version
+UPDATE db_version SET version='7.1.0.41';
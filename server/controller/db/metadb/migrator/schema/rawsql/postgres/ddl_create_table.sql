@@ -1677,6 +1677,7 @@ CREATE TABLE IF NOT EXISTS ch_az (
     icon_id                 INTEGER,
     team_id                 INTEGER,
     domain_id               INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_az;
@@ -1689,6 +1690,7 @@ CREATE TABLE IF NOT EXISTS ch_l3_epc (
     icon_id                 INTEGER,
     team_id                 INTEGER,
     domain_id               INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_l3_epc;
@@ -1702,6 +1704,7 @@ CREATE TABLE IF NOT EXISTS ch_subnet (
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
     l3_epc_id               INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_subnet;
@@ -1734,6 +1737,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_cluster (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_pod_cluster;
@@ -1747,6 +1751,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_node (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_pod_node;
@@ -1760,6 +1765,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_ns (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_pod_ns;
@@ -1775,6 +1781,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_group (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_pod_group;
@@ -1792,6 +1799,7 @@ CREATE TABLE IF NOT EXISTS ch_pod (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_pod;
@@ -1808,6 +1816,7 @@ CREATE TABLE IF NOT EXISTS ch_device (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     PRIMARY KEY (devicetype, deviceid)
 );
@@ -1817,12 +1826,25 @@ CREATE INDEX ch_device_updated_at_index ON ch_device(updated_at);
 CREATE TABLE IF NOT EXISTS ch_lb_listener (
     id                      INTEGER NOT NULL PRIMARY KEY,
     name                    VARCHAR(256),
+    lb_id                   INTEGER DEFAULT 0,
+    port                    INTEGER DEFAULT 0,
+    protocol                VARCHAR(64) DEFAULT '',
     team_id                 INTEGER,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_lb_listener;
 CREATE INDEX ch_lb_listener_updated_at_index ON ch_lb_listener(updated_at);
 
+CREATE TABLE IF NOT EXISTS ch_pod_ingress_rule (
+    id                      INTEGER NOT NULL PRIMARY KEY,
+    name                    VARCHAR(256),
+    pod_ingress_id          INTEGER DEFAULT 0,
+    team_id                 INTEGER,
+    updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+TRUNCATE TABLE ch_pod_ingress_rule;
+CREATE INDEX ch_pod_ingress_rule_updated_at_index ON ch_pod_ingress_rule(updated_at);
+
 CREATE TABLE IF NOT EXISTS ch_pod_ingress (
     id                      INTEGER NOT NULL PRIMARY KEY,
     name                    VARCHAR(256),
@@ -1831,6 +1853,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_ingress (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_pod_ingress;
@@ -1947,6 +1970,7 @@ CREATE TABLE IF NOT EXISTS ch_gprocess (
     team_id                 INTEGER,
     domain_id               INTEGER,
     sub_domain_id           INTEGER,
+    deleted_at              TIMESTAMP DEFAULT NULL,
     updated_at              TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_gprocess;
@@ -2077,6 +2101,7 @@ CREATE TABLE IF NOT EXISTS ch_pod_service (
     team_id                     INTEGER,
     domain_id                   INTEGER,
     sub_domain_id               INTEGER,
+    deleted_at                  TIMESTAMP DEFAULT NULL,
     updated_at                  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_pod_service;
@@ -2092,6 +2117,7 @@ CREATE TABLE IF NOT EXISTS ch_chost (
     hostname                    VARCHAR(256),
     team_id                     INTEGER,
     domain_id                   INTEGER,
+    deleted_at                  TIMESTAMP DEFAULT NULL,
     updated_at                  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 TRUNCATE TABLE ch_chost;
@@ -36,6 +36,15 @@ func (b ChUpdatedAtBase) GetUpdatedAt() time.Time {
 	return b.UpdatedAt
 }
 
+// ChDeletedAtBase 源资源软删除的时间，超过保留时长后由tagrecorder清理
+type ChDeletedAtBase struct {
+	DeletedAt *time.Time `gorm:"column:deleted_at;type:datetime;default:null" json:"DELETED_AT"`
+}
+
+func (b *ChDeletedAtBase) SetDeletedAt(deletedAt *time.Time) {
+	b.DeletedAt = deletedAt
+}
+
 type ChRegion struct {
 	ID        int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Name      string    `gorm:"column:name;type:varchar(64);default:null" json:"NAME"`
@@ -46,6 +55,7 @@ type ChRegion struct {
 type ChAZ struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	Name            string `gorm:"column:name;type:varchar(64);default:null" json:"NAME"`
 	IconID          int    `gorm:"column:icon_id;type:int;default:null" json:"ICON_ID"`
 	TeamID          int    `gorm:"column:team_id;type:int;not null" json:"TEAM_ID"`
@@ -55,6 +65,7 @@ type ChAZ struct {
 type ChVPC struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	Name            string `gorm:"column:name;type:varchar(64);default:null" json:"NAME"`
 	IconID          int    `gorm:"column:icon_id;type:int;default:null" json:"ICON_ID"`
 	UID             string `gorm:"column:uid;type:char(64);default:null" json:"UID"`
@@ -68,6 +79,7 @@ func (ChVPC) TableName() string {
 
 type ChDevice struct {
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	DeviceType      int    `gorm:"primaryKey;column:devicetype;type:int;not null" json:"DEVICETYPE"`
 	DeviceID        int    `gorm:"primaryKey;column:deviceid;type:int;not null" json:"DEVICEID"`
 	Name            string `gorm:"column:name;type:text;default:null" json:"NAME"`
@@ -181,6 +193,7 @@ func (ChIPResource) TableName() string {
 type ChNetwork struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	Name            string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
 	IconID          int    `gorm:"column:icon_id;type:int;default:null" json:"ICON_ID"`
 	TeamID          int    `gorm:"column:team_id;type:int;not null" json:"TEAM_ID"`
@@ -196,6 +209,7 @@ func (ChNetwork) TableName() string {
 type ChPod struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	// ID           int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Name         string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
 	IconID       int    `gorm:"column:icon_id;type:int;default:null" json:"ICON_ID"`
@@ -213,6 +227,7 @@ type ChPod struct {
 type ChPodCluster struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	Name            string `gorm:"column:name;type:varchar(64);default:null" json:"NAME"`
 	IconID          int    `gorm:"column:icon_id;type:int;default:null" json:"ICON_ID"`
 	TeamID          int    `gorm:"column:team_id;type:int;not null" json:"TEAM_ID"`
@@ -223,6 +238,7 @@ type ChPodCluster struct {
 type ChPodGroup struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	// ID           int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Name         string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
 	PodGroupType int    `gorm:"column:pod_group_type;type:int;default:null" json:"POD_GROUP_TYPE"`
@@ -238,6 +254,7 @@ type ChPodGroup struct {
 type ChPodNamespace struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	// ID           int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Name         string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
 	IconID       int    `gorm:"column:icon_id;type:int;default:null" json:"ICON_ID"`
@@ -255,6 +272,7 @@ func (ChPodNamespace) TableName() string {
 type ChPodNode struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	// ID           int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Name         string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
 	IconID       int    `gorm:"column:icon_id;type:int;default:null" json:"ICON_ID"`
@@ -293,8 +311,11 @@ type ChLBListener struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
 	// ID        int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
-	Name   string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
-	TeamID int    `gorm:"column:team_id;type:int;not null" json:"TEAM_ID"`
+	Name     string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
+	LBID     int    `gorm:"column:lb_id;type:int;default:0" json:"LB_ID"`
+	Port     int    `gorm:"column:port;type:int;default:0" json:"PORT"`
+	Protocol string `gorm:"column:protocol;type:varchar(64);default:''" json:"PROTOCOL"`
+	TeamID   int    `gorm:"column:team_id;type:int;not null" json:"TEAM_ID"`
 	// UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime:now,type:timestamp" json:"UPDATED_AT"`
 }
 
@@ -302,9 +323,22 @@ func (ChLBListener) TableName() string {
 	return "ch_lb_listener"
 }
 
+type ChPodIngressRule struct {
+	ChIDBase        `gorm:"embedded"`
+	ChUpdatedAtBase `gorm:"embedded"`
+	Name            string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
+	PodIngressID    int    `gorm:"column:pod_ingress_id;type:int;default:0" json:"POD_INGRESS_ID"`
+	TeamID          int    `gorm:"column:team_id;type:int;not null" json:"TEAM_ID"`
+}
+
+func (ChPodIngressRule) TableName() string {
+	return "ch_pod_ingress_rule"
+}
+
 type ChPodIngress struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	// ID           int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Name         string `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
 	TeamID       int    `gorm:"column:team_id;type:int;not null" json:"TEAM_ID"`
@@ -465,6 +499,7 @@ func (ChOSAppTags) TableName() string {
 type ChGProcess struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	Name            string `gorm:"column:name;type:text;default:null" json:"NAME"`
 	IconID          int    `gorm:"column:icon_id;type:int;default:null" json:"ICON_ID"`
 	CHostID         int    `gorm:"column:chost_id;type:int;not null" json:"CHOST_ID"`
@@ -595,6 +630,7 @@ type ChPrometheusTargetLabelLayout struct {
 type ChPodService struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	// ID           int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Name         string `gorm:"column:name;type:varchar(256)" json:"NAME"`
 	PodClusterID int    `gorm:"column:pod_cluster_id;type:int" json:"POD_CLUSTER_ID"`
@@ -608,6 +644,7 @@ type ChPodService struct {
 type ChChost struct {
 	ChIDBase        `gorm:"embedded"`
 	ChUpdatedAtBase `gorm:"embedded"`
+	ChDeletedAtBase `gorm:"embedded"`
 	// ID        int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Name     string `gorm:"column:name;type:varchar(256)" json:"NAME"`
 	L3EPCID  int    `gorm:"column:l3_epc_id;type:int" json:"L3_EPC_ID"`
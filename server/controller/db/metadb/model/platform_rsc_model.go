@@ -80,6 +80,10 @@ type SoftDeleteBase struct {
 	DeletedAt    gorm.DeletedAt `gorm:"column:deleted_at;type:datetime;default:null" json:"DELETED_AT" mapstructure:"DELETED_AT"`
 }
 
+func (b SoftDeleteBase) GetDeletedAt() gorm.DeletedAt {
+	return b.DeletedAt
+}
+
 type Process struct {
 	Base           `gorm:"embedded" mapstructure:",squash"`
 	SoftDeleteBase `gorm:"embedded" mapstructure:",squash"`
@@ -24,6 +24,7 @@ import (
 
 	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
 	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	trcheck "github.com/deepflowio/deepflow/server/controller/tagrecorder/check"
 	"github.com/deepflowio/deepflow/server/libs/logger"
 )
 
@@ -61,6 +62,10 @@ func (s *Health) RegisterTo(e *gin.Engine) {
 			)
 		}
 	})
+	// ch表与metadb的一致性检查结果，只有master controller运行检查，其他controller的STATE为master only
+	e.GET("/v1/health/tagrecorder/", func(c *gin.Context) {
+		response.JSON(c, response.SetData(trcheck.GetChecker().GetStatus()))
+	})
 }
 
 func SetInitStageForHealthChecker(s string) {
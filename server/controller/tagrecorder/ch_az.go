@@ -103,6 +103,6 @@ func (a *ChAZ) sourceToTarget(md *message.Metadata, az *metadbmodel.AZ) (keys []
 func (a *ChAZ) softDeletedTargetsUpdated(targets []metadbmodel.ChAZ, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
@@ -90,6 +90,6 @@ func (c *ChChost) onResourceUpdated(md *message.Metadata, updateMessage *message
 func (c *ChChost) softDeletedTargetsUpdated(targets []metadbmodel.ChChost, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
@@ -100,7 +100,7 @@ func (c *ChVMDevice) onResourceUpdated(md *message.Metadata, updateMessage *mess
 func (c *ChVMDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -176,7 +176,7 @@ func (c *ChHostDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice,
 
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -248,7 +248,7 @@ func (c *ChVRouterDevice) onResourceUpdated(md *message.Metadata, updateMessage
 func (c *ChVRouterDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -320,7 +320,7 @@ func (c *ChDHCPPortDevice) onResourceUpdated(md *message.Metadata, updateMessage
 func (c *ChDHCPPortDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -393,7 +393,7 @@ func (c *ChNATGatewayDevice) onResourceUpdated(md *message.Metadata, updateMessa
 func (c *ChNATGatewayDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -466,7 +466,7 @@ func (c *ChLBDevice) onResourceUpdated(md *message.Metadata, updateMessage *mess
 func (c *ChLBDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -537,7 +537,7 @@ func (c *ChRDSInstanceDevice) onResourceUpdated(md *message.Metadata, updateMess
 func (c *ChRDSInstanceDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -608,7 +608,7 @@ func (c *ChRedisInstanceDevice) onResourceUpdated(md *message.Metadata, updateMe
 func (c *ChRedisInstanceDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -692,7 +692,7 @@ func (c *ChPodServiceDevice) onResourceUpdated(md *message.Metadata, updateMessa
 func (c *ChPodServiceDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -763,7 +763,7 @@ func (c *ChPodDevice) onResourceUpdated(md *message.Metadata, updateMessage *mes
 func (c *ChPodDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -834,7 +834,7 @@ func (c *ChPodGroupDevice) onResourceUpdated(md *message.Metadata, updateMessage
 func (c *ChPodGroupDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -907,7 +907,7 @@ func (c *ChPodNodeDevice) onResourceUpdated(md *message.Metadata, updateMessage
 func (c *ChPodNodeDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -980,7 +980,7 @@ func (c *ChPodClusterDevice) onResourceUpdated(md *message.Metadata, updateMessa
 func (c *ChPodClusterDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -1054,7 +1054,7 @@ func (c *ChProcessDevice) onResourceUpdated(md *message.Metadata, updateMessage
 func (c *ChProcessDevice) softDeletedTargetsUpdated(targets []metadbmodel.ChDevice, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "deviceid"}, {Name: "devicetype"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
@@ -97,7 +97,7 @@ func (c *ChGProcess) onResourceUpdated(md *message.Metadata, updateMessage *mess
 func (c *ChGProcess) softDeletedTargetsUpdated(targets []metadbmodel.ChGProcess, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
 
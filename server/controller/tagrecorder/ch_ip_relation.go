@@ -19,12 +19,36 @@ package tagrecorder
 import (
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/controller/db/metadb"
 	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/libs/logger"
 )
 
+// 连续增量刷新的最大轮数，之后与ch表全量对比一次，修正ch表被直接修改等造成的偏差
+const IP_RELATION_FULL_DIFF_ROUNDS = 10
+
+// ipRelationGeneration 组织上一次成功写入的ch_ip_relation数据，rounds为自上次全量对比后增量刷新的轮数
+type ipRelationGeneration struct {
+	keyToDBItem map[IPRelationKey]metadbmodel.ChIPRelation
+	rounds      int
+}
+
+// 各组织上一次成功写入的ch_ip_relation数据，updater每次刷新都会重新创建，因此放在包级别保存
+var (
+	ipRelationGenerationsLock sync.Mutex
+	ipRelationGenerations     = make(map[int]ipRelationGeneration)
+)
+
+// invalidateIPRelationGeneration 丢弃组织上一次的数据，下次刷新与ch表全量对比
+func invalidateIPRelationGeneration(orgID int) {
+	ipRelationGenerationsLock.Lock()
+	defer ipRelationGenerationsLock.Unlock()
+	delete(ipRelationGenerations, orgID)
+}
+
 // 以VPCID和IP为key，获取IP关联的NAT网关、负载均衡、负载均衡监听器、容器Ingress和容器服务数据
 type ChIPRelation struct {
 	UpdaterComponent[metadbmodel.ChIPRelation, IPRelationKey]
@@ -40,6 +64,115 @@ func NewChIPRelation() *ChIPRelation {
 	return updater
 }
 
+type ipRelationChanges struct {
+	added   int
+	updated int
+	deleted int
+}
+
+// Refresh 与上一次生成的数据做增量对比，只写入新增、变化和删除的行；
+// 重启后的第一次刷新、每IP_RELATION_FULL_DIFF_ROUNDS轮及一致性检查发现差异后，与ch表做全量对比
+func (i *ChIPRelation) Refresh() {
+	orgIDs, err := metadb.GetORGIDs()
+	if err != nil {
+		log.Errorf("get org info fail : %s", err)
+		return
+	}
+
+	for _, orgID := range orgIDs {
+		db, err := metadb.GetDB(orgID)
+		if err != nil {
+			log.Error("get org dbinfo fail", logger.NewORGPrefix(orgID))
+			continue
+		}
+		GetTeamInfo(db)
+		if err := i.RefreshORG(db); err != nil {
+			log.Errorf("failed to refresh %s, rollback: %s", i.resourceTypeName, err, db.LogPrefixORGID)
+		}
+	}
+}
+
+// RefreshORG 增量刷新一个组织的ch_ip_relation
+func (i *ChIPRelation) RefreshORG(db *metadb.DB) error {
+	_, err := i.refreshIncremental(db)
+	return err
+}
+
+// Check 与ch表全量对比；有差异时上一次的数据已不可信，无论是否修复都丢弃，下次刷新全量对比
+func (i *ChIPRelation) Check(db *metadb.DB, repair bool) (*CheckResult, error) {
+	result, err := i.UpdaterComponent.Check(db, repair)
+	if err != nil || result == nil || !result.Consistent() {
+		invalidateIPRelationGeneration(db.ORGID)
+	}
+	return result, err
+}
+
+func (i *ChIPRelation) refreshIncremental(db *metadb.DB) (ipRelationChanges, error) {
+	var changes ipRelationChanges
+	newKeyToDBItem, ok := i.generateNewData(db)
+	if !ok {
+		return changes, nil
+	}
+	ipRelationGenerationsLock.Lock()
+	generation, ok := ipRelationGenerations[db.ORGID]
+	ipRelationGenerationsLock.Unlock()
+	oldKeyToDBItem, rounds := generation.keyToDBItem, generation.rounds+1
+	if !ok || generation.rounds >= IP_RELATION_FULL_DIFF_ROUNDS {
+		if oldKeyToDBItem, ok = i.generateOldData(db); !ok {
+			return changes, nil
+		}
+		rounds = 0
+	}
+
+	keysToUpsert := []IPRelationKey{}
+	itemsToUpsert := []metadbmodel.ChIPRelation{}
+	for key, newDBItem := range newKeyToDBItem {
+		oldDBItem, exists := oldKeyToDBItem[key]
+		if !exists {
+			changes.added++
+		} else if _, updated := i.generateUpdateInfo(oldDBItem, newDBItem); updated {
+			changes.updated++
+		} else {
+			continue
+		}
+		keysToUpsert = append(keysToUpsert, key)
+		itemsToUpsert = append(itemsToUpsert, newDBItem)
+	}
+	keysToDelete := []IPRelationKey{}
+	itemsToDelete := []metadbmodel.ChIPRelation{}
+	for key, oldDBItem := range oldKeyToDBItem {
+		if _, exists := newKeyToDBItem[key]; !exists {
+			keysToDelete = append(keysToDelete, key)
+			itemsToDelete = append(itemsToDelete, oldDBItem)
+		}
+	}
+	changes.deleted = len(itemsToDelete)
+
+	// add 以主键冲突时覆盖的方式写入，新增和变化的行一起批量处理
+	err := transaction(db, func(tx *metadb.DB) error {
+		if len(itemsToUpsert) > 0 {
+			if err := i.dbOperator.batchPage(keysToUpsert, itemsToUpsert, i.dbOperator.add, tx); err != nil {
+				return err
+			}
+		}
+		if len(itemsToDelete) > 0 {
+			return i.dbOperator.batchPage(keysToDelete, itemsToDelete, i.dbOperator.delete, tx)
+		}
+		return nil
+	})
+
+	ipRelationGenerationsLock.Lock()
+	defer ipRelationGenerationsLock.Unlock()
+	if err != nil {
+		// 写入已回滚，丢弃上一次的数据，下次刷新重新与ch表全量对比
+		delete(ipRelationGenerations, db.ORGID)
+		return changes, err
+	}
+	ipRelationGenerations[db.ORGID] = ipRelationGeneration{keyToDBItem: newKeyToDBItem, rounds: rounds}
+	log.Infof("refresh %s, added: %d, updated: %d, deleted: %d", i.resourceTypeName, changes.added, changes.updated, changes.deleted, db.LogPrefixORGID)
+	return changes, nil
+}
+
 func (i *ChIPRelation) generateNewData(db *metadb.DB) (map[IPRelationKey]metadbmodel.ChIPRelation, bool) {
 	log.Infof("generate data for %s", i.resourceTypeName, db.LogPrefixORGID)
 	toolDS, ok := i.newToolDataSet(db)
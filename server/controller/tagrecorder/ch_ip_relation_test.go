@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tagrecorder
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/deepflowio/deepflow/server/controller/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbconfig "github.com/deepflowio/deepflow/server/controller/db/metadb/config"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	trtest "github.com/deepflowio/deepflow/server/controller/tagrecorder/test"
+	"github.com/deepflowio/deepflow/server/libs/logger"
+)
+
+const (
+	TEST_IP_RELATION_DB_FILE = "./ch_ip_relation_test.db"
+)
+
+type ChIPRelationTestSuite struct {
+	suite.Suite
+	db *metadb.DB
+}
+
+func TestChIPRelationSuite(t *testing.T) {
+	if _, err := os.Stat(TEST_IP_RELATION_DB_FILE); err == nil {
+		os.Remove(TEST_IP_RELATION_DB_FILE)
+	}
+	suite.Run(t, new(ChIPRelationTestSuite))
+}
+
+func (s *ChIPRelationTestSuite) SetupSuite() {
+	gormDB, err := gorm.Open(
+		sqlite.Open(TEST_IP_RELATION_DB_FILE),
+		&gorm.Config{NamingStrategy: schema.NamingStrategy{SingularTable: true}},
+	)
+	if err != nil {
+		fmt.Printf("create sqlite database failed: %s\n", err.Error())
+		os.Exit(1)
+	}
+	s.db = &metadb.DB{
+		DB:             gormDB,
+		ORGID:          1,
+		Name:           "test_db",
+		LogPrefixORGID: logger.NewORGPrefix(1),
+		LogPrefixName:  metadb.NewDBNameLogPrefix("test_db"),
+		Config:         metadbconfig.Config{Database: "test_db", Type: "SQLite"},
+	}
+	for _, val := range append(trtest.Models(),
+		&metadbmodel.WANIP{}, &metadbmodel.NATGateway{}, &metadbmodel.NATRule{}, &metadbmodel.NATVMConnection{},
+		&metadbmodel.LBListener{}, &metadbmodel.LBTargetServer{}, &metadbmodel.LBVMConnection{},
+		&metadbmodel.Pod{}, &metadbmodel.PodGroupPort{}, &metadbmodel.PodIngress{}, &metadbmodel.PodService{},
+		&metadbmodel.ChIPRelation{},
+	) {
+		s.db.AutoMigrate(val)
+	}
+}
+
+func (s *ChIPRelationTestSuite) TearDownSuite() {
+	sqlDB, _ := s.db.DB.DB()
+	sqlDB.Close()
+	os.Remove(TEST_IP_RELATION_DB_FILE)
+}
+
+func (s *ChIPRelationTestSuite) SetupTest() {
+	s.Require().NoError(trtest.Truncate(s.db.DB, append(trtest.Models(), &metadbmodel.ChIPRelation{})...))
+	_ = s.db.Exec("DROP TRIGGER IF EXISTS fail_ch_ip_relation").Error
+	ipRelationGenerationsLock.Lock()
+	delete(ipRelationGenerations, s.db.ORGID)
+	ipRelationGenerationsLock.Unlock()
+}
+
+func (s *ChIPRelationTestSuite) newUpdater() *ChIPRelation {
+	updater := NewChIPRelation()
+	cfg := config.ControllerConfig{}
+	cfg.TagRecorderCfg.MySQLBatchSize = 1
+	updater.SetConfig(cfg)
+	return updater
+}
+
+// seed 创建一个VPC及其下2个负载均衡器，每个负载均衡器1个网卡，每个网卡2个IP
+func (s *ChIPRelationTestSuite) seed() *trtest.Graph {
+	graph, err := trtest.NewRegion().WithVPC(1).WithLB(2).WithLANIP(2).Create(s.db.DB)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, graph.LBLANIPs)
+	return graph
+}
+
+// failAfterRows ch_ip_relation中已有rows行时再写入报错，模拟批量写入中途失败
+func (s *ChIPRelationTestSuite) failAfterRows(rows int) {
+	s.Require().NoError(s.db.Exec(fmt.Sprintf(
+		"CREATE TRIGGER fail_ch_ip_relation BEFORE INSERT ON ch_ip_relation WHEN (SELECT COUNT(*) FROM ch_ip_relation) >= %d BEGIN SELECT RAISE(ABORT, 'forced failure'); END", rows,
+	)).Error)
+}
+
+func (s *ChIPRelationTestSuite) ipRelationIPs() []string {
+	var ips []string
+	s.Require().NoError(s.db.Model(&metadbmodel.ChIPRelation{}).Order("ip").Pluck("ip", &ips).Error)
+	return ips
+}
+
+func (s *ChIPRelationTestSuite) TestRefreshIncremental() {
+	graph := s.seed()
+
+	// 重启后第一次刷新，全量写入
+	changes, err := s.newUpdater().refreshIncremental(s.db)
+	s.Require().NoError(err)
+	s.Equal(ipRelationChanges{added: 4}, changes)
+
+	// 基础数据不变，不写入任何行
+	changes, err = s.newUpdater().refreshIncremental(s.db)
+	s.Require().NoError(err)
+	s.Equal(ipRelationChanges{}, changes)
+
+	// 修改一个LANIP所属的负载均衡器，只更新一行
+	s.Require().NoError(s.db.Model(&metadbmodel.LANIP{}).Where("id = ?", graph.LANIPIDs[1]).Update("vifid", graph.VInterfaceIDs[1]).Error)
+	changes, err = s.newUpdater().refreshIncremental(s.db)
+	s.Require().NoError(err)
+	s.Equal(ipRelationChanges{updated: 1}, changes)
+
+	var relations []metadbmodel.ChIPRelation
+	s.Require().NoError(s.db.Order("ip").Find(&relations).Error)
+	s.Require().Len(relations, 4)
+	s.Equal(graph.LBIDs[0], relations[0].LBID)
+	s.Equal(graph.LBIDs[1], relations[1].LBID)
+	s.Equal(graph.LBIDs[1], relations[2].LBID)
+	s.Equal(graph.LBIDs[1], relations[3].LBID)
+	s.Equal("lb-2", relations[1].LBName)
+
+	// 删除LANIP
+	s.Require().NoError(s.db.Delete(&metadbmodel.LANIP{}, graph.LANIPIDs[0]).Error)
+	changes, err = s.newUpdater().refreshIncremental(s.db)
+	s.Require().NoError(err)
+	s.Equal(ipRelationChanges{deleted: 1}, changes)
+	var count int64
+	s.db.Model(&metadbmodel.ChIPRelation{}).Count(&count)
+	s.Equal(int64(3), count)
+}
+
+func (s *ChIPRelationTestSuite) TestRefreshRollback() {
+	graph := s.seed()
+	s.Require().NoError(s.db.Create(&metadbmodel.ChIPRelation{L3EPCID: graph.VPCIDs[0], IP: "10.0.0.9", LBID: 3}).Error)
+
+	// 每批1行，写入2批后失败，已写入的批次随事务回滚
+	s.failAfterRows(3)
+	updater := s.newUpdater()
+	err := updater.UpdaterComponent.refreshORG(s.db)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "forced failure")
+	s.Equal([]string{"10.0.0.9"}, s.ipRelationIPs())
+
+	s.Require().NoError(s.db.Exec("DROP TRIGGER fail_ch_ip_relation").Error)
+	batchWriterCounter.GetCounter()
+	s.Require().NoError(updater.UpdaterComponent.refreshORG(s.db))
+	s.Equal(graph.LBLANIPs, s.ipRelationIPs())
+	counter := batchWriterCounter.GetCounter().(*BatchCounter)
+	s.Equal(uint64(5), counter.Rows)
+	s.Equal(uint64(5), counter.Batches)
+}
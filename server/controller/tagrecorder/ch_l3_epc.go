@@ -92,6 +92,6 @@ func (c *ChVPC) onResourceUpdated(md *message.Metadata, updateMessage *message.U
 func (c *ChVPC) softDeletedTargetsUpdated(targets []metadbmodel.ChVPC, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
@@ -66,12 +66,18 @@ func (l *ChLbListener) generateNewData(db *metadb.DB) (map[IDKey]metadbmodel.ChL
 			keyToItem[IDKey{ID: lbListener.ID}] = metadbmodel.ChLBListener{
 				ChIDBase: metadbmodel.ChIDBase{ID: lbListener.ID},
 				Name:     lbListener.Name + " (deleted)",
+				LBID:     lbListener.LBID,
+				Port:     lbListener.Port,
+				Protocol: lbListener.Protocol,
 				TeamID:   DomainToTeamID[lbListener.Domain],
 			}
 		} else {
 			keyToItem[IDKey{ID: lbListener.ID}] = metadbmodel.ChLBListener{
 				ChIDBase: metadbmodel.ChIDBase{ID: lbListener.ID},
 				Name:     lbListener.Name,
+				LBID:     lbListener.LBID,
+				Port:     lbListener.Port,
+				Protocol: lbListener.Protocol,
 				TeamID:   DomainToTeamID[lbListener.Domain],
 			}
 		}
@@ -88,6 +94,15 @@ func (l *ChLbListener) generateUpdateInfo(oldItem, newItem metadbmodel.ChLBListe
 	if oldItem.Name != newItem.Name {
 		updateInfo["name"] = newItem.Name
 	}
+	if oldItem.LBID != newItem.LBID {
+		updateInfo["lb_id"] = newItem.LBID
+	}
+	if oldItem.Port != newItem.Port {
+		updateInfo["port"] = newItem.Port
+	}
+	if oldItem.Protocol != newItem.Protocol {
+		updateInfo["protocol"] = newItem.Protocol
+	}
 	if len(updateInfo) > 0 {
 		return updateInfo, true
 	}
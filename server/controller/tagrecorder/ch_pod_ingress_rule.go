@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tagrecorder
+
+import (
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+type ChPodIngressRule struct {
+	UpdaterComponent[metadbmodel.ChPodIngressRule, IDKey]
+}
+
+func NewChPodIngressRule() *ChPodIngressRule {
+	updater := &ChPodIngressRule{
+		newUpdaterComponent[metadbmodel.ChPodIngressRule, IDKey](
+			RESOURCE_TYPE_CH_POD_INGRESS_RULE,
+		),
+	}
+
+	updater.updaterDG = updater
+	return updater
+}
+
+func (r *ChPodIngressRule) generateNewData(db *metadb.DB) (map[IDKey]metadbmodel.ChPodIngressRule, bool) {
+	log.Infof("generate data for %s", r.resourceTypeName, db.LogPrefixORGID)
+	var podIngressRules []metadbmodel.PodIngressRule
+	err := db.Find(&podIngressRules).Error
+	if err != nil {
+		log.Errorf(dbQueryResourceFailed(r.resourceTypeName, err), db.LogPrefixORGID)
+		return nil, false
+	}
+
+	// pod_ingress_rule随pod_ingress一起硬删除，源数据不存在时删除对应的ch数据
+	keyToItem := make(map[IDKey]metadbmodel.ChPodIngressRule)
+	for _, podIngressRule := range podIngressRules {
+		keyToItem[IDKey{ID: podIngressRule.ID}] = metadbmodel.ChPodIngressRule{
+			ChIDBase:     metadbmodel.ChIDBase{ID: podIngressRule.ID},
+			Name:         podIngressRule.Name,
+			PodIngressID: podIngressRule.PodIngressID,
+			TeamID:       DomainToTeamID[podIngressRule.Domain],
+		}
+	}
+	return keyToItem, true
+}
+
+func (r *ChPodIngressRule) generateKey(dbItem metadbmodel.ChPodIngressRule) IDKey {
+	return IDKey{ID: dbItem.ID}
+}
+
+func (r *ChPodIngressRule) generateUpdateInfo(oldItem, newItem metadbmodel.ChPodIngressRule) (map[string]interface{}, bool) {
+	updateInfo := make(map[string]interface{})
+	if oldItem.Name != newItem.Name {
+		updateInfo["name"] = newItem.Name
+	}
+	if oldItem.PodIngressID != newItem.PodIngressID {
+		updateInfo["pod_ingress_id"] = newItem.PodIngressID
+	}
+	if oldItem.TeamID != newItem.TeamID {
+		updateInfo["team_id"] = newItem.TeamID
+	}
+	if len(updateInfo) > 0 {
+		return updateInfo, true
+	}
+	return nil, false
+}
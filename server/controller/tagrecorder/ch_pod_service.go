@@ -88,6 +88,6 @@ func (c *ChPodService) onResourceUpdated(md *message.Metadata, updateMessage *me
 func (c *ChPodService) softDeletedTargetsUpdated(targets []metadbmodel.ChPodService, db *metadb.DB) {
 	db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"name", "deleted_at"}),
 	}).Create(&targets)
 }
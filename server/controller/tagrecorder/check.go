@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tagrecorder
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+)
+
+// CheckResult 一个组织中ch表与源数据的一致性检查结果
+type CheckResult struct {
+	ResourceType string          `json:"RESOURCE_TYPE"`
+	ORGID        int             `json:"ORG_ID"`
+	Missing      []string        `json:"MISSING"`    // 源数据中存在、ch表中缺失的数据key
+	Orphaned     []string        `json:"ORPHANED"`   // ch表中存在、源数据中不存在的数据key
+	Mismatched   []CheckMismatch `json:"MISMATCHED"` // 字段与源数据不一致的数据
+	Repaired     bool            `json:"REPAIRED"`
+}
+
+// CheckMismatch 字段不一致的数据key，及不一致的字段按源数据应更新为的值
+type CheckMismatch struct {
+	Key    string                 `json:"KEY"`
+	Fields map[string]interface{} `json:"FIELDS"`
+}
+
+func (r *CheckResult) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Orphaned) == 0 && len(r.Mismatched) == 0
+}
+
+func (r *CheckResult) String() string {
+	return fmt.Sprintf("%s missing: %v, orphaned: %v, mismatched: %v, repaired: %t", r.ResourceType, r.Missing, r.Orphaned, r.Mismatched, r.Repaired)
+}
+
+// Check 对比组织的源数据与ch表，返回ch表中缺失、多余及字段不一致的数据，repair为true时按差异修复ch表
+func (b *UpdaterComponent[MT, KT]) Check(db *metadb.DB, repair bool) (*CheckResult, error) {
+	diff, ok := b.generateDiff(db)
+	if !ok {
+		return nil, fmt.Errorf("failed to generate %s data", b.resourceTypeName)
+	}
+	result := &CheckResult{ResourceType: b.resourceTypeName, ORGID: db.ORGID}
+	for _, key := range diff.keysToAdd {
+		result.Missing = append(result.Missing, checkKeyString(key))
+	}
+	for _, key := range diff.keysToDelete {
+		result.Orphaned = append(result.Orphaned, checkKeyString(key))
+	}
+	for i, key := range diff.keysToUpdate {
+		result.Mismatched = append(result.Mismatched, CheckMismatch{Key: checkKeyString(key), Fields: diff.updateInfos[i]})
+	}
+	// 按key排序，便于比较多次检查的结果
+	sort.Strings(result.Missing)
+	sort.Strings(result.Orphaned)
+	sort.Slice(result.Mismatched, func(i, j int) bool { return result.Mismatched[i].Key < result.Mismatched[j].Key })
+
+	if repair && !result.Consistent() {
+		if err := b.applyDiff(db, diff); err != nil {
+			return result, err
+		}
+		result.Repaired = true
+	}
+	return result, nil
+}
+
+func checkKeyString(key interface{}) string {
+	return fmt.Sprintf("%+v", key)
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package check
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/controller/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	"github.com/deepflowio/deepflow/server/controller/tagrecorder"
+	"github.com/deepflowio/deepflow/server/libs/logger"
+)
+
+var log = logger.MustGetLogger("tagrecorder.check")
+
+var (
+	checkerOnce sync.Once
+	checker     *Checker
+)
+
+const (
+	STATE_MASTER_ONLY = "master only" // 非master controller不运行检查
+	STATE_DISABLED    = "disabled"    // consistency-check-interval不大于0
+	STATE_NOT_RUN     = "not run"     // 已启动，尚未完成第一次检查
+	STATE_CHECKED     = "checked"
+)
+
+// Checker 检查各组织ch表与源数据的一致性，只在master controller中运行
+type Checker struct {
+	mutex     sync.RWMutex
+	ctx       context.Context // 最近一次Start的ctx，为空或已取消时说明不是master controller
+	state     string
+	checkedAt time.Time
+	results   []*tagrecorder.CheckResult
+}
+
+// Status 最近一次检查的结果，只包含不一致的ch表，未完成检查时CHECKED_AT及CONSISTENT为空
+type Status struct {
+	State      string                     `json:"STATE"`
+	CheckedAt  *time.Time                 `json:"CHECKED_AT,omitempty"`
+	Consistent *bool                      `json:"CONSISTENT,omitempty"`
+	Results    []*tagrecorder.CheckResult `json:"RESULTS"`
+}
+
+func GetChecker() *Checker {
+	checkerOnce.Do(func() {
+		checker = &Checker{}
+	})
+	return checker
+}
+
+func (c *Checker) Start(ctx context.Context, cfg config.ControllerConfig) {
+	interval := cfg.TagRecorderCfg.ConsistencyCheckInterval
+	if interval <= 0 {
+		c.reset(ctx, STATE_DISABLED)
+		return
+	}
+	c.reset(ctx, STATE_NOT_RUN)
+	log.Info("tagrecorder consistency checker started")
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+	LOOP:
+		for {
+			select {
+			case <-ticker.C:
+				c.Run(cfg, cfg.TagRecorderCfg.ConsistencyCheckRepair)
+			case <-ctx.Done():
+				break LOOP
+			}
+		}
+	}()
+}
+
+func (c *Checker) reset(ctx context.Context, state string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ctx = ctx
+	c.state = state
+	c.checkedAt = time.Time{}
+	c.results = nil
+}
+
+// Run 检查所有组织的所有updater，repair为true时修复不一致的ch表，返回不一致的检查结果
+func (c *Checker) Run(cfg config.ControllerConfig, repair bool) []*tagrecorder.CheckResult {
+	orgIDs, err := metadb.GetORGIDs()
+	if err != nil {
+		log.Errorf("get org info fail : %s", err)
+		return nil
+	}
+	domainLcuuidToIconID, resourceTypeToIconID, _ := tagrecorder.UpdateIconInfo(cfg)
+	results := []*tagrecorder.CheckResult{}
+	for _, orgID := range orgIDs {
+		db, err := metadb.GetDB(orgID)
+		if err != nil {
+			log.Error("get org dbinfo fail", logger.NewORGPrefix(orgID))
+			continue
+		}
+		results = append(results, RunORG(db, tagrecorder.NewUpdaters(cfg, domainLcuuidToIconID, resourceTypeToIconID), repair)...)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.state = STATE_CHECKED
+	c.checkedAt = time.Now()
+	c.results = results
+	return results
+}
+
+// RunORG 检查一个组织的各updater，返回不一致的检查结果
+func RunORG(db *metadb.DB, updaters []tagrecorder.Updater, repair bool) []*tagrecorder.CheckResult {
+	results := []*tagrecorder.CheckResult{}
+	for _, updater := range updaters {
+		result, err := updater.Check(db, repair)
+		if err != nil {
+			log.Errorf("failed to check %T: %s", updater, err.Error(), db.LogPrefixORGID)
+		}
+		if result == nil || result.Consistent() {
+			continue
+		}
+		log.Warningf("ch data is inconsistent with metadb, %s", result, db.LogPrefixORGID)
+		results = append(results, result)
+	}
+	return results
+}
+
+// GetStatus 返回最近一次检查的结果，供健康检查接口使用
+func (c *Checker) GetStatus() Status {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.ctx == nil || c.ctx.Err() != nil {
+		return Status{State: STATE_MASTER_ONLY}
+	}
+	status := Status{State: c.state, Results: c.results}
+	if c.state == STATE_CHECKED {
+		checkedAt, consistent := c.checkedAt, len(c.results) == 0
+		status.CheckedAt, status.Consistent = &checkedAt, &consistent
+	}
+	return status
+}
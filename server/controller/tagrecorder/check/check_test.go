@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package check
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/deepflowio/deepflow/server/controller/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbconfig "github.com/deepflowio/deepflow/server/controller/db/metadb/config"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/controller/tagrecorder"
+	trtest "github.com/deepflowio/deepflow/server/controller/tagrecorder/test"
+	"github.com/deepflowio/deepflow/server/libs/logger"
+)
+
+const (
+	TEST_CHECK_DB_FILE = "./check_test.db"
+)
+
+type CheckTestSuite struct {
+	suite.Suite
+	db    *metadb.DB
+	orgID int
+}
+
+func TestCheckSuite(t *testing.T) {
+	if _, err := os.Stat(TEST_CHECK_DB_FILE); err == nil {
+		os.Remove(TEST_CHECK_DB_FILE)
+	}
+	suite.Run(t, new(CheckTestSuite))
+}
+
+func (s *CheckTestSuite) SetupSuite() {
+	gormDB, err := gorm.Open(
+		sqlite.Open(TEST_CHECK_DB_FILE),
+		&gorm.Config{NamingStrategy: schema.NamingStrategy{SingularTable: true}},
+	)
+	if err != nil {
+		fmt.Printf("create sqlite database failed: %s\n", err.Error())
+		os.Exit(1)
+	}
+	s.db = &metadb.DB{
+		DB:            gormDB,
+		Name:          "test_db",
+		LogPrefixName: metadb.NewDBNameLogPrefix("test_db"),
+		Config:        metadbconfig.Config{Database: "test_db", Type: "SQLite"},
+	}
+	for _, val := range append(trtest.Models(),
+		&metadbmodel.WANIP{}, &metadbmodel.NATGateway{}, &metadbmodel.NATRule{}, &metadbmodel.NATVMConnection{},
+		&metadbmodel.LBListener{}, &metadbmodel.LBTargetServer{}, &metadbmodel.LBVMConnection{},
+		&metadbmodel.Pod{}, &metadbmodel.PodGroupPort{}, &metadbmodel.PodIngress{}, &metadbmodel.PodService{},
+		&metadbmodel.ChIPRelation{},
+	) {
+		s.db.AutoMigrate(val)
+	}
+}
+
+func (s *CheckTestSuite) TearDownSuite() {
+	sqlDB, _ := s.db.DB.DB()
+	sqlDB.Close()
+	os.Remove(TEST_CHECK_DB_FILE)
+}
+
+// SetupTest 清空数据，并使用新的组织ID，避免ch_ip_relation沿用上一个用例保存的数据
+func (s *CheckTestSuite) SetupTest() {
+	s.Require().NoError(trtest.Truncate(s.db.DB, append(trtest.Models(), &metadbmodel.ChIPRelation{})...))
+	_ = s.db.Exec("DROP TRIGGER IF EXISTS fail_ch_ip_relation").Error
+	s.orgID++
+	s.db.ORGID = s.orgID
+	s.db.LogPrefixORGID = logger.NewORGPrefix(s.orgID)
+}
+
+func (s *CheckTestSuite) newIPRelation() *tagrecorder.ChIPRelation {
+	updater := tagrecorder.NewChIPRelation()
+	cfg := config.ControllerConfig{}
+	cfg.TagRecorderCfg.MySQLBatchSize = 1
+	updater.SetConfig(cfg)
+	return updater
+}
+
+// seed 创建一个VPC及其下2个负载均衡器，每个负载均衡器1个网卡，每个网卡2个IP
+func (s *CheckTestSuite) seed() *trtest.Graph {
+	graph, err := trtest.NewRegion().WithVPC(1).WithLB(2).WithLANIP(2).Create(s.db.DB)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, graph.LBLANIPs)
+	return graph
+}
+
+func (s *CheckTestSuite) ipRelationIPs() []string {
+	var ips []string
+	s.Require().NoError(s.db.Model(&metadbmodel.ChIPRelation{}).Order("ip").Pluck("ip", &ips).Error)
+	return ips
+}
+
+func (s *CheckTestSuite) runCheck(updater tagrecorder.Updater, repair bool) []*tagrecorder.CheckResult {
+	return RunORG(s.db, []tagrecorder.Updater{updater}, repair)
+}
+
+// TestIPRelationDrift ch表被直接修改后，增量刷新基于上一次的数据无法发现差异，检查发现差异后下次刷新全量对比修正
+func (s *CheckTestSuite) TestIPRelationDrift() {
+	graph := s.seed()
+	updater := s.newIPRelation()
+	s.Require().NoError(updater.RefreshORG(s.db))
+	s.Empty(s.runCheck(updater, false))
+
+	s.Require().NoError(s.db.Where("ip = ?", "10.0.0.1").Delete(&metadbmodel.ChIPRelation{}).Error)
+	s.Require().NoError(updater.RefreshORG(s.db))
+	s.Equal(graph.LBLANIPs[1:], s.ipRelationIPs())
+
+	results := s.runCheck(updater, false)
+	s.Require().Len(results, 1)
+	s.Len(results[0].Missing, 1)
+	s.False(results[0].Repaired)
+	s.Require().NoError(updater.RefreshORG(s.db))
+	s.Equal(graph.LBLANIPs, s.ipRelationIPs())
+	s.Empty(s.runCheck(updater, false))
+}
+
+// TestIPRelationRepair 修复后ch表已与源数据一致，之后的刷新不再重复写入或删除
+func (s *CheckTestSuite) TestIPRelationRepair() {
+	graph := s.seed()
+	updater := s.newIPRelation()
+	s.Require().NoError(updater.RefreshORG(s.db))
+
+	s.Require().NoError(s.db.Model(&metadbmodel.ChIPRelation{}).Where("ip = ?", "10.0.0.2").Update("lb_name", "lb-x").Error)
+	s.Require().NoError(s.db.Create(&metadbmodel.ChIPRelation{L3EPCID: graph.VPCIDs[0], IP: "10.0.0.9", LBID: 3}).Error)
+	results := s.runCheck(updater, true)
+	s.Require().Len(results, 1)
+	s.Len(results[0].Orphaned, 1)
+	s.Len(results[0].Mismatched, 1)
+	s.True(results[0].Repaired)
+	s.Equal(graph.LBLANIPs, s.ipRelationIPs())
+
+	// 源数据变化后增量刷新基于ch表当前的数据
+	s.Require().NoError(s.db.Delete(&metadbmodel.LANIP{}, graph.LANIPIDs[0]).Error)
+	s.Require().NoError(updater.RefreshORG(s.db))
+	s.Equal(graph.LBLANIPs[1:], s.ipRelationIPs())
+	s.Empty(s.runCheck(updater, false))
+}
+
+// TestIPRelationPeriodicFullDiff 未运行检查时，连续增量刷新IP_RELATION_FULL_DIFF_ROUNDS轮后全量对比一次修正偏差
+func (s *CheckTestSuite) TestIPRelationPeriodicFullDiff() {
+	graph := s.seed()
+	updater := s.newIPRelation()
+	s.Require().NoError(updater.RefreshORG(s.db))
+
+	s.Require().NoError(s.db.Where("ip = ?", "10.0.0.4").Delete(&metadbmodel.ChIPRelation{}).Error)
+	for i := 0; i < tagrecorder.IP_RELATION_FULL_DIFF_ROUNDS; i++ {
+		s.Require().NoError(updater.RefreshORG(s.db))
+		s.Equal(graph.LBLANIPs[:3], s.ipRelationIPs(), "round: %d", i+1)
+	}
+	s.Require().NoError(updater.RefreshORG(s.db))
+	s.Equal(graph.LBLANIPs, s.ipRelationIPs())
+}
+
+func (s *CheckTestSuite) TestIPRelationRollback() {
+	graph := s.seed()
+	s.Require().NoError(s.db.Exec(
+		"CREATE TRIGGER fail_ch_ip_relation BEFORE INSERT ON ch_ip_relation WHEN (SELECT COUNT(*) FROM ch_ip_relation) >= 2 BEGIN SELECT RAISE(ABORT, 'forced failure'); END",
+	).Error)
+	updater := s.newIPRelation()
+	err := updater.RefreshORG(s.db)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "forced failure")
+	s.Empty(s.ipRelationIPs())
+
+	// 回滚后丢弃了上一次的数据，下次刷新与ch表全量对比
+	s.Require().NoError(s.db.Exec("DROP TRIGGER fail_ch_ip_relation").Error)
+	s.Require().NoError(updater.RefreshORG(s.db))
+	s.Equal(graph.LBLANIPs, s.ipRelationIPs())
+	s.Empty(s.runCheck(updater, false))
+}
+
+// TestIPRelationRandomGraphs 随机生成多组资源，ch_ip_relation应包含且仅包含负载均衡器网卡上的IP
+func (s *CheckTestSuite) TestIPRelationRandomGraphs() {
+	for seed := int64(1); seed <= 10; seed++ {
+		s.SetupTest()
+		graph, err := trtest.Random(seed).Create(s.db.DB)
+		s.Require().NoError(err, "seed: %d", seed)
+
+		updater := s.newIPRelation()
+		s.Require().NoError(updater.RefreshORG(s.db), "seed: %d", seed)
+		s.ElementsMatch(graph.LBLANIPs, s.ipRelationIPs(), "seed: %d", seed)
+		s.Empty(s.runCheck(updater, false), "seed: %d", seed)
+	}
+}
+
+// TestCheckerStatus 非master controller及尚未完成检查时不返回一致性结论
+func TestCheckerStatus(t *testing.T) {
+	c := &Checker{}
+	if status := c.GetStatus(); status.State != STATE_MASTER_ONLY || status.Consistent != nil || status.CheckedAt != nil {
+		t.Errorf("get %+v, want master only", status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := config.ControllerConfig{}
+	c.Start(ctx, cfg)
+	if status := c.GetStatus(); status.State != STATE_DISABLED || status.Consistent != nil {
+		t.Errorf("get %+v, want disabled", status)
+	}
+	cfg.TagRecorderCfg.ConsistencyCheckInterval = 3600
+	c.Start(ctx, cfg)
+	if status := c.GetStatus(); status.State != STATE_NOT_RUN || status.Consistent != nil || status.CheckedAt != nil {
+		t.Errorf("get %+v, want not run", status)
+	}
+
+	checkedAt := time.Now()
+	c.mutex.Lock()
+	c.state, c.checkedAt, c.results = STATE_CHECKED, checkedAt, []*tagrecorder.CheckResult{{}}
+	c.mutex.Unlock()
+	status := c.GetStatus()
+	if status.State != STATE_CHECKED || status.Consistent == nil || *status.Consistent || status.CheckedAt == nil || !status.CheckedAt.Equal(checkedAt) {
+		t.Errorf("get %+v, want inconsistent", status)
+	}
+
+	// 不再是master controller
+	cancel()
+	if status := c.GetStatus(); status.State != STATE_MASTER_ONLY || status.Consistent != nil || len(status.Results) != 0 {
+		t.Errorf("get %+v, want master only", status)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tagrecorder
+
+import (
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	trtest "github.com/deepflowio/deepflow/server/controller/tagrecorder/test"
+)
+
+func (s *UpdaterTestSuite) TestCheck() {
+	for i := 0; i < 2; i++ {
+		_, err := trtest.NewRegion().Create(s.db.DB)
+		s.Require().NoError(err)
+	}
+	updater := NewChRegion(nil, nil)
+	s.refresh(updater)
+
+	result, err := updater.Check(s.db, false)
+	s.Require().NoError(err)
+	s.True(result.Consistent())
+
+	// 分别构造ch表中缺失、多余及字段不一致的数据
+	s.Require().NoError(s.db.Delete(&metadbmodel.ChRegion{ID: 1}).Error)
+	s.Require().NoError(s.db.Create(&metadbmodel.ChRegion{ID: 3, Name: "region-3"}).Error)
+	s.Require().NoError(s.db.Model(&metadbmodel.ChRegion{ID: 2}).Update("name", "region-x").Error)
+
+	result, err = updater.Check(s.db, false)
+	s.Require().NoError(err)
+	s.False(result.Consistent())
+	s.Equal(RESOURCE_TYPE_CH_REGION, result.ResourceType)
+	s.Equal(1, result.ORGID)
+	s.Equal([]string{"{ID:1}"}, result.Missing)
+	s.Equal([]string{"{ID:3}"}, result.Orphaned)
+	s.Require().Len(result.Mismatched, 1)
+	s.Equal("{ID:2}", result.Mismatched[0].Key)
+	s.Equal(map[string]interface{}{"name": "region-2"}, result.Mismatched[0].Fields)
+	s.False(result.Repaired)
+
+	// 不修复时ch表保持不变
+	var ids []int
+	s.Require().NoError(s.db.Model(&metadbmodel.ChRegion{}).Order("id").Pluck("id", &ids).Error)
+	s.Equal([]int{2, 3}, ids)
+
+	// 修复后ch表与源数据一致
+	result, err = updater.Check(s.db, true)
+	s.Require().NoError(err)
+	s.True(result.Repaired)
+	var chRegions []metadbmodel.ChRegion
+	s.Require().NoError(s.db.Order("id").Find(&chRegions).Error)
+	s.Require().Len(chRegions, 2)
+	s.Equal("region-1", chRegions[0].Name)
+	s.Equal("region-2", chRegions[1].Name)
+
+	result, err = updater.Check(s.db, false)
+	s.Require().NoError(err)
+	s.True(result.Consistent())
+	s.False(result.Repaired)
+}
@@ -17,9 +17,12 @@
 package config
 
 type TagRecorderConfig struct {
-	Interval                  int `default:"60" yaml:"timeout"`
-	MySQLBatchSize            int `default:"1000" yaml:"mysql_batch_size"`
-	DictionaryRefreshInterval int `default:"60" yaml:"dictionary_refresh_interval"`
-	LiveViewRefreshSecond     int `default:"60" yaml:"live_view_refresh_second"`
-	DictionaryReloadInterval  int `default:"3600" yaml:"dictionary_reload_interval"`
+	Interval                  int  `default:"60" yaml:"timeout"`
+	MySQLBatchSize            int  `default:"1000" yaml:"mysql_batch_size"`
+	DictionaryRefreshInterval int  `default:"60" yaml:"dictionary_refresh_interval"`
+	LiveViewRefreshSecond     int  `default:"60" yaml:"live_view_refresh_second"`
+	DictionaryReloadInterval  int  `default:"3600" yaml:"dictionary_reload_interval"`
+	SoftDeletedRetention      int  `default:"168" yaml:"soft_deleted_retention"`
+	ConsistencyCheckInterval  int  `default:"3600" yaml:"consistency_check_interval"`
+	ConsistencyCheckRepair    bool `default:"false" yaml:"consistency_check_repair"`
 }
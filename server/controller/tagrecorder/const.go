@@ -22,6 +22,9 @@ import (
 	"github.com/deepflowio/deepflow/server/controller/common"
 )
 
+// 未配置mysql_batch_size时ch表每批写入的行数
+const DEFAULT_BATCH_SIZE = 1000
+
 const (
 	RESOURCE_TYPE_REGION                    = "region"
 	RESOURCE_TYPE_AZ                        = "az"
@@ -74,6 +77,7 @@ const (
 	RESOURCE_TYPE_CH_VTAP                      = "ch_vtap"
 	RESOURCE_TYPE_CH_VTAP_PORT                 = "ch_vtap_port"
 	RESOURCE_TYPE_CH_LB_LISTENER               = "ch_lb_listener"
+	RESOURCE_TYPE_CH_POD_INGRESS_RULE          = "ch_pod_ingress_rule"
 	RESOURCE_TYPE_CH_STRING_ENUM               = "ch_string_enum"
 	RESOURCE_TYPE_CH_INT_ENUM                  = "ch_int_enum"
 	RESOURCE_TYPE_CH_NODE_TYPE                 = "ch_node_type"
@@ -178,6 +182,8 @@ const (
 	CH_DICTIONARY_IP_PORT        = "ip_port_map"
 	CH_DICTIONARY_SERVER_PORT    = "server_port_map"
 
+	CH_DICTIONARY_POD_INGRESS_RULE = "pod_ingress_rule_map"
+
 	CH_DICTIONARY_IP_RELATION = "ip_relation_map"
 	CH_DICTIONARY_IP_RESOURCE = "ip_resource_map"
 
@@ -389,6 +395,20 @@ const (
 		"(\n" +
 		"    `id` UInt64,\n" +
 		"    `name` String,\n" +
+		"    `lb_id` UInt64,\n" +
+		"    `port` UInt64,\n" +
+		"    `protocol` String,\n" +
+		"    `team_id` UInt64\n" +
+		")\n" +
+		"PRIMARY KEY id\n" +
+		"%s" +
+		SQL_LIFETIME +
+		SQL_LAYOUT_FLAT
+	CREATE_POD_INGRESS_RULE_DICTIONARY_SQL = SQL_CREATE_DICT +
+		"(\n" +
+		"    `id` UInt64,\n" +
+		"    `name` String,\n" +
+		"    `pod_ingress_id` UInt64,\n" +
 		"    `team_id` UInt64\n" +
 		")\n" +
 		"PRIMARY KEY id\n" +
@@ -681,6 +701,8 @@ var CREATE_SQL_MAP = map[string]string{
 	CH_INT_DICTIONARY_ENUM:       CREATE_INT_ENUM_SQL,
 	CH_DICTIONARY_USER:           CREATE_ID_NAME_DICTIONARY_SQL,
 
+	CH_DICTIONARY_POD_INGRESS_RULE: CREATE_POD_INGRESS_RULE_DICTIONARY_SQL,
+
 	CH_DICTIONARY_POLICY:     CREATE_POLICY_DICTIONARY_SQL,
 	CH_DICTIONARY_NPB_TUNNEL: CREATE_NPB_TUNNEL_DICTIONARY_SQL,
 
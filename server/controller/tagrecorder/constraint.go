@@ -29,7 +29,7 @@ type MySQLChModel interface {
 		metadbmodel.ChPrometheusMetricAPPLabelLayout | metadbmodel.ChPodServiceK8sLabels | metadbmodel.ChPodServiceK8sLabel | metadbmodel.ChOSAppTags |
 		metadbmodel.ChOSAppTag | metadbmodel.ChPodNSCloudTags | metadbmodel.ChChostCloudTags | metadbmodel.ChPodNSCloudTag | metadbmodel.ChChostCloudTag | metadbmodel.ChIntEnum |
 		metadbmodel.ChStringEnum | metadbmodel.ChPodIngress | metadbmodel.ChVTapPort | metadbmodel.ChAZ | metadbmodel.ChIPResource | metadbmodel.ChPodK8sLabel |
-		metadbmodel.ChLBListener | metadbmodel.ChRegion | metadbmodel.ChVPC |
+		metadbmodel.ChLBListener | metadbmodel.ChPodIngressRule | metadbmodel.ChRegion | metadbmodel.ChVPC |
 		metadbmodel.ChDevice | metadbmodel.ChIPRelation | metadbmodel.ChPodGroup | metadbmodel.ChNetwork | metadbmodel.ChPod | metadbmodel.ChPodCluster |
 		metadbmodel.ChPodNode | metadbmodel.ChPodNamespace | metadbmodel.ChTapType | metadbmodel.ChVTap | metadbmodel.ChPodK8sLabels | metadbmodel.ChNodeType | metadbmodel.ChGProcess | metadbmodel.ChPodK8sAnnotation | metadbmodel.ChPodK8sAnnotations |
 		metadbmodel.ChPodServiceK8sAnnotation | metadbmodel.ChPodServiceK8sAnnotations |
@@ -17,6 +17,7 @@
 package tagrecorder
 
 import (
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
 	"github.com/deepflowio/deepflow/server/controller/config"
@@ -68,6 +69,9 @@ func (b *operatorComponent[MT, KT]) setUpdateMode(mode UpdateMode) {
 func (b *operatorComponent[MT, KT]) batchPage(keys []KT, items []MT, operateFunc func([]KT, []MT, *metadb.DB) error, db *metadb.DB) error {
 	count := len(items)
 	offset := b.cfg.TagRecorderCfg.MySQLBatchSize
+	if offset <= 0 {
+		offset = DEFAULT_BATCH_SIZE
+	}
 	var pages int
 	if count%offset == 0 {
 		pages = count / offset
@@ -84,10 +88,20 @@ func (b *operatorComponent[MT, KT]) batchPage(keys []KT, items []MT, operateFunc
 		if err != nil {
 			return err
 		}
+		batchWriterCounter.AddBatch(end - start)
 	}
 	return nil
 }
 
+// transaction 在同一个事务中执行fc，fc返回错误时回滚，ch表保留之前的数据
+func transaction(db *metadb.DB, fc func(*metadb.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		txDB := *db
+		txDB.DB = tx
+		return fc(&txDB)
+	})
+}
+
 func (b *operatorComponent[MT, KT]) add(keys []KT, dbItems []MT, db *metadb.DB) error {
 	err := db.Clauses(clause.OnConflict{
 		UpdateAll: true,
@@ -213,6 +213,7 @@ func (c *Dictionary) update(clickHouseCfg *clickhouse.ClickHouseConfig) {
 		CH_DICTIONARY_IP_PORT,
 		CH_DICTIONARY_SERVER_PORT,
 		CH_DICTIONARY_LB_LISTENER,
+		CH_DICTIONARY_POD_INGRESS_RULE,
 		CH_DICTIONARY_POD_INGRESS,
 		CH_DICTIONARY_NODE_TYPE,
 		CH_STRING_DICTIONARY_ENUM,
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tagrecorder
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/deepflowio/deepflow/server/controller/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+// 测试中替换以模拟时间推移
+var nowFunc = time.Now
+
+// 记录源资源软删除时间的ch表
+var softDeletedChModels = []interface{}{
+	&metadbmodel.ChAZ{}, &metadbmodel.ChChost{}, &metadbmodel.ChVPC{}, &metadbmodel.ChNetwork{}, &metadbmodel.ChDevice{},
+	&metadbmodel.ChPodCluster{}, &metadbmodel.ChPodNode{}, &metadbmodel.ChPodNamespace{}, &metadbmodel.ChPodIngress{},
+	&metadbmodel.ChPodService{}, &metadbmodel.ChPodGroup{}, &metadbmodel.ChPod{}, &metadbmodel.ChGProcess{},
+}
+
+type softDeletedSource interface {
+	GetDeletedAt() gorm.DeletedAt
+}
+
+type softDeletedTarget interface {
+	SetDeletedAt(*time.Time)
+}
+
+// softDeletedAt 返回源资源软删除的时间，未软删除或不支持软删除时返回nil
+func softDeletedAt(source interface{}) *time.Time {
+	s, ok := source.(softDeletedSource)
+	if !ok || !s.GetDeletedAt().Valid {
+		return nil
+	}
+	deletedAt := s.GetDeletedAt().Time
+	return &deletedAt
+}
+
+// softDeletedExpiredAt 返回软删除资源在ch表中的保留截止时间，早于该时间软删除的资源不再保留，
+// 保留时长不大于0时不清理
+func softDeletedExpiredAt(cfg config.ControllerConfig) (time.Time, bool) {
+	retention := cfg.TagRecorderCfg.SoftDeletedRetention
+	if retention <= 0 {
+		return time.Time{}, false
+	}
+	return nowFunc().Add(-time.Duration(retention) * time.Hour), true
+}
+
+// purgeSoftDeleted 删除源资源软删除时间早于expiredAt的ch数据
+func purgeSoftDeleted(db *metadb.DB, expiredAt time.Time) error {
+	return transaction(db, func(tx *metadb.DB) error {
+		for _, model := range softDeletedChModels {
+			result := tx.Where("deleted_at < ?", expiredAt).Delete(model)
+			if result.Error != nil {
+				log.Errorf("purge soft deleted %T failed: %s", model, result.Error.Error(), db.LogPrefixORGID)
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				log.Infof("purge soft deleted %T (deleted before: %s, count: %d) success", model, expiredAt, result.RowsAffected, db.LogPrefixORGID)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tagrecorder
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/deepflowio/deepflow/server/controller/config"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/controller/recorder/pubsub/message"
+)
+
+func (s *UpdaterTestSuite) TestSoftDeletedRetention() {
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func() { nowFunc = time.Now }()
+	nowFunc = func() time.Time { return deletedAt.Add(10 * time.Minute) }
+
+	cfg := config.ControllerConfig{}
+	cfg.TagRecorderCfg.SoftDeletedRetention = 1
+	md := message.NewMetadata(message.MetadataDB(s.db))
+	softDelete := metadbmodel.SoftDeleteBase{DeletedAt: gorm.DeletedAt{Time: deletedAt, Valid: true}}
+
+	azs := []*metadbmodel.AZ{
+		{Base: metadbmodel.Base{ID: 1, Lcuuid: "az-1"}, Name: "az-1", Domain: "domain"},
+		{Base: metadbmodel.Base{ID: 2, Lcuuid: "az-2"}, SoftDeleteBase: softDelete, Name: "az-2", Domain: "domain"},
+	}
+	vpcs := []*metadbmodel.VPC{
+		{Base: metadbmodel.Base{ID: 1, Lcuuid: "vpc-1"}, Name: "vpc-1", Domain: "domain"},
+		{Base: metadbmodel.Base{ID: 2, Lcuuid: "vpc-2"}, SoftDeleteBase: softDelete, Name: "vpc-2", Domain: "domain"},
+	}
+	vms := []*metadbmodel.VM{
+		{Base: metadbmodel.Base{ID: 1, Lcuuid: "vm-1"}, Name: "vm-1", VPCID: 1, Domain: "domain"},
+		{Base: metadbmodel.Base{ID: 2, Lcuuid: "vm-2"}, SoftDeleteBase: softDelete, Name: "vm-2", VPCID: 2, Domain: "domain"},
+	}
+
+	// 新增未删除的资源，软删除的资源按软删除消息更新
+	az := NewChAZ(map[string]int{"domain": 1}, nil)
+	az.SetConfig(cfg)
+	keys, targets := az.generateKeyTargets(md, azs[:1])
+	s.Require().NoError(az.dbOperator.add(keys, targets, s.db))
+	_, targets = az.generateKeyTargets(md, azs[1:])
+	az.subscriberDG.softDeletedTargetsUpdated(targets, s.db)
+
+	vpc := NewChVPC(nil)
+	vpc.SetConfig(cfg)
+	keys, targets2 := vpc.generateKeyTargets(md, vpcs[:1])
+	s.Require().NoError(vpc.dbOperator.add(keys, targets2, s.db))
+	_, targets2 = vpc.generateKeyTargets(md, vpcs[1:])
+	vpc.subscriberDG.softDeletedTargetsUpdated(targets2, s.db)
+
+	chost := NewChChost()
+	chost.SetConfig(cfg)
+	keys, targets3 := chost.generateKeyTargets(md, vms[:1])
+	s.Require().NoError(chost.dbOperator.add(keys, targets3, s.db))
+	_, targets3 = chost.generateKeyTargets(md, vms[1:])
+	chost.subscriberDG.softDeletedTargetsUpdated(targets3, s.db)
+
+	// 软删除的资源保留在ch表中并记录删除时间
+	var chAZs []metadbmodel.ChAZ
+	s.Require().NoError(s.db.Order("id").Find(&chAZs).Error)
+	s.Require().Len(chAZs, 2)
+	s.Nil(chAZs[0].DeletedAt)
+	s.Equal("az-2 (deleted)", chAZs[1].Name)
+	s.Require().NotNil(chAZs[1].DeletedAt)
+	s.True(deletedAt.Equal(*chAZs[1].DeletedAt))
+
+	var chVPCs []metadbmodel.ChVPC
+	s.Require().NoError(s.db.Order("id").Find(&chVPCs).Error)
+	s.Require().Len(chVPCs, 2)
+	s.Nil(chVPCs[0].DeletedAt)
+	s.Equal("vpc-2 (deleted)", chVPCs[1].Name)
+	s.NotNil(chVPCs[1].DeletedAt)
+
+	var chChosts []metadbmodel.ChChost
+	s.Require().NoError(s.db.Order("id").Find(&chChosts).Error)
+	s.Require().Len(chChosts, 2)
+	s.Nil(chChosts[0].DeletedAt)
+	s.Equal("vm-2 (deleted)", chChosts[1].Name)
+	s.Equal(2, chChosts[1].L3EPCID)
+	s.NotNil(chChosts[1].DeletedAt)
+
+	// 保留时长内不清理
+	expiredAt, ok := softDeletedExpiredAt(cfg)
+	s.Require().True(ok)
+	s.Require().NoError(purgeSoftDeleted(s.db, expiredAt))
+	var count int64
+	s.Require().NoError(s.db.Model(&metadbmodel.ChChost{}).Count(&count).Error)
+	s.Equal(int64(2), count)
+	s.Len(chost.filterExpiredSoftDeleted(vms), 2)
+
+	// 超过保留时长后清理软删除的资源，未删除的资源保留
+	nowFunc = func() time.Time { return deletedAt.Add(2 * time.Hour) }
+	expiredAt, _ = softDeletedExpiredAt(cfg)
+	s.Require().NoError(purgeSoftDeleted(s.db, expiredAt))
+	var ids []int
+	s.Require().NoError(s.db.Model(&metadbmodel.ChAZ{}).Pluck("id", &ids).Error)
+	s.Equal([]int{1}, ids)
+	s.Require().NoError(s.db.Model(&metadbmodel.ChVPC{}).Pluck("id", &ids).Error)
+	s.Equal([]int{1}, ids)
+	s.Require().NoError(s.db.Model(&metadbmodel.ChChost{}).Pluck("id", &ids).Error)
+	s.Equal([]int{1}, ids)
+
+	// 已清理的资源不再被重新添加
+	remains := chost.filterExpiredSoftDeleted(vms)
+	s.Require().Len(remains, 1)
+	s.Equal(1, remains[0].ID)
+
+	// 保留时长为0时不清理
+	cfg.TagRecorderCfg.SoftDeletedRetention = 0
+	_, ok = softDeletedExpiredAt(cfg)
+	s.False(ok)
+}
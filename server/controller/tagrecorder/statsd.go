@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tagrecorder
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/deepflowio/deepflow/server/libs/stats"
+)
+
+var (
+	batchWriterCounter         = newBatchWriterCounter()
+	batchWriterCounterRegister sync.Once
+)
+
+type BatchCounter struct {
+	Rows    uint64 `statsd:"rows"`
+	Batches uint64 `statsd:"batches"`
+}
+
+func (c *BatchCounter) AddBatch(rows int) {
+	atomic.AddUint64(&c.Rows, uint64(rows))
+	atomic.AddUint64(&c.Batches, 1)
+}
+
+// BatchWriterCounter 统计batchPage写入ch表的行数和执行的批次数
+type BatchWriterCounter struct {
+	*BatchCounter
+}
+
+func newBatchWriterCounter() *BatchWriterCounter {
+	return &BatchWriterCounter{
+		BatchCounter: &BatchCounter{},
+	}
+}
+
+func (c *BatchWriterCounter) GetCounter() interface{} {
+	counter := &BatchCounter{}
+	counter, c.BatchCounter = c.BatchCounter, counter
+	return counter
+}
+
+func (c *BatchWriterCounter) Closed() bool {
+	return false
+}
+
+func registerBatchWriterCounter() {
+	batchWriterCounterRegister.Do(func() {
+		err := stats.RegisterCountableWithModulePrefix("controller_", "tagrecorder_batch_writer", batchWriterCounter)
+		if err != nil {
+			log.Errorf("failed to register tagrecorder batch writer statsd: %s", err.Error())
+		}
+	})
+}
@@ -57,6 +57,7 @@ func GetSubscriberManager() *SubscriberManager {
 
 func (c *SubscriberManager) Init(cfg config.ControllerConfig) {
 	c.cfg = cfg
+	registerBatchWriterCounter()
 }
 
 func (c *SubscriberManager) Start() (err error) {
@@ -258,6 +259,14 @@ func (s *SubscriberComponent[MAPT, MAT, MUPT, MUT, MDPT, MDT, MT, CT, KT]) gener
 			log.Errorf("sourceToTarget returned mismatched lengths: keys=%d, targets=%d", len(ks), len(ts))
 			continue
 		}
+		// 记录源资源软删除的时间，超过保留时长后清理
+		if deletedAt := softDeletedAt(item); deletedAt != nil {
+			for i := range ts {
+				if target, ok := any(&ts[i]).(softDeletedTarget); ok {
+					target.SetDeletedAt(deletedAt)
+				}
+			}
+		}
 		// deduplicate
 		for i, k := range ks {
 			if !seenKeys[k] {
@@ -299,10 +308,26 @@ func (s *SubscriberComponent[MAPT, MAT, MUPT, MUT, MDPT, MDT, MT, CT, KT]) OnRes
 	if err != nil {
 		log.Error("get org dbinfo fail", logger.NewORGPrefix(md.GetORGID()))
 	}
-	keys, chItems := s.generateKeyTargets(md, dbItems)
+	keys, chItems := s.generateKeyTargets(md, s.filterExpiredSoftDeleted(dbItems))
 	s.dbOperator.batchPage(keys, chItems, s.dbOperator.add, db)
 }
 
+// filterExpiredSoftDeleted 过滤软删除时间超过保留时长的源资源，避免healer重新添加已清理的ch数据
+func (s *SubscriberComponent[MAPT, MAT, MUPT, MUT, MDPT, MDT, MT, CT, KT]) filterExpiredSoftDeleted(sources []*MT) []*MT {
+	expiredAt, ok := softDeletedExpiredAt(s.cfg)
+	if !ok {
+		return sources
+	}
+	result := make([]*MT, 0, len(sources))
+	for _, item := range sources {
+		if deletedAt := softDeletedAt(item); deletedAt != nil && deletedAt.Before(expiredAt) {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
 // OnResourceBatchUpdated implements interface Subscriber in recorder/pubsub/subscriber.go
 func (s *SubscriberComponent[MAPT, MAT, MUPT, MUT, MDPT, MDT, MT, CT, KT]) OnResourceUpdated(md *message.Metadata, msg interface{}) {
 	updateMessage := msg.(MUPT)
@@ -0,0 +1,283 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package test 提供tagrecorder测试使用的metadb数据构造工具
+package test
+
+import (
+	"fmt"
+	"math/rand"
+
+	"gorm.io/gorm"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+// Models 返回builder写入的所有model，用于AutoMigrate及清理
+func Models() []interface{} {
+	return []interface{}{
+		&metadbmodel.Region{}, &metadbmodel.AZ{}, &metadbmodel.VPC{}, &metadbmodel.VM{}, &metadbmodel.LB{},
+		&metadbmodel.VInterface{}, &metadbmodel.LANIP{},
+	}
+}
+
+// Truncate 清空model对应的表，表名由db的命名策略决定
+func Truncate(db *gorm.DB, models ...interface{}) error {
+	for _, model := range models {
+		if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Graph 记录一次Create写入的资源
+type Graph struct {
+	RegionID      int
+	RegionLcuuid  string
+	AZIDs         []int
+	VPCIDs        []int
+	VMIDs         []int
+	LBIDs         []int
+	VInterfaceIDs []int
+	LANIPIDs      []int
+	LANIPs        []string
+	LBLANIPs      []string // 负载均衡器网卡上的IP
+}
+
+// RegionBuilder 构造一个区域及其下的可用区、VPC、云主机、负载均衡器、网卡及IP，
+// 云主机、负载均衡器数量为每个VPC下的数量，网卡数量为每个设备下的数量，IP数量为每个网卡下的数量
+type RegionBuilder struct {
+	domain       string
+	azNum        int
+	vpcNum       int
+	vmNum        int
+	lbNum        int
+	vifNum       int
+	lanIPNum     int
+	nextIDs      map[string]int
+	createdGraph *Graph
+}
+
+func NewRegion() *RegionBuilder {
+	return &RegionBuilder{
+		domain:   "domain",
+		vifNum:   1,
+		lanIPNum: 1,
+	}
+}
+
+// Random 使用seed随机生成各资源数量，seed相同时生成的数据相同
+func Random(seed int64) *RegionBuilder {
+	r := rand.New(rand.NewSource(seed))
+	return NewRegion().
+		WithAZ(1 + r.Intn(3)).
+		WithVPC(1 + r.Intn(3)).
+		WithVM(r.Intn(4)).
+		WithLB(r.Intn(4)).
+		WithVInterface(1 + r.Intn(2)).
+		WithLANIP(1 + r.Intn(3))
+}
+
+func (b *RegionBuilder) WithDomain(domain string) *RegionBuilder {
+	b.domain = domain
+	return b
+}
+
+func (b *RegionBuilder) WithAZ(num int) *RegionBuilder {
+	b.azNum = num
+	return b
+}
+
+func (b *RegionBuilder) WithVPC(num int) *RegionBuilder {
+	b.vpcNum = num
+	return b
+}
+
+func (b *RegionBuilder) WithVM(num int) *RegionBuilder {
+	b.vmNum = num
+	return b
+}
+
+func (b *RegionBuilder) WithLB(num int) *RegionBuilder {
+	b.lbNum = num
+	return b
+}
+
+func (b *RegionBuilder) WithVInterface(num int) *RegionBuilder {
+	b.vifNum = num
+	return b
+}
+
+func (b *RegionBuilder) WithLANIP(num int) *RegionBuilder {
+	b.lanIPNum = num
+	return b
+}
+
+// Create 在一个事务中写入数据，ID在表中已有最大ID之后顺序分配
+func (b *RegionBuilder) Create(db *gorm.DB) (*Graph, error) {
+	b.nextIDs = make(map[string]int)
+	b.createdGraph = &Graph{}
+	if err := db.Transaction(b.create); err != nil {
+		return nil, err
+	}
+	return b.createdGraph, nil
+}
+
+func (b *RegionBuilder) create(tx *gorm.DB) error {
+	g := b.createdGraph
+	regionID, err := b.allocateID(tx, &metadbmodel.Region{})
+	if err != nil {
+		return err
+	}
+	g.RegionID = regionID
+	g.RegionLcuuid = lcuuid("region", regionID)
+	if err := tx.Create(&metadbmodel.Region{
+		Base: metadbmodel.Base{ID: regionID, Lcuuid: g.RegionLcuuid}, Name: g.RegionLcuuid,
+	}).Error; err != nil {
+		return err
+	}
+
+	azLcuuids := make([]string, 0, b.azNum)
+	for i := 0; i < b.azNum; i++ {
+		id, err := b.allocateID(tx, &metadbmodel.AZ{})
+		if err != nil {
+			return err
+		}
+		azLcuuids = append(azLcuuids, lcuuid("az", id))
+		if err := tx.Create(&metadbmodel.AZ{
+			Base: metadbmodel.Base{ID: id, Lcuuid: lcuuid("az", id)}, Name: lcuuid("az", id), Region: g.RegionLcuuid, Domain: b.domain,
+		}).Error; err != nil {
+			return err
+		}
+		g.AZIDs = append(g.AZIDs, id)
+	}
+
+	for i := 0; i < b.vpcNum; i++ {
+		vpcID, err := b.allocateID(tx, &metadbmodel.VPC{})
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&metadbmodel.VPC{
+			Base: metadbmodel.Base{ID: vpcID, Lcuuid: lcuuid("vpc", vpcID)}, Name: lcuuid("vpc", vpcID), Region: g.RegionLcuuid, Domain: b.domain,
+		}).Error; err != nil {
+			return err
+		}
+		g.VPCIDs = append(g.VPCIDs, vpcID)
+
+		for j := 0; j < b.vmNum; j++ {
+			id, err := b.allocateID(tx, &metadbmodel.VM{})
+			if err != nil {
+				return err
+			}
+			vm := &metadbmodel.VM{
+				Base: metadbmodel.Base{ID: id, Lcuuid: lcuuid("vm", id)}, Name: lcuuid("vm", id), State: common.VM_STATE_RUNNING,
+				VPCID: vpcID, Region: g.RegionLcuuid, Domain: b.domain,
+			}
+			// 云主机依次分布在各可用区
+			if len(azLcuuids) > 0 {
+				vm.AZ = azLcuuids[len(g.VMIDs)%len(azLcuuids)]
+			}
+			if err := tx.Create(vm).Error; err != nil {
+				return err
+			}
+			g.VMIDs = append(g.VMIDs, id)
+			if err := b.createVInterfaces(tx, common.VIF_DEVICE_TYPE_VM, id, vpcID); err != nil {
+				return err
+			}
+		}
+
+		for j := 0; j < b.lbNum; j++ {
+			id, err := b.allocateID(tx, &metadbmodel.LB{})
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&metadbmodel.LB{
+				Base: metadbmodel.Base{ID: id, Lcuuid: lcuuid("lb", id)}, Name: lcuuid("lb", id),
+				VPCID: vpcID, Region: g.RegionLcuuid, Domain: b.domain,
+			}).Error; err != nil {
+				return err
+			}
+			g.LBIDs = append(g.LBIDs, id)
+			if err := b.createVInterfaces(tx, common.VIF_DEVICE_TYPE_LB, id, vpcID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *RegionBuilder) createVInterfaces(tx *gorm.DB, deviceType, deviceID, vpcID int) error {
+	g := b.createdGraph
+	for i := 0; i < b.vifNum; i++ {
+		vifID, err := b.allocateID(tx, &metadbmodel.VInterface{})
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&metadbmodel.VInterface{
+			Base: metadbmodel.Base{ID: vifID, Lcuuid: lcuuid("vif", vifID)}, Name: lcuuid("vif", vifID),
+			DeviceType: deviceType, DeviceID: deviceID, VPCID: vpcID, Region: g.RegionLcuuid, Domain: b.domain,
+		}).Error; err != nil {
+			return err
+		}
+		g.VInterfaceIDs = append(g.VInterfaceIDs, vifID)
+
+		for j := 0; j < b.lanIPNum; j++ {
+			id, err := b.allocateID(tx, &metadbmodel.LANIP{})
+			if err != nil {
+				return err
+			}
+			ip := ipFromID(id)
+			if err := tx.Create(&metadbmodel.LANIP{
+				Base: metadbmodel.Base{ID: id, Lcuuid: lcuuid("lan-ip", id)}, IP: ip, VInterfaceID: vifID, Domain: b.domain,
+			}).Error; err != nil {
+				return err
+			}
+			g.LANIPIDs = append(g.LANIPIDs, id)
+			g.LANIPs = append(g.LANIPs, ip)
+			if deviceType == common.VIF_DEVICE_TYPE_LB {
+				g.LBLANIPs = append(g.LBLANIPs, ip)
+			}
+		}
+	}
+	return nil
+}
+
+// allocateID 返回model下一个可用的ID，首次分配时查询表中已有的最大ID（包含软删除的数据），
+// 通过model而非表名查询，兼容sqlite与mysql的命名策略
+func (b *RegionBuilder) allocateID(tx *gorm.DB, model interface{}) (int, error) {
+	key := fmt.Sprintf("%T", model)
+	if id, ok := b.nextIDs[key]; ok {
+		b.nextIDs[key] = id + 1
+		return id, nil
+	}
+	var maxID int
+	if err := tx.Model(model).Unscoped().Select("COALESCE(MAX(id), 0)").Scan(&maxID).Error; err != nil {
+		return 0, err
+	}
+	b.nextIDs[key] = maxID + 2
+	return maxID + 1, nil
+}
+
+func lcuuid(prefix string, id int) string {
+	return fmt.Sprintf("%s-%d", prefix, id)
+}
+
+// ipFromID 根据LANIP ID生成10.0.0.0/8内唯一的IP
+func ipFromID(id int) string {
+	return fmt.Sprintf("10.%d.%d.%d", id>>16&0xff, id>>8&0xff, id&0xff)
+}
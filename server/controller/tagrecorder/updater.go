@@ -44,6 +44,7 @@ func GetUpdaterManager() *UpdaterManager {
 func (u *UpdaterManager) Init(ctx context.Context, cfg config.ControllerConfig) {
 	u.cfg = cfg
 	u.tCtx, u.tCancel = context.WithCancel(ctx)
+	registerBatchWriterCounter()
 }
 
 func (c *UpdaterManager) Start(sCtx context.Context) {
@@ -74,13 +75,44 @@ func (c *UpdaterManager) run() {
 	// 调用API获取资源对应的icon_id
 	c.domainLcuuidToIconID, c.resourceTypeToIconID, _ = UpdateIconInfo(c.cfg)
 	c.refresh()
+	c.purgeSoftDeleted()
+}
+
+// purgeSoftDeleted 清理各组织中源资源软删除超过保留时长的ch数据
+func (c *UpdaterManager) purgeSoftDeleted() {
+	expiredAt, ok := softDeletedExpiredAt(c.cfg)
+	if !ok {
+		return
+	}
+	orgIDs, err := metadb.GetORGIDs()
+	if err != nil {
+		log.Errorf("get org info fail : %s", err)
+		return
+	}
+	for _, orgID := range orgIDs {
+		db, err := metadb.GetDB(orgID)
+		if err != nil {
+			log.Error("get org dbinfo fail", logger.NewORGPrefix(orgID))
+			continue
+		}
+		if err := purgeSoftDeleted(db, expiredAt); err != nil {
+			log.Errorf("failed to purge soft deleted resources, rollback: %s", err, db.LogPrefixORGID)
+		}
+	}
 }
 
 func (c *UpdaterManager) refresh() {
 	log.Info("tagrecorder updaters refresh")
 	// 生成各资源更新器，刷新ch数据
+	for _, updater := range NewUpdaters(c.cfg, c.domainLcuuidToIconID, c.resourceTypeToIconID) {
+		updater.Refresh()
+	}
+}
+
+// NewUpdaters 生成各资源更新器
+func NewUpdaters(cfg config.ControllerConfig, domainLcuuidToIconID map[string]int, resourceTypeToIconID map[IconKey]int) []Updater {
 	updaters := []Updater{
-		NewChRegion(c.domainLcuuidToIconID, c.resourceTypeToIconID),
+		NewChRegion(domainLcuuidToIconID, resourceTypeToIconID),
 		NewChIPRelation(),
 		NewChVTapPort(),
 		NewChStringEnum(),
@@ -92,26 +124,27 @@ func (c *UpdaterManager) refresh() {
 		NewChPrometheusLabelName(),
 		NewChPrometheusMetricNames(),
 		NewChPrometheusMetricAPPLabelLayout(),
-		NewChTapType(c.resourceTypeToIconID),
-		NewChVTap(c.resourceTypeToIconID),
-		NewChLbListener(c.resourceTypeToIconID),
+		NewChTapType(resourceTypeToIconID),
+		NewChVTap(resourceTypeToIconID),
+		NewChLbListener(resourceTypeToIconID),
+		NewChPodIngressRule(),
 
 		NewChPolicy(),
 		NewChNpbTunnel(),
 		NewChAlarmPolicy(),
 		NewChOSAppTag(),
 		NewChOSAppTags(),
-		NewChCustomBizService(c.resourceTypeToIconID),
+		NewChCustomBizService(resourceTypeToIconID),
 		NewChCustomBizServiceFilter(),
 	}
 
-	if c.cfg.FPermit.Enabled {
+	if cfg.FPermit.Enabled {
 		updaters = append(updaters, NewChUser())
 	}
 	for _, updater := range updaters {
-		updater.SetConfig(c.cfg)
-		updater.Refresh()
+		updater.SetConfig(cfg)
 	}
+	return updaters
 }
 
 type Updater interface {
@@ -121,6 +154,8 @@ type Updater interface {
 	// 遍历新的ch数据，若key不在旧的ch数据中，则新增；否则检查是否有更新，若有更新，则更新
 	// 遍历旧的ch数据，若key不在新的ch数据中，则删除
 	Refresh()
+	// 对比组织的源数据与ch表，repair为true时按差异修复ch表
+	Check(db *metadb.DB, repair bool) (*CheckResult, error)
 	SetConfig(cfg config.ControllerConfig)
 }
 
@@ -173,50 +208,78 @@ func (b *UpdaterComponent[MT, KT]) Refresh() {
 			continue
 		}
 		GetTeamInfo(db)
-		newKeyToDBItem, newOK := b.updaterDG.generateNewData(db)
-		oldKeyToDBItem, oldOK := b.generateOldData(db)
-		keysToAdd := []KT{}
-		itemsToAdd := []MT{}
-		keysToDelete := []KT{}
-		itemsToDelete := []MT{}
-		if newOK && oldOK {
-			for key, newDBItem := range newKeyToDBItem {
-				oldDBItem, exists := oldKeyToDBItem[key]
-				if !exists {
-					keysToAdd = append(keysToAdd, key)
-					itemsToAdd = append(itemsToAdd, newDBItem)
-				} else {
-					updateInfo, ok := b.updaterDG.generateUpdateInfo(oldDBItem, newDBItem)
-					if ok {
-						err := b.dbOperator.update(oldDBItem, updateInfo, key, db)
-						if err != nil {
-							log.Errorf("failed to update %s: %s", b.resourceTypeName, err, db.LogPrefixORGID)
-						}
-					}
-				}
-			}
-			if len(itemsToAdd) > 0 {
-				err := b.dbOperator.batchPage(keysToAdd, itemsToAdd, b.dbOperator.add, db) // 1是个占位符
-				if err != nil {
-					log.Errorf("failed to add %s: %s", b.resourceTypeName, err, db.LogPrefixORGID)
-				}
-			}
+		if err := b.refreshORG(db); err != nil {
+			log.Errorf("failed to refresh %s, rollback: %s", b.resourceTypeName, err, db.LogPrefixORGID)
+		}
+	}
+}
+
+// refreshORG 在一个事务中写入组织的新增、更新和删除数据，任一写入失败时回滚
+func (b *UpdaterComponent[MT, KT]) refreshORG(db *metadb.DB) error {
+	diff, ok := b.generateDiff(db)
+	if !ok {
+		return nil
+	}
+	return b.applyDiff(db, diff)
+}
+
+// updaterDiff 根据源数据构建的ch数据与ch表中数据的差异
+type updaterDiff[MT MySQLChModel, KT ChModelKey] struct {
+	keysToAdd     []KT
+	itemsToAdd    []MT
+	keysToUpdate  []KT
+	itemsToUpdate []MT // ch表中的旧数据
+	updateInfos   []map[string]interface{}
+	keysToDelete  []KT
+	itemsToDelete []MT
+}
+
+func (b *UpdaterComponent[MT, KT]) generateDiff(db *metadb.DB) (*updaterDiff[MT, KT], bool) {
+	newKeyToDBItem, newOK := b.updaterDG.generateNewData(db)
+	oldKeyToDBItem, oldOK := b.generateOldData(db)
+	if !newOK || !oldOK {
+		return nil, false
+	}
+	diff := &updaterDiff[MT, KT]{}
+	for key, newDBItem := range newKeyToDBItem {
+		oldDBItem, exists := oldKeyToDBItem[key]
+		if !exists {
+			diff.keysToAdd = append(diff.keysToAdd, key)
+			diff.itemsToAdd = append(diff.itemsToAdd, newDBItem)
+		} else if updateInfo, ok := b.updaterDG.generateUpdateInfo(oldDBItem, newDBItem); ok {
+			diff.keysToUpdate = append(diff.keysToUpdate, key)
+			diff.itemsToUpdate = append(diff.itemsToUpdate, oldDBItem)
+			diff.updateInfos = append(diff.updateInfos, updateInfo)
+		}
+	}
+	for key, oldDBItem := range oldKeyToDBItem {
+		if _, exists := newKeyToDBItem[key]; !exists {
+			diff.keysToDelete = append(diff.keysToDelete, key)
+			diff.itemsToDelete = append(diff.itemsToDelete, oldDBItem)
+		}
+	}
+	return diff, true
+}
 
-			for key, oldDBItem := range oldKeyToDBItem {
-				_, exists := newKeyToDBItem[key]
-				if !exists {
-					keysToDelete = append(keysToDelete, key)
-					itemsToDelete = append(itemsToDelete, oldDBItem)
-				}
+func (b *UpdaterComponent[MT, KT]) applyDiff(db *metadb.DB, diff *updaterDiff[MT, KT]) error {
+	return transaction(db, func(tx *metadb.DB) error {
+		for i, key := range diff.keysToUpdate {
+			if err := b.dbOperator.update(diff.itemsToUpdate[i], diff.updateInfos[i], key, tx); err != nil {
+				return err
 			}
-			if len(itemsToDelete) > 0 {
-				err := b.dbOperator.batchPage(keysToDelete, itemsToDelete, b.dbOperator.delete, db) // 1是个占位符
-				if err != nil {
-					log.Errorf("failed to delete %s: %s", b.resourceTypeName, err, db.LogPrefixORGID)
-				}
+		}
+		if len(diff.itemsToAdd) > 0 {
+			if err := b.dbOperator.batchPage(diff.keysToAdd, diff.itemsToAdd, b.dbOperator.add, tx); err != nil {
+				return err
 			}
 		}
-	}
+		if len(diff.itemsToDelete) > 0 {
+			if err := b.dbOperator.batchPage(diff.keysToDelete, diff.itemsToDelete, b.dbOperator.delete, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (b *UpdaterComponent[MT, KT]) generateOldData(db *metadb.DB) (map[KT]MT, bool) {
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tagrecorder
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/deepflowio/deepflow/server/controller/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbconfig "github.com/deepflowio/deepflow/server/controller/db/metadb/config"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/libs/logger"
+)
+
+const (
+	TEST_UPDATER_DB_FILE = "./updater_test.db"
+)
+
+type UpdaterTestSuite struct {
+	suite.Suite
+	db *metadb.DB
+}
+
+func TestUpdaterSuite(t *testing.T) {
+	if _, err := os.Stat(TEST_UPDATER_DB_FILE); err == nil {
+		os.Remove(TEST_UPDATER_DB_FILE)
+	}
+	suite.Run(t, new(UpdaterTestSuite))
+}
+
+func getModels() []interface{} {
+	return []interface{}{
+		&metadbmodel.LBListener{}, &metadbmodel.LBTargetServer{}, &metadbmodel.ChLBListener{},
+		&metadbmodel.PodIngressRule{}, &metadbmodel.ChPodIngressRule{},
+		&metadbmodel.ChAZ{}, &metadbmodel.ChChost{}, &metadbmodel.ChVPC{},
+		&metadbmodel.Region{}, &metadbmodel.AZ{}, &metadbmodel.VPC{}, &metadbmodel.ChRegion{},
+	}
+}
+
+func (s *UpdaterTestSuite) SetupSuite() {
+	gormDB, err := gorm.Open(
+		sqlite.Open(TEST_UPDATER_DB_FILE),
+		&gorm.Config{NamingStrategy: schema.NamingStrategy{SingularTable: true}},
+	)
+	if err != nil {
+		fmt.Printf("create sqlite database failed: %s\n", err.Error())
+		os.Exit(1)
+	}
+	s.db = &metadb.DB{
+		DB:             gormDB,
+		ORGID:          1,
+		Name:           "test_db",
+		LogPrefixORGID: logger.NewORGPrefix(1),
+		LogPrefixName:  metadb.NewDBNameLogPrefix("test_db"),
+		Config:         metadbconfig.Config{Database: "test_db", Type: "SQLite"},
+	}
+	for _, val := range getModels() {
+		s.db.AutoMigrate(val)
+	}
+	DomainToTeamID = map[string]int{"domain": 2}
+}
+
+func (s *UpdaterTestSuite) TearDownSuite() {
+	sqlDB, _ := s.db.DB.DB()
+	sqlDB.Close()
+	os.Remove(TEST_UPDATER_DB_FILE)
+}
+
+func (s *UpdaterTestSuite) SetupTest() {
+	for _, table := range []string{
+		"lb_listener", "lb_target_server", "ch_lb_listener", "pod_ingress_rule", "ch_pod_ingress_rule",
+		"ch_az", "ch_chost", "ch_l3_epc", "region", "az", "epc", "ch_region",
+	} {
+		_ = s.db.Exec("DELETE FROM " + table).Error
+	}
+}
+
+func (s *UpdaterTestSuite) refresh(updater interface{ refreshORG(*metadb.DB) error }) {
+	s.Require().NoError(updater.refreshORG(s.db))
+}
+
+func (s *UpdaterTestSuite) TestChLBListener() {
+	for _, listener := range []*metadbmodel.LBListener{
+		{Base: metadbmodel.Base{ID: 1, Lcuuid: "listener-1"}, LBID: 10, Name: "listener-1", Port: 80, Protocol: "TCP", Domain: "domain"},
+		{Base: metadbmodel.Base{ID: 2, Lcuuid: "listener-2"}, LBID: 10, Name: "listener-2", Port: 443, Protocol: "TCP", Domain: "domain"},
+	} {
+		s.Require().NoError(s.db.Create(listener).Error)
+	}
+	for _, server := range []*metadbmodel.LBTargetServer{
+		{Base: metadbmodel.Base{ID: 1, Lcuuid: "server-1"}, LBID: 10, LBListenerID: 1, Domain: "domain"},
+		{Base: metadbmodel.Base{ID: 2, Lcuuid: "server-2"}, LBID: 10, LBListenerID: 2, Domain: "domain"},
+	} {
+		s.Require().NoError(s.db.Create(server).Error)
+	}
+	updater := NewChLbListener(nil)
+	updater.SetConfig(config.ControllerConfig{})
+
+	// 新增
+	s.refresh(&updater.UpdaterComponent)
+	var items []metadbmodel.ChLBListener
+	s.Require().NoError(s.db.Order("id").Find(&items).Error)
+	s.Require().Len(items, 2)
+	s.Equal("listener-1", items[0].Name)
+	s.Equal(10, items[0].LBID)
+	s.Equal(80, items[0].Port)
+	s.Equal("TCP", items[0].Protocol)
+	s.Equal(2, items[0].TeamID)
+
+	// 重命名及修改端口原地更新
+	s.Require().NoError(s.db.Model(&metadbmodel.LBListener{}).Where("id = ?", 1).Updates(map[string]interface{}{"name": "listener-1-renamed", "port": 8080}).Error)
+	s.refresh(&updater.UpdaterComponent)
+	var item metadbmodel.ChLBListener
+	s.Require().NoError(s.db.First(&item, 1).Error)
+	s.Equal("listener-1-renamed", item.Name)
+	s.Equal(8080, item.Port)
+
+	// 删除
+	s.Require().NoError(s.db.Unscoped().Delete(&metadbmodel.LBListener{}, 2).Error)
+	s.refresh(&updater.UpdaterComponent)
+	var ids []int
+	s.Require().NoError(s.db.Model(&metadbmodel.ChLBListener{}).Order("id").Pluck("id", &ids).Error)
+	s.Equal([]int{1}, ids)
+}
+
+func (s *UpdaterTestSuite) TestChPodIngressRule() {
+	for _, rule := range []*metadbmodel.PodIngressRule{
+		{Base: metadbmodel.Base{ID: 1, Lcuuid: "rule-1"}, Name: "rule-1", PodIngressID: 20, Domain: "domain"},
+		{Base: metadbmodel.Base{ID: 2, Lcuuid: "rule-2"}, Name: "rule-2", PodIngressID: 20, Domain: "domain"},
+	} {
+		s.Require().NoError(s.db.Create(rule).Error)
+	}
+	updater := NewChPodIngressRule()
+	updater.SetConfig(config.ControllerConfig{})
+
+	// 新增
+	s.refresh(&updater.UpdaterComponent)
+	var items []metadbmodel.ChPodIngressRule
+	s.Require().NoError(s.db.Order("id").Find(&items).Error)
+	s.Require().Len(items, 2)
+	s.Equal("rule-1", items[0].Name)
+	s.Equal(20, items[0].PodIngressID)
+	s.Equal(2, items[0].TeamID)
+
+	// 重命名原地更新
+	s.Require().NoError(s.db.Model(&metadbmodel.PodIngressRule{}).Where("id = ?", 1).Update("name", "rule-1-renamed").Error)
+	s.refresh(&updater.UpdaterComponent)
+	var item metadbmodel.ChPodIngressRule
+	s.Require().NoError(s.db.First(&item, 1).Error)
+	s.Equal("rule-1-renamed", item.Name)
+
+	// 删除
+	s.Require().NoError(s.db.Delete(&metadbmodel.PodIngressRule{}, 2).Error)
+	s.refresh(&updater.UpdaterComponent)
+	var ids []int
+	s.Require().NoError(s.db.Model(&metadbmodel.ChPodIngressRule{}).Order("id").Pluck("id", &ids).Error)
+	s.Equal([]int{1}, ids)
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultControllerResolveInterval = 60 // s
+	DefaultResolveTimeout            = 5 * time.Second
+	DefaultStatsdServer              = DefaultLocalIP
+)
+
+var hostnameRegexp = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*\.?$`)
+
+// replaced in tests
+var (
+	lookupHost = func(host string) ([]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultResolveTimeout)
+		defer cancel()
+		return net.DefaultResolver.LookupHost(ctx, host)
+	}
+	interfaceAddrs = net.InterfaceAddrs
+)
+
+// resolvedAddrs holds the resolved addresses of controller-ips, which are refreshed periodically
+type resolvedAddrs struct {
+	sync.RWMutex
+	addrs []string
+}
+
+func isHostname(host string) bool {
+	return len(host) <= 253 && hostnameRegexp.MatchString(host)
+}
+
+// resolveAddress accepts an IP literal (IPv6 may carry a zone) or a hostname
+func resolveAddress(entry string) ([]string, error) {
+	if _, err := netip.ParseAddr(entry); err == nil {
+		return []string{entry}, nil
+	}
+	if !isHostname(entry) {
+		return nil, errors.New("not an IP address or hostname")
+	}
+	addrs, err := lookupHost(entry)
+	if err != nil {
+		return nil, fmt.Errorf("resolve failed: %s", err)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("resolve failed: no address found")
+	}
+	return addrs, nil
+}
+
+func (c *Config) resolveControllerIPs() error {
+	var errs []error
+	var addrs []string
+	for i, entry := range c.ControllerIPs {
+		resolved, err := resolveAddress(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("controller-ips[%d] '%s': %s", i, entry, err))
+			continue
+		}
+		addrs = append(addrs, resolved...)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if c.resolvedControllerIPs == nil {
+		c.resolvedControllerIPs = &resolvedAddrs{}
+	}
+	c.resolvedControllerIPs.Lock()
+	c.resolvedControllerIPs.addrs = addrs
+	c.resolvedControllerIPs.Unlock()
+	return nil
+}
+
+func (c *Config) hasControllerHostname() bool {
+	for _, entry := range c.ControllerIPs {
+		if _, err := netip.ParseAddr(entry); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolvedControllerIPs returns the addresses of controller-ips, hostnames are replaced by their resolved addresses
+func (c *Config) ResolvedControllerIPs() []string {
+	if c.resolvedControllerIPs == nil {
+		return c.ControllerIPs
+	}
+	c.resolvedControllerIPs.RLock()
+	defer c.resolvedControllerIPs.RUnlock()
+	if c.resolvedControllerIPs.addrs == nil {
+		return c.ControllerIPs
+	}
+	return c.resolvedControllerIPs.addrs
+}
+
+// ControllerNetIPs converts ResolvedControllerIPs to net.IP, the zone of IPv6 is dropped and IPv4 uses 4 bytes
+func (c *Config) ControllerNetIPs() []net.IP {
+	addrs := c.ResolvedControllerIPs()
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			continue
+		}
+		ips = append(ips, net.IP(ip.WithZone("").Unmap().AsSlice()))
+	}
+	return ips
+}
+
+// StartControllerResolver refreshes the resolved addresses of the hostnames in controller-ips periodically
+func (c *Config) StartControllerResolver() {
+	if c.ControllerResolveInterval <= 0 || !c.hasControllerHostname() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(c.ControllerResolveInterval) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := c.resolveControllerIPs(); err != nil {
+				log.Warningf("refresh controller-ips failed, keep the last resolved addresses %v: %s", c.ResolvedControllerIPs(), err)
+			}
+		}
+	}()
+}
+
+// selectLocalIP returns the first local interface address in the CIDR
+func selectLocalIP(cidr string) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("local-ip-cidr '%s': invalid CIDR: %s", cidr, err)
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("local-ip-cidr '%s': get interface addresses failed: %s", cidr, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if ok && prefix.Contains(ip.Unmap()) {
+			return ip.Unmap().String(), nil
+		}
+	}
+	return "", fmt.Errorf("local-ip-cidr '%s': no local interface address in the subnet", cidr)
+}
+
+// parseStatsdServer accepts 'host' or 'host:port', the port in statsd-server overrides statsd-port,
+// when neither is set the listen-port of the ingester is used
+func (c *Config) parseStatsdServer() (string, error) {
+	host, port := c.StatsdServer, int(c.StatsdPort)
+	if host == "" {
+		host = DefaultStatsdServer
+	} else if h, p, err := net.SplitHostPort(host); err == nil {
+		portInt, err := strconv.Atoi(p)
+		if err != nil || portInt <= 0 || portInt > 65535 {
+			return "", fmt.Errorf("statsd-server '%s': invalid port '%s'", c.StatsdServer, p)
+		}
+		host, port = h, portInt
+	} else {
+		host = strings.Trim(host, "[]")
+	}
+	if _, err := netip.ParseAddr(host); err != nil && !isHostname(host) {
+		return "", fmt.Errorf("statsd-server '%s': '%s' is not an IP address or hostname", c.StatsdServer, host)
+	}
+	if port == 0 {
+		port = int(c.ListenPort)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// StatsdAddress returns host:port of the statsd server, available after Validate
+func (c *Config) StatsdAddress() string {
+	return c.statsdAddress
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stubResolver(t *testing.T, hosts map[string][]string) {
+	origin := lookupHost
+	lookupHost = func(host string) ([]string, error) {
+		if addrs, ok := hosts[host]; ok {
+			return addrs, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	t.Cleanup(func() { lookupHost = origin })
+}
+
+func stubInterfaceAddrs(t *testing.T, cidrs ...string) {
+	origin := interfaceAddrs
+	interfaceAddrs = func() ([]net.Addr, error) {
+		addrs := []net.Addr{}
+		for _, cidr := range cidrs {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			ipNet.IP = ip
+			addrs = append(addrs, ipNet)
+		}
+		return addrs, nil
+	}
+	t.Cleanup(func() { interfaceAddrs = origin })
+}
+
+func TestResolveControllerIPs(t *testing.T) {
+	stubResolver(t, map[string][]string{
+		"deepflow-server.deepflow": {"10.0.0.2", "10.0.0.3"},
+	})
+	c := &Config{ControllerIPs: []string{"10.0.0.1", "deepflow-server.deepflow", "fe80::1%eth0"}}
+	if err := c.resolveControllerIPs(); err != nil {
+		t.Fatalf("resolve failed: %s", err)
+	}
+	expect := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "fe80::1%eth0"}
+	if addrs := c.ResolvedControllerIPs(); !reflect.DeepEqual(addrs, expect) {
+		t.Errorf("Expected %v found %v", expect, addrs)
+	}
+	ips := c.ControllerNetIPs()
+	if len(ips) != 4 || len(ips[0]) != net.IPv4len || !ips[3].Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("Expected 4 controller ips found %v", ips)
+	}
+	if !c.hasControllerHostname() {
+		t.Error("Expected hostname in controller-ips")
+	}
+}
+
+func TestResolveControllerIPsErrors(t *testing.T) {
+	stubResolver(t, nil)
+	c := &Config{ControllerIPs: []string{"10.0.0.1", "unknown.host", "bad_ip"}}
+	err := c.resolveControllerIPs()
+	if err == nil {
+		t.Fatal("Expected resolve errors")
+	}
+	for _, expect := range []string{
+		"controller-ips[1] 'unknown.host': resolve failed: no such host",
+		"controller-ips[2] 'bad_ip': not an IP address or hostname",
+	} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("Expected error contains '%s' found %v", expect, err)
+		}
+	}
+	// keep the original entries when never resolved
+	if addrs := c.ResolvedControllerIPs(); !reflect.DeepEqual(addrs, c.ControllerIPs) {
+		t.Errorf("Expected %v found %v", c.ControllerIPs, addrs)
+	}
+}
+
+func TestSelectLocalIP(t *testing.T) {
+	stubInterfaceAddrs(t, "127.0.0.1/8", "192.168.1.10/24", "10.1.2.3/16", "fd00::10/64")
+	for _, c := range []struct {
+		cidr   string
+		expect string
+		err    string
+	}{
+		{cidr: "10.1.0.0/16", expect: "10.1.2.3"},
+		{cidr: "192.168.0.0/16", expect: "192.168.1.10"},
+		{cidr: "fd00::/64", expect: "fd00::10"},
+		{cidr: "172.16.0.0/12", err: "local-ip-cidr '172.16.0.0/12': no local interface address in the subnet"},
+		{cidr: "10.1.0.0", err: "local-ip-cidr '10.1.0.0': invalid CIDR"},
+	} {
+		ip, err := selectLocalIP(c.cidr)
+		if c.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+				t.Errorf("%s: Expected error '%s' found %v", c.cidr, c.err, err)
+			}
+			continue
+		}
+		if err != nil || ip != c.expect {
+			t.Errorf("%s: Expected %s found %s %v", c.cidr, c.expect, ip, err)
+		}
+	}
+}
+
+func TestParseStatsdServer(t *testing.T) {
+	for _, c := range []struct {
+		server string
+		port   uint16
+		expect string
+		err    string
+	}{
+		{expect: "127.0.0.1:20033"},
+		{port: 30033, expect: "127.0.0.1:30033"},
+		{server: "statsd.deepflow", expect: "statsd.deepflow:20033"},
+		{server: "10.0.0.1:8125", port: 30033, expect: "10.0.0.1:8125"},
+		{server: "[fd00::1]:8125", expect: "[fd00::1]:8125"},
+		{server: "fd00::1", port: 8125, expect: "[fd00::1]:8125"},
+		{server: "10.0.0.1:0", err: "statsd-server '10.0.0.1:0': invalid port '0'"},
+		{server: "bad_host:8125", err: "statsd-server 'bad_host:8125': 'bad_host' is not an IP address or hostname"},
+	} {
+		config := &Config{ListenPort: DefaultListenPort, StatsdServer: c.server, StatsdPort: c.port}
+		address, err := config.parseStatsdServer()
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%s: Expected error '%s' found %v", c.server, c.err, err)
+			}
+			continue
+		}
+		if err != nil || address != c.expect {
+			t.Errorf("%s: Expected %s found %s %v", c.server, c.expect, address, err)
+		}
+	}
+}
+
+func TestValidateAddresses(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	t.Setenv(EnvK8sNodeIP, "")
+	stubResolver(t, map[string][]string{"deepflow-server": {"10.0.0.2"}})
+	stubInterfaceAddrs(t, "192.168.1.10/24")
+
+	config, err := loadBaseConfig([]byte(`
+ingester:
+  storage-disabled: true
+  controller-ips: [deepflow-server]
+  local-ip-cidr: 192.168.1.0/24
+  statsd-server: 10.0.0.5
+  statsd-port: 8125
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Base.Validate(); err != nil {
+		t.Fatalf("validate config failed: %s", err)
+	}
+	if ips := config.Base.ResolvedControllerIPs(); !reflect.DeepEqual(ips, []string{"10.0.0.2"}) {
+		t.Errorf("Expected resolved controller ips [10.0.0.2] found %v", ips)
+	}
+	if config.Base.NodeIP != "192.168.1.10" {
+		t.Errorf("Expected node ip 192.168.1.10 found %s", config.Base.NodeIP)
+	}
+	if config.Base.StatsdAddress() != "10.0.0.5:8125" {
+		t.Errorf("Expected statsd address 10.0.0.5:8125 found %s", config.Base.StatsdAddress())
+	}
+	if config.Base.ControllerResolveInterval != DefaultControllerResolveInterval {
+		t.Errorf("Expected resolve interval %d found %d", DefaultControllerResolveInterval, config.Base.ControllerResolveInterval)
+	}
+}
@@ -69,6 +69,9 @@ const (
 	//   any endpoints beyond this limit will be ignored
 	MaxClickHouseEndpointsPerServer = 128
 	DefaultDatasourceListenPort     = 20106
+	DefaultLogMaxBackups            = 5
+	LogFormatText                   = "text"
+	LogFormatJSON                   = "json"
 )
 
 type DatabaseTable struct {
@@ -165,31 +168,40 @@ func (c *CKDB) updateActualAddrs(endpoints []Endpoint) {
 }
 
 type Config struct {
-	IsRunningModeStandalone  bool
-	StorageDisabled          bool            `yaml:"storage-disabled"`
-	ListenPort               uint16          `yaml:"listen-port"`
-	CKDB                     CKDB            `yaml:"ckdb"`
-	ControllerIPs            []string        `yaml:"controller-ips,flow"`
-	ControllerPort           uint16          `yaml:"controller-port"`
-	CKDBAuth                 Auth            `yaml:"ckdb-auth"`
-	IngesterEnabled          bool            `yaml:"ingester-enabled"`
-	UDPReadBuffer            int             `yaml:"udp-read-buffer"`
-	TCPReadBuffer            int             `yaml:"tcp-read-buffer"`
-	TCPReaderBuffer          int             `yaml:"tcp-reader-buffer"`
-	CKDiskMonitor            CKDiskMonitor   `yaml:"ck-disk-monitor"`
-	ColdStorage              CKDBColdStorage `yaml:"ckdb-cold-storage"`
-	ckdbColdStorages         map[string]*ckdb.ColdStorage
-	NodeIP                   string `yaml:"node-ip"`
-	GrpcBufferSize           int    `yaml:"grpc-buffer-size"`
-	ServiceLabelerLruCap     int    `yaml:"service-labeler-lru-cap"`
-	StatsInterval            int    `yaml:"stats-interval"`
-	FlowTagCacheFlushTimeout uint32 `yaml:"flow-tag-cache-flush-timeout"`
-	FlowTagCacheMaxSize      uint32 `yaml:"flow-tag-cache-max-size"`
-	DatasourceListenPort     uint16 `yaml:"datasource-listen-port"`
-	LogFile                  string
-	LogLevel                 string
-	MyNodeName               string
-	TraceIdWithIndex         TraceIdWithIndex
+	IsRunningModeStandalone   bool
+	StorageDisabled           bool     `yaml:"storage-disabled"`
+	ListenPort                uint16   `yaml:"listen-port"`
+	CKDB                      CKDB     `yaml:"ckdb"`
+	ControllerIPs             []string `yaml:"controller-ips,flow"`
+	ControllerPort            uint16   `yaml:"controller-port"`
+	ControllerResolveInterval int      `yaml:"controller-resolve-interval"`
+	resolvedControllerIPs     *resolvedAddrs
+	LocalIPCIDR               string `yaml:"local-ip-cidr"`
+	StatsdServer              string `yaml:"statsd-server"`
+	StatsdPort                uint16 `yaml:"statsd-port"`
+	statsdAddress             string
+	CKDBAuth                  Auth            `yaml:"ckdb-auth"`
+	IngesterEnabled           bool            `yaml:"ingester-enabled"`
+	UDPReadBuffer             int             `yaml:"udp-read-buffer"`
+	TCPReadBuffer             int             `yaml:"tcp-read-buffer"`
+	TCPReaderBuffer           int             `yaml:"tcp-reader-buffer"`
+	CKDiskMonitor             CKDiskMonitor   `yaml:"ck-disk-monitor"`
+	ColdStorage               CKDBColdStorage `yaml:"ckdb-cold-storage"`
+	ckdbColdStorages          map[string]*ckdb.ColdStorage
+	NodeIP                    string `yaml:"node-ip"`
+	GrpcBufferSize            int    `yaml:"grpc-buffer-size"`
+	ServiceLabelerLruCap      int    `yaml:"service-labeler-lru-cap"`
+	StatsInterval             int    `yaml:"stats-interval"`
+	FlowTagCacheFlushTimeout  uint32 `yaml:"flow-tag-cache-flush-timeout"`
+	FlowTagCacheMaxSize       uint32 `yaml:"flow-tag-cache-max-size"`
+	DatasourceListenPort      uint16 `yaml:"datasource-listen-port"`
+	LogFile                   string
+	LogLevel                  string
+	LogConfig                 LogConfig
+	MyNodeName                string
+	TraceIdWithIndex          TraceIdWithIndex
+	configPath                string
+	configBytes               []byte
 }
 
 type Location struct {
@@ -206,9 +218,20 @@ type TraceIdWithIndex struct {
 	TypeIsIncrementalId   bool
 }
 
+// LogConfig max-size-mb为0时日志文件按天切分，否则按大小切分
+type LogConfig struct {
+	MaxSizeMB  int    `yaml:"max-size-mb"`
+	MaxBackups int    `yaml:"max-backups"`
+	MaxAgeDays int    `yaml:"max-age-days"`
+	Compress   bool   `yaml:"compress"`
+	Format     string `yaml:"format"` // text or json
+}
+
 type BaseConfig struct {
+	ConfigVersion    int              `yaml:"config-version"`
 	LogFile          string           `yaml:"log-file"`
-	LogLevel         string           `yaml:"log-level"`
+	LogLevel         string           `yaml:"log-level" reload:"hot"`
+	LogConfig        LogConfig        `yaml:"log-config"`
 	TraceIdWithIndex TraceIdWithIndex `yaml:"trace-id-with-index"`
 	Base             Config           `yaml:"ingester"`
 }
@@ -218,7 +241,23 @@ func sleepAndExit() {
 	os.Exit(1)
 }
 
+// Validate checks the config and fills the defaults, all problems are collected and returned together
 func (c *Config) Validate() error {
+	if err := c.ValidateFields(); err != nil {
+		return err
+	}
+	// in standalone mode, only supports one ClickHouse endpoint. no watcher required
+	if c.StorageDisabled || c.IsRunningModeStandalone {
+		return nil
+	}
+	c.watchClickhouse()
+	return nil
+}
+
+// ValidateFields checks the fields and fills the default values, it does not connect to kubernetes or clickhouse,
+// so it is also used when reloading the config
+func (c *Config) ValidateFields() error {
+	var errs []error
 	runningMode, _ := os.LookupEnv(EnvRunningMode)
 	// in standalone mode, only supports single node and does not support horizontal expansion
 	c.IsRunningModeStandalone = runningMode == RunningModeStandalone
@@ -229,33 +268,56 @@ func (c *Config) Validate() error {
 		}
 
 		if c.TraceIdWithIndex.Type != IndexTypeIncremetalIdLocation && c.TraceIdWithIndex.Type != IndexTypeHash {
-			log.Errorf("invalid 'type'(%s) of 'trace-id-with-index', must be '%s' or '%s'", c.TraceIdWithIndex.Type, IndexTypeIncremetalIdLocation, IndexTypeHash)
-			sleepAndExit()
+			errs = append(errs, fmt.Errorf("invalid 'type'(%s) of 'trace-id-with-index', must be '%s' or '%s'", c.TraceIdWithIndex.Type, IndexTypeIncremetalIdLocation, IndexTypeHash))
 		}
 		c.TraceIdWithIndex.TypeIsIncrementalId = false
 		if c.TraceIdWithIndex.Type == IndexTypeIncremetalIdLocation {
 			c.TraceIdWithIndex.TypeIsIncrementalId = true
 			location := c.TraceIdWithIndex.IncrementalIdLocation
 			if location.Format != FormatHex && location.Format != FormatDecimal {
-				log.Errorf("invalid 'format'(%s) of 'trace-id-with-index:incremetal-id-location', must be '%s' or '%s'", location.Format, FormatHex, FormatDecimal)
-				sleepAndExit()
+				errs = append(errs, fmt.Errorf("invalid 'format'(%s) of 'trace-id-with-index:incremetal-id-location', must be '%s' or '%s'", location.Format, FormatHex, FormatDecimal))
 			}
 			if location.Length == 0 || (location.Length > 20 && location.Format == FormatDecimal) || (location.Length > 16 && location.Format == FormatHex) {
-				log.Errorf("invalid 'length'(%d) of 'trace-id-with-index:incremetal-id-location' out of range. when 'format' is '%s' range is (0, 20], 'format' is '%s' range is (0, 16]", location.Length, FormatDecimal, FormatHex)
-
-				sleepAndExit()
+				errs = append(errs, fmt.Errorf("invalid 'length'(%d) of 'trace-id-with-index:incremetal-id-location' out of range. when 'format' is '%s' range is (0, 20], 'format' is '%s' range is (0, 16]", location.Length, FormatDecimal, FormatHex))
 			}
 			c.TraceIdWithIndex.FormatIsHex = c.TraceIdWithIndex.IncrementalIdLocation.Format == FormatHex
 		}
 	}
 
+	if c.LogConfig.Format == "" {
+		c.LogConfig.Format = LogFormatText
+	}
+	if c.LogConfig.Format != LogFormatText && c.LogConfig.Format != LogFormatJSON {
+		errs = append(errs, fmt.Errorf("invalid 'format'(%s) of 'log-config', must be '%s' or '%s'", c.LogConfig.Format, LogFormatText, LogFormatJSON))
+	}
+	if c.LogConfig.MaxSizeMB < 0 || c.LogConfig.MaxBackups < 0 || c.LogConfig.MaxAgeDays < 0 {
+		errs = append(errs, fmt.Errorf("invalid 'log-config', 'max-size-mb'(%d), 'max-backups'(%d) and 'max-age-days'(%d) must not be negative", c.LogConfig.MaxSizeMB, c.LogConfig.MaxBackups, c.LogConfig.MaxAgeDays))
+	}
+	if c.LogConfig.Compress && c.LogConfig.MaxBackups == 0 {
+		errs = append(errs, errors.New("invalid 'log-config', 'compress' requires 'max-backups' greater than 0, there are no rotated files to compress"))
+	}
+
 	if len(c.ControllerIPs) == 0 {
 		log.Warning("controller-ips is empty")
+	} else if err := c.resolveControllerIPs(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ControllerResolveInterval == 0 {
+		c.ControllerResolveInterval = DefaultControllerResolveInterval
+	}
+
+	if statsdAddress, err := c.parseStatsdServer(); err != nil {
+		errs = append(errs, err)
 	} else {
-		for _, ipString := range c.ControllerIPs {
-			if net.ParseIP(ipString) == nil {
-				return errors.New("controller-ips invalid")
-			}
+		c.statsdAddress = statsdAddress
+	}
+
+	// the explicit 'node-ip' takes precedence over 'local-ip-cidr'
+	if c.LocalIPCIDR != "" {
+		if localIP, err := selectLocalIP(c.LocalIPCIDR); err != nil {
+			errs = append(errs, err)
+		} else if c.NodeIP == "" {
+			c.NodeIP = localIP
 		}
 	}
 
@@ -266,13 +328,7 @@ func (c *Config) Validate() error {
 		c.FlowTagCacheFlushTimeout = DefaultFlowTagCacheFlushTimeout
 	}
 
-	level := strings.ToLower(c.LogLevel)
-	c.LogLevel = "info"
-	for _, l := range []string{"error", "warn", "info", "debug"} {
-		if level == l {
-			c.LogLevel = l
-		}
-	}
+	c.LogLevel = normalizeLogLevel(c.LogLevel)
 
 	if c.GrpcBufferSize <= 0 {
 		c.GrpcBufferSize = DefaultGrpcBufferSize
@@ -286,15 +342,15 @@ func (c *Config) Validate() error {
 		c.StatsInterval = DefaultStatsInterval
 	}
 
-	var myNodeName, myPodName, myNamespace string
 	// in standalone mode, no 'EnvK8sNodeName', 'EnvK8sPodName', 'EnvK8sNamespace' environment variables
 	if c.IsRunningModeStandalone {
 		// in standalone mode, also can get NodeIP from 'EnvK8sNodeIP'
 		nodeIP, _ := os.LookupEnv(EnvK8sNodeIP)
-		if nodeIP == "" {
-			nodeIP = DefaultLocalIP
+		if nodeIP != "" {
+			c.NodeIP = nodeIP
+		} else if c.NodeIP == "" {
+			c.NodeIP = DefaultLocalIP
 		}
-		c.NodeIP = nodeIP
 		c.MyNodeName, _ = os.Hostname()
 		if c.CKDB.Host == "" {
 			c.CKDB.Host = DefaultLocalIP
@@ -307,36 +363,28 @@ func (c *Config) Validate() error {
 		actualAddrs = append(actualAddrs, net.JoinHostPort(c.CKDB.Host, strconv.Itoa(c.CKDB.Port)))
 		c.CKDB.ActualAddrs = &actualAddrs
 	} else {
-		if c.NodeIP == "" && c.ControllerIPs[0] == DefaultLocalIP {
+		if c.NodeIP == "" && len(c.ControllerIPs) > 0 && c.ControllerIPs[0] == DefaultLocalIP {
 			nodeIP, exist := os.LookupEnv(EnvK8sNodeIP)
 			if !exist {
-				log.Errorf("Can't get env %s", EnvK8sNodeIP)
-				sleepAndExit()
+				errs = append(errs, fmt.Errorf("can't get env %s", EnvK8sNodeIP))
 			}
 			c.NodeIP = nodeIP
 		}
 		var exist bool
-		myNodeName, exist = os.LookupEnv(EnvK8sNodeName)
+		c.MyNodeName, exist = os.LookupEnv(EnvK8sNodeName)
 		if !exist {
-			log.Errorf("Can't get node name env %s", EnvK8sNodeName)
-			sleepAndExit()
+			errs = append(errs, fmt.Errorf("can't get node name env %s", EnvK8sNodeName))
 		}
-		c.MyNodeName = myNodeName
-
-		myPodName, exist = os.LookupEnv(EnvK8sPodName)
-		if !exist {
-			log.Errorf("Can't get pod name env %s", EnvK8sPodName)
-			sleepAndExit()
+		if _, exist = os.LookupEnv(EnvK8sPodName); !exist {
+			errs = append(errs, fmt.Errorf("can't get pod name env %s", EnvK8sPodName))
 		}
-		myNamespace, exist = os.LookupEnv(EnvK8sNamespace)
-		if !exist {
-			log.Errorf("Can't get pod namespace env %s", EnvK8sNamespace)
-			sleepAndExit()
+		if _, exist = os.LookupEnv(EnvK8sNamespace); !exist {
+			errs = append(errs, fmt.Errorf("can't get pod namespace env %s", EnvK8sNamespace))
 		}
 	}
 
 	if c.StorageDisabled {
-		return nil
+		return errors.Join(errs...)
 	}
 	c.CKDiskMonitor.Validate()
 
@@ -345,8 +393,7 @@ func (c *Config) Validate() error {
 	}
 
 	if c.CKDB.Type != ckdb.CKDBTypeByconity && c.CKDB.Type != ckdb.CKDBTypeClickhouse {
-		log.Errorf("the setting of 'ckdb.type' (%s) is invalid, should be '%s' or '%s'", c.CKDB.Type, ckdb.CKDBTypeClickhouse, ckdb.CKDBTypeByconity)
-		sleepAndExit()
+		errs = append(errs, fmt.Errorf("the setting of 'ckdb.type' (%s) is invalid, should be '%s' or '%s'", c.CKDB.Type, ckdb.CKDBTypeClickhouse, ckdb.CKDBTypeByconity))
 	}
 
 	if c.CKDB.Host == "" {
@@ -383,19 +430,24 @@ func (c *Config) Validate() error {
 		c.CKDB.TimeZone = ckdb.DF_TIMEZONE
 	}
 
+	if err := c.ValidateAndSetckdbColdStorages(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// watchClickhouse blocks until the clickhouse endpoints are got from kubernetes and the cluster and storage policy are checked
+func (c *Config) watchClickhouse() {
+	myPodName, _ := os.LookupEnv(EnvK8sPodName)
+	myNamespace, _ := os.LookupEnv(EnvK8sNamespace)
 	var watcher *Watcher
 	var err error
 	for retryTimes := 0; ; retryTimes++ {
-		if c.IsRunningModeStandalone {
-			// in standalone mode, only supports one ClickHouse endpoint. no watcher required
-			break
-		}
-
 		if retryTimes > 0 {
 			time.Sleep(time.Second * 30)
 		}
 		if watcher == nil {
-			watcher, err = NewWatcher(c, myNodeName, myPodName, myNamespace)
+			watcher, err = NewWatcher(c, c.MyNodeName, myPodName, myNamespace)
 			if err != nil {
 				log.Warningf("get kubernetes watcher failed: %s", err)
 				continue
@@ -430,8 +482,6 @@ func (c *Config) Validate() error {
 		}
 		break
 	}
-
-	return c.ValidateAndSetckdbColdStorages()
 }
 
 func (c *Config) ValidateAndSetckdbColdStorages() error {
@@ -440,25 +490,26 @@ func (c *Config) ValidateAndSetckdbColdStorages() error {
 		return nil
 	}
 
+	var errs []error
 	var diskType ckdb.DiskType
 	if c.ColdStorage.ColdDisk.Type == "disk" {
 		diskType = ckdb.Disk
 	} else if c.ColdStorage.ColdDisk.Type == "volume" {
 		diskType = ckdb.Volume
 	} else {
-		return fmt.Errorf("'ingester.ckdb-cold-storage.cold-disk.type' is '%s', should be 'volume' or 'disk'", c.ColdStorage.ColdDisk.Type)
+		errs = append(errs, fmt.Errorf("'ingester.ckdb-cold-storage.cold-disk.type' is '%s', should be 'volume' or 'disk'", c.ColdStorage.ColdDisk.Type))
 	}
 
 	if c.ColdStorage.ColdDisk.Name == "" {
-		return errors.New("'ingester.ckdb-cold-storage.cold-disk.name' is empty")
+		errs = append(errs, errors.New("'ingester.ckdb-cold-storage.cold-disk.name' is empty"))
 	}
 
 	for i, setting := range c.ColdStorage.Settings {
 		if setting.Db == "" {
-			return fmt.Errorf("'ingester.ckdb-cold-storage.settings[%d].db' is empty", i)
+			errs = append(errs, fmt.Errorf("'ingester.ckdb-cold-storage.settings[%d].db' is empty", i))
 		}
 		if setting.TTLToMove < 1 {
-			return fmt.Errorf("'ingester.ckdb-cold-storage.settings[%d].ttl-hour-to-move' is '%d', should > 0", i, setting.TTLToMove)
+			errs = append(errs, fmt.Errorf("'ingester.ckdb-cold-storage.settings[%d].ttl-hour-to-move' is '%d', should > 0", i, setting.TTLToMove))
 		}
 		for _, table := range setting.Tables {
 			c.ckdbColdStorages[setting.Db+table] = &ckdb.ColdStorage{
@@ -478,26 +529,41 @@ func (c *Config) ValidateAndSetckdbColdStorages() error {
 			}
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (c *Config) GetCKDBColdStorages() map[string]*ckdb.ColdStorage {
 	return c.ckdbColdStorages
 }
 
-func Load(path string) *Config {
-	configBytes, err := os.ReadFile(path)
-	config := BaseConfig{
+// normalizeLogLevel returns the lower case log level, unsupported levels fall back to info
+func normalizeLogLevel(level string) string {
+	level = strings.ToLower(level)
+	for _, l := range []string{"error", "warn", "info", "debug"} {
+		if level == l {
+			return l
+		}
+	}
+	return "info"
+}
+
+func defaultBaseConfig() BaseConfig {
+	return BaseConfig{
 		LogFile:  "/var/log/deepflow/server.log",
 		LogLevel: "info",
+		LogConfig: LogConfig{
+			MaxBackups: DefaultLogMaxBackups,
+			Format:     LogFormatText,
+		},
 		Base: Config{
-			ControllerIPs:   []string{DefaultLocalIP},
-			ControllerPort:  DefaultControllerPort,
-			CKDBAuth:        Auth{"default", ""},
-			IngesterEnabled: true,
-			UDPReadBuffer:   64 << 20,
-			TCPReadBuffer:   4 << 20,
-			TCPReaderBuffer: 1 << 20,
+			ControllerIPs:             []string{DefaultLocalIP},
+			ControllerPort:            DefaultControllerPort,
+			ControllerResolveInterval: DefaultControllerResolveInterval,
+			CKDBAuth:                  Auth{"default", ""},
+			IngesterEnabled:           true,
+			UDPReadBuffer:             64 << 20,
+			TCPReadBuffer:             4 << 20,
+			TCPReaderBuffer:           1 << 20,
 			CKDiskMonitor: CKDiskMonitor{
 				DefaultCheckInterval,
 				false,
@@ -538,23 +604,68 @@ func Load(path string) *Config {
 			DatasourceListenPort:     DefaultDatasourceListenPort,
 		},
 	}
+}
+
+// loadBaseConfig precedence: environment variables > config file > defaults
+func loadBaseConfig(configBytes []byte) (*BaseConfig, error) {
+	config := defaultBaseConfig()
+	configBytes, err := checkAndMigrateConfig(configBytes)
 	if err != nil {
-		log.Error("Read config file error:", err)
-		sleepAndExit()
+		return nil, fmt.Errorf("check config error: %w", err)
 	}
-	if err = yaml.Unmarshal(configBytes, &config); err != nil {
-		log.Error("Unmarshal yaml error:", err)
-		sleepAndExit()
+	// the errors of yaml contain the line number, e.g. "yaml: line 3: did not find expected key"
+	if err := yaml.Unmarshal(configBytes, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal yaml error: %s", err)
 	}
-
+	if err := ApplyEnvOverrides(&config, EnvOverridePrefix); err != nil {
+		return nil, err
+	}
+	config.LogLevel = normalizeLogLevel(config.LogLevel)
 	config.Base.TraceIdWithIndex = config.TraceIdWithIndex
+	config.Base.LogConfig = config.LogConfig
+	return &config, nil
+}
+
+// LoadBase returns the whole config file, which is required by ConfigWatcher
+func LoadBase(path string) (*BaseConfig, error) {
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file error: %s", err)
+	}
+	config, err := loadBaseConfig(configBytes)
+	if err != nil {
+		return nil, fmt.Errorf("load config file %s failed: %w", path, err)
+	}
 	if err = config.Base.Validate(); err != nil {
-		log.Error(err)
-		sleepAndExit()
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
 	}
 	config.Base.LogFile = config.LogFile
 	config.Base.LogLevel = config.LogLevel
-	return &config.Base
+	config.Base.configPath = path
+	config.Base.configBytes = configBytes
+	return config, nil
+}
+
+func Load(path string) (*Config, error) {
+	config, err := LoadBase(path)
+	if err != nil {
+		return nil, err
+	}
+	return &config.Base, nil
+}
+
+// MustLoadBase exits the process if the config file can not be loaded
+func MustLoadBase(path string) *BaseConfig {
+	config, err := LoadBase(path)
+	if err != nil {
+		log.Error(err)
+		sleepAndExit()
+	}
+	return config
+}
+
+func MustLoad(path string) *Config {
+	return &MustLoadBase(path).Base
 }
 
 func CheckCluster(conns common.DBs, clusterName string) error {
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), "not-exist.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "read config file error") {
+		t.Errorf("Expected read error found %v", err)
+	}
+}
+
+func TestLoadMalformedYaml(t *testing.T) {
+	path := writeTestConfig(t, "ingester:\n  listen-port: 20033\n  controller-ips: [10.0.0.1\n")
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "line ") {
+		t.Errorf("Expected error with line number found %v", err)
+	}
+
+	path = writeTestConfig(t, "ingester:\n  listen-port: abc\n")
+	_, err = Load(path)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected error with line 2 found %v", err)
+	}
+}
+
+func TestLoadValidateErrors(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	path := writeTestConfig(t, `
+trace-id-with-index:
+  type: unknown
+ingester:
+  controller-ips: [10.0.0.1, bad_ip]
+  ckdb:
+    type: mysql
+`)
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("Expected validate errors")
+	}
+	for _, expect := range []string{"'trace-id-with-index'", "controller-ips[1] 'bad_ip'", "'ckdb.type' (mysql)"} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("Expected error contains %s found %v", expect, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	path := writeTestConfig(t, "log-level: debug\ningester:\n  storage-disabled: true\n  controller-ips: [10.0.0.1]\n")
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config failed: %s", err)
+	}
+	if config.LogLevel != "debug" || config.ControllerIPs[0] != "10.0.0.1" || config.ListenPort != DefaultListenPort {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
+
+func TestLoadLogConfig(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	path := writeTestConfig(t, "log-config:\n  max-size-mb: 100\n  format: json\ningester:\n  storage-disabled: true\n")
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config failed: %s", err)
+	}
+	expect := LogConfig{MaxSizeMB: 100, MaxBackups: DefaultLogMaxBackups, Format: LogFormatJSON}
+	if config.LogConfig != expect {
+		t.Errorf("Expected %+v found %+v", expect, config.LogConfig)
+	}
+
+	path = writeTestConfig(t, "log-config:\n  max-backups: 0\n  compress: true\n  format: xml\ningester:\n  storage-disabled: true\n")
+	_, err = Load(path)
+	if err == nil {
+		t.Fatal("Expected validate errors")
+	}
+	for _, expect := range []string{"'compress' requires 'max-backups'", "invalid 'format'(xml) of 'log-config'"} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("Expected error contains %s found %v", expect, err)
+		}
+	}
+}
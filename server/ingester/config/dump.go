@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const RedactedValue = "******"
+
+// the values of the yaml keys containing these words are redacted in Dump
+var sensitiveKeyWords = []string{"password", "token", "secret"}
+
+func isSensitiveKey(yamlKey string) bool {
+	yamlKey = strings.ToLower(yamlKey)
+	for _, word := range sensitiveKeyWords {
+		if strings.Contains(yamlKey, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dump returns the effective config (defaults, config file and environment variables applied) as yaml,
+// in the same layout as the config file. Fields without a yaml tag are runtime states and are not dumped,
+// sensitive fields such as passwords and tokens are redacted.
+func (c *Config) Dump() ([]byte, error) {
+	hotConfigLock.RLock()
+	base := BaseConfig{
+		ConfigVersion:    CurrentConfigVersion,
+		LogFile:          c.LogFile,
+		LogLevel:         c.LogLevel,
+		LogConfig:        c.LogConfig,
+		TraceIdWithIndex: c.TraceIdWithIndex,
+		Base:             *c,
+	}
+	hotConfigLock.RUnlock()
+	return yaml.Marshal(dumpValue(reflect.ValueOf(base)))
+}
+
+func dumpValue(v reflect.Value) interface{} {
+	if v.Type() == durationType {
+		return v.Interface().(time.Duration).String()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := yaml.MapSlice{}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			yamlKey := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if yamlKey == "" || yamlKey == "-" || !t.Field(i).IsExported() {
+				continue
+			}
+			field := v.Field(i)
+			if isSensitiveKey(yamlKey) && !field.IsZero() {
+				fields = append(fields, yaml.MapItem{Key: yamlKey, Value: RedactedValue})
+				continue
+			}
+			fields = append(fields, yaml.MapItem{Key: yamlKey, Value: dumpValue(field)})
+		}
+		return fields
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return dumpValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, dumpValue(v.Index(i)))
+		}
+		return items
+	case reflect.Map:
+		items := make(map[interface{}]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			items[iter.Key().Interface()] = dumpValue(iter.Value())
+		}
+		return items
+	}
+	return v.Interface()
+}
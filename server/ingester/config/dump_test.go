@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestDump(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	t.Setenv(EnvK8sNodeIP, "")
+	t.Setenv(EnvOverridePrefix+"STATS_INTERVAL", "30")
+	path := writeTestConfig(t, `
+log-level: debug
+ingester:
+  storage-disabled: true
+  controller-ips: [10.0.0.1]
+  ckdb-auth:
+    username: deepflow
+    password: deepflow-password
+`)
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump, err := config.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "dump.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, dump, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dump) != string(expect) {
+		t.Errorf("Expected:\n%s\nfound:\n%s", expect, dump)
+	}
+}
+
+func TestDumpRedaction(t *testing.T) {
+	type secrets struct {
+		Password    string        `yaml:"password"`
+		AccessToken string        `yaml:"access-token"`
+		SecretKey   []string      `yaml:"secret-key"`
+		Empty       string        `yaml:"empty-password"`
+		Timeout     time.Duration `yaml:"timeout"`
+		Username    string        `yaml:"username"`
+	}
+	bytes, err := yaml.Marshal(dumpValue(reflect.ValueOf(secrets{
+		Password:    "p@ss",
+		AccessToken: "t0ken",
+		SecretKey:   []string{"k1"},
+		Timeout:     90 * time.Second,
+		Username:    "deepflow",
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := string(bytes)
+	for _, leaked := range []string{"p@ss", "t0ken", "k1"} {
+		if strings.Contains(dump, leaked) {
+			t.Errorf("Expected %s redacted found:\n%s", leaked, dump)
+		}
+	}
+	expect := "password: '******'\naccess-token: '******'\nsecret-key: '******'\nempty-password: \"\"\ntimeout: 1m30s\nusername: deepflow\n"
+	if dump != expect {
+		t.Errorf("Expected:\n%s\nfound:\n%s", expect, dump)
+	}
+}
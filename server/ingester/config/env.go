@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvOverridePrefix = "DEEPFLOW_"
+	// the fields of the 'ingester' section share the top-level prefix, e.g. DEEPFLOW_CONTROLLER_IPS
+	envInlineSection = "ingester"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// EnvName returns the environment variable name derived from the yaml key path,
+// e.g. ckdb.host -> DEEPFLOW_CKDB_HOST
+func EnvName(prefix, yamlKey string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(yamlKey, "-", "_"))
+}
+
+// ApplyEnvOverrides overrides the fields of the struct referenced by `ptr` with
+// environment variables derived from their yaml tags. Nested structs extend the
+// prefix with their own key, fields without a yaml tag are ignored.
+// Lists are split on comma, durations accept time.ParseDuration format or plain seconds.
+func ApplyEnvOverrides(ptr interface{}, prefix string) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("env overrides require a struct pointer, got %T", ptr)
+	}
+	return applyEnvOverrides(v.Elem(), prefix, true)
+}
+
+func applyEnvOverrides(v reflect.Value, prefix string, isTop bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		yamlKey := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if yamlKey == "" || yamlKey == "-" {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			subPrefix := EnvName(prefix, yamlKey) + "_"
+			if isTop && yamlKey == envInlineSection {
+				subPrefix = prefix
+			}
+			if err := applyEnvOverrides(field, subPrefix, false); err != nil {
+				return err
+			}
+			continue
+		}
+		name := EnvName(prefix, yamlKey)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFieldFromEnv(field, value); err != nil {
+			return fmt.Errorf("invalid value '%s' of env %s: %s", value, name, err)
+		}
+	}
+	return nil
+}
+
+func setFieldFromEnv(field reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+	if field.Type() == durationType {
+		if d, err := time.ParseDuration(value); err == nil {
+			field.SetInt(int64(d))
+			return nil
+		}
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("should be a duration or seconds")
+		}
+		field.SetInt(int64(time.Duration(seconds) * time.Second))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setFieldFromEnv(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
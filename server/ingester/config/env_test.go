@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const envTestYaml = `
+log-level: warn
+ingester:
+  storage-disabled: true
+  controller-ips: [10.0.0.1]
+  listen-port: 30033
+  ckdb:
+    host: ck-from-file
+`
+
+func TestLoadBaseConfigEnvOverrides(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	t.Setenv("DEEPFLOW_LOG_LEVEL", "debug")
+	t.Setenv("DEEPFLOW_CONTROLLER_IPS", "10.0.0.2, 10.0.0.3")
+	t.Setenv("DEEPFLOW_CKDB_HOST", "ck-from-env")
+	t.Setenv("DEEPFLOW_STATS_INTERVAL", "30")
+
+	config, err := loadBaseConfig([]byte(envTestYaml))
+	if err != nil {
+		t.Fatalf("load config failed: %s", err)
+	}
+	if err := config.Base.Validate(); err != nil {
+		t.Fatalf("validate config failed: %s", err)
+	}
+	if config.LogLevel != "debug" {
+		t.Errorf("Expected log level debug found %s", config.LogLevel)
+	}
+	if expect := []string{"10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(config.Base.ControllerIPs, expect) {
+		t.Errorf("Expected controller ips %v found %v", expect, config.Base.ControllerIPs)
+	}
+	if config.Base.CKDB.Host != "ck-from-env" {
+		t.Errorf("Expected ckdb host ck-from-env found %s", config.Base.CKDB.Host)
+	}
+	if config.Base.StatsInterval != 30 {
+		t.Errorf("Expected stats interval 30 found %d", config.Base.StatsInterval)
+	}
+	// not overridden, keep the value of the file or the default
+	if config.Base.ListenPort != 30033 {
+		t.Errorf("Expected listen port 30033 found %d", config.Base.ListenPort)
+	}
+	if config.Base.ControllerPort != DefaultControllerPort {
+		t.Errorf("Expected controller port %d found %d", DefaultControllerPort, config.Base.ControllerPort)
+	}
+}
+
+func TestLoadBaseConfigEnvInvalid(t *testing.T) {
+	t.Setenv("DEEPFLOW_LISTEN_PORT", "70000")
+	if _, err := loadBaseConfig([]byte(envTestYaml)); err == nil {
+		t.Error("Expected error for listen port out of range")
+	}
+}
+
+func TestLoadBaseConfigEnvValidate(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	t.Setenv("DEEPFLOW_CONTROLLER_IPS", "10.0.0.2,not_an_ip")
+
+	config, err := loadBaseConfig([]byte(envTestYaml))
+	if err != nil {
+		t.Fatalf("load config failed: %s", err)
+	}
+	expect := "controller-ips[1] 'not_an_ip': not an IP address or hostname"
+	if err := config.Base.Validate(); err == nil || err.Error() != expect {
+		t.Errorf("Expected error '%s' found %v", expect, err)
+	}
+}
+
+func TestApplyEnvOverridesTypes(t *testing.T) {
+	type nested struct {
+		Ratio float64 `yaml:"ratio"`
+	}
+	type envTestConfig struct {
+		Interval  time.Duration `yaml:"interval"`
+		Timeout   time.Duration `yaml:"timeout"`
+		QueueSize int           `yaml:"queue-size"`
+		Ports     []uint16      `yaml:"ports,flow"`
+		Enabled   bool          `yaml:"enabled"`
+		Nested    nested        `yaml:"nested"`
+		Ignored   string
+	}
+	t.Setenv("TEST_INTERVAL", "1m30s")
+	t.Setenv("TEST_TIMEOUT", "10")
+	t.Setenv("TEST_QUEUE_SIZE", "65536")
+	t.Setenv("TEST_PORTS", "20033,20035")
+	t.Setenv("TEST_ENABLED", "true")
+	t.Setenv("TEST_NESTED_RATIO", "0.5")
+	t.Setenv("TEST_IGNORED", "x")
+
+	config := envTestConfig{}
+	if err := ApplyEnvOverrides(&config, "TEST_"); err != nil {
+		t.Fatalf("apply env overrides failed: %s", err)
+	}
+	expect := envTestConfig{
+		Interval:  90 * time.Second,
+		Timeout:   10 * time.Second,
+		QueueSize: 65536,
+		Ports:     []uint16{20033, 20035},
+		Enabled:   true,
+		Nested:    nested{Ratio: 0.5},
+	}
+	if !reflect.DeepEqual(config, expect) {
+		t.Errorf("Expected %+v found %+v", expect, config)
+	}
+
+	t.Setenv("TEST_TIMEOUT", "10x")
+	if err := ApplyEnvOverrides(&config, "TEST_"); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/deepflowio/deepflow/server/libs/logger"
+)
+
+// InitLogger writes the file log according to log-config, 'max-size-mb' 0 keeps the daily rotation
+func InitLogger(c *Config) error {
+	var formatter logging.Formatter
+	if c.LogConfig.Format == LogFormatJSON {
+		formatter = logger.JSONFormatter{}
+	}
+	maxAge := time.Duration(c.LogConfig.MaxAgeDays) * 24 * time.Hour
+	if c.LogConfig.MaxSizeMB == 0 {
+		if maxAge == 0 {
+			maxAge = logger.LOG_MAX_AGE
+		}
+		return logger.EnableFileLogWithFormatter(c.LogFile, maxAge, formatter)
+	}
+	writer, err := logger.NewSizeRotatingWriter(c.LogFile, int64(c.LogConfig.MaxSizeMB)<<20, c.LogConfig.MaxBackups, maxAge, c.LogConfig.Compress)
+	if err != nil {
+		return err
+	}
+	logger.EnableFileLogWriter(writer, formatter)
+	return nil
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// fields tagged with `reload:"hot"` are applied on reload, all other changes require restart
+	reloadTagName  = "reload"
+	reloadTagHot   = "hot"
+	KeyLogLevel    = "log-level"
+	reloadKeyDelim = "."
+)
+
+// hotConfigLock guards the hot-reloadable fields, Reload writes them while Dump may be reading them
+var hotConfigLock sync.RWMutex
+
+// ChangeCallback is invoked with the yaml key path (e.g. 'log-level') and the old and new values
+type ChangeCallback func(key string, oldValue, newValue interface{})
+
+type FieldChange struct {
+	Key      string
+	OldValue interface{}
+	NewValue interface{}
+	Hot      bool
+}
+
+type ReloadResult struct {
+	Applied         []FieldChange
+	RequiresRestart []FieldChange
+}
+
+// ConfigWatcher re-reads the config file on SIGHUP, and applies the changes of the hot-reloadable fields
+type ConfigWatcher struct {
+	sync.Mutex
+	path      string
+	current   *BaseConfig
+	callbacks map[string][]ChangeCallback
+	validate  func(*Config) error
+
+	signals chan os.Signal
+	done    chan struct{}
+}
+
+func NewConfigWatcher(path string, current *BaseConfig) *ConfigWatcher {
+	return &ConfigWatcher{
+		path:      path,
+		current:   current,
+		callbacks: make(map[string][]ChangeCallback),
+		validate:  (*Config).ValidateFields,
+	}
+}
+
+// OnChange registers a callback of the hot-reloadable field `key`
+func (w *ConfigWatcher) OnChange(key string, callback ChangeCallback) error {
+	if !isHotReloadKey(reflect.TypeOf(BaseConfig{}), key) {
+		return fmt.Errorf("config '%s' is not hot-reloadable", key)
+	}
+	w.Lock()
+	w.callbacks[key] = append(w.callbacks[key], callback)
+	w.Unlock()
+	return nil
+}
+
+func (w *ConfigWatcher) OnLogLevelChange(callback func(level string)) {
+	w.OnChange(KeyLogLevel, func(_ string, _, newValue interface{}) {
+		callback(newValue.(string))
+	})
+}
+
+// Reload re-reads the config file. If the new config fails validation, the current config is kept.
+// Only the fields are validated, the clickhouse connections are checked at startup.
+func (w *ConfigWatcher) Reload() (*ReloadResult, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	configBytes, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file error: %s", err)
+	}
+	config, err := loadBaseConfig(configBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.validate(&config.Base); err != nil {
+		return nil, fmt.Errorf("validate config failed, keep the current config: %s", err)
+	}
+
+	result := &ReloadResult{}
+	hotConfigLock.Lock()
+	for _, change := range diffConfig(reflect.ValueOf(w.current).Elem(), reflect.ValueOf(config).Elem(), "", false) {
+		if !change.Hot {
+			log.Warningf("config '%s' changed from %v to %v, requires restart", change.Key, change.OldValue, change.NewValue)
+			result.RequiresRestart = append(result.RequiresRestart, change)
+			continue
+		}
+		setConfigField(reflect.ValueOf(w.current).Elem(), change.Key, change.NewValue)
+		log.Infof("config '%s' changed from %v to %v", change.Key, change.OldValue, change.NewValue)
+		result.Applied = append(result.Applied, change)
+	}
+	// keep consistent with Load
+	w.current.Base.LogLevel = w.current.LogLevel
+	hotConfigLock.Unlock()
+
+	for _, change := range result.Applied {
+		for _, callback := range w.callbacks[change.Key] {
+			callback(change.Key, change.OldValue, change.NewValue)
+		}
+	}
+	return result, nil
+}
+
+func (w *ConfigWatcher) Start() {
+	w.Lock()
+	defer w.Unlock()
+	if w.signals != nil {
+		return
+	}
+	w.signals = make(chan os.Signal, 1)
+	w.done = make(chan struct{})
+	signal.Notify(w.signals, syscall.SIGHUP)
+	go w.run(w.signals, w.done)
+}
+
+func (w *ConfigWatcher) run(signals chan os.Signal, done chan struct{}) {
+	for {
+		select {
+		case <-signals:
+			log.Infof("received SIGHUP, reload config %s", w.path)
+			if _, err := w.Reload(); err != nil {
+				log.Error(err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (w *ConfigWatcher) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.signals == nil {
+		return nil
+	}
+	signal.Stop(w.signals)
+	close(w.done)
+	w.signals, w.done = nil, nil
+	return nil
+}
+
+// diffConfig compares the fields with yaml tags, nested structs are compared field by field
+func diffConfig(oldValue, newValue reflect.Value, prefix string, hot bool) []FieldChange {
+	changes := []FieldChange{}
+	t := oldValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		yamlKey := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if yamlKey == "" || yamlKey == "-" || !field.IsExported() {
+			continue
+		}
+		key := prefix + yamlKey
+		fieldHot := hot || field.Tag.Get(reloadTagName) == reloadTagHot
+		oldField, newField := oldValue.Field(i), newValue.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			changes = append(changes, diffConfig(oldField, newField, key+reloadKeyDelim, fieldHot)...)
+			continue
+		}
+		if !reflect.DeepEqual(oldField.Interface(), newField.Interface()) {
+			changes = append(changes, FieldChange{
+				Key:      key,
+				OldValue: oldField.Interface(),
+				NewValue: newField.Interface(),
+				Hot:      fieldHot,
+			})
+		}
+	}
+	return changes
+}
+
+func lookupConfigField(t reflect.Type, key string) ([]int, bool, bool) {
+	hot := false
+	index := []int{}
+	for _, yamlKey := range strings.Split(key, reloadKeyDelim) {
+		if t.Kind() != reflect.Struct {
+			return nil, false, false
+		}
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if strings.Split(field.Tag.Get("yaml"), ",")[0] == yamlKey {
+				hot = hot || field.Tag.Get(reloadTagName) == reloadTagHot
+				index = append(index, i)
+				t = field.Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false, false
+		}
+	}
+	return index, hot, true
+}
+
+func isHotReloadKey(t reflect.Type, key string) bool {
+	_, hot, ok := lookupConfigField(t, key)
+	return ok && hot
+}
+
+func setConfigField(v reflect.Value, key string, value interface{}) {
+	index, _, ok := lookupConfigField(v.Type(), key)
+	if !ok {
+		return
+	}
+	v.FieldByIndex(index).Set(reflect.ValueOf(value))
+}
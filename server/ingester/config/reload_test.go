@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const reloadTestYaml = `
+log-level: %s
+ingester:
+  storage-disabled: true
+  controller-ips: [%s]
+  listen-port: %d
+`
+
+func writeReloadTestConfig(t *testing.T, path, logLevel, controllerIP string, listenPort int) {
+	content := []byte(fmt.Sprintf(reloadTestYaml, logLevel, controllerIP, listenPort))
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newReloadTestWatcher(t *testing.T) (*ConfigWatcher, string) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	writeReloadTestConfig(t, path, "info", "10.0.0.1", DefaultListenPort)
+	configBytes, _ := os.ReadFile(path)
+	config, err := loadBaseConfig(configBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Base.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewConfigWatcher(path, config), path
+}
+
+func TestConfigReloadHotChange(t *testing.T) {
+	w, path := newReloadTestWatcher(t)
+	level := ""
+	w.OnLogLevelChange(func(l string) { level = l })
+
+	writeReloadTestConfig(t, path, "debug", "10.0.0.1", DefaultListenPort)
+	result, err := w.Reload()
+	if err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	if len(result.Applied) != 1 || result.Applied[0].Key != KeyLogLevel || len(result.RequiresRestart) != 0 {
+		t.Errorf("Expected only %s applied found %+v", KeyLogLevel, result)
+	}
+	if level != "debug" {
+		t.Errorf("Expected callback with debug found '%s'", level)
+	}
+	if w.current.LogLevel != "debug" || w.current.Base.LogLevel != "debug" {
+		t.Errorf("Expected current log level debug found %s/%s", w.current.LogLevel, w.current.Base.LogLevel)
+	}
+}
+
+func TestConfigReloadInvalid(t *testing.T) {
+	w, path := newReloadTestWatcher(t)
+	called := false
+	w.OnLogLevelChange(func(string) { called = true })
+
+	writeReloadTestConfig(t, path, "debug", "not_an_ip", DefaultListenPort)
+	if _, err := w.Reload(); err == nil {
+		t.Error("Expected error for invalid controller-ips")
+	}
+	if err := os.WriteFile(path, []byte("ingester: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Reload(); err == nil {
+		t.Error("Expected error for invalid yaml")
+	}
+	if called || w.current.LogLevel != "info" || w.current.Base.ControllerIPs[0] != "10.0.0.1" {
+		t.Errorf("Expected current config kept found %s %v", w.current.LogLevel, w.current.Base.ControllerIPs)
+	}
+}
+
+func TestConfigReloadRequiresRestart(t *testing.T) {
+	w, path := newReloadTestWatcher(t)
+
+	writeReloadTestConfig(t, path, "info", "10.0.0.1", 30033)
+	result, err := w.Reload()
+	if err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	if len(result.Applied) != 0 || len(result.RequiresRestart) != 1 || result.RequiresRestart[0].Key != "ingester.listen-port" {
+		t.Errorf("Expected ingester.listen-port requires restart found %+v", result)
+	}
+	if w.current.Base.ListenPort != DefaultListenPort {
+		t.Errorf("Expected listen port %d kept found %d", DefaultListenPort, w.current.Base.ListenPort)
+	}
+	if err := w.OnChange("ingester.listen-port", func(string, interface{}, interface{}) {}); err == nil {
+		t.Error("Expected error registering callback of immutable config")
+	}
+}
+
+func TestConfigReloadNotStandalone(t *testing.T) {
+	w, path := newReloadTestWatcher(t)
+	// not standalone, reload only validates the fields and does not connect to kubernetes or clickhouse
+	t.Setenv(EnvRunningMode, "")
+	t.Setenv(EnvK8sNodeName, "node")
+	t.Setenv(EnvK8sPodName, "pod")
+	t.Setenv(EnvK8sNamespace, "deepflow")
+
+	writeReloadTestConfig(t, path, "debug", "10.0.0.1", DefaultListenPort)
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Reload()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("reload failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload blocked")
+	}
+	if w.current.LogLevel != "debug" {
+		t.Errorf("Expected current log level debug found %s", w.current.LogLevel)
+	}
+}
+
+func TestConfigReloadNormalizeLogLevel(t *testing.T) {
+	w, path := newReloadTestWatcher(t)
+	level := ""
+	w.OnLogLevelChange(func(l string) { level = l })
+
+	writeReloadTestConfig(t, path, "DEBUG", "10.0.0.1", DefaultListenPort)
+	if _, err := w.Reload(); err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	if level != "debug" || w.current.LogLevel != "debug" || w.current.Base.LogLevel != "debug" {
+		t.Errorf("Expected log level debug found %s %s/%s", level, w.current.LogLevel, w.current.Base.LogLevel)
+	}
+	// unsupported levels fall back to info
+	writeReloadTestConfig(t, path, "verbose", "10.0.0.1", DefaultListenPort)
+	if _, err := w.Reload(); err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	if level != "info" || w.current.LogLevel != "info" || w.current.Base.LogLevel != "info" {
+		t.Errorf("Expected log level info found %s %s/%s", level, w.current.LogLevel, w.current.Base.LogLevel)
+	}
+}
+
+// run with -race, Dump of /v1/config may be called while reloading
+func TestConfigReloadConcurrentDump(t *testing.T) {
+	w, path := newReloadTestWatcher(t)
+	done := make(chan struct{})
+	dumped := make(chan error, 1)
+	go func() {
+		defer close(dumped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := w.current.Base.Dump(); err != nil {
+				dumped <- err
+				return
+			}
+		}
+	}()
+	for i, level := range []string{"debug", "info", "warn", "error"} {
+		writeReloadTestConfig(t, path, level, "10.0.0.1", DefaultListenPort)
+		if _, err := w.Reload(); err != nil {
+			t.Fatalf("reload %d failed: %s", i, err)
+		}
+	}
+	close(done)
+	if err := <-dumped; err != nil {
+		t.Errorf("dump failed: %s", err)
+	}
+}
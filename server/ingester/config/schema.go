@@ -0,0 +1,373 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	yamlv2 "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	// CurrentConfigVersion 当前配置文件版本，'config-version' 低于该版本时加载前执行迁移
+	CurrentConfigVersion = 1
+	ConfigVersionKey     = "config-version"
+	// 'extra' 下的配置项不做校验，用于向前兼容
+	ExtraConfigKey = "extra"
+)
+
+// KeyRename moves the value of the dotted key path `From` to `To`, e.g. ingester.ckdb.host
+type KeyRename struct {
+	From string
+	To   string
+}
+
+var (
+	schemaLock   sync.Mutex
+	schemaTypes  []reflect.Type
+	migrations   = map[int][]KeyRename{}
+	unmarshalerT = reflect.TypeOf((*yamlv2.Unmarshaler)(nil)).Elem()
+)
+
+func init() {
+	RegisterSchema(BaseConfig{})
+}
+
+// RegisterSchema registers the yaml keys of `schema`, which is the struct of the whole config file,
+// e.g. FlowLogConfig. The modules reading the config file by themselves must register their struct,
+// otherwise their keys will be reported as unknown.
+func RegisterSchema(schema interface{}) {
+	schemaLock.Lock()
+	schemaTypes = append(schemaTypes, reflect.TypeOf(schema))
+	schemaLock.Unlock()
+}
+
+// RegisterMigration registers the keys renamed when upgrading the config file from `fromVersion` to `fromVersion+1`
+func RegisterMigration(fromVersion int, renames ...KeyRename) {
+	schemaLock.Lock()
+	migrations[fromVersion] = append(migrations[fromVersion], renames...)
+	schemaLock.Unlock()
+}
+
+// ReadConfigFile returns the config file with the migrations applied, the modules
+// re-reading the config file use it to see the same keys as the base config.
+func (c *Config) ReadConfigFile(path string) ([]byte, error) {
+	if c != nil && c.configPath == path && c.configBytes != nil {
+		configBytes, _, err := migrateConfig(c.configBytes)
+		return configBytes, err
+	}
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configBytes, _, err = migrateConfig(configBytes)
+	return configBytes, err
+}
+
+// migrateConfig applies the migrations without logging, returns the changes
+func migrateConfig(configBytes []byte) ([]byte, []string, error) {
+	doc, root := parseConfigNode(configBytes)
+	if root == nil {
+		return configBytes, nil, nil
+	}
+	changes, err := migrateNode(root)
+	if err != nil || len(changes) == 0 {
+		return configBytes, changes, err
+	}
+	configBytes, err = yamlv3.Marshal(doc)
+	return configBytes, changes, err
+}
+
+// checkAndMigrateConfig migrates the config file of lower version, logs the changes and
+// reports the unknown keys with their line numbers
+func checkAndMigrateConfig(configBytes []byte) ([]byte, error) {
+	doc, root := parseConfigNode(configBytes)
+	if root == nil {
+		return configBytes, nil
+	}
+	changes, err := migrateNode(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		log.Infof("config migrated: %s, please update the config file", change)
+	}
+	if err := checkUnknownKeys(root); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return configBytes, nil
+	}
+	return yamlv3.Marshal(doc)
+}
+
+// parseConfigNode returns nil root if the config file is not a yaml map,
+// the syntax errors are reported by the decoder later
+func parseConfigNode(configBytes []byte) (*yamlv3.Node, *yamlv3.Node) {
+	doc := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(configBytes, doc); err != nil {
+		return nil, nil
+	}
+	if doc.Kind != yamlv3.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+	return doc, doc.Content[0]
+}
+
+func configVersion(root *yamlv3.Node) (int, error) {
+	_, value := lookupKey(root, ConfigVersionKey)
+	if value == nil {
+		return 0, nil
+	}
+	var version int
+	if err := value.Decode(&version); err != nil || version < 0 {
+		return 0, fmt.Errorf("line %d: invalid '%s'(%s)", value.Line, ConfigVersionKey, value.Value)
+	}
+	return version, nil
+}
+
+func migrateNode(root *yamlv3.Node) ([]string, error) {
+	version, err := configVersion(root)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentConfigVersion {
+		log.Warningf("'%s'(%d) is newer than the supported version %d", ConfigVersionKey, version, CurrentConfigVersion)
+		return nil, nil
+	}
+	schemaLock.Lock()
+	defer schemaLock.Unlock()
+	var changes []string
+	for v := version; v < CurrentConfigVersion; v++ {
+		for _, rename := range migrations[v] {
+			line, err := renameKey(root, rename)
+			if err != nil {
+				return nil, err
+			}
+			if line > 0 {
+				changes = append(changes, fmt.Sprintf("line %d: '%s' is renamed to '%s' (version %d -> %d)", line, rename.From, rename.To, v, v+1))
+			}
+		}
+	}
+	return changes, nil
+}
+
+func lookupKey(mapping *yamlv3.Node, key string) (*yamlv3.Node, *yamlv3.Node) {
+	if mapping == nil || mapping.Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// renameKey returns the line number of the renamed key, 0 if `From` does not exist
+func renameKey(root *yamlv3.Node, rename KeyRename) (int, error) {
+	from := strings.Split(rename.From, ".")
+	parent := root
+	for _, key := range from[:len(from)-1] {
+		_, parent = lookupKey(parent, key)
+	}
+	if parent == nil || parent.Kind != yamlv3.MappingNode {
+		return 0, nil
+	}
+	fromKey := from[len(from)-1]
+	index := -1
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == fromKey {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0, nil
+	}
+	keyNode, valueNode := parent.Content[index], parent.Content[index+1]
+
+	to := strings.Split(rename.To, ".")
+	target := root
+	for _, key := range to[:len(to)-1] {
+		_, next := lookupKey(target, key)
+		if next == nil {
+			next = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+			target.Content = append(target.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}, next)
+		} else if next.Kind != yamlv3.MappingNode {
+			return 0, fmt.Errorf("line %d: can not rename '%s' to '%s', '%s' is not a map", next.Line, rename.From, rename.To, key)
+		}
+		target = next
+	}
+	toKey := to[len(to)-1]
+	if existKey, _ := lookupKey(target, toKey); existKey != nil {
+		return 0, fmt.Errorf("line %d: both '%s' and '%s'(line %d) are set, remove '%s'", keyNode.Line, rename.From, rename.To, existKey.Line, rename.From)
+	}
+	// 删除原配置项后再添加新配置项，避免二者位于同一map时下标失效
+	parent.Content = append(parent.Content[:index], parent.Content[index+2:]...)
+	line := keyNode.Line
+	keyNode.Value = toKey
+	target.Content = append(target.Content, keyNode, valueNode)
+	return line, nil
+}
+
+// schemaNode describes the known keys of a yaml value, `fields` is nil for scalars,
+// maps and other values whose keys are not checked
+type schemaNode struct {
+	fields map[string]*schemaNode
+	elem   *schemaNode
+}
+
+func buildSchema(t reflect.Type, built map[reflect.Type]*schemaNode) *schemaNode {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node, ok := built[t]; ok {
+		return node
+	}
+	node := &schemaNode{}
+	built[t] = node
+	if t.Implements(unmarshalerT) || reflect.PtrTo(t).Implements(unmarshalerT) {
+		return node
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		node.elem = buildSchema(t.Elem(), built)
+	case reflect.Struct:
+		node.fields = map[string]*schemaNode{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			tags := strings.Split(field.Tag.Get("yaml"), ",")
+			// fields without a yaml tag are runtime states, same as ApplyEnvOverrides and Dump
+			if tags[0] == "-" || (tags[0] == "" && !hasTag(tags[1:], "inline")) {
+				continue
+			}
+			child := buildSchema(field.Type, built)
+			if hasTag(tags[1:], "inline") {
+				mergeSchema(node, child)
+				continue
+			}
+			if exist, ok := node.fields[tags[0]]; ok {
+				mergeSchema(exist, child)
+			} else {
+				node.fields[tags[0]] = child
+			}
+		}
+	}
+	return node
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeSchema merges the keys of `src` into `dst`, the modules share the 'ingester' section with different structs
+func mergeSchema(dst, src *schemaNode) {
+	mergeSchemaOnce(dst, src, map[[2]*schemaNode]bool{})
+}
+
+func mergeSchemaOnce(dst, src *schemaNode, merged map[[2]*schemaNode]bool) {
+	// 递归定义的结构体只合并一次
+	if dst == src || merged[[2]*schemaNode{dst, src}] {
+		return
+	}
+	merged[[2]*schemaNode{dst, src}] = true
+	if src.fields != nil {
+		if dst.fields == nil {
+			dst.fields = map[string]*schemaNode{}
+		}
+		for key, child := range src.fields {
+			if exist, ok := dst.fields[key]; ok {
+				mergeSchemaOnce(exist, child, merged)
+			} else {
+				dst.fields[key] = child
+			}
+		}
+	}
+	if src.elem != nil {
+		if dst.elem == nil {
+			dst.elem = &schemaNode{}
+		}
+		mergeSchemaOnce(dst.elem, src.elem, merged)
+	}
+}
+
+func knownSchema() *schemaNode {
+	schemaLock.Lock()
+	defer schemaLock.Unlock()
+	root := &schemaNode{fields: map[string]*schemaNode{ConfigVersionKey: {}}}
+	for _, t := range schemaTypes {
+		// 每个结构体单独构建，避免共享的子结构被合并修改
+		mergeSchema(root, buildSchema(t, map[reflect.Type]*schemaNode{}))
+	}
+	return root
+}
+
+// checkUnknownKeys reports the keys which are not defined by the registered schemas. The top-level
+// keys unknown to the ingester belong to other modules (e.g. controller, querier) and are not checked.
+func checkUnknownKeys(root *yamlv3.Node) error {
+	schema := knownSchema()
+	var errs []error
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i].Value
+		if child, ok := schema.fields[key]; ok {
+			errs = append(errs, checkNode(root.Content[i+1], child, key)...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkNode(node *yamlv3.Node, schema *schemaNode, path string) []error {
+	if node.Kind == yamlv3.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	var errs []error
+	switch {
+	case node.Kind == yamlv3.MappingNode && schema.fields != nil:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == ExtraConfigKey || key == "<<" {
+				continue
+			}
+			child, ok := schema.fields[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("line %d: unknown key '%s.%s'", node.Content[i].Line, path, key))
+				continue
+			}
+			errs = append(errs, checkNode(node.Content[i+1], child, path+"."+key)...)
+		}
+	case node.Kind == yamlv3.SequenceNode && schema.elem != nil:
+		for i, item := range node.Content {
+			errs = append(errs, checkNode(item, schema.elem, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadUnknownKeys(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	path := writeTestConfig(t, `querier:
+  not-ingester-key: 1
+ingester:
+  storage-disabled: true
+  listen-prot: 30033
+  ckdb:
+    hots: 127.0.0.1
+`)
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("Expected unknown key errors")
+	}
+	for _, expect := range []string{"line 5: unknown key 'ingester.listen-prot'", "line 7: unknown key 'ingester.ckdb.hots'"} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("Expected error contains %s found %v", expect, err)
+		}
+	}
+	if strings.Contains(err.Error(), "not-ingester-key") {
+		t.Errorf("Expected keys of other modules not checked found %v", err)
+	}
+}
+
+func TestLoadExtraKeys(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	path := writeTestConfig(t, `extra:
+  new-feature: true
+ingester:
+  storage-disabled: true
+  ckdb:
+    extra:
+      new-ckdb-option: 1
+  extra:
+    new-ingester-option: [1, 2]
+`)
+	if _, err := Load(path); err != nil {
+		t.Errorf("Expected keys under 'extra' passed through found %v", err)
+	}
+}
+
+func TestLoadMigration(t *testing.T) {
+	t.Setenv(EnvRunningMode, RunningModeStandalone)
+	RegisterMigration(0, KeyRename{From: "ingester.old-listen-port", To: "ingester.listen-port"})
+	t.Cleanup(func() { delete(migrations, 0) })
+
+	path := writeTestConfig(t, "ingester:\n  storage-disabled: true\n  old-listen-port: 30033\n")
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config failed: %s", err)
+	}
+	if config.ListenPort != 30033 {
+		t.Errorf("Expected listen-port migrated to 30033 found %d", config.ListenPort)
+	}
+	configBytes, err := config.ReadConfigFile(path)
+	if err != nil || !strings.Contains(string(configBytes), "listen-port: 30033") || strings.Contains(string(configBytes), "old-listen-port") {
+		t.Errorf("Expected migrated config file found %s, %v", configBytes, err)
+	}
+
+	path = writeTestConfig(t, "ingester:\n  storage-disabled: true\n  old-listen-port: 30033\n  listen-port: 30034\n")
+	_, err = Load(path)
+	if err == nil || !strings.Contains(err.Error(), "both 'ingester.old-listen-port' and 'ingester.listen-port'") {
+		t.Errorf("Expected conflict error found %v", err)
+	}
+
+	// 当前版本的配置文件不再迁移
+	path = writeTestConfig(t, "config-version: 1\ningester:\n  storage-disabled: true\n  old-listen-port: 30033\n")
+	_, err = Load(path)
+	if err == nil || !strings.Contains(err.Error(), "line 4: unknown key 'ingester.old-listen-port'") {
+		t.Errorf("Expected unknown key error found %v", err)
+	}
+}
@@ -19,7 +19,6 @@ package config
 import (
 	"context"
 	"fmt"
-	"net"
 	"reflect"
 	"sort"
 	"strings"
@@ -106,13 +105,7 @@ func NewWatcher(cfg *Config, myNodeName, myPodName, myPodNamespace string) (*Wat
 		return nil, errMsg
 	}
 
-	controllers := make([]net.IP, len(cfg.ControllerIPs))
-	for i, ipString := range cfg.ControllerIPs {
-		controllers[i] = net.ParseIP(ipString)
-		if controllers[i].To4() != nil {
-			controllers[i] = controllers[i].To4()
-		}
-	}
+	controllers := cfg.ControllerNetIPs()
 	nodePodNamesWatch := NewServerInstranceInfo(controllers, int(cfg.ControllerPort), cfg.GrpcBufferSize)
 
 	watcher := &Watcher{
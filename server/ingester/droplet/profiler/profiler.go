@@ -45,6 +45,8 @@ const (
 	CONFIG_CMD_PROFILER_STATUS
 )
 
+const CONFIG_DUMP_PATH = "/v1/config"
+
 var log = logging.MustGetLogger("profile")
 
 func NewProfiler(port int) *ProfilerServer {
@@ -53,6 +55,19 @@ func NewProfiler(port int) *ProfilerServer {
 	return server
 }
 
+// RegisterConfigHandler serves the effective config on CONFIG_DUMP_PATH of the profiler http server
+func RegisterConfigHandler(dump func() ([]byte, error)) {
+	http.HandleFunc(CONFIG_DUMP_PATH, func(w http.ResponseWriter, r *http.Request) {
+		content, err := dump()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("dump config failed: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-yaml; charset=utf-8")
+		w.Write(content)
+	})
+}
+
 func (s *ProfilerServer) RecvCommand(conn *net.UDPConn, remote *net.UDPAddr, operate uint16, args *bytes.Buffer) {
 	switch operate {
 	case CONFIG_CMD_PROFILER_ON:
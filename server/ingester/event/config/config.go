@@ -59,6 +59,11 @@ type EventConfig struct {
 	Event Config `yaml:"ingester"`
 }
 
+// 注册配置项，加载配置文件时校验未知的配置项
+func init() {
+	config.RegisterSchema(EventConfig{})
+}
+
 func (c *Config) Validate() error {
 	if c.DecoderQueueCount == 0 {
 		c.DecoderQueueCount = DefaultDecoderQueueCount
@@ -118,7 +123,7 @@ func Load(base *config.Config, path string) *Config {
 		log.Info("no config file, use defaults")
 		return &config.Event
 	}
-	configBytes, err := os.ReadFile(path)
+	configBytes, err := base.ReadConfigFile(path)
 	if err != nil {
 		log.Warning("Read config file error:", err)
 		config.Event.Validate()
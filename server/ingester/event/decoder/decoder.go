@@ -81,13 +81,7 @@ func NewDecoder(
 	exporters *exporters.Exporters,
 	config *config.Config,
 ) *Decoder {
-	controllers := make([]net.IP, len(config.Base.ControllerIPs))
-	for i, ipString := range config.Base.ControllerIPs {
-		controllers[i] = net.ParseIP(ipString)
-		if controllers[i].To4() != nil {
-			controllers[i] = controllers[i].To4()
-		}
-	}
+	controllers := config.Base.ControllerNetIPs()
 	return &Decoder{
 		index:        index,
 		eventType:    eventType,
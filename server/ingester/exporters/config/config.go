@@ -582,6 +582,11 @@ type ExportersConfig struct {
 	Exporters Config `yaml:"ingester"`
 }
 
+// 注册配置项，加载配置文件时校验未知的配置项
+func init() {
+	config.RegisterSchema(ExportersConfig{})
+}
+
 type Config struct {
 	Base      *config.Config
 	Exporters []ExporterCfg `yaml:"exporters"`
@@ -741,7 +746,7 @@ func Load(base *config.Config, path string) *Config {
 		log.Info("no config file, use defaults")
 		return &config.Exporters
 	}
-	configBytes, err := os.ReadFile(path)
+	configBytes, err := base.ReadConfigFile(path)
 	if err != nil {
 		log.Warning("Read config file error:", err)
 		config.Exporters.Validate()
@@ -315,13 +315,7 @@ func NewUniversalTagsManager(k8sLabelConfig []string, baseCfg *config.Config) *U
 		}
 	}
 
-	controllers := make([]net.IP, len(baseCfg.ControllerIPs))
-	for i, ipString := range baseCfg.ControllerIPs {
-		controllers[i] = net.ParseIP(ipString)
-		if controllers[i].To4() != nil {
-			controllers[i] = controllers[i].To4()
-		}
-	}
+	controllers := baseCfg.ControllerNetIPs()
 	m.grpcSession.Init(controllers, baseCfg.ControllerPort, grpc.DEFAULT_SYNC_INTERVAL, baseCfg.GrpcBufferSize, runOnce)
 	debug.ServerRegisterSimple(ingesterctl.CMD_EXPORTER_PLATFORMDATA, m)
 
@@ -60,6 +60,11 @@ type FlowLogConfig struct {
 	FlowLog Config `yaml:"ingester"`
 }
 
+// 注册配置项，加载配置文件时校验未知的配置项
+func init() {
+	config.RegisterSchema(FlowLogConfig{})
+}
+
 func (c *Config) Validate() error {
 	// Begin validation.
 	if c.DecoderQueueCount == 0 {
@@ -102,7 +107,7 @@ func Load(base *config.Config, path string) *Config {
 		log.Info("no config file, use defaults")
 		return &config.FlowLog
 	}
-	configBytes, err := os.ReadFile(path)
+	configBytes, err := base.ReadConfigFile(path)
 	if err != nil {
 		log.Warning("Read config file error:", err)
 		config.FlowLog.Validate()
@@ -66,6 +66,11 @@ type FlowMetricsConfig struct {
 	FlowMetrics Config `yaml:"ingester"`
 }
 
+// 注册配置项，加载配置文件时校验未知的配置项
+func init() {
+	config.RegisterSchema(FlowMetricsConfig{})
+}
+
 func (c *Config) Validate() error {
 	if c.ReceiverWindowSize < 64 || c.ReceiverWindowSize > 64*1024 {
 		c.ReceiverWindowSize = DefaultReceiverWindowSize
@@ -106,7 +111,7 @@ func Load(base *config.Config, path string) *Config {
 		log.Info("no config file, use defaults")
 		return &config.FlowMetrics
 	}
-	configBytes, err := os.ReadFile(path)
+	configBytes, err := base.ReadConfigFile(path)
 	if err != nil {
 		log.Warningf("Read config file error:", err)
 		config.FlowMetrics.Validate()
@@ -19,7 +19,6 @@ package ingester
 import (
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"strconv"
 	"time"
@@ -42,6 +41,7 @@ import (
 	"github.com/deepflowio/deepflow/server/ingester/ckissu"
 	"github.com/deepflowio/deepflow/server/ingester/common"
 	"github.com/deepflowio/deepflow/server/ingester/config"
+	"github.com/deepflowio/deepflow/server/ingester/droplet/profiler"
 	eventcfg "github.com/deepflowio/deepflow/server/ingester/event/config"
 	"github.com/deepflowio/deepflow/server/ingester/event/event"
 	exporterscfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
@@ -67,17 +67,34 @@ const (
 )
 
 func Start(configPath string, shared *servercommon.ControllerIngesterShared) []io.Closer {
-	cfg := config.Load(configPath)
-	bytes, _ := yaml.Marshal(cfg)
+	baseCfg := config.MustLoadBase(configPath)
+	cfg := &baseCfg.Base
+	bytes, _ := cfg.Dump()
 
 	logger.EnableStdoutLog()
-	logger.EnableFileLog(cfg.LogFile)
+	if err := config.InitLogger(cfg); err != nil {
+		log.Errorf("init file log %s failed: %s", cfg.LogFile, err)
+	}
 	logLevel, _ := logging.LogLevel(cfg.LogLevel)
 	logging.SetLevel(logLevel, "")
 
 	log.Info("==================== Launching DeepFlow-Server-Ingester ====================")
 	log.Infof("ingester base config:\n%s", string(bytes))
 
+	// 收到SIGHUP时重新加载配置，仅热更新标记为可重载的配置项
+	configWatcher := config.NewConfigWatcher(configPath, baseCfg)
+	configWatcher.OnLogLevelChange(func(level string) {
+		logLevel, err := logging.LogLevel(level)
+		if err != nil {
+			log.Warningf("invalid log level %s: %s", level, err)
+			return
+		}
+		logging.SetLevel(logLevel, "")
+	})
+	configWatcher.Start()
+	cfg.StartControllerResolver()
+	profiler.RegisterConfigHandler(cfg.Dump)
+
 	pool.SetCounterRegisterCallback(func(counter *pool.Counter) {
 		tags := stats.OptionStatTags{
 			"name":                counter.Name,
@@ -91,12 +108,12 @@ func Start(configPath string, shared *servercommon.ControllerIngesterShared) []i
 	stats.RegisterGcMonitor()
 	stats.SetMinInterval(time.Duration(cfg.StatsInterval) * time.Second)
 	stats.SetRemoteType(stats.REMOTE_TYPE_DFSTATSD)
-	stats.SetDFRemote(net.JoinHostPort("127.0.0.1", strconv.Itoa(int(cfg.ListenPort))))
+	stats.SetDFRemote(cfg.StatsdAddress())
 
 	receiver := receiver.NewReceiver(int(cfg.ListenPort), cfg.UDPReadBuffer, cfg.TCPReadBuffer, cfg.TCPReaderBuffer)
 
 	ingesterOrgHandler := NewOrgHandler(cfg)
-	closers := []io.Closer{}
+	closers := []io.Closer{configWatcher}
 
 	if cfg.IngesterEnabled {
 		flowLogConfig := flowlogcfg.Load(cfg, configPath)
@@ -160,13 +177,7 @@ func Start(configPath string, shared *servercommon.ControllerIngesterShared) []i
 		}
 
 		// platformData manager init
-		controllers := make([]net.IP, len(cfg.ControllerIPs))
-		for i, ipString := range cfg.ControllerIPs {
-			controllers[i] = net.ParseIP(ipString)
-			if controllers[i].To4() != nil {
-				controllers[i] = controllers[i].To4()
-			}
-		}
+		controllers := cfg.ControllerNetIPs()
 		platformDataManager := grpc.NewPlatformDataManager(
 			controllers,
 			int(cfg.ControllerPort),
@@ -45,6 +45,11 @@ type PcapConfig struct {
 	Pcap Config `yaml:"ingester"`
 }
 
+// 注册配置项，加载配置文件时校验未知的配置项
+func init() {
+	config.RegisterSchema(PcapConfig{})
+}
+
 func (c *Config) Validate() error {
 	if c.PcapQueueCount <= 0 {
 		c.PcapQueueCount = DefaultPcapQueueCount
@@ -73,7 +78,7 @@ func Load(base *config.Config, path string) *Config {
 		log.Info("no config file, use defaults")
 		return &config.Pcap
 	}
-	configBytes, err := os.ReadFile(path)
+	configBytes, err := base.ReadConfigFile(path)
 	if err != nil {
 		log.Warning("Read config file error:", err)
 		config.Pcap.Validate()
@@ -40,6 +40,11 @@ type ProfileConfig struct {
 	Profile Config `yaml:"ingester"`
 }
 
+// 注册配置项，加载配置文件时校验未知的配置项
+func init() {
+	config.RegisterSchema(ProfileConfig{})
+}
+
 const (
 	DefaultProfileTTL                 = 72 // hour
 	DefaultDecoderQueueCount          = 2
@@ -85,7 +90,7 @@ func Load(base *config.Config, path string) *Config {
 		log.Info("no config file, use defaults")
 		return &config.Profile
 	}
-	configBytes, err := os.ReadFile(path)
+	configBytes, err := base.ReadConfigFile(path)
 	if err != nil {
 		log.Warning("Read config file error:", err)
 		config.Profile.Validate()
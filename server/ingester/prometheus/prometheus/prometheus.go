@@ -67,7 +67,7 @@ func NewPrometheusHandler(config *config.Config, recv *receiver.Receiver, platfo
 
 	recv.RegistHandler(msgType, decodeQueues, queueCount)
 
-	prometheusLabelTable := decoder.NewPrometheusLabelTable(config.Base.ControllerIPs, int(config.Base.ControllerPort), config.LabelMsgMaxSize, config.LabelCacheExpiration)
+	prometheusLabelTable := decoder.NewPrometheusLabelTable(config.Base.ResolvedControllerIPs(), int(config.Base.ControllerPort), config.LabelMsgMaxSize, config.LabelCacheExpiration)
 
 	prometheusLabelTable.RequestAllLabelIDs(0)
 	currentColumnIndexMax := prometheusLabelTable.GetMaxAppLabelColumnIndex()
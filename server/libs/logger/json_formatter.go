@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"encoding/json"
+	"io"
+
+	logging "github.com/op/go-logging"
+)
+
+const JSON_TIME_FORMAT = "2006-01-02T15:04:05.000Z07:00"
+
+type jsonRecord struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Module    string `json:"module"`
+	Message   string `json:"message"`
+}
+
+// JSONFormatter 每条日志输出为一行json，便于日志采集系统解析
+type JSONFormatter struct{}
+
+func (f JSONFormatter) Format(calldepth int, r *logging.Record, w io.Writer) error {
+	content, err := json.Marshal(jsonRecord{
+		Timestamp: r.Time.Format(JSON_TIME_FORMAT),
+		Level:     r.Level.String(),
+		Module:    r.Module,
+		Message:   r.Message(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
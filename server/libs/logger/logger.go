@@ -90,7 +90,8 @@ func compressFile(filename string) error {
 	return nil
 }
 
-func EnableFileLogWithMaxAge(logPath string, maxAge time.Duration) error {
+// EnableFileLogWithFormatter 按天切分文件日志，formatter为nil时使用LOG_FORMAT
+func EnableFileLogWithFormatter(logPath string, maxAge time.Duration, formatter logging.Formatter) error {
 	dir := path.Dir(logPath)
 	if _, err := os.Stat(dir); err != nil {
 		if os.IsNotExist(err) {
@@ -120,18 +121,27 @@ func EnableFileLogWithMaxAge(logPath string, maxAge time.Duration) error {
 		return err
 	}
 
-	fileBackend = logging.NewBackendFormatter(
-		logging.NewLogBackend(ioWriter, "", 0),
-		logging.MustStringFormatter(LOG_FORMAT),
-	)
-	applyBackendChange()
+	EnableFileLogWriter(ioWriter, formatter)
 	return nil
 }
 
+func EnableFileLogWithMaxAge(logPath string, maxAge time.Duration) error {
+	return EnableFileLogWithFormatter(logPath, maxAge, nil)
+}
+
 func EnableFileLog(logPath string) error {
 	return EnableFileLogWithMaxAge(logPath, LOG_MAX_AGE)
 }
 
+// EnableFileLogWriter 文件日志输出到指定的writer，如SizeRotatingWriter，formatter为nil时使用LOG_FORMAT
+func EnableFileLogWriter(writer io.Writer, formatter logging.Formatter) {
+	if formatter == nil {
+		formatter = logging.MustStringFormatter(LOG_FORMAT)
+	}
+	fileBackend = logging.NewBackendFormatter(logging.NewLogBackend(writer, "", 0), formatter)
+	applyBackendChange()
+}
+
 func EnableCustomBackends(backends ...ClosableBackend) {
 	for _, b := range customBackends {
 		b.Close()
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const compressSuffix = ".gz"
+
+// SizeRotatingWriter 按大小切分的日志文件，写入后超过maxSize时切分，
+// 切分后的文件为<path>.1, <path>.2 ...，序号越大越旧，compress时为<path>.1.gz
+type SizeRotatingWriter struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int           // 保留的切分文件数，为0时不保留
+	maxAge     time.Duration // 切分文件的最长保留时间，为0时不限制
+	compress   bool
+
+	file *os.File
+	size int64
+}
+
+func NewSizeRotatingWriter(logPath string, maxSize int64, maxBackups int, maxAge time.Duration, compress bool) (*SizeRotatingWriter, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid max size %d of log file %s", maxSize, logPath)
+	}
+	if err := os.MkdirAll(path.Dir(logPath), 0755); err != nil {
+		return nil, err
+	}
+	w := &SizeRotatingWriter{
+		path:       logPath,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+		compress:   compress,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *SizeRotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	return nil
+}
+
+func (w *SizeRotatingWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *SizeRotatingWriter) backupName(index int) string {
+	return fmt.Sprintf("%s.%d", w.path, index)
+}
+
+func (w *SizeRotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	// 最旧的文件超出保留数量，其余依次后移
+	for _, suffix := range []string{"", compressSuffix} {
+		os.Remove(w.backupName(w.maxBackups) + suffix)
+		for i := w.maxBackups - 1; i >= 1; i-- {
+			os.Rename(w.backupName(i)+suffix, w.backupName(i+1)+suffix)
+		}
+	}
+	if w.maxBackups > 0 {
+		if err := os.Rename(w.path, w.backupName(1)); err != nil {
+			return err
+		}
+		if w.compress {
+			if err := compressFile(w.backupName(1)); err != nil {
+				os.Remove(w.backupName(1) + compressSuffix)
+			}
+		}
+	} else {
+		os.Remove(w.path)
+	}
+	w.removeExpired()
+	return w.open()
+}
+
+func (w *SizeRotatingWriter) removeExpired() {
+	if w.maxAge <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-w.maxAge)
+	for i := 1; i <= w.maxBackups; i++ {
+		for _, suffix := range []string{"", compressSuffix} {
+			name := w.backupName(i) + suffix
+			if info, err := os.Stat(name); err == nil && info.ModTime().Before(deadline) {
+				os.Remove(name)
+			}
+		}
+	}
+}
+
+func (w *SizeRotatingWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+func TestSizeRotatingWriter(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "server.log")
+	w, err := NewSizeRotatingWriter(logPath, 64, 2, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	line := []byte(strings.Repeat("x", 39) + "\n")
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 每个文件只能容纳一行，5行切分4次，只保留2个切分文件
+	for _, name := range []string{logPath, logPath + ".1", logPath + ".2"} {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("expect %s exists: %s", name, err)
+		}
+		if !bytes.Equal(content, line) {
+			t.Errorf("%s: get %q, want %q", name, content, line)
+		}
+	}
+	if _, err := os.Stat(logPath + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expect %s.3 removed, found %v", logPath, err)
+	}
+}
+
+func TestSizeRotatingWriterCompress(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "server.log")
+	w, err := NewSizeRotatingWriter(logPath, 16, 1, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(logPath + ".1.gz"); err != nil {
+		t.Errorf("expect compressed backup: %s", err)
+	}
+	if _, err := os.Stat(logPath + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expect uncompressed backup removed, found %v", err)
+	}
+}
+
+func TestJSONFormatter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	backend := logging.NewBackendFormatter(logging.NewLogBackend(buf, "", 0), JSONFormatter{})
+	logger := logging.MustGetLogger("json_test")
+	logger.SetBackend(logging.AddModuleLevel(backend))
+	logger.Warningf("disk %s is %d%% used", "path_0", 90)
+
+	record := map[string]string{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid json %q: %s", buf.String(), err)
+	}
+	if record["level"] != "WARNING" || record["module"] != "json_test" || record["message"] != "disk path_0 is 90% used" || record["timestamp"] == "" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
@@ -27,6 +27,7 @@ const (
 	SERVER_ERROR                    = "SERVER_ERROR"
 	RESOURCE_NUM_EXCEEDED           = "RESOURCE_NUM_EXCEEDED"
 	SELECTED_RESOURCES_NUM_EXCEEDED = "SELECTED_RESOURCES_NUM_EXCEEDED"
+	TOO_MANY_QUERIES                = "TOO_MANY_QUERIES"
 )
 
 const (
@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 查询结果的输出格式，默认为json
+const (
+	RESULT_FORMAT_JSON    = "json"
+	RESULT_FORMAT_COLUMNS = "columns"
+	RESULT_FORMAT_CSV     = "csv"
+	RESULT_FORMAT_TSV     = "tsv"
+)
+
+// ResultEncoder 将查询结果按行写入w，不在内存中另外构造完整的输出
+type ResultEncoder interface {
+	ContentType() string
+	Encode(w io.Writer, r *Result) error
+}
+
+var (
+	resultEncodersLock sync.RWMutex
+	resultEncoders     = map[string]ResultEncoder{
+		RESULT_FORMAT_COLUMNS: ColumnsEncoder{},
+		RESULT_FORMAT_CSV:     CSVEncoder{},
+		RESULT_FORMAT_TSV:     TSVEncoder{},
+	}
+)
+
+// RegisterResultEncoder 注册新的输出格式，已存在时覆盖
+func RegisterResultEncoder(format string, encoder ResultEncoder) {
+	resultEncodersLock.Lock()
+	resultEncoders[format] = encoder
+	resultEncodersLock.Unlock()
+}
+
+// GetResultEncoder json格式使用Result.ToJson，不在此注册
+func GetResultEncoder(format string) (ResultEncoder, bool) {
+	resultEncodersLock.RLock()
+	defer resultEncodersLock.RUnlock()
+	encoder, ok := resultEncoders[strings.ToLower(format)]
+	return encoder, ok
+}
+
+// ColumnsEncoder 按列输出，header中为列名及列的类型、单位，data中每一项为一列的全部取值，
+// 宽表的时序数据中列名不再随每行重复
+type ColumnsEncoder struct{}
+
+func (ColumnsEncoder) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (ColumnsEncoder) Encode(w io.Writer, r *Result) error {
+	bw := bufio.NewWriter(w)
+	header := map[string]interface{}{
+		"columns": r.Columns,
+		"types":   r.columnAttributes(func(s *ColumnSchema) string { return s.ValueType }),
+		"units":   r.columnAttributes(func(s *ColumnSchema) string { return s.Unit }),
+	}
+	if len(r.Meta) > 0 {
+		header["meta"] = r.Meta
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	bw.WriteString(`{"header":`)
+	bw.Write(headerBytes)
+	bw.WriteString(`,"data":[`)
+	for i := range r.Columns {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteByte('[')
+		for j, row := range r.Values {
+			if j > 0 {
+				bw.WriteByte(',')
+			}
+			value, err := json.Marshal(rowValue(row, i))
+			if err != nil {
+				return err
+			}
+			bw.Write(value)
+		}
+		bw.WriteByte(']')
+	}
+	bw.WriteString("]}\n")
+	return bw.Flush()
+}
+
+// CSVEncoder 首行为表头，每列为"列名:类型:单位"，null输出为空，数组等复合类型输出为json，
+// Comma为0时使用逗号分隔
+type CSVEncoder struct {
+	Comma rune
+}
+
+func (CSVEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (e CSVEncoder) Encode(w io.Writer, r *Result) error {
+	cw := csv.NewWriter(w)
+	if e.Comma != 0 {
+		cw.Comma = e.Comma
+	}
+	record := r.columnHeaders()
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for _, row := range r.Values {
+		for i := range r.Columns {
+			value, err := formatValue(rowValue(row, i))
+			if err != nil {
+				return err
+			}
+			record[i] = value
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// TSVEncoder 与ClickHouse的TabSeparated格式相同，转义\t、\n及\，不使用引号
+type TSVEncoder struct{}
+
+func (TSVEncoder) ContentType() string {
+	return "text/tab-separated-values; charset=utf-8"
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (TSVEncoder) Encode(w io.Writer, r *Result) error {
+	bw := bufio.NewWriter(w)
+	writeRecord := func(values []string) {
+		for i, value := range values {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			tsvEscaper.WriteString(bw, value)
+		}
+		bw.WriteByte('\n')
+	}
+	writeRecord(r.columnHeaders())
+	record := make([]string, len(r.Columns))
+	for _, row := range r.Values {
+		for i := range r.Columns {
+			value, err := formatValue(rowValue(row, i))
+			if err != nil {
+				return err
+			}
+			record[i] = value
+		}
+		writeRecord(record)
+	}
+	return bw.Flush()
+}
+
+// columnAttributes 按列顺序返回schema中的属性，无schema的列为空
+func (r *Result) columnAttributes(attribute func(*ColumnSchema) string) []string {
+	attributes := make([]string, len(r.Columns))
+	for i := range r.Columns {
+		if i < len(r.Schemas) && r.Schemas[i] != nil {
+			attributes[i] = attribute(r.Schemas[i])
+		}
+	}
+	return attributes
+}
+
+func (r *Result) columnHeaders() []string {
+	types := r.columnAttributes(func(s *ColumnSchema) string { return s.ValueType })
+	units := r.columnAttributes(func(s *ColumnSchema) string { return s.Unit })
+	headers := make([]string, len(r.Columns))
+	for i, column := range r.Columns {
+		headers[i] = fmt.Sprintf("%v:%s:%s", column, types[i], units[i])
+	}
+	return headers
+}
+
+// rowValue 返回一行中第i列的值，行可能为[]interface{}或其他类型的slice
+func rowValue(row interface{}, i int) interface{} {
+	if values, ok := row.([]interface{}); ok {
+		if i < len(values) {
+			return values[i]
+		}
+		return nil
+	}
+	v := reflect.ValueOf(row)
+	if v.Kind() != reflect.Slice || i >= v.Len() {
+		return nil
+	}
+	return v.Index(i).Interface()
+}
+
+func formatValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return "", nil
+		}
+		return formatValue(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array, reflect.Map:
+		bytes, err := json.Marshal(value)
+		return string(bytes), err
+	}
+	return fmt.Sprint(value), nil
+}
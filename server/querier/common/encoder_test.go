@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func encoderFixture() *Result {
+	return &Result{
+		Columns: []interface{}{"time", "pod", "TopK(pod_ns)", "sum_byte", "avg_rtt"},
+		Values: []interface{}{
+			[]interface{}{1700000000, "服务-a", []string{"default", "kube-system"}, 1024, 1.5},
+			[]interface{}{1700000060, nil, []interface{}{}, nil, nil},
+			[]interface{}{1700000120, "tab\tname, \"q\"", []string{"名称"}, 0, 0.25},
+		},
+		Schemas: ColumnSchemas{
+			{Name: "time", ValueType: "UInt32"},
+			{Name: "pod", ValueType: "String"},
+			{Name: "TopK(pod_ns)", ValueType: "Array(String)"},
+			{Name: "sum_byte", ValueType: "UInt64", Unit: "byte"},
+			{Name: "avg_rtt", ValueType: "Float64", Unit: "us"},
+		},
+	}
+}
+
+func TestResultEncoders(t *testing.T) {
+	for _, tc := range []struct {
+		format      string
+		contentType string
+		output      string
+	}{{
+		format:      RESULT_FORMAT_COLUMNS,
+		contentType: "application/json; charset=utf-8",
+		output: `{"header":{"columns":["time","pod","TopK(pod_ns)","sum_byte","avg_rtt"],"types":["UInt32","String","Array(String)","UInt64","Float64"],"units":["","","","byte","us"]},` +
+			`"data":[[1700000000,1700000060,1700000120],["服务-a",null,"tab\tname, \"q\""],[["default","kube-system"],[],["名称"]],[1024,null,0],[1.5,null,0.25]]}` + "\n",
+	}, {
+		format:      RESULT_FORMAT_CSV,
+		contentType: "text/csv; charset=utf-8",
+		output: "time:UInt32:,pod:String:,TopK(pod_ns):Array(String):,sum_byte:UInt64:byte,avg_rtt:Float64:us\n" +
+			"1700000000,服务-a,\"[\"\"default\"\",\"\"kube-system\"\"]\",1024,1.5\n" +
+			"1700000060,,[],,\n" +
+			"1700000120,\"tab\tname, \"\"q\"\"\",\"[\"\"名称\"\"]\",0,0.25\n",
+	}, {
+		format:      RESULT_FORMAT_TSV,
+		contentType: "text/tab-separated-values; charset=utf-8",
+		output: "time:UInt32:\tpod:String:\tTopK(pod_ns):Array(String):\tsum_byte:UInt64:byte\tavg_rtt:Float64:us\n" +
+			"1700000000\t服务-a\t[\"default\",\"kube-system\"]\t1024\t1.5\n" +
+			"1700000060\t\t[]\t\t\n" +
+			"1700000120\ttab\\tname, \"q\"\t[\"名称\"]\t0\t0.25\n",
+	}} {
+		encoder, ok := GetResultEncoder(tc.format)
+		if !ok {
+			t.Errorf("%s: encoder not found", tc.format)
+			continue
+		}
+		if encoder.ContentType() != tc.contentType {
+			t.Errorf("%s: get content type %s, want %s", tc.format, encoder.ContentType(), tc.contentType)
+		}
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, encoderFixture()); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.format, err)
+			continue
+		}
+		if buf.String() != tc.output {
+			t.Errorf("%s:\nget:  %q\nwant: %q", tc.format, buf.String(), tc.output)
+		}
+	}
+
+	if _, ok := GetResultEncoder("xml"); ok {
+		t.Error("unknown format xml is found")
+	}
+}
+
+func TestColumnsEncoderValidJson(t *testing.T) {
+	// 没有schema及空结果
+	for _, result := range []*Result{
+		{Columns: []interface{}{"a", "b"}, Values: []interface{}{[]string{"x", "y"}}},
+		{Columns: []interface{}{"a"}},
+		{},
+	} {
+		var buf bytes.Buffer
+		if err := (ColumnsEncoder{}).Encode(&buf, result); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("invalid json: %s", buf.String())
+		}
+	}
+}
@@ -23,18 +23,29 @@ import (
 )
 
 type QuerierParams struct {
-	Debug         string
-	UseQueryCache bool
-	QueryCacheTTL string
-	QueryUUID     string
-	DB            string
-	Sql           string
-	DataSource    string
-	Context       context.Context
-	NoPreWhere    bool
-	ORGID         string
-	SimpleSql     bool
-	Language      string
+	Debug             string
+	UseQueryCache     bool
+	QueryCacheTTL     string
+	QueryUUID         string
+	DB                string
+	Sql               string
+	DataSource        string
+	Context           context.Context
+	NoPreWhere        bool
+	PreWhere          bool
+	NoDivZeroGuard    bool
+	AlignTimeRange    bool
+	TimestampMilli    bool
+	DefaultGroupOrder bool
+	TimeFrom          int64 // $__from，秒
+	TimeTo            int64 // $__to，秒
+	Interval          int   // $__interval，秒
+	MaxPoints         int   // time()的最大时间点数，为0时使用配置的max-points
+	MaxRows           int   // 流式查询最多返回的行数，为0时使用默认上限，小于0时不限制
+	ORGID             string
+	SimpleSql         bool
+	Language          string
+	Comment           string // 生成的sql前的注释，如dashboard_id=123，用于追溯慢查询来源
 }
 
 type TempoParams struct {
@@ -29,14 +29,19 @@ type Result struct {
 	Columns []interface{}
 	Values  []interface{}
 	Schemas ColumnSchemas
+	Meta    map[string]interface{} // 查询的附加信息，如对齐前后的时间范围
 }
 
 func (r *Result) ToJson() map[string]interface{} {
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"columns": r.Columns,
 		"values":  r.Values,
 		"schemas": r.Schemas.ToArray(),
 	}
+	if len(r.Meta) > 0 {
+		result["meta"] = r.Meta
+	}
+	return result
 }
 
 type ColumnSchema struct {
@@ -55,12 +55,47 @@ type QuerierConfig struct {
 	Language                        string                        `default:"en" yaml:"language"`
 	OtelEndpoint                    string                        `default:"http://deepflow-agent/api/v1/otel/trace" yaml:"otel-endpoint"`
 	Limit                           string                        `default:"10000" yaml:"limit"`
+	DefaultLimits                   map[string]string             `yaml:"default-limits"`
+	MaxOffset                       int                           `default:"0" yaml:"max-offset"`
+	MaxPoints                       int                           `default:"0" yaml:"max-points"`
+	PreWhere                        bool                          `default:"false" yaml:"prewhere"`
+	AllowRawExpr                    bool                          `default:"false" yaml:"allow-raw-expr"`
+	ModelCacheSize                  int                           `default:"0" yaml:"model-cache-size"`
+	TagDictCacheTTL                 int                           `default:"0" yaml:"tag-dict-cache-ttl"`
+	TagDictCacheMaxEntries          int                           `default:"1000" yaml:"tag-dict-cache-max-entries"`
+	TagDictCacheLoadTimeout         int                           `default:"5" yaml:"tag-dict-cache-load-timeout"`
+	DatasourceCacheTTL              int                           `default:"60" yaml:"datasource-cache-ttl"`
 	TimeFillLimit                   int                           `default:"20" yaml:"time-fill-limit"`
 	PrometheusCacheUpdateInterval   int                           `default:"60" yaml:"prometheus-cache-update-interval"`
 	MaxCacheableEntrySize           int                           `default:"1000" yaml:"max-cacheable-entry-size"`
 	MaxPrometheusIdSubqueryLruEntry int                           `default:"8000" yaml:"max-prometheus-id-subquery-lru-entry"`
 	PrometheusIdSubqueryLruTimeout  int                           `default:"60" yaml:"prometheus-id-subquery-lru-timeout"`
 	AutoCustomTags                  []AutoCustomTags              `yaml:"auto-custom-tags" binding:"omitempty,dive"`
+	DefaultSettings                 map[string]map[string]string  `yaml:"default-settings"`
+	AllowedSettings                 []string                      `default:"[\"max_execution_time\",\"max_memory_usage\",\"max_threads\",\"max_rows_to_read\",\"max_bytes_to_read\",\"max_result_rows\",\"max_result_bytes\"]" yaml:"allowed-settings"`
+	SlowQuery                       SlowQuery                     `yaml:"slow-query"`
+	Admission                       Admission                     `yaml:"admission"`
+}
+
+type SlowQuery struct {
+	Threshold  int    `default:"0" yaml:"threshold"`
+	LogFile    string `default:"/var/log/deepflow/querier-slow-query.log" yaml:"log-file"`
+	MaxSizeMB  int    `default:"100" yaml:"max-size-mb"`
+	MaxBackups int    `default:"3" yaml:"max-backups"`
+	BufferSize int    `default:"100" yaml:"buffer-size"`
+	Redact     bool   `default:"true" yaml:"redact"`
+}
+
+type Admission struct {
+	MaxConcurrentQueries int                       `default:"0" yaml:"max-concurrent-queries"`
+	MaxQueuedQueries     int                       `default:"0" yaml:"max-queued-queries"`
+	QueueTimeout         int                       `default:"10" yaml:"queue-timeout"`
+	Overrides            map[string]AdmissionLimit `yaml:"overrides"`
+}
+
+type AdmissionLimit struct {
+	MaxConcurrentQueries int `yaml:"max-concurrent-queries"`
+	MaxQueuedQueries     int `yaml:"max-queued-queries"`
 }
 
 type DeepflowApp struct {
@@ -86,11 +121,19 @@ type Clickhouse struct {
 	Host           string `default:"clickhouse" yaml:"host"`
 	Port           int    `default:"9000" yaml:"port"`
 	Timeout        int    `default:"60" yaml:"timeout"`
+	QueryTimeout   int    `default:"300" yaml:"query-timeout"`
 	ConnectTimeout int    `default:"2" yaml:"connect-timeout"`
 	MaxConnection  int    `default:"20" yaml:"max-connection"`
 	UseQueryCache  bool   `default:"true" yaml:"use-query-cache"`
 	QueryCacheTTL  string `default:"600" yaml:"query-cache-ttl"`
 	Version        string `default:"" yaml:"-"`
+	// 多个ClickHouse地址（host:port），为空时使用host及port
+	Endpoints             []string `yaml:"endpoints"`
+	EndpointPolicy        string   `default:"round-robin" yaml:"endpoint-policy"`
+	EndpointMaxFailures   int      `default:"3" yaml:"endpoint-max-failures"`
+	EndpointProbeInterval int      `default:"10" yaml:"endpoint-probe-interval"`
+	// Distributed表，格式为db.table、db.*或*，查询这些表时IN子查询输出为GLOBAL IN
+	ClusterTables []string `yaml:"cluster-tables"`
 }
 
 type AutoCustomTags struct {
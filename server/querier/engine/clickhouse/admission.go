@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/config"
+)
+
+var (
+	admissionOnce sync.Once
+	admissionIns  *AdmissionController
+)
+
+// AdmissionLimit 同时执行的查询数及排队等待的查询数上限，MaxConcurrent为0时不限制
+type AdmissionLimit struct {
+	MaxConcurrent int
+	MaxQueued     int
+}
+
+// AdmissionKeyFunc 按查询所属的租户及表返回准入的key，key相同的查询共用一组并发数及等待队列
+type AdmissionKeyFunc func(orgID, db, table string) string
+
+// AdmissionController 查询准入控制，并发数达到上限时按先后顺序排队，
+// 队列已满或等待超时的查询返回TOO_MANY_QUERIES错误
+type AdmissionController struct {
+	limit     AdmissionLimit
+	overrides map[string]AdmissionLimit
+	timeout   time.Duration
+	KeyFunc   AdmissionKeyFunc
+
+	lock  sync.Mutex
+	slots map[string]*admissionSlot
+}
+
+type admissionSlot struct {
+	limit   AdmissionLimit
+	running int
+	waiters *list.List // *admissionWaiter
+}
+
+type admissionWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func NewAdmissionController(limit AdmissionLimit, overrides map[string]AdmissionLimit, timeout time.Duration) *AdmissionController {
+	c := &AdmissionController{
+		limit:     limit,
+		overrides: overrides,
+		timeout:   timeout,
+		slots:     map[string]*admissionSlot{},
+	}
+	c.KeyFunc = c.defaultKey
+	return c
+}
+
+// GetAdmissionController admission.max-concurrent-queries为0且没有单独限制时不做准入控制，返回nil
+func GetAdmissionController() *AdmissionController {
+	if config.Cfg == nil {
+		return nil
+	}
+	cfg := config.Cfg.Admission
+	if cfg.MaxConcurrentQueries <= 0 && len(cfg.Overrides) == 0 {
+		return nil
+	}
+	admissionOnce.Do(func() {
+		overrides := make(map[string]AdmissionLimit, len(cfg.Overrides))
+		for key, limit := range cfg.Overrides {
+			overrides[key] = AdmissionLimit{MaxConcurrent: limit.MaxConcurrentQueries, MaxQueued: limit.MaxQueuedQueries}
+		}
+		admissionIns = NewAdmissionController(
+			AdmissionLimit{MaxConcurrent: cfg.MaxConcurrentQueries, MaxQueued: cfg.MaxQueuedQueries},
+			overrides, time.Duration(cfg.QueueTimeout)*time.Second,
+		)
+	})
+	return admissionIns
+}
+
+// defaultKey 依次匹配<org_id>/<db>.<table>、<db>.<table>及<org_id>的单独限制，都未配置时使用全局的限制
+func (c *AdmissionController) defaultKey(orgID, db, table string) string {
+	for _, key := range []string{fmt.Sprintf("%s/%s.%s", orgID, db, table), fmt.Sprintf("%s.%s", db, table), orgID} {
+		if _, ok := c.overrides[key]; ok {
+			return key
+		}
+	}
+	return ""
+}
+
+// Acquire 获取执行查询的许可，查询结束后须调用返回的release；ctx取消或排队超时时放弃等待
+func (c *AdmissionController) Acquire(ctx context.Context, orgID, db, table string) (func(), error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	key := c.KeyFunc(orgID, db, table)
+	c.lock.Lock()
+	slot, ok := c.slots[key]
+	if !ok {
+		limit, ok := c.overrides[key]
+		if !ok {
+			limit = c.limit
+		}
+		slot = &admissionSlot{limit: limit, waiters: list.New()}
+		c.slots[key] = slot
+	}
+	if slot.limit.MaxConcurrent <= 0 || slot.running < slot.limit.MaxConcurrent {
+		slot.running++
+		c.lock.Unlock()
+		return c.releaseFunc(slot), nil
+	}
+	if slot.waiters.Len() >= slot.limit.MaxQueued {
+		c.lock.Unlock()
+		return nil, newTooManyQueriesError(key, fmt.Sprintf("%d queries running and %d queued", slot.running, slot.waiters.Len()))
+	}
+	waiter := &admissionWaiter{ready: make(chan struct{})}
+	element := slot.waiters.PushBack(waiter)
+	c.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var err error
+	select {
+	case <-waiter.ready:
+		return c.releaseFunc(slot), nil
+	case <-timeout:
+		err = newTooManyQueriesError(key, fmt.Sprintf("waited in queue for more than %s", c.timeout))
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	c.lock.Lock()
+	if waiter.granted {
+		// 放弃等待的同时被唤醒，将许可交给下一个排队的查询
+		c.releaseLocked(slot)
+	} else {
+		slot.waiters.Remove(element)
+	}
+	c.lock.Unlock()
+	return nil, err
+}
+
+func (c *AdmissionController) releaseFunc(slot *admissionSlot) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.lock.Lock()
+			c.releaseLocked(slot)
+			c.lock.Unlock()
+		})
+	}
+}
+
+// releaseLocked 有排队的查询时直接将许可交给最早的一个，否则减少执行数
+func (c *AdmissionController) releaseLocked(slot *admissionSlot) {
+	if front := slot.waiters.Front(); front != nil {
+		waiter := slot.waiters.Remove(front).(*admissionWaiter)
+		waiter.granted = true
+		close(waiter.ready)
+		return
+	}
+	slot.running--
+}
+
+func newTooManyQueriesError(key, reason string) error {
+	if key == "" {
+		key = "global"
+	}
+	return common.NewError(common.TOO_MANY_QUERIES, fmt.Sprintf("too many queries for %s: %s", key, reason))
+}
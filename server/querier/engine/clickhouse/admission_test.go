@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+)
+
+// mockExecutor 模拟执行中的查询，获取许可后阻塞到finish被调用
+type mockExecutor struct {
+	lock  sync.Mutex
+	order []string
+}
+
+func (m *mockExecutor) run(c *AdmissionController, ctx context.Context, name, table string) (finish func(), err error) {
+	release, err := c.Acquire(ctx, "1", "flow_log", table)
+	if err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	m.order = append(m.order, name)
+	m.lock.Unlock()
+	return release, nil
+}
+
+// waitQueued 等待key的队列长度达到n
+func waitQueued(t *testing.T, c *AdmissionController, key string, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		slot, ok := c.slots[key]
+		queued := 0
+		if ok {
+			queued = slot.waiters.Len()
+		}
+		c.lock.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("queue %s: want %d waiters", key, n)
+}
+
+func isTooManyQueries(err error) bool {
+	var serviceErr *common.ServiceError
+	return errors.As(err, &serviceErr) && serviceErr.Status == common.TOO_MANY_QUERIES
+}
+
+func TestAdmissionQueueOrder(t *testing.T) {
+	c := NewAdmissionController(AdmissionLimit{MaxConcurrent: 1, MaxQueued: 3}, nil, time.Second)
+	m := &mockExecutor{}
+	finish, err := m.run(c, context.Background(), "q0", "l4_flow_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 排队的查询按进入队列的顺序执行
+	var wg sync.WaitGroup
+	for i, name := range []string{"q1", "q2", "q3"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			finish, err := m.run(c, context.Background(), name, "l4_flow_log")
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				return
+			}
+			finish()
+		}(name)
+		waitQueued(t, c, "", i+1)
+	}
+	// 队列已满时直接返回
+	if _, err := m.run(c, context.Background(), "q4", "l4_flow_log"); !isTooManyQueries(err) {
+		t.Errorf("queue full: get %v, want TOO_MANY_QUERIES", err)
+	}
+	finish()
+	wg.Wait()
+	if want := []string{"q0", "q1", "q2", "q3"}; !reflect.DeepEqual(m.order, want) {
+		t.Errorf("order: get %v, want %v", m.order, want)
+	}
+	if running := c.slots[""].running; running != 0 {
+		t.Errorf("running: get %d, want 0", running)
+	}
+}
+
+func TestAdmissionTimeout(t *testing.T) {
+	c := NewAdmissionController(AdmissionLimit{MaxConcurrent: 1, MaxQueued: 1}, nil, 20*time.Millisecond)
+	m := &mockExecutor{}
+	finish, err := m.run(c, context.Background(), "q0", "l4_flow_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer finish()
+	start := time.Now()
+	if _, err := m.run(c, context.Background(), "q1", "l4_flow_log"); !isTooManyQueries(err) {
+		t.Errorf("timeout: get %v, want TOO_MANY_QUERIES", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("returned after %s, want queue timeout 20ms", elapsed)
+	}
+	// 超时的查询移出队列
+	waitQueued(t, c, "", 0)
+}
+
+func TestAdmissionReleaseOnCancel(t *testing.T) {
+	c := NewAdmissionController(AdmissionLimit{MaxConcurrent: 1, MaxQueued: 1}, nil, time.Minute)
+	m := &mockExecutor{}
+	finish, err := m.run(c, context.Background(), "q0", "l4_flow_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := m.run(c, ctx, "q1", "l4_flow_log")
+		done <- err
+	}()
+	waitQueued(t, c, "", 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancel: get %v, want context.Canceled", err)
+	}
+	waitQueued(t, c, "", 0)
+	// 取消的查询不占用许可，释放后新的查询直接执行
+	finish()
+	finish()
+	finish, err = m.run(c, context.Background(), "q2", "l4_flow_log")
+	if err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+	finish()
+	if want := []string{"q0", "q2"}; !reflect.DeepEqual(m.order, want) {
+		t.Errorf("order: get %v, want %v", m.order, want)
+	}
+	if running := c.slots[""].running; running != 0 {
+		t.Errorf("running: get %d, want 0", running)
+	}
+}
+
+func TestAdmissionOverrides(t *testing.T) {
+	c := NewAdmissionController(
+		AdmissionLimit{MaxConcurrent: 1, MaxQueued: 0},
+		map[string]AdmissionLimit{"flow_log.l7_flow_log": {MaxConcurrent: 2, MaxQueued: 0}},
+		time.Second,
+	)
+	m := &mockExecutor{}
+	finish, err := m.run(c, context.Background(), "l4", "l4_flow_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer finish()
+	// 单独限制的表不占用全局的并发数
+	for _, name := range []string{"l7_a", "l7_b"} {
+		finish, err := m.run(c, context.Background(), name, "l7_flow_log")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer finish()
+	}
+	if _, err := m.run(c, context.Background(), "l7_c", "l7_flow_log"); !isTooManyQueries(err) {
+		t.Errorf("override limit: get %v, want TOO_MANY_QUERIES", err)
+	}
+	if _, err := m.run(c, context.Background(), "l4_b", "l4_flow_log"); !isTooManyQueries(err) {
+		t.Errorf("global limit: get %v, want TOO_MANY_QUERIES", err)
+	}
+
+	// 自定义key，按租户限制
+	c = NewAdmissionController(AdmissionLimit{MaxConcurrent: 1}, nil, time.Second)
+	c.KeyFunc = func(orgID, db, table string) string { return orgID }
+	finish, err = c.Acquire(context.Background(), "1", "flow_log", "l4_flow_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer finish()
+	if _, err := c.Acquire(context.Background(), "2", "flow_log", "l4_flow_log"); err != nil {
+		t.Errorf("other org: %v", err)
+	}
+
+	var nilController *AdmissionController
+	if release, err := nilController.Acquire(context.Background(), "1", "flow_log", "l4_flow_log"); err != nil || release == nil {
+		t.Errorf("nil controller should admit all queries")
+	}
+}
@@ -0,0 +1,405 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+	"github.com/deepflowio/deepflow/server/querier/parse"
+)
+
+/*
+QueryBuilder 以链式调用构造查询语句，不经过sql文本，例：
+
+	NewQuery("l4_flow_log").
+		Select(Col("region_0")).
+		SelectAs(Fn("Sum", Col("byte")), "sum_byte").
+		Where(Cmp(Col("protocol"), "=", Int(6))).
+		GroupBy(Col("region_0")).
+		OrderBy(Col("sum_byte"), "desc").
+		Limit(10).
+		Build(e)
+
+常用的算子及time()聚合有对应的简写，例：
+
+	NewQuery("l4_flow_log").
+		Metric(Sum("byte"), "sum_byte").
+		GroupByTime(120, "time_120").
+		Build(e)
+
+sqlparser不支持的语法同样可以构造，例：
+
+	NewQuery("l4_flow_log").
+		Select(Col("region_0"), Col("az_0")).
+		Metric(Sum("byte"), "sum_byte").
+		Where(ILike(Col("region_0"), Str("a*"))).
+		GroupingSets([]sqlparser.Expr{Col("region_0")}, []sqlparser.Expr{Col("az_0")}).
+		OrderBy(Col("sum_byte"), "desc nulls last").
+		Final().
+		Settings("max_threads", "4").
+		Build(e)
+
+Build时与解析sql走相同的Trans流程，tag/指标量翻译、time()、拆层及校验与sql查询一致
+*/
+type QueryBuilder struct {
+	stmt *sqlparser.Select
+	pre  *parse.Preparsed // final、with totals、grouping sets及settings，与解析sql时预处理的结果相同
+}
+
+func NewQuery(table string) *QueryBuilder {
+	return &QueryBuilder{
+		stmt: &sqlparser.Select{
+			From: sqlparser.TableExprs{&sqlparser.AliasedTableExpr{
+				Expr: sqlparser.TableName{Name: sqlparser.NewTableIdent(strings.Trim(table, "`"))},
+			}},
+		},
+		pre: &parse.Preparsed{},
+	}
+}
+
+func (b *QueryBuilder) Select(exprs ...sqlparser.Expr) *QueryBuilder {
+	for _, expr := range exprs {
+		b.stmt.SelectExprs = append(b.stmt.SelectExprs, &sqlparser.AliasedExpr{Expr: expr})
+	}
+	return b
+}
+
+func (b *QueryBuilder) SelectAs(expr sqlparser.Expr, alias string) *QueryBuilder {
+	b.stmt.SelectExprs = append(b.stmt.SelectExprs, &sqlparser.AliasedExpr{
+		Expr: expr,
+		As:   sqlparser.NewColIdent(strings.Trim(alias, "`")),
+	})
+	return b
+}
+
+// Metric 添加算子，如Metric(Sum("byte"), "sum_byte")
+func (b *QueryBuilder) Metric(function *sqlparser.FuncExpr, alias string) *QueryBuilder {
+	return b.SelectAs(function, alias)
+}
+
+// GroupByTime 按interval秒聚合时间，与select time(time, interval) as alias ... group by alias相同，
+// 在select中的位置与调用顺序一致
+func (b *QueryBuilder) GroupByTime(interval int, alias string) *QueryBuilder {
+	b.SelectAs(Fn("time", Col(chCommon.DEFAULT_TIME_COLUMN), Int(interval)), alias)
+	return b.GroupBy(Col(alias))
+}
+
+func (b *QueryBuilder) Distinct() *QueryBuilder {
+	b.stmt.Distinct = sqlparser.DistinctStr
+	return b
+}
+
+// Where 多次调用时以AND连接
+func (b *QueryBuilder) Where(expr sqlparser.Expr) *QueryBuilder {
+	b.stmt.Where = andWhere(b.stmt.Where, sqlparser.WhereStr, expr)
+	return b
+}
+
+// Having 多次调用时以AND连接
+func (b *QueryBuilder) Having(expr sqlparser.Expr) *QueryBuilder {
+	b.stmt.Having = andWhere(b.stmt.Having, sqlparser.HavingStr, expr)
+	return b
+}
+
+func (b *QueryBuilder) GroupBy(exprs ...sqlparser.Expr) *QueryBuilder {
+	b.stmt.GroupBy = append(b.stmt.GroupBy, exprs...)
+	return b
+}
+
+// GroupingSets 与group by grouping sets ((a), (a, b), ())相同，各集合中相同的group只在group by中出现一次
+func (b *QueryBuilder) GroupingSets(sets ...[]sqlparser.Expr) *QueryBuilder {
+	for _, set := range sets {
+		indexes := []int{}
+		for _, expr := range set {
+			index := slices.IndexFunc(b.stmt.GroupBy, func(group sqlparser.Expr) bool {
+				return sqlparser.String(group) == sqlparser.String(expr)
+			})
+			if index < 0 {
+				b.stmt.GroupBy = append(b.stmt.GroupBy, expr)
+				index = len(b.stmt.GroupBy) - 1
+			}
+			indexes = append(indexes, index)
+		}
+		b.pre.GroupingSets = append(b.pre.GroupingSets, indexes)
+	}
+	return b
+}
+
+// WithTotals 与group by ... with totals相同，需要有group by
+func (b *QueryBuilder) WithTotals() *QueryBuilder {
+	b.pre.WithTotals = true
+	return b
+}
+
+// Final 与from <table> final相同
+func (b *QueryBuilder) Final() *QueryBuilder {
+	b.pre.Finals = []bool{true}
+	return b
+}
+
+// Settings 与末尾的settings key=value相同，多次调用时合并
+func (b *QueryBuilder) Settings(key, value string) *QueryBuilder {
+	if b.pre.Settings == nil {
+		b.pre.Settings = map[string]string{}
+	}
+	b.pre.Settings[key] = value
+	return b
+}
+
+// OrderBy direction为asc或desc，为空时同sql默认asc，可跟nulls first/last及collate，如desc nulls last collate 'zh'
+func (b *QueryBuilder) OrderBy(expr sqlparser.Expr, direction string) *QueryBuilder {
+	fields := strings.Fields(direction)
+	direction = sqlparser.AscScr
+	if len(fields) > 0 && (strings.EqualFold(fields[0], sqlparser.AscScr) || strings.EqualFold(fields[0], sqlparser.DescScr)) {
+		direction = strings.ToLower(fields[0])
+		fields = fields[1:]
+	}
+	if len(fields) > 0 {
+		direction += " " + strings.Join(fields, " ")
+	}
+	b.stmt.OrderBy = append(b.stmt.OrderBy, &sqlparser.Order{Expr: expr, Direction: direction})
+	return b
+}
+
+func (b *QueryBuilder) Limit(limit int) *QueryBuilder {
+	if b.stmt.Limit == nil {
+		b.stmt.Limit = &sqlparser.Limit{}
+	}
+	b.stmt.Limit.Rowcount = Int(limit)
+	return b
+}
+
+func (b *QueryBuilder) Offset(offset int) *QueryBuilder {
+	if b.stmt.Limit == nil {
+		b.stmt.Limit = &sqlparser.Limit{}
+	}
+	b.stmt.Limit.Offset = Int(offset)
+	return b
+}
+
+// Build 将查询写入e.Model并生成View，e需要已设置DB
+func (b *QueryBuilder) Build(e *CHEngine) (*view.View, error) {
+	if e.Model == nil {
+		e.Init()
+	}
+	if b.stmt.Limit != nil && b.stmt.Limit.Rowcount == nil {
+		// 只设置了offset时使用默认的limit
+		b.stmt.Limit.Rowcount = sqlparser.NewIntVal([]byte(defaultLimit(e.DefaultLimit)))
+	}
+	if b.pre.WithTotals && len(b.stmt.GroupBy) == 0 {
+		return nil, fmt.Errorf("with totals requires group by")
+	}
+	for key, value := range b.pre.Settings {
+		if !parse.ValidSetting(key, value) {
+			return nil, fmt.Errorf("settings item '%s=%s' is invalid, it should be like key=value", key, value)
+		}
+	}
+	for _, expr := range b.stmt.SelectExprs {
+		if item, ok := expr.(*sqlparser.AliasedExpr); ok {
+			if err := validateFunctions(item.Expr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	parser := parse.Parser{Engine: e, Context: e.Context, DefaultGroupOrder: e.DefaultGroupOrder}
+	parseErr := parser.ParseStmt(b.stmt, b.pre)
+	if slices.Contains(chCommon.DB_TABLE_MAP[e.DB], e.Table) {
+		if err := e.validateTags(b.stmt); err != nil {
+			return nil, err
+		}
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return e.ToView(), nil
+}
+
+// Statement 返回构造的select语句
+func (b *QueryBuilder) Statement() *sqlparser.Select {
+	return b.stmt
+}
+
+func andWhere(where *sqlparser.Where, whereType string, expr sqlparser.Expr) *sqlparser.Where {
+	if where == nil {
+		return &sqlparser.Where{Type: whereType, Expr: expr}
+	}
+	where.Expr = &sqlparser.AndExpr{Left: where.Expr, Right: expr}
+	return where
+}
+
+// sqlparser会将关键字转为小写，如Enum解析为enum，与其保持一致
+func builderIdent(name string) string {
+	_, value := sqlparser.NewStringTokenizer(name).Scan()
+	if strings.EqualFold(string(value), name) {
+		return string(value)
+	}
+	return name
+}
+
+// Col 字段，a.b及a.b.c形式的名称与sql中不加反引号时的解析结果一致
+func Col(name string) *sqlparser.ColName {
+	if strings.HasPrefix(name, "`") {
+		return &sqlparser.ColName{Name: sqlparser.NewColIdent(strings.Trim(name, "`"))}
+	}
+	parts := strings.Split(name, ".")
+	switch len(parts) {
+	case 2:
+		return &sqlparser.ColName{
+			Qualifier: sqlparser.TableName{Name: sqlparser.NewTableIdent(parts[0])},
+			Name:      sqlparser.NewColIdent(builderIdent(parts[1])),
+		}
+	case 3:
+		return &sqlparser.ColName{
+			Qualifier: sqlparser.TableName{Qualifier: sqlparser.NewTableIdent(parts[0]), Name: sqlparser.NewTableIdent(parts[1])},
+			Name:      sqlparser.NewColIdent(builderIdent(parts[2])),
+		}
+	}
+	return &sqlparser.ColName{Name: sqlparser.NewColIdent(builderIdent(name))}
+}
+
+// Fn 函数，包括算子、tag函数及数学运算，如Fn("time", Col("time"), Int(60))
+func Fn(name string, args ...sqlparser.Expr) *sqlparser.FuncExpr {
+	exprs := sqlparser.SelectExprs{}
+	for _, arg := range args {
+		exprs = append(exprs, &sqlparser.AliasedExpr{Expr: arg})
+	}
+	return &sqlparser.FuncExpr{Name: sqlparser.NewColIdent(builderIdent(name)), Exprs: exprs}
+}
+
+func Sum(field string) *sqlparser.FuncExpr {
+	return Fn(view.FUNCTION_SUM, Col(field))
+}
+
+func Max(field string) *sqlparser.FuncExpr {
+	return Fn(view.FUNCTION_MAX, Col(field))
+}
+
+func Min(field string) *sqlparser.FuncExpr {
+	return Fn(view.FUNCTION_MIN, Col(field))
+}
+
+func Avg(field string) *sqlparser.FuncExpr {
+	return Fn(view.FUNCTION_AVG, Col(field))
+}
+
+func AAvg(field string) *sqlparser.FuncExpr {
+	return Fn(view.FUNCTION_AAVG, Col(field))
+}
+
+func Count() *sqlparser.FuncExpr {
+	return Fn(view.FUNCTION_COUNT, Col("row"))
+}
+
+func Str(value string) *sqlparser.SQLVal {
+	return sqlparser.NewStrVal([]byte(value))
+}
+
+func Int(value int) *sqlparser.SQLVal {
+	return sqlparser.NewIntVal([]byte(strconv.Itoa(value)))
+}
+
+func Float(value float64) *sqlparser.SQLVal {
+	return sqlparser.NewFloatVal([]byte(strconv.FormatFloat(value, 'f', -1, 64)))
+}
+
+// Cmp 比较运算，op如=、!=、>=、like、not regexp
+func Cmp(left sqlparser.Expr, op string, right sqlparser.Expr) *sqlparser.ComparisonExpr {
+	op = strings.ToLower(op)
+	if op == "<>" {
+		op = sqlparser.NotEqualStr
+	}
+	return &sqlparser.ComparisonExpr{Operator: op, Left: left, Right: right}
+}
+
+func In(left sqlparser.Expr, values ...sqlparser.Expr) *sqlparser.ComparisonExpr {
+	return &sqlparser.ComparisonExpr{Operator: sqlparser.InStr, Left: left, Right: sqlparser.ValTuple(values)}
+}
+
+func NotIn(left sqlparser.Expr, values ...sqlparser.Expr) *sqlparser.ComparisonExpr {
+	return &sqlparser.ComparisonExpr{Operator: sqlparser.NotInStr, Left: left, Right: sqlparser.ValTuple(values)}
+}
+
+// ILike 与sql中的a ilike 'x'相同，不区分大小写
+func ILike(left, right sqlparser.Expr) *sqlparser.ComparisonExpr {
+	return Cmp(left, "ilike", right)
+}
+
+func NotILike(left, right sqlparser.Expr) *sqlparser.ComparisonExpr {
+	return Cmp(left, "not ilike", right)
+}
+
+// HasAny 数组tag的过滤，与sql中的tags hasAny ('a', 'b')相同
+func HasAny(left sqlparser.Expr, values ...sqlparser.Expr) *sqlparser.ComparisonExpr {
+	return &sqlparser.ComparisonExpr{Operator: parse.OPERATOR_HAS_ANY, Left: left, Right: sqlparser.ValTuple(values)}
+}
+
+func NotHasAny(left sqlparser.Expr, values ...sqlparser.Expr) *sqlparser.ComparisonExpr {
+	return &sqlparser.ComparisonExpr{Operator: "not " + parse.OPERATOR_HAS_ANY, Left: left, Right: sqlparser.ValTuple(values)}
+}
+
+func HasAll(left sqlparser.Expr, values ...sqlparser.Expr) *sqlparser.ComparisonExpr {
+	return &sqlparser.ComparisonExpr{Operator: parse.OPERATOR_HAS_ALL, Left: left, Right: sqlparser.ValTuple(values)}
+}
+
+func NotHasAll(left sqlparser.Expr, values ...sqlparser.Expr) *sqlparser.ComparisonExpr {
+	return &sqlparser.ComparisonExpr{Operator: "not " + parse.OPERATOR_HAS_ALL, Left: left, Right: sqlparser.ValTuple(values)}
+}
+
+// And 与sql一致按从左到右的顺序结合
+func And(exprs ...sqlparser.Expr) sqlparser.Expr {
+	var result sqlparser.Expr
+	for _, expr := range exprs {
+		if result == nil {
+			result = expr
+		} else {
+			result = &sqlparser.AndExpr{Left: result, Right: expr}
+		}
+	}
+	return result
+}
+
+func Or(exprs ...sqlparser.Expr) sqlparser.Expr {
+	var result sqlparser.Expr
+	for _, expr := range exprs {
+		if result == nil {
+			result = expr
+		} else {
+			result = &sqlparser.OrExpr{Left: result, Right: expr}
+		}
+	}
+	return result
+}
+
+func Not(expr sqlparser.Expr) *sqlparser.NotExpr {
+	return &sqlparser.NotExpr{Expr: expr}
+}
+
+func Paren(expr sqlparser.Expr) *sqlparser.ParenExpr {
+	return &sqlparser.ParenExpr{Expr: expr}
+}
+
+// Math 四则运算，op为+、-、*、/
+func Math(left sqlparser.Expr, op string, right sqlparser.Expr) *sqlparser.BinaryExpr {
+	return &sqlparser.BinaryExpr{Operator: op, Left: left, Right: right}
+}
@@ -44,6 +44,17 @@ func (c *Callback) Format(m *view.Model) {
 	m.AddCallback(c.Column, c.Function(c.Args))
 }
 
+// timeFillSeconds 补点按秒计算，toUnixTimestamp64Milli输出的毫秒时间戳先转为秒
+func timeFillSeconds(value interface{}) int {
+	switch v := value.(type) {
+	case uint32:
+		return int(v)
+	case int64:
+		return int(v / 1000)
+	}
+	return 0
+}
+
 func TimeFill(args []interface{}) func(result *common.Result) error { // group by time时的补点
 	return func(result *common.Result) error {
 		if result.Values == nil || len(result.Values) == 0 {
@@ -131,7 +142,7 @@ func TimeFill(args []interface{}) func(result *common.Result) error { // group b
 				record := series.Values
 				// get localtion of record in newValues
 				var timeIndex int
-				timeInt := int(record[timeFieldIndex].(uint32))
+				timeInt := timeFillSeconds(record[timeFieldIndex])
 				if !reverse {
 					timeIndex = (timeInt - start) / m.Time.Interval
 				} else {
@@ -167,7 +178,11 @@ func TimeFill(args []interface{}) func(result *common.Result) error { // group b
 					} else {
 						timestamp = end - i*m.Time.Interval
 					}
-					newValue[timeFieldIndex] = uint32(timestamp)
+					if m.TimestampMilli {
+						newValue[timeFieldIndex] = int64(timestamp) * 1000
+					} else {
+						newValue[timeFieldIndex] = uint32(timestamp)
+					}
 					newValues[i] = newValue
 				} else {
 					// if point exist && metrics is null, fill the metrics
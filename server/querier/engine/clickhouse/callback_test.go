@@ -138,3 +138,35 @@ func TestTimeFill(t *testing.T) {
 		t.Errorf("Callback: TimeFill, columns: %v, values: %v, newValues: %v, want: %v", columns, values, result.Values, want)
 	}
 }
+
+func TestTimeFillMilli(t *testing.T) {
+	m := view.NewModel()
+	m.Time.TimeStart = 1645092000
+	m.Time.TimeEnd = 1645092120
+	m.Time.Fill = "0"
+	m.Time.Interval = 60
+	m.Time.Alias = "time"
+	m.TimestampMilli = true
+	callback := TimeFill([]interface{}{m})
+	result := &common.Result{
+		Columns: []interface{}{"time", "field_0"},
+		Values:  []interface{}{[]interface{}{int64(1645092060000), 1}},
+		Schemas: common.ColumnSchemas{&common.ColumnSchema{
+			Type:      common.COLUMN_SCHEMA_TYPE_TAG,
+			ValueType: "Int64",
+		}, &common.ColumnSchema{
+			Type:      common.COLUMN_SCHEMA_TYPE_METRICS,
+			ValueType: "Float64",
+		},
+		},
+	}
+	callback(result)
+	want := []interface{}{
+		[]interface{}{int64(1645092000000), 0},
+		[]interface{}{int64(1645092060000), 1},
+		[]interface{}{int64(1645092120000), 0},
+	}
+	if !reflect.DeepEqual(result.Values, want) {
+		t.Errorf("Callback: TimeFill, newValues: %v, want: %v", result.Values, want)
+	}
+}
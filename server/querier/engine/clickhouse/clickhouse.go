@@ -26,6 +26,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	//"github.com/k0kubun/pp"
 	"github.com/bitly/go-simplejson"
@@ -50,9 +51,11 @@ var DEFAULT_LIMIT = "10000"
 var INVALID_PROMETHEUS_SUBQUERY_CACHE_ENTRY = "-1"
 var subSqlRegexp = regexp.MustCompile(`\(SELECT\s.+?LIMIT\s.+?\)`)
 var checkWithSqlRegexp = regexp.MustCompile(`WITH\s+\S+\s+AS\s+\(`)
+var unionRegexp = regexp.MustCompile(`(?i)\sunion\s`)
 var letterRegexp = regexp.MustCompile("^[a-zA-Z]")
 var fromRegexp = regexp.MustCompile(`(?i)from\s+(\S+)`)
 var whereRegexp = regexp.MustCompile(`(?i)where\s+(\S.*)`)
+var exactIntervalRegexp = regexp.MustCompile(`(?i)/\*\s*exact_interval\s*\*/`)
 var visibilityRegexp = regexp.MustCompile(`(?i)regexp\s+(\S+)`)
 var notRegexp = regexp.MustCompile(`(?i)(\S+)\s+not regexp\s+(\S+)`)
 
@@ -96,24 +99,56 @@ type TargetLabelFilter struct {
 	TransFilter  string
 }
 
+// CompileOptions 影响生成的sql的查询选项，子engine整体继承，ModelCache整体作为key的一部分
+type CompileOptions struct {
+	Language          string
+	NoPreWhere        bool
+	PreWhere          bool   // 将简单的列比较放入PREWHERE，NoPreWhere为true时不生效
+	NoDivZeroGuard    bool   // 关闭用户除法表达式的除0保护
+	AllowRawExpr      bool   // 允许Raw('expr')透传ClickHouse表达式
+	AlignTimeRange    bool   // 有time()聚合时将时间范围对齐到DatasourceInterval
+	TimestampMilli    bool   // time()输出毫秒时间戳
+	DefaultGroupOrder bool   // 未指定ORDER BY时按time()及其余group升序排序
+	MaxOffset         int    // 允许的最大OFFSET，0表示不限制
+	MaxPoints         int    // time()聚合的最大时间点数，超过时调大间隔，0表示不限制
+	ExactInterval     bool   // sql中有/* exact_interval */时不调整time()的间隔
+	DefaultLimit      string // 查询未指定LIMIT时使用，为空时使用全局limit
+}
+
 type CHEngine struct {
+	CompileOptions
 	Model              *view.Model
 	Statements         []Statement
 	DB                 string
 	Table              string
 	DataSource         string
 	AsTagMap           map[string]string
+	AsFuncMap          map[string]string // alias of function/binary expr in select, used by having
 	ColumnSchemas      []*common.ColumnSchema
 	View               *view.View
 	Context            context.Context
 	TargetLabelFilters []TargetLabelFilter
-	NoPreWhere         bool
+	DefaultSettings    map[string]string // 按库配置的默认SETTINGS，查询中的同名setting优先
+	QueryTimeout       time.Duration     // 查询超时时间，为0时使用clickhouse配置的query-timeout
+	Comment            string            // 生成的sql前加上的注释，如dashboard_id=123，通过SetComment设置
 	IsDerivative       bool
 	DerivativeGroupBy  []string
 	ORGID              string
-	Language           string
+	ModelCache         *ModelCache          // 为nil时不缓存编译后的Model
+	DictCache          *TagDictCache        // 为nil时tag翻译不内联字典内容
+	DatasourceCache    *DatasourceCache     // 为nil时time()不按粒度选择数据源
+	SlowQueryLog       *SlowQueryLog        // 为nil时不记录慢查询
+	Metrics            *EngineMetrics       // 为nil时不统计prometheus指标
+	Admission          *AdmissionController // 为nil时不做准入控制
+	Now                func() time.Time     // where中now()的时间来源，为空时使用time.Now
 	NativeField        map[string]*metrics.Metrics
 	CustomMetrics      map[string]*simplejson.Json
+	inHaving           bool     // 正在解析having或order by中未select的算子，select别名可直接引用，且不修改ColumnSchemas
+	selectIndex        int      // 正在解析的select列的位置，从1开始
+	selectColumns      []string // select中的列名，非普通tag的列为空字符串
+	timeWindowFunction string   // select中按时间滑动的窗口函数，如MovingAvg，要求按time()聚合
+	modelCacheHit      bool     // 使用了ModelCache中的编译结果，Model中只有时间范围及回调
+	cachedLevels       int      // 命中ModelCache时生成的sql的层数
 }
 
 func init() {
@@ -181,20 +216,73 @@ func ReplaceCustomBizServiceFilter(sql, orgID string) (string, error) {
 	return sql, nil
 }
 
-func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map[string]interface{}, error) {
-	// 解析show开头的sql
-	// show metrics/tags from <table_name> 例：show metrics/tags from l4_flow_log
+// initQuery 按请求参数及配置初始化engine，替换sql中的宏及自定义业务过滤，返回的cancel在查询结束后调用
+func (e *CHEngine) initQuery(args *common.QuerierParams) (string, context.CancelFunc, error) {
 	var err error
 	sql := args.Sql
+	var cancel context.CancelFunc = func() {}
 	e.Context = args.Context
+	if e.Context == nil {
+		e.Context = context.Background()
+	}
+	// 超时或客户端断开时ctx取消，clickhouse driver随即通知ClickHouse终止正在执行的查询
+	queryTimeout := e.QueryTimeout
+	if queryTimeout == 0 {
+		queryTimeout = time.Duration(config.Cfg.Clickhouse.QueryTimeout) * time.Second
+	}
+	if queryTimeout > 0 {
+		e.Context, cancel = context.WithTimeout(e.Context, queryTimeout)
+	}
 	e.NoPreWhere = args.NoPreWhere
+	e.PreWhere = args.PreWhere || config.Cfg.PreWhere
+	e.NoDivZeroGuard = args.NoDivZeroGuard
+	e.AlignTimeRange = args.AlignTimeRange
+	e.TimestampMilli = args.TimestampMilli
+	e.DefaultGroupOrder = args.DefaultGroupOrder
+	e.SetComment(args.Comment)
+	e.AllowRawExpr = config.Cfg.AllowRawExpr
+	e.MaxOffset = config.Cfg.MaxOffset
+	e.MaxPoints = config.Cfg.MaxPoints
+	if args.MaxPoints > 0 {
+		e.MaxPoints = args.MaxPoints
+	}
+	e.ExactInterval = exactIntervalRegexp.MatchString(sql)
+	e.DefaultSettings = config.Cfg.DefaultSettings[e.DB]
+	e.DefaultLimit = config.Cfg.DefaultLimits[e.DB]
+	if e.ModelCache == nil {
+		e.ModelCache = GetModelCache()
+	}
+	if e.DictCache == nil {
+		e.DictCache = GetTagDictCache()
+	}
+	if e.DatasourceCache == nil {
+		e.DatasourceCache = GetDatasourceCache()
+	}
+	if e.SlowQueryLog == nil {
+		e.SlowQueryLog = GetSlowQueryLog()
+	}
+	if e.Metrics == nil {
+		e.Metrics = GetEngineMetrics()
+	}
+	if e.Admission == nil {
+		e.Admission = GetAdmissionController()
+	}
+	if e.Model != nil {
+		e.Model.NoDivZeroGuard = e.NoDivZeroGuard
+		e.Model.TimestampMilli = e.TimestampMilli
+		e.Model.Settings.Defaults = e.DefaultSettings
+		e.Model.DefaultLimit = e.DefaultLimit
+	}
 	e.Language = args.Language
 	e.ORGID = common.DEFAULT_ORG_ID
 	if args.ORGID != "" {
 		e.ORGID = args.ORGID
 	}
-	query_uuid := args.QueryUUID // FIXME: should be queryUUID
-	debug_info := &client.DebugInfo{}
+	// 替换Grafana模板中的$__from、$__to及$__interval等宏
+	sql, err = parse.SubstituteMacros(sql, parse.Macros{From: args.TimeFrom, To: args.TimeTo, Interval: args.Interval})
+	if err != nil {
+		return "", cancel, err
+	}
 	// replace custom_biz_filter
 	fromMatch := fromRegexp.FindStringSubmatch(sql)
 	if len(fromMatch) > 1 {
@@ -202,10 +290,23 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 		if table != chCommon.TABLE_NAME_ALERT_EVENT && table != chCommon.TABLE_NAME_ALERT_RECORD {
 			sql, err = ReplaceCustomBizServiceFilter(sql, e.ORGID)
 			if err != nil {
-				return nil, nil, err
+				return "", cancel, err
 			}
 		}
 	}
+	return sql, cancel, nil
+}
+
+func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map[string]interface{}, error) {
+	// 解析show开头的sql
+	// show metrics/tags from <table_name> 例：show metrics/tags from l4_flow_log
+	sql, cancel, err := e.initQuery(args)
+	defer cancel()
+	if err != nil {
+		return nil, nil, err
+	}
+	query_uuid := args.QueryUUID // FIXME: should be queryUUID
+	debug_info := &client.DebugInfo{}
 	// Parse withSql
 	withResult, withDebug, err := e.QueryWithSql(sql, args)
 	if err != nil {
@@ -218,6 +319,18 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 		debug_info.Debug = append(debug_info.Debug, *withDebug)
 		return withResult, debug_info.Get(), err
 	}
+	// Parse unionSql
+	unionResult, unionDebug, err := e.QueryUnionSql(sql, args)
+	if err != nil {
+		if unionDebug != nil {
+			debug_info.Debug = append(debug_info.Debug, *unionDebug)
+		}
+		return nil, debug_info.Get(), err
+	}
+	if unionResult != nil {
+		debug_info.Debug = append(debug_info.Debug, *unionDebug)
+		return unionResult, debug_info.Get(), err
+	}
 	// Parse slimitSql
 	slimitResult, slimitDebug, err := e.QuerySlimitSql(sql, args)
 	if err != nil {
@@ -265,35 +378,27 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 			ColumnSchemaMap[ColumnSchema.Name] = ColumnSchema
 		}
 	}
-	parser := parse.Parser{}
 	for _, sql1 := range sqlList {
 		usedEngine := &CHEngine{}
+		var chSql string
+		parseStart := time.Now()
 		if isShow {
 			showEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID}
 			showEngine.Init()
-			parser.Engine = showEngine
 			usedEngine = showEngine
+			chSql, err = usedEngine.compileSQL(sql1)
 		} else {
-			parser.Engine = e
 			usedEngine = e
+			chSql, err = usedEngine.ParseCachedSQL(sql1)
 		}
-		err = parser.ParseSQL(sql1)
+		parseTime := time.Since(parseStart)
 		if err != nil {
+			e.Metrics.ObserveParse(usedEngine.DB, usedEngine.Table, parseTime, "", 0, err)
 			errorMessage := fmt.Sprintf("sql: %s; parse error: %s", sql1, err.Error())
 			log.Error(errorMessage)
 			return nil, nil, err
 		}
-		// To do
-		for _, stmt := range usedEngine.Statements {
-			stmt.Format(usedEngine.Model)
-		}
-		FormatModel(usedEngine.Model)
-		// 使用Model生成View
-		usedEngine.View = view.NewView(usedEngine.Model)
-		if !isShow {
-			usedEngine.View.NoPreWhere = usedEngine.NoPreWhere
-		}
-		chSql := usedEngine.ToSQLString()
+		e.Metrics.ObserveParse(usedEngine.DB, usedEngine.Table, parseTime, chSql, usedEngine.subViewLevels(), nil)
 		callbacks := usedEngine.View.GetCallbacks()
 		debug.Sql = chSql
 		if !isShow {
@@ -312,7 +417,19 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 		if !isShow {
 			params.Callbacks = callbacks
 		}
-		result, err := chClient.DoQuery(params)
+		debug.Rows, debug.Bytes = 0, 0
+		var result *common.Result
+		err = e.executeAndRecord(queryRecord{
+			db: usedEngine.DB, table: usedEngine.Table, queryUUID: query_uuid,
+			sql: sql1, chSql: chSql, parseStart: parseStart, parseTime: parseTime, debug: debug,
+		}, func() (int, error) {
+			var err error
+			result, err = chClient.DoQuery(params)
+			if result == nil {
+				return 0, err
+			}
+			return len(result.Values), err
+		})
 		if err != nil {
 			log.Error(err)
 			debug_info.Debug = append(debug_info.Debug, *debug)
@@ -323,6 +440,7 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 			results.Columns = result.Columns
 			if !isShow {
 				results.Schemas = result.Schemas
+				results.Meta = usedEngine.TimeRangeMeta()
 			}
 			debug_info.Debug = append(debug_info.Debug, *debug)
 		}
@@ -331,6 +449,49 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 
 }
 
+// queryRecord 一次clickhouse查询的信息，用于准入控制、查询指标及慢查询日志
+type queryRecord struct {
+	db         string // 查询的表所在的db及表名，按其做准入控制及记录指标
+	table      string
+	queryUUID  string
+	sql        string
+	chSql      string
+	parseStart time.Time
+	parseTime  time.Duration
+	debug      *client.Debug // 执行后从中读取结果的行数及字节数
+}
+
+// executeAndRecord 并发查询数达到上限时排队，排队超时或队列已满时直接返回；
+// 执行query后记录查询指标及慢查询日志，query返回结果的行数
+func (e *CHEngine) executeAndRecord(record queryRecord, query func() (int, error)) error {
+	release, err := e.Admission.Acquire(e.Context, e.ORGID, record.db, record.table)
+	if err != nil {
+		log.Warning(err)
+		return err
+	}
+	queryStart := time.Now()
+	rows, err := query()
+	release()
+	e.Metrics.ObserveQuery(record.db, record.table, time.Since(queryStart), rows, err)
+	slowQuery := SlowQuery{
+		Time:      record.parseStart,
+		QueryUUID: record.queryUUID,
+		ORGID:     e.ORGID,
+		DB:        e.DB,
+		Sql:       record.sql,
+		ChSql:     record.chSql,
+		ParseTime: record.parseTime.Seconds(),
+		QueryTime: time.Since(queryStart).Seconds(),
+		Rows:      record.debug.Rows,
+		Bytes:     record.debug.Bytes,
+	}
+	if err != nil {
+		slowQuery.Error = err.Error()
+	}
+	e.SlowQueryLog.Record(slowQuery)
+	return err
+}
+
 func ShowTagTypeMetrics(tagDescriptions, result *common.Result, db, table string) {
 	for _, tagValue := range tagDescriptions.Values {
 		tagSlice := tagValue.([]interface{})
@@ -922,14 +1083,14 @@ func (e *CHEngine) ParseSlimitSql(sql string, args *common.QuerierParams) (strin
 				}
 			}
 		}
-		innerEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID}
+		innerEngine := e.newSubEngine()
 		innerEngine.Init()
 		if strings.Contains(innerSql, "Derivative") {
 			innerEngine.IsDerivative = true
 			innerEngine.Model.IsDerivative = true
 			innerEngine.Model.DerivativeGroupBy = innerEngine.DerivativeGroupBy
 		}
-		innerParser := parse.Parser{Engine: innerEngine}
+		innerParser := parse.Parser{Engine: innerEngine, Context: innerEngine.Context}
 		err = innerParser.ParseSQL(innerSql)
 		if err != nil {
 			return "", nil, nil, fmt.Errorf("sql: %s; parse error: %s", innerSql, err.Error())
@@ -942,18 +1103,19 @@ func (e *CHEngine) ParseSlimitSql(sql string, args *common.QuerierParams) (strin
 		innerEngine.View = view.NewView(innerEngine.Model)
 		innerTransSql = innerEngine.ToSQLString()
 	}
-	outerEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID}
+	outerEngine := e.newSubEngine()
 	outerEngine.Init()
 	if strings.Contains(newSql, "Derivative") {
 		outerEngine.IsDerivative = true
 		outerEngine.Model.IsDerivative = true
 		outerEngine.Model.DerivativeGroupBy = outerEngine.DerivativeGroupBy
 	}
-	outerParser := parse.Parser{Engine: outerEngine}
+	outerParser := parse.Parser{Engine: outerEngine, Context: outerEngine.Context, DefaultGroupOrder: outerEngine.DefaultGroupOrder}
 	err = outerParser.ParseSQL(newSql)
 	if err != nil {
 		return "", nil, nil, fmt.Errorf("sql: %s; parse error: %s", innerSql, err.Error())
 	}
+	outerEngine.adjustTimeInterval()
 	for _, stmt := range outerEngine.Statements {
 		stmt.Format(outerEngine.Model)
 	}
@@ -998,7 +1160,7 @@ func (e *CHEngine) ParseSlimitSql(sql string, args *common.QuerierParams) (strin
 	for _, ColumnSchema := range outerEngine.ColumnSchemas {
 		columnSchemaMap[ColumnSchema.Name] = ColumnSchema
 	}
-	return outerSql, callbacks, columnSchemaMap, nil
+	return e.withComment(outerSql), callbacks, columnSchemaMap, nil
 }
 
 func (e *CHEngine) QueryWithSql(sql string, args *common.QuerierParams) (*common.Result, *client.Debug, error) {
@@ -1010,7 +1172,24 @@ func (e *CHEngine) QueryWithSql(sql string, args *common.QuerierParams) (*common
 	if sql == "" {
 		return nil, nil, nil
 	}
+	return e.queryParsedSql(sql, callbacks, columnSchemaMap, args)
+}
 
+// QueryUnionSql 查询select ... union all select ...，非union all查询时返回nil
+func (e *CHEngine) QueryUnionSql(sql string, args *common.QuerierParams) (*common.Result, *client.Debug, error) {
+	sql, callbacks, columnSchemaMap, err := e.ParseUnionSql(sql)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	if sql == "" {
+		return nil, nil, nil
+	}
+	return e.queryParsedSql(sql, callbacks, columnSchemaMap, args)
+}
+
+// queryParsedSql 执行已翻译好的clickhouse-sql
+func (e *CHEngine) queryParsedSql(sql string, callbacks map[string]func(*common.Result) error, columnSchemaMap map[string]*common.ColumnSchema, args *common.QuerierParams) (*common.Result, *client.Debug, error) {
 	query_uuid := args.QueryUUID
 	debug := &client.Debug{
 		IP:        config.Cfg.Clickhouse.Host,
@@ -1055,9 +1234,9 @@ func (e *CHEngine) ParseWithSql(sql string) (string, map[string]func(*common.Res
 	for _, match := range subMatches {
 		match = strings.TrimPrefix(match, "(")
 		match = strings.TrimSuffix(match, ")")
-		matchEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID}
+		matchEngine := e.newSubEngine()
 		matchEngine.Init()
-		matchParser := parse.Parser{Engine: matchEngine}
+		matchParser := parse.Parser{Engine: matchEngine, Context: matchEngine.Context}
 		err := matchParser.ParseSQL(match)
 		if err != nil {
 			return "", nil, nil, err
@@ -1080,18 +1259,152 @@ func (e *CHEngine) ParseWithSql(sql string) (string, map[string]func(*common.Res
 	for i, parseSql := range parsedSqls {
 		sql = strings.ReplaceAll(sql, subMatches[i], fmt.Sprintf("(%s)", parseSql))
 	}
-	return sql, callbacks, columnSchemaMap, nil
+	return e.withComment(sql), callbacks, columnSchemaMap, nil
+}
+
+// ParseUnionSql 将union all的各分支分别解析为View，再以UNION ALL拼接，
+// union all按位置合并各分支的列，因此各分支的列数及列名需与第一个分支一致
+func (e *CHEngine) ParseUnionSql(sql string) (string, map[string]func(*common.Result) error, map[string]*common.ColumnSchema, error) {
+	if !unionRegexp.MatchString(sql) {
+		return "", nil, nil, nil
+	}
+	stmt, pre, err := parse.Prepare(sql)
+	if err != nil || pre.Settings != nil || pre.WithTotals || pre.GroupingSets != nil {
+		// 语法错误及分支中不支持的语法交给普通查询报错
+		return "", nil, nil, nil
+	}
+	union, ok := stmt.(*sqlparser.Union)
+	if !ok {
+		return "", nil, nil, nil
+	}
+	branches := []*sqlparser.Select{}
+	if err := flattenUnionAll(union, &branches, true); err != nil {
+		return "", nil, nil, err
+	}
+	var unionView *view.View
+	var columns []*common.ColumnSchema
+	var callbacks map[string]func(*common.Result) error
+	columnSchemaMap := make(map[string]*common.ColumnSchema)
+	for i, branch := range branches {
+		branchEngine := e.newSubEngine()
+		branchEngine.Init()
+		branchParser := parse.Parser{Engine: branchEngine, Context: branchEngine.Context}
+		if err := branchParser.ParseStmt(branch, pre.Branch(i)); err != nil {
+			return "", nil, nil, err
+		}
+		for _, stmt := range branchEngine.Statements {
+			stmt.Format(branchEngine.Model)
+		}
+		FormatModel(branchEngine.Model)
+		branchEngine.View = view.NewView(branchEngine.Model)
+		branchEngine.View.NoPreWhere = e.NoPreWhere
+		if i == 0 {
+			unionView = branchEngine.View
+			columns = branchEngine.ColumnSchemas
+			callbacks = branchEngine.View.GetCallbacks()
+			for _, columnSchema := range columns {
+				columnSchemaMap[columnSchema.Name] = columnSchema
+			}
+			continue
+		}
+		if err := checkUnionColumns(columns, branchEngine.ColumnSchemas, i); err != nil {
+			return "", nil, nil, err
+		}
+		unionView.Unions = append(unionView.Unions, branchEngine.View)
+	}
+	// union整体的order by只能使用输出列
+	for _, order := range union.OrderBy {
+		name := strings.Trim(sqlparser.String(order.Expr), "`")
+		if !slices.ContainsFunc(columns, func(c *common.ColumnSchema) bool { return c.Name == name }) {
+			return "", nil, nil, fmt.Errorf("order by '%s' of union all is not a select column", name)
+		}
+		if unionView.UnionOrders == nil {
+			unionView.UnionOrders = &view.Orders{}
+		}
+		unionView.UnionOrders.Append(newOrder(name, order.Direction, true))
+	}
+	if union.Limit != nil {
+		unionView.UnionLimit = &view.Limit{Limit: sqlparser.String(union.Limit.Rowcount)}
+		if union.Limit.Offset != nil {
+			unionView.UnionLimit.Offset = sqlparser.String(union.Limit.Offset)
+			if err := e.checkOffset(unionView.UnionLimit.Offset); err != nil {
+				return "", nil, nil, err
+			}
+		}
+	}
+	return e.withComment(unionView.ToString()), callbacks, columnSchemaMap, nil
+}
+
+// 展开嵌套的union，只支持union all，嵌套的union all不支持单独的order by/limit
+func flattenUnionAll(stmt sqlparser.SelectStatement, branches *[]*sqlparser.Select, isTop bool) error {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Union:
+		if stmt.Type != sqlparser.UnionAllStr {
+			return fmt.Errorf("%s is not supported, use UNION ALL instead", strings.ToUpper(stmt.Type))
+		}
+		if !isTop && (len(stmt.OrderBy) > 0 || stmt.Limit != nil) {
+			return errors.New("order by/limit of nested union all is not supported")
+		}
+		if err := flattenUnionAll(stmt.Left, branches, false); err != nil {
+			return err
+		}
+		return flattenUnionAll(stmt.Right, branches, false)
+	case *sqlparser.ParenSelect:
+		return flattenUnionAll(stmt.Select, branches, false)
+	case *sqlparser.Select:
+		*branches = append(*branches, stmt)
+	}
+	return nil
+}
+
+func checkUnionColumns(expect, columns []*common.ColumnSchema, branch int) error {
+	if len(columns) != len(expect) {
+		return fmt.Errorf("union all branch %d has %d columns, but the first branch has %d", branch+1, len(columns), len(expect))
+	}
+	for i := range expect {
+		if columns[i].Name != expect[i].Name {
+			return fmt.Errorf("union all branch %d column %d '%s' does not match '%s' of the first branch", branch+1, i+1, columns[i].Name, expect[i].Name)
+		}
+	}
+	return nil
+}
+
+// newSubEngine 创建解析子查询的engine，继承当前engine的所有查询选项；
+// 注释只加在最外层的sql前，不继承Comment
+func (e *CHEngine) newSubEngine() *CHEngine {
+	return &CHEngine{
+		CompileOptions: e.CompileOptions, DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID, Now: e.Now,
+		DefaultSettings: e.DefaultSettings, QueryTimeout: e.QueryTimeout, DictCache: e.DictCache, DatasourceCache: e.DatasourceCache,
+	}
 }
 
 func (e *CHEngine) Init() {
 	e.Model = view.NewModel()
 	e.Model.DB = e.DB
+	e.Model.NoDivZeroGuard = e.NoDivZeroGuard
+	e.Model.TimestampMilli = e.TimestampMilli
+	e.Model.Settings.Defaults = e.DefaultSettings
+	e.Model.DefaultLimit = e.DefaultLimit
 	if e.ORGID == "" {
 		e.ORGID = common.DEFAULT_ORG_ID
 	}
 }
 
+func (e *CHEngine) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
+
 func (e *CHEngine) TransSelect(tags sqlparser.SelectExprs) error {
+	tags, err := e.expandStar(tags)
+	if err != nil {
+		return err
+	}
+	if err := checkDuplicateAlias(tags); err != nil {
+		return err
+	}
 	tagSlice := []string{}
 	for _, tag := range tags {
 		item, ok := tag.(*sqlparser.AliasedExpr)
@@ -1120,7 +1433,12 @@ func (e *CHEngine) TransSelect(tags sqlparser.SelectExprs) error {
 	}
 
 	e.AsTagMap = make(map[string]string)
-	for _, tag := range tags {
+	e.AsFuncMap = make(map[string]string)
+	e.selectColumns = make([]string, 0, len(tags))
+	for i, tag := range tags {
+		// 记录select列的位置，最外层按该顺序输出
+		e.selectIndex = i + 1
+		e.Statements = append(e.Statements, &SelectIndex{Index: e.selectIndex})
 		err := e.parseSelect(tag)
 		if err != nil {
 			return err
@@ -1179,12 +1497,14 @@ func (e *CHEngine) TransSelect(tags sqlparser.SelectExprs) error {
 			if ok {
 				if as != "" {
 					e.AsTagMap[as] = strings.Trim(sqlparser.String(function.Name), "`")
+					e.AsFuncMap[as] = sqlparser.String(function)
 				}
 			}
 			binary, ok := item.Expr.(*sqlparser.BinaryExpr)
 			if ok {
 				if as != "" {
 					e.AsTagMap[as] = sqlparser.String(binary)
+					e.AsFuncMap[as] = sqlparser.String(binary)
 				}
 			}
 			// Integer tag
@@ -1196,6 +1516,37 @@ func (e *CHEngine) TransSelect(tags sqlparser.SelectExprs) error {
 			}
 		}
 	}
+	// 之后group等引入的列不在select中
+	e.selectIndex = 0
+	e.Statements = append(e.Statements, &SelectIndex{})
+	if e.timeWindowFunction != "" && e.Model.Time.Interval == 0 {
+		return fmt.Errorf("function %s requires time() in group by", e.timeWindowFunction)
+	}
+	return nil
+}
+
+// checkDuplicateAlias 别名与其他输出列重名时结果列有歧义，返回冲突的两列
+func checkDuplicateAlias(tags sqlparser.SelectExprs) error {
+	type outputColumn struct {
+		expr     string
+		hasAlias bool
+	}
+	columns := map[string]outputColumn{}
+	for _, tag := range tags {
+		item, ok := tag.(*sqlparser.AliasedExpr)
+		if !ok {
+			continue
+		}
+		name := strings.Trim(chCommon.ParseAlias(item.As), "`")
+		hasAlias := name != ""
+		if !hasAlias {
+			name = strings.Trim(chCommon.ParseAlias(item.Expr), "`")
+		}
+		if previous, ok := columns[name]; ok && (hasAlias || previous.hasAlias) {
+			return fmt.Errorf("duplicate column name %s in select: '%s' conflicts with '%s'", name, sqlparser.String(item), previous.expr)
+		}
+		columns[name] = outputColumn{expr: sqlparser.String(item), hasAlias: hasAlias}
+	}
 	return nil
 }
 
@@ -1228,8 +1579,7 @@ func (e *CHEngine) TransPrometheusTargetIDFilter(expr view.Node) (view.Node, err
 				return expr, nil
 			} else if filter == INVALID_PROMETHEUS_SUBQUERY_CACHE_ENTRY {
 				sql := strings.Join(trgetTransFilters, " INTERSECT ")
-				targetFilter := fmt.Sprintf("toUInt64(target_id) IN (%s)", sql)
-				rightExpr := &view.Expr{Value: targetFilter}
+				rightExpr := &view.InSubquery{Expr: &view.Expr{Value: "toUInt64(target_id)"}, Subquery: sql}
 				op := view.Operator{Type: view.AND}
 				expr = &view.BinaryExpr{Left: expr, Right: rightExpr, Op: &op}
 				return expr, nil
@@ -1277,8 +1627,7 @@ func (e *CHEngine) TransPrometheusTargetIDFilter(expr view.Node) (view.Node, err
 			// insert a special value into the cache so that the next time you check the cache, you will find
 			entryValue := common.EntryValue{Time: time.Now(), Filter: INVALID_PROMETHEUS_SUBQUERY_CACHE_ENTRY}
 			prometheusSubqueryCache.Add(entryKey, entryValue)
-			targetFilter := fmt.Sprintf("toUInt64(target_id) IN (%s)", sql)
-			rightExpr := &view.Expr{Value: targetFilter}
+			rightExpr := &view.InSubquery{Expr: &view.Expr{Value: "toUInt64(target_id)"}, Subquery: sql}
 			op := view.Operator{Type: view.AND}
 			expr = &view.BinaryExpr{Left: expr, Right: rightExpr, Op: &op}
 		}
@@ -1296,6 +1645,9 @@ func (e *CHEngine) TransWhere(node *sqlparser.Where) error {
 	if err != nil {
 		return err
 	}
+	if err := checkTimeRange(whereStmt.time); err != nil {
+		return err
+	}
 	expr, err = e.TransPrometheusTargetIDFilter(expr)
 	filter := view.Filters{Expr: expr}
 	whereStmt.filter = &filter
@@ -1303,28 +1655,165 @@ func (e *CHEngine) TransWhere(node *sqlparser.Where) error {
 	return err
 }
 
+// TimeRangeMeta 时间范围被对齐或time()间隔被调大时返回调整前后的取值，供前端标注
+func (e *CHEngine) TimeRangeMeta() map[string]interface{} {
+	t := e.Model.Time
+	if t.RequestedTimeStart == 0 && t.RequestedTimeEnd == 0 && t.RequestedInterval == 0 {
+		return nil
+	}
+	meta := map[string]interface{}{}
+	if t.RequestedTimeStart != 0 || t.RequestedTimeEnd != 0 {
+		meta["requested_time_range"] = []int64{t.RequestedTimeStart, t.RequestedTimeEnd}
+		meta["effective_time_range"] = []int64{t.TimeStart, t.TimeEnd}
+	}
+	if t.RequestedInterval != 0 {
+		meta["requested_interval"] = t.RequestedInterval
+		meta["effective_interval"] = t.Interval
+	}
+	return meta
+}
+
+// adjustTimeInterval 时间范围内time()的时间点数超过MaxPoints时，将间隔调大为DatasourceInterval的整数倍，
+// 需在time()及where解析完成后、Format之前调用
+func (e *CHEngine) adjustTimeInterval() {
+	t := e.Model.Time
+	if e.MaxPoints <= 0 || e.ExactInterval || t.Interval <= 0 || t.TimeStart <= 0 || t.RequestedInterval != 0 {
+		return
+	}
+	// 按周、月聚合的间隔不是固定秒数，Derivative的开始时间已按原间隔前移
+	if t.Unit == TIME_UNIT_WEEK || t.Unit == TIME_UNIT_MONTH || e.IsDerivative {
+		return
+	}
+	timeEnd := t.TimeEnd
+	if timeEnd == 0 {
+		timeEnd = e.now().Unix()
+	}
+	timeRange := int(timeEnd - t.TimeStart)
+	interval := (timeRange + e.MaxPoints - 1) / e.MaxPoints
+	// 向上取整，保证时间点数不超过MaxPoints；超过1天时按天聚合，取整到天
+	step := max(t.DatasourceInterval, 1)
+	if interval >= INTERVAL_1D {
+		step = INTERVAL_1D
+	}
+	interval = (interval + step - 1) / step * step
+	if interval <= t.Interval {
+		return
+	}
+	t.RequestedInterval = t.Interval
+	t.Interval = interval
+}
+
+func checkTimeRange(t *view.Time) error {
+	if t.TimeEnd != 0 && t.TimeStart > t.TimeEnd {
+		return fmt.Errorf("time range is invalid: start time %d is later than end time %d", t.TimeStart, t.TimeEnd)
+	}
+	return nil
+}
+
 func (e *CHEngine) TransHaving(node *sqlparser.Where) error {
+	// 窗口函数的结果不能用在having中，引用其别名的条件拆出后在窗口层作为WHERE
+	havingExpr, windowExpr, err := e.splitWindowHaving(node.Expr)
+	if err != nil {
+		return err
+	}
+	if windowExpr != nil {
+		if err := e.transHavingExpr(windowExpr, true); err != nil {
+			return err
+		}
+	}
+	if havingExpr == nil {
+		return nil
+	}
+	return e.transHavingExpr(havingExpr, false)
+}
+
+func (e *CHEngine) transHavingExpr(node sqlparser.Expr, onTop bool) error {
+	e.inHaving = true
+	defer func() { e.inHaving = false }()
 	// 生成having的statement
-	havingStmt := Having{Where{}}
+	havingStmt := Having{Where: Where{isHaving: true}, onTop: onTop}
 	// 解析ast树并生成view.Node结构
 	// having中的metric需要在trans之前确定是否分层，所以需要提前遍历
-	_, err := e.parseWhere(node.Expr, &havingStmt.Where, true)
+	_, err := e.parseWhere(node, &havingStmt.Where, true)
 	if err != nil {
 		return err
 	}
-	expr, err := e.parseWhere(node.Expr, &havingStmt.Where, false)
+	expr, err := e.parseWhere(node, &havingStmt.Where, false)
 	filter := view.Filters{Expr: expr}
 	havingStmt.filter = &filter
 	e.Statements = append(e.Statements, &havingStmt)
 	return err
 }
 
+// isWindowAlias 别名是否为select中窗口函数（如ZScore）的别名
+func (e *CHEngine) isWindowAlias(alias string) bool {
+	_, ok := e.AsFuncMap[alias]
+	return ok && common.IsValueInSliceString(e.AsTagMap[alias], view.WINDOW_FUNCTIONS)
+}
+
+// splitWindowHaving 按AND拆分having，返回普通having条件及引用窗口函数别名的条件
+func (e *CHEngine) splitWindowHaving(node sqlparser.Expr) (having sqlparser.Expr, window sqlparser.Expr, err error) {
+	if andExpr, ok := node.(*sqlparser.AndExpr); ok {
+		leftHaving, leftWindow, err := e.splitWindowHaving(andExpr.Left)
+		if err != nil {
+			return nil, nil, err
+		}
+		rightHaving, rightWindow, err := e.splitWindowHaving(andExpr.Right)
+		if err != nil {
+			return nil, nil, err
+		}
+		return joinAndExpr(leftHaving, rightHaving), joinAndExpr(leftWindow, rightWindow), nil
+	}
+	hasWindowAlias, hasFunction := false, false
+	err = sqlparser.Walk(func(n sqlparser.SQLNode) (bool, error) {
+		switch n := n.(type) {
+		case *sqlparser.ColName:
+			if e.isWindowAlias(chCommon.ParseAlias(n)) {
+				hasWindowAlias = true
+			}
+		case *sqlparser.FuncExpr:
+			if name := strings.Trim(sqlparser.String(n.Name), "`"); common.IsValueInSliceString(name, view.WINDOW_FUNCTIONS) {
+				return false, fmt.Errorf("function %s is not supported in having, use its alias instead", name)
+			}
+			hasFunction = true
+		}
+		return true, nil
+	}, node)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hasWindowAlias {
+		return node, nil, nil
+	}
+	if hasFunction {
+		return nil, nil, fmt.Errorf("having condition '%s' mixes window function alias with other functions, combine them with AND instead", sqlparser.String(node))
+	}
+	return nil, node, nil
+}
+
+func joinAndExpr(left, right sqlparser.Expr) sqlparser.Expr {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+	return &sqlparser.AndExpr{Left: left, Right: right}
+}
+
 func (e *CHEngine) TransFrom(froms sqlparser.TableExprs) error {
 	for _, from := range froms {
 		switch from := from.(type) {
 		case *sqlparser.AliasedTableExpr:
+			isFinal := false
+			alias := ""
+			if !from.As.IsEmpty() {
+				// from <table> as <alias>: 列中的别名限定符在parse中已去除
+				alias = sqlparser.String(from.As)
+			}
+			from.As = sqlparser.NewTableIdent("")
 			// 解析Table类型
-			table := strings.Trim(sqlparser.String(from), "`")
+			table := normalizeTableName(e.DB, strings.Trim(sqlparser.String(from), "`"))
 			if strings.Contains(table, "vtap_app_port") {
 				table = strings.ReplaceAll(table, "vtap_app_port", "application")
 			} else if strings.Contains(table, "vtap_app_edge_port") {
@@ -1413,6 +1902,7 @@ func (e *CHEngine) TransFrom(froms sqlparser.TableExprs) error {
 				return err
 			}
 			e.Model.Time.DatasourceInterval = interval
+			e.Model.Cluster = isClusterTable(e.DB, table)
 			newDB := e.DB
 			if e.ORGID != common.DEFAULT_ORG_ID && e.ORGID != "" {
 				orgIDInt, err := strconv.Atoi(e.ORGID)
@@ -1425,13 +1915,12 @@ func (e *CHEngine) TransFrom(froms sqlparser.TableExprs) error {
 				}
 			}
 			if e.DataSource != "" {
-				e.AddTable(fmt.Sprintf("%s.`%s.%s`", newDB, table, e.DataSource))
+				e.AddTableWithAlias(fmt.Sprintf("%s.`%s.%s`", newDB, table, e.DataSource), alias, isFinal)
 			} else {
-				newDBTableStr := fmt.Sprintf("%s.`%s`", newDB, table)
 				if table == chCommon.TABLE_NAME_ALERT_EVENT {
-					newDBTableStr = newDBTableStr + " FINAL"
+					isFinal = true
 				}
-				e.AddTable(newDBTableStr)
+				e.AddTableWithAlias(fmt.Sprintf("%s.`%s`", newDB, table), alias, isFinal)
 			}
 			virtualTableFilter, ok := GetVirtualTableFilter(e.DB, e.Table)
 			if ok {
@@ -1445,6 +1934,49 @@ func (e *CHEngine) TransFrom(froms sqlparser.TableExprs) error {
 	return nil
 }
 
+// isClusterTable 表是否配置为Distributed表，network.1m等带数据源的表按network匹配
+func isClusterTable(db, table string) bool {
+	table, _, _ = strings.Cut(table, ".")
+	for _, clusterTable := range config.Cfg.Clickhouse.ClusterTables {
+		if clusterTable == "*" || clusterTable == db+".*" || clusterTable == db+"."+table {
+			return true
+		}
+	}
+	return false
+}
+
+// selectDatasourceByInterval 未指定数据源时，根据time()的聚合粒度选择能整除该粒度的最粗数据源
+// 例如time(time, 3600)查询flow_metrics.network时改用network.1h
+func (e *CHEngine) selectDatasourceByInterval(interval int) {
+	if e.DB != chCommon.DB_NAME_FLOW_METRICS || e.DataSource != "" || strings.Contains(e.Table, ".") || interval <= 0 {
+		return
+	}
+	for _, name := range chCommon.FLOW_METRICS_DATASOURCES {
+		dsInterval := chCommon.DATASOURCE_NAME_INTERVAL_MAP[name]
+		if interval%dsInterval != 0 || e.Model.Time.Offset%dsInterval != 0 {
+			continue
+		}
+		if dsInterval <= e.Model.Time.DatasourceInterval {
+			return
+		}
+		// 确认该数据源存在且粒度一致，只读取缓存
+		realInterval, ok := e.DatasourceCache.Get(e.DB, e.Table, name, e.ORGID)
+		if !ok || realInterval != dsInterval {
+			continue
+		}
+		for _, stmt := range e.Statements {
+			table, ok := stmt.(*Table)
+			if !ok || !strings.HasSuffix(table.Value, "`") {
+				continue
+			}
+			table.Value = fmt.Sprintf("%s.%s`", strings.TrimSuffix(table.Value, "`"), name)
+		}
+		e.DataSource = name
+		e.Model.Time.DatasourceInterval = dsInterval
+		return
+	}
+}
+
 func (e *CHEngine) TransGroupBy(groups sqlparser.GroupBy) error {
 	groupSlice := []string{}
 	for _, group := range groups {
@@ -1492,9 +2024,133 @@ func (e *CHEngine) TransGroupBy(groups sqlparser.GroupBy) error {
 			return err
 		}
 	}
+	e.setArrayJoinTags(groupSlice)
+	return nil
+}
+
+// setArrayJoinTags 按数组tag分组时，select中的该tag展开为元素后再分组
+func (e *CHEngine) setArrayJoinTags(groups []string) {
+	for _, stmt := range e.Statements {
+		selectTag, ok := stmt.(*SelectTag)
+		if !ok || !slices.Contains(tagdescription.TAG_ARRAY, strings.Trim(selectTag.Value, "`")) {
+			continue
+		}
+		for _, group := range groups {
+			if strings.Trim(group, "`") == strings.Trim(selectTag.Value, "`") {
+				selectTag.ArrayJoin = true
+				break
+			}
+		}
+	}
+}
+
+// grouping sets中的group转换为view中group的名称，由view生成GROUP BY GROUPING SETS
+func (e *CHEngine) TransGroupingSets(sets []sqlparser.GroupBy) error {
+	groupingSets := [][]string{}
+	for _, set := range sets {
+		groupNames := []string{}
+		for _, group := range set {
+			groupTag := chCommon.ParseAlias(group)
+			groupNames = append(groupNames, strings.Trim(groupTag, "`"))
+			stmts, err := GetGroup(groupTag, e)
+			if err != nil {
+				return err
+			}
+			for _, stmt := range stmts {
+				groupStmt, ok := stmt.(*GroupTag)
+				if !ok {
+					continue
+				}
+				if groupStmt.Alias != "" {
+					groupNames = append(groupNames, strings.Trim(groupStmt.Alias, "`"))
+				} else {
+					groupNames = append(groupNames, strings.Trim(groupStmt.Value, "`"))
+				}
+			}
+		}
+		groupingSets = append(groupingSets, groupNames)
+	}
+	e.Statements = append(e.Statements, &GroupingSets{Sets: groupingSets})
+	return nil
+}
+
+// from <table> final，final只输出在物理表上
+func (e *CHEngine) TransFinal() error {
+	for _, stmt := range e.Statements {
+		if table, ok := stmt.(*Table); ok {
+			table.Final = true
+		}
+	}
 	return nil
 }
 
+// TransTableFunction 将from的表放入表函数中，如cluster('df', l4_flow_log)，
+// 表函数在集群的各节点上执行，IN子查询同Distributed表一样输出为GLOBAL IN
+func (e *CHEngine) TransTableFunction(prefix, suffix string) error {
+	for _, stmt := range e.Statements {
+		if table, ok := stmt.(*Table); ok {
+			table.FunctionPrefix = prefix
+			table.FunctionSuffix = suffix
+		}
+	}
+	e.Model.Cluster = true
+	return nil
+}
+
+// with totals由view输出在聚合层的GROUP BY之后
+func (e *CHEngine) TransWithTotals() error {
+	e.Statements = append(e.Statements, &WithTotals{})
+	return nil
+}
+
+// select distinct只作用于tag，不能与算子同时使用
+func (e *CHEngine) TransDistinct() error {
+	for _, stmt := range e.Statements {
+		switch stmt.(type) {
+		case *AggFunction, *BinaryFunction:
+			return errors.New("distinct is not supported with aggregate functions")
+		}
+	}
+	raws, err := e.getDistinctRaws()
+	if err != nil {
+		return err
+	}
+	e.Statements = append(e.Statements, &Distinct{Raws: raws})
+	return nil
+}
+
+// select全部为普通tag且存在需要翻译的tag时，返回这些tag翻译前的原始列，否则返回nil
+func (e *CHEngine) getDistinctRaws() ([]*GroupTag, error) {
+	var raws []*GroupTag
+	translated := false
+	for _, column := range e.selectColumns {
+		if column == "" {
+			return nil, nil
+		}
+		stmts, err := GetGroup(column, e)
+		if err != nil {
+			return nil, err
+		}
+		if len(stmts) == 0 {
+			return nil, nil
+		}
+		for _, stmt := range stmts {
+			groupTag, ok := stmt.(*GroupTag)
+			if !ok {
+				return nil, nil
+			}
+			if strings.Trim(groupTag.Value, "`") != strings.Trim(column, "`") {
+				translated = true
+			}
+			raws = append(raws, groupTag)
+		}
+	}
+	if !translated {
+		return nil, nil
+	}
+	return raws, nil
+}
+
 func (e *CHEngine) TransDerivativeGroupBy(groups sqlparser.GroupBy) error {
 	groupSlice := []string{}
 	for _, group := range groups {
@@ -1521,50 +2177,218 @@ func (e *CHEngine) TransOrderBy(orders sqlparser.OrderBy) error {
 }
 
 func (e *CHEngine) TransLimit(limit *sqlparser.Limit) error {
+	// limit及offset只支持整数，limit -1表示不限制
+	for _, value := range []sqlparser.Expr{limit.Rowcount, limit.Offset} {
+		if value == nil {
+			continue
+		}
+		if val, ok := value.(*sqlparser.SQLVal); !ok || val.Type != sqlparser.IntVal {
+			return fmt.Errorf("limit and offset should be integers: %s", sqlparser.String(value))
+		}
+	}
 	e.Model.Limit.Limit = sqlparser.String(limit.Rowcount)
 	if limit.Offset != nil {
 		e.Model.Limit.Offset = sqlparser.String(limit.Offset)
+		if err := e.checkOffset(e.Model.Limit.Offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOffset 深度翻页需要ClickHouse读取并丢弃offset之前的全部行，超过MaxOffset时拒绝
+func (e *CHEngine) checkOffset(offset string) error {
+	if e.MaxOffset <= 0 {
+		return nil
+	}
+	offsetValue, err := strconv.Atoi(offset)
+	if err != nil {
+		return nil
+	}
+	if offsetValue > e.MaxOffset {
+		return fmt.Errorf("offset %d exceeds max-offset %d, page by filtering on the last returned row (e.g. time < last_time) instead of offset", offsetValue, e.MaxOffset)
+	}
+	return nil
+}
+
+// 查询中的settings覆盖同名的默认settings，只允许allowed-settings中的setting
+func (e *CHEngine) TransSettings(settings map[string]string) error {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if !slices.Contains(config.Cfg.AllowedSettings, key) {
+			return fmt.Errorf("setting '%s' is not allowed, allowed settings: %s", key, strings.Join(config.Cfg.AllowedSettings, ", "))
+		}
+	}
+	for key, value := range settings {
+		e.Model.Settings.Set(key, value)
 	}
 	return nil
 }
 
 // 原始sql转为clickhouse-sql
 func (e *CHEngine) ToSQLString() string {
+	// View生成clickhouse-sql
+	chSql := e.ToView().ToString()
+	return e.withComment(chSql)
+}
+
+// SetComment 设置生成的sql前的注释，用于从慢查询追溯到发起查询的dashboard等，
+// 注释中的*/、/*及控制字符会被替换，不能提前结束注释或注入其他sql
+func (e *CHEngine) SetComment(comment string) {
+	e.Comment = sanitizeComment(comment)
+}
+
+// withComment 在sql前加上/* Comment */
+func (e *CHEngine) withComment(sql string) string {
+	if e.Comment == "" || sql == "" {
+		return sql
+	}
+	return "/* " + e.Comment + " */ " + sql
+}
+
+// sanitizeComment 控制字符替换为空格，拆开*/及/*（ClickHouse支持嵌套注释），直到不再出现
+func sanitizeComment(comment string) string {
+	comment = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, comment)
+	for strings.Contains(comment, "*/") || strings.Contains(comment, "/*") {
+		comment = strings.ReplaceAll(comment, "*/", "* /")
+		comment = strings.ReplaceAll(comment, "/*", "/ *")
+	}
+	return strings.TrimSpace(comment)
+}
+
+// subViewLevels 生成的sql的层数，命中ModelCache时View未拆层，使用缓存的层数
+func (e *CHEngine) subViewLevels() int {
+	if e.modelCacheHit {
+		return e.cachedLevels
+	}
+	return len(e.View.SubViewLevels)
+}
+
+// ToParameterizedSQLString 生成参数化的clickhouse-sql，过滤条件中的常量替换为{p0:UInt64}形式的占位符，
+// 同时返回参数名到参数值的映射，用于查询日志及缓存
+func (e *CHEngine) ToParameterizedSQLString() (string, map[string]string) {
+	v := e.ToView()
+	params := view.NewQueryParams()
+	filters := []*view.Filters{e.Model.Filters, e.Model.PreFilters, e.Model.Havings, e.Model.TopFilters}
+	for _, f := range filters {
+		f.Params = params
+	}
+	chSql := v.ToString()
+	for _, f := range filters {
+		f.Params = nil
+	}
+	return e.withComment(chSql), params.Values
+}
+
+// ToView 将解析结果写入Model并生成View
+func (e *CHEngine) ToView() *view.View {
 	if e.View == nil {
+		e.adjustTimeInterval()
 		for _, stmt := range e.Statements {
 			stmt.Format(e.Model)
 		}
+		e.splitPreWhere()
 		FormatLimit(e.Model)
 		// 使用Model生成View
 		e.View = view.NewView(e.Model)
 	}
-	// View生成clickhouse-sql
-	chSql := e.View.ToString()
-	return chSql
+	return e.View
 }
 
 func (e *CHEngine) parseOrderBy(order *sqlparser.Order) error {
 	switch expr := order.Expr.(type) {
 	case *sqlparser.FuncExpr:
-		e.Model.Orders.Append(
-			&view.Order{
-				SortBy:  sqlparser.String(expr),
-				OrderBy: order.Direction,
-				IsField: false,
-			},
-		)
+		sortBy := sqlparser.String(expr)
+		// select中的算子直接使用其别名
+		if e.isSelectColumn(sortBy) {
+			e.Model.Orders.Append(newOrder(sortBy, order.Direction, true))
+			return nil
+		}
+		if _, ok := metrics.METRICS_FUNCTIONS_MAP[strings.Trim(sqlparser.String(expr.Name), "`")]; ok {
+			// 未在select中的算子，与having相同翻译为聚合表达式，分层时排序在外层
+			e.inHaving = true
+			function, err := e.parseSelectBinaryExpr(expr)
+			e.inHaving = false
+			if err != nil {
+				return err
+			}
+			sortBy = function.Trans(e.Model).ToString()
+		}
+		e.Model.Orders.Append(newOrder(sortBy, order.Direction, false))
 	case *sqlparser.ColName:
-		e.Model.Orders.Append(
-			&view.Order{
-				SortBy:  chCommon.ParseAlias(expr),
-				OrderBy: order.Direction,
-				IsField: true,
-			},
-		)
+		sortBy := chCommon.ParseAlias(expr)
+		if !e.isSelectColumn(sortBy) {
+			// 未在select中的tag及指标量，与where相同翻译为实际的列
+			if translator, ok := e.getOrderTranslator(sortBy); ok {
+				e.Model.Orders.Append(newOrder(translator, order.Direction, false))
+				return nil
+			}
+		}
+		e.Model.Orders.Append(newOrder(sortBy, order.Direction, true))
 	}
 	return nil
 }
 
+// isSelectColumn 是否为select返回的列名（别名或未指定别名时的表达式）
+func (e *CHEngine) isSelectColumn(name string) bool {
+	name = strings.Trim(name, "`")
+	for _, column := range e.ColumnSchemas {
+		if column.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getOrderTranslator 返回未在select中的tag或指标量排序时使用的表达式，无需翻译时返回false
+func (e *CHEngine) getOrderTranslator(name string) (string, bool) {
+	metricStruct, ok := metrics.GetMetrics(name, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics)
+	if ok && metricStruct.Type != metrics.METRICS_TYPE_TAG {
+		return metricStruct.DBField, metricStruct.DBField != name
+	}
+	stmts, _, err := GetTagTranslator(name, "", e)
+	if err != nil || len(stmts) != 1 {
+		return "", false
+	}
+	// 依赖with的tag无法只在order by中引用，保持原样
+	selectTag, ok := stmts[0].(*SelectTag)
+	if !ok || len(selectTag.Withs) > 0 || selectTag.Value == "" || strings.Trim(selectTag.Value, "`") == strings.Trim(name, "`") {
+		return "", false
+	}
+	return selectTag.Value, true
+}
+
+// direction中可带nulls first/last及collate，如desc nulls last collate 'zh'
+func newOrder(sortBy, direction string, isField bool) *view.Order {
+	order := &view.Order{SortBy: sortBy, IsField: isField}
+	fields := strings.Fields(direction)
+	for i := 0; i < len(fields); i++ {
+		switch strings.ToLower(fields[i]) {
+		case "nulls":
+			if i+1 < len(fields) {
+				i++
+				order.NullsFirst = strings.ToLower(fields[i]) == "first"
+				order.NullsLast = strings.ToLower(fields[i]) == "last"
+			}
+		case "collate":
+			order.Collate = strings.Trim(strings.Join(fields[i+1:], " "), `'"`)
+			i = len(fields)
+		default:
+			order.OrderBy = fields[i]
+		}
+	}
+	return order
+}
+
 // 解析GroupBy
 func (e *CHEngine) parseGroupBy(group sqlparser.Expr) error {
 	//var args []string
@@ -1572,6 +2396,9 @@ func (e *CHEngine) parseGroupBy(group sqlparser.Expr) error {
 	// 普通字符串
 	case *sqlparser.ColName, *sqlparser.SQLVal:
 		groupTag := chCommon.ParseAlias(expr)
+		if _, ok := e.AsTagMap[groupTag]; !ok {
+			groupTag = e.TransIDTag(groupTag)
+		}
 		// pod_ingress/lb_listener is not supported by group
 		if strings.HasPrefix(groupTag, "pod_ingress") || strings.HasPrefix(groupTag, "lb_listener") {
 			errStr := fmt.Sprintf("%s is not supported by group", groupTag)
@@ -1610,6 +2437,24 @@ func (e *CHEngine) parseGroupBy(group sqlparser.Expr) error {
 		}
 	// func(field)
 	case *sqlparser.FuncExpr:
+		name, args, _, err := e.parseFunction(expr)
+		if err != nil {
+			return err
+		}
+		name = strings.Trim(name, "`")
+		if name == TAG_FUNCTION_ENUM || name == TAG_FUNCTION_RAW || name == TAG_FUNCTION_BUCKET {
+			stmts, err := GetTagFunctionGroup(name, args, e)
+			if err != nil {
+				return err
+			}
+			e.Statements = append(e.Statements, stmts...)
+		} else if isGroupExprFunction(name) {
+			value, err := e.transGroupExpr(expr)
+			if err != nil {
+				return err
+			}
+			e.Statements = append(e.Statements, &GroupTag{Value: value, IsExpr: true})
+		}
 		/* name, args, err := e.parseFunction(expr)
 		if err != nil {
 			return err
@@ -1642,6 +2487,10 @@ func (e *CHEngine) parseSelect(tag sqlparser.SelectExpr) error {
 }
 
 func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
+	fillNull, err := parseFillNull(item)
+	if err != nil {
+		return err
+	}
 	as := chCommon.ParseAlias(item.As)
 	labelType := ""
 	if as != "" {
@@ -1649,6 +2498,7 @@ func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
 	} else {
 		e.ColumnSchemas = append(e.ColumnSchemas, common.NewColumnSchema(strings.ReplaceAll(chCommon.ParseAlias(item.Expr), "`", ""), "", labelType))
 	}
+	e.selectColumns = append(e.selectColumns, "")
 	//var args []string
 	switch expr := item.Expr.(type) {
 	// 普通字符串
@@ -1660,23 +2510,41 @@ func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
 		binFunction.SetAlias(as)
 		e.Statements = append(e.Statements, binFunction)
 		return nil
-	case *sqlparser.ColName, *sqlparser.SQLVal:
-		labelType, err := e.AddTag(chCommon.ParseAlias(expr), as)
+	// 常量列原样输出，只放在计算层外层，不参与聚合分层
+	case *sqlparser.SQLVal:
+		e.Statements = append(e.Statements, &SelectTag{Value: sqlparser.String(expr), Alias: as, Flag: view.NODE_FLAG_METRICS_OUTER})
+		return nil
+	case *sqlparser.UnaryExpr:
+		if !isNumericLiteral(expr) {
+			return fmt.Errorf("select: %s not support", sqlparser.String(expr))
+		}
+		e.Statements = append(e.Statements, &SelectTag{Value: sqlparser.String(expr), Alias: as, Flag: view.NODE_FLAG_METRICS_OUTER})
+		return nil
+	case *sqlparser.ColName:
+		tagName := e.TransIDTag(chCommon.ParseAlias(expr))
+		labelType, err := e.AddTag(tagName, as)
 		if err != nil {
 			return err
 		}
+		e.selectColumns[len(e.selectColumns)-1] = tagName
 		if labelType != "" {
 			if as != "" {
 				e.ColumnSchemas[len(e.ColumnSchemas)-1] = common.NewColumnSchema(as, strings.ReplaceAll(chCommon.ParseAlias(item.Expr), "`", ""), labelType)
 			} else {
 				e.ColumnSchemas[len(e.ColumnSchemas)-1] = common.NewColumnSchema(strings.ReplaceAll(chCommon.ParseAlias(item.Expr), "`", ""), "", labelType)
 			}
+		} else if as == "" && tagName != chCommon.ParseAlias(expr) {
+			// id tag翻译为资源名称后返回列名为资源名称
+			e.ColumnSchemas[len(e.ColumnSchemas)-1] = common.NewColumnSchema(tagName, strings.ReplaceAll(chCommon.ParseAlias(item.Expr), "`", ""), labelType)
 		}
 		return nil
 	// func(field/tag)
 	case *sqlparser.FuncExpr:
 		// 二级运算符
 		if common.IsValueInSliceString(sqlparser.String(expr.Name), view.MATH_FUNCTIONS) {
+			if fillNull != "" {
+				return fmt.Errorf("fillnull only supports metric functions: %s", sqlparser.String(expr))
+			}
 			if as == "" {
 				as = strings.ReplaceAll(chCommon.ParseAlias(item.Expr), "`", "")
 			}
@@ -1731,9 +2599,9 @@ func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
 			topkStrSchema.Type = common.COLUMN_SCHEMA_TYPE_METRICS
 			topkCountsSchema := common.NewColumnSchema(topKCountsAs, topKCounts, "")
 			topkCountsSchema.Type = common.COLUMN_SCHEMA_TYPE_METRICS
-			e.Statements = append([]Statement{&SelectTag{Value: topKCounts, Alias: topKCountsAs, Flag: view.NODE_FLAG_METRICS_OUTER}}, e.Statements...)
+			e.Statements = append([]Statement{&SelectTag{Value: topKCounts, Alias: topKCountsAs, Flag: view.NODE_FLAG_METRICS_OUTER}, &SelectIndex{}}, e.Statements...)
 			e.ColumnSchemas = append([]*common.ColumnSchema{topkCountsSchema}, e.ColumnSchemas...)
-			e.Statements = append([]Statement{&SelectTag{Value: topKStr, Alias: topKStrAs, Flag: view.NODE_FLAG_METRICS_OUTER}}, e.Statements...)
+			e.Statements = append([]Statement{&SelectIndex{Index: e.selectIndex}, &SelectTag{Value: topKStr, Alias: topKStrAs, Flag: view.NODE_FLAG_METRICS_OUTER}}, e.Statements...)
 			e.ColumnSchemas = append([]*common.ColumnSchema{topkStrSchema}, e.ColumnSchemas...)
 		}
 
@@ -1742,6 +2610,9 @@ func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
 			return err
 		}
 		if function != nil {
+			if fillNull != "" {
+				function.(*AggFunction).FillNull = fillNull
+			}
 			// 通过metric判断view是否拆层
 			e.SetLevelFlag(levelFlag)
 			e.Statements = append(e.Statements, function)
@@ -1751,6 +2622,9 @@ func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
 			}
 			return nil
 		}
+		if fillNull != "" {
+			return fmt.Errorf("fillnull only supports metric functions: %s", sqlparser.String(expr))
+		}
 		args[0] = strings.Trim(args[0], "`")
 		tagFunction, err := GetTagFunction(name, args, as, e)
 		if err != nil {
@@ -1760,12 +2634,25 @@ func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
 			// time需要被最先解析
 			if name == "time" {
 				tagFunction.(*Time).Trans(e.Model)
-				e.Statements = append([]Statement{tagFunction}, e.Statements...)
+				e.selectDatasourceByInterval(e.Model.Time.Interval)
+				e.Statements = append([]Statement{&SelectIndex{Index: e.selectIndex}, tagFunction, &SelectIndex{}}, e.Statements...)
 			} else {
 				e.Statements = append(e.Statements, tagFunction)
 			}
 			return nil
 		}
+		// 计算表达式作为tag输出，可通过别名在group by中引用
+		if isGroupExprFunction(name) {
+			value, err := e.transGroupExpr(expr)
+			if err != nil {
+				return err
+			}
+			e.Statements = append(e.Statements, &SelectTag{Value: value, Alias: functionAs})
+			return nil
+		}
+		if !isRegisteredFunction(name) {
+			return newUnknownFunctionError(name)
+		}
 		return errors.New(fmt.Sprintf("function: %s not support", sqlparser.String(expr)))
 	// field +=*/ field 运算符
 	case *sqlparser.BinaryExpr:
@@ -1784,6 +2671,30 @@ func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
 	}
 }
 
+// parseFillNull 去掉select项外层由fillnull改写的FillNull(func(x), 0)，返回补null的值
+func parseFillNull(item *sqlparser.AliasedExpr) (string, error) {
+	expr, ok := item.Expr.(*sqlparser.FuncExpr)
+	if !ok || !strings.EqualFold(expr.Name.String(), parse.FUNCTION_FILL_NULL) {
+		return "", nil
+	}
+	if len(expr.Exprs) != 2 {
+		return "", fmt.Errorf("fillnull only supports one argument: %s", sqlparser.String(expr))
+	}
+	inner, ok := expr.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return "", fmt.Errorf("fillnull only supports metric functions: %s", sqlparser.String(expr))
+	}
+	if _, ok := inner.Expr.(*sqlparser.FuncExpr); !ok {
+		return "", fmt.Errorf("fillnull only supports metric functions: %s", sqlparser.String(inner.Expr))
+	}
+	value := sqlparser.String(expr.Exprs[1])
+	if arg, ok := expr.Exprs[1].(*sqlparser.AliasedExpr); !ok || !isNumericLiteral(arg.Expr) {
+		return "", fmt.Errorf("fillnull value should be a number: %s", value)
+	}
+	item.Expr = inner.Expr
+	return value, nil
+}
+
 func (e *CHEngine) parseFunction(item *sqlparser.FuncExpr) (name string, args []string, derivativeArgs []string, err error) {
 	for _, arg := range item.Exprs {
 		argStr := sqlparser.String(arg)
@@ -1824,7 +2735,16 @@ func (e *CHEngine) parseFunction(item *sqlparser.FuncExpr) (name string, args []
 			}
 		}
 	}
-	return sqlparser.String(item.Name), args, derivativeArgs, nil
+	name = sqlparser.String(item.Name)
+	// Count()等同于Sum(log_count)，Count(distinct tag)等同于Uniq(tag)
+	if strings.Trim(name, "`") == view.FUNCTION_COUNT {
+		if len(args) == 0 {
+			return view.FUNCTION_SUM, []string{metrics.LOG_COUNT_METRICS_NAME}, derivativeArgs, nil
+		} else if item.Distinct {
+			return view.FUNCTION_UNIQ, args, derivativeArgs, nil
+		}
+	}
+	return name, args, derivativeArgs, nil
 }
 
 // 解析运算符
@@ -1847,6 +2767,30 @@ func (e *CHEngine) parseSelectBinaryExpr(node sqlparser.Expr) (binary Function,
 	case *sqlparser.FuncExpr:
 		// 嵌套算子
 		if common.IsValueInSliceString(sqlparser.String(expr.Name), view.MATH_FUNCTIONS) {
+			if sqlparser.String(expr.Name) == view.FUNCTION_SMOOTHED_PCTL {
+				movingAvg, err := e.parseSmoothedPercentile(expr)
+				if err != nil {
+					return nil, err
+				}
+				return e.parseSelectBinaryExpr(movingAvg)
+			}
+			if sqlparser.String(expr.Name) == view.FUNCTION_ERROR_RATIO {
+				errorRatio, err := e.parseErrorRatio(expr)
+				if err != nil {
+					return nil, err
+				}
+				return e.parseSelectBinaryExpr(errorRatio)
+			}
+			if sqlparser.String(expr.Name) == view.FUNCTION_MOVING_AVG {
+				if err := e.parseMovingAvg(expr); err != nil {
+					return nil, err
+				}
+			}
+			if sqlparser.String(expr.Name) == view.FUNCTION_PERCENT_OF_TOTAL {
+				if err := checkPercentOfTotal(expr); err != nil {
+					return nil, err
+				}
+			}
 			args := []Function{}
 			for _, argExpr := range expr.Exprs {
 				arg, err := e.parseSelectBinaryExpr(argExpr.(*sqlparser.AliasedExpr).Expr)
@@ -1855,7 +2799,7 @@ func (e *CHEngine) parseSelectBinaryExpr(node sqlparser.Expr) (binary Function,
 				}
 				args = append(args, arg)
 			}
-			if function, ok := metrics.METRICS_FUNCTIONS_MAP[sqlparser.String(expr.Name)]; ok {
+			if function, ok := metrics.METRICS_FUNCTIONS_MAP[sqlparser.String(expr.Name)]; ok && !e.inHaving {
 				e.ColumnSchemas[len(e.ColumnSchemas)-1].Unit = strings.ReplaceAll(function.UnitOverwrite, "$unit", e.ColumnSchemas[len(e.ColumnSchemas)-1].Unit)
 			}
 			return GetBinaryFunc(sqlparser.String(expr.Name), args)
@@ -1872,6 +2816,10 @@ func (e *CHEngine) parseSelectBinaryExpr(node sqlparser.Expr) (binary Function,
 		if aggfunction != nil {
 			// 通过metric判断view是否拆层
 			e.SetLevelFlag(levelFlag)
+			// having中未出现在select里的算子不属于任何返回列
+			if e.inHaving {
+				return aggfunction.(Function), nil
+			}
 			e.ColumnSchemas[len(e.ColumnSchemas)-1].Type = common.COLUMN_SCHEMA_TYPE_METRICS
 			if unit != "" && e.ColumnSchemas[len(e.ColumnSchemas)-1].Unit == "" {
 				e.ColumnSchemas[len(e.ColumnSchemas)-1].Unit = unit
@@ -1889,13 +2837,29 @@ func (e *CHEngine) parseSelectBinaryExpr(node sqlparser.Expr) (binary Function,
 			}
 			return function, nil
 		}
+		if !isRegisteredFunction(name) {
+			return nil, newUnknownFunctionError(name)
+		}
 		return nil, errors.New(fmt.Sprintf("function: %s not support in binary", sqlparser.String(expr)))
 	case *sqlparser.ParenExpr:
 		// 括号
 		return e.parseSelectBinaryExpr(expr.Expr)
 	case *sqlparser.SQLVal:
 		return &Field{Value: sqlparser.String(expr)}, nil
+	case *sqlparser.UnaryExpr:
+		// 负数小数等带符号的常量，如Sum(byte)*-0.5
+		if !isNumericLiteral(expr) {
+			return nil, fmt.Errorf("operator: %s not support in binary", expr.Operator)
+		}
+		return &Field{Value: sqlparser.String(expr)}, nil
 	case *sqlparser.ColName:
+		// having表达式中引用select中算子的别名时，直接使用外层的别名
+		if e.inHaving {
+			alias := chCommon.ParseAlias(expr)
+			if _, ok := e.AsFuncMap[alias]; ok {
+				return &Field{Value: fmt.Sprintf("`%s`", strings.Trim(alias, "`"))}, nil
+			}
+		}
 		field := sqlparser.String(expr)
 		fieldFunc, err := GetFieldFunc(field)
 		if err != nil {
@@ -1915,6 +2879,102 @@ func (e *CHEngine) parseSelectBinaryExpr(node sqlparser.Expr) (binary Function,
 	}
 }
 
+// isNumericLiteral 判断是否为数值常量，整数为负数时sqlparser直接解析为常量，小数为负数时解析为一元运算
+func isNumericLiteral(expr sqlparser.Expr) bool {
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal:
+		return expr.Type == sqlparser.IntVal || expr.Type == sqlparser.FloatVal
+	case *sqlparser.UnaryExpr:
+		return (expr.Operator == sqlparser.UMinusStr || expr.Operator == sqlparser.UPlusStr) && isNumericLiteral(expr.Expr)
+	}
+	return false
+}
+
+// checkPercentOfTotal PercentOfTotal的参数为一个聚合算子，窗口层按其在计算层的输出求总和
+func checkPercentOfTotal(expr *sqlparser.FuncExpr) error {
+	if len(expr.Exprs) == 1 {
+		if arg, ok := expr.Exprs[0].(*sqlparser.AliasedExpr); ok {
+			if _, ok := arg.Expr.(*sqlparser.FuncExpr); ok {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("function %s requires 1 aggregate argument, e.g. %s(Sum(byte)), got %s", view.FUNCTION_PERCENT_OF_TOTAL, view.FUNCTION_PERCENT_OF_TOTAL, sqlparser.String(expr))
+}
+
+// parseMovingAvg 校验MovingAvg(metric, N)的参数，指标未指定聚合时按Avg计算
+func (e *CHEngine) parseMovingAvg(expr *sqlparser.FuncExpr) error {
+	if len(expr.Exprs) != 2 {
+		return fmt.Errorf("function %s requires 2 arguments: metric and window size", view.FUNCTION_MOVING_AVG)
+	}
+	sizeExpr, ok := expr.Exprs[1].(*sqlparser.AliasedExpr)
+	if !ok {
+		return fmt.Errorf("function %s window size must be a positive integer, got %s", view.FUNCTION_MOVING_AVG, sqlparser.String(expr.Exprs[1]))
+	}
+	size, err := strconv.Atoi(sqlparser.String(sizeExpr.Expr))
+	if err != nil || size <= 0 {
+		return fmt.Errorf("function %s window size must be a positive integer, got %s", view.FUNCTION_MOVING_AVG, sqlparser.String(sizeExpr.Expr))
+	}
+	if metricExpr, ok := expr.Exprs[0].(*sqlparser.AliasedExpr); ok {
+		if colName, ok := metricExpr.Expr.(*sqlparser.ColName); ok {
+			metricExpr.Expr = &sqlparser.FuncExpr{
+				Name:  sqlparser.NewColIdent(view.FUNCTION_AVG),
+				Exprs: sqlparser.SelectExprs{&sqlparser.AliasedExpr{Expr: colName}},
+			}
+		}
+	}
+	if e.timeWindowFunction == "" {
+		e.timeWindowFunction = view.FUNCTION_MOVING_AVG
+	}
+	return nil
+}
+
+// parseSmoothedPercentile SmoothedPercentile(metric, level, N)改写为MovingAvg(Percentile(metric, level), N)，
+// 计算层按时间桶求分位数，窗口层按时间滑动求平均，level与Percentile相同
+func (e *CHEngine) parseSmoothedPercentile(expr *sqlparser.FuncExpr) (*sqlparser.FuncExpr, error) {
+	if len(expr.Exprs) != 3 {
+		return nil, fmt.Errorf("function %s requires 3 arguments: metric, percentile and window size", view.FUNCTION_SMOOTHED_PCTL)
+	}
+	levelExpr, ok := expr.Exprs[1].(*sqlparser.AliasedExpr)
+	if !ok || !isNumericLiteral(levelExpr.Expr) {
+		return nil, fmt.Errorf("function %s percentile must be a number, got %s", view.FUNCTION_SMOOTHED_PCTL, sqlparser.String(expr.Exprs[1]))
+	}
+	sizeExpr, ok := expr.Exprs[2].(*sqlparser.AliasedExpr)
+	if !ok {
+		return nil, fmt.Errorf("function %s window size must be a positive integer, got %s", view.FUNCTION_SMOOTHED_PCTL, sqlparser.String(expr.Exprs[2]))
+	}
+	if size, err := strconv.Atoi(sqlparser.String(sizeExpr.Expr)); err != nil || size <= 0 {
+		return nil, fmt.Errorf("function %s window size must be a positive integer, got %s", view.FUNCTION_SMOOTHED_PCTL, sqlparser.String(sizeExpr.Expr))
+	}
+	e.timeWindowFunction = view.FUNCTION_SMOOTHED_PCTL
+	percentile := &sqlparser.FuncExpr{
+		Name:  sqlparser.NewColIdent(view.FUNCTION_PCTL),
+		Exprs: sqlparser.SelectExprs{expr.Exprs[0], levelExpr},
+	}
+	return &sqlparser.FuncExpr{
+		Name:  sqlparser.NewColIdent(view.FUNCTION_MOVING_AVG),
+		Exprs: sqlparser.SelectExprs{&sqlparser.AliasedExpr{Expr: percentile}, sizeExpr},
+	}, nil
+}
+
+// parseErrorRatio ErrorRatio()改写为Sum(error)/Sum(log_count)，除数为0时结果为NULL
+func (e *CHEngine) parseErrorRatio(expr *sqlparser.FuncExpr) (sqlparser.Expr, error) {
+	if len(expr.Exprs) != 0 {
+		return nil, fmt.Errorf("function %s takes no arguments", view.FUNCTION_ERROR_RATIO)
+	}
+	sums := []sqlparser.Expr{}
+	for _, metric := range []string{"error", metrics.LOG_COUNT_METRICS_NAME} {
+		if _, ok := metrics.GetAggMetrics(metric, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics); !ok {
+			return nil, fmt.Errorf("function %s is not supported in table %s", view.FUNCTION_ERROR_RATIO, e.Table)
+		}
+		sums = append(sums, &sqlparser.FuncExpr{
+			Name:  sqlparser.NewColIdent(view.FUNCTION_SUM),
+			Exprs: sqlparser.SelectExprs{&sqlparser.AliasedExpr{Expr: &sqlparser.ColName{Name: sqlparser.NewColIdent(metric)}}},
+		})
+	}
+	return &sqlparser.BinaryExpr{Operator: sqlparser.DivStr, Left: sums[0], Right: sums[1]}, nil
+}
+
 func (e *CHEngine) AddGroup(group string) error {
 	stmts, err := GetGroup(group, e)
 	if err != nil {
@@ -1931,6 +2991,16 @@ func (e *CHEngine) AddTable(table string) {
 	e.Statements = append(e.Statements, stmt)
 }
 
+func (e *CHEngine) AddTableWithFinal(table string, final bool) {
+	stmt := &Table{Value: table, Final: final}
+	e.Statements = append(e.Statements, stmt)
+}
+
+func (e *CHEngine) AddTableWithAlias(table string, alias string, final bool) {
+	stmt := &Table{Value: table, Alias: alias, Final: final}
+	e.Statements = append(e.Statements, stmt)
+}
+
 func (e *CHEngine) AddTag(tag string, alias string) (string, error) {
 
 	stmts, labelType, err := GetTagTranslator(tag, alias, e)
@@ -1994,10 +3064,16 @@ func (e *CHEngine) parseWhere(node sqlparser.Expr, w *Where, isCheck bool) (view
 		op := view.Operator{Type: view.OR}
 		return &view.BinaryExpr{Left: left, Right: right, Op: &op}, nil
 	case *sqlparser.NotExpr:
+		w.negated++
 		expr, err := e.parseWhere(node.Expr, w, isCheck)
+		w.negated--
 		if err != nil {
 			return expr, err
 		}
+		// NOT (...)，对条件组取反
+		if nested, ok := expr.(*view.Nested); ok {
+			return &view.Not{Expr: nested.Expr}, nil
+		}
 		op := view.Operator{Type: view.NOT}
 		return &view.UnaryExpr{Op: &op, Expr: expr}, nil
 	case *sqlparser.ParenExpr: // 括号
@@ -2017,12 +3093,17 @@ func (e *CHEngine) parseWhere(node sqlparser.Expr, w *Where, isCheck bool) (view
 		switch comparExpr.(type) {
 		case *sqlparser.ColName, *sqlparser.SQLVal:
 			whereTag := chCommon.ParseAlias(node.Left)
+			// having中引用select中函数的别名时，直接使用别名，避免重复生成聚合
+			if _, ok := e.AsFuncMap[whereTag]; ok && w.isHaving {
+				filter := fmt.Sprintf("`%s` %s %s", strings.Trim(whereTag, "`"), node.Operator, sqlparser.String(node.Right))
+				return &view.Expr{Value: filter}, nil
+			}
 			metricStruct, ok := metrics.GetMetrics(whereTag, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics)
 			if ok && metricStruct.Type != metrics.METRICS_TYPE_TAG {
 				whereTag = metricStruct.DBField
 			}
 			whereValue := sqlparser.String(node.Right)
-			stmt := GetWhere(whereTag, whereValue)
+			stmt := GetWhere(whereTag, whereValue, e.DB)
 			return stmt.Trans(node, w, e)
 		case *sqlparser.FuncExpr, *sqlparser.BinaryExpr:
 			function, err := e.parseSelectBinaryExpr(comparExpr)
@@ -2037,19 +3118,46 @@ func (e *CHEngine) parseWhere(node sqlparser.Expr, w *Where, isCheck bool) (view
 			return stmt.Trans(node, w, e)
 		}
 	case *sqlparser.IsExpr:
-		if isCheck {
-			return nil, nil
-		}
 		operator := strings.ToLower(node.Operator)
 		if operator != sqlparser.IsNullStr && operator != sqlparser.IsNotNullStr {
 			return nil, errors.New(fmt.Sprintf("parse where error: %s(%T)", sqlparser.String(node), node))
 		}
+		isExpr := node.Expr
+		if parenExpr, ok := isExpr.(*sqlparser.ParenExpr); ok {
+			isExpr = parenExpr.Expr
+		}
+		switch isExpr.(type) {
+		case *sqlparser.FuncExpr, *sqlparser.BinaryExpr:
+			// 聚合结果判空，如Apdex可能输出null
+			function, err := e.parseSelectBinaryExpr(isExpr)
+			if err != nil {
+				return nil, err
+			}
+			if isCheck {
+				return nil, nil
+			}
+			outfunc := function.Trans(e.Model)
+			w.withs = append(w.withs, outfunc.GetWiths()...)
+			return &view.IsNullExpr{Expr: outfunc, Not: operator == sqlparser.IsNotNullStr}, nil
+		}
+		if isCheck {
+			return nil, nil
+		}
 		whereTag := chCommon.ParseAlias(node.Expr)
+		// having中引用select中函数的别名时，直接使用别名
+		if _, ok := e.AsFuncMap[whereTag]; ok && w.isHaving {
+			aliasExpr := &view.Expr{Value: fmt.Sprintf("`%s`", strings.Trim(whereTag, "`"))}
+			return &view.IsNullExpr{Expr: aliasExpr, Not: operator == sqlparser.IsNotNullStr}, nil
+		}
+		// 不同表中未知值可能为0或''，按db_descriptions的标注改写
+		if filter, ok, err := GetTagNullFilter(whereTag, operator == sqlparser.IsNotNullStr, w, e); ok || err != nil {
+			return filter, err
+		}
 		metricStruct, ok := metrics.GetMetrics(whereTag, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics)
 		if ok && metricStruct.Type != metrics.METRICS_TYPE_TAG {
 			whereTag = metricStruct.DBField
 		}
-		stmt := GetWhere(whereTag, "NULL")
+		stmt := GetWhere(whereTag, "NULL", e.DB)
 		filterNode, err := stmt.Trans(&sqlparser.ComparisonExpr{
 			Left:     node.Expr,
 			Operator: "=",
@@ -2080,11 +3188,47 @@ func (e *CHEngine) parseWhere(node sqlparser.Expr, w *Where, isCheck bool) (view
 			return nil, nil
 		}
 		return &view.Expr{Value: "(" + whereFilter + ")"}, nil
-
+	case *sqlparser.RangeCond:
+		expr, err := e.transRangeCond(node, w)
+		if err != nil {
+			return nil, err
+		}
+		return e.parseWhere(expr, w, isCheck)
+	case *sqlparser.BinaryExpr:
+		// ip_0 << '10.0.0.0/8'，网段匹配
+		if node.Operator == CIDR_MATCH_OPERATOR {
+			if _, ok := node.Left.(*sqlparser.ColName); ok {
+				whereTag := strings.Trim(chCommon.ParseAlias(node.Left), "`")
+				filter, err := TransCIDRMatchFilter(whereTag, sqlparser.String(node.Right))
+				if err != nil {
+					return nil, err
+				}
+				return &view.Expr{Value: filter}, nil
+			}
+		}
 	}
 	return nil, errors.New(fmt.Sprintf("parse where error: %s(%T)", sqlparser.String(node), node))
 }
 
+// transRangeCond BETWEEN改写为带括号的>=及<=，两端都包含，NOT BETWEEN改写为对其取反，不修改时间范围
+func (e *CHEngine) transRangeCond(node *sqlparser.RangeCond, w *Where) (sqlparser.Expr, error) {
+	if colName, ok := node.Left.(*sqlparser.ColName); ok {
+		name := strings.Trim(chCommon.ParseAlias(colName), "`")
+		_, isAlias := e.AsFuncMap[name]
+		if !(isAlias && w.isHaving) && e.tagColumnType(name) == COLUMN_TYPE_STRING {
+			return nil, fmt.Errorf("%s does not support string tag %s", node.Operator, name)
+		}
+	}
+	expr := &sqlparser.ParenExpr{Expr: &sqlparser.AndExpr{
+		Left:  &sqlparser.ComparisonExpr{Operator: sqlparser.GreaterEqualStr, Left: node.Left, Right: node.From},
+		Right: &sqlparser.ComparisonExpr{Operator: sqlparser.LessEqualStr, Left: node.Left, Right: node.To},
+	}}
+	if node.Operator == sqlparser.NotBetweenStr {
+		return &sqlparser.NotExpr{Expr: expr}, nil
+	}
+	return expr, nil
+}
+
 func (e *CHEngine) parseTimeWhere(node sqlparser.Expr, w *Where) (view.Node, error) {
 	switch node := node.(type) {
 	case *sqlparser.AndExpr:
@@ -2110,12 +3254,25 @@ func (e *CHEngine) parseTimeWhere(node sqlparser.Expr, w *Where) (view.Node, err
 		op := view.Operator{Type: view.OR}
 		return &view.BinaryExpr{Left: left, Right: right, Op: &op}, nil
 	case *sqlparser.NotExpr:
+		w.negated++
 		expr, err := e.parseTimeWhere(node.Expr, w)
+		w.negated--
 		if err != nil {
 			return expr, err
 		}
 		op := view.Operator{Type: view.NOT}
 		return &view.UnaryExpr{Op: &op, Expr: expr}, nil
+	case *sqlparser.ParenExpr:
+		return e.parseTimeWhere(node.Expr, w)
+	case *sqlparser.RangeCond:
+		if chCommon.ParseAlias(node.Left) != "time" {
+			return nil, nil
+		}
+		expr, err := e.transRangeCond(node, w)
+		if err != nil {
+			return nil, err
+		}
+		return e.parseTimeWhere(expr, w)
 	case *sqlparser.ComparisonExpr:
 		var comparExpr sqlparser.Expr
 		switch expr := node.Left.(type) {
@@ -2129,7 +3286,7 @@ func (e *CHEngine) parseTimeWhere(node sqlparser.Expr, w *Where) (view.Node, err
 			whereTag := chCommon.ParseAlias(node.Left)
 			if whereTag == "time" {
 				whereValue := sqlparser.String(node.Right)
-				stmt := GetWhere(whereTag, whereValue)
+				stmt := GetWhere(whereTag, whereValue, e.DB)
 				return stmt.Trans(node, w, e)
 			}
 		}
@@ -2178,6 +3335,20 @@ func LoadDbDescriptions(dbDescriptions map[string]interface{}) error {
 	} else {
 		return errors.New("clickhouse not has tag")
 	}
+	// 加载各数据库的时间列名，未配置时使用time
+	if timeColumnData, ok := dbDataMap["time_column"]; ok {
+		err := chCommon.LoadTimeColumns(timeColumnData.([][]interface{}))
+		if err != nil {
+			return err
+		}
+	}
+	// 加载预聚合表中指标量的聚合方式
+	if preAggregationData, ok := dbDataMap["pre_aggregation"]; ok {
+		err := metrics.LoadPreAggregations(preAggregationData.([][]interface{}))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -2186,12 +3357,18 @@ func FormatModel(m *view.Model) {
 	FormatLimit(m)
 }
 
+// FormatLimit 查询未指定LIMIT时由View在最外层使用默认值
 func FormatLimit(m *view.Model) {
-	if m.Limit.Limit == "" {
-		defaultLimit := DEFAULT_LIMIT
-		if config.Cfg != nil {
-			defaultLimit = config.Cfg.Limit
-		}
-		m.Limit.Limit = defaultLimit
+	m.DefaultLimit = defaultLimit(m.DefaultLimit)
+}
+
+// defaultLimit 未按库配置默认LIMIT时使用全局limit
+func defaultLimit(limit string) string {
+	if limit != "" {
+		return limit
+	}
+	if config.Cfg != nil {
+		return config.Cfg.Limit
 	}
+	return DEFAULT_LIMIT
 }
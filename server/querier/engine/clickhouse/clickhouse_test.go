@@ -18,23 +18,30 @@ package clickhouse
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"bou.ke/monkey"
 	"github.com/jarcoal/httpmock"
+	"github.com/xwb1989/sqlparser"
 
 	//"github.com/k0kubun/pp"
 
 	"github.com/deepflowio/deepflow/server/querier/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/client"
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/metrics"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
 	"github.com/deepflowio/deepflow/server/querier/parse"
 )
 
@@ -48,18 +55,22 @@ import (
 
 var (
 	parseSQL = []struct {
-		name       string
-		input      string
-		output     []string
-		db         string
-		datasource string
-		wantErr    string
+		name           string
+		input          string
+		output         []string
+		db             string
+		datasource     string
+		wantErr        string
+		noDivGuard     bool
+		allowRawExpr   bool
+		timestampMilli bool
+		maxOffset      int
 	}{{
 		input:  "select byte from l4_flow_log limit 1",
 		output: []string{"SELECT byte_tx+byte_rx AS `byte` FROM flow_log.`l4_flow_log` LIMIT 1"},
 	}, {
 		input:  "select Sum(byte)/Time_interval as sum_byte, time(time, 120) as time_120 from l4_flow_log group by time_120 having Sum(byte)>=0 limit 10 offset 20",
-		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_time_120` SELECT toUnixTimestamp(`_time_120`) AS `time_120`, divide(SUM(byte_tx+byte_rx), 120) AS `sum_byte` FROM flow_log.`l4_flow_log` GROUP BY `time_120` HAVING SUM(byte_tx+byte_rx) >= 0 LIMIT 20, 10"},
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_time_120` SELECT divide(SUM(byte_tx+byte_rx), 120) AS `sum_byte`, toUnixTimestamp(`_time_120`) AS `time_120` FROM flow_log.`l4_flow_log` GROUP BY `time_120` HAVING SUM(byte_tx+byte_rx) >= 0 LIMIT 20, 10"},
 	}, {
 		input:  "select Sum(log_count) as sum_log_count from l4_flow_log order by sum_log_count desc limit 1",
 		output: []string{"SELECT SUM(1) AS `sum_log_count` FROM flow_log.`l4_flow_log` ORDER BY `sum_log_count` desc LIMIT 1"},
@@ -99,6 +110,15 @@ var (
 	}, {
 		input:  "select Percentile(byte_tx, 50) as percentile_byte_tx from l4_flow_log limit 1",
 		output: []string{"SELECT quantile(50)(byte_tx) AS `percentile_byte_tx` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "percentile_exact",
+		input:  "select PercentileExact(byte_tx, 99) as percentile_exact_byte_tx from l4_flow_log limit 1",
+		output: []string{"SELECT quantileExact(99)(byte_tx) AS `percentile_exact_byte_tx` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "percentile_exact_layered",
+		input:  "select PercentileExact(byte_tx, 99) as p99_byte_tx, region_0 from vtap_flow_edge_port group by region_0 limit 1",
+		output: []string{"SELECT quantileExact(99)(`_sum_byte_tx`) AS `p99_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
 	}, {
 		input:  "select Avg(rtt) as avg_rtt from l4_flow_log where time >= 100+1 and time <= 102 limit 1",
 		output: []string{"SELECT AVGIf(rtt, rtt > 0) AS `avg_rtt` FROM flow_log.`l4_flow_log` WHERE `time` >= 100 + 1 AND `time` <= 102 LIMIT 1"},
@@ -113,22 +133,55 @@ var (
 		output: []string{"SELECT MAX(byte_tx) AS `max_byte_tx`, AVGIf(rtt, rtt > 0) AS `aavg_rtt` FROM flow_log.`l4_flow_log` LIMIT 1"},
 	}, {
 		input:  "select ((Max(byte_tx))+Avg(rtt ))/(1-Avg(rtt )) as avg_rtt from l4_flow_log limit 1",
-		output: []string{"SELECT divide(plus(MAX(byte_tx), AVGIf(rtt, rtt > 0)), minus(1, AVGIf(rtt, rtt > 0))) AS `avg_rtt` FROM flow_log.`l4_flow_log` LIMIT 1"},
+		output: []string{"WITH if(minus(1, AVGIf(rtt, rtt > 0))>0, divide(plus(MAX(byte_tx), AVGIf(rtt, rtt > 0)), minus(1, AVGIf(rtt, rtt > 0))), null) AS `divide_0diveider_as_null_plus__max_byte_tx__avg_rtt_minus_1__avg_rtt` SELECT `divide_0diveider_as_null_plus__max_byte_tx__avg_rtt_minus_1__avg_rtt` AS `avg_rtt` FROM flow_log.`l4_flow_log` LIMIT 1"},
 	}, {
 		input:  "select ((Max(byte_tx))+AAvg(rtt ))/(1-AAvg(rtt )) as aavg_rtt from l4_flow_log limit 1",
-		output: []string{"SELECT divide(plus(MAX(byte_tx), AVGIf(rtt, rtt > 0)), minus(1, AVGIf(rtt, rtt > 0))) AS `aavg_rtt` FROM flow_log.`l4_flow_log` LIMIT 1"},
+		output: []string{"WITH if(minus(1, AVGIf(rtt, rtt > 0))>0, divide(plus(MAX(byte_tx), AVGIf(rtt, rtt > 0)), minus(1, AVGIf(rtt, rtt > 0))), null) AS `divide_0diveider_as_null_plus__max_byte_tx__avg_rtt_minus_1__avg_rtt` SELECT `divide_0diveider_as_null_plus__max_byte_tx__avg_rtt_minus_1__avg_rtt` AS `aavg_rtt` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "div_zero_guard",
+		input:  "select Sum(byte_tx)/Sum(packet_tx) as bpp from l4_flow_log limit 1",
+		output: []string{"WITH if(SUM(packet_tx)>0, divide(SUM(byte_tx), SUM(packet_tx)), null) AS `divide_0diveider_as_null_sum_byte_tx_sum_packet_tx` SELECT `divide_0diveider_as_null_sum_byte_tx_sum_packet_tx` AS `bpp` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "div_zero_guard_nested",
+		input:  "select Sum(byte_tx)/Sum(packet_tx)/Sum(packet_rx) as bpp from l4_flow_log limit 1",
+		output: []string{"WITH if(SUM(packet_tx)>0, divide(SUM(byte_tx), SUM(packet_tx)), null) AS `divide_0diveider_as_null_sum_byte_tx_sum_packet_tx`, if(SUM(packet_rx)>0, divide(`divide_0diveider_as_null_sum_byte_tx_sum_packet_tx`, SUM(packet_rx)), null) AS `divide_0diveider_as_null_div__sum_byte_tx__sum_packet_tx_sum_packet_rx` SELECT `divide_0diveider_as_null_div__sum_byte_tx__sum_packet_tx_sum_packet_rx` AS `bpp` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:       "div_zero_guard_disabled",
+		input:      "select Sum(byte_tx)/Sum(packet_tx)/Sum(packet_rx) as bpp from l4_flow_log limit 1",
+		output:     []string{"SELECT divide(divide(SUM(byte_tx), SUM(packet_tx)), SUM(packet_rx)) AS `bpp` FROM flow_log.`l4_flow_log` LIMIT 1"},
+		noDivGuard: true,
 	}, {
 		input:  "select Apdex(rtt, 100) as apdex_rtt_100 from l4_flow_log limit 1",
 		output: []string{"WITH if(COUNT()>0, divide(plus(SUM(if(rtt<=100,1,0)), SUM(if(100<rtt AND rtt<=100*4,0.5,0))), COUNT()), null) AS `divide_0diveider_as_null_plus_apdex_satisfy_rtt_100_apdex_toler_rtt_100_count_` SELECT `divide_0diveider_as_null_plus_apdex_satisfy_rtt_100_apdex_toler_rtt_100_count_`*100 AS `apdex_rtt_100` FROM flow_log.`l4_flow_log` LIMIT 1"},
 	}, {
 		input:  "select Max(byte) as max_byte, time(time,120) as time_120 from l4_flow_log group by time_120 having Min(byte)>=0 limit 1",
-		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_time_120` SELECT toUnixTimestamp(`_time_120`) AS `time_120`, MAX(byte_tx+byte_rx) AS `max_byte` FROM flow_log.`l4_flow_log` GROUP BY `time_120` HAVING MIN(byte_tx+byte_rx) >= 0 LIMIT 1"},
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_time_120` SELECT MAX(byte_tx+byte_rx) AS `max_byte`, toUnixTimestamp(`_time_120`) AS `time_120` FROM flow_log.`l4_flow_log` GROUP BY `time_120` HAVING MIN(byte_tx+byte_rx) >= 0 LIMIT 1"},
 	}, {
 		input:  "select Max(byte) as max_byte, time(time,86400) as time_120 from l4_flow_log group by time_120 having Min(byte)>=0 limit 1",
-		output: []string{"WITH toStartOfInterval(time, toIntervalDay(1)) + toIntervalDay(arrayJoin([0]) * 1) AS `_time_120` SELECT toUnixTimestamp(`_time_120`) AS `time_120`, MAX(byte_tx+byte_rx) AS `max_byte` FROM flow_log.`l4_flow_log` GROUP BY `time_120` HAVING MIN(byte_tx+byte_rx) >= 0 LIMIT 1"},
+		output: []string{"WITH toStartOfInterval(time, toIntervalDay(1)) + toIntervalDay(arrayJoin([0]) * 1) AS `_time_120` SELECT MAX(byte_tx+byte_rx) AS `max_byte`, toUnixTimestamp(`_time_120`) AS `time_120` FROM flow_log.`l4_flow_log` GROUP BY `time_120` HAVING MIN(byte_tx+byte_rx) >= 0 LIMIT 1"},
+	}, {
+		name:   "time_unit_hour",
+		input:  "select Max(byte) as max_byte, time(time, 2, 'hour') as time_2h from l4_flow_log group by time_2h limit 1",
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(7200)) + toIntervalSecond(arrayJoin([0]) * 7200) AS `_time_2h` SELECT MAX(byte_tx+byte_rx) AS `max_byte`, toUnixTimestamp(`_time_2h`) AS `time_2h` FROM flow_log.`l4_flow_log` GROUP BY `time_2h` LIMIT 1"},
+	}, {
+		name:   "time_unit_day",
+		input:  "select Max(byte) as max_byte, time(time, 1, 'day') as time_1d from l4_flow_log group by time_1d limit 1",
+		output: []string{"WITH toStartOfDay(time) + toIntervalDay(arrayJoin([0]) * 1) AS `_time_1d` SELECT MAX(byte_tx+byte_rx) AS `max_byte`, toUnixTimestamp(`_time_1d`) AS `time_1d` FROM flow_log.`l4_flow_log` GROUP BY `time_1d` LIMIT 1"},
+	}, {
+		name:   "time_unit_week",
+		input:  "select Max(byte) as max_byte, time(time, 2, 'week', 2) as time_2w from l4_flow_log group by time_2w limit 1",
+		output: []string{"WITH toStartOfInterval(time, toIntervalWeek(2)) + toIntervalWeek(arrayJoin([0,1]) * 2) AS `_time_2w` SELECT MAX(byte_tx+byte_rx) AS `max_byte`, toUnixTimestamp(`_time_2w`) AS `time_2w` FROM flow_log.`l4_flow_log` GROUP BY `time_2w` LIMIT 1"},
+	}, {
+		name:   "time_unit_month",
+		input:  "select Max(byte) as max_byte, time(time, 1, 'month') as time_1mon from l4_flow_log group by time_1mon limit 1",
+		output: []string{"WITH toStartOfMonth(time) + toIntervalMonth(arrayJoin([0]) * 1) AS `_time_1mon` SELECT MAX(byte_tx+byte_rx) AS `max_byte`, toUnixTimestamp(`_time_1mon`) AS `time_1mon` FROM flow_log.`l4_flow_log` GROUP BY `time_1mon` LIMIT 1"},
+	}, {
+		name:    "time_unit_invalid",
+		input:   "select Max(byte) as max_byte, time(time, 1, 'year') as time_1y from l4_flow_log group by time_1y limit 1",
+		wantErr: "time unit 'year' not support",
 	}, {
 		input:  "select Max(byte) as 'max_byte',region_0,chost_1,lb_1 from l4_flow_log group by region_0,chost_1,lb_1 limit 1",
-		output: []string{"WITH if(l3_device_type_1 = 1, l3_device_type_1, 0) AS `device_type_chost_1`, if(l3_device_type_1 = 15, l3_device_type_1, 0) AS `device_type_lb_1` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, dictGet('flow_tag.device_map', 'name', (toUInt64(device_type_chost_1),toUInt64(l3_device_id_1))) AS `chost_1`, device_type_chost_1, dictGet('flow_tag.device_map', 'name', (toUInt64(device_type_lb_1),toUInt64(l3_device_id_1))) AS `lb_1`, device_type_lb_1, MAX(byte_tx+byte_rx) AS `max_byte` FROM flow_log.`l4_flow_log` WHERE (l3_device_id_1!=0 AND l3_device_type_1=1) AND (l3_device_id_1!=0 AND l3_device_type_1=15) GROUP BY `region_id_0`, `l3_device_id_1`, `device_type_chost_1`, `device_type_lb_1` LIMIT 1"},
+		output: []string{"WITH if(l3_device_type_1 = 1, l3_device_type_1, 0) AS `device_type_chost_1`, if(l3_device_type_1 = 15, l3_device_type_1, 0) AS `device_type_lb_1` SELECT MAX(byte_tx+byte_rx) AS `max_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, dictGet('flow_tag.device_map', 'name', (toUInt64(device_type_chost_1),toUInt64(l3_device_id_1))) AS `chost_1`, device_type_chost_1, dictGet('flow_tag.device_map', 'name', (toUInt64(device_type_lb_1),toUInt64(l3_device_id_1))) AS `lb_1`, device_type_lb_1 FROM flow_log.`l4_flow_log` WHERE (l3_device_id_1!=0 AND l3_device_type_1=1) AND (l3_device_id_1!=0 AND l3_device_type_1=15) GROUP BY `region_id_0`, `l3_device_id_1`, `device_type_chost_1`, `device_type_lb_1` LIMIT 1"},
 	}, {
 		input:  "select Percentage(Max(byte)+100,100) as percentage_max_byte_100 from l4_flow_log limit 1",
 		output: []string{"SELECT divide(plus(MAX(byte_tx+byte_rx), 100), 100)*100 AS `percentage_max_byte_100` FROM flow_log.`l4_flow_log` LIMIT 1"},
@@ -159,15 +212,94 @@ var (
 	}, {
 		input:  "SELECT ip_0 FROM l4_flow_log WHERE  ((is_internet_1=1) OR (is_internet_0=1)) GROUP BY ip_0 limit 1",
 		output: []string{"SELECT if(is_ipv4=1, IPv4NumToString(ip4_0), IPv6NumToString(ip6_0)) AS `ip_0` FROM flow_log.`l4_flow_log` WHERE (((l3_epc_id_1 = -2)) OR ((l3_epc_id_0 = -2))) GROUP BY `is_ipv4`, `ip4_0`, `ip6_0` LIMIT 1"},
+	}, {
+		name:   "group_ip_unlayered",
+		input:  "select ip_0, Sum(byte) as sum_byte from l4_flow_log group by ip_0 limit 10",
+		output: []string{"SELECT if(is_ipv4=1, IPv4NumToString(ip4_0), IPv6NumToString(ip6_0)) AS `ip_0`, SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` GROUP BY `is_ipv4`, `ip4_0`, `ip6_0` LIMIT 10"},
 	}, {
 		input:  "select Sum(byte) as `流量总量`, region_0 as `区域` from l4_flow_log where 1=1 group by `区域` order by `流量总量` desc",
-		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `区域`, SUM(byte_tx+byte_rx) AS `流量总量` FROM flow_log.`l4_flow_log` WHERE 1 = 1 GROUP BY `区域` ORDER BY `流量总量` desc LIMIT 10000"},
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `流量总量`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `区域` FROM flow_log.`l4_flow_log` WHERE 1 = 1 GROUP BY `区域` ORDER BY `流量总量` desc LIMIT 10000"},
 	}, {
 		input:  "select byte as `123` from l4_flow_log where 1=1 group by `123` order by `123` limit 1 ",
 		output: []string{"SELECT byte_tx+byte_rx AS `123` FROM flow_log.`l4_flow_log` WHERE 1 = 1 GROUP BY `123` ORDER BY `123` asc LIMIT 1"},
+	}, {
+		name:   "order_nulls_last",
+		input:  "select Sum(byte) as sum_byte, region_0 as region_name from l4_flow_log group by region_name order by region_name desc nulls last limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_name` FROM flow_log.`l4_flow_log` GROUP BY `region_name` ORDER BY `region_name` desc NULLS LAST LIMIT 1"},
+	}, {
+		name:   "order_nulls_first",
+		input:  "select Sum(byte) as sum_byte, region_0 as region_name from l4_flow_log group by region_name order by region_name asc NULLS FIRST limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_name` FROM flow_log.`l4_flow_log` GROUP BY `region_name` ORDER BY `region_name` asc NULLS FIRST LIMIT 1"},
+	}, {
+		name:   "order_collate",
+		input:  "select Sum(byte) as sum_byte, region_0 as region_name from l4_flow_log group by region_name order by region_name collate 'zh' limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_name` FROM flow_log.`l4_flow_log` GROUP BY `region_name` ORDER BY `region_name` asc COLLATE 'zh' LIMIT 1"},
+	}, {
+		name:   "order_nulls_collate",
+		input:  "select Sum(byte) as sum_byte, region_0 as region_name from l4_flow_log group by region_name order by region_name desc collate 'zh' nulls last, sum_byte desc nulls first limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_name` FROM flow_log.`l4_flow_log` GROUP BY `region_name` ORDER BY `region_name` desc NULLS LAST COLLATE 'zh',`sum_byte` desc NULLS FIRST LIMIT 1"},
+	}, {
+		name:   "order_modifier_dedup",
+		input:  "select Sum(byte) as sum_byte, region_0 as region_name from l4_flow_log group by region_name order by region_name desc nulls first, region_name desc nulls last, region_name desc nulls last limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_name` FROM flow_log.`l4_flow_log` GROUP BY `region_name` ORDER BY `region_name` desc NULLS FIRST,`region_name` desc NULLS LAST LIMIT 1"},
+	}, {
+		name:   "order_modifier_quoted",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log where region_0 = 'order by a nulls last, b' limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'order by a nulls last, b')) LIMIT 1"},
+	}, {
+		name:   "order_nulls_last_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port group by region_0 order by region_0 desc nulls last limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` ORDER BY `region_0` desc NULLS LAST LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "order_by_not_selected_tag",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log group by region_0 order by region_0 desc limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` ORDER BY dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) desc LIMIT 1"},
+	}, {
+		name:   "order_by_not_selected_tag_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx from vtap_flow_edge_port group by region_0 order by region_0 desc limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx` FROM (SELECT region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0` ORDER BY dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) desc LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "order_by_selected_metric",
+		input:  "select region_0, Sum(byte) from l4_flow_log group by region_0 order by Sum(byte) desc limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, SUM(byte_tx+byte_rx) AS `Sum(byte)` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` ORDER BY `Sum(byte)` desc LIMIT 1"},
+	}, {
+		name:   "order_by_not_selected_metric",
+		input:  "select region_0 from l4_flow_log group by region_0 order by Sum(byte) desc limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` ORDER BY SUM(byte_tx+byte_rx) desc LIMIT 1"},
+	}, {
+		name:   "order_by_not_selected_metric_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port group by region_0 order by Sum(byte_rx) desc limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_rx) AS `_sum_byte_rx`, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` ORDER BY SUM(`_sum_byte_rx`) desc LIMIT 1"},
+		db:     "flow_metrics",
 	}, {
 		input:  "select byte from l4_flow_log where ip>=('1.1.1.1/24','2.2.2.2') and ip<='::/24'",
 		output: []string{"SELECT byte_tx+byte_rx AS `byte` FROM flow_log.`l4_flow_log` WHERE (((if(is_ipv4=1, ip4 >= toIPv4OrNull('1.1.1.255'), ip6 >= toIPv6OrNull('1.1.1.255'))) OR (if(is_ipv4=1, ip4 >= toIPv4OrNull('2.2.2.2'), ip6 >= toIPv6OrNull('2.2.2.2'))))) AND (((if(is_ipv4=1, ip4 <= toIPv4OrNull('::'), ip6 <= toIPv6OrNull('::'))))) LIMIT 10000"},
+	}, {
+		name:   "cidr_match_v4",
+		input:  "select byte from l4_flow_log where ip_0 << '10.1.2.3/8'",
+		output: []string{"SELECT byte_tx+byte_rx AS `byte` FROM flow_log.`l4_flow_log` WHERE (is_ipv4 = 1 AND ip4_0 BETWEEN toIPv4('10.0.0.0') AND toIPv4('10.255.255.255')) LIMIT 10000"},
+	}, {
+		name:   "cidr_match_v4_host",
+		input:  "select byte from l4_flow_log where not ip_1 << '10.1.2.3/32'",
+		output: []string{"SELECT byte_tx+byte_rx AS `byte` FROM flow_log.`l4_flow_log` WHERE NOT (is_ipv4 = 1 AND ip4_1 BETWEEN toIPv4('10.1.2.3') AND toIPv4('10.1.2.3')) LIMIT 10000"},
+	}, {
+		name:   "cidr_match_v6_all",
+		input:  "select byte from l4_flow_log where ip << '::/0'",
+		output: []string{"SELECT byte_tx+byte_rx AS `byte` FROM flow_log.`l4_flow_log` WHERE (is_ipv4 = 0 AND ip6 BETWEEN toIPv6('::') AND toIPv6('ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff')) LIMIT 10000"},
+	}, {
+		name:   "cidr_match_v6",
+		input:  "select byte from l4_flow_log where ip_0 << '2001:db8:1:2::/64'",
+		output: []string{"SELECT byte_tx+byte_rx AS `byte` FROM flow_log.`l4_flow_log` WHERE (is_ipv4 = 0 AND ip6_0 BETWEEN toIPv6('2001:db8:1:2::') AND toIPv6('2001:db8:1:2:ffff:ffff:ffff:ffff')) LIMIT 10000"},
+	}, {
+		name:    "cidr_match_invalid",
+		input:   "select byte from l4_flow_log where ip_0 << '10.0.0.0/33'",
+		wantErr: "invalid cidr '10.0.0.0/33'",
+	}, {
+		name:    "cidr_match_not_ip",
+		input:   "select byte from l4_flow_log where server_port << '10.0.0.0/8'",
+		wantErr: "operator << only supports tags ip, ip_0, ip_1, got server_port",
 	}, {
 		input:  "select `k8s.label.statefulset.kubernetes.io/pod-name_0` from l4_flow_log where `k8s.label.statefulset.kubernetes.io/pod-name_0`='opensource-loki-0' group by `k8s.label.statefulset.kubernetes.io/pod-name_0`",
 		output: []string{"SELECT if(dictGet('flow_tag.pod_service_k8s_label_map', 'value', (toUInt64(service_id_0),'statefulset.kubernetes.io/pod-name'))!='', dictGet('flow_tag.pod_service_k8s_label_map', 'value', (toUInt64(service_id_0),'statefulset.kubernetes.io/pod-name')), dictGet('flow_tag.pod_k8s_label_map', 'value', (toUInt64(pod_id_0),'statefulset.kubernetes.io/pod-name')) ) AS `k8s.label.statefulset.kubernetes.io/pod-name_0` FROM flow_log.`l4_flow_log` WHERE ((toUInt64(service_id_0) GLOBAL IN (SELECT id FROM flow_tag.pod_service_k8s_label_map WHERE value = 'opensource-loki-0' and key='statefulset.kubernetes.io/pod-name')) OR (toUInt64(pod_id_0) GLOBAL IN (SELECT id FROM flow_tag.pod_k8s_label_map WHERE value = 'opensource-loki-0' and key='statefulset.kubernetes.io/pod-name'))) GROUP BY `k8s.label.statefulset.kubernetes.io/pod-name_0` LIMIT 10000"},
@@ -217,16 +349,291 @@ var (
 		output: []string{"WITH dictGetOrDefault('flow_tag.string_enum_map', 'name_en', ('observation_point',observation_point), observation_point) AS `Enum(tap_side)` SELECT `Enum(tap_side)` FROM flow_log.`l7_flow_log` LIMIT 0, 50"},
 	}, {
 		input:  "select AAvg(`byte_tx`) AS `AAvg(byte_tx)`,icon_id(chost_0) as `xx`,region_0 from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
-		output: []string{"SELECT `xx`, region_0, AVG(`_sum_byte_tx`) AS `AAvg(byte_tx)` FROM (WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `xx` SELECT `xx`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `xx`, `region_id_0`) GROUP BY `xx`, `region_id_0`, `region_0` LIMIT 1"},
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `AAvg(byte_tx)`, `xx`, region_0 FROM (WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `xx` SELECT `xx`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `xx`, `region_id_0`) GROUP BY `xx`, `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "grouping_sets",
+		input:  "select Sum(byte) as sum_byte, region_0, az_0 from l4_flow_log group by grouping sets ((region_0), (az_0), ()) limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, dictGet('flow_tag.az_map', 'name', (toUInt64(az_id_0))) AS `az_0` FROM flow_log.`l4_flow_log` GROUP BY GROUPING SETS ((`region_id_0`), (`az_id_0`), ()) LIMIT 1"},
+	}, {
+		name:   "grouping_sets_quoted",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log where region_0 = 'group by grouping sets ((a), ())' limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'group by grouping sets ((a), ())')) LIMIT 1"},
+	}, {
+		name:   "grouping_sets_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0, az_0 from vtap_flow_edge_port group by grouping sets ((region_0), (az_0), ()) limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0, az_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, dictGet('flow_tag.az_map', 'name', (toUInt64(az_id_0))) AS `az_0`, region_id_0, az_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`, `az_id_0`) GROUP BY GROUPING SETS ((`region_id_0`, `region_0`), (`az_id_0`, `az_0`), ()) LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "with_totals",
+		input:  "select Sum(byte) as sum_byte, region_0 from l4_flow_log group by region_0 with totals order by sum_byte desc limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` WITH TOTALS ORDER BY `sum_byte` desc LIMIT 1"},
+	}, {
+		name:   "with_totals_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port group by region_0 WITH TOTALS limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` WITH TOTALS LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "with_totals_grouping_sets",
+		input:  "select Sum(byte) as sum_byte, region_0, az_0 from l4_flow_log group by grouping sets ((region_0), (az_0)) with totals limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, dictGet('flow_tag.az_map', 'name', (toUInt64(az_id_0))) AS `az_0` FROM flow_log.`l4_flow_log` GROUP BY GROUPING SETS ((`region_id_0`), (`az_id_0`)) WITH TOTALS LIMIT 1"},
+	}, {
+		name:   "with_totals_quoted",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log where region_0 = 'with totals' limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'with totals')) LIMIT 1"},
+	}, {
+		name:    "with_totals_without_group_by",
+		input:   "select Sum(byte) as sum_byte from l4_flow_log with totals limit 1",
+		wantErr: "with totals requires group by",
+	}, {
+		name:   "table_final",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log final limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` FINAL LIMIT 1"},
+	}, {
+		name:   "table_final_alias",
+		input:  "select Sum(byte) as sum_byte from `l4_flow_log` as f FINAL where region_0 = 'from a final' limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` AS f FINAL WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'from a final')) LIMIT 1"},
+	}, {
+		name:   "table_function",
+		input:  "select Sum(byte) as sum_byte from cluster('df', l4_flow_log) as f limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM cluster('df', flow_log.`l4_flow_log`) AS f LIMIT 1"},
+	}, {
+		// FINAL只作用于物理表，表函数不输出
+		name:   "table_function_final",
+		input:  "select Sum(byte) as sum_byte from cluster('df', l4_flow_log) final limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM cluster('df', flow_log.`l4_flow_log`) LIMIT 1"},
+	}, {
+		name:   "table_alias",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log as f limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` AS f LIMIT 1"},
+	}, {
+		name:   "table_alias_qualified_columns",
+		input:  "select f.region_0, Sum(f.byte) as sum_byte from l4_flow_log as f where f.region_0 = 'a' group by f.region_0 limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` AS f WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'a')) GROUP BY `region_id_0` LIMIT 1"},
+	}, {
+		name:   "table_final_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port final group by region_0 limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` FINAL GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:      "offset_allowed",
+		input:     "select Sum(byte) as sum_byte from l4_flow_log limit 10 offset 1000",
+		output:    []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1000, 10"},
+		maxOffset: 1000,
+	}, {
+		name:      "offset_rejected",
+		input:     "select Sum(byte) as sum_byte from l4_flow_log limit 10 offset 1000000",
+		wantErr:   "offset 1000000 exceeds max-offset 1000, page by filtering on the last returned row (e.g. time < last_time) instead of offset",
+		maxOffset: 1000,
+	}, {
+		name:      "offset_rejected_comma",
+		input:     "select Sum(byte) as sum_byte from l4_flow_log limit 1000000, 10",
+		wantErr:   "offset 1000000 exceeds max-offset 1000, page by filtering on the last returned row (e.g. time < last_time) instead of offset",
+		maxOffset: 1000,
+	}, {
+		name:   "offset_unlimited",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log limit 10 offset 1000000",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1000000, 10"},
+	}, {
+		name:    "duplicate_alias",
+		input:   "select Sum(byte) as x, Max(byte) as x from l4_flow_log limit 1",
+		wantErr: "duplicate column name x in select: 'Max(byte) as x' conflicts with 'Sum(byte) as x'",
+	}, {
+		name:    "duplicate_alias_column",
+		input:   "select byte, Sum(byte) as byte from l4_flow_log limit 1",
+		wantErr: "duplicate column name byte in select: 'Sum(byte) as byte' conflicts with 'byte'",
+	}, {
+		name:   "distinct_alias",
+		input:  "select Sum(byte) as x, Max(byte) as y from l4_flow_log limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `x`, MAX(byte_tx+byte_rx) AS `y` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "union_all",
+		input:  "select Count(row) as c from l4_flow_log UNION ALL select Count(row) as c from l7_flow_log",
+		output: []string{"(SELECT COUNT(1) AS `c` FROM flow_log.`l4_flow_log` LIMIT 10000) UNION ALL (SELECT COUNT(1) AS `c` FROM flow_log.`l7_flow_log` LIMIT 10000)"},
+	}, {
+		name:   "union_all_final",
+		input:  "select Count(row) as c from l4_flow_log UNION ALL select Count(row) as c from l7_flow_log final",
+		output: []string{"(SELECT COUNT(1) AS `c` FROM flow_log.`l4_flow_log` LIMIT 10000) UNION ALL (SELECT COUNT(1) AS `c` FROM flow_log.`l7_flow_log` FINAL LIMIT 10000)"},
+	}, {
+		name:   "union_all_order_limit",
+		input:  "select Count(row) as c from l4_flow_log limit 5 UNION ALL select Count(row) as c from l7_flow_log order by c desc limit 10",
+		output: []string{"SELECT * FROM ((SELECT COUNT(1) AS `c` FROM flow_log.`l4_flow_log` LIMIT 5) UNION ALL (SELECT COUNT(1) AS `c` FROM flow_log.`l7_flow_log` LIMIT 10000)) ORDER BY `c` desc LIMIT 10"},
+	}, {
+		name:    "union_all_column_count",
+		input:   "select Count(row) as c from l4_flow_log UNION ALL select Count(row) as c, Sum(byte) as s from l7_flow_log",
+		wantErr: "union all branch 2 has 2 columns, but the first branch has 1",
+	}, {
+		name:    "union_all_column_alias",
+		input:   "select Count(row) as c from l4_flow_log UNION ALL select Count(row) as d from l7_flow_log",
+		wantErr: "union all branch 2 column 1 'd' does not match 'c' of the first branch",
+	}, {
+		name:    "union_distinct",
+		input:   "select Count(row) as c from l4_flow_log UNION select Count(row) as c from l7_flow_log",
+		wantErr: "UNION is not supported, use UNION ALL instead",
+	}, {
+		name:   "having_alias",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log having sum_byte >= 0 limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` HAVING `sum_byte` >= 0 LIMIT 1"},
+	}, {
+		name:   "having_alias_same_as_metric",
+		input:  "select Sum(byte) as byte from l4_flow_log having byte >= 0 limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `byte` FROM flow_log.`l4_flow_log` HAVING `byte` >= 0 LIMIT 1"},
+	}, {
+		name:   "having_alias_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port group by region_0 having aavg_byte_tx >= 0 limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` HAVING `aavg_byte_tx` >= 0 LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "having_alias_expr_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port group by region_0 having aavg_byte_tx/2 >= 0 limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` HAVING divide(`aavg_byte_tx`, 2) >= 0 LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "having_not_selected_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port group by region_0 having Sum(byte_rx) >= 100 limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_rx) AS `_sum_byte_rx`, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` HAVING SUM(`_sum_byte_rx`) >= 100 LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "having_not_selected_time_layered",
+		input:  "select time(time, 120) as toi, AAvg(byte_tx) as aavg_byte_tx from vtap_flow_edge_port group by toi having Sum(byte_rx) >= 100 limit 1",
+		output: []string{"WITH toStartOfInterval(_time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, AVG(`_sum_byte_tx`) AS `aavg_byte_tx` FROM (WITH toStartOfInterval(time, toIntervalSecond(60)) AS `_time` SELECT _time, SUM(byte_rx) AS `_sum_byte_rx`, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map.1m` GROUP BY `_time`) GROUP BY `toi` HAVING SUM(`_sum_byte_rx`) >= 100 LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "having_is_not_null_alias",
+		input:  "select Apdex(rtt, 100) as apdex_rtt_100 from l4_flow_log having apdex_rtt_100 is not null limit 1",
+		output: []string{"WITH if(COUNT()>0, divide(plus(SUM(if(rtt<=100,1,0)), SUM(if(100<rtt AND rtt<=100*4,0.5,0))), COUNT()), null) AS `divide_0diveider_as_null_plus_apdex_satisfy_rtt_100_apdex_toler_rtt_100_count_` SELECT `divide_0diveider_as_null_plus_apdex_satisfy_rtt_100_apdex_toler_rtt_100_count_`*100 AS `apdex_rtt_100` FROM flow_log.`l4_flow_log` HAVING `apdex_rtt_100` IS NOT NULL LIMIT 1"},
+	}, {
+		name:   "having_is_null_alias",
+		input:  "select Apdex(rtt, 100) as apdex_rtt_100 from l4_flow_log having apdex_rtt_100 is null limit 1",
+		output: []string{"WITH if(COUNT()>0, divide(plus(SUM(if(rtt<=100,1,0)), SUM(if(100<rtt AND rtt<=100*4,0.5,0))), COUNT()), null) AS `divide_0diveider_as_null_plus_apdex_satisfy_rtt_100_apdex_toler_rtt_100_count_` SELECT `divide_0diveider_as_null_plus_apdex_satisfy_rtt_100_apdex_toler_rtt_100_count_`*100 AS `apdex_rtt_100` FROM flow_log.`l4_flow_log` HAVING `apdex_rtt_100` IS NULL LIMIT 1"},
+	}, {
+		name:   "having_is_not_null_function",
+		input:  "select Max(byte) as max_byte from l4_flow_log having Apdex(rtt, 100) is not null limit 1",
+		output: []string{"WITH if(COUNT()>0, divide(plus(SUM(if(rtt<=100,1,0)), SUM(if(100<rtt AND rtt<=100*4,0.5,0))), COUNT()), null) AS `divide_0diveider_as_null_plus_apdex_satisfy_rtt_100_apdex_toler_rtt_100_count_` SELECT MAX(byte_tx+byte_rx) AS `max_byte` FROM flow_log.`l4_flow_log` HAVING `divide_0diveider_as_null_plus_apdex_satisfy_rtt_100_apdex_toler_rtt_100_count_`*100 IS NOT NULL LIMIT 1"},
+	}, {
+		name:   "having_is_not_null_alias_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port group by region_0 having aavg_byte_tx is not null limit 1",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` HAVING `aavg_byte_tx` IS NOT NULL LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		input:  "select Avg(`byte_tx`) AS `Avg(byte_tx)`,icon_id(chost_0) as `xx`,region_0 from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
-		output: []string{"WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `xx` SELECT `xx`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, sum(byte_tx)/(121/1) AS `Avg(byte_tx)` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `xx`, `region_id_0` LIMIT 1"},
+		output: []string{"WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `xx` SELECT sum(byte_tx)/(121/1) AS `Avg(byte_tx)`, `xx`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `xx`, `region_id_0` LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		input:  "select Avg(`rtt`) AS `Avg(rtt)`,Max(`byte`) AS `Max(byte)`,region_0 from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
-		output: []string{"SELECT region_0, AVGIf(`_div__sum_rtt_sum__sum_rtt_count`, `_div__sum_rtt_sum__sum_rtt_count` > 0) AS `Avg(rtt)`, MAX(`_sum_byte`) AS `Max(byte)` FROM (WITH if(SUM(rtt_count)>0, divide(SUM(rtt_sum), SUM(rtt_count)), null) AS `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` AS `_div__sum_rtt_sum__sum_rtt_count`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		output: []string{"SELECT AVGIf(`_div__sum_rtt_sum__sum_rtt_count`, `_div__sum_rtt_sum__sum_rtt_count` > 0) AS `Avg(rtt)`, MAX(`_sum_byte`) AS `Max(byte)`, region_0 FROM (WITH if(SUM(rtt_count)>0, divide(SUM(rtt_sum), SUM(rtt_count)), null) AS `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` AS `_div__sum_rtt_sum__sum_rtt_count`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "raw_select",
+		input:  "select Raw(tap_side) from l7_flow_log limit 1",
+		output: []string{"WITH observation_point AS `Raw(tap_side)` SELECT `Raw(tap_side)` FROM flow_log.`l7_flow_log` LIMIT 1"},
+	}, {
+		name:   "raw_where",
+		input:  "select request from l7_flow_log where Raw(tap_side)='c' limit 1",
+		output: []string{"SELECT if(type IN [0, 2],1,0) AS `request` FROM flow_log.`l7_flow_log` WHERE (observation_point = 'c') LIMIT 1"},
+	}, {
+		name:   "raw_group",
+		input:  "select Raw(tap_side), Count(row) as c from l7_flow_log group by Raw(tap_side) limit 1",
+		output: []string{"WITH observation_point AS `Raw(tap_side)` SELECT `Raw(tap_side)`, COUNT(1) AS `c` FROM flow_log.`l7_flow_log` GROUP BY `Raw(tap_side)` LIMIT 1"},
+	}, {
+		name:         "raw_expr_select",
+		input:        "select Raw('toStartOfHour(time)') as hour, Count(row) as c from l4_flow_log group by hour limit 1",
+		output:       []string{"WITH toStartOfHour(time) AS `hour` SELECT `hour`, COUNT(1) AS `c` FROM flow_log.`l4_flow_log` GROUP BY `hour` LIMIT 1"},
+		allowRawExpr: true,
+	}, {
+		name:         "raw_expr_where",
+		input:        "select byte from l4_flow_log where Raw('length(l7_protocol_str)')>0 limit 1",
+		output:       []string{"SELECT byte_tx+byte_rx AS `byte` FROM flow_log.`l4_flow_log` WHERE (length(l7_protocol_str) > 0) LIMIT 1"},
+		allowRawExpr: true,
+	}, {
+		name:    "raw_expr_disabled",
+		input:   "select Raw('toStartOfHour(time)') as hour from l4_flow_log limit 1",
+		wantErr: "function Raw with expression 'toStartOfHour(time)' is disabled",
+	}, {
+		name:    "raw_expr_where_disabled",
+		input:   "select byte from l4_flow_log where Raw('length(l7_protocol_str)')>0 limit 1",
+		wantErr: "function Raw with expression 'length(l7_protocol_str)' is disabled",
+	}, {
+		name:   "bucket_group",
+		input:  "select Bucket(rtt, 50) as bucketed_rtt, Count(row) as c from l4_flow_log group by bucketed_rtt limit 1",
+		output: []string{"WITH intDiv(rtt, 50) * 50 AS `bucketed_rtt` SELECT `bucketed_rtt`, COUNT(1) AS `c` FROM flow_log.`l4_flow_log` GROUP BY `bucketed_rtt` LIMIT 1"},
+	}, {
+		name:   "bucket_group_expr",
+		input:  "select Bucket(byte, 1000), Count(row) as c from l4_flow_log group by Bucket(byte, 1000) limit 1",
+		output: []string{"WITH intDiv(byte_tx+byte_rx, 1000) * 1000 AS `Bucket(byte, 1000)` SELECT `Bucket(byte, 1000)`, COUNT(1) AS `c` FROM flow_log.`l4_flow_log` GROUP BY `Bucket(byte, 1000)` LIMIT 1"},
+	}, {
+		name:   "group_expr_alias",
+		input:  "select intDiv(server_port, 1000) as port_bucket, Count(row) as c from l4_flow_log group by port_bucket limit 1",
+		output: []string{"SELECT intDiv(server_port, 1000) AS `port_bucket`, COUNT(1) AS `c` FROM flow_log.`l4_flow_log` GROUP BY `port_bucket` LIMIT 1"},
+	}, {
+		name:   "group_expr",
+		input:  "select intDiv(server_port, 1000), Count(row) as c from l4_flow_log group by intDiv(server_port, 1000) limit 1",
+		output: []string{"SELECT intDiv(server_port, 1000) AS `intDiv(server_port, 1000)`, COUNT(1) AS `c` FROM flow_log.`l4_flow_log` GROUP BY intDiv(server_port, 1000) LIMIT 1"},
+	}, {
+		name:   "group_expr_nested",
+		input:  "select modulo(intDiv(byte, 1000), 10) as kb_mod, Count(row) as c from l4_flow_log group by modulo(intDiv(byte, 1000), 10) limit 1",
+		output: []string{"SELECT modulo(intDiv(byte_tx+byte_rx, 1000), 10) AS `kb_mod`, COUNT(1) AS `c` FROM flow_log.`l4_flow_log` GROUP BY modulo(intDiv(byte_tx+byte_rx, 1000), 10) LIMIT 1"},
+	}, {
+		name:    "group_expr_invalid_argument",
+		input:   "select Count(row) as c from l4_flow_log group by intDiv(server_port, Sum(byte)) limit 1",
+		wantErr: "function intDiv does not support argument Sum(byte)",
+	}, {
+		name:    "bucket_invalid_width",
+		input:   "select Bucket(rtt, 0) as bucketed_rtt from l4_flow_log group by bucketed_rtt limit 1",
+		wantErr: "function Bucket width must be a positive integer, got 0",
+	}, {
+		name:   "literal_string",
+		input:  "select 'prod' as env, Sum(byte) as sum_byte from l4_flow_log limit 1",
+		output: []string{"SELECT 'prod' AS `env`, SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "literal_number",
+		input:  "select region_0, 1 as one, 0.5 as ratio from l4_flow_log group by region_0 limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, 1 AS `one`, 0.5 AS `ratio` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` LIMIT 1"},
+	}, {
+		name:   "literal_negative_number",
+		input:  "select region_0, -1 as neg_one, -0.5 as neg_ratio from l4_flow_log group by region_0 limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, -1 AS `neg_one`, -0.5 AS `neg_ratio` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` LIMIT 1"},
+	}, {
+		name:   "math_negative_decimal",
+		input:  "select Sum(byte)*-1.5 as neg_byte from l4_flow_log limit 1",
+		output: []string{"SELECT multiply(SUM(byte_tx+byte_rx), -1.5) AS `neg_byte` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:    "math_unary_column",
+		input:   "select Sum(byte)*-rtt as neg_byte from l4_flow_log limit 1",
+		wantErr: "operator: - not support in binary",
+	}, {
+		name:   "having_negative_decimal",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log having sum_byte >= -1.5 limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` HAVING `sum_byte` >= -1.5 LIMIT 1"},
+	}, {
+		name:    "limit_decimal",
+		input:   "select Sum(byte) as sum_byte from l4_flow_log limit 1.5",
+		wantErr: "limit and offset should be integers: 1.5",
+	}, {
+		name:    "offset_negative",
+		input:   "select Sum(byte) as sum_byte from l4_flow_log limit 10 offset -0.5",
+		wantErr: "limit and offset should be integers: -0.5",
+	}, {
+		name:   "literal_layered",
+		input:  "select 'prod' as env, region_0, Max(byte) as max_byte from vtap_flow_edge_port group by region_0 limit 1",
+		output: []string{"SELECT 'prod' AS `env`, region_0, MAX(`_sum_byte`) AS `max_byte` FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
 		db:     "flow_metrics",
+	}, {
+		name:    "unknown_function_suggest",
+		input:   "select Aveg(byte) as avg_byte from l4_flow_log limit 1",
+		wantErr: "unknown function \"Aveg\"; did you mean \"Avg\"?",
+	}, {
+		name:    "unknown_function_suggest_binary",
+		input:   "select Aveg(byte)/10 as avg_byte from l4_flow_log limit 1",
+		wantErr: "unknown function \"Aveg\"; did you mean \"Avg\"?",
+	}, {
+		name:    "unknown_function",
+		input:   "select NoSuchFunc(byte) as s from l4_flow_log limit 1",
+		wantErr: "unknown function \"NoSuchFunc\"",
+	}, {
+		name:   "enum_group",
+		input:  "select Enum(tap_side), Count(row) as c from l7_flow_log group by Enum(tap_side) limit 1",
+		output: []string{"WITH dictGetOrDefault('flow_tag.string_enum_map', 'name_en', ('observation_point',observation_point), observation_point) AS `Enum(tap_side)` SELECT `Enum(tap_side)`, COUNT(1) AS `c` FROM flow_log.`l7_flow_log` GROUP BY `Enum(tap_side)` LIMIT 1"},
 	}, {
 		input:  "select request from l7_flow_log where Enum(tap_side)='xxx' limit 0, 50",
 		output: []string{"SELECT if(type IN [0, 2],1,0) AS `request` FROM flow_log.`l7_flow_log` WHERE (observation_point GLOBAL IN (SELECT value FROM flow_tag.string_enum_map WHERE name_en = 'xxx' and tag_name='observation_point') OR observation_point = 'xxx') LIMIT 0, 50"},
@@ -235,7 +642,7 @@ var (
 		output: []string{"SELECT if(type IN [0, 2],1,0) AS `request` FROM flow_log.`l7_flow_log` WHERE (observation_point GLOBAL IN (SELECT value FROM flow_tag.string_enum_map WHERE name_en ilike 'xxx' and tag_name='observation_point')) LIMIT 0, 50"},
 	}, {
 		input:  "select Histogram(Sum(byte),10) AS histo from l4_flow_log",
-		output: []string{"SELECT histogramIf(10)(assumeNotNull(`_sum_byte_tx+byte_rx`),`_sum_byte_tx+byte_rx`>0) AS `histo` FROM (SELECT SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log` LIMIT 10000)"},
+		output: []string{"SELECT histogramIf(10)(assumeNotNull(`_sum_byte_tx+byte_rx`),`_sum_byte_tx+byte_rx`>0) AS `histo` FROM (SELECT SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log`) LIMIT 10000"},
 	}, {
 		input:  "select Sum(log_count) from event",
 		output: []string{"SELECT SUM(1) AS `Sum(log_count)` FROM event.`event` LIMIT 10000"},
@@ -246,6 +653,59 @@ var (
 	}, {
 		input:  "select region_0 from l7_flow_log where region regexp '系统*'",
 		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_log.`l7_flow_log` WHERE (toUInt64(region_id) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE match(name,'系统*'))) LIMIT 10000"},
+	}, {
+		name:   "ilike",
+		input:  "select region_0 from l7_flow_log where region ILike 'SyS*'",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_log.`l7_flow_log` WHERE (toUInt64(region_id) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name ilike 'SyS%')) LIMIT 10000"},
+	}, {
+		name:   "not_ilike",
+		input:  "select region_0 from l7_flow_log where region not ilike '*Sys'",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_log.`l7_flow_log` WHERE (toUInt64(region_id) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name not ilike '%Sys')) LIMIT 10000"},
+	}, {
+		name:   "ilike_in_string",
+		input:  "select region_0 from l7_flow_log where region like 'ILike*'",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_log.`l7_flow_log` WHERE (toUInt64(region_id) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name ilike 'ILike%')) LIMIT 10000"},
+	}, {
+		name:   "ilike_enum",
+		input:  "select request from l7_flow_log where Enum(tap_side) ilike 'XxX' limit 0, 50",
+		output: []string{"SELECT if(type IN [0, 2],1,0) AS `request` FROM flow_log.`l7_flow_log` WHERE (observation_point GLOBAL IN (SELECT value FROM flow_tag.string_enum_map WHERE name_en ilike 'XxX' and tag_name='observation_point')) LIMIT 0, 50"},
+	}, {
+		name:   "select_order_time_tags_metrics",
+		input:  "select Sum(byte) as sum_byte, time(time, 120) as time_120, region_0, Max(byte) as max_byte from l4_flow_log group by time_120, region_0 limit 1",
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_time_120` SELECT SUM(byte_tx+byte_rx) AS `sum_byte`, toUnixTimestamp(`_time_120`) AS `time_120`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, MAX(byte_tx+byte_rx) AS `max_byte` FROM flow_log.`l4_flow_log` GROUP BY `time_120`, `region_id_0` LIMIT 1"},
+	}, {
+		name:   "select_order_layered",
+		input:  "select Max(`byte`) AS `Max(byte)`, region_0, Avg(`rtt`) AS `Avg(rtt)` from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
+		output: []string{"SELECT MAX(`_sum_byte`) AS `Max(byte)`, region_0, AVGIf(`_div__sum_rtt_sum__sum_rtt_count`, `_div__sum_rtt_sum__sum_rtt_count` > 0) AS `Avg(rtt)` FROM (WITH if(SUM(rtt_count)>0, divide(SUM(rtt_sum), SUM(rtt_count)), null) AS `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte) AS `_sum_byte`, `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` AS `_div__sum_rtt_sum__sum_rtt_count` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "not_group_single",
+		input:  "select region_0 from l7_flow_log where not (region = 'a')",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_log.`l7_flow_log` WHERE NOT ((toUInt64(region_id) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'a'))) LIMIT 10000"},
+	}, {
+		name:   "not_group_compound",
+		input:  "select region_0 from l7_flow_log where not (region = 'a' and az = 'b') or region = 'c'",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_log.`l7_flow_log` WHERE NOT ((toUInt64(region_id) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'a')) AND (toUInt64(az_id) GLOBAL IN (SELECT id FROM flow_tag.az_map WHERE name = 'b'))) OR (toUInt64(region_id) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'c')) LIMIT 10000"},
+	}, {
+		name:   "distinct_single",
+		input:  "select distinct region_0 from l7_flow_log limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM (SELECT DISTINCT region_id_0 FROM flow_log.`l7_flow_log`) LIMIT 1"},
+	}, {
+		name:   "distinct_multi",
+		input:  "select distinct region_0, az_0 from l4_flow_log limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, dictGet('flow_tag.az_map', 'name', (toUInt64(az_id_0))) AS `az_0` FROM (SELECT DISTINCT region_id_0, az_id_0 FROM flow_log.`l4_flow_log`) LIMIT 1"},
+	}, {
+		name:   "distinct_ip",
+		input:  "select distinct pod_id_0, ip_0 from l4_flow_log where region_0 = 'a' order by ip_0 limit 100",
+		output: []string{"SELECT pod_id_0, if(is_ipv4=1, IPv4NumToString(ip4_0), IPv6NumToString(ip6_0)) AS `ip_0` FROM (SELECT DISTINCT pod_id_0, is_ipv4, ip4_0, ip6_0 FROM flow_log.`l4_flow_log` WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'a'))) ORDER BY `ip_0` asc LIMIT 100"},
+	}, {
+		name:   "distinct_raw",
+		input:  "select distinct region_id_0, az_id_0 from l4_flow_log limit 1",
+		output: []string{"SELECT DISTINCT region_id_0, az_id_0 FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:    "distinct_with_metrics",
+		input:   "select distinct region_0, Sum(byte) as sum_byte from l4_flow_log group by region_0 limit 1",
+		wantErr: "distinct is not supported with aggregate functions",
 	}, {
 		input:  "select time(time, 0.2) as toi, PerSecond(Sum(byte)+100) as persecond_max_byte_100 from l4_flow_log group by toi limit 1",
 		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(1)) + toIntervalSecond(arrayJoin([0]) * 1) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, divide(plus(SUM(byte_tx+byte_rx), 100), 1) AS `persecond_max_byte_100` FROM flow_log.`l4_flow_log` GROUP BY `toi` LIMIT 1"},
@@ -257,6 +717,224 @@ var (
 		input:  "select time(time, 1.2) as toi, Avg(`byte_tx`) AS `Avg(byte_tx)` from vtap_flow_edge_port group by toi limit 1",
 		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(2)) + toIntervalSecond(arrayJoin([0]) * 2) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, sum(byte_tx)/(2/1) AS `Avg(byte_tx)` FROM flow_metrics.`network_map` GROUP BY `toi` LIMIT 1"},
 		db:     "flow_metrics",
+	}, {
+		name:   "delta",
+		input:  "select time(time, 60) as toi, Delta(byte) as delta_byte from l4_flow_log group by toi limit 1",
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, minus(argMax(byte_tx+byte_rx, time), argMin(byte_tx+byte_rx, time)) AS `delta_byte` FROM flow_log.`l4_flow_log` GROUP BY `toi` LIMIT 1"},
+	}, {
+		name:   "last_first",
+		input:  "select Last(rtt) as last_rtt, First(rtt, 'nonzero') as first_rtt from l4_flow_log limit 1",
+		output: []string{"SELECT argMax(rtt, time) AS `last_rtt`, argMinIf(rtt, time, rtt > 0) AS `first_rtt` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "last_layered",
+		input:  "select time(time, 120) as toi, Last(byte_tx) as last_byte_tx, Max(byte) as max_byte from vtap_flow_edge_port group by toi limit 1",
+		output: []string{"WITH toStartOfInterval(_time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, argMax(`_sum_byte_tx`, _time) AS `last_byte_tx`, MAX(`_sum_byte`) AS `max_byte` FROM (WITH toStartOfInterval(time, toIntervalSecond(60)) AS `_time` SELECT _time, SUM(byte_tx) AS `_sum_byte_tx`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map.1m` GROUP BY `_time`) GROUP BY `toi` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "last_layered_nonzero",
+		input:  "select time(time, 120) as toi, Last(byte_tx, 'nonzero') as last_byte_tx, Max(byte) as max_byte from vtap_flow_edge_port group by toi limit 1",
+		output: []string{"WITH toStartOfInterval(_time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, argMaxIf(`_sum_byte_tx`, _time, `_sum_byte_tx` > 0) AS `last_byte_tx`, MAX(`_sum_byte`) AS `max_byte` FROM (WITH toStartOfInterval(time, toIntervalSecond(60)) AS `_time` SELECT _time, SUM(byte_tx) AS `_sum_byte_tx`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map.1m` GROUP BY `_time`) GROUP BY `toi` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:    "last_invalid_arg",
+		input:   "select Last(rtt, 'zero') as last_rtt from l4_flow_log limit 1",
+		wantErr: "function [Last] only supports optional argument 'nonzero'",
+	}, {
+		name:   "avg_include_zero",
+		input:  "select Avg(rtt, include_zero=true) as avg_rtt, Avg(rtt, include_zero=false) as avg_rtt_nonzero from l4_flow_log limit 1",
+		output: []string{"SELECT AVG(rtt) AS `avg_rtt`, AVGIf(rtt, rtt > 0) AS `avg_rtt_nonzero` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "avg_include_zero_layered",
+		input:  "select Avg(`rtt`, include_zero=true) AS `Avg(rtt)`,Max(`byte`) AS `Max(byte)`,region_0 from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
+		output: []string{"SELECT AVG(`_div__sum_rtt_sum__sum_rtt_count`) AS `Avg(rtt)`, MAX(`_sum_byte`) AS `Max(byte)`, region_0 FROM (WITH if(SUM(rtt_count)>0, divide(SUM(rtt_sum), SUM(rtt_count)), null) AS `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` AS `_div__sum_rtt_sum__sum_rtt_count`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:    "avg_invalid_arg",
+		input:   "select Avg(rtt, 'zero') as avg_rtt from l4_flow_log limit 1",
+		wantErr: "function [Avg] only supports optional argument include_zero=true or include_zero=false",
+	}, {
+		name:   "without_fillnull",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "fillnull",
+		input:  "select Sum(byte) fillnull(0) as sum_byte from l4_flow_log limit 1",
+		output: []string{"WITH SUM(byte_tx+byte_rx) AS `fillnull_sum_byte` SELECT ifNull(`fillnull_sum_byte`, 0) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "fillnull_spread",
+		input:  "select Spread(byte_tx) FILLNULL(0) as spread_byte_tx, Max(byte_tx) as max_byte_tx from l4_flow_log limit 1",
+		output: []string{"WITH minus(MAX(byte_tx), MIN(byte_tx)) AS `fillnull_spread_byte_tx` SELECT ifNull(`fillnull_spread_byte_tx`, 0) AS `spread_byte_tx`, MAX(byte_tx) AS `max_byte_tx` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:    "fillnull_tag_function",
+		input:   "select Enum(tap_side) fillnull(0) as tap_side from l7_flow_log limit 1",
+		wantErr: "fillnull only supports metric functions: Enum(tap_side)",
+	}, {
+		name:    "fillnull_invalid_value",
+		input:   "select Sum(byte) fillnull('a') as sum_byte from l4_flow_log limit 1",
+		wantErr: "fillnull value should be a number: 'a'",
+	}, {
+		name:   "fillnull_negative_decimal",
+		input:  "select Sum(byte) fillnull(-0.5) as sum_byte from l4_flow_log limit 1",
+		output: []string{"WITH SUM(byte_tx+byte_rx) AS `fillnull_sum_byte` SELECT ifNull(`fillnull_sum_byte`, -0.5) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "argmax_group_by",
+		input:  "select region_0, ArgMax(pod_0, byte) as top_pod from l4_flow_log group by region_0 limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, argMaxIf(dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id_0))), byte_tx+byte_rx, dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id_0))) != '') AS `top_pod` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` LIMIT 1"},
+	}, {
+		name:   "argmin_delay_group_by",
+		input:  "select region_0, ArgMin(pod_0, rtt) as fast_pod from l4_flow_log group by region_0 limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, argMinIf(dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id_0))), rtt, dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id_0))) != '' AND rtt > 0) AS `fast_pod` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` LIMIT 1"},
+	}, {
+		name:   "argmax_layered_group_by",
+		input:  "select ArgMax(pod_0, byte) as top_pod, Max(byte) as max_byte, region_0 from vtap_flow_edge_port group by region_0 limit 1",
+		output: []string{"SELECT argMax(`_argmax_pod_0_byte`, `_argmax_pod_0_byte_metric`) AS `top_pod`, MAX(`_sum_byte`) AS `max_byte`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, argMaxIf(dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id_0))), byte, dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id_0))) != '') AS `_argmax_pod_0_byte`, MAXIf(byte, dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id_0))) != '') AS `_argmax_pod_0_byte_metric`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:    "argmax_invalid_metric",
+		input:   "select ArgMax(pod_0, region_0) from l4_flow_log limit 1",
+		wantErr: "function [ArgMax] not support metric [region_0]",
+	}, {
+		name:   "uppercase_table_and_tag",
+		input:  "SELECT Sum(BYTE) AS Sum_Byte FROM L4_FLOW_LOG WHERE REGION_0 = 'A' LIMIT 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `Sum_Byte` FROM flow_log.`l4_flow_log` WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'A')) LIMIT 1"},
+	}, {
+		name:   "uppercase_alias_reference",
+		input:  "select Region_0, Sum(Byte) as BYTE from l4_flow_log group by Region_0 order by BYTE desc limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, SUM(byte_tx+byte_rx) AS `BYTE` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` ORDER BY `BYTE` desc LIMIT 1"},
+	}, {
+		name:   "zscore",
+		input:  "select region_0, ZScore(Sum(byte)) as z from l4_flow_log group by region_0 order by z desc limit 10",
+		output: []string{"SELECT `region_0`, divide(minus(`_sum_byte_tx+byte_rx`, avg(`_sum_byte_tx+byte_rx`) OVER ()), stddevPop(`_sum_byte_tx+byte_rx`) OVER ()) AS `z` FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0`) ORDER BY `z` desc LIMIT 10"},
+	}, {
+		name:   "zscore_having",
+		input:  "select region_0, ZScore(Sum(byte)) as z from l4_flow_log group by region_0 having z > 3 order by z desc limit 10",
+		output: []string{"SELECT `region_0`, `z` FROM (SELECT `region_0`, divide(minus(`_sum_byte_tx+byte_rx`, avg(`_sum_byte_tx+byte_rx`) OVER ()), stddevPop(`_sum_byte_tx+byte_rx`) OVER ()) AS `z` FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0`)) WHERE `z` > 3 ORDER BY `z` desc LIMIT 10"},
+	}, {
+		name:   "zscore_having_mixed",
+		input:  "select region_0, ZScore(Sum(byte)) as z from l4_flow_log group by region_0 having z > 3 and Sum(byte) > 0 limit 10",
+		output: []string{"SELECT `region_0`, `z` FROM (SELECT `region_0`, divide(minus(`_sum_byte_tx+byte_rx`, avg(`_sum_byte_tx+byte_rx`) OVER ()), stddevPop(`_sum_byte_tx+byte_rx`) OVER ()) AS `z` FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` HAVING SUM(byte_tx+byte_rx) > 0)) WHERE `z` > 3 LIMIT 10"},
+	}, {
+		name:   "zscore_layered",
+		input:  "select region_0, ZScore(Max(byte)) as z from vtap_flow_edge_port group by region_0 limit 1",
+		output: []string{"SELECT region_0, divide(minus(`_max__sum_byte`, avg(`_max__sum_byte`) OVER ()), stddevPop(`_max__sum_byte`) OVER ()) AS `z` FROM (SELECT region_0, MAX(`_sum_byte`) AS `_max__sum_byte` FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0`) LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:    "zscore_in_having",
+		input:   "select region_0, ZScore(Sum(byte)) as z from l4_flow_log group by region_0 having ZScore(Sum(byte)) > 3 limit 10",
+		wantErr: "function ZScore is not supported in having, use its alias instead",
+	}, {
+		name:   "percent_of_total",
+		input:  "select region_0, PercentOfTotal(Sum(byte)) as p from l4_flow_log group by region_0 order by p desc limit 10",
+		output: []string{"SELECT `region_0`, divide(`_sum_byte_tx+byte_rx`, nullIf(sum(`_sum_byte_tx+byte_rx`) OVER (), 0)) AS `p` FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0`) ORDER BY `p` desc LIMIT 10"},
+	}, {
+		name:   "percent_of_total_ungrouped",
+		input:  "select PercentOfTotal(Sum(byte)) as p from l4_flow_log limit 1",
+		output: []string{"SELECT divide(`_sum_byte_tx+byte_rx`, nullIf(sum(`_sum_byte_tx+byte_rx`) OVER (), 0)) AS `p` FROM (SELECT SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log`) LIMIT 1"},
+	}, {
+		name:    "percent_of_total_not_aggregated",
+		input:   "select region_0, PercentOfTotal(byte) as p from l4_flow_log group by region_0 limit 10",
+		wantErr: "function PercentOfTotal requires 1 aggregate argument, e.g. PercentOfTotal(Sum(byte)), got PercentOfTotal(byte)",
+	}, {
+		name:   "moving_avg_3",
+		input:  "select time(time, 60) as toi, MovingAvg(rtt, 3) as ma_rtt from l4_flow_log group by toi limit 10",
+		output: []string{"SELECT `toi`, avg(`_avg_rtt`) OVER (ORDER BY `toi` ROWS BETWEEN 2 PRECEDING AND CURRENT ROW) AS `ma_rtt` FROM (WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, AVGIf(rtt, rtt > 0) AS `_avg_rtt` FROM flow_log.`l4_flow_log` GROUP BY `toi`) LIMIT 10"},
+	}, {
+		name:   "moving_avg_5",
+		input:  "select time(time, 60) as toi, region_0, MovingAvg(Sum(byte), 5) as ma_byte from l4_flow_log group by toi, region_0 order by toi limit 10",
+		output: []string{"SELECT `toi`, `region_0`, avg(`_sum_byte_tx+byte_rx`) OVER (PARTITION BY `region_0` ORDER BY `toi` ROWS BETWEEN 4 PRECEDING AND CURRENT ROW) AS `ma_byte` FROM (WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log` GROUP BY `toi`, `region_id_0`) ORDER BY `toi` asc LIMIT 10"},
+	}, {
+		name:    "moving_avg_without_time",
+		input:   "select region_0, MovingAvg(Sum(byte), 3) as ma_byte from l4_flow_log group by region_0 limit 10",
+		wantErr: "function MovingAvg requires time() in group by",
+	}, {
+		name:    "moving_avg_invalid_size",
+		input:   "select time(time, 60) as toi, MovingAvg(Sum(byte), 0) as ma_byte from l4_flow_log group by toi limit 10",
+		wantErr: "function MovingAvg window size must be a positive integer, got 0",
+	}, {
+		name:   "smoothed_percentile",
+		input:  "select time(time, 60) as toi, SmoothedPercentile(rtt, 95, 3) as p95_rtt from l4_flow_log group by toi limit 10",
+		output: []string{"SELECT `toi`, avg(`_quantile_rtt_95`) OVER (ORDER BY `toi` ROWS BETWEEN 2 PRECEDING AND CURRENT ROW) AS `p95_rtt` FROM (WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, quantileIf(95)(rtt, rtt > 0) AS `_quantile_rtt_95` FROM flow_log.`l4_flow_log` GROUP BY `toi`) LIMIT 10"},
+	}, {
+		name:   "smoothed_percentile_group",
+		input:  "select time(time, 60) as toi, region_0, SmoothedPercentile(byte_tx, 0.95, 5) as p95_byte from l4_flow_log group by toi, region_0 order by toi limit 10",
+		output: []string{"SELECT `toi`, `region_0`, avg(`_quantile_byte_tx_0.95`) OVER (PARTITION BY `region_0` ORDER BY `toi` ROWS BETWEEN 4 PRECEDING AND CURRENT ROW) AS `p95_byte` FROM (WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, quantile(0.95)(byte_tx) AS `_quantile_byte_tx_0.95` FROM flow_log.`l4_flow_log` GROUP BY `toi`, `region_id_0`) ORDER BY `toi` asc LIMIT 10"},
+	}, {
+		name:    "smoothed_percentile_without_time",
+		input:   "select region_0, SmoothedPercentile(rtt, 95, 3) as p95_rtt from l4_flow_log group by region_0 limit 10",
+		wantErr: "function SmoothedPercentile requires time() in group by",
+	}, {
+		name:    "smoothed_percentile_missing_window",
+		input:   "select time(time, 60) as toi, SmoothedPercentile(rtt, 95) as p95_rtt from l4_flow_log group by toi limit 10",
+		wantErr: "function SmoothedPercentile requires 3 arguments: metric, percentile and window size",
+	}, {
+		name:   "l7_percentile_error_ratio",
+		input:  "select time(time, 60) as time_60, Percentile(response_duration, 95) as p95, Sum(error)/Sum(log_count) as error_ratio from l7_flow_log group by time_60 limit 10",
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_time_60`, if(SUM(1)>0, divide(SUM(if(response_status IN [4, 3],1,0)), SUM(1)), null) AS `divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_1` SELECT toUnixTimestamp(`_time_60`) AS `time_60`, quantileIf(95)(response_duration, response_duration > 0) AS `p95`, `divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_1` AS `error_ratio` FROM flow_log.`l7_flow_log` GROUP BY `time_60` LIMIT 10"},
+	}, {
+		name:   "l7_error_ratio_function",
+		input:  "select time(time, 60) as time_60, Percentile(response_duration, 95) as p95, ErrorRatio() as error_ratio from l7_flow_log group by time_60 limit 10",
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_time_60`, if(SUM(1)>0, divide(SUM(if(response_status IN [4, 3],1,0)), SUM(1)), null) AS `divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_1` SELECT toUnixTimestamp(`_time_60`) AS `time_60`, quantileIf(95)(response_duration, response_duration > 0) AS `p95`, `divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_1` AS `error_ratio` FROM flow_log.`l7_flow_log` GROUP BY `time_60` LIMIT 10"},
+	}, {
+		name:    "error_ratio_unsupported_table",
+		input:   "select ErrorRatio() as error_ratio from l4_flow_log limit 10",
+		wantErr: "function ErrorRatio is not supported in table l4_flow_log",
+	}, {
+		name:    "error_ratio_with_args",
+		input:   "select ErrorRatio(error) as error_ratio from l7_flow_log limit 10",
+		wantErr: "function ErrorRatio takes no arguments",
+	}, {
+		name:   "geomean",
+		input:  "select GeoMean(byte_tx) as geomean_byte_tx from l4_flow_log limit 1",
+		output: []string{"SELECT exp(AVGIf(log(byte_tx), byte_tx > 0)) AS `geomean_byte_tx` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "geomean_delay",
+		input:  "select GeoMean(rtt) as geomean_rtt from l4_flow_log limit 1",
+		output: []string{"SELECT exp(AVGIf(log(rtt), rtt > 0)) AS `geomean_rtt` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "geomean_layered",
+		input:  "select GeoMean(`byte`) AS `GeoMean(byte)`,region_0 from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
+		output: []string{"SELECT exp(AVGIf(log(`_sum_byte`), `_sum_byte` > 0)) AS `GeoMean(byte)`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "harmonic_mean",
+		input:  "select HarmonicMean(byte_tx) as hm_byte_tx from l4_flow_log limit 1",
+		output: []string{"SELECT divide(COUNTIf(byte_tx > 0), SUMIf(divide(1, byte_tx), byte_tx > 0)) AS `hm_byte_tx` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "harmonic_mean_delay",
+		input:  "select HarmonicMean(rtt) as hm_rtt from l4_flow_log where time >= 100+1 and time <= 102 limit 1",
+		output: []string{"SELECT divide(COUNTIf(rtt > 0), SUMIf(divide(1, rtt), rtt > 0)) AS `hm_rtt` FROM flow_log.`l4_flow_log` WHERE `time` >= 100 + 1 AND `time` <= 102 LIMIT 1"},
+	}, {
+		name:   "harmonic_mean_layered",
+		input:  "select HarmonicMean(`byte`) AS `HarmonicMean(byte)`,region_0 from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
+		output: []string{"SELECT divide(COUNTIf(`_sum_byte` > 0), SUMIf(divide(1, `_sum_byte`), `_sum_byte` > 0)) AS `HarmonicMean(byte)`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:           "time_milli",
+		input:          "select time(time, 60) as toi, Sum(byte_tx) as sum_byte_tx from vtap_flow_port group by toi limit 1",
+		output:         []string{"WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp64Milli(toDateTime64(`_toi`, 3)) AS `toi`, SUM(byte_tx) AS `sum_byte_tx` FROM flow_metrics.`network.1m` GROUP BY `toi` LIMIT 1"},
+		db:             "flow_metrics",
+		timestampMilli: true,
+	}, {
+		name:           "time_milli_layered",
+		input:          "select time(time, 120) as toi, Max(byte) as max_byte from vtap_flow_edge_port group by toi limit 1",
+		output:         []string{"WITH toStartOfInterval(_time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_toi` SELECT toUnixTimestamp64Milli(toDateTime64(`_toi`, 3)) AS `toi`, MAX(`_sum_byte`) AS `max_byte` FROM (WITH toStartOfInterval(time, toIntervalSecond(60)) AS `_time` SELECT _time, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map.1m` GROUP BY `_time`) GROUP BY `toi` LIMIT 1"},
+		db:             "flow_metrics",
+		timestampMilli: true,
+	}, {
+		name:   "datasource_auto_1m",
+		input:  "select time(time, 60) as toi, Sum(byte_tx) as sum_byte_tx from vtap_flow_port group by toi limit 1",
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, SUM(byte_tx) AS `sum_byte_tx` FROM flow_metrics.`network.1m` GROUP BY `toi` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "datasource_auto_1h",
+		input:  "select time(time, 3600) as toi, Sum(byte_tx) as sum_byte_tx, Avg(byte_tx) as avg_byte_tx from vtap_flow_port group by toi limit 1",
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(3600)) + toIntervalSecond(arrayJoin([0]) * 3600) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, SUM(byte_tx) AS `sum_byte_tx`, sum(byte_tx)/(3600/3600) AS `avg_byte_tx` FROM flow_metrics.`network.1h` GROUP BY `toi` LIMIT 1"},
+		db:     "flow_metrics",
+	}, {
+		name:   "datasource_explicit_table",
+		input:  "select time(time, 3600) as toi, Sum(byte_tx) as sum_byte_tx from `vtap_flow_port.1m` group by toi limit 1",
+		output: []string{"WITH toStartOfInterval(time, toIntervalSecond(3600)) + toIntervalSecond(arrayJoin([0]) * 3600) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, SUM(byte_tx) AS `sum_byte_tx` FROM flow_metrics.`network.1m` GROUP BY `toi` LIMIT 1"},
+		db:     "flow_metrics",
 	}, {
 		input:  "SELECT time(time,5,1,0) as toi, AAvg(`metrics.dropped`) AS `AAvg(metrics.dropped)` FROM `deepflow_agent_collect_sender` GROUP BY  toi ORDER BY toi desc",
 		output: []string{"WITH toStartOfInterval(_time, toIntervalSecond(5)) + toIntervalSecond(arrayJoin([0]) * 5) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, AVG(`_sum_if(indexOf(metrics_float_names, dropped)=0,null,metrics_float_values[indexOf(metrics_float_names, dropped)])`) AS `AAvg(metrics.dropped)` FROM (WITH toStartOfInterval(time, toIntervalSecond(1)) AS `_time` SELECT _time, SUM(if(indexOf(metrics_float_names, 'dropped')=0,null,metrics_float_values[indexOf(metrics_float_names, 'dropped')])) AS `_sum_if(indexOf(metrics_float_names, dropped)=0,null,metrics_float_values[indexOf(metrics_float_names, dropped)])` FROM deepflow_tenant.`deepflow_collector` WHERE (virtual_table_name='deepflow_agent_collect_sender') GROUP BY `_time`) GROUP BY `toi` ORDER BY `toi` desc LIMIT 10000"},
@@ -322,20 +1000,77 @@ var (
 	}, {
 		name:   "count_1",
 		input:  "select Count(row) as a from l7_flow_log having a > 0 ",
-		output: []string{"SELECT COUNT(1) AS `a` FROM flow_log.`l7_flow_log` HAVING a > 0 LIMIT 10000"},
+		output: []string{"SELECT COUNT(1) AS `a` FROM flow_log.`l7_flow_log` HAVING `a` > 0 LIMIT 10000"},
 	}, {
 		name:   "count_2",
 		input:  "select Count(row) from l7_flow_log having Count(row) > 0 ",
 		output: []string{"SELECT COUNT(1) AS `Count(row)` FROM flow_log.`l7_flow_log` HAVING COUNT(1) > 0 LIMIT 10000"},
+	}, {
+		name:   "settings",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log where region_0 = 'a' limit 1 settings max_execution_time=30, max_memory_usage=10000000000",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name = 'a')) LIMIT 1 SETTINGS max_execution_time=30, max_memory_usage=10000000000"},
+	}, {
+		name:   "settings_layered",
+		input:  "select AAvg(byte_tx) as aavg_byte_tx, region_0 from vtap_flow_edge_port group by region_0 limit 1 settings max_threads=4",
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `aavg_byte_tx`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1 SETTINGS max_threads=4"},
+		db:     "flow_metrics",
+	}, {
+		name:    "settings_invalid",
+		input:   "select Sum(byte) as sum_byte from l4_flow_log limit 1 settings max_threads=(select 1)",
+		wantErr: "settings item 'max_threads=(select 1)' is invalid, it should be like key=value",
+	}, {
+		name:    "settings_not_allowed",
+		input:   "select Sum(byte) as sum_byte from l4_flow_log limit 1 settings max_threads=4, readonly=0",
+		wantErr: "setting 'readonly' is not allowed, allowed settings: max_execution_time, max_memory_usage, max_threads, max_rows_to_read, max_bytes_to_read, max_result_rows, max_result_bytes",
+	}, {
+		name:   "has_any_single",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log where acl_gids hasAny (1) limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` WHERE (hasAny(acl_gids, [1])) LIMIT 1"},
+	}, {
+		name:   "array_join_group",
+		input:  "select acl_gids, Sum(byte) as sum_byte from l4_flow_log group by acl_gids limit 1",
+		output: []string{"SELECT arrayJoin(acl_gids) AS `acl_gids`, SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` GROUP BY `acl_gids` LIMIT 1"},
+	}, {
+		name:   "array_no_group",
+		input:  "select acl_gids from l4_flow_log limit 1",
+		output: []string{"SELECT acl_gids FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "has_any_multiple",
+		input:  "select Count(row) as c from l7_flow_log where attribute_names hasAny ('a', 'b') limit 1",
+		output: []string{"SELECT COUNT(1) AS `c` FROM flow_log.`l7_flow_log` WHERE (hasAny(attribute_names, ['a', 'b'])) LIMIT 1"},
+	}, {
+		name:   "has_all_single",
+		input:  "select Count(row) as c from l7_flow_log where attribute_names HASALL ('hasAny (') limit 1",
+		output: []string{"SELECT COUNT(1) AS `c` FROM flow_log.`l7_flow_log` WHERE (hasAll(attribute_names, ['hasAny ('])) LIMIT 1"},
+	}, {
+		name:   "has_all_multiple",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log where region_0 in ('a') and acl_gids not hasAll (1, 2) limit 1",
+		output: []string{"SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` WHERE (toUInt64(region_id_0) GLOBAL IN (SELECT id FROM flow_tag.region_map WHERE name in ('a'))) AND (not(hasAll(acl_gids, [1, 2]))) LIMIT 1"},
+	}, {
+		name:   "count_no_args",
+		input:  "select Count() as c from l4_flow_log limit 1",
+		output: []string{"SELECT SUM(1) AS `c` FROM flow_log.`l4_flow_log` LIMIT 1"},
+	}, {
+		name:   "count_no_args_having_order",
+		input:  "select Count() from l7_flow_log having Count() > 0 order by Count() desc",
+		output: []string{"SELECT SUM(1) AS `Count()` FROM flow_log.`l7_flow_log` HAVING SUM(1) > 0 ORDER BY `Count()` desc LIMIT 10000"},
+	}, {
+		name:   "count_distinct",
+		input:  "select region_0, Count(distinct ip_0) as n from l4_flow_log group by region_0 having n > 1 order by n desc limit 1",
+		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, uniq((is_ipv4, ip4_0, ip6_0)) AS `n` FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` HAVING `n` > 1 ORDER BY `n` desc LIMIT 1"},
+	}, {
+		name:   "count_mixed",
+		input:  "select Count() + Count(distinct ip_0) as mix, Max(byte) as max_byte from l4_flow_log limit 1",
+		output: []string{"SELECT plus(SUM(1), uniq((is_ipv4, ip4_0, ip6_0))) AS `mix`, MAX(byte_tx+byte_rx) AS `max_byte` FROM flow_log.`l4_flow_log` LIMIT 1"},
 	}, {
 		name:   "count_3",
 		input:  "select Avg(`byte_tx`) AS `Avg(byte_tx)`,icon_id(chost_0) as `xx`, Count(row) as `c`, region_0 from vtap_flow_edge_port group by region_0 having `c` > 0 limit 1",
-		output: []string{"WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `xx` SELECT `xx`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, sum(byte_tx)/(1/1) AS `Avg(byte_tx)`, COUNT(1) AS `c` FROM flow_metrics.`network_map` GROUP BY `xx`, `region_id_0` HAVING c > 0 LIMIT 1"},
+		output: []string{"WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `xx` SELECT sum(byte_tx)/(1/1) AS `Avg(byte_tx)`, `xx`, COUNT(1) AS `c`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_metrics.`network_map` GROUP BY `xx`, `region_id_0` HAVING `c` > 0 LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "count_3_aavg",
 		input:  "select AAvg(`byte_tx`) AS `AAvg(byte_tx)`,icon_id(chost_0) as `xx`, Count(row) as `c`, region_0 from vtap_flow_edge_port group by region_0 having `c` > 0 limit 1",
-		output: []string{"SELECT `xx`, region_0, AVG(`_sum_byte_tx`) AS `AAvg(byte_tx)`, SUM(`_count_1`) AS `c` FROM (WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `xx` SELECT `xx`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx`, COUNT(1) AS `_count_1` FROM flow_metrics.`network_map` GROUP BY `xx`, `region_id_0`) GROUP BY `xx`, `region_id_0`, `region_0` HAVING c > 0 LIMIT 1"},
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `AAvg(byte_tx)`, `xx`, SUM(`_count_1`) AS `c`, region_0 FROM (WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `xx` SELECT `xx`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx`, COUNT(1) AS `_count_1` FROM flow_metrics.`network_map` GROUP BY `xx`, `region_id_0`) GROUP BY `xx`, `region_id_0`, `region_0` HAVING `c` > 0 LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "topk_1",
@@ -399,53 +1134,53 @@ var (
 	}, {
 		name:   "layered_0",
 		input:  "select Avg(`byte_tx`) AS `Avg(byte_tx)`, region_0 from vtap_flow_edge_port group by region_0 limit 1",
-		output: []string{"SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, sum(byte_tx)/(1/1) AS `Avg(byte_tx)` FROM flow_metrics.`network_map` GROUP BY `region_id_0` LIMIT 1"},
+		output: []string{"SELECT sum(byte_tx)/(1/1) AS `Avg(byte_tx)`, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0` FROM flow_metrics.`network_map` GROUP BY `region_id_0` LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "layered_0_aavg",
 		input:  "select AAvg(`byte_tx`) AS `AAvg(byte_tx)`, region_0 from vtap_flow_edge_port group by region_0 limit 1",
-		output: []string{"SELECT region_0, AVG(`_sum_byte_tx`) AS `AAvg(byte_tx)` FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
+		output: []string{"SELECT AVG(`_sum_byte_tx`) AS `AAvg(byte_tx)`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "division>=0_l4_flow_log",
 		input:  "select Avg(`l7_error_ratio`) AS `Avg(l7_error_ratio)`, Avg(`retrans_syn_ratio`) AS `Avg(retrans_syn_ratio)`, Avg(`retrans_synack_ratio`) AS `Avg(retrans_synack_ratio)`, Avg(`l7_client_error_ratio`) AS `Avg(l7_client_error_ratio)`, Avg(`l7_server_error_ratio`) AS `Avg(l7_server_error_ratio)`, auto_service_id from l4_flow_log group by auto_service_id limit 1",
-		output: []string{"WITH if(SUMIf(l7_response, l7_response>0)>0, if(divide(SUM(l7_error), SUMIf(l7_response, l7_response>0))>=0, least(divide(SUM(l7_error), SUMIf(l7_response, l7_response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_l7_error_sum_l7_response_l7_response>0`, if(SUMIf(syn_count, syn_count>0)>0, if(divide(SUM(retrans_syn), SUMIf(syn_count, syn_count>0))>=0, least(divide(SUM(retrans_syn), SUMIf(syn_count, syn_count>0)), 1), null), null) AS `divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`, if(SUMIf(synack_count, synack_count>0)>0, if(divide(SUM(retrans_synack), SUMIf(synack_count, synack_count>0))>=0, least(divide(SUM(retrans_synack), SUMIf(synack_count, synack_count>0)), 1), null), null) AS `divide_0diveider_as_null_sum_retrans_synack_sum_synack_count_synack_count>0`, if(SUMIf(l7_response, l7_response>0)>0, if(divide(SUM(l7_client_error), SUMIf(l7_response, l7_response>0))>=0, least(divide(SUM(l7_client_error), SUMIf(l7_response, l7_response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_l7_client_error_sum_l7_response_l7_response>0`, if(SUMIf(l7_response, l7_response>0)>0, if(divide(SUM(l7_server_error), SUMIf(l7_response, l7_response>0))>=0, least(divide(SUM(l7_server_error), SUMIf(l7_response, l7_response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_l7_server_error_sum_l7_response_l7_response>0` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, if(`divide_0diveider_as_null_sum_l7_error_sum_l7_response_l7_response>0`>=0, least(`divide_0diveider_as_null_sum_l7_error_sum_l7_response_l7_response>0`, 1), null)*100 AS `Avg(l7_error_ratio)`, if(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`>=0, least(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`, 1), null)*100 AS `Avg(retrans_syn_ratio)`, if(`divide_0diveider_as_null_sum_retrans_synack_sum_synack_count_synack_count>0`>=0, least(`divide_0diveider_as_null_sum_retrans_synack_sum_synack_count_synack_count>0`, 1), null)*100 AS `Avg(retrans_synack_ratio)`, if(`divide_0diveider_as_null_sum_l7_client_error_sum_l7_response_l7_response>0`>=0, least(`divide_0diveider_as_null_sum_l7_client_error_sum_l7_response_l7_response>0`, 1), null)*100 AS `Avg(l7_client_error_ratio)`, if(`divide_0diveider_as_null_sum_l7_server_error_sum_l7_response_l7_response>0`>=0, least(`divide_0diveider_as_null_sum_l7_server_error_sum_l7_response_l7_response>0`, 1), null)*100 AS `Avg(l7_server_error_ratio)` FROM flow_log.`l4_flow_log` GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"WITH if(SUMIf(l7_response, l7_response>0)>0, if(divide(SUM(l7_client_error), SUMIf(l7_response, l7_response>0))>=0, least(divide(SUM(l7_client_error), SUMIf(l7_response, l7_response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_l7_client_error_sum_l7_response_l7_response>0`, if(SUMIf(l7_response, l7_response>0)>0, if(divide(SUM(l7_error), SUMIf(l7_response, l7_response>0))>=0, least(divide(SUM(l7_error), SUMIf(l7_response, l7_response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_l7_error_sum_l7_response_l7_response>0`, if(SUMIf(l7_response, l7_response>0)>0, if(divide(SUM(l7_server_error), SUMIf(l7_response, l7_response>0))>=0, least(divide(SUM(l7_server_error), SUMIf(l7_response, l7_response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_l7_server_error_sum_l7_response_l7_response>0`, if(SUMIf(syn_count, syn_count>0)>0, if(divide(SUM(retrans_syn), SUMIf(syn_count, syn_count>0))>=0, least(divide(SUM(retrans_syn), SUMIf(syn_count, syn_count>0)), 1), null), null) AS `divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`, if(SUMIf(synack_count, synack_count>0)>0, if(divide(SUM(retrans_synack), SUMIf(synack_count, synack_count>0))>=0, least(divide(SUM(retrans_synack), SUMIf(synack_count, synack_count>0)), 1), null), null) AS `divide_0diveider_as_null_sum_retrans_synack_sum_synack_count_synack_count>0` SELECT if(`divide_0diveider_as_null_sum_l7_error_sum_l7_response_l7_response>0`>=0, least(`divide_0diveider_as_null_sum_l7_error_sum_l7_response_l7_response>0`, 1), null)*100 AS `Avg(l7_error_ratio)`, if(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`>=0, least(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`, 1), null)*100 AS `Avg(retrans_syn_ratio)`, if(`divide_0diveider_as_null_sum_retrans_synack_sum_synack_count_synack_count>0`>=0, least(`divide_0diveider_as_null_sum_retrans_synack_sum_synack_count_synack_count>0`, 1), null)*100 AS `Avg(retrans_synack_ratio)`, if(`divide_0diveider_as_null_sum_l7_client_error_sum_l7_response_l7_response>0`>=0, least(`divide_0diveider_as_null_sum_l7_client_error_sum_l7_response_l7_response>0`, 1), null)*100 AS `Avg(l7_client_error_ratio)`, if(`divide_0diveider_as_null_sum_l7_server_error_sum_l7_response_l7_response>0`>=0, least(`divide_0diveider_as_null_sum_l7_server_error_sum_l7_response_l7_response>0`, 1), null)*100 AS `Avg(l7_server_error_ratio)`, if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id` FROM flow_log.`l4_flow_log` GROUP BY `auto_service_id` LIMIT 1"},
 	}, {
 		name:   "division>=0_l4_flow_log_aavg",
 		input:  "select AAvg(`l7_error_ratio`) AS `AAvg(l7_error_ratio)`, AAvg(`retrans_syn_ratio`) AS `AAvg(retrans_syn_ratio)`, AAvg(`retrans_synack_ratio`) AS `AAvg(retrans_synack_ratio)`, AAvg(`l7_client_error_ratio`) AS `AAvg(l7_client_error_ratio)`, AAvg(`l7_server_error_ratio`) AS `AAvg(l7_server_error_ratio)`, auto_service_id from l4_flow_log group by auto_service_id limit 1",
-		output: []string{"SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, AVGIf(if(l7_error/l7_response>=0, least(l7_error/l7_response, 1), null), l7_response>0)*100 AS `AAvg(l7_error_ratio)`, AVGIf(if(retrans_syn/syn_count>=0, least(retrans_syn/syn_count, 1), null), syn_count>0)*100 AS `AAvg(retrans_syn_ratio)`, AVGIf(if(retrans_synack/synack_count>=0, least(retrans_synack/synack_count, 1), null), synack_count>0)*100 AS `AAvg(retrans_synack_ratio)`, AVGIf(if(l7_client_error/l7_response>=0, least(l7_client_error/l7_response, 1), null), l7_response>0)*100 AS `AAvg(l7_client_error_ratio)`, AVGIf(if(l7_server_error/l7_response>=0, least(l7_server_error/l7_response, 1), null), l7_response>0)*100 AS `AAvg(l7_server_error_ratio)` FROM flow_log.`l4_flow_log` GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"SELECT AVGIf(if(l7_error/l7_response>=0, least(l7_error/l7_response, 1), null), l7_response>0)*100 AS `AAvg(l7_error_ratio)`, AVGIf(if(retrans_syn/syn_count>=0, least(retrans_syn/syn_count, 1), null), syn_count>0)*100 AS `AAvg(retrans_syn_ratio)`, AVGIf(if(retrans_synack/synack_count>=0, least(retrans_synack/synack_count, 1), null), synack_count>0)*100 AS `AAvg(retrans_synack_ratio)`, AVGIf(if(l7_client_error/l7_response>=0, least(l7_client_error/l7_response, 1), null), l7_response>0)*100 AS `AAvg(l7_client_error_ratio)`, AVGIf(if(l7_server_error/l7_response>=0, least(l7_server_error/l7_response, 1), null), l7_response>0)*100 AS `AAvg(l7_server_error_ratio)`, if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id` FROM flow_log.`l4_flow_log` GROUP BY `auto_service_id` LIMIT 1"},
 	}, {
 		name:   "division>=0_l7_flow_log",
 		input:  "select Avg(`error_ratio`) AS `Avg(error_ratio)`, auto_service_id from l7_flow_log group by auto_service_id limit 1",
-		output: []string{"WITH if(SUMIf(if(type IN [1, 2],1,0), if(type IN [1, 2],1,0)>0)>0, if(divide(SUM(if(response_status IN [4, 3],1,0)), SUMIf(if(type IN [1, 2],1,0), if(type IN [1, 2],1,0)>0))>=0, least(divide(SUM(if(response_status IN [4, 3],1,0)), SUMIf(if(type IN [1, 2],1,0), if(type IN [1, 2],1,0)>0)), 1), null), null) AS `divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_if(type IN [1, 2],1,0)_if(type IN [1, 2],1,0)>0` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, if(`divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_if(type IN [1, 2],1,0)_if(type IN [1, 2],1,0)>0`>=0, least(`divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_if(type IN [1, 2],1,0)_if(type IN [1, 2],1,0)>0`, 1), null)*100 AS `Avg(error_ratio)` FROM flow_log.`l7_flow_log` GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"WITH if(SUMIf(if(type IN [1, 2],1,0), if(type IN [1, 2],1,0)>0)>0, if(divide(SUM(if(response_status IN [4, 3],1,0)), SUMIf(if(type IN [1, 2],1,0), if(type IN [1, 2],1,0)>0))>=0, least(divide(SUM(if(response_status IN [4, 3],1,0)), SUMIf(if(type IN [1, 2],1,0), if(type IN [1, 2],1,0)>0)), 1), null), null) AS `divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_if(type IN [1, 2],1,0)_if(type IN [1, 2],1,0)>0` SELECT if(`divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_if(type IN [1, 2],1,0)_if(type IN [1, 2],1,0)>0`>=0, least(`divide_0diveider_as_null_sum_if(response_status IN [4, 3],1,0)_sum_if(type IN [1, 2],1,0)_if(type IN [1, 2],1,0)>0`, 1), null)*100 AS `Avg(error_ratio)`, if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id` FROM flow_log.`l7_flow_log` GROUP BY `auto_service_id` LIMIT 1"},
 	}, {
 		name:   "division>=0_l7_flow_log_aavg",
 		input:  "select AAvg(`error_ratio`) AS `AAvg(error_ratio)`, auto_service_id from l7_flow_log group by auto_service_id limit 1",
-		output: []string{"SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, AVGIf(if(if(response_status IN [4, 3],1,0)/if(type IN [1, 2],1,0)>=0, least(if(response_status IN [4, 3],1,0)/if(type IN [1, 2],1,0), 1), null), if(type IN [1, 2],1,0)>0)*100 AS `AAvg(error_ratio)` FROM flow_log.`l7_flow_log` GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"SELECT AVGIf(if(if(response_status IN [4, 3],1,0)/if(type IN [1, 2],1,0)>=0, least(if(response_status IN [4, 3],1,0)/if(type IN [1, 2],1,0), 1), null), if(type IN [1, 2],1,0)>0)*100 AS `AAvg(error_ratio)`, if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id` FROM flow_log.`l7_flow_log` GROUP BY `auto_service_id` LIMIT 1"},
 	}, {
 		name:   "division>=0_vtap_app_port",
 		input:  "select Avg(`rrt`) AS `Avg(rrt)`, Avg(`error_ratio`) AS `Avg(error_ratio)`, auto_service_id from vtap_app_port group by auto_service_id limit 1",
-		output: []string{"WITH if(SUMIf(rrt_count, rrt_count>0)>0, divide(SUM(rrt_sum), SUMIf(rrt_count, rrt_count>0)), null) AS `divide_0diveider_as_null_sum_rrt_sum_sum_rrt_count_rrt_count>0`, if(SUMIf(response, response>0)>0, if(divide(SUM(error), SUMIf(response, response>0))>=0, least(divide(SUM(error), SUMIf(response, response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_error_sum_response_response>0` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, `divide_0diveider_as_null_sum_rrt_sum_sum_rrt_count_rrt_count>0` AS `Avg(rrt)`, if(`divide_0diveider_as_null_sum_error_sum_response_response>0`>=0, least(`divide_0diveider_as_null_sum_error_sum_response_response>0`, 1), null)*100 AS `Avg(error_ratio)` FROM flow_metrics.`application` GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"WITH if(SUMIf(response, response>0)>0, if(divide(SUM(error), SUMIf(response, response>0))>=0, least(divide(SUM(error), SUMIf(response, response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_error_sum_response_response>0`, if(SUMIf(rrt_count, rrt_count>0)>0, divide(SUM(rrt_sum), SUMIf(rrt_count, rrt_count>0)), null) AS `divide_0diveider_as_null_sum_rrt_sum_sum_rrt_count_rrt_count>0` SELECT `divide_0diveider_as_null_sum_rrt_sum_sum_rrt_count_rrt_count>0` AS `Avg(rrt)`, if(`divide_0diveider_as_null_sum_error_sum_response_response>0`>=0, least(`divide_0diveider_as_null_sum_error_sum_response_response>0`, 1), null)*100 AS `Avg(error_ratio)`, if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id` FROM flow_metrics.`application` GROUP BY `auto_service_id` LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "success_ratio_vtap_app_port",
 		input:  "select Avg(`success_ratio`) AS `Avg(success_ratio)`, Spread(`success_ratio`) AS `Spread(success_ratio)`, auto_service_id from vtap_app_port group by auto_service_id limit 1",
-		output: []string{"WITH if(count(`_minus_1__div__sum_error__sum_response`)=1, min(`_minus_1__div__sum_error__sum_response`), 0) AS `min_fillnullaszero__minus_1__div__sum_error__sum_response` SELECT auto_service_id, AVG(`_minus_1__div__sum_error__sum_response`)*100 AS `Avg(success_ratio)`, minus(MAX(`_minus_1__div__sum_error__sum_response`), `min_fillnullaszero__minus_1__div__sum_error__sum_response`)*100 AS `Spread(success_ratio)` FROM (WITH if(SUM(response)>0, if(divide(SUM(error), SUM(response))>=0, least(divide(SUM(error), SUM(response)), 1), null), null) AS `divide_0diveider_as_null_sum_error_sum_response` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, minus(1, if(`divide_0diveider_as_null_sum_error_sum_response`>=0, least(`divide_0diveider_as_null_sum_error_sum_response`, 1), null)) AS `_minus_1__div__sum_error__sum_response` FROM flow_metrics.`application` GROUP BY `auto_service_id`) GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"WITH if(count(`_minus_1__div__sum_error__sum_response`)=1, min(`_minus_1__div__sum_error__sum_response`), 0) AS `min_fillnullaszero__minus_1__div__sum_error__sum_response` SELECT AVG(`_minus_1__div__sum_error__sum_response`)*100 AS `Avg(success_ratio)`, minus(MAX(`_minus_1__div__sum_error__sum_response`), `min_fillnullaszero__minus_1__div__sum_error__sum_response`)*100 AS `Spread(success_ratio)`, auto_service_id FROM (WITH if(SUM(response)>0, if(divide(SUM(error), SUM(response))>=0, least(divide(SUM(error), SUM(response)), 1), null), null) AS `divide_0diveider_as_null_sum_error_sum_response` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, minus(1, if(`divide_0diveider_as_null_sum_error_sum_response`>=0, least(`divide_0diveider_as_null_sum_error_sum_response`, 1), null)) AS `_minus_1__div__sum_error__sum_response` FROM flow_metrics.`application` GROUP BY `auto_service_id`) GROUP BY `auto_service_id` LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "division>=0_vtap_app_port_aavg",
 		input:  "select AAvg(`rrt`) AS `AAvg(rrt)`, AAvg(`error_ratio`) AS `AAvg(error_ratio)`, auto_service_id from vtap_app_port group by auto_service_id limit 1",
-		output: []string{"SELECT auto_service_id, AVGArray(arrayFilter(x -> x>0, `_grouparray_rrt_sum/rrt_count`)) AS `AAvg(rrt)`, AVG(`_div__sum_error__sum_response`)*100 AS `AAvg(error_ratio)` FROM (WITH if(SUM(response)>0, if(divide(SUM(error), SUM(response))>=0, least(divide(SUM(error), SUM(response)), 1), null), null) AS `divide_0diveider_as_null_sum_error_sum_response` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, groupArrayIf(rrt_sum/rrt_count, rrt_sum/rrt_count > 0) AS `_grouparray_rrt_sum/rrt_count`, if(`divide_0diveider_as_null_sum_error_sum_response`>=0, least(`divide_0diveider_as_null_sum_error_sum_response`, 1), null) AS `_div__sum_error__sum_response` FROM flow_metrics.`application` GROUP BY `auto_service_id`) GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"SELECT AVGArray(arrayFilter(x -> x>0, `_grouparray_rrt_sum/rrt_count`)) AS `AAvg(rrt)`, AVG(`_div__sum_error__sum_response`)*100 AS `AAvg(error_ratio)`, auto_service_id FROM (WITH if(SUM(response)>0, if(divide(SUM(error), SUM(response))>=0, least(divide(SUM(error), SUM(response)), 1), null), null) AS `divide_0diveider_as_null_sum_error_sum_response` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, groupArrayIf(rrt_sum/rrt_count, rrt_sum/rrt_count > 0) AS `_grouparray_rrt_sum/rrt_count`, if(`divide_0diveider_as_null_sum_error_sum_response`>=0, least(`divide_0diveider_as_null_sum_error_sum_response`, 1), null) AS `_div__sum_error__sum_response` FROM flow_metrics.`application` GROUP BY `auto_service_id`) GROUP BY `auto_service_id` LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "division>=0_vtap_flow_edge_port",
 		input:  "select Avg(`bpp`) AS `Avg(bpp)`, Avg(`retrans_syn_ratio`) AS `Avg(retrans_syn_ratio)`, auto_service_id from vtap_flow_edge_port group by auto_service_id limit 1",
-		output: []string{"WITH if(SUMIf(packet, packet>0)>0, divide(SUM(byte), SUMIf(packet, packet>0)), null) AS `divide_0diveider_as_null_sum_byte_sum_packet_packet>0`, if(SUMIf(syn_count, syn_count>0)>0, if(divide(SUM(retrans_syn), SUMIf(syn_count, syn_count>0))>=0, least(divide(SUM(retrans_syn), SUMIf(syn_count, syn_count>0)), 1), null), null) AS `divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, `divide_0diveider_as_null_sum_byte_sum_packet_packet>0` AS `Avg(bpp)`, if(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`>=0, least(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`, 1), null)*100 AS `Avg(retrans_syn_ratio)` FROM flow_metrics.`network_map` GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"WITH if(SUMIf(packet, packet>0)>0, divide(SUM(byte), SUMIf(packet, packet>0)), null) AS `divide_0diveider_as_null_sum_byte_sum_packet_packet>0`, if(SUMIf(syn_count, syn_count>0)>0, if(divide(SUM(retrans_syn), SUMIf(syn_count, syn_count>0))>=0, least(divide(SUM(retrans_syn), SUMIf(syn_count, syn_count>0)), 1), null), null) AS `divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0` SELECT `divide_0diveider_as_null_sum_byte_sum_packet_packet>0` AS `Avg(bpp)`, if(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`>=0, least(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count_syn_count>0`, 1), null)*100 AS `Avg(retrans_syn_ratio)`, if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id` FROM flow_metrics.`network_map` GROUP BY `auto_service_id` LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "division>=0_vtap_flow_edge_port_aavg",
 		input:  "select AAvg(`bpp`) AS `AAvg(bpp)`, AAvg(`retrans_syn_ratio`) AS `AAvg(retrans_syn_ratio)`, auto_service_id from vtap_flow_edge_port group by auto_service_id limit 1",
-		output: []string{"SELECT auto_service_id, AVG(`_div__sum_byte__sum_packet`) AS `AAvg(bpp)`, AVG(`_div__sum_retrans_syn__sum_syn_count`)*100 AS `AAvg(retrans_syn_ratio)` FROM (WITH if(SUM(packet)>0, divide(SUM(byte), SUM(packet)), null) AS `divide_0diveider_as_null_sum_byte_sum_packet`, if(SUM(syn_count)>0, if(divide(SUM(retrans_syn), SUM(syn_count))>=0, least(divide(SUM(retrans_syn), SUM(syn_count)), 1), null), null) AS `divide_0diveider_as_null_sum_retrans_syn_sum_syn_count` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, `divide_0diveider_as_null_sum_byte_sum_packet` AS `_div__sum_byte__sum_packet`, if(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count`>=0, least(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count`, 1), null) AS `_div__sum_retrans_syn__sum_syn_count` FROM flow_metrics.`network_map` GROUP BY `auto_service_id`) GROUP BY `auto_service_id` LIMIT 1"},
+		output: []string{"SELECT AVG(`_div__sum_byte__sum_packet`) AS `AAvg(bpp)`, AVG(`_div__sum_retrans_syn__sum_syn_count`)*100 AS `AAvg(retrans_syn_ratio)`, auto_service_id FROM (WITH if(SUM(packet)>0, divide(SUM(byte), SUM(packet)), null) AS `divide_0diveider_as_null_sum_byte_sum_packet`, if(SUM(syn_count)>0, if(divide(SUM(retrans_syn), SUM(syn_count))>=0, least(divide(SUM(retrans_syn), SUM(syn_count)), 1), null), null) AS `divide_0diveider_as_null_sum_retrans_syn_sum_syn_count` SELECT if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id`, `divide_0diveider_as_null_sum_byte_sum_packet` AS `_div__sum_byte__sum_packet`, if(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count`>=0, least(`divide_0diveider_as_null_sum_retrans_syn_sum_syn_count`, 1), null) AS `_div__sum_retrans_syn__sum_syn_count` FROM flow_metrics.`network_map` GROUP BY `auto_service_id`) GROUP BY `auto_service_id` LIMIT 1"},
 		db:     "flow_metrics",
 	}, {
 		name:   "exist_trans_support_tag_0",
@@ -465,13 +1200,13 @@ var (
 		db:         "flow_metrics",
 		datasource: "1m",
 		input:      "WITH query1 AS (SELECT PerSecond(Avg(`request`)) AS `请求速率`, Avg(`server_error_ratio`) AS `服务端异常比例`, Avg(`rrt`) AS `响应时延`, node_type(region_0) AS `client_node_type`, icon_id(region_0) AS `client_icon_id`, region_id_0, region_0, Enum(tap_side), tap_side, is_internet_0, node_type(region_1) AS `server_node_type`, icon_id(region_1) AS `server_icon_id`, region_id_1, region_1, is_internet_1 FROM vtap_app_edge_port WHERE time>=1704338640 AND time<=1704339600 GROUP BY region_0, tap_side, is_internet_0, region_id_0, `client_node_type`, region_1, is_internet_1, region_id_1, `server_node_type` ORDER BY `请求速率` DESC LIMIT 50 OFFSET 0), query2 AS (SELECT Avg(`packet_tx`) AS `Avg(发送包数)`, node_type(region_0) AS `client_node_type`, icon_id(region_0) AS `client_icon_id`, region_id_0, region_0, Enum(tap_side), tap_side, is_internet_0, node_type(region_1) AS `server_node_type`, icon_id(region_1) AS `server_icon_id`, region_id_1, region_1, is_internet_1 FROM vtap_flow_edge_port WHERE time>=1704338640 AND time<=1704339600 GROUP BY region_0, tap_side, is_internet_0, region_id_0, `client_node_type`, region_1, is_internet_1, region_id_1, `server_node_type` LIMIT 50) SELECT query1.`请求速率` AS `请求速率`, query1.`服务端异常比例` AS `服务端异常比例`, query1.`响应时延` AS `响应时延`, query1.`client_node_type` AS `client_node_type`, query1.`client_icon_id` AS `client_icon_id`, query1.`region_id_0` AS `region_id_0`, query1.`region_0` AS `region_0`, query1.`Enum(tap_side)` AS `Enum(tap_side)`, query1.`tap_side` AS `tap_side`, query1.`is_internet_0` AS `is_internet_0`, query1.`server_node_type` AS `server_node_type`, query1.`server_icon_id` AS `server_icon_id`, query1.`region_id_1` AS `region_id_1`, query1.`region_1` AS `region_1`, query1.`is_internet_1` AS `is_internet_1`, query2.`Avg(发送包数)` AS `Avg(发送包数)` FROM query1 LEFT JOIN query2 ON query1.`region_0` = query2.`region_0` AND query1.`tap_side` = query2.`tap_side` AND query1.`is_internet_0` = query2.`is_internet_0` AND query1.`region_id_0` = query2.`region_id_0` AND query1.`client_node_type` = query2.`client_node_type` AND query1.`region_1` = query2.`region_1` AND query1.`is_internet_1` = query2.`is_internet_1` AND query1.`region_id_1` = query2.`region_id_1` AND query1.`server_node_type` = query2.`server_node_type`",
-		output:     []string{"WITH query1 AS (WITH dictGet('flow_tag.region_map', 'icon_id', (toUInt64(region_id_0))) AS `client_icon_id`, dictGetOrDefault('flow_tag.string_enum_map', 'name_en', ('observation_point',observation_point), observation_point) AS `Enum(tap_side)`, dictGet('flow_tag.region_map', 'icon_id', (toUInt64(region_id_1))) AS `server_icon_id`, if(SUMIf(response, response>0)>0, if(divide(SUM(server_error), SUMIf(response, response>0))>=0, least(divide(SUM(server_error), SUMIf(response, response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_server_error_sum_response_response>0`, if(SUMIf(rrt_count, rrt_count>0)>0, divide(SUM(rrt_sum), SUMIf(rrt_count, rrt_count>0)), null) AS `divide_0diveider_as_null_sum_rrt_sum_sum_rrt_count_rrt_count>0` SELECT 'region' AS `client_node_type`, `client_icon_id`, region_id_0, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, `Enum(tap_side)`, observation_point AS `tap_side`, if(l3_epc_id_0=-2,1,0) AS `is_internet_0`, 'region' AS `server_node_type`, `server_icon_id`, region_id_1, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_1))) AS `region_1`, if(l3_epc_id_1=-2,1,0) AS `is_internet_1`, divide(sum(request)/(1020/60), 60) AS `请求速率`, if(`divide_0diveider_as_null_sum_server_error_sum_response_response>0`>=0, least(`divide_0diveider_as_null_sum_server_error_sum_response_response>0`, 1), null)*100 AS `服务端异常比例`, `divide_0diveider_as_null_sum_rrt_sum_sum_rrt_count_rrt_count>0` AS `响应时延` FROM flow_metrics.`application_map.1m` WHERE `time` >= 1704338640 AND `time` <= 1704339600 GROUP BY `region_id_0`, `observation_point`, `is_internet_0`, `region_id_1`, `is_internet_1` ORDER BY `请求速率` desc LIMIT 0, 50), query2 AS (WITH dictGet('flow_tag.region_map', 'icon_id', (toUInt64(region_id_0))) AS `client_icon_id`, dictGetOrDefault('flow_tag.string_enum_map', 'name_en', ('observation_point',observation_point), observation_point) AS `Enum(tap_side)`, dictGet('flow_tag.region_map', 'icon_id', (toUInt64(region_id_1))) AS `server_icon_id` SELECT 'region' AS `client_node_type`, `client_icon_id`, region_id_0, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, `Enum(tap_side)`, observation_point AS `tap_side`, if(l3_epc_id_0=-2,1,0) AS `is_internet_0`, 'region' AS `server_node_type`, `server_icon_id`, region_id_1, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_1))) AS `region_1`, if(l3_epc_id_1=-2,1,0) AS `is_internet_1`, sum(packet_tx)/(1020/60) AS `Avg(发送包数)` FROM flow_metrics.`network_map.1m` WHERE `time` >= 1704338640 AND `time` <= 1704339600 GROUP BY `region_id_0`, `observation_point`, `is_internet_0`, `region_id_1`, `is_internet_1` LIMIT 50) SELECT query1.`请求速率` AS `请求速率`, query1.`服务端异常比例` AS `服务端异常比例`, query1.`响应时延` AS `响应时延`, query1.`client_node_type` AS `client_node_type`, query1.`client_icon_id` AS `client_icon_id`, query1.`region_id_0` AS `region_id_0`, query1.`region_0` AS `region_0`, query1.`Enum(tap_side)` AS `Enum(tap_side)`, query1.`tap_side` AS `tap_side`, query1.`is_internet_0` AS `is_internet_0`, query1.`server_node_type` AS `server_node_type`, query1.`server_icon_id` AS `server_icon_id`, query1.`region_id_1` AS `region_id_1`, query1.`region_1` AS `region_1`, query1.`is_internet_1` AS `is_internet_1`, query2.`Avg(发送包数)` AS `Avg(发送包数)` FROM query1 LEFT JOIN query2 ON query1.`region_0` = query2.`region_0` AND query1.`tap_side` = query2.`tap_side` AND query1.`is_internet_0` = query2.`is_internet_0` AND query1.`region_id_0` = query2.`region_id_0` AND query1.`client_node_type` = query2.`client_node_type` AND query1.`region_1` = query2.`region_1` AND query1.`is_internet_1` = query2.`is_internet_1` AND query1.`region_id_1` = query2.`region_id_1` AND query1.`server_node_type` = query2.`server_node_type`"},
+		output:     []string{"WITH query1 AS (WITH dictGetOrDefault('flow_tag.string_enum_map', 'name_en', ('observation_point',observation_point), observation_point) AS `Enum(tap_side)`, dictGet('flow_tag.region_map', 'icon_id', (toUInt64(region_id_0))) AS `client_icon_id`, if(SUMIf(rrt_count, rrt_count>0)>0, divide(SUM(rrt_sum), SUMIf(rrt_count, rrt_count>0)), null) AS `divide_0diveider_as_null_sum_rrt_sum_sum_rrt_count_rrt_count>0`, if(SUMIf(response, response>0)>0, if(divide(SUM(server_error), SUMIf(response, response>0))>=0, least(divide(SUM(server_error), SUMIf(response, response>0)), 1), null), null) AS `divide_0diveider_as_null_sum_server_error_sum_response_response>0`, dictGet('flow_tag.region_map', 'icon_id', (toUInt64(region_id_1))) AS `server_icon_id` SELECT divide(sum(request)/(1020/60), 60) AS `请求速率`, if(`divide_0diveider_as_null_sum_server_error_sum_response_response>0`>=0, least(`divide_0diveider_as_null_sum_server_error_sum_response_response>0`, 1), null)*100 AS `服务端异常比例`, `divide_0diveider_as_null_sum_rrt_sum_sum_rrt_count_rrt_count>0` AS `响应时延`, 'region' AS `client_node_type`, `client_icon_id`, region_id_0, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, `Enum(tap_side)`, observation_point AS `tap_side`, if(l3_epc_id_0=-2,1,0) AS `is_internet_0`, 'region' AS `server_node_type`, `server_icon_id`, region_id_1, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_1))) AS `region_1`, if(l3_epc_id_1=-2,1,0) AS `is_internet_1` FROM flow_metrics.`application_map.1m` WHERE `time` >= 1704338640 AND `time` <= 1704339600 GROUP BY `region_id_0`, `observation_point`, `is_internet_0`, `region_id_1`, `is_internet_1` ORDER BY `请求速率` desc LIMIT 0, 50), query2 AS (WITH dictGetOrDefault('flow_tag.string_enum_map', 'name_en', ('observation_point',observation_point), observation_point) AS `Enum(tap_side)`, dictGet('flow_tag.region_map', 'icon_id', (toUInt64(region_id_0))) AS `client_icon_id`, dictGet('flow_tag.region_map', 'icon_id', (toUInt64(region_id_1))) AS `server_icon_id` SELECT sum(packet_tx)/(1020/60) AS `Avg(发送包数)`, 'region' AS `client_node_type`, `client_icon_id`, region_id_0, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, `Enum(tap_side)`, observation_point AS `tap_side`, if(l3_epc_id_0=-2,1,0) AS `is_internet_0`, 'region' AS `server_node_type`, `server_icon_id`, region_id_1, dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_1))) AS `region_1`, if(l3_epc_id_1=-2,1,0) AS `is_internet_1` FROM flow_metrics.`network_map.1m` WHERE `time` >= 1704338640 AND `time` <= 1704339600 GROUP BY `region_id_0`, `observation_point`, `is_internet_0`, `region_id_1`, `is_internet_1` LIMIT 50) SELECT query1.`请求速率` AS `请求速率`, query1.`服务端异常比例` AS `服务端异常比例`, query1.`响应时延` AS `响应时延`, query1.`client_node_type` AS `client_node_type`, query1.`client_icon_id` AS `client_icon_id`, query1.`region_id_0` AS `region_id_0`, query1.`region_0` AS `region_0`, query1.`Enum(tap_side)` AS `Enum(tap_side)`, query1.`tap_side` AS `tap_side`, query1.`is_internet_0` AS `is_internet_0`, query1.`server_node_type` AS `server_node_type`, query1.`server_icon_id` AS `server_icon_id`, query1.`region_id_1` AS `region_id_1`, query1.`region_1` AS `region_1`, query1.`is_internet_1` AS `is_internet_1`, query2.`Avg(发送包数)` AS `Avg(发送包数)` FROM query1 LEFT JOIN query2 ON query1.`region_0` = query2.`region_0` AND query1.`tap_side` = query2.`tap_side` AND query1.`is_internet_0` = query2.`is_internet_0` AND query1.`region_id_0` = query2.`region_id_0` AND query1.`client_node_type` = query2.`client_node_type` AND query1.`region_1` = query2.`region_1` AND query1.`is_internet_1` = query2.`is_internet_1` AND query1.`region_id_1` = query2.`region_id_1` AND query1.`server_node_type` = query2.`server_node_type`"},
 	}, {
 		name:       "test_slimit",
 		db:         "flow_metrics",
 		datasource: "1m",
 		input:      "SELECT time(time,1,1,0) as toi, PerSecond(Avg(`byte`)) AS `流量速率`, pod as pod FROM `vtap_flow_port` WHERE time>=1705040184 AND time<=1705045184 GROUP BY toi, pod ORDER BY toi desc SLIMIT 5",
-		output:     []string{"WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id))) AS `pod`, toUnixTimestamp(`_toi`) AS `toi`, divide(sum(byte)/(60/60), 60) AS `流量速率` FROM flow_metrics.`network.1m` WHERE (pod) GLOBAL IN (SELECT dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id))) AS `pod` FROM flow_metrics.`network.1m` WHERE `time` >= 1705040184 AND `time` <= 1705045184 GROUP BY `pod_id` LIMIT 5) AND `time` >= 1705040184 AND `time` <= 1705045184 GROUP BY `toi`, `pod_id` ORDER BY `toi` desc LIMIT 10000"},
+		output:     []string{"WITH toStartOfInterval(time, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, divide(sum(byte)/(60/60), 60) AS `流量速率`, dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id))) AS `pod` FROM flow_metrics.`network.1m` WHERE (pod) GLOBAL IN (SELECT dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id))) AS `pod` FROM flow_metrics.`network.1m` WHERE `time` >= 1705040184 AND `time` <= 1705045184 GROUP BY `pod_id` LIMIT 5) AND `time` >= 1705040184 AND `time` <= 1705045184 GROUP BY `toi`, `pod_id` ORDER BY `toi` desc LIMIT 10000"},
 	}, {
 		name:       "test_host_hostname_ip",
 		db:         "flow_metrics",
@@ -483,7 +1218,7 @@ var (
 		db:         "flow_metrics",
 		datasource: "1m",
 		input:      "SELECT region_0 as region_0, chost_hostname_id_0, chost_ip_id_0, chost_hostname_0, chost_ip_0, node_type(chost_hostname_0) as `client_node_type`, icon_id(chost_hostname_0) as `client_icon_id` FROM `vtap_flow_edge_port` WHERE time>=1705040184 AND time<=1705045184 AND chost_hostname_0 != 'a' AND chost_hostname_id_0 != 1 AND chost_ip_id_0 != 2 GROUP BY region_0, chost_hostname_id_0, chost_ip_id_0, chost_hostname_0, chost_ip_0, `client_node_type` limit 5",
-		output:     []string{"WITH if(l3_device_type_0 = 1, l3_device_type_0, 0) AS `device_type_chost_hostname_0`, if(l3_device_type_0 = 1, l3_device_type_0, 0) AS `device_type_chost_ip_0`, if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `client_icon_id` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, if(l3_device_type_0=1,l3_device_id_0, 0) AS `chost_hostname_id_0`, if(l3_device_type_0=1,l3_device_id_0, 0) AS `chost_ip_id_0`, dictGet('flow_tag.device_map', 'hostname', (toUInt64(device_type_chost_hostname_0),toUInt64(l3_device_id_0))) AS `chost_hostname_0`, device_type_chost_hostname_0, dictGet('flow_tag.device_map', 'ip', (toUInt64(device_type_chost_ip_0),toUInt64(l3_device_id_0))) AS `chost_ip_0`, device_type_chost_ip_0, 'chost' AS `client_node_type`, `client_icon_id` FROM flow_metrics.`network_map.1m` WHERE `time` >= 1705040184 AND `time` <= 1705045184 AND (not(toUInt64(l3_device_id_0) GLOBAL IN (SELECT deviceid FROM flow_tag.device_map WHERE hostname = 'a' AND devicetype=1) AND l3_device_type_0=1)) AND (not(l3_device_id_0 = 1 AND l3_device_type_0=1)) AND (not(l3_device_id_0 = 2 AND l3_device_type_0=1)) AND (l3_device_id_0!=0 AND l3_device_type_0=1) AND (l3_device_id_0!=0 AND l3_device_type_0=1) AND (l3_device_id_0!=0 AND l3_device_type_0=1) AND (l3_device_id_0!=0 AND l3_device_type_0=1) GROUP BY `client_icon_id`, `region_id_0`, `chost_hostname_id_0`, `chost_ip_id_0`, `l3_device_id_0`, `device_type_chost_hostname_0`, `device_type_chost_ip_0` LIMIT 5"},
+		output:     []string{"WITH if(l3_device_type_0=1, dictGet('flow_tag.device_map', 'icon_id', (toUInt64(1),toUInt64(l3_device_id_0))), 0) AS `client_icon_id`, if(l3_device_type_0 = 1, l3_device_type_0, 0) AS `device_type_chost_hostname_0`, if(l3_device_type_0 = 1, l3_device_type_0, 0) AS `device_type_chost_ip_0` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, if(l3_device_type_0=1,l3_device_id_0, 0) AS `chost_hostname_id_0`, if(l3_device_type_0=1,l3_device_id_0, 0) AS `chost_ip_id_0`, dictGet('flow_tag.device_map', 'hostname', (toUInt64(device_type_chost_hostname_0),toUInt64(l3_device_id_0))) AS `chost_hostname_0`, device_type_chost_hostname_0, dictGet('flow_tag.device_map', 'ip', (toUInt64(device_type_chost_ip_0),toUInt64(l3_device_id_0))) AS `chost_ip_0`, device_type_chost_ip_0, 'chost' AS `client_node_type`, `client_icon_id` FROM flow_metrics.`network_map.1m` WHERE `time` >= 1705040184 AND `time` <= 1705045184 AND (not(toUInt64(l3_device_id_0) GLOBAL IN (SELECT deviceid FROM flow_tag.device_map WHERE hostname = 'a' AND devicetype=1) AND l3_device_type_0=1)) AND (not(l3_device_id_0 = 1 AND l3_device_type_0=1)) AND (not(l3_device_id_0 = 2 AND l3_device_type_0=1)) AND (l3_device_id_0!=0 AND l3_device_type_0=1) AND (l3_device_id_0!=0 AND l3_device_type_0=1) AND (l3_device_id_0!=0 AND l3_device_type_0=1) AND (l3_device_id_0!=0 AND l3_device_type_0=1) GROUP BY `client_icon_id`, `region_id_0`, `chost_hostname_id_0`, `chost_ip_id_0`, `l3_device_id_0`, `device_type_chost_hostname_0`, `device_type_chost_ip_0` LIMIT 5"},
 	}, {
 		name:       "test_pod_node_hostname_ip",
 		db:         "flow_metrics",
@@ -608,10 +1343,87 @@ var (
 		name:   "test_alert",
 		db:     "event",
 		input:  "SELECT Count(row), alert_policy, alert_policy_id, event_level, auto_service_0, auto_service_type_0, auto_service_type, auto_service FROM alert_event where auto_service='abc' AND auto_service_type_1=1 GROUP BY alert_policy, alert_policy_id, event_level, auto_service_0, auto_service_type_0, auto_service_type, auto_service LIMIT 1",
-		output: []string{"SELECT dictGet('flow_tag.alarm_policy_map', 'name', (toUInt64(policy_id))) AS `alert_policy`, policy_id AS `alert_policy_id`, event_level, tag_string_values[indexOf(tag_string_names,'auto_service_0')] AS `auto_service_0`, tag_int_values[indexOf(tag_int_names,'auto_service_type_0')] AS `auto_service_type_0`, tag_int_values[indexOf(tag_int_names,'auto_service_type')] AS `auto_service_type`, tag_string_values[indexOf(tag_string_names,'auto_service')] AS `auto_service`, COUNT(1) AS `Count(row)` FROM event.`alert_event` FINAL WHERE if(indexOf(tag_string_names,'auto_service')=0 AND indexOf(tag_string_names,'auto_service_0')=0 AND indexOf(tag_string_names,'auto_service_1')=0,1!=1,(tag_string_values[indexOf(tag_string_names,'auto_service')] = 'abc' OR tag_string_values[indexOf(tag_string_names,'auto_service_0')] = 'abc' OR tag_string_values[indexOf(tag_string_names,'auto_service_1')] = 'abc')) AND if(indexOf(tag_int_names,'auto_service_type_1')=0,NULL,tag_int_values[indexOf(tag_int_names,'auto_service_type_1')]) = 1 GROUP BY `policy_id`, `event_level`, `auto_service_0`, `auto_service_type_0`, `auto_service_type`, `auto_service` LIMIT 1"},
+		output: []string{"SELECT COUNT(1) AS `Count(row)`, dictGet('flow_tag.alarm_policy_map', 'name', (toUInt64(policy_id))) AS `alert_policy`, policy_id AS `alert_policy_id`, event_level, tag_string_values[indexOf(tag_string_names,'auto_service_0')] AS `auto_service_0`, tag_int_values[indexOf(tag_int_names,'auto_service_type_0')] AS `auto_service_type_0`, tag_int_values[indexOf(tag_int_names,'auto_service_type')] AS `auto_service_type`, tag_string_values[indexOf(tag_string_names,'auto_service')] AS `auto_service` FROM event.`alert_event` FINAL WHERE if(indexOf(tag_string_names,'auto_service')=0 AND indexOf(tag_string_names,'auto_service_0')=0 AND indexOf(tag_string_names,'auto_service_1')=0,1!=1,(tag_string_values[indexOf(tag_string_names,'auto_service')] = 'abc' OR tag_string_values[indexOf(tag_string_names,'auto_service_0')] = 'abc' OR tag_string_values[indexOf(tag_string_names,'auto_service_1')] = 'abc')) AND if(indexOf(tag_int_names,'auto_service_type_1')=0,NULL,tag_int_values[indexOf(tag_int_names,'auto_service_type_1')]) = 1 GROUP BY `policy_id`, `event_level`, `auto_service_0`, `auto_service_type_0`, `auto_service_type`, `auto_service` LIMIT 1"},
 	}}
 )
 
+// flow_metrics中的指标量已在写入时预聚合，Avg及Max按db_descriptions/clickhouse/pre_aggregation中的定义改写
+var flowMetricsSQL = []struct {
+	name   string
+	input  string
+	output string
+	db     string
+}{{
+	name:   "weighted_avg",
+	input:  "select Avg(rtt) as avg_rtt from vtap_flow_edge_port limit 1",
+	output: "WITH if(SUMIf(rtt_count, rtt_count>0)>0, divide(SUM(rtt_sum), SUMIf(rtt_count, rtt_count>0)), null) AS `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count_rtt_count>0` SELECT `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count_rtt_count>0` AS `avg_rtt` FROM flow_metrics.`network_map` LIMIT 1",
+	db:     "flow_metrics",
+}, {
+	name:   "weighted_avg_layered",
+	input:  "select Avg(`rtt`) AS `Avg(rtt)`,Max(`byte`) AS `Max(byte)`,region_0 from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
+	output: "SELECT AVGIf(`_div__sum_rtt_sum__sum_rtt_count`, `_div__sum_rtt_sum__sum_rtt_count` > 0) AS `Avg(rtt)`, MAX(`_sum_byte`) AS `Max(byte)`, region_0 FROM (WITH if(SUM(rtt_count)>0, divide(SUM(rtt_sum), SUM(rtt_count)), null) AS `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` AS `_div__sum_rtt_sum__sum_rtt_count`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1",
+	db:     "flow_metrics",
+}, {
+	// 时延类保留include_zero的处理
+	name:   "weighted_avg_include_zero",
+	input:  "select Avg(`rtt`, include_zero=true) AS `Avg(rtt)`,Max(`byte`) AS `Max(byte)`,region_0 from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 group by region_0 limit 1",
+	output: "SELECT AVG(`_div__sum_rtt_sum__sum_rtt_count`) AS `Avg(rtt)`, MAX(`_sum_byte`) AS `Max(byte)`, region_0 FROM (WITH if(SUM(rtt_count)>0, divide(SUM(rtt_sum), SUM(rtt_count)), null) AS `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, `divide_0diveider_as_null_sum_rtt_sum_sum_rtt_count` AS `_div__sum_rtt_sum__sum_rtt_count`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1",
+	db:     "flow_metrics",
+}, {
+	name:   "stored_max",
+	input:  "select Max(rtt) as max_rtt from vtap_flow_edge_port limit 1",
+	output: "SELECT MAXIf(rtt_max, rtt_max > 0) AS `max_rtt` FROM flow_metrics.`network_map` LIMIT 1",
+	db:     "flow_metrics",
+}, {
+	name:   "stored_max_layered",
+	input:  "select Max(rtt) as max_rtt, Max(byte) as max_byte, region_0 from vtap_flow_edge_port group by region_0 limit 1",
+	output: "SELECT MAXIf(`_max_rtt_max`, `_max_rtt_max` > 0) AS `max_rtt`, MAX(`_sum_byte`) AS `max_byte`, region_0 FROM (SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`, region_id_0, MAXIf(rtt_max, rtt_max > 0) AS `_max_rtt_max`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map` GROUP BY `region_id_0`) GROUP BY `region_id_0`, `region_0` LIMIT 1",
+	db:     "flow_metrics",
+}, {
+	name:   "stored_max_application",
+	input:  "select Max(rrt) as max_rrt, auto_service_id from vtap_app_port group by auto_service_id limit 1",
+	output: "SELECT MAXIf(rrt_max, rrt_max > 0) AS `max_rrt`, if(auto_service_type in (0,255),subnet_id,auto_service_id) AS `auto_service_id` FROM flow_metrics.`application` GROUP BY `auto_service_id` LIMIT 1",
+	db:     "flow_metrics",
+}, {
+	name:   "undefined_metric",
+	input:  "select Avg(byte_tx) as avg_byte_tx from vtap_flow_edge_port where `time` >= 60 AND `time` <= 180 limit 1",
+	output: "SELECT sum(byte_tx)/(121/1) AS `avg_byte_tx` FROM flow_metrics.`network_map` WHERE `time` >= 60 AND `time` <= 180 LIMIT 1",
+	db:     "flow_metrics",
+}, {
+	name:   "not_pre_aggregated",
+	input:  "select Max(rtt) as max_rtt from l4_flow_log limit 1",
+	output: "SELECT MAXIf(rtt, rtt > 0) AS `max_rtt` FROM flow_log.`l4_flow_log` LIMIT 1",
+	db:     "flow_log",
+}}
+
+func TestFlowMetricsPreAggregation(t *testing.T) {
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	// 使用db_descriptions中加载的定义
+	if p, ok := metrics.GetPreAggregation("flow_metrics", "network_map.1m", "rtt"); !ok || p.Avg != "rtt_sum/rtt_count" || p.Max != "rtt_max" {
+		t.Fatalf("pre aggregation of network_map rtt: get %+v", p)
+	}
+
+	for _, pcase := range flowMetricsSQL {
+		e := CHEngine{DB: pcase.db, Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(pcase.input); err != nil {
+			t.Errorf("%s: unexpected error %v", pcase.name, err)
+			continue
+		}
+		if out := e.ToSQLString(); out != pcase.output {
+			t.Errorf("\nParse [%s]\n\t%q \n get: \n\t%q \n want: \n\t%q", pcase.name, pcase.input, out, pcase.output)
+		}
+	}
+}
+
 func TestGetSql(t *testing.T) {
 	var c *client.Client
 	result := &common.Result{}
@@ -624,6 +1436,12 @@ func TestGetSql(t *testing.T) {
 	defer httpmock.DeactivateAndReset()
 	mockDatasources()
 	mockNativeFields()
+	datasourceCache := NewDatasourceCache(chCommon.GetDatasourceInterval, time.Hour)
+	for _, table := range []string{"network", "network_map", "application", "application_map"} {
+		for _, name := range chCommon.FLOW_METRICS_DATASOURCES {
+			datasourceCache.Load(chCommon.DB_NAME_FLOW_METRICS, table, name, common.DEFAULT_ORG_ID)
+		}
+	}
 
 	for i, pcase := range parseSQL {
 		if len(pcase.output) == 0 {
@@ -634,7 +1452,7 @@ func TestGetSql(t *testing.T) {
 			db = "flow_log"
 		}
 		// test language en
-		e := CHEngine{DB: db, Language: "en"}
+		e := CHEngine{DB: db, CompileOptions: CompileOptions{Language: "en", NoDivZeroGuard: pcase.noDivGuard, AllowRawExpr: pcase.allowRawExpr, TimestampMilli: pcase.timestampMilli, MaxOffset: pcase.maxOffset}, DatasourceCache: datasourceCache}
 		if pcase.datasource != "" {
 			e.DataSource = pcase.datasource
 		}
@@ -649,6 +1467,9 @@ func TestGetSql(t *testing.T) {
 		if strings.HasPrefix(pcase.input, "WITH") {
 			outSql, _, _, err = e.ParseWithSql(pcase.input)
 			out = append(out, outSql)
+		} else if strings.Contains(pcase.input, "UNION") {
+			outSql, _, _, err = e.ParseUnionSql(pcase.input)
+			out = append(out, outSql)
 		} else if strings.Contains(pcase.input, "SLIMIT") || strings.Contains(pcase.input, "slimit") {
 			outSql, _, _, err = e.ParseSlimitSql(pcase.input, args)
 			out = append(out, outSql)
@@ -703,36 +1524,1491 @@ func TestGetSql(t *testing.T) {
 	 }
  } */
 
-func Load() error {
-	ServerCfg := config.DefaultConfig()
-	config.Cfg = &ServerCfg.QuerierConfig
-	config.Cfg.Clickhouse.Version = "24.8"
-	config.ControllerCfg = &ServerCfg.ControllerConfig
-	dir := "../../db_descriptions"
-	dbDescriptions, err := common.LoadDbDescriptions(dir)
-	if err != nil {
-		return err
+func TestValidate(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	for _, tc := range []struct {
+		name    string
+		input   string
+		errType string
+		errName string
+	}{{
+		name:  "valid",
+		input: "select region_0, Sum(byte) as sum_byte, Percentile(rtt, 50) as p50 from l4_flow_log group by region_0 limit 1",
+	}, {
+		name:  "valid_count_alias",
+		input: "select Count() as c, Count(distinct ip_0) as n from l4_flow_log limit 1",
+	}, {
+		name:  "valid_uppercase",
+		input: "SELECT REGION_0, Sum(BYTE) AS Sum_Byte FROM L4_FLOW_LOG GROUP BY REGION_0 LIMIT 1",
+	}, {
+		name:    "syntax_error",
+		input:   "select from l4_flow_log where",
+		errType: VALIDATE_ERROR_SYNTAX,
+	}, {
+		name:    "unknown_tag",
+		input:   "select no_such_tag from l4_flow_log limit 1",
+		errType: VALIDATE_ERROR_UNKNOWN_TAG,
+		errName: "no_such_tag",
+	}, {
+		name:    "unknown_group_tag",
+		input:   "select Sum(byte) as sum_byte from l4_flow_log group by no_such_tag limit 1",
+		errType: VALIDATE_ERROR_UNKNOWN_TAG,
+		errName: "no_such_tag",
+	}, {
+		name:    "unknown_metric",
+		input:   "select Sum(no_such_metric) as s from l4_flow_log limit 1",
+		errType: VALIDATE_ERROR_UNKNOWN_TAG,
+		errName: "no_such_metric",
+	}, {
+		name:    "unknown_function",
+		input:   "select NoSuchFunc(byte) as s from l4_flow_log limit 1",
+		errType: VALIDATE_ERROR_UNKNOWN_FUNCTION,
+		errName: "NoSuchFunc",
+	}, {
+		name:    "too_many_arguments",
+		input:   "select Sum(byte, 1) as s from l4_flow_log limit 1",
+		errType: VALIDATE_ERROR_ARGUMENT_COUNT,
+		errName: "Sum",
+	}, {
+		name:    "too_few_arguments",
+		input:   "select Percentile(rtt) as p from l4_flow_log limit 1",
+		errType: VALIDATE_ERROR_ARGUMENT_COUNT,
+		errName: "Percentile",
+	}} {
+		e := CHEngine{DB: "flow_log", Context: context.Background()}
+		e.Init()
+		err := e.Validate(tc.input)
+		if tc.errType == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tc.name, err)
+			}
+			continue
+		}
+		var validateErr *ValidateError
+		if !errors.As(err, &validateErr) {
+			t.Errorf("%s: get error %v, want %s", tc.name, err, tc.errType)
+			continue
+		}
+		if validateErr.Type != tc.errType || validateErr.Name != tc.errName {
+			t.Errorf("%s: get %s(%s), want %s(%s)", tc.name, validateErr.Type, validateErr.Name, tc.errType, tc.errName)
+		}
 	}
-	err = LoadDbDescriptions(dbDescriptions)
-	if err != nil {
-		return err
+	// 语法错误包含出错token在原始sql中的位置
+	e := CHEngine{DB: "flow_log", Context: context.Background()}
+	e.Init()
+	var parseErr *parse.ParseError
+	if err := e.Validate("select region_0, from l4_flow_log limit 1"); !errors.As(err, &parseErr) || parseErr.Position != 17 || parseErr.Near != "from" {
+		t.Errorf("syntax_error_position: get %v", err)
 	}
-	metrics.DB_DESCRIPTIONS = dbDescriptions
-	return nil
 }
 
-func mockDatasources() {
-	httpmock.RegisterResponder(
-		"GET", "http://localhost:20417/v1/data-sources/",
-		func(req *http.Request) (*http.Response, error) {
-			values := req.URL.Query()
-			name := values.Get("name")
-			if name == "" {
-				name = "1s"
-			}
+func TestGetColumnTypes(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
 
-			return httpmock.NewStringResponse(200,
-				fmt.Sprintf(`{"DATA":[{"NAME":"%s","INTERVAL":%d}]}`,
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  []ColumnType
+	}{{
+		name:  "time_metric_ip",
+		input: "select time(time, 60) as toi, Sum(byte) as sum_byte, ip_0 from l4_flow_log group by toi, ip_0 limit 1",
+		want:  []ColumnType{{Alias: "toi", Type: COLUMN_TYPE_TIME}, {Alias: "sum_byte", Type: COLUMN_TYPE_INT}, {Alias: "ip_0", Type: COLUMN_TYPE_IP}},
+	}, {
+		name:  "float_and_string",
+		input: "select region_0, Avg(rtt) as avg_rtt, Sum(byte)/Sum(packet) as bpp, Uniq(ip_0) as n from l4_flow_log group by region_0 limit 1",
+		want:  []ColumnType{{Alias: "region_0", Type: COLUMN_TYPE_STRING}, {Alias: "avg_rtt", Type: COLUMN_TYPE_FLOAT}, {Alias: "bpp", Type: COLUMN_TYPE_FLOAT}, {Alias: "n", Type: COLUMN_TYPE_INT}},
+	}, {
+		name:  "negative_literal",
+		input: "select -1 as neg_one, -0.5 as neg_ratio from l4_flow_log limit 1",
+		want:  []ColumnType{{Alias: "neg_one", Type: COLUMN_TYPE_INT}, {Alias: "neg_ratio", Type: COLUMN_TYPE_FLOAT}},
+	}} {
+		e := CHEngine{DB: "flow_log", Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(tc.input); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if got := e.GetColumnTypes(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s:\nget:  %v\nwant: %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTimeWhere(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	// 2023-09-01T00:00:00Z
+	now := time.Unix(1693526400, 0)
+	for _, tc := range []struct {
+		name      string
+		where     string
+		output    string
+		timeStart int64
+		timeEnd   int64
+		wantErr   string
+	}{{
+		name:      "relative",
+		where:     "time >= now() - 300 and time <= now()",
+		output:    "`time` >= 1693526100 AND `time` <= 1693526400",
+		timeStart: 1693526100,
+		timeEnd:   1693526400,
+	}, {
+		name:      "iso",
+		where:     "time >= '2023-08-31T23:00:00Z' and time < '2023-09-01T08:00:00+08:00'",
+		output:    "`time` >= 1693522800 AND `time` < 1693526400",
+		timeStart: 1693522800,
+		timeEnd:   1693526400,
+	}, {
+		name:      "mixed",
+		where:     "time >= now() - 3600 and time >= 1693525000 and time <= now()",
+		output:    "`time` >= 1693522800 AND `time` >= 1693525000 AND `time` <= 1693526400",
+		timeStart: 1693525000,
+		timeEnd:   1693526400,
+	}, {
+		name:    "start_after_end",
+		where:   "time >= now() and time <= now() - 60",
+		wantErr: "time range is invalid: start time 1693526400 is later than end time 1693526340",
+	}, {
+		name:    "invalid_iso",
+		where:   "time >= '2023-09-01'",
+		wantErr: "time: '2023-09-01' is not a RFC3339 time",
+	}} {
+		e := CHEngine{DB: "flow_log", Context: context.Background(), Now: func() time.Time { return now }}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		err := parser.ParseSQL("select Avg(rtt) as avg_rtt from l4_flow_log where " + tc.where + " limit 1")
+		if tc.wantErr != "" {
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("%s: get error %v, want %s", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		want := "SELECT AVGIf(rtt, rtt > 0) AS `avg_rtt` FROM flow_log.`l4_flow_log` WHERE " + tc.output + " LIMIT 1"
+		if out := e.ToSQLString(); out != want {
+			t.Errorf("%s: get %s, want %s", tc.name, out, want)
+		}
+		if e.Model.Time.TimeStart != tc.timeStart || e.Model.Time.TimeEnd != tc.timeEnd {
+			t.Errorf("%s: get time range [%d, %d], want [%d, %d]", tc.name, e.Model.Time.TimeStart, e.Model.Time.TimeEnd, tc.timeStart, tc.timeEnd)
+		}
+	}
+}
+
+func TestTimeAlign(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	for _, tc := range []struct {
+		name   string
+		input  string
+		align  bool
+		filter string
+		meta   map[string]interface{}
+	}{{
+		name:   "engine_option",
+		input:  "select time(time, 120) as toi, Sum(byte) as s from `network.1m` where time >= 65 and time <= 185 group by toi limit 1",
+		align:  true,
+		filter: "`time` >= 60 AND `time` <= 239",
+		meta: map[string]interface{}{
+			"requested_time_range": []int64{65, 185},
+			"effective_time_range": []int64{60, 239},
+		},
+	}, {
+		name:   "time_argument",
+		input:  "select time(time, 120, 'align') as toi, Sum(byte) as s from `network.1m` where time > 65 and time < 185 group by toi limit 1",
+		filter: "`time` >= 60 AND `time` < 240",
+		meta: map[string]interface{}{
+			"requested_time_range": []int64{65, 185},
+			"effective_time_range": []int64{60, 240},
+		},
+	}, {
+		name:   "unaligned",
+		input:  "select time(time, 120) as toi, Sum(byte) as s from `network.1m` where time >= 65 and time <= 185 group by toi limit 1",
+		filter: "`time` >= 65 AND `time` <= 185",
+	}, {
+		name:   "no_time_group",
+		input:  "select Sum(byte) as s from `network.1m` where time >= 65 and time <= 185 limit 1",
+		align:  true,
+		filter: "`time` >= 65 AND `time` <= 185",
+	}} {
+		e := CHEngine{DB: "flow_metrics", Context: context.Background(), CompileOptions: CompileOptions{AlignTimeRange: tc.align}}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(tc.input); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if out := e.ToSQLString(); !strings.Contains(out, tc.filter) {
+			t.Errorf("%s: get %s, want filter %s", tc.name, out, tc.filter)
+		}
+		if meta := e.TimeRangeMeta(); !reflect.DeepEqual(meta, tc.meta) && (len(meta) != 0 || len(tc.meta) != 0) {
+			t.Errorf("%s: get meta %v, want %v", tc.name, meta, tc.meta)
+		}
+	}
+}
+
+func TestMaxPoints(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	translate := func(sql string, maxPoints int) (string, map[string]interface{}, error) {
+		e := CHEngine{DB: "flow_metrics", Context: context.Background(), CompileOptions: CompileOptions{MaxPoints: maxPoints, ExactInterval: exactIntervalRegexp.MatchString(sql)}}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(sql); err != nil {
+			return "", nil, err
+		}
+		return e.ToSQLString(), e.TimeRangeMeta(), nil
+	}
+	// 1天的时间范围最多100个点时，间隔至少为864秒，向上取整到数据源粒度60秒为900秒
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  string
+		meta  map[string]interface{}
+	}{{
+		name:  "bump",
+		input: "select time(time, 60) as toi, Sum(byte) as s from `network.1m` where time >= 1700006400 and time < 1700092800 group by toi limit 1",
+		want:  "select time(time, 900) as toi, Sum(byte) as s from `network.1m` where time >= 1700006400 and time < 1700092800 group by toi limit 1",
+		meta:  map[string]interface{}{"requested_interval": 60, "effective_interval": 900},
+	}, {
+		name:  "enough_interval",
+		input: "select time(time, 3600) as toi, Sum(byte) as s from `network.1m` where time >= 1700006400 and time < 1700092800 group by toi limit 1",
+		want:  "select time(time, 3600) as toi, Sum(byte) as s from `network.1m` where time >= 1700006400 and time < 1700092800 group by toi limit 1",
+	}, {
+		name:  "exact_interval",
+		input: "select /* exact_interval */ time(time, 60) as toi, Sum(byte) as s from `network.1m` where time >= 1700006400 and time < 1700092800 group by toi limit 1",
+		want:  "select time(time, 60) as toi, Sum(byte) as s from `network.1m` where time >= 1700006400 and time < 1700092800 group by toi limit 1",
+	}, {
+		name:  "no_time_group",
+		input: "select Sum(byte) as s from `network.1m` where time >= 1700006400 and time < 1700092800 limit 1",
+		want:  "select Sum(byte) as s from `network.1m` where time >= 1700006400 and time < 1700092800 limit 1",
+	}} {
+		out, meta, err := translate(tc.input, 100)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		want, _, _ := translate(tc.want, 0)
+		if out != want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, out, want)
+		}
+		if !reflect.DeepEqual(meta, tc.meta) && (len(meta) != 0 || len(tc.meta) != 0) {
+			t.Errorf("%s: get meta %v, want %v", tc.name, meta, tc.meta)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	translate := func(sql string) (*CHEngine, string, error) {
+		e := CHEngine{DB: "flow_log", Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(sql); err != nil {
+			return nil, "", err
+		}
+		return &e, e.ToSQLString(), nil
+	}
+	// BETWEEN与两端都包含的>=及<=等价
+	for _, tc := range []struct {
+		name      string
+		input     string
+		want      string
+		timeStart int64
+		timeEnd   int64
+	}{{
+		name:  "tag",
+		input: "select Sum(byte) as s from l4_flow_log where server_port between 8000 and 9000 limit 1",
+		want:  "select Sum(byte) as s from l4_flow_log where (server_port >= 8000 and server_port <= 9000) limit 1",
+	}, {
+		name:  "not_between",
+		input: "select Sum(byte) as s from l4_flow_log where server_port not between 8000 and 9000 limit 1",
+		want:  "select Sum(byte) as s from l4_flow_log where not (server_port >= 8000 and server_port <= 9000) limit 1",
+	}, {
+		name:  "metric_or",
+		input: "select Sum(byte) as s from l4_flow_log where rtt between 1000 and 5000 or byte > 10 limit 1",
+		want:  "select Sum(byte) as s from l4_flow_log where (rtt >= 1000 and rtt <= 5000) or byte > 10 limit 1",
+	}, {
+		name:  "half_open",
+		input: "select Sum(byte) as s from l4_flow_log where rtt >= 1000 and rtt < 5000 limit 1",
+		want:  "select Sum(byte) as s from l4_flow_log where rtt >= 1000 and rtt < 5000 limit 1",
+	}, {
+		name:      "time",
+		input:     "select Sum(byte) as s from l4_flow_log where time between 100 and 200 and server_port = 80 limit 1",
+		want:      "select Sum(byte) as s from l4_flow_log where (time >= 100 and time <= 200) and server_port = 80 limit 1",
+		timeStart: 100,
+		timeEnd:   200,
+	}} {
+		e, out, err := translate(tc.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		_, want, err := translate(tc.want)
+		if err != nil || out != want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, out, want)
+		}
+		if e.Model.Time.TimeStart != tc.timeStart || e.Model.Time.TimeEnd != tc.timeEnd {
+			t.Errorf("%s: get time range [%d, %d], want [%d, %d]", tc.name, e.Model.Time.TimeStart, e.Model.Time.TimeEnd, tc.timeStart, tc.timeEnd)
+		}
+	}
+	if _, _, err := translate("select Sum(byte) as s from l4_flow_log where region_0 between 'a' and 'b' limit 1"); err == nil || err.Error() != "between does not support string tag region_0" {
+		t.Errorf("string_tag: get %v", err)
+	}
+}
+
+func TestPreWhere(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	translate := func(sql string, preWhere, noPreWhere bool) string {
+		e := CHEngine{DB: "flow_log", Context: context.Background(), CompileOptions: CompileOptions{PreWhere: preWhere, NoPreWhere: noPreWhere}}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(sql); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return e.ToSQLString()
+	}
+	sql := "select Sum(byte) as s from l4_flow_log where time >= 100 and time <= 200 and observation_point = 'c' and rtt > 10 and region_0 = 'a' limit 1"
+	out := translate(sql, true, false)
+	prewhere, where, ok := strings.Cut(out, " WHERE ")
+	if !ok || !strings.Contains(prewhere, " PREWHERE ") {
+		t.Fatalf("get %s, want both PREWHERE and WHERE", out)
+	}
+	_, prewhere, _ = strings.Cut(prewhere, " PREWHERE ")
+	// 简单的列比较放入PREWHERE
+	for _, filter := range []string{"observation_point = 'c'", "rtt > 10"} {
+		if !strings.Contains(prewhere, filter) || strings.Contains(where, filter) {
+			t.Errorf("%s should be in PREWHERE: %s", filter, out)
+		}
+	}
+	// 时间过滤及子查询保留在WHERE
+	for _, filter := range []string{"`time` >= 100", "`time` <= 200", "flow_tag.region_map"} {
+		if strings.Contains(prewhere, filter) || !strings.Contains(where, filter) {
+			t.Errorf("%s should be in WHERE: %s", filter, out)
+		}
+	}
+	// 未开启或no_prewhere时只有WHERE
+	for _, out := range []string{translate(sql, false, false), translate(sql, true, true)} {
+		if strings.Contains(out, "PREWHERE") || !strings.Contains(out, "observation_point = 'c'") {
+			t.Errorf("get %s, want all filters in WHERE", out)
+		}
+	}
+}
+
+// TestParameterizedSQL 参数化的sql代入参数后与常量内联的sql相同
+func TestParameterizedSQL(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	placeholderRegexp := regexp.MustCompile(`\{(p\d+):(\w+)\}`)
+	for _, tc := range []struct {
+		name   string
+		input  string
+		output string
+		params map[string]string
+	}{{
+		name:   "time",
+		input:  "select Avg(rtt) as avg_rtt from l4_flow_log where time >= 100+1 and time <= 102 limit 1",
+		output: "SELECT AVGIf(rtt, rtt > 0) AS `avg_rtt` FROM flow_log.`l4_flow_log` WHERE `time` >= {p0:UInt64} + {p1:UInt64} AND `time` <= {p2:UInt64} LIMIT 1",
+		params: map[string]string{"p0": "100", "p1": "1", "p2": "102"},
+	}, {
+		name:  "tag",
+		input: "select Sum(byte) as sum_byte, region_0 from l4_flow_log where time >= 100 and region_0 = 'a' and server_port in (80, 443) and rtt > 1.5 group by region_0 having sum_byte > 100 limit 1",
+	}, {
+		name:  "ip",
+		input: "select byte from l4_flow_log where ip_0 = '1.1.1.1' or ip_1 << '10.0.0.0/8'",
+	}} {
+		e := CHEngine{DB: "flow_log", Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(tc.input); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		parameterized, params := e.ToParameterizedSQLString()
+		inlined := e.ToSQLString()
+		if tc.output != "" && (parameterized != tc.output || !reflect.DeepEqual(params, tc.params)) {
+			t.Errorf("%s:\nget:  %s %v\nwant: %s %v", tc.name, parameterized, params, tc.output, tc.params)
+		}
+		if parameterized == inlined || len(params) == 0 {
+			t.Errorf("%s: filters are not parameterized: %s", tc.name, parameterized)
+		}
+		substituted := placeholderRegexp.ReplaceAllStringFunc(parameterized, func(placeholder string) string {
+			match := placeholderRegexp.FindStringSubmatch(placeholder)
+			value := params[match[1]]
+			if match[2] == "String" {
+				return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+			}
+			return value
+		})
+		if substituted != inlined {
+			t.Errorf("%s:\nsubstituted: %s\ninlined:     %s", tc.name, substituted, inlined)
+		}
+	}
+}
+
+func TestTimeColumn(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	timeColumns := chCommon.DB_TIME_COLUMN_MAP
+	defer func() { chCommon.DB_TIME_COLUMN_MAP = timeColumns }()
+	if err := chCommon.LoadTimeColumns([][]interface{}{{"flow_log", "timestamp"}, {"flow_metrics", "timestamp"}}); err != nil {
+		t.Fatalf("load time columns failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		db     string
+		input  string
+		output string
+	}{{
+		name:   "where",
+		db:     "flow_log",
+		input:  "select Avg(rtt) as avg_rtt from l4_flow_log where time >= 60 and time <= 120 limit 1",
+		output: "SELECT AVGIf(rtt, rtt > 0) AS `avg_rtt` FROM flow_log.`l4_flow_log` WHERE `timestamp` >= 60 AND `timestamp` <= 120 LIMIT 1",
+	}, {
+		name:   "select",
+		db:     "flow_log",
+		input:  "select time from l4_flow_log limit 1",
+		output: "SELECT timestamp AS `time` FROM flow_log.`l4_flow_log` LIMIT 1",
+	}, {
+		name:   "time_delta",
+		db:     "flow_log",
+		input:  "select time(time, 60) as toi, Delta(byte) as delta_byte from l4_flow_log group by toi limit 1",
+		output: "WITH toStartOfInterval(timestamp, toIntervalSecond(60)) + toIntervalSecond(arrayJoin([0]) * 60) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, minus(argMax(byte_tx+byte_rx, timestamp), argMin(byte_tx+byte_rx, timestamp)) AS `delta_byte` FROM flow_log.`l4_flow_log` GROUP BY `toi` LIMIT 1",
+	}, {
+		name:   "last",
+		db:     "flow_log",
+		input:  "select Last(rtt) as last_rtt from l4_flow_log limit 1",
+		output: "SELECT argMax(rtt, timestamp) AS `last_rtt` FROM flow_log.`l4_flow_log` LIMIT 1",
+	}, {
+		name:   "delta_layered",
+		db:     "flow_metrics",
+		input:  "select time(time, 120) as toi, Delta(byte_tx) as delta_byte_tx, Max(byte) as max_byte from vtap_flow_edge_port group by toi limit 1",
+		output: "WITH toStartOfInterval(_time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, minus(argMax(`_sum_byte_tx`, _time), argMin(`_sum_byte_tx`, _time)) AS `delta_byte_tx`, MAX(`_sum_byte`) AS `max_byte` FROM (WITH toStartOfInterval(timestamp, toIntervalSecond(60)) AS `_time` SELECT _time, SUM(byte_tx) AS `_sum_byte_tx`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map.1m` GROUP BY `_time`) GROUP BY `toi` LIMIT 1",
+	}, {
+		name:   "time_layered",
+		db:     "flow_metrics",
+		input:  "select time(time, 120) as toi, Last(byte_tx) as last_byte_tx, Max(byte) as max_byte from vtap_flow_edge_port group by toi limit 1",
+		output: "WITH toStartOfInterval(_time, toIntervalSecond(120)) + toIntervalSecond(arrayJoin([0]) * 120) AS `_toi` SELECT toUnixTimestamp(`_toi`) AS `toi`, argMax(`_sum_byte_tx`, _time) AS `last_byte_tx`, MAX(`_sum_byte`) AS `max_byte` FROM (WITH toStartOfInterval(timestamp, toIntervalSecond(60)) AS `_time` SELECT _time, SUM(byte_tx) AS `_sum_byte_tx`, SUM(byte) AS `_sum_byte` FROM flow_metrics.`network_map.1m` GROUP BY `_time`) GROUP BY `toi` LIMIT 1",
+	}} {
+		e := CHEngine{DB: tc.db, Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(tc.input); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if out := e.ToSQLString(); out != tc.output {
+			t.Errorf("%s: get %s, want %s", tc.name, out, tc.output)
+		}
+	}
+
+	if err := chCommon.LoadTimeColumns([][]interface{}{{"flow_log"}}); err == nil {
+		t.Error("invalid_description: want error")
+	}
+}
+
+// TestSQLDeterministic 相同的查询多次翻译得到的sql逐字节相同，便于缓存及比对
+func TestSQLDeterministic(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	translate := func(db, sql string) (string, error) {
+		e := CHEngine{DB: db, Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(sql); err != nil {
+			return "", err
+		}
+		return e.ToSQLString(), nil
+	}
+	for i, pcase := range parseSQL {
+		// WITH、UNION、SLIMIT及SHOW不经过单条sql的翻译流程
+		input := strings.ToUpper(pcase.input)
+		if strings.HasPrefix(input, "WITH") || strings.HasPrefix(input, "SHOW") || strings.Contains(input, "UNION") || strings.Contains(input, "SLIMIT") {
+			continue
+		}
+		db := pcase.db
+		if db == "" {
+			db = "flow_log"
+		}
+		caseName := pcase.name
+		if caseName == "" {
+			caseName = strconv.Itoa(i)
+		}
+		first, err := translate(db, pcase.input)
+		if err != nil {
+			continue
+		}
+		for j := 0; j < 5; j++ {
+			if out, _ := translate(db, pcase.input); out != first {
+				t.Errorf("\nParse [%s]\n\t%q \n get: \n\t%q \n want: \n\t%q", caseName, pcase.input, out, first)
+				break
+			}
+		}
+	}
+}
+
+func TestQueryCost(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	queryCost := func(sql string) *QueryCost {
+		e := CHEngine{DB: "flow_log", Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(sql); err != nil {
+			t.Fatalf("%s: unexpected error %v", sql, err)
+		}
+		return e.QueryCost()
+	}
+	narrow := queryCost("select Sum(byte) as sum_byte from l4_flow_log where time >= 3600 and time <= 7199 limit 1")
+	wide := queryCost("select Sum(byte) as sum_byte from l4_flow_log where time >= 3600 and time <= 90000 limit 1")
+	filtered := queryCost("select Sum(byte) as sum_byte from l4_flow_log where time >= 3600 and time <= 90000 and region_0 = 'a' limit 1")
+
+	if narrow.Partitions != 1 || wide.Partitions != 25 {
+		t.Errorf("partitions: get narrow %d wide %d, want narrow 1 wide 25", narrow.Partitions, wide.Partitions)
+	}
+	if narrow.Score >= wide.Score {
+		t.Errorf("narrow time range should cost less: narrow %f wide %f", narrow.Score, wide.Score)
+	}
+	// 非时间过滤条件可下推到PREWHERE，不减少分区但减少扫描量
+	if filtered.Partitions != wide.Partitions || filtered.Score >= wide.Score {
+		t.Errorf("filter should narrow the scan: filtered %+v wide %+v", filtered, wide)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	for _, tc := range []struct {
+		name     string
+		input    string
+		defaults map[string]string
+		output   string
+	}{{
+		name:     "defaults_only",
+		input:    "select Sum(byte) as sum_byte from l4_flow_log limit 1",
+		defaults: map[string]string{"max_execution_time": "30"},
+		output:   "SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1 SETTINGS max_execution_time=30",
+	}, {
+		name:     "override",
+		input:    "select Sum(byte) as sum_byte from l4_flow_log limit 1 settings max_execution_time=60",
+		defaults: map[string]string{"max_execution_time": "30"},
+		output:   "SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1 SETTINGS max_execution_time=60",
+	}, {
+		name:     "merge",
+		input:    "select Sum(byte) as sum_byte from l4_flow_log limit 1 SETTINGS max_memory_usage=10000000000",
+		defaults: map[string]string{"max_execution_time": "30"},
+		output:   "SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1 SETTINGS max_execution_time=30, max_memory_usage=10000000000",
+	}, {
+		name:   "no_settings",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log limit 1",
+		output: "SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 1",
+	}} {
+		e := CHEngine{DB: "flow_log", Context: context.Background(), DefaultSettings: tc.defaults}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(tc.input); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if out := e.ToSQLString(); out != tc.output {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, out, tc.output)
+		}
+	}
+}
+
+func TestDefaultLimit(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	for _, tc := range []struct {
+		name         string
+		input        string
+		defaultLimit string
+		output       string
+	}{{
+		name:   "global",
+		input:  "select Sum(byte) as sum_byte from l4_flow_log",
+		output: "SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 10000",
+	}, {
+		name:         "per_db",
+		input:        "select Sum(byte) as sum_byte from l4_flow_log",
+		defaultLimit: "100",
+		output:       "SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 100",
+	}, {
+		name:         "explicit",
+		input:        "select Sum(byte) as sum_byte from l4_flow_log limit 20",
+		defaultLimit: "100",
+		output:       "SELECT SUM(byte_tx+byte_rx) AS `sum_byte` FROM flow_log.`l4_flow_log` LIMIT 20",
+	}, {
+		name:         "layered_outermost",
+		input:        "select Histogram(Sum(byte),10) AS histo from l4_flow_log",
+		defaultLimit: "100",
+		output:       "SELECT histogramIf(10)(assumeNotNull(`_sum_byte_tx+byte_rx`),`_sum_byte_tx+byte_rx`>0) AS `histo` FROM (SELECT SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log`) LIMIT 100",
+	}, {
+		name:         "layered_explicit",
+		input:        "select Histogram(Sum(byte),10) AS histo from l4_flow_log limit 20",
+		defaultLimit: "100",
+		output:       "SELECT histogramIf(10)(assumeNotNull(`_sum_byte_tx+byte_rx`),`_sum_byte_tx+byte_rx`>0) AS `histo` FROM (SELECT SUM(byte_tx+byte_rx) AS `_sum_byte_tx+byte_rx` FROM flow_log.`l4_flow_log` LIMIT 20)",
+	}} {
+		e := CHEngine{DB: "flow_log", Context: context.Background(), CompileOptions: CompileOptions{DefaultLimit: tc.defaultLimit}}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(tc.input); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if out := e.ToSQLString(); out != tc.output {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, out, tc.output)
+		}
+	}
+}
+
+func TestDefaultGroupOrder(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	translate := func(db, sql string, defaultGroupOrder bool) string {
+		e := CHEngine{DB: db, Context: context.Background(), CompileOptions: CompileOptions{DefaultGroupOrder: defaultGroupOrder}}
+		e.Init()
+		parser := parse.Parser{Engine: &e, DefaultGroupOrder: e.DefaultGroupOrder}
+		if err := parser.ParseSQL(sql); err != nil {
+			t.Fatalf("%s: unexpected error %v", sql, err)
+		}
+		return e.ToSQLString()
+	}
+	// 开启后未指定ORDER BY的查询与显式按time()及其余group升序排序的查询相同
+	for _, tc := range []struct {
+		name  string
+		db    string
+		input string
+		want  string
+	}{{
+		name:  "time_then_tag",
+		db:    "flow_log",
+		input: "select region_0, time(time, 120) as time_120, Sum(byte) as sum_byte from l4_flow_log group by region_0, time_120 limit 10",
+		want:  "select region_0, time(time, 120) as time_120, Sum(byte) as sum_byte from l4_flow_log group by region_0, time_120 order by time_120 asc, region_0 asc limit 10",
+	}, {
+		name:  "layered",
+		db:    "flow_metrics",
+		input: "select time(time, 120) as toi, region_0, AAvg(byte_tx) as aavg_byte_tx from vtap_flow_edge_port group by region_0, toi limit 10",
+		want:  "select time(time, 120) as toi, region_0, AAvg(byte_tx) as aavg_byte_tx from vtap_flow_edge_port group by region_0, toi order by toi asc, region_0 asc limit 10",
+	}, {
+		name:  "window_layer",
+		db:    "flow_log",
+		input: "select time(time, 60) as toi, region_0, MovingAvg(Sum(byte), 5) as ma_byte from l4_flow_log group by toi, region_0 limit 10",
+		want:  "select time(time, 60) as toi, region_0, MovingAvg(Sum(byte), 5) as ma_byte from l4_flow_log group by toi, region_0 order by toi asc, region_0 asc limit 10",
+	}, {
+		name:  "explicit_order",
+		db:    "flow_log",
+		input: "select region_0, time(time, 120) as time_120, Sum(byte) as sum_byte from l4_flow_log group by region_0, time_120 order by sum_byte desc limit 10",
+		want:  "select region_0, time(time, 120) as time_120, Sum(byte) as sum_byte from l4_flow_log group by region_0, time_120 order by sum_byte desc limit 10",
+	}, {
+		name:  "no_group",
+		db:    "flow_log",
+		input: "select Sum(byte) as sum_byte from l4_flow_log limit 10",
+		want:  "select Sum(byte) as sum_byte from l4_flow_log limit 10",
+	}} {
+		out, want := translate(tc.db, tc.input, true), translate(tc.db, tc.want, false)
+		if out != want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, out, want)
+		}
+		if strings.Contains(tc.want, "order by") && !strings.Contains(out, "ORDER BY") {
+			t.Errorf("%s: order by is missing in %s", tc.name, out)
+		}
+	}
+	// 默认不添加排序
+	if out := translate("flow_log", "select region_0, Sum(byte) as sum_byte from l4_flow_log group by region_0 limit 10", false); strings.Contains(out, "ORDER BY") {
+		t.Errorf("default: unexpected order by in %s", out)
+	}
+}
+
+// TestCompositeTagGroup ip等由多列组成的tag在里层按全部原始列聚合，外层再按翻译后的值聚合
+func TestCompositeTagGroup(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	for _, tc := range []struct {
+		name        string
+		input       string
+		innerGroups string
+		outerGroups []string
+	}{{
+		name:        "select_ip",
+		input:       "select ip_0, Sum(byte) as sum_byte, AAvg(byte_tx) as aavg_byte_tx from vtap_flow_edge_port group by ip_0 limit 10",
+		innerGroups: "GROUP BY `is_ipv4`, `ip4_0`, `ip6_0`",
+		outerGroups: []string{"`is_ipv4`", "`ip4_0`", "`ip6_0`", "`ip_0`"},
+	}, {
+		name:        "group_only",
+		input:       "select Sum(byte) as sum_byte, AAvg(byte_tx) as aavg_byte_tx from vtap_flow_edge_port group by ip_0 limit 10",
+		innerGroups: "GROUP BY `is_ipv4`, `ip4_0`, `ip6_0`",
+		outerGroups: []string{"`is_ipv4`", "`ip4_0`", "`ip6_0`"},
+	}} {
+		e := CHEngine{DB: "flow_metrics", Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(tc.input); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		out := e.ToSQLString()
+		inner, outer, ok := strings.Cut(out, ") GROUP BY ")
+		if !ok {
+			t.Errorf("%s: sql is not layered: %s", tc.name, out)
+			continue
+		}
+		// 里层需要输出外层聚合使用的全部原始列
+		if !strings.Contains(inner, tc.innerGroups) || !strings.Contains(inner, "is_ipv4, ip4_0, ip6_0") {
+			t.Errorf("%s: inner layer should group by all raw columns: %s", tc.name, out)
+		}
+		outerGroups, _, _ := strings.Cut(outer, " LIMIT")
+		for _, group := range tc.outerGroups {
+			if !strings.Contains(outerGroups, group) {
+				t.Errorf("%s: outer layer should group by %s: %s", tc.name, group, out)
+			}
+		}
+	}
+}
+
+func TestQueryCancel(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	// driver阻塞到ctx取消，记录收到的取消原因
+	var c *client.Client
+	queried := make(chan struct{}, 1)
+	canceled := make(chan error, 1)
+	guard := monkey.PatchInstanceMethod(reflect.TypeOf(c), "DoQuery", func(c *client.Client, params *client.QueryParams) (*common.Result, error) {
+		queried <- struct{}{}
+		<-c.Context.Done()
+		canceled <- c.Context.Err()
+		return nil, c.Context.Err()
+	})
+	defer guard.Unpatch()
+	sql := "select Sum(byte) as sum_byte from l4_flow_log limit 1"
+
+	// 超过engine的超时时间
+	e := CHEngine{DB: "flow_log", QueryTimeout: 100 * time.Millisecond}
+	e.Init()
+	_, _, err := e.ExecuteQuery(&common.QuerierParams{DB: "flow_log", Sql: sql, Context: context.Background()})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timeout: get error %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case driverErr := <-canceled:
+		if !errors.Is(driverErr, context.DeadlineExceeded) {
+			t.Errorf("timeout: driver canceled by %v, want %v", driverErr, context.DeadlineExceeded)
+		}
+		<-queried
+	default:
+		t.Error("timeout: cancellation did not reach the driver")
+	}
+
+	// 执行中客户端断开
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-queried
+		cancel()
+	}()
+	e = CHEngine{DB: "flow_log"}
+	e.Init()
+	_, _, err = e.ExecuteQuery(&common.QuerierParams{DB: "flow_log", Sql: sql, Context: ctx})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cancel: get error %v, want %v", err, context.Canceled)
+	}
+	if driverErr := <-canceled; !errors.Is(driverErr, context.Canceled) {
+		t.Errorf("cancel: driver canceled by %v, want %v", driverErr, context.Canceled)
+	}
+
+	// 解析前已取消时不再解析及查询
+	e = CHEngine{DB: "flow_log"}
+	e.Init()
+	_, _, err = e.ExecuteQuery(&common.QuerierParams{DB: "flow_log", Sql: sql, Context: ctx})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled_before_parse: get error %v, want %v", err, context.Canceled)
+	}
+	if len(queried) != 0 {
+		t.Error("canceled_before_parse: query reached the driver")
+	}
+}
+
+func TestNewSubEngine(t *testing.T) {
+	// 不属于查询选项的字段：解析状态、执行相关的组件，及只加在最外层sql前的Comment
+	skip := map[string]bool{
+		"Model": true, "Statements": true, "Table": true, "AsTagMap": true, "AsFuncMap": true, "ColumnSchemas": true, "View": true,
+		"TargetLabelFilters": true, "Comment": true, "IsDerivative": true, "DerivativeGroupBy": true, "ModelCache": true,
+		"SlowQueryLog": true, "Metrics": true, "Admission": true, "NativeField": true, "CustomMetrics": true,
+	}
+	e := &CHEngine{}
+	v := reflect.ValueOf(e).Elem()
+	// CompileOptions中的字段按提升后的字段检查
+	fields := reflect.VisibleFields(v.Type())
+	for i, field := range fields {
+		value := v.FieldByIndex(field.Index)
+		if !field.IsExported() || field.Anonymous || skip[field.Name] {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.Bool:
+			value.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			value.SetInt(int64(i + 1))
+		case reflect.String:
+			value.SetString(field.Name)
+		case reflect.Map:
+			value.Set(reflect.MakeMap(value.Type()))
+		case reflect.Ptr:
+			value.Set(reflect.New(value.Type().Elem()))
+		case reflect.Func:
+			value.Set(reflect.ValueOf(time.Now))
+		case reflect.Interface:
+			value.Set(reflect.ValueOf(context.Background()))
+		default:
+			t.Fatalf("field %s of kind %s is not handled", field.Name, value.Kind())
+		}
+	}
+	e.Comment = "dashboard_id=1"
+	sub := reflect.ValueOf(e.newSubEngine()).Elem()
+	for _, field := range fields {
+		want, get := v.FieldByIndex(field.Index), sub.FieldByIndex(field.Index)
+		if !field.IsExported() || field.Anonymous || skip[field.Name] {
+			continue
+		}
+		if want.Kind() == reflect.Func || want.Kind() == reflect.Map || want.Kind() == reflect.Ptr {
+			if get.Pointer() != want.Pointer() {
+				t.Errorf("sub engine does not inherit %s", field.Name)
+			}
+		} else if !reflect.DeepEqual(get.Interface(), want.Interface()) {
+			t.Errorf("sub engine does not inherit %s: get %v, want %v", field.Name, get.Interface(), want.Interface())
+		}
+	}
+	if sub.FieldByName("Comment").String() != "" {
+		t.Error("sub engine should not inherit Comment")
+	}
+}
+
+func TestModelCache(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	compile := func(sql string) string {
+		e := CHEngine{DB: "flow_log", Context: context.Background()}
+		e.Init()
+		out, err := e.compileSQL(sql)
+		if err != nil {
+			t.Fatalf("compile %s: %v", sql, err)
+		}
+		return out
+	}
+	cache := NewModelCache(10)
+	parseCached := func(sql string) *CHEngine {
+		e := CHEngine{DB: "flow_log", Context: context.Background(), ModelCache: cache}
+		e.Init()
+		out, err := e.ParseCachedSQL(sql)
+		if err != nil {
+			t.Fatalf("parse cached %s: %v", sql, err)
+		}
+		if want := compile(sql); out != want {
+			t.Errorf("parse cached %s: get %s, want %s", sql, out, want)
+		}
+		return &e
+	}
+
+	sql := "select region_0, Avg(rtt) as avg_rtt from l4_flow_log where time >= %d and time <= %d and protocol = 6 group by region_0 limit 10"
+	first := parseCached(fmt.Sprintf(sql, 60, 180))
+	second := parseCached(fmt.Sprintf(sql, 120, 240))
+	if first.modelCacheHit || !second.modelCacheHit {
+		t.Errorf("cache hit: get (%v, %v), want (false, true)", first.modelCacheHit, second.modelCacheHit)
+	}
+	// 命中时不共享缓存的Model，时间范围为本次查询的
+	if first.Model == second.Model || second.Model.Time.TimeStart != 120 || second.Model.Time.TimeEnd != 240 {
+		t.Errorf("cache hit should use its own Model with time range [120, 240], get %+v", second.Model.Time)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("get %d cache entries, want 1", cache.Len())
+	}
+	// 其他条件不同时不能命中
+	other := parseCached("select region_0, Avg(rtt) as avg_rtt from l4_flow_log where time >= 60 and time <= 180 and protocol = 17 group by region_0 limit 10")
+	if other.modelCacheHit {
+		t.Errorf("different filters should not hit the cache")
+	}
+
+	// 未按时间聚合的PerSecond依赖时间范围，不能复用
+	sql = "select PerSecond(Sum(byte)) as ps from l4_flow_log where time >= %d and time <= %d limit 1"
+	first = parseCached(fmt.Sprintf(sql, 60, 180))
+	second = parseCached(fmt.Sprintf(sql, 60, 600))
+	if second.modelCacheHit {
+		t.Errorf("time range dependent sql should not be reused")
+	}
+
+	e := CHEngine{DB: "flow_log", Context: context.Background(), ModelCache: cache}
+	e.Init()
+	if _, err := e.ParseCachedSQL(fmt.Sprintf(sql, 180, 60)); err == nil {
+		t.Errorf("start time later than end time should fail")
+	}
+
+	// 与compileSQL经过相同的预处理，final、settings、ilike等也能缓存，且不与不带这些语法的sql共用
+	for _, sql := range []string{
+		"select Sum(byte) as sum_byte from l4_flow_log final where time >= %d and time <= %d limit 1",
+		"select Sum(byte) as sum_byte from cluster('df', l4_flow_log) where time >= %d and time <= %d limit 1",
+		"select Sum(byte) as sum_byte from l4_flow_log where time >= %d and time <= %d and region_0 ilike 'a*' limit 1 settings max_threads=4",
+		"select region_0, Sum(byte) as sum_byte from l4_flow_log where time >= %d and time <= %d group by region_0 with totals limit 1",
+	} {
+		first = parseCached(fmt.Sprintf(sql, 60, 180))
+		second = parseCached(fmt.Sprintf(sql, 120, 240))
+		if first.modelCacheHit || !second.modelCacheHit {
+			t.Errorf("%s: cache hit: get (%v, %v), want (false, true)", sql, first.modelCacheHit, second.modelCacheHit)
+		}
+	}
+
+	// 库配置了时间列时，按该列过滤的条件同样替换为占位符
+	timeColumns := chCommon.DB_TIME_COLUMN_MAP
+	defer func() { chCommon.DB_TIME_COLUMN_MAP = timeColumns }()
+	if err := chCommon.LoadTimeColumns([][]interface{}{{"flow_log", "timestamp"}}); err != nil {
+		t.Fatalf("load time columns failed: %v", err)
+	}
+	sql = "select Sum(byte) as sum_byte from l4_flow_log where timestamp >= %d and timestamp <= %d limit 1"
+	first = parseCached(fmt.Sprintf(sql, 60, 180))
+	second = parseCached(fmt.Sprintf(sql, 120, 240))
+	if first.modelCacheHit || !second.modelCacheHit {
+		t.Errorf("time column: cache hit: get (%v, %v), want (false, true)", first.modelCacheHit, second.modelCacheHit)
+	}
+}
+
+func TestModelCacheConcurrentHits(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	sql := "select region_0, Avg(rtt) as avg_rtt from l4_flow_log where time >= %d and time <= %d and protocol = 6 group by region_0 limit 10"
+	ranges := [][2]int64{{60, 180}, {120, 240}}
+	wants := make([]string, len(ranges))
+	for i, r := range ranges {
+		e := CHEngine{DB: "flow_log", Context: context.Background()}
+		e.Init()
+		out, err := e.compileSQL(fmt.Sprintf(sql, r[0], r[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wants[i] = out
+	}
+	cache := NewModelCache(10)
+	parseCached := func(i int) (*CHEngine, string, error) {
+		e := &CHEngine{DB: "flow_log", Context: context.Background(), ModelCache: cache}
+		e.Init()
+		out, err := e.ParseCachedSQL(fmt.Sprintf(sql, ranges[i][0], ranges[i][1]))
+		return e, out, err
+	}
+	if _, _, err := parseCached(0); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for n := 0; n < 10; n++ {
+		for i := range ranges {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				e, out, err := parseCached(i)
+				switch {
+				case err != nil:
+					errs <- err
+				case !e.modelCacheHit:
+					errs <- fmt.Errorf("range %v: cache not hit", ranges[i])
+				case out != wants[i]:
+					errs <- fmt.Errorf("range %v: get %s, want %s", ranges[i], out, wants[i])
+				case e.Model.Time.TimeStart != ranges[i][0] || e.Model.Time.TimeEnd != ranges[i][1]:
+					errs <- fmt.Errorf("range %v: get model time [%d, %d]", ranges[i], e.Model.Time.TimeStart, e.Model.Time.TimeEnd)
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestReplaceNumber(t *testing.T) {
+	sql := "WHERE `time` >= 3000000000 AND `time` <= 3900000000 AND x = 30000000001 AND y = 13000000000 AND z IN (3000000000.5, 3000000000) LIMIT 3000000000"
+	out, count := replaceNumber(sql, "3000000000", "?")
+	if want := "WHERE `time` >= ? AND `time` <= 3900000000 AND x = 30000000001 AND y = 13000000000 AND z IN (3000000000.5, ?) LIMIT ?"; out != want || count != 3 {
+		t.Errorf("get (%s, %d), want (%s, 3)", out, count, want)
+	}
+}
+
+func TestTagDictCache(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	now := time.Unix(1693526400, 0)
+	dicts := map[string]map[uint64]string{
+		"region_map": {2: "it's", 1: "华北"},
+		"az_map":     {1: "az-1", 2: "az-2", 3: "az-3"},
+	}
+	var sourceErr error
+	loads := 0
+	cache := NewTagDictCache(func(ctx context.Context, orgID, dictionary, field string, limit int) (map[uint64]string, error) {
+		loads++
+		return dicts[dictionary], sourceErr
+	}, time.Minute, 2, time.Second)
+	cache.Now = func() time.Time { return now }
+	compile := func(sql string) string {
+		e := CHEngine{DB: "flow_log", Context: context.Background(), DictCache: cache}
+		e.Init()
+		out, err := e.compileSQL(sql)
+		if err != nil {
+			t.Fatalf("compile %s: %v", sql, err)
+		}
+		return out
+	}
+
+	out := compile("select region_0, chost_0 from l4_flow_log limit 1")
+	if !strings.Contains(out, "transform(toUInt64(region_id_0), [1,2], ['华北','it\\'s'], '') AS `region_0`") {
+		t.Errorf("region_0 should be inlined: %s", out)
+	}
+	// 未开启TranslationCache的tag仍使用dictGet
+	if !strings.Contains(out, "dictGet('flow_tag.device_map', 'name', (toUInt64(device_type_chost_0),toUInt64(l3_device_id_0))) AS `chost_0`") {
+		t.Errorf("chost_0 should use dictGet: %s", out)
+	}
+	compile("select region_0 from l4_flow_log limit 1")
+	if loads != 1 {
+		t.Errorf("get %d loads, want 1", loads)
+	}
+	// 字典条数超过MaxEntries
+	out = compile("select az_0 from l4_flow_log limit 1")
+	if !strings.Contains(out, "dictGet('flow_tag.az_map', 'name', (toUInt64(az_id_0))) AS `az_0`") {
+		t.Errorf("large dictionary should use dictGet: %s", out)
+	}
+	// 过期后重新读取
+	dicts["region_map"] = map[uint64]string{1: "华东"}
+	now = now.Add(2 * time.Minute)
+	version := cache.GetVersion()
+	out = compile("select region_0 from l4_flow_log limit 1")
+	if !strings.Contains(out, "transform(toUInt64(region_id_0), [1], ['华东'], '')") {
+		t.Errorf("expired dictionary should be reloaded: %s", out)
+	}
+	if cache.GetVersion() == version {
+		t.Errorf("reload should change the version")
+	}
+	// 过期后读取失败时不使用旧的内容
+	sourceErr = errors.New("clickhouse unavailable")
+	now = now.Add(2 * time.Minute)
+	out = compile("select region_0 from l4_flow_log limit 1")
+	if !strings.Contains(out, "dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0))) AS `region_0`") {
+		t.Errorf("stale dictionary should use dictGet: %s", out)
+	}
+}
+
+func TestTagDictCacheLoad(t *testing.T) {
+	release := make(chan struct{})
+	var loads atomic.Int32
+	var deadline atomic.Bool
+	cache := NewTagDictCache(func(ctx context.Context, orgID, dictionary, field string, limit int) (map[uint64]string, error) {
+		loads.Add(1)
+		_, ok := ctx.Deadline()
+		deadline.Store(ok)
+		select {
+		case <-release:
+			return map[uint64]string{1: "华北"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}, time.Minute, 10, time.Second)
+
+	// 并发未命中时只读取一次，查询取消时放弃等待，不影响其他调用方
+	canceled, cancel := context.WithCancel(context.Background())
+	canceledDone := make(chan bool)
+	go func() {
+		_, ok := cache.Get(canceled, "1", "region_map", "name")
+		canceledDone <- ok
+	}()
+	var wg sync.WaitGroup
+	results := make([]bool, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, results[i] = cache.Get(context.Background(), "1", "region_map", "name")
+		}(i)
+	}
+	for loads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if ok := <-canceledDone; ok {
+		t.Errorf("canceled query should not wait for the dictionary")
+	}
+	close(release)
+	wg.Wait()
+	for i, ok := range results {
+		if !ok {
+			t.Errorf("caller %d should get the dictionary", i)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Errorf("get %d loads, want 1", loads.Load())
+	}
+	if !deadline.Load() {
+		t.Errorf("dictionary load should have a timeout")
+	}
+
+	// 读取超时后不内联
+	timeout := NewTagDictCache(func(ctx context.Context, orgID, dictionary, field string, limit int) (map[uint64]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, time.Minute, 10, 10*time.Millisecond)
+	if _, ok := timeout.Get(context.Background(), "1", "region_map", "name"); ok {
+		t.Errorf("timed out load should not be inlined")
+	}
+}
+
+func TestDatasourceCache(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	now := time.Unix(1693526400, 0)
+	loaded := make(chan string, 10)
+	intervals := map[string]int{"1h": 3600, "1m": 60}
+	cache := NewDatasourceCache(func(db, table, name, orgID string) (int, error) {
+		defer func() { loaded <- name }()
+		if interval, ok := intervals[name]; ok {
+			return interval, nil
+		}
+		return 0, fmt.Errorf("datasource %s not found", name)
+	}, time.Minute)
+	cache.Now = func() time.Time { return now }
+	compile := func() string {
+		e := CHEngine{DB: "flow_metrics", Context: context.Background(), DatasourceCache: cache}
+		e.Init()
+		out, err := e.compileSQL("select time(time, 3600) as toi, Sum(byte_tx) as sum_byte_tx from vtap_flow_port group by toi limit 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	waitLoaded := func(want int) {
+		for i := 0; i < want; i++ {
+			select {
+			case <-loaded:
+			case <-time.After(5 * time.Second):
+				t.Fatal("datasource is not loaded in background")
+			}
+		}
+	}
+
+	// 未缓存时不等待读取，不选择数据源
+	if out := compile(); !strings.Contains(out, "FROM flow_metrics.`network` ") {
+		t.Errorf("uncached datasource should not be selected: %s", out)
+	}
+	// 1h及1m在后台读取
+	waitLoaded(2)
+	version := cache.GetVersion()
+	if out := compile(); !strings.Contains(out, "FROM flow_metrics.`network.1h`") {
+		t.Errorf("cached datasource should be selected: %s", out)
+	}
+	// 过期后继续使用旧的值，并在后台刷新
+	delete(intervals, "1h")
+	now = now.Add(2 * time.Minute)
+	if out := compile(); !strings.Contains(out, "FROM flow_metrics.`network.1h`") {
+		t.Errorf("expired datasource should be used until reloaded: %s", out)
+	}
+	waitLoaded(1)
+	if cache.GetVersion() == version {
+		t.Errorf("reload should change the version")
+	}
+	if out := compile(); !strings.Contains(out, "FROM flow_metrics.`network.1m`") {
+		t.Errorf("removed datasource should not be selected: %s", out)
+	}
+	// 1m也已过期，等待后台刷新完成
+	waitLoaded(1)
+
+	var nilCache *DatasourceCache
+	if _, ok := nilCache.Get("flow_metrics", "network", "1h", common.DEFAULT_ORG_ID); ok || nilCache.GetVersion() != 0 {
+		t.Error("nil datasource cache should cache nothing")
+	}
+}
+
+func TestQueryBuilder(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	for _, tc := range []struct {
+		name    string
+		db      string
+		sql     string
+		builder *QueryBuilder
+	}{{
+		name: "tag_metric_order",
+		db:   "flow_log",
+		sql:  "select region_0, Sum(byte) as sum_byte from l4_flow_log where protocol=6 group by region_0 order by sum_byte desc limit 10",
+		builder: NewQuery("l4_flow_log").
+			Select(Col("region_0")).
+			SelectAs(Fn("Sum", Col("byte")), "sum_byte").
+			Where(Cmp(Col("protocol"), "=", Int(6))).
+			GroupBy(Col("region_0")).
+			OrderBy(Col("sum_byte"), "desc").
+			Limit(10),
+	}, {
+		name: "time_having_offset",
+		db:   "flow_log",
+		sql:  "select Max(byte_tx) as max_byte_tx, time(time, 120) as time_120 from l4_flow_log group by time_120 having Sum(byte)>=0 limit 10 offset 20",
+		builder: NewQuery("l4_flow_log").
+			SelectAs(Fn("Max", Col("byte_tx")), "max_byte_tx").
+			SelectAs(Fn("time", Col("time"), Int(120)), "time_120").
+			GroupBy(Col("time_120")).
+			Having(Cmp(Fn("Sum", Col("byte")), ">=", Int(0))).
+			Limit(10).
+			Offset(20),
+	}, {
+		name: "layered_math",
+		db:   "flow_metrics",
+		sql:  "select Avg(rtt) as avg_rtt, Sum(byte)/Sum(packet) as bpp from `network.1m` where region_0 in ('a', 'b') and byte>0 limit 1",
+		builder: NewQuery("network.1m").
+			SelectAs(Fn("Avg", Col("rtt")), "avg_rtt").
+			SelectAs(Math(Fn("Sum", Col("byte")), "/", Fn("Sum", Col("packet"))), "bpp").
+			Where(In(Col("region_0"), Str("a"), Str("b"))).
+			Where(Cmp(Col("byte"), ">", Int(0))).
+			Limit(1),
+	}, {
+		name: "enum_group",
+		db:   "flow_log",
+		sql:  "select Enum(tap_side), Count(row) as c from l7_flow_log group by Enum(tap_side) limit 1",
+		builder: NewQuery("l7_flow_log").
+			Select(Fn("Enum", Col("tap_side"))).
+			SelectAs(Fn("Count", Col("row")), "c").
+			GroupBy(Fn("Enum", Col("tap_side"))).
+			Limit(1),
+	}, {
+		name: "distinct_or",
+		db:   "flow_log",
+		sql:  "select distinct region_0, az_0 from l4_flow_log where (region_0='a' or az_0 like 'b*') limit 1",
+		builder: NewQuery("l4_flow_log").
+			Select(Col("region_0"), Col("az_0")).
+			Distinct().
+			Where(Paren(Or(Cmp(Col("region_0"), "=", Str("a")), Cmp(Col("az_0"), "like", Str("b*"))))).
+			Limit(1),
+	}, {
+		// time()与sql查询共用解析，不在builder中重复生成
+		name: "metric_group_by_time",
+		db:   "flow_log",
+		sql:  "select Sum(byte) as sum_byte, Count(row) as c, time(time, 120) as time_120 from l4_flow_log group by time_120 limit 10",
+		builder: NewQuery("l4_flow_log").
+			Metric(Sum("byte"), "sum_byte").
+			Metric(Count(), "c").
+			GroupByTime(120, "time_120").
+			Limit(10),
+	}, {
+		name: "metric_group_by_time_layered",
+		db:   "flow_metrics",
+		sql:  "select time(time, 3600) as time_3600, region_0, AAvg(byte) as aavg_byte, Max(rtt) as max_rtt from network group by time_3600, region_0 limit 10",
+		builder: NewQuery("network").
+			GroupByTime(3600, "time_3600").
+			Select(Col("region_0")).
+			Metric(AAvg("byte"), "aavg_byte").
+			Metric(Max("rtt"), "max_rtt").
+			GroupBy(Col("region_0")).
+			Limit(10),
+	}, {
+		// sqlparser不支持的语法与sql经过相同的预处理结果
+		name: "ilike_final_settings",
+		db:   "flow_log",
+		sql:  "select region_0, Sum(byte) as sum_byte from l4_flow_log final where region_0 ilike 'a*' and az_0 not ilike 'b*' group by region_0 limit 1 settings max_threads=4",
+		builder: NewQuery("l4_flow_log").
+			Select(Col("region_0")).
+			Metric(Sum("byte"), "sum_byte").
+			Where(ILike(Col("region_0"), Str("a*"))).
+			Where(NotILike(Col("az_0"), Str("b*"))).
+			GroupBy(Col("region_0")).
+			Limit(1).
+			Final().
+			Settings("max_threads", "4"),
+	}, {
+		name: "has_any_has_all",
+		db:   "flow_log",
+		sql:  "select Count(row) as c from l7_flow_log where attribute_names hasAny ('a', 'b') and attribute_names not hasAll ('c') limit 1",
+		builder: NewQuery("l7_flow_log").
+			Metric(Count(), "c").
+			Where(HasAny(Col("attribute_names"), Str("a"), Str("b"))).
+			Where(NotHasAll(Col("attribute_names"), Str("c"))).
+			Limit(1),
+	}, {
+		name: "with_totals_nulls_collate",
+		db:   "flow_log",
+		sql:  "select Sum(byte) as sum_byte, region_0 as region_name from l4_flow_log group by region_name with totals order by region_name desc collate 'zh' nulls last, sum_byte desc nulls first limit 1",
+		builder: NewQuery("l4_flow_log").
+			Metric(Sum("byte"), "sum_byte").
+			SelectAs(Col("region_0"), "region_name").
+			GroupBy(Col("region_name")).
+			WithTotals().
+			OrderBy(Col("region_name"), "desc collate 'zh' nulls last").
+			OrderBy(Col("sum_byte"), "desc nulls first").
+			Limit(1),
+	}, {
+		name: "grouping_sets",
+		db:   "flow_log",
+		sql:  "select Sum(byte) as sum_byte, region_0, az_0 from l4_flow_log group by grouping sets ((region_0), (region_0, az_0), ()) limit 1",
+		builder: NewQuery("l4_flow_log").
+			Metric(Sum("byte"), "sum_byte").
+			Select(Col("region_0"), Col("az_0")).
+			GroupingSets([]sqlparser.Expr{Col("region_0")}, []sqlparser.Expr{Col("region_0"), Col("az_0")}, []sqlparser.Expr{}).
+			Limit(1),
+	}, {
+		name: "grouping_sets_layered",
+		db:   "flow_metrics",
+		sql:  "select AAvg(byte_tx) as aavg_byte_tx, region_0, az_0 from vtap_flow_edge_port group by grouping sets ((region_0), (az_0), ()) limit 1",
+		builder: NewQuery("vtap_flow_edge_port").
+			Metric(AAvg("byte_tx"), "aavg_byte_tx").
+			Select(Col("region_0"), Col("az_0")).
+			GroupingSets([]sqlparser.Expr{Col("region_0")}, []sqlparser.Expr{Col("az_0")}, []sqlparser.Expr{}).
+			Limit(1),
+	}} {
+		parserEngine := CHEngine{DB: tc.db, Context: context.Background()}
+		parserEngine.Init()
+		parser := parse.Parser{Engine: &parserEngine}
+		if err := parser.ParseSQL(tc.sql); err != nil {
+			t.Errorf("%s: parse error %v", tc.name, err)
+			continue
+		}
+		builderEngine := CHEngine{DB: tc.db, Context: context.Background()}
+		builderEngine.Init()
+		v, err := tc.builder.Build(&builderEngine)
+		if err != nil {
+			t.Errorf("%s: build error %v", tc.name, err)
+			continue
+		}
+		if got, want := v.ToString(), parserEngine.ToSQLString(); got != want {
+			t.Errorf("%s: builder output %s, parser output %s", tc.name, got, want)
+		}
+	}
+
+	e := CHEngine{DB: "flow_log", Context: context.Background()}
+	e.Init()
+	_, err := NewQuery("l4_flow_log").Select(Col("no_such_tag")).Limit(1).Build(&e)
+	var validateErr *ValidateError
+	if !errors.As(err, &validateErr) || validateErr.Type != VALIDATE_ERROR_UNKNOWN_TAG || validateErr.Name != "no_such_tag" {
+		t.Errorf("unknown_tag: get error %v, want %s(no_such_tag)", err, VALIDATE_ERROR_UNKNOWN_TAG)
+	}
+	if _, err := NewQuery("l4_flow_log").Metric(Sum("byte"), "sum_byte").WithTotals().Build(&CHEngine{DB: "flow_log", Context: context.Background()}); err == nil || err.Error() != "with totals requires group by" {
+		t.Errorf("with_totals_without_group_by: get error %v", err)
+	}
+	if _, err := NewQuery("l4_flow_log").Metric(Sum("byte"), "sum_byte").Settings("max_threads", "4; drop").Build(&CHEngine{DB: "flow_log", Context: context.Background()}); err == nil {
+		t.Errorf("settings_invalid: get no error")
+	}
+}
+
+func Load() error {
+	ServerCfg := config.DefaultConfig()
+	config.Cfg = &ServerCfg.QuerierConfig
+	config.Cfg.Clickhouse.Version = "24.8"
+	config.ControllerCfg = &ServerCfg.ControllerConfig
+	dir := "../../db_descriptions"
+	dbDescriptions, err := common.LoadDbDescriptions(dir)
+	if err != nil {
+		return err
+	}
+	err = LoadDbDescriptions(dbDescriptions)
+	if err != nil {
+		return err
+	}
+	metrics.DB_DESCRIPTIONS = dbDescriptions
+	return nil
+}
+
+func mockDatasources() {
+	httpmock.RegisterResponder(
+		"GET", "http://localhost:20417/v1/data-sources/",
+		func(req *http.Request) (*http.Response, error) {
+			values := req.URL.Query()
+			name := values.Get("name")
+			if name == "" {
+				name = "1s"
+			}
+
+			return httpmock.NewStringResponse(200,
+				fmt.Sprintf(`{"DATA":[{"NAME":"%s","INTERVAL":%d}]}`,
 					name, convertNameToInterval(name)),
 			), nil
 		},
@@ -1002,3 +3278,360 @@ func TestReplaceCustomBizServiceFilterIDPatterns(t *testing.T) {
 		})
 	}
 }
+
+// TestTagNullFilter 标注了NullValue的tag，IS NULL与原始列等于未知值的查询相同
+func TestTagNullFilter(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	translate := func(db, sql string) string {
+		e := CHEngine{DB: db, Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(sql); err != nil {
+			t.Fatalf("%s: unexpected error %v", sql, err)
+		}
+		return e.ToSQLString()
+	}
+	for _, tc := range []struct {
+		name  string
+		db    string
+		input string
+		want  string
+	}{{
+		name:  "int_tag",
+		db:    "flow_log",
+		input: "select Sum(byte) as sum_byte from l4_flow_log where pod_id_0 is null limit 1",
+		want:  "select Sum(byte) as sum_byte from l4_flow_log where pod_id_0 = 0 limit 1",
+	}, {
+		name:  "int_tag_not_null",
+		db:    "flow_log",
+		input: "select Sum(byte) as sum_byte from l4_flow_log where pod_id_0 is not null limit 1",
+		want:  "select Sum(byte) as sum_byte from l4_flow_log where pod_id_0 != 0 limit 1",
+	}, {
+		name:  "string_tag",
+		db:    "flow_log",
+		input: "select Count(row) as count_row from l7_flow_log where request_domain is null limit 1",
+		want:  "select Count(row) as count_row from l7_flow_log where request_domain = '' limit 1",
+	}, {
+		name:  "string_tag_not_null",
+		db:    "flow_log",
+		input: "select Count(row) as count_row from l7_flow_log where request_domain is not null limit 1",
+		want:  "select Count(row) as count_row from l7_flow_log where request_domain != '' limit 1",
+	}, {
+		// 翻译后的tag在翻译前判断
+		name:  "translated_tag",
+		db:    "flow_log",
+		input: "select pod_0, Sum(byte) as sum_byte from l4_flow_log where pod_0 is null group by pod_0 limit 1",
+		want:  "select pod_0, Sum(byte) as sum_byte from l4_flow_log where pod_id_0 = 0 group by pod_0 limit 1",
+	}, {
+		name:  "translated_tag_single_side",
+		db:    "flow_metrics",
+		input: "select pod, Sum(byte) as sum_byte from `network.1m` where pod is not null group by pod limit 1",
+		want:  "select pod, Sum(byte) as sum_byte from `network.1m` where pod_id != 0 group by pod limit 1",
+	}} {
+		out, want := translate(tc.db, tc.input), translate(tc.db, tc.want)
+		if out != want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, out, want)
+		}
+		if strings.Contains(out, "IS NULL") || strings.Contains(out, "dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id_0))) =") {
+			t.Errorf("%s: null check is not rewritten in %s", tc.name, out)
+		}
+	}
+	// 未标注的tag保持IS NULL
+	if out := translate("flow_log", "select Sum(byte) as sum_byte from l4_flow_log where region_0 is null limit 1"); !strings.Contains(out, "IS NULL") {
+		t.Errorf("region_0: get %s, want IS NULL", out)
+	}
+}
+
+// TestIDTagTranslation 标注了IDTranslation的资源，id tag查询时与资源名称的查询相同
+func TestIDTagTranslation(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	translate := func(db, sql string) (string, []*common.ColumnSchema) {
+		e := CHEngine{DB: db, Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(sql); err != nil {
+			t.Fatalf("%s: unexpected error %v", sql, err)
+		}
+		return e.ToSQLString(), e.ColumnSchemas
+	}
+	for _, tc := range []struct {
+		name   string
+		db     string
+		input  string
+		want   string
+		column string
+	}{{
+		name:   "select",
+		db:     "flow_metrics",
+		input:  "select region_id, Sum(byte) as sum_byte from `network.1m` group by region_id limit 1",
+		want:   "select region, Sum(byte) as sum_byte from `network.1m` group by region limit 1",
+		column: "region",
+	}, {
+		name:   "alias",
+		db:     "flow_metrics",
+		input:  "select az_id as az_name, Sum(request) as sum_request from `application.1m` group by az_id limit 1",
+		want:   "select az as az_name, Sum(request) as sum_request from `application.1m` group by az limit 1",
+		column: "az_name",
+	}, {
+		// 过滤条件仍使用id
+		name:   "filter",
+		db:     "flow_metrics",
+		input:  "select region_id, Sum(byte) as sum_byte from `network.1m` where region_id = 1 group by region_id limit 1",
+		want:   "select region, Sum(byte) as sum_byte from `network.1m` where region_id = 1 group by region limit 1",
+		column: "region",
+	}, {
+		// 未标注的表保持id
+		name:   "not_marked",
+		db:     "flow_log",
+		input:  "select region_id_0 from l4_flow_log group by region_id_0 limit 1",
+		want:   "select region_id_0 from l4_flow_log group by region_id_0 limit 1",
+		column: "region_id_0",
+	}} {
+		out, columns := translate(tc.db, tc.input)
+		want, _ := translate(tc.db, tc.want)
+		if out != want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, out, want)
+		}
+		if columns[0].Name != tc.column {
+			t.Errorf("%s: column name get %s, want %s", tc.name, columns[0].Name, tc.column)
+		}
+	}
+	out, _ := translate("flow_metrics", "select region_id from `network.1m` group by region_id limit 1")
+	if !strings.Contains(out, "dictGet('flow_tag.region_map', 'name', (toUInt64(region_id))) AS `region`") {
+		t.Errorf("region_id: get %s, want dictGet of region_map", out)
+	}
+}
+
+// TestQueryMacros 替换宏后与直接写入数值的查询相同
+func TestQueryMacros(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	var chSql string
+	var c *client.Client
+	guard := monkey.PatchInstanceMethod(reflect.TypeOf(c), "DoQuery", func(c *client.Client, params *client.QueryParams) (*common.Result, error) {
+		chSql = params.Sql
+		return &common.Result{}, nil
+	})
+	defer guard.Unpatch()
+
+	execute := func(sql string) (string, error) {
+		chSql = ""
+		e := CHEngine{DB: "flow_log"}
+		e.Init()
+		_, _, err := e.ExecuteQuery(&common.QuerierParams{
+			DB: "flow_log", Sql: sql, Context: context.Background(),
+			TimeFrom: 1700000000, TimeTo: 1700003600, Interval: 60,
+		})
+		return chSql, err
+	}
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  string
+	}{{
+		name:  "where",
+		input: "select Sum(byte) as sum_byte from l4_flow_log where time >= $__from and time <= $__to limit 1",
+		want:  "select Sum(byte) as sum_byte from l4_flow_log where time >= 1700000000 and time <= 1700003600 limit 1",
+	}, {
+		name:  "time",
+		input: "select time(time, $__interval) as toi, Sum(byte) as sum_byte from l4_flow_log where time >= $__from and time <= $__to group by toi limit 1",
+		want:  "select time(time, 60) as toi, Sum(byte) as sum_byte from l4_flow_log where time >= 1700000000 and time <= 1700003600 group by toi limit 1",
+	}, {
+		name:  "arithmetic",
+		input: "select Sum(byte)/$__interval as bps, Sum(byte)/$__interval_ms as bpms from l4_flow_log where time >= $__to-300 limit 1",
+		want:  "select Sum(byte)/60 as bps, Sum(byte)/60000 as bpms from l4_flow_log where time >= 1700003600-300 limit 1",
+	}} {
+		out, err := execute(tc.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		want, _ := execute(tc.want)
+		if out == "" || out != want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, out, want)
+		}
+	}
+	if _, err := execute("select Sum(byte) as sum_byte from l4_flow_log where time >= $__timeFrom limit 1"); err == nil || !strings.Contains(err.Error(), "supported macros: $__from, $__to, $__interval, $__interval_ms") {
+		t.Errorf("unknown macro: get %v", err)
+	}
+}
+
+func TestSelectStar(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	// 小的表定义：两端的ip展开为两列，同时匹配两端的tunnel_tx_ip、已废弃及map_item的tag不展开
+	originKeys := tag.TAG_DESCRIPTION_KEYS
+	t.Cleanup(func() { tag.TAG_DESCRIPTION_KEYS = originKeys })
+	for _, description := range []*tag.TagDescription{
+		{Name: "time", ClientName: "time", ServerName: "time", Type: "time"},
+		{Name: "ip", ClientName: "ip_0", ServerName: "ip_1", Type: "ip"},
+		{Name: "is_ipv4", ClientName: "is_ipv4", ServerName: "is_ipv4", Type: "int_enum"},
+		{Name: "tunnel_tx_ip", ClientName: "tunnel_tx_ip", ServerName: "tunnel_tx_ip", Type: "ip", NotSupportedOperators: []string{"select", "group"}},
+		{Name: "tunnel_tx_ip_0", ClientName: "tunnel_tx_ip_0", ServerName: "tunnel_tx_ip_0", Type: "ip"},
+		{Name: "service", ClientName: "service_0", ServerName: "service_1", Type: "resource", Deprecated: true},
+		{Name: "biz_service.group", ClientName: "biz_service.group_0", ServerName: "biz_service.group_1", Type: "map_item"},
+		{Name: "k8s.label", ClientName: "k8s.label_0", ServerName: "k8s.label_1", Type: "map"},
+	} {
+		key := tag.TagDescriptionKey{DB: "flow_log", Table: "star_test", TagName: description.Name}
+		tag.TAG_DESCRIPTION_KEYS = append(tag.TAG_DESCRIPTION_KEYS, key)
+		tag.TAG_DESCRIPTIONS[key] = description
+		t.Cleanup(func() { delete(tag.TAG_DESCRIPTIONS, key) })
+	}
+	stmt, err := sqlparser.Parse("select Sum(byte) as sum_byte, * from star_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := CHEngine{DB: "flow_log", Table: "star_test"}
+	expanded, err := e.expandStar(stmt.(*sqlparser.Select).SelectExprs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get, want := sqlparser.String(expanded), "Sum(byte) as sum_byte, `time`, ip_0, ip_1, is_ipv4, tunnel_tx_ip_0, `k8s.label_0`, `k8s.label_1`"; get != want {
+		t.Errorf("expand:\nget:  %s\nwant: %s", get, want)
+	}
+	e = CHEngine{DB: "flow_log", Table: "unknown_table"}
+	if _, err := e.expandStar(stmt.(*sqlparser.Select).SelectExprs); err == nil {
+		t.Error("table without tags: want error")
+	}
+
+	// 按实际的表定义展开后可以正常翻译
+	e = CHEngine{DB: "flow_log", Context: context.Background()}
+	e.Init()
+	parser := parse.Parser{Engine: &e}
+	if err := parser.ParseSQL("select * from l4_flow_log limit 10"); err != nil {
+		t.Fatalf("select *: unexpected error %v", err)
+	}
+	columns := map[string]bool{}
+	for _, schema := range e.ColumnSchemas {
+		columns[schema.Name] = true
+	}
+	for _, name := range []string{"ip_0", "ip_1", "tunnel_tx_ip_0", "region_0", "region_1"} {
+		if !columns[name] {
+			t.Errorf("select *: missing column %s", name)
+		}
+	}
+	for _, name := range []string{"ip", "tunnel_tx_ip", "ip4_0"} {
+		if columns[name] {
+			t.Errorf("select *: unexpected column %s", name)
+		}
+	}
+}
+
+func TestClusterTables(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	translate := func(db, sql string) (string, bool) {
+		e := CHEngine{DB: db, Context: context.Background()}
+		e.Init()
+		parser := parse.Parser{Engine: &e}
+		if err := parser.ParseSQL(sql); err != nil {
+			t.Fatalf("%s: unexpected error %v", sql, err)
+		}
+		return e.ToSQLString(), e.Model.Cluster
+	}
+	sql := "select pod_0, Sum(byte) as sum_byte from l4_flow_log where pod_0 = 'a' and protocol in (6, 17) group by pod_0 limit 1"
+	local, cluster := translate("flow_log", sql)
+	if cluster {
+		t.Errorf("local: cluster mode without cluster-tables")
+	}
+
+	originTables := config.Cfg.Clickhouse.ClusterTables
+	t.Cleanup(func() { config.Cfg.Clickhouse.ClusterTables = originTables })
+	config.Cfg.Clickhouse.ClusterTables = []string{"flow_log.*", "flow_metrics.network"}
+	// 资源名称的过滤条件已是GLOBAL IN，常量列表不变
+	out, cluster := translate("flow_log", sql)
+	if !cluster || out != local {
+		t.Errorf("cluster:\nget:  %s (cluster %v)\nwant: %s", out, cluster, local)
+	}
+	if !strings.Contains(out, "GLOBAL IN (SELECT") || strings.Contains(out, "GLOBAL GLOBAL") {
+		t.Errorf("cluster: get %s", out)
+	}
+	for _, c := range []struct {
+		db   string
+		sql  string
+		want bool
+	}{
+		{"flow_metrics", "select Sum(byte) as sum_byte from `network.1m` limit 1", true},
+		{"flow_metrics", "select Sum(byte) as sum_byte from `network_map.1m` limit 1", false},
+	} {
+		if _, cluster := translate(c.db, c.sql); cluster != c.want {
+			t.Errorf("%s: cluster get %v, want %v", c.sql, cluster, c.want)
+		}
+	}
+	// 用户的子查询只在查询Distributed表时输出为GLOBAL IN
+	config.Cfg.Clickhouse.ClusterTables = originTables
+	subquerySQL := "select pod_0, Sum(byte) as sum_byte from l4_flow_log where pod_0 = 'a' and protocol not in (select protocol from flow_log.l7_flow_log where app_service = 'a') group by pod_0 limit 1"
+	local, _ = translate("flow_log", subquerySQL)
+	if !strings.Contains(local, "protocol NOT IN (select protocol from flow_log.l7_flow_log where app_service = 'a')") {
+		t.Errorf("subquery local: get %s", local)
+	}
+	config.Cfg.Clickhouse.ClusterTables = []string{"flow_log.*"}
+	out, _ = translate("flow_log", subquerySQL)
+	want := strings.Replace(local, "protocol NOT IN (select", "protocol GLOBAL NOT IN (select", 1)
+	if out == local || out != want {
+		t.Errorf("subquery cluster:\nget:  %s\nwant: %s", out, want)
+	}
+}
+
+func TestQueryComment(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	cache := NewModelCache(10)
+	translate := func(comment string, cached bool) string {
+		e := CHEngine{DB: "flow_log", Context: context.Background()}
+		if cached {
+			e.ModelCache = cache
+		}
+		e.Init()
+		e.SetComment(comment)
+		out, err := e.ParseCachedSQL("select pod_0, Sum(byte) as sum_byte from l4_flow_log where time >= 60 and time <= 180 group by pod_0 limit 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	plain := translate("", false)
+	for _, cached := range []bool{false, true} {
+		if out, want := translate("dashboard_id=123", cached), "/* dashboard_id=123 */ "+plain; out != want {
+			t.Errorf("cached %v:\nget:  %s\nwant: %s", cached, out, want)
+		}
+		// 注释中的*/、/*及换行不能结束注释或注入其他sql
+		out := translate("x */ DROP TABLE t; /*/ y\n-- z", cached)
+		if want := "/* x * / DROP TABLE t; / * / y -- z */ " + plain; out != want {
+			t.Errorf("cached %v:\nget:  %s\nwant: %s", cached, out, want)
+		}
+		if strings.Count(out, "*/") != 1 || strings.Count(out, "/*") != 1 {
+			t.Errorf("cached %v: comment breaks out: %s", cached, out)
+		}
+	}
+	// 缓存的sql不含注释
+	if out := translate("", true); out != plain {
+		t.Errorf("cached without comment:\nget:  %s\nwant: %s", out, plain)
+	}
+}
@@ -23,8 +23,8 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
-	"unsafe"
 
 	clickhouse "github.com/ClickHouse/clickhouse-go/v2"
 	//"github.com/k0kubun/pp"
@@ -32,7 +32,6 @@ import (
 	ctrCommon "github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/querier/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
-	"github.com/deepflowio/deepflow/server/querier/statsd"
 	"github.com/google/uuid"
 	logging "github.com/op/go-logging"
 )
@@ -48,22 +47,26 @@ type QueryParams struct {
 	ColumnSchemaMap map[string]*common.ColumnSchema
 	ORGID           string
 	SimpleSql       bool
+	MaxRows         int // 流式查询最多返回的行数，0时使用DEFAULT_STREAM_MAX_ROWS，小于0时不限制
 }
 
-// All ClickHouse Client share one connection
-var connection clickhouse.Conn
-var version string
+// All ClickHouse Client share one endpoint pool
+var (
+	pool     *EndpointPool
+	poolLock sync.Mutex
+	version  string
+)
 
 type Client struct {
-	Host       string
-	Port       int
-	UserName   string
-	Password   string
-	connection clickhouse.Conn
-	DB         string
-	Context    context.Context
-	Debug      *Debug
-	Version    string
+	Host     string
+	Port     int
+	UserName string
+	Password string
+	pool     *EndpointPool
+	DB       string
+	Context  context.Context
+	Debug    *Debug
+	Version  string
 }
 
 func (c *Client) Init(query_uuid string) error {
@@ -76,9 +79,34 @@ func (c *Client) Init(query_uuid string) error {
 			IP:        c.Host,
 		}
 	}
-	if connection == nil { // FIXME: add a RWLock
+	poolLock.Lock()
+	if pool == nil {
+		endpointPool, err := c.newEndpointPool()
+		if err != nil {
+			poolLock.Unlock()
+			return err
+		}
+		pool = endpointPool
+	}
+	c.pool = pool
+	poolLock.Unlock()
+	if version == "" {
+		version, _ = c.GetVersion()
+		c.Version = version
+	}
+	return nil
+}
+
+// newEndpointPool 为每个ClickHouse地址建立连接，未配置endpoints时使用Host及Port
+func (c *Client) newEndpointPool() (*EndpointPool, error) {
+	addrs := config.Cfg.Clickhouse.Endpoints
+	if len(addrs) == 0 {
+		addrs = []string{net.JoinHostPort(c.Host, strconv.Itoa(c.Port))}
+	}
+	endpoints := make([]*Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
 		conn, err := clickhouse.Open(&clickhouse.Options{
-			Addr: []string{net.JoinHostPort(c.Host, strconv.Itoa(c.Port))},
+			Addr: []string{addr},
 			Auth: clickhouse.Auth{
 				Database: "default",
 				Username: c.UserName,
@@ -97,25 +125,26 @@ func (c *Client) Init(query_uuid string) error {
 			DialTimeout:  time.Duration(config.Cfg.Clickhouse.Timeout) * time.Second,
 		})
 		if err != nil {
-			log.Errorf("connect clickhouse failed: %s, url: %s:%s@%s:%d", err, c.UserName, c.Password, c.Host, c.Port)
-			return err
+			log.Errorf("connect clickhouse failed: %s, url: %s:%s@%s", err, c.UserName, c.Password, addr)
+			return nil, err
 		}
-		connection = conn
-	}
-	c.connection = connection
-	if version == "" {
-		version, _ = c.GetVersion()
-		c.Version = version
+		endpoints = append(endpoints, NewEndpoint(addr, conn))
 	}
-	return nil
+	endpointPool := NewEndpointPool(endpoints, config.Cfg.Clickhouse.EndpointPolicy, config.Cfg.Clickhouse.EndpointMaxFailures)
+	endpointPool.StartProbe(
+		time.Duration(config.Cfg.Clickhouse.EndpointProbeInterval)*time.Second,
+		time.Duration(config.Cfg.Clickhouse.Timeout)*time.Second,
+	)
+	return endpointPool, nil
 }
 
 func (c *Client) Close() error {
 	return nil
 }
 
-func (c *Client) DoQuery(params *QueryParams) (result *common.Result, err error) {
-	sqlstr, callbacks, query_uuid, columnSchemaMap, simpleSql := params.Sql, params.Callbacks, params.QueryUUID, params.ColumnSchemaMap, params.SimpleSql
+// prepareSql 按查询缓存、组织及ClickHouse版本改写sql
+func (c *Client) prepareSql(params *QueryParams) (string, error) {
+	sqlstr := params.Sql
 	queryCacheStr := ""
 	if params.UseQueryCache {
 		queryCacheStr = " SETTINGS use_query_cache = true"
@@ -130,10 +159,10 @@ func (c *Client) DoQuery(params *QueryParams) (result *common.Result, err error)
 		sqlstr += queryCacheStr
 	}
 	// ORGID
-	if !simpleSql && params.ORGID != common.DEFAULT_ORG_ID && params.ORGID != "" {
+	if !params.SimpleSql && params.ORGID != common.DEFAULT_ORG_ID && params.ORGID != "" {
 		orgIDInt, err := strconv.Atoi(params.ORGID)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 		sqlstr = strings.ReplaceAll(sqlstr, "flow_tag", fmt.Sprintf("%04d_flow_tag", orgIDInt))
 	}
@@ -142,89 +171,33 @@ func (c *Client) DoQuery(params *QueryParams) (result *common.Result, err error)
 		sqlstr = strings.ReplaceAll(sqlstr, "app_label_live_view", "app_label_map")
 		sqlstr = strings.ReplaceAll(sqlstr, "target_label_live_view", "target_label_map")
 	}
+	return sqlstr, nil
+}
 
-	err = c.Init(query_uuid)
-	if err != nil {
-		return nil, err
-	}
-	defer c.Close()
-
-	start := time.Now()
-	ctx := c.Context
-	if c.Context == nil {
-		ctx = context.Background()
-	}
-	rows, err := c.connection.Query(ctx, sqlstr)
-	c.Debug.Sql = sqlstr
-	if err != nil {
-		log.Errorf("query clickhouse Error: %s, sql: %s, query_uuid: %s", err, sqlstr, c.Debug.QueryUUID)
-		c.Debug.Error = fmt.Sprintf("%s", err)
-		return nil, err
-	}
-	defer rows.Close()
-	columns := rows.ColumnTypes()
-	resColumns := len(columns)
-	columnNames := make([]interface{}, 0, len(columns))
-	var columnSchemas common.ColumnSchemas // FIXME: Slice growth should be avoided.
-	// 获取列名和列类型
-	for _, column := range columns {
-		columnNames = append(columnNames, column.Name())
-		if schema, ok := columnSchemaMap[column.Name()]; ok {
-			columnSchemas = append(columnSchemas, schema)
-		} else {
-			columnSchemas = append(columnSchemas, common.NewColumnSchema(column.Name(), "", ""))
-		}
-	}
+// DoQuery 读取完整的查询结果，回调在完整结果上执行
+func (c *Client) DoQuery(params *QueryParams) (result *common.Result, err error) {
+	result = &common.Result{}
 	var values []interface{}
-	columnValues := make([]interface{}, len(columns))
-	for i := range columns {
-		columnValues[i] = reflect.New(columns[i].ScanType()).Interface()
-		columnSchemas[i].ValueType = columns[i].DatabaseTypeName()
-	}
-	resSize := 0
-	for rows.Next() {
-		if err := rows.Scan(columnValues...); err != nil {
-			c.Debug.Error = fmt.Sprintf("%s", err)
-			return nil, err
-		}
-		record := make([]interface{}, 0, len(columns))
-		for _, rawValue := range columnValues {
-			value := TransType(rawValue)
-			resSize += int(unsafe.Sizeof(value))
-			record = append(record, value)
-		}
-		values = append(values, record)
-	}
-	// Even if the query operation produces an error, it does not necessarily return an error in the'err 'parameter,
-	// so the return value of the'rows. Err () ' method must be checked to ensure that the query operation is successful
-	if err := rows.Err(); err != nil {
-		log.Errorf("query clickhouse Error: %s, sql: %s, query_uuid: %s", err, sqlstr, c.Debug.QueryUUID)
-		c.Debug.Error = fmt.Sprintf("%s", err)
-		return nil, err
-	}
-	queryTime := time.Since(start)
-	resRows := len(values)
-	statsd.QuerierCounter.WriteCk(
-		&statsd.ClickhouseCounter{
-			ResponseSize: uint64(resSize),
-			RowCount:     uint64(resRows),
-			ColumnCount:  uint64(resColumns),
-			QueryTime:    uint64(queryTime),
+	err = c.doQueryStream(params, nil, 0, &RowHandler{
+		OnColumns: func(columns []interface{}, schemas common.ColumnSchemas) error {
+			result.Columns, result.Schemas = columns, schemas
+			return nil
 		},
-	)
-	c.Debug.QueryTime = fmt.Sprintf("%.9fs", float64(queryTime)/1e9)
-	result = &common.Result{
-		Columns: columnNames,
-		Values:  values,
-		Schemas: columnSchemas,
+		OnRow: func(row []interface{}) error {
+			values = append(values, row)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
-	for _, callback := range callbacks {
+	result.Values = values
+	for _, callback := range params.Callbacks {
 		err := callback(result)
 		if err != nil {
 			log.Error("Execute Callback %v Error: %v", callback, err)
 		}
 	}
-	log.Infof("query_uuid: %s. query api statistics: %d rows, %d columns, %d bytes, cost %f ms", c.Debug.QueryUUID, resRows, resColumns, resSize, float64(queryTime.Milliseconds()))
 	return result, nil
 }
 
@@ -235,7 +208,7 @@ func (c *Client) GetVersion() (version string, err error) {
 		ctx = context.Background()
 	}
 	sqlstr := "SELECT version()"
-	rows, err := c.connection.Query(ctx, sqlstr)
+	rows, _, err := c.pool.Query(ctx, sqlstr)
 	if err != nil {
 		log.Errorf("query clickhouse Error: %s, sql: %s", err, sqlstr)
 		return
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// blockingConn 查询阻塞到ctx取消，记录driver收到的取消原因
+type blockingConn struct {
+	driver.Conn
+	started  chan struct{}
+	canceled chan error
+}
+
+func (c *blockingConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	close(c.started)
+	<-ctx.Done()
+	c.canceled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func mockConnection(t *testing.T) *blockingConn {
+	conn := &blockingConn{started: make(chan struct{}), canceled: make(chan error, 1)}
+	originPool, originVersion := pool, version
+	pool, version = NewEndpointPool([]*Endpoint{NewEndpoint("clickhouse:9000", conn)}, ENDPOINT_POLICY_ROUND_ROBIN, 1), "24.8"
+	t.Cleanup(func() { pool, version = originPool, originVersion })
+	return conn
+}
+
+func TestDoQueryCancel(t *testing.T) {
+	conn := mockConnection(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-conn.started
+		cancel()
+	}()
+
+	c := &Client{Context: ctx}
+	_, err := c.DoQuery(&QueryParams{Sql: "SELECT 1", SimpleSql: true})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DoQuery() error = %v, want %v", err, context.Canceled)
+	}
+	select {
+	case driverErr := <-conn.canceled:
+		if !errors.Is(driverErr, context.Canceled) {
+			t.Errorf("driver canceled by %v, want %v", driverErr, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Error("cancellation did not reach the driver")
+	}
+	if c.Debug.Error == "" {
+		t.Error("debug error is empty")
+	}
+}
+
+func TestDoQueryTimeout(t *testing.T) {
+	conn := mockConnection(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	c := &Client{Context: ctx}
+	_, err := c.DoQuery(&QueryParams{Sql: "SELECT 1", SimpleSql: true})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DoQuery() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if driverErr := <-conn.canceled; !errors.Is(driverErr, context.DeadlineExceeded) {
+		t.Errorf("driver canceled by %v, want %v", driverErr, context.DeadlineExceeded)
+	}
+}
@@ -29,6 +29,8 @@ type Debug struct {
 	QueryTime string
 	QueryUUID string
 	Error     string
+	Rows      int `json:"-"`
+	Bytes     int `json:"-"`
 }
 
 type DebugInfo struct {
@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	clickhouse "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// 选择地址的策略
+const (
+	ENDPOINT_POLICY_ROUND_ROBIN       = "round-robin"
+	ENDPOINT_POLICY_LEAST_OUTSTANDING = "least-outstanding"
+)
+
+// Endpoint ClickHouse的一个地址，连续连接失败达到阈值后标记为不健康，由探测恢复
+type Endpoint struct {
+	Addr        string
+	conn        driver.Conn
+	outstanding int // 正在执行的查询数
+	failures    int // 连续的连接错误数
+	healthy     bool
+}
+
+func NewEndpoint(addr string, conn driver.Conn) *Endpoint {
+	return &Endpoint{Addr: addr, conn: conn, healthy: true}
+}
+
+// EndpointPool 在多个ClickHouse地址间路由查询，连接错误时换一个地址重试一次
+type EndpointPool struct {
+	endpoints   []*Endpoint
+	policy      string
+	maxFailures int
+
+	lock sync.Mutex
+	next int // 轮询的起始位置
+}
+
+func NewEndpointPool(endpoints []*Endpoint, policy string, maxFailures int) *EndpointPool {
+	if maxFailures <= 0 {
+		maxFailures = 1
+	}
+	return &EndpointPool{endpoints: endpoints, policy: policy, maxFailures: maxFailures}
+}
+
+// Query 在健康的地址上执行查询，连接错误时换一个地址重试一次，查询本身的错误（语法、内存超限等）不重试，
+// 返回实际执行查询的地址，关闭返回的rows后才认为查询结束
+func (p *EndpointPool) Query(ctx context.Context, query string) (driver.Rows, string, error) {
+	var tried *Endpoint
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		endpoint := p.pick(tried)
+		if endpoint == nil {
+			break
+		}
+		rows, err := endpoint.conn.Query(ctx, query)
+		if err == nil {
+			p.markSuccess(endpoint)
+			return &endpointRows{Rows: rows, release: func() { p.release(endpoint) }}, endpoint.Addr, nil
+		}
+		p.release(endpoint)
+		if ctx.Err() != nil {
+			return nil, endpoint.Addr, err
+		}
+		if !IsConnectionError(err) {
+			p.markSuccess(endpoint)
+			return nil, endpoint.Addr, err
+		}
+		p.markFailure(endpoint, err)
+		tried, lastErr = endpoint, err
+	}
+	if tried == nil {
+		return nil, "", clickhouse.ErrAcquireConnNoAddress
+	}
+	return nil, tried.Addr, lastErr
+}
+
+// pick 按策略选择一个健康的地址，都不健康时从所有地址中选择，exclude为已尝试过的地址
+func (p *EndpointPool) pick(exclude *Endpoint) *Endpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var candidates []*Endpoint
+	for _, endpoint := range p.endpoints {
+		if endpoint != exclude && endpoint.healthy {
+			candidates = append(candidates, endpoint)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, endpoint := range p.endpoints {
+			if endpoint != exclude {
+				candidates = append(candidates, endpoint)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	start := p.next % len(candidates)
+	p.next++
+	selected := candidates[start]
+	if p.policy == ENDPOINT_POLICY_LEAST_OUTSTANDING {
+		// 正在执行的查询数相同时按轮询的顺序选择
+		for i := 1; i < len(candidates); i++ {
+			endpoint := candidates[(start+i)%len(candidates)]
+			if endpoint.outstanding < selected.outstanding {
+				selected = endpoint
+			}
+		}
+	}
+	selected.outstanding++
+	return selected
+}
+
+func (p *EndpointPool) release(endpoint *Endpoint) {
+	p.lock.Lock()
+	endpoint.outstanding--
+	p.lock.Unlock()
+}
+
+func (p *EndpointPool) markSuccess(endpoint *Endpoint) {
+	p.lock.Lock()
+	if !endpoint.healthy {
+		log.Infof("clickhouse endpoint %s recovered", endpoint.Addr)
+	}
+	endpoint.failures = 0
+	endpoint.healthy = true
+	p.lock.Unlock()
+}
+
+func (p *EndpointPool) markFailure(endpoint *Endpoint, err error) {
+	p.lock.Lock()
+	endpoint.failures++
+	if endpoint.healthy && endpoint.failures >= p.maxFailures {
+		endpoint.healthy = false
+		log.Warningf("clickhouse endpoint %s is unhealthy after %d consecutive failures: %s", endpoint.Addr, endpoint.failures, err)
+	}
+	p.lock.Unlock()
+}
+
+// Probe 探测不健康的地址，连接成功的地址恢复为健康
+func (p *EndpointPool) Probe(ctx context.Context) {
+	p.lock.Lock()
+	var unhealthy []*Endpoint
+	for _, endpoint := range p.endpoints {
+		if !endpoint.healthy {
+			unhealthy = append(unhealthy, endpoint)
+		}
+	}
+	p.lock.Unlock()
+	for _, endpoint := range unhealthy {
+		if err := endpoint.conn.Ping(ctx); err != nil {
+			log.Debugf("probe clickhouse endpoint %s failed: %s", endpoint.Addr, err)
+			continue
+		}
+		p.markSuccess(endpoint)
+	}
+}
+
+// StartProbe 每隔interval探测一次不健康的地址
+func (p *EndpointPool) StartProbe(interval, timeout time.Duration) {
+	if interval <= 0 || len(p.endpoints) <= 1 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			p.Probe(ctx)
+			cancel()
+		}
+	}()
+}
+
+// Healthy 返回健康的地址
+func (p *EndpointPool) Healthy() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var addrs []string
+	for _, endpoint := range p.endpoints {
+		if endpoint.healthy {
+			addrs = append(addrs, endpoint.Addr)
+		}
+	}
+	return addrs
+}
+
+// IsConnectionError 是否为连接层面的错误，ClickHouse返回的异常及取消、超时都不是连接错误
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, clickhouse.ErrAcquireConnTimeout)
+}
+
+// endpointRows 关闭时减少地址正在执行的查询数
+type endpointRows struct {
+	driver.Rows
+	once    sync.Once
+	release func()
+}
+
+func (r *endpointRows) Close() error {
+	err := r.Rows.Close()
+	r.once.Do(r.release)
+	return err
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+
+	clickhouse "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// fakeRows 查询成功时返回的空结果
+type fakeRows struct {
+	driver.Rows
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+// fakeConn 按queryErr及pingErr返回结果，记录收到的查询数
+type fakeConn struct {
+	driver.Conn
+	queryErr error
+	pingErr  error
+	queries  int
+}
+
+func (c *fakeConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	c.queries++
+	if c.queryErr != nil {
+		return nil, c.queryErr
+	}
+	return &fakeRows{}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	return c.pingErr
+}
+
+func newFakePool(policy string, maxFailures int, conns ...*fakeConn) *EndpointPool {
+	endpoints := make([]*Endpoint, 0, len(conns))
+	for i, conn := range conns {
+		endpoints = append(endpoints, NewEndpoint(string(rune('a'+i)), conn))
+	}
+	return NewEndpointPool(endpoints, policy, maxFailures)
+}
+
+func TestEndpointFailover(t *testing.T) {
+	down := &fakeConn{queryErr: syscall.ECONNREFUSED}
+	up := &fakeConn{}
+	p := newFakePool(ENDPOINT_POLICY_ROUND_ROBIN, 2, down, up)
+	// 连接错误时换一个地址重试
+	for i := 0; i < 2; i++ {
+		rows, addr, err := p.Query(context.Background(), "SELECT 1")
+		if err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+		rows.Close()
+		if addr != "b" {
+			t.Errorf("query %d: executed on %s, want b", i, addr)
+		}
+	}
+	// 连续失败达到阈值后不再路由到该地址
+	if healthy := p.Healthy(); !reflect.DeepEqual(healthy, []string{"b"}) {
+		t.Errorf("healthy: get %v, want [b]", healthy)
+	}
+	queries := down.queries
+	for i := 0; i < 4; i++ {
+		rows, _, err := p.Query(context.Background(), "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	if down.queries != queries {
+		t.Errorf("unhealthy endpoint received %d queries", down.queries-queries)
+	}
+
+	// 只重试一次，都失败时返回连接错误
+	p = newFakePool(ENDPOINT_POLICY_ROUND_ROBIN, 3, &fakeConn{queryErr: syscall.ECONNRESET}, &fakeConn{queryErr: syscall.ECONNREFUSED})
+	if _, _, err := p.Query(context.Background(), "SELECT 1"); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("all failed: get %v, want %v", err, syscall.ECONNREFUSED)
+	}
+}
+
+func TestEndpointNoRetryOnQueryError(t *testing.T) {
+	exception := &clickhouse.Exception{Code: 62, Message: "Syntax error"}
+	first := &fakeConn{queryErr: exception}
+	second := &fakeConn{}
+	p := newFakePool(ENDPOINT_POLICY_ROUND_ROBIN, 1, first, second)
+	_, addr, err := p.Query(context.Background(), "SELEC 1")
+	if !errors.Is(err, exception) || addr != "a" {
+		t.Errorf("get (%s, %v), want (a, %v)", addr, err, exception)
+	}
+	if second.queries != 0 {
+		t.Errorf("query error retried on another endpoint")
+	}
+	if healthy := p.Healthy(); len(healthy) != 2 {
+		t.Errorf("query error marked endpoint unhealthy: %v", healthy)
+	}
+
+	// 取消的查询不重试
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	first, second = &fakeConn{queryErr: context.Canceled}, &fakeConn{queryErr: context.Canceled}
+	p = newFakePool(ENDPOINT_POLICY_ROUND_ROBIN, 1, first, second)
+	if _, _, err := p.Query(ctx, "SELECT 1"); !errors.Is(err, context.Canceled) || first.queries+second.queries != 1 {
+		t.Errorf("canceled query: get %v, want %v without retry", err, context.Canceled)
+	}
+}
+
+func TestEndpointRecovery(t *testing.T) {
+	down := &fakeConn{queryErr: syscall.ECONNREFUSED, pingErr: syscall.ECONNREFUSED}
+	p := newFakePool(ENDPOINT_POLICY_ROUND_ROBIN, 1, down, &fakeConn{})
+	rows, _, err := p.Query(context.Background(), "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	p.Probe(context.Background())
+	if healthy := p.Healthy(); !reflect.DeepEqual(healthy, []string{"b"}) {
+		t.Errorf("probe failed: healthy %v, want [b]", healthy)
+	}
+	// 探测成功后恢复路由
+	down.queryErr, down.pingErr = nil, nil
+	p.Probe(context.Background())
+	if healthy := p.Healthy(); !reflect.DeepEqual(healthy, []string{"a", "b"}) {
+		t.Errorf("probe succeeded: healthy %v, want [a b]", healthy)
+	}
+	queries := down.queries
+	for i := 0; i < 2; i++ {
+		rows, _, err := p.Query(context.Background(), "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	if down.queries != queries+1 {
+		t.Errorf("recovered endpoint received %d of 2 queries, want 1", down.queries-queries)
+	}
+}
+
+func TestEndpointLeastOutstanding(t *testing.T) {
+	p := newFakePool(ENDPOINT_POLICY_LEAST_OUTSTANDING, 1, &fakeConn{}, &fakeConn{}, &fakeConn{})
+	var addrs []string
+	var open []driver.Rows
+	for i := 0; i < 3; i++ {
+		rows, addr, err := p.Query(context.Background(), "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+		open = append(open, rows)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("addrs: get %v, want %v", addrs, want)
+	}
+	// b的查询结束后，新的查询路由到b
+	open[1].Close()
+	open[1].Close()
+	rows, addr, err := p.Query(context.Background(), "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "b" {
+		t.Errorf("get %s, want b", addr)
+	}
+	rows.Close()
+	open[0].Close()
+	open[2].Close()
+	for _, endpoint := range p.endpoints {
+		if endpoint.outstanding != 0 {
+			t.Errorf("%s outstanding: get %d, want 0", endpoint.Addr, endpoint.outstanding)
+		}
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{syscall.ECONNREFUSED, true},
+		{clickhouse.ErrAcquireConnTimeout, true},
+		{&clickhouse.Exception{Code: 241, Message: "Memory limit exceeded"}, false},
+		{context.DeadlineExceeded, false},
+		{nil, false},
+	} {
+		if get := IsConnectionError(c.err); get != c.want {
+			t.Errorf("IsConnectionError(%v) = %v, want %v", c.err, get, c.want)
+		}
+	}
+}
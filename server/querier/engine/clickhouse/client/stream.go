@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+	"unsafe"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/statsd"
+)
+
+const (
+	// DEFAULT_STREAM_MAX_ROWS 流式查询未设置MaxRows时最多返回的行数
+	DEFAULT_STREAM_MAX_ROWS = 1000000
+	// STREAM_BLOCK_SIZE 每读取这么多行执行一次列翻译回调并交给调用方
+	STREAM_BLOCK_SIZE = 8192
+)
+
+// ErrStopStream OnRow返回该错误时停止读取并终止ClickHouse上的查询，DoQueryStream不返回错误
+var ErrStopStream = errors.New("stop stream")
+
+// RowLimitExceededError 流式查询的结果超过了行数上限
+type RowLimitExceededError struct {
+	Limit int
+}
+
+func (e *RowLimitExceededError) Error() string {
+	return fmt.Sprintf("query result exceeds %d rows, add a limit to the query or raise max rows", e.Limit)
+}
+
+// RowHandler 流式查询的回调
+type RowHandler struct {
+	// OnColumns 在第一行之前调用一次，列名为执行列翻译回调后的结果
+	OnColumns func(columns []interface{}, schemas common.ColumnSchemas) error
+	// OnRow 每行调用一次，返回ErrStopStream时停止读取
+	OnRow func(row []interface{}) error
+}
+
+// DoQueryStream 按行读取查询结果交给handler，不在内存中保留完整结果；
+// 列翻译回调按批执行，补点等需要完整结果的回调不能用于流式查询
+func (c *Client) DoQueryStream(params *QueryParams, handler *RowHandler) error {
+	maxRows := params.MaxRows
+	if maxRows == 0 {
+		maxRows = DEFAULT_STREAM_MAX_ROWS
+	}
+	return c.doQueryStream(params, params.Callbacks, maxRows, handler)
+}
+
+// doQueryStream maxRows小于等于0时不限制行数
+func (c *Client) doQueryStream(params *QueryParams, callbacks map[string]func(result *common.Result) error, maxRows int, handler *RowHandler) error {
+	sqlstr, err := c.prepareSql(params)
+	if err != nil {
+		return err
+	}
+	err = c.Init(params.QueryUUID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	start := time.Now()
+	ctx := c.Context
+	if c.Context == nil {
+		ctx = context.Background()
+	}
+	// 调用方提前停止读取时取消查询，避免继续接收剩余的数据
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rows, addr, err := c.pool.Query(ctx, sqlstr)
+	c.Debug.Sql = sqlstr
+	if addr != "" {
+		c.Debug.IP = addr
+	}
+	if err != nil {
+		log.Errorf("query clickhouse Error: %s, sql: %s, query_uuid: %s", err, sqlstr, c.Debug.QueryUUID)
+		c.Debug.Error = fmt.Sprintf("%s", err)
+		return err
+	}
+	defer rows.Close()
+	columns := rows.ColumnTypes()
+	resColumns := len(columns)
+	columnNames := make([]interface{}, 0, len(columns))
+	var columnSchemas common.ColumnSchemas // FIXME: Slice growth should be avoided.
+	// 获取列名和列类型
+	for _, column := range columns {
+		columnNames = append(columnNames, column.Name())
+		if schema, ok := params.ColumnSchemaMap[column.Name()]; ok {
+			columnSchemas = append(columnSchemas, schema)
+		} else {
+			columnSchemas = append(columnSchemas, common.NewColumnSchema(column.Name(), "", ""))
+		}
+	}
+	columnValues := make([]interface{}, len(columns))
+	for i := range columns {
+		columnValues[i] = reflect.New(columns[i].ScanType()).Interface()
+		columnSchemas[i].ValueType = columns[i].DatabaseTypeName()
+	}
+
+	columnsSent := false
+	block := make([]interface{}, 0, STREAM_BLOCK_SIZE)
+	// flush 对一批行执行列翻译回调后交给调用方
+	flush := func() error {
+		result := &common.Result{Columns: columnNames, Values: block, Schemas: columnSchemas}
+		for _, callback := range callbacks {
+			err := callback(result)
+			if err != nil {
+				log.Error("Execute Callback %v Error: %v", callback, err)
+			}
+		}
+		if !columnsSent {
+			columnsSent = true
+			if handler.OnColumns != nil {
+				if err := handler.OnColumns(result.Columns, result.Schemas); err != nil {
+					return err
+				}
+			}
+		}
+		for _, row := range result.Values {
+			if err := handler.OnRow(row.([]interface{})); err != nil {
+				return err
+			}
+		}
+		block = block[:0]
+		return nil
+	}
+	resSize, resRows := 0, 0
+	for err == nil && rows.Next() {
+		if maxRows > 0 && resRows >= maxRows {
+			err = &RowLimitExceededError{Limit: maxRows}
+			break
+		}
+		if err = rows.Scan(columnValues...); err != nil {
+			break
+		}
+		record := make([]interface{}, 0, len(columns))
+		for _, rawValue := range columnValues {
+			value := TransType(rawValue)
+			resSize += int(unsafe.Sizeof(value))
+			record = append(record, value)
+		}
+		block = append(block, record)
+		resRows++
+		if len(block) >= STREAM_BLOCK_SIZE {
+			err = flush()
+		}
+	}
+	// Even if the query operation produces an error, it does not necessarily return an error in the'err 'parameter,
+	// so the return value of the'rows. Err () ' method must be checked to ensure that the query operation is successful
+	if err == nil {
+		if err = rows.Err(); err != nil {
+			log.Errorf("query clickhouse Error: %s, sql: %s, query_uuid: %s", err, sqlstr, c.Debug.QueryUUID)
+		}
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// 先取消查询再关闭rows
+		cancel()
+		if errors.Is(err, ErrStopStream) {
+			err = nil
+		} else {
+			c.Debug.Error = fmt.Sprintf("%s", err)
+		}
+	}
+	queryTime := time.Since(start)
+	statsd.QuerierCounter.WriteCk(
+		&statsd.ClickhouseCounter{
+			ResponseSize: uint64(resSize),
+			RowCount:     uint64(resRows),
+			ColumnCount:  uint64(resColumns),
+			QueryTime:    uint64(queryTime),
+		},
+	)
+	c.Debug.QueryTime = fmt.Sprintf("%.9fs", float64(queryTime)/1e9)
+	c.Debug.Rows, c.Debug.Bytes = resRows, resSize
+	log.Infof("query_uuid: %s. query api statistics: %d rows, %d columns, %d bytes, cost %f ms", c.Debug.QueryUUID, resRows, resColumns, resSize, float64(queryTime.Milliseconds()))
+	return err
+}
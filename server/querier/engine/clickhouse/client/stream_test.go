@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/statsd"
+)
+
+const streamTestRows = 100000
+
+type fakeColumnType struct {
+	driver.ColumnType
+	name     string
+	scanType reflect.Type
+	dbType   string
+}
+
+func (c *fakeColumnType) Name() string             { return c.name }
+func (c *fakeColumnType) ScanType() reflect.Type   { return c.scanType }
+func (c *fakeColumnType) DatabaseTypeName() string { return c.dbType }
+
+// generatedRows 逐行生成total行(id, name)，记录已扫描的行数及是否在ctx取消后关闭
+type generatedRows struct {
+	driver.Rows
+	ctx     context.Context
+	total   int
+	scanned int
+	closed  bool
+}
+
+func (r *generatedRows) ColumnTypes() []driver.ColumnType {
+	return []driver.ColumnType{
+		&fakeColumnType{name: "id", scanType: reflect.TypeOf(uint64(0)), dbType: "UInt64"},
+		&fakeColumnType{name: "name", scanType: reflect.TypeOf(""), dbType: "String"},
+	}
+}
+
+func (r *generatedRows) Next() bool {
+	return r.ctx.Err() == nil && r.scanned < r.total
+}
+
+func (r *generatedRows) Scan(dest ...interface{}) error {
+	*dest[0].(*uint64) = uint64(r.scanned)
+	*dest[1].(*string) = fmt.Sprintf("row-%d", r.scanned)
+	r.scanned++
+	return nil
+}
+
+func (r *generatedRows) Err() error   { return nil }
+func (r *generatedRows) Close() error { r.closed = true; return nil }
+
+// generatorConn 每次查询返回一个新的generatedRows
+type generatorConn struct {
+	driver.Conn
+	total int
+	rows  *generatedRows
+}
+
+func (c *generatorConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	c.rows = &generatedRows{ctx: ctx, total: c.total}
+	return c.rows, nil
+}
+
+func mockGenerator(t *testing.T, total int) *generatorConn {
+	conn := &generatorConn{total: total}
+	originPool, originVersion, originCounter := pool, version, statsd.QuerierCounter
+	pool, version = NewEndpointPool([]*Endpoint{NewEndpoint("clickhouse:9000", conn)}, ENDPOINT_POLICY_ROUND_ROBIN, 1), "24.8"
+	statsd.QuerierCounter = statsd.NewCounter()
+	t.Cleanup(func() { pool, version, statsd.QuerierCounter = originPool, originVersion, originCounter })
+	return conn
+}
+
+// suffixName 列翻译回调，给name列加上后缀
+func suffixName(result *common.Result) error {
+	for _, row := range result.Values {
+		record := row.([]interface{})
+		record[1] = fmt.Sprintf("%v!", record[1])
+	}
+	return nil
+}
+
+func TestDoQueryStreamBounded(t *testing.T) {
+	conn := mockGenerator(t, streamTestRows)
+	c := &Client{}
+	var columns []interface{}
+	consumed, maxBuffered := 0, 0
+	err := c.DoQueryStream(&QueryParams{
+		Sql:       "SELECT id, name FROM t",
+		SimpleSql: true,
+		MaxRows:   -1,
+		Callbacks: map[string]func(*common.Result) error{"name": suffixName},
+	}, &RowHandler{
+		OnColumns: func(c []interface{}, schemas common.ColumnSchemas) error {
+			columns = c
+			return nil
+		},
+		OnRow: func(row []interface{}) error {
+			// 已读取但未交给调用方的行数不超过一批
+			if buffered := conn.rows.scanned - consumed; buffered > maxBuffered {
+				maxBuffered = buffered
+			}
+			if want := fmt.Sprintf("row-%d!", consumed); row[0] != uint64(consumed) || row[1] != want {
+				return fmt.Errorf("row %d: get %v", consumed, row)
+			}
+			consumed++
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed != streamTestRows {
+		t.Errorf("consumed: get %d, want %d", consumed, streamTestRows)
+	}
+	if maxBuffered > STREAM_BLOCK_SIZE {
+		t.Errorf("buffered %d rows, want at most %d", maxBuffered, STREAM_BLOCK_SIZE)
+	}
+	if want := []interface{}{"id", "name"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns: get %v, want %v", columns, want)
+	}
+	if !conn.rows.closed {
+		t.Error("rows not closed")
+	}
+}
+
+func TestDoQueryStreamStop(t *testing.T) {
+	conn := mockGenerator(t, streamTestRows)
+	c := &Client{}
+	consumed := 0
+	err := c.DoQueryStream(&QueryParams{Sql: "SELECT id, name FROM t", SimpleSql: true}, &RowHandler{
+		OnRow: func(row []interface{}) error {
+			consumed++
+			if consumed == 10 {
+				return ErrStopStream
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("stop: get %v, want nil", err)
+	}
+	if consumed != 10 {
+		t.Errorf("consumed: get %d, want 10", consumed)
+	}
+	// 停止后取消查询，不再读取剩余的行
+	if conn.rows.ctx.Err() == nil {
+		t.Error("query not canceled after stop")
+	}
+	if conn.rows.scanned > STREAM_BLOCK_SIZE {
+		t.Errorf("scanned %d rows after stop, want at most %d", conn.rows.scanned, STREAM_BLOCK_SIZE)
+	}
+	if !conn.rows.closed {
+		t.Error("rows not closed")
+	}
+
+	// 其他错误原样返回
+	handlerErr := errors.New("write failed")
+	err = c.DoQueryStream(&QueryParams{Sql: "SELECT id, name FROM t", SimpleSql: true}, &RowHandler{
+		OnRow: func(row []interface{}) error { return handlerErr },
+	})
+	if !errors.Is(err, handlerErr) {
+		t.Errorf("handler error: get %v, want %v", err, handlerErr)
+	}
+}
+
+func TestDoQueryStreamRowLimit(t *testing.T) {
+	conn := mockGenerator(t, streamTestRows)
+	c := &Client{}
+	consumed := 0
+	handler := &RowHandler{OnRow: func(row []interface{}) error {
+		consumed++
+		return nil
+	}}
+	err := c.DoQueryStream(&QueryParams{Sql: "SELECT id, name FROM t", SimpleSql: true, MaxRows: 1000}, handler)
+	var limitErr *RowLimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.Limit != 1000 {
+		t.Fatalf("row limit: get %v, want RowLimitExceededError", err)
+	}
+	if conn.rows.scanned != 1000 || conn.rows.ctx.Err() == nil {
+		t.Errorf("scanned %d rows, canceled %v; want 1000 rows and canceled", conn.rows.scanned, conn.rows.ctx.Err() != nil)
+	}
+
+	// 结果行数恰好等于上限时不报错
+	consumed = 0
+	conn.total = 1000
+	if err := c.DoQueryStream(&QueryParams{Sql: "SELECT id, name FROM t", SimpleSql: true, MaxRows: 1000}, handler); err != nil || consumed != 1000 {
+		t.Errorf("exact limit: get (%d, %v), want (1000, nil)", consumed, err)
+	}
+}
+
+func TestDoQueryOnStream(t *testing.T) {
+	mockGenerator(t, streamTestRows)
+	c := &Client{}
+	result, err := c.DoQuery(&QueryParams{
+		Sql:       "SELECT id, name FROM t",
+		SimpleSql: true,
+		Callbacks: map[string]func(*common.Result) error{"name": suffixName},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 一次性查询不受流式查询的行数上限限制
+	if len(result.Values) != streamTestRows {
+		t.Fatalf("rows: get %d, want %d", len(result.Values), streamTestRows)
+	}
+	last := result.Values[streamTestRows-1].([]interface{})
+	if want := []interface{}{uint64(streamTestRows - 1), fmt.Sprintf("row-%d!", streamTestRows-1)}; !reflect.DeepEqual(last, want) {
+		t.Errorf("last row: get %v, want %v", last, want)
+	}
+	if c.Debug.Rows != streamTestRows {
+		t.Errorf("debug rows: get %d, want %d", c.Debug.Rows, streamTestRows)
+	}
+}
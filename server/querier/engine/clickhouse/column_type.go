@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/metrics"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+)
+
+const (
+	COLUMN_TYPE_TIME   = "time"
+	COLUMN_TYPE_INT    = "int"
+	COLUMN_TYPE_FLOAT  = "float"
+	COLUMN_TYPE_STRING = "string"
+	COLUMN_TYPE_IP     = "ip"
+)
+
+// tag定义中的类型对应的输出类型，未列出的类型输出为string
+var tagColumnTypes = map[string]string{
+	"time":     COLUMN_TYPE_TIME,
+	"ip":       COLUMN_TYPE_IP,
+	"int":      COLUMN_TYPE_INT,
+	"int_enum": COLUMN_TYPE_INT,
+	"bit_enum": COLUMN_TYPE_INT,
+	"id":       COLUMN_TYPE_INT,
+	"bool":     COLUMN_TYPE_INT,
+}
+
+// 输出类型与指标量类型相同的算子，其余算子输出为float
+var metricsTypeFunctions = []string{
+	view.FUNCTION_SUM, view.FUNCTION_MAX, view.FUNCTION_MIN, view.FUNCTION_LAST, view.FUNCTION_FIRST,
+	view.FUNCTION_SPREAD, view.FUNCTION_DELTA,
+}
+
+// 指标量值为浮点数的库
+var floatMetricsDBs = []string{
+	chCommon.DB_NAME_EXT_METRICS, chCommon.DB_NAME_PROMETHEUS, chCommon.DB_NAME_DEEPFLOW_ADMIN, chCommon.DB_NAME_DEEPFLOW_TENANT,
+}
+
+// ColumnType select中一列的别名及输出值的类型
+type ColumnType struct {
+	Alias string
+	Type  string
+}
+
+// GetColumnTypes 解析后按tag及算子的定义返回select中每一列的类型，无法确定类型的列为string
+func (e *CHEngine) GetColumnTypes() []ColumnType {
+	columnTypes := make([]ColumnType, 0, len(e.ColumnSchemas))
+	for _, schema := range e.ColumnSchemas {
+		expr := schema.PreAS
+		if expr == "" {
+			expr = schema.Name
+		}
+		columnTypes = append(columnTypes, ColumnType{Alias: schema.Name, Type: e.exprColumnType(expr)})
+	}
+	return columnTypes
+}
+
+func (e *CHEngine) exprColumnType(expr string) string {
+	stmt, err := sqlparser.Parse("select " + expr)
+	if err != nil {
+		return COLUMN_TYPE_STRING
+	}
+	selectStmt, ok := stmt.(*sqlparser.Select)
+	if !ok || len(selectStmt.SelectExprs) == 0 {
+		return COLUMN_TYPE_STRING
+	}
+	item, ok := selectStmt.SelectExprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return COLUMN_TYPE_STRING
+	}
+	return e.nodeColumnType(item.Expr)
+}
+
+func (e *CHEngine) nodeColumnType(expr sqlparser.Expr) string {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		return e.tagColumnType(strings.Trim(sqlparser.String(expr), "`"))
+	case *sqlparser.FuncExpr:
+		name := expr.Name.String()
+		args := []sqlparser.Expr{}
+		for _, arg := range expr.Exprs {
+			if item, ok := arg.(*sqlparser.AliasedExpr); ok {
+				args = append(args, item.Expr)
+			}
+		}
+		switch {
+		case strings.EqualFold(name, "time"):
+			return COLUMN_TYPE_TIME
+		case name == view.FUNCTION_UNIQ || name == view.FUNCTION_UNIQ_EXACT || name == view.FUNCTION_COUNT:
+			return COLUMN_TYPE_INT
+		case isArgMaxFunction(name) && len(args) > 0:
+			// 返回取到最大/最小值的维度
+			return e.nodeColumnType(args[0])
+		case slices.Contains(metricsTypeFunctions, name) && len(args) > 0:
+			return e.metricsColumnType(strings.Trim(sqlparser.String(args[0]), "`"))
+		case name != view.FUNCTION_TOPK && name != view.FUNCTION_ANY && metrics.METRICS_FUNCTIONS_MAP[name] != nil:
+			return COLUMN_TYPE_FLOAT
+		}
+	case *sqlparser.BinaryExpr, *sqlparser.ParenExpr:
+		return COLUMN_TYPE_FLOAT
+	case *sqlparser.SQLVal:
+		switch expr.Type {
+		case sqlparser.IntVal:
+			return COLUMN_TYPE_INT
+		case sqlparser.FloatVal:
+			return COLUMN_TYPE_FLOAT
+		}
+	case *sqlparser.UnaryExpr:
+		if isNumericLiteral(expr) {
+			return e.nodeColumnType(expr.Expr)
+		}
+	}
+	return COLUMN_TYPE_STRING
+}
+
+// tagColumnType 按tag定义返回类型，不是tag时按指标量返回类型
+func (e *CHEngine) tagColumnType(name string) string {
+	// network.1m等带数据源的表使用表名部分的定义
+	table, _, _ := strings.Cut(e.Table, ".")
+	for _, key := range tag.TAG_DESCRIPTION_KEYS {
+		if key.DB != e.DB || key.Table != table {
+			continue
+		}
+		description := tag.TAG_DESCRIPTIONS[key]
+		if description.Name != name && description.ClientName != name && description.ServerName != name {
+			continue
+		}
+		if columnType, ok := tagColumnTypes[description.Type]; ok {
+			return columnType
+		}
+		return COLUMN_TYPE_STRING
+	}
+	return e.metricsColumnType(name)
+}
+
+func (e *CHEngine) metricsColumnType(name string) string {
+	metricStruct, ok := metrics.GetAggMetrics(name, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics)
+	if !ok {
+		return COLUMN_TYPE_STRING
+	}
+	switch metricStruct.Type {
+	case metrics.METRICS_TYPE_PERCENTAGE, metrics.METRICS_TYPE_QUOTIENT, metrics.METRICS_TYPE_BOUNDED_GAUGE:
+		return COLUMN_TYPE_FLOAT
+	case metrics.METRICS_TYPE_TAG, metrics.METRICS_TYPE_ARRAY:
+		return COLUMN_TYPE_STRING
+	}
+	if slices.Contains(floatMetricsDBs, e.DB) {
+		return COLUMN_TYPE_FLOAT
+	}
+	return COLUMN_TYPE_INT
+}
@@ -63,6 +63,11 @@ const (
 	API_CUSTOM_METRICS_FORMAT = "http://localhost:%d/v1/custom-metrics"
 )
 
+const DEFAULT_TIME_COLUMN = "time"
+
+// 各数据库的时间列名，未配置的数据库使用time，由db_descriptions/clickhouse/time_column加载
+var DB_TIME_COLUMN_MAP = map[string]string{}
+
 var DB_TABLE_MAP = map[string][]string{
 	DB_NAME_FLOW_LOG:        []string{"l4_flow_log", "l7_flow_log", "l4_packet", "l7_packet"},
 	DB_NAME_FLOW_METRICS:    []string{"network", "network_map", "application", "application_map", "traffic_policy"},
@@ -101,3 +106,13 @@ var PositiveOperatorMap = map[string]string{
 	"in":    "not in",
 	"=":     "!=",
 }
+
+// flow_metrics按时间粒度从粗到细排列的数据源
+var FLOW_METRICS_DATASOURCES = []string{"1d", "1h", "1m", "1s"}
+
+var DATASOURCE_NAME_INTERVAL_MAP = map[string]int{
+	"1s": 1,
+	"1m": 60,
+	"1h": 3600,
+	"1d": 86400,
+}
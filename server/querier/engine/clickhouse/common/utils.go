@@ -48,6 +48,31 @@ func ParseAlias(node sqlparser.SQLNode) string {
 	return alias
 }
 
+// GetTimeColumn 返回数据库的时间列名，查询中的time会被替换为该列
+func GetTimeColumn(db string) string {
+	if column, ok := DB_TIME_COLUMN_MAP[db]; ok {
+		return column
+	}
+	return DEFAULT_TIME_COLUMN
+}
+
+// LoadTimeColumns 加载各数据库的时间列名，每行格式为: db, time_column
+func LoadTimeColumns(data [][]interface{}) error {
+	timeColumns := map[string]string{}
+	for _, line := range data {
+		if len(line) != 2 {
+			return fmt.Errorf("time column description %v is invalid, should be 'db, time_column'", line)
+		}
+		db, column := line[0].(string), line[1].(string)
+		if db == "" || column == "" {
+			return fmt.Errorf("time column description %v is invalid, db and time_column can't be empty", line)
+		}
+		timeColumns[db] = column
+	}
+	DB_TIME_COLUMN_MAP = timeColumns
+	return nil
+}
+
 // Permissions解析为数组
 // 最高十进制位：用户组A是否有权限，通常可用于代表管理员用户组
 // 第二个十进制位：用户组B是否有权限，通常可用于代表OnPrem租户用户组
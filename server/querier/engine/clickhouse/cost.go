@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"strings"
+
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+)
+
+const (
+	COST_DEFAULT_PARTITION_SECONDS = 3600
+	// 未限定开始时间时按30天估计
+	COST_UNBOUNDED_TIME_RANGE = 30 * 86400
+	// 存在可下推到PREWHERE的非时间过滤条件时，扫描量按该比例估计
+	COST_PREWHERE_FACTOR = 0.5
+)
+
+// 各库的分区粒度(s)，与ingester建表时的PARTITION BY一致
+var costPartitionSeconds = map[string]int64{
+	chCommon.DB_NAME_FLOW_LOG:        3600,
+	chCommon.DB_NAME_APPLICATION_LOG: 3600,
+	chCommon.DB_NAME_PROFILE:         3600,
+	chCommon.DB_NAME_PROMETHEUS:      2 * 3600,
+	chCommon.DB_NAME_EXT_METRICS:     2 * 3600,
+	chCommon.DB_NAME_DEEPFLOW_ADMIN:  2 * 3600,
+	chCommon.DB_NAME_DEEPFLOW_TENANT: 2 * 3600,
+	chCommon.DB_NAME_EVENT:           12 * 3600,
+}
+
+// flow_metrics按数据源粒度分区：1s按小时，1m按12小时，1h按周，1d按月
+var costMetricsPartitionSeconds = map[int]int64{
+	1:     3600,
+	60:    12 * 3600,
+	3600:  7 * 86400,
+	86400: 31 * 86400,
+}
+
+// QueryCost 查询代价的启发式估计，用于在执行前提示高代价的查询
+type QueryCost struct {
+	Partitions int64   `json:"partitions"` // 预计扫描的分区数
+	Score      float64 `json:"score"`      // 复杂度评分，约为每个序列扫描的数据点数，越大代价越高
+}
+
+// QueryCost 根据解析后Model中的时间范围、数据源及过滤条件估算查询代价，不访问ClickHouse
+func (e *CHEngine) QueryCost() *QueryCost {
+	e.ToView()
+	t := e.Model.Time
+	end := t.TimeEnd
+	if end == 0 {
+		end = e.now().Unix()
+	}
+	start := t.TimeStart
+	if start == 0 {
+		start = end - COST_UNBOUNDED_TIME_RANGE
+	}
+	timeRange := end - start
+	if timeRange < 1 {
+		timeRange = 1
+	}
+
+	partitionSeconds := int64(COST_DEFAULT_PARTITION_SECONDS)
+	if e.DB == chCommon.DB_NAME_FLOW_METRICS {
+		if seconds, ok := costMetricsPartitionSeconds[t.DatasourceInterval]; ok {
+			partitionSeconds = seconds
+		}
+	} else if seconds, ok := costPartitionSeconds[e.DB]; ok {
+		partitionSeconds = seconds
+	}
+
+	datasourceInterval := t.DatasourceInterval
+	if datasourceInterval < 1 {
+		datasourceInterval = 1
+	}
+	score := float64(timeRange) / float64(datasourceInterval)
+	if !e.NoPreWhere && (!e.Model.PreFilters.IsNull() || hasNonTimeFilter(e.Model.Filters.Expr, chCommon.GetTimeColumn(e.DB))) {
+		score *= COST_PREWHERE_FACTOR
+	}
+	return &QueryCost{
+		Partitions: end/partitionSeconds - start/partitionSeconds + 1,
+		Score:      score,
+	}
+}
+
+// hasNonTimeFilter where中是否存在时间以外的过滤条件
+func hasNonTimeFilter(node view.Node, timeColumn string) bool {
+	switch n := node.(type) {
+	case nil:
+		return false
+	case *view.Nested:
+		return hasNonTimeFilter(n.Expr, timeColumn)
+	case *view.Not:
+		return hasNonTimeFilter(n.Expr, timeColumn)
+	case *view.UnaryExpr:
+		return hasNonTimeFilter(n.Expr, timeColumn)
+	case *view.BinaryExpr:
+		return hasNonTimeFilter(n.Left, timeColumn) || hasNonTimeFilter(n.Right, timeColumn)
+	case *view.Expr:
+		fields := strings.Fields(n.Value)
+		if len(fields) == 0 {
+			return false
+		}
+		column := strings.Trim(fields[0], "`")
+		return column != chCommon.DEFAULT_TIME_COLUMN && column != timeColumn
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"sync"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/querier/config"
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+)
+
+var (
+	datasourceCacheOnce sync.Once
+	datasourceCacheIns  *DatasourceCache
+)
+
+// DatasourceSource 读取数据源的粒度，单位：秒
+type DatasourceSource func(db, table, name, orgID string) (int, error)
+
+type datasourceEntry struct {
+	Interval int // 为0表示数据源不存在或读取失败
+	LoadTime time.Time
+}
+
+// DatasourceCache 缓存数据源的粒度，time()按粒度选择数据源时只读取缓存，解析sql时不访问controller；
+// 未缓存或已过期的数据源在后台读取，过期的值在读取完成前继续使用，未缓存的数据源在读取完成前不会被选择
+type DatasourceCache struct {
+	Source  DatasourceSource
+	TTL     time.Duration
+	Now     func() time.Time // 为空时使用time.Now
+	Entries map[string]*datasourceEntry
+	Loading map[string]bool
+	Version uint64 // 数据源粒度变化时递增，用于区分ModelCache中的编译结果
+	Lock    sync.Mutex
+}
+
+func NewDatasourceCache(source DatasourceSource, ttl time.Duration) *DatasourceCache {
+	return &DatasourceCache{
+		Source:  source,
+		TTL:     ttl,
+		Entries: map[string]*datasourceEntry{},
+		Loading: map[string]bool{},
+	}
+}
+
+// GetDatasourceCache datasource-cache-ttl为0时不开启缓存，返回nil
+func GetDatasourceCache() *DatasourceCache {
+	if config.Cfg == nil || config.Cfg.DatasourceCacheTTL <= 0 {
+		return nil
+	}
+	datasourceCacheOnce.Do(func() {
+		datasourceCacheIns = NewDatasourceCache(chCommon.GetDatasourceInterval, time.Duration(config.Cfg.DatasourceCacheTTL)*time.Second)
+	})
+	return datasourceCacheIns
+}
+
+func (c *DatasourceCache) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// Get 返回缓存的数据源粒度，未缓存或已过期时在后台重新读取，为nil时返回false
+func (c *DatasourceCache) Get(db, table, name, orgID string) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	key := orgID + "|" + db + "|" + table + "|" + name
+	now := c.now()
+	c.Lock.Lock()
+	entry, ok := c.Entries[key]
+	if (!ok || now.Sub(entry.LoadTime) >= c.TTL) && !c.Loading[key] {
+		c.Loading[key] = true
+		go c.Load(db, table, name, orgID)
+	}
+	c.Lock.Unlock()
+	if !ok || entry.Interval == 0 {
+		return 0, false
+	}
+	return entry.Interval, true
+}
+
+// Load 读取数据源的粒度并更新缓存
+func (c *DatasourceCache) Load(db, table, name, orgID string) {
+	key := orgID + "|" + db + "|" + table + "|" + name
+	interval, err := c.Source(db, table, name, orgID)
+	if err != nil {
+		log.Warningf("load datasource %s of %s.%s failed: %s", name, db, table, err)
+		interval = 0
+	}
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	delete(c.Loading, key)
+	if entry, ok := c.Entries[key]; !ok || entry.Interval != interval {
+		c.Version++
+	}
+	c.Entries[key] = &datasourceEntry{Interval: interval, LoadTime: c.now()}
+}
+
+// GetVersion 为nil时返回0
+func (c *DatasourceCache) GetVersion() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.Version
+}
@@ -40,9 +40,11 @@ import (
 )
 
 type Where struct {
-	filter *view.Filters
-	withs  []view.Node
-	time   *view.Time
+	filter   *view.Filters
+	withs    []view.Node
+	time     *view.Time
+	isHaving bool
+	negated  int // 所在NOT的层数，NOT中的time条件不能用于确定时间范围
 }
 
 func (w *Where) Format(m *view.Model) {
@@ -54,22 +56,86 @@ func (w *Where) Format(m *view.Model) {
 
 type Having struct {
 	Where
+	onTop bool // 引用窗口函数的别名，在窗口层作为WHERE
 }
 
 func (h *Having) Format(m *view.Model) {
 	h.filter.Withs = h.withs
-	if !h.filter.IsNull() {
+	if h.filter.IsNull() {
+		return
+	}
+	if h.onTop {
+		m.AddTopFilter(h.filter)
+	} else {
 		m.AddHaving(h.filter)
 	}
 }
 
-func GetWhere(name, value string) WhereStatement {
-	switch name {
-	case "time":
+func GetWhere(name, value, db string) WhereStatement {
+	// time已按metrics替换为数据库配置的时间列
+	if name == chCommon.DEFAULT_TIME_COLUMN || name == chCommon.GetTimeColumn(db) {
 		return &TimeTag{Value: value}
-	default:
-		return &WhereTag{Tag: name, Value: value}
 	}
+	return &WhereTag{Tag: name, Value: value}
+}
+
+// GetTagNullFilter db_descriptions中标注了NullValue的tag，IS NULL改写为原始列等于未知值，
+// 资源tag在翻译前判断，如pod_0判断pod_id_0
+func GetTagNullFilter(name string, not bool, w *Where, e *CHEngine) (view.Node, bool, error) {
+	name = strings.Trim(name, "`")
+	column := name
+	// network.1m等按数据精度区分的表使用network的描述
+	table := strings.Split(e.Table, ".")[0]
+	description := e.getTagDescription(table, name)
+	if description != nil && description.Type == "resource" {
+		column = resourceIDTag(name)
+	} else if description == nil {
+		// pod_id_0等id tag使用对应资源的标注
+		description = e.getTagDescription(table, resourceNameTag(name))
+		if description != nil && description.Type != "resource" {
+			description = nil
+		}
+	}
+	if description == nil || description.NullValue == "" {
+		return nil, false, nil
+	}
+	var value *sqlparser.SQLVal
+	if description.NullValue == "''" {
+		value = sqlparser.NewStrVal([]byte{})
+	} else {
+		value = sqlparser.NewIntVal([]byte(description.NullValue))
+	}
+	op := "="
+	if not {
+		op = "!="
+	}
+	stmt := GetWhere(column, description.NullValue, e.DB)
+	filter, err := stmt.Trans(&sqlparser.ComparisonExpr{
+		Left:     &sqlparser.ColName{Name: sqlparser.NewColIdent(column)},
+		Operator: op,
+		Right:    value,
+	}, w, e)
+	return filter, true, err
+}
+
+// resourceIDTag pod_0 -> pod_id_0
+func resourceIDTag(name string) string {
+	for _, suffix := range []string{"_0", "_1"} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix) + "_id" + suffix
+		}
+	}
+	return name + "_id"
+}
+
+// resourceNameTag pod_id_0 -> pod_0
+func resourceNameTag(name string) string {
+	for _, suffix := range []string{"_0", "_1", ""} {
+		if strings.HasSuffix(name, "_id"+suffix) {
+			return strings.TrimSuffix(name, "_id"+suffix) + suffix
+		}
+	}
+	return name
 }
 
 func TransWhereTagFunction(db, table string, name string, args []string) (filter string) {
@@ -307,6 +373,22 @@ func TransIngressFilter(translator, regexpTranslator, op, value string) (filter
 	return
 }
 
+// like与ilike都按ilike翻译，*为通配符
+func isLikeOperator(op string) bool {
+	switch strings.ToLower(op) {
+	case "like", "not like", "ilike", "not ilike":
+		return true
+	}
+	return false
+}
+
+func toILikeOperator(op string) string {
+	if strings.HasPrefix(strings.ToLower(op), "not") {
+		return "not ilike"
+	}
+	return "ilike"
+}
+
 // trace_id
 func TransTraceIDFilter(op, value, table string) (filter string) {
 	opLower := strings.ToLower(op)
@@ -330,7 +412,7 @@ func TransTraceIDFilter(op, value, table string) (filter string) {
 		return
 	}
 	switch opLower {
-	case "!=", "not in", "not like":
+	case "!=", "not in", "not like", "not ilike":
 		filter = fmt.Sprintf("trace_id %s %s AND (%s %s %s OR %s = '')", op, value, chCommon.TRACE_ID_2_TAG, op, value, chCommon.TRACE_ID_2_TAG)
 	case "match":
 		filter = fmt.Sprintf("match(trace_id,%s) OR match(%s,%s)", value, chCommon.TRACE_ID_2_TAG, value)
@@ -517,13 +599,9 @@ func (t *WhereTag) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.Node,
 	var err error
 
 	whereTag := t.Tag
-	if strings.ToLower(op) == "like" || strings.ToLower(op) == "not like" {
+	if isLikeOperator(op) {
 		t.Value = strings.ReplaceAll(t.Value, "*", "%")
-		if strings.ToLower(op) == "like" {
-			op = "ilike"
-		} else {
-			op = "not ilike"
-		}
+		op = toILikeOperator(op)
 	} else if strings.ToLower(op) == "regexp" || strings.ToLower(op) == "not regexp" {
 		// check regexp format
 		// 检查正则表达式格式
@@ -537,6 +615,10 @@ func (t *WhereTag) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.Node,
 		} else {
 			op = "not match"
 		}
+	} else if isArrayOperator(op) {
+		return t.transArrayFilter(op, e)
+	} else if subquery, ok := expr.(*sqlparser.ComparisonExpr).Right.(*sqlparser.Subquery); ok && isInOperator(op) {
+		return t.transSubqueryFilter(op, subquery, e), nil
 	}
 	if db == "flow_tag" {
 		if t.Tag == "vpc" || t.Tag == "vpc_id" {
@@ -1207,6 +1289,59 @@ func (t *WhereTag) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.Node,
 
 }
 
+// 数组tag的过滤操作符及对应的ClickHouse函数
+var arrayOperatorFunctions = map[string]string{
+	"hasany": "hasAny",
+	"hasall": "hasAll",
+}
+
+func isArrayOperator(op string) bool {
+	_, ok := arrayOperatorFunctions[strings.TrimPrefix(strings.ToLower(op), "not ")]
+	return ok
+}
+
+// transArrayFilter tags hasAny ('a','b')翻译为hasAny(tags, ['a','b'])，tags为翻译后的数组列
+func (t *WhereTag) transArrayFilter(op string, e *CHEngine) (view.Node, error) {
+	column := t.Tag
+	if preAsTag, ok := e.AsTagMap[t.Tag]; ok {
+		column = preAsTag
+	}
+	if tagItem, ok := tag.GetTag(strings.Trim(column, "`"), e.DB, e.Table, "default"); ok && tagItem.TagTranslator != "" {
+		column = tagItem.TagTranslator
+	}
+	if !strings.HasPrefix(t.Value, "(") || !strings.HasSuffix(t.Value, ")") {
+		return nil, fmt.Errorf("the value of %s should be a list such as ('a','b'): %s", op, t.Value)
+	}
+	opLower := strings.ToLower(op)
+	function := arrayOperatorFunctions[strings.TrimPrefix(opLower, "not ")]
+	filter := fmt.Sprintf("%s(%s, [%s])", function, column, t.Value[1:len(t.Value)-1])
+	if strings.HasPrefix(opLower, "not ") {
+		filter = "not(" + filter + ")"
+	}
+	return &view.Expr{Value: "(" + filter + ")"}, nil
+}
+
+func isInOperator(op string) bool {
+	op = strings.ToLower(op)
+	return op == sqlparser.InStr || op == sqlparser.NotInStr
+}
+
+// transSubqueryFilter tag in (select ...)中的子查询原样输出，查询Distributed表时由View输出为GLOBAL IN
+func (t *WhereTag) transSubqueryFilter(op string, subquery *sqlparser.Subquery, e *CHEngine) view.Node {
+	column := t.Tag
+	if preAsTag, ok := e.AsTagMap[t.Tag]; ok {
+		column = preAsTag
+	}
+	if tagItem, ok := tag.GetTag(strings.Trim(column, "`"), e.DB, e.Table, "default"); ok && tagItem.TagTranslator != "" {
+		column = tagItem.TagTranslator
+	}
+	return &view.InSubquery{
+		Expr:     &view.Expr{Value: column},
+		Not:      strings.ToLower(op) == sqlparser.NotInStr,
+		Subquery: sqlparser.String(subquery.Select),
+	}
+}
+
 func TransCustomBizFilter(idFilter, orgID, id string) (string, error) {
 	filter := "1!=1"
 	col := "server_filter"
@@ -1377,20 +1512,27 @@ type TimeTag struct {
 	Value string
 }
 
+var nowRegexp = regexp.MustCompile(`(?i)\bnow\(\)`)
+
 func (t *TimeTag) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.Node, error) {
 	compareExpr := expr.(*sqlparser.ComparisonExpr)
-	time, err := strconv.ParseInt(t.Value, 10, 64)
-	if err == nil {
-	} else {
-		timeExpr, err := govaluate.NewEvaluableExpression(t.Value)
-		if err != nil {
-			return nil, err
-		}
-		timeValue, err := timeExpr.Evaluate(nil)
-		if err != nil {
-			return nil, err
-		}
-		time = int64(timeValue.(float64))
+	time, resolved, err := e.parseTimeValue(t.Value)
+	if err != nil {
+		return nil, err
+	}
+	if resolved {
+		// now()及时间字符串替换为解析后的时间戳，保证查询内使用同一时刻
+		compareExpr.Right = sqlparser.NewIntVal([]byte(strconv.FormatInt(time, 10)))
+	}
+	if column := chCommon.GetTimeColumn(e.DB); column != chCommon.DEFAULT_TIME_COLUMN {
+		compareExpr.Left = &sqlparser.ColName{Name: sqlparser.NewColIdent(column)}
+	}
+	if w.negated > 0 {
+		return &view.Expr{Value: sqlparser.String(compareExpr)}, nil
+	}
+	// 没有time()聚合时不需要对齐
+	if (e.AlignTimeRange || w.time.Align) && w.time.Interval > 0 && w.time.DatasourceInterval > 1 {
+		time = alignTimeValue(compareExpr, time, w.time)
 	}
 	newTime := time
 	if compareExpr.Operator == ">=" || compareExpr.Operator == ">" {
@@ -1411,6 +1553,65 @@ func (t *TimeTag) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.Node,
 	return &view.Expr{Value: newValue}, nil
 }
 
+// alignTimeValue 开始时间向下、结束时间向上对齐到DatasourceInterval，使首尾的时间桶完整，并记录对齐前的时间范围
+func alignTimeValue(compareExpr *sqlparser.ComparisonExpr, value int64, t *view.Time) int64 {
+	interval := int64(t.DatasourceInterval)
+	var aligned int64
+	switch compareExpr.Operator {
+	case ">=":
+		t.AddRequestedTimeStart(value)
+		aligned = value / interval * interval
+	case ">":
+		t.AddRequestedTimeStart(value)
+		aligned = (value + 1) / interval * interval
+		compareExpr.Operator = ">="
+	case "<=":
+		t.AddRequestedTimeEnd(value)
+		aligned = value/interval*interval + interval - 1
+	case "<":
+		t.AddRequestedTimeEnd(value)
+		aligned = (value + interval - 1) / interval * interval
+	default:
+		return value
+	}
+	compareExpr.Right = sqlparser.NewIntVal([]byte(strconv.FormatInt(aligned, 10)))
+	return aligned
+}
+
+// parseTimeValue 将time的过滤值解析为unix秒，支持时间戳、算术表达式、now()相对时间(如now() - 300)及RFC3339时间字符串，
+// 取值为now()或时间字符串时resolved为true
+func (e *CHEngine) parseTimeValue(value string) (int64, bool, error) {
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return timestamp, false, nil
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		timeValue, err := time.Parse(time.RFC3339, strings.Trim(value, "'"))
+		if err != nil {
+			return 0, false, fmt.Errorf("time: %s is not a RFC3339 time", value)
+		}
+		return timeValue.Unix(), true, nil
+	}
+	resolved := false
+	if nowRegexp.MatchString(value) {
+		resolved = true
+		value = nowRegexp.ReplaceAllString(value, strconv.FormatInt(e.now().Unix(), 10))
+	}
+	timeExpr, err := govaluate.NewEvaluableExpression(value)
+	if err != nil {
+		return 0, false, err
+	}
+	timeValue, err := timeExpr.Evaluate(nil)
+	if err != nil {
+		return 0, false, err
+	}
+	timeFloat, ok := timeValue.(float64)
+	if !ok {
+		return 0, false, fmt.Errorf("time: %s is not a number", value)
+	}
+	return int64(timeFloat), resolved, nil
+}
+
 type WhereFunction struct {
 	Function view.Node
 	Value    string
@@ -1446,13 +1647,9 @@ func (f *WhereFunction) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.
 			nameColumn = "name_" + cfgLang
 		}
 		if db == "flow_tag" {
-			if strings.ToLower(opName) == "like" || strings.ToLower(opName) == "not like" {
+			if isLikeOperator(opName) {
 				f.Value = strings.ReplaceAll(f.Value, "*", "%")
-				if strings.ToLower(opName) == "like" {
-					opName = "ilike"
-				} else {
-					opName = "not ilike"
-				}
+				opName = toILikeOperator(opName)
 			} else if strings.ToLower(opName) == "regexp" || strings.ToLower(opName) == "not regexp" {
 				// check regexp format
 				// 检查正则表达式格式
@@ -1524,13 +1721,9 @@ func (f *WhereFunction) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.
 			right = view.Expr{Value: f.Value}
 		} else {
 			whereFilter := tagItem.WhereTranslator
-			if strings.ToLower(opName) == "like" || strings.ToLower(opName) == "not like" {
+			if isLikeOperator(opName) {
 				f.Value = strings.ReplaceAll(f.Value, "*", "%")
-				if strings.ToLower(opName) == "like" {
-					opName = "ilike"
-				} else {
-					opName = "not ilike"
-				}
+				opName = toILikeOperator(opName)
 			} else if strings.ToLower(opName) == "regexp" || strings.ToLower(opName) == "not regexp" {
 				// check regexp format
 				// 检查正则表达式格式
@@ -1620,6 +1813,26 @@ func (f *WhereFunction) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.
 			}
 			return &view.Expr{Value: "(" + whereFilter + ")"}, nil
 		}
+	} else if strings.HasPrefix(function, "Raw(") {
+		// Raw直接过滤原始值，不查询枚举字典
+		tagName := strings.TrimPrefix(function, "Raw(")
+		tagName = strings.TrimSuffix(tagName, ")")
+		rawValue, isExpr := GetRawExpr(tagName)
+		if !isExpr {
+			rawValue = GetRawTagValue(tagName, db, table)
+		} else if !e.AllowRawExpr {
+			return nil, errors.New(fmt.Sprintf("function Raw with expression %s is disabled", tagName))
+		}
+		var whereFilter string
+		switch strings.ToLower(opName) {
+		case "regexp":
+			whereFilter = fmt.Sprintf("match(%s,%s)", rawValue, f.Value)
+		case "not regexp":
+			whereFilter = fmt.Sprintf("not(match(%s,%s))", rawValue, f.Value)
+		default:
+			whereFilter = fmt.Sprintf("%s %s %s", rawValue, opName, f.Value)
+		}
+		return &view.Expr{Value: "(" + whereFilter + ")"}, nil
 	} else if function == "FastFilter(trace_id)" {
 		traceConfig := config.TraceConfig
 		TypeIsIncrementalId := traceConfig.Type == chCommon.INDEX_TYPE_INCREMETAL_ID
@@ -1687,3 +1900,81 @@ func (f *WhereFunction) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.
 	w.withs = append(w.withs, f.Function.GetWiths()...)
 	return &view.BinaryExpr{Left: f.Function, Right: &right, Op: op}, nil
 }
+
+// CIDR_MATCH_OPERATOR 网段匹配，如ip_0 << '10.0.0.0/8'
+const CIDR_MATCH_OPERATOR = sqlparser.ShiftLeftStr
+
+// 支持网段匹配的ip tag，由is_ipv4、ip4、ip6三列组成
+var CIDR_MATCH_TAGS = []string{"ip", "ip_0", "ip_1"}
+
+// TransCIDRMatchFilter 按网段的地址族展开为ip4或ip6列的范围比较，v4网段不匹配v6的数据，反之亦然
+func TransCIDRMatchFilter(tagName, value string) (string, error) {
+	if !slices.Contains(CIDR_MATCH_TAGS, tagName) {
+		return "", fmt.Errorf("operator %s only supports tags %s, got %s", CIDR_MATCH_OPERATOR, strings.Join(CIDR_MATCH_TAGS, ", "), tagName)
+	}
+	cidr, err := netaddr.ParseIPPrefix(strings.Trim(value, "'"))
+	if err != nil {
+		log.Error(err)
+		return "", fmt.Errorf("invalid cidr %s", value)
+	}
+	suffix := strings.TrimPrefix(tagName, "ip")
+	ipRange := cidr.Masked().Range()
+	if cidr.IP().Is4() {
+		return fmt.Sprintf("(is_ipv4 = 1 AND ip4%s BETWEEN toIPv4('%s') AND toIPv4('%s'))", suffix, ipRange.From(), ipRange.To()), nil
+	}
+	return fmt.Sprintf("(is_ipv4 = 0 AND ip6%s BETWEEN toIPv6('%s') AND toIPv6('%s'))", suffix, ipRange.From(), ipRange.To()), nil
+}
+
+// 常量，数值或单引号字符串
+const preWhereLiteral = `(?:-?\d+(?:\.\d+)?|'(?:[^'\\]|\\.)*')`
+
+// 单个列与常量的比较或IN常量列表
+var preWhereFilterRegexp = regexp.MustCompile("^`?(\\w+)`?\\s*(?:(?:=|!=|<>|>=|<=|>|<)\\s*" + preWhereLiteral +
+	`|(?i:not\s+)?(?i:in)\s*\(\s*` + preWhereLiteral + `(?:\s*,\s*` + preWhereLiteral + `)*\s*\))$`)
+
+// splitPreWhere 将where顶层AND中简单的列比较移入PREWHERE，先读取这些列过滤掉大部分行；
+// 时间过滤已由主键索引裁剪，函数、子查询等复杂条件在PREWHERE中反而更慢，保留在WHERE
+func (e *CHEngine) splitPreWhere() {
+	if !e.PreWhere || e.NoPreWhere || e.Model.Filters.IsNull() || !e.Model.PreFilters.IsNull() {
+		return
+	}
+	timeColumn := chCommon.GetTimeColumn(e.DB)
+	var where view.Node
+	for _, node := range splitAndFilters(e.Model.Filters.Expr) {
+		if isPreWhereFilter(node, timeColumn) {
+			e.Model.PreFilters.Append(&view.Filters{Expr: node})
+		} else if where == nil {
+			where = node
+		} else {
+			where = &view.BinaryExpr{Left: where, Right: node, Op: &view.Operator{Type: view.AND}}
+		}
+	}
+	e.Model.Filters.Expr = where
+}
+
+func splitAndFilters(node view.Node) []view.Node {
+	if binary, ok := node.(*view.BinaryExpr); ok && binary.Op.Type == view.AND {
+		return append(splitAndFilters(binary.Left), splitAndFilters(binary.Right)...)
+	}
+	return []view.Node{node}
+}
+
+func isPreWhereFilter(node view.Node, timeColumn string) bool {
+	for {
+		nested, ok := node.(*view.Nested)
+		if !ok {
+			break
+		}
+		node = nested.Expr
+	}
+	expr, ok := node.(*view.Expr)
+	if !ok {
+		return false
+	}
+	value := strings.TrimSpace(expr.Value)
+	for strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+	match := preWhereFilterRegexp.FindStringSubmatch(value)
+	return match != nil && match[1] != chCommon.DEFAULT_TIME_COLUMN && match[1] != timeColumn
+}
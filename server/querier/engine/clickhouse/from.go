@@ -28,10 +28,23 @@ import (
 
 type Table struct {
 	Value string
+	Alias string
+	Final bool
+	// 表函数中表名之前及之后的部分，如cluster('df', 及)
+	FunctionPrefix string
+	FunctionSuffix string
 }
 
 func (t *Table) Format(m *view.Model) {
-	m.AddTable(t.Value)
+	if t.FunctionPrefix != "" {
+		m.AddTableFunction(t.FunctionPrefix+t.Value+t.FunctionSuffix, t.Alias)
+	} else if t.Alias != "" {
+		m.AddTableWithAlias(t.Value, t.Alias, t.Final)
+	} else if t.Final {
+		m.AddTableFinal(t.Value)
+	} else {
+		m.AddTable(t.Value)
+	}
 }
 
 func GetVirtualTableFilter(db, table string) (view.Node, bool) {
@@ -47,18 +47,41 @@ const (
 	TAG_FUNCTION_TOPK                       = "topK"
 	TAG_FUNCTION_NEW_TAG                    = "newTag"
 	TAG_FUNCTION_ENUM                       = "enum"
+	TAG_FUNCTION_RAW                        = "Raw"
 	TAG_FUNCTION_FAST_FILTER                = "FastFilter"
 	TAG_FUNCTION_FAST_TRANS                 = "FastTrans"
 	TAG_FUNCTION_COUNT_DISTINCT             = "countDistinct"
+	TAG_FUNCTION_BUCKET                     = "Bucket"
 )
 
 const INTERVAL_1D = 86400
 
+// time()的时间单位，如time(time, 1, 'day')
+const (
+	TIME_UNIT_SECOND = "second"
+	TIME_UNIT_MINUTE = "minute"
+	TIME_UNIT_HOUR   = "hour"
+	TIME_UNIT_DAY    = "day"
+	TIME_UNIT_WEEK   = "week"
+	TIME_UNIT_MONTH  = "month"
+)
+
+// 时间单位对应的秒数，月按30天计算，只用于选择数据源等需要秒级interval的场景
+var TIME_UNIT_SECONDS = map[string]int{
+	TIME_UNIT_SECOND: 1,
+	TIME_UNIT_MINUTE: 60,
+	TIME_UNIT_HOUR:   3600,
+	TIME_UNIT_DAY:    INTERVAL_1D,
+	TIME_UNIT_WEEK:   7 * INTERVAL_1D,
+	TIME_UNIT_MONTH:  30 * INTERVAL_1D,
+}
+
 var TAG_FUNCTIONS = []string{
 	TAG_FUNCTION_NODE_TYPE, TAG_FUNCTION_ICON_ID, TAG_FUNCTION_MASK, TAG_FUNCTION_TIME,
 	TAG_FUNCTION_TO_UNIX_TIMESTAMP_64_MICRO, TAG_FUNCTION_TO_STRING, TAG_FUNCTION_IF,
 	TAG_FUNCTION_UNIQ, TAG_FUNCTION_ANY, TAG_FUNCTION_TOPK, TAG_FUNCTION_TO_UNIX_TIMESTAMP,
-	TAG_FUNCTION_NEW_TAG, TAG_FUNCTION_ENUM, TAG_FUNCTION_FAST_FILTER, TAG_FUNCTION_FAST_TRANS, TAG_FUNCTION_COUNT_DISTINCT,
+	TAG_FUNCTION_NEW_TAG, TAG_FUNCTION_ENUM, TAG_FUNCTION_RAW, TAG_FUNCTION_FAST_FILTER, TAG_FUNCTION_FAST_TRANS, TAG_FUNCTION_COUNT_DISTINCT,
+	TAG_FUNCTION_BUCKET,
 }
 
 type Function interface {
@@ -92,6 +115,8 @@ func GetAggFunc(name string, args []string, alias string, derivativeArgs []strin
 		return GetTopKTrans(name, args, alias, e)
 	} else if name == view.FUNCTION_UNIQ || name == view.FUNCTION_UNIQ_EXACT {
 		return GetUniqTrans(name, args, alias, e)
+	} else if isArgMaxFunction(name) {
+		return GetArgMaxTrans(name, args, alias, e)
 	}
 
 	var levelFlag int
@@ -102,6 +127,14 @@ func GetAggFunc(name string, args []string, alias string, derivativeArgs []strin
 		return nil, 0, "", fmt.Errorf("function [%s] not support metric [%s]",
 			view.FUNCTION_COUNT, metrics.COUNT_METRICS_NAME)
 	}
+	// Last/First只支持可选参数'nonzero'
+	if isLastFunction(name) && (len(args) > 2 || (len(args) == 2 && args[1] != view.LAST_NONZERO_FLAG)) {
+		return nil, 0, "", fmt.Errorf("function [%s] only supports optional argument %s", name, view.LAST_NONZERO_FLAG)
+	}
+	// Avg只支持可选参数include_zero=true/false
+	if name == view.FUNCTION_AVG && (len(args) > 2 || (len(args) == 2 && args[1] != view.AVG_INCLUDE_ZERO_FLAG && args[1] != view.AVG_EXCLUDE_ZERO_FLAG)) {
+		return nil, 0, "", fmt.Errorf("function [%s] only supports optional argument include_zero=true or include_zero=false", name)
+	}
 
 	function, ok := metrics.METRICS_FUNCTIONS_MAP[name]
 	if !ok {
@@ -114,6 +147,10 @@ func GetAggFunc(name string, args []string, alias string, derivativeArgs []strin
 	if metricStruct.Type == metrics.METRICS_TYPE_ARRAY {
 		return nil, 0, "", nil
 	}
+	// 预聚合表中有定义时，Avg按sum(分子)/sum(分母)加权平均，Max使用存储最大值的列
+	if preAggregation, ok := metrics.GetPreAggregation(e.DB, e.Table, field); ok {
+		metricStruct = preAggregation.Trans(name, metricStruct)
+	}
 	unit := strings.ReplaceAll(function.UnitOverwrite, "$unit", metricStruct.Unit)
 	// 判断算子是否支持单层
 	if db != chCommon.DB_NAME_FLOW_LOG {
@@ -237,6 +274,21 @@ func processEnumField(field, db, table string, e *CHEngine) string {
 	return transField
 }
 
+// tagNotEmptyCondition TopK/Any/ArgMax忽略tag的空值
+func tagNotEmptyCondition(metricStruct *metrics.Metrics, dbField string) string {
+	if metricStruct.TagType == "string" || metricStruct.TagType == "ip" {
+		return dbField + " != ''"
+	} else if metricStruct.TagType == "id" {
+		return dbField + " != 0"
+	} else if metricStruct.TagType == "resource" && strings.Contains(metricStruct.DisplayName, "_id") {
+		if strings.Contains(metricStruct.DisplayName, "epc") {
+			return dbField + " != -2"
+		}
+		return dbField + " != 0"
+	}
+	return dbField + " != ''"
+}
+
 func GetTopKTrans(name string, args []string, alias string, e *CHEngine) (Statement, int, string, error) {
 	db := e.DB
 	table := e.Table
@@ -290,20 +342,7 @@ func GetTopKTrans(name string, args []string, alias string, e *CHEngine) (Statem
 		}
 
 		if condition == "" && metricStruct.TagType != "int" && metricStruct.TagType != "int_enum" {
-			if metricStruct.TagType == "string" || metricStruct.TagType == "ip" {
-				condition = dbFields[i] + " != ''"
-			} else if metricStruct.TagType == "id" {
-				condition = dbFields[i] + " != 0"
-			} else if metricStruct.TagType == "resource" && strings.Contains(metricStruct.DisplayName, "_id") {
-				if strings.Contains(metricStruct.DisplayName, "epc") {
-					condition = dbFields[i] + " != -2"
-				} else {
-					condition = dbFields[i] + " != 0"
-				}
-			} else {
-				condition = dbFields[i] + " != ''"
-			}
-			conditions = append(conditions, condition)
+			conditions = append(conditions, tagNotEmptyCondition(metricStruct, dbFields[i]))
 		}
 
 		// 判断算子是否支持单层
@@ -336,6 +375,73 @@ func GetTopKTrans(name string, args []string, alias string, e *CHEngine) (Statem
 	}, levelFlag, unit, nil
 }
 
+// GetArgMaxTrans ArgMax(dim, metric)/ArgMin(dim, metric)，dim与TopK一样翻译为tag的取值，metric取原始行的值
+func GetArgMaxTrans(name string, args []string, alias string, e *CHEngine) (Statement, int, string, error) {
+	function, ok := metrics.METRICS_FUNCTIONS_MAP[name]
+	if !ok {
+		return nil, 0, "", nil
+	}
+	if len(args) != 2 {
+		return nil, 0, "", fmt.Errorf("function [%s] requires 2 arguments (dim, metric)", name)
+	}
+
+	dim := strings.Trim(args[0], "`")
+	isEnum := false
+	if strings.HasPrefix(dim, "enum(") && strings.HasSuffix(dim, ")") && len(dim) > 6 {
+		dim = dim[5 : len(dim)-1]
+		isEnum = true
+	}
+	dimStruct, ok := metrics.GetAggMetrics(dim, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics)
+	if !ok || dimStruct.Type == metrics.METRICS_TYPE_ARRAY {
+		return nil, 0, "", nil
+	}
+	dimField := dimStruct.DBField
+	_, _, withs := TransMultiTag(false, dim, nil, nil)
+	if isEnum {
+		dimField = processEnumField(dim, e.DB, e.Table, e)
+	}
+
+	metric := strings.Trim(args[1], "`")
+	metricStruct, ok := metrics.GetAggMetrics(metric, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics)
+	if !ok {
+		return nil, 0, "", nil
+	}
+	if !slices.Contains(metrics.METRICS_FUNCTIONS_MAP[view.FUNCTION_MAX].SupportMetricsTypes, metricStruct.Type) {
+		return nil, 0, "", fmt.Errorf("function [%s] not support metric [%s]", name, metric)
+	}
+
+	conditions := []string{}
+	if dimStruct.Condition != "" {
+		conditions = append(conditions, dimStruct.Condition)
+	} else if dimStruct.Type == metrics.METRICS_TYPE_TAG && dimStruct.TagType != "int" && dimStruct.TagType != "int_enum" {
+		conditions = append(conditions, tagNotEmptyCondition(dimStruct, dimField))
+	}
+	if metricStruct.Condition != "" {
+		conditions = append(conditions, metricStruct.Condition)
+	}
+	// 时延类与其他算子一致，忽略0值
+	if metricStruct.Type == metrics.METRICS_TYPE_DELAY {
+		conditions = append(conditions, metricStruct.DBField+" > 0")
+	}
+
+	dimStructCopy := *dimStruct
+	dimStructCopy.DBField = dimField
+	dimStructCopy.Condition = strings.Join(conditions, " AND ")
+	metricStructCopy := *metricStruct
+
+	unit := strings.ReplaceAll(function.UnitOverwrite, "$unit", dimStruct.Unit)
+
+	// 按原始行取值，单层与分层结果一致，无需强制分层
+	return &AggFunction{
+		Metrics:    &dimStructCopy,
+		ArgMetrics: &metricStructCopy,
+		Name:       name,
+		Args:       args,
+		Alias:      alias,
+		Withs:      withs,
+	}, view.MODEL_METRICS_LEVEL_FLAG_UNLAY, unit, nil
+}
+
 func GetUniqTrans(name string, args []string, alias string, e *CHEngine) (Statement, int, string, error) {
 	db := e.DB
 	fields := args
@@ -437,6 +543,31 @@ func (f *BinaryFunction) Trans(m *view.Model) view.Node {
 		histogram.SetFlag(view.METRICS_FLAG_TOP)
 		histogram.Init()
 		return histogram
+	} else if f.Name == view.FUNCTION_ZSCORE || f.Name == view.FUNCTION_PERCENT_OF_TOTAL {
+		// 计算层输出参与计算的值，窗口层计算标准分或占比
+		windowInnerName := fields[0].(view.Function).GetDefaultAlias(true)
+		windowInnerName = fmt.Sprintf("`%s`", strings.Trim(windowInnerName, "`"))
+		fields[0].(view.Function).SetAlias(windowInnerName, true)
+		fields[0].(view.Function).SetFlag(view.METRICS_FLAG_OUTER)
+		m.AddTag(fields[0])
+		window := view.GetFunc(f.Name)
+		window.SetFields([]view.Node{&view.Field{Value: windowInnerName}})
+		window.SetFlag(view.METRICS_FLAG_TOP)
+		window.Init()
+		return window
+	} else if f.Name == view.FUNCTION_MOVING_AVG {
+		// 计算层输出每个时间桶的值，窗口层按时间滑动求平均
+		movingAvgInnerName := fields[0].(view.Function).GetDefaultAlias(true)
+		movingAvgInnerName = fmt.Sprintf("`%s`", strings.Trim(movingAvgInnerName, "`"))
+		fields[0].(view.Function).SetAlias(movingAvgInnerName, true)
+		fields[0].(view.Function).SetFlag(view.METRICS_FLAG_OUTER)
+		m.AddTag(fields[0])
+		movingAvg := view.GetFunc(f.Name)
+		movingAvg.SetFields([]view.Node{&view.Field{Value: movingAvgInnerName}})
+		movingAvg.SetArgs([]string{fields[1].ToString()}) // window size
+		movingAvg.SetFlag(view.METRICS_FLAG_TOP)
+		movingAvg.Init()
+		return movingAvg
 	} else if f.Name == view.FUNCTION_PCTL || f.Name == view.FUNCTION_PCTL_EXACT {
 		function := view.GetFunc(f.Name)
 		function.SetFields(fields[:1])                   // metrics
@@ -448,6 +579,12 @@ func (f *BinaryFunction) Trans(m *view.Model) view.Node {
 	}
 	function := view.GetFunc(f.Name)
 	function.SetFields(fields)
+	// 用户除法的除数非常量时，除数为0的结果为NULL
+	if divFunction, ok := function.(*view.DivFunction); ok && !m.NoDivZeroGuard {
+		if _, ok := fields[1].(view.Function); ok {
+			divFunction.DivType = view.FUNCTION_DIV_TYPE_0DIVIDER_AS_NULL
+		}
+	}
 	function.SetFlag(view.METRICS_FLAG_OUTER)
 	function.SetTime(m.Time)
 	function.Init()
@@ -479,12 +616,79 @@ type AggFunction struct {
 	DerivativeArgs    []string
 	DerivativeGroupBy []string
 	Withs             []view.Node
+	// ArgMax/ArgMin取最大/最小值的指标，Metrics为返回的维度
+	ArgMetrics *metrics.Metrics
+	// 算子结果为null时补的值，例：Sum(byte) fillnull(0)
+	FillNull string
 }
 
 func (f *AggFunction) SetAlias(alias string) {
 	f.Alias = alias
 }
 
+func isLastFunction(name string) bool {
+	return name == view.FUNCTION_LAST || name == view.FUNCTION_FIRST
+}
+
+func isArgMaxFunction(name string) bool {
+	return name == view.FUNCTION_ARGMAX || name == view.FUNCTION_ARGMIN
+}
+
+// transArgMax 单层直接argMax(dim, metric)
+// 分层时里层取每组指标最大/最小的行对应的维度值及该指标值，外层再按里层的指标值取维度值
+func (f *AggFunction) transArgMax(m *view.Model) view.Node {
+	outFunc := &view.ArgMaxFunction{DefaultFunction: view.DefaultFunction{Name: f.Name}}
+	if m.MetricsLevelFlag == view.MODEL_METRICS_LEVEL_FLAG_LAYERED {
+		innerAlias := fmt.Sprintf("_%s_%s_%s", strings.ToLower(f.Name), strings.Trim(f.Args[0], "`"), strings.Trim(f.Args[1], "`"))
+		innerFunction := &view.ArgMaxFunction{DefaultFunction: view.DefaultFunction{
+			Name:      f.Name,
+			Fields:    []view.Node{&view.Field{Value: f.Metrics.DBField}},
+			Args:      []string{f.ArgMetrics.DBField},
+			Condition: f.Metrics.Condition,
+			Withs:     f.Withs,
+		}}
+		innerAlias = innerFunction.SetAlias(innerAlias, true)
+		innerFunction.SetFlag(view.METRICS_FLAG_INNER)
+		innerFunction.Init()
+		m.AddTag(innerFunction)
+
+		metricFunction := view.DefaultFunction{
+			Name:      view.FUNCTION_MAX,
+			Fields:    []view.Node{&view.Field{Value: f.ArgMetrics.DBField}},
+			Condition: f.Metrics.Condition,
+		}
+		if f.Name == view.FUNCTION_ARGMIN {
+			metricFunction.Name = view.FUNCTION_MIN
+		}
+		metricAlias := metricFunction.SetAlias(strings.Trim(innerAlias, "`")+"_metric", true)
+		metricFunction.SetFlag(view.METRICS_FLAG_INNER)
+		metricFunction.Init()
+		m.AddTag(&metricFunction)
+
+		outFunc.SetFields([]view.Node{&view.Field{Value: innerAlias}})
+		outFunc.SetArgs([]string{metricAlias})
+	} else {
+		outFunc.SetFields([]view.Node{&view.Field{Value: f.Metrics.DBField}})
+		outFunc.SetArgs([]string{f.ArgMetrics.DBField})
+		outFunc.SetCondition(f.Metrics.Condition)
+		outFunc.Withs = f.Withs
+	}
+	outFunc.SetFlag(view.METRICS_FLAG_OUTER)
+	outFunc.SetTime(m.Time)
+	outFunc.Init()
+	return outFunc
+}
+
+// isNonZero Last(x, 'nonzero')及First(x, 'nonzero')忽略0值
+func (f *AggFunction) isNonZero() bool {
+	return len(f.Args) > 1 && f.Args[1] == view.LAST_NONZERO_FLAG
+}
+
+// isIncludeZero Avg(x, include_zero=true)不忽略0值
+func (f *AggFunction) isIncludeZero() bool {
+	return f.Name == view.FUNCTION_AVG && len(f.Args) > 1 && f.Args[1] == view.AVG_INCLUDE_ZERO_FLAG
+}
+
 func (f *AggFunction) FormatInnerTag(m *view.Model) (innerAlias string) {
 	switch f.Metrics.Type {
 	case metrics.METRICS_TYPE_COUNTER, metrics.METRICS_TYPE_GAUGE:
@@ -515,6 +719,18 @@ func (f *AggFunction) FormatInnerTag(m *view.Model) (innerAlias string) {
 		m.AddTag(&innerFunction)
 		return innerAlias
 	case metrics.METRICS_TYPE_DELAY, metrics.METRICS_TYPE_BOUNDED_GAUGE:
+		// Last/First内层按time取值，外层再按内层的_time取值
+		if isLastFunction(f.Name) {
+			innerFunction := view.GetFunc(f.Name)
+			innerFunction.SetFields([]view.Node{&view.Field{Value: f.Metrics.DBField}})
+			setArgTimeField(innerFunction, m)
+			innerFunction.SetIgnoreZero(f.isNonZero())
+			innerAlias = innerFunction.SetAlias("", true)
+			innerFunction.SetFlag(view.METRICS_FLAG_INNER)
+			innerFunction.Init()
+			m.AddTag(innerFunction)
+			return innerAlias
+		}
 		// 时延类，内层结构为groupArray，忽略0值
 		innerFunction := view.DefaultFunction{
 			Name:       view.FUNCTION_GROUP_ARRAY,
@@ -524,8 +740,8 @@ func (f *AggFunction) FormatInnerTag(m *view.Model) (innerAlias string) {
 		// When using max, and min operators. The inner layer uses itself
 		if slices.Contains([]string{view.FUNCTION_MAX, view.FUNCTION_MIN}, f.Name) {
 			field := f.Metrics.DBField
-			if f.Metrics.DBField == "time" {
-				field = "toUnixTimestamp(time)"
+			if f.Metrics.DBField == chCommon.GetTimeColumn(m.DB) {
+				field = fmt.Sprintf("toUnixTimestamp(%s)", field)
 			}
 			innerFunction = view.DefaultFunction{
 				Name:       f.Name,
@@ -561,7 +777,7 @@ func (f *AggFunction) FormatInnerTag(m *view.Model) (innerAlias string) {
 				innerFunction := view.DefaultFunction{
 					Name:       f.Name,
 					Fields:     []view.Node{&view.Field{Value: f.Metrics.DBField}},
-					IgnoreZero: true,
+					IgnoreZero: !f.isIncludeZero(),
 				}
 				innerAlias = innerFunction.SetAlias("", true)
 				innerFunction.SetFlag(view.METRICS_FLAG_INNER)
@@ -647,15 +863,21 @@ func (f *AggFunction) FormatInnerTag(m *view.Model) (innerAlias string) {
 }
 
 func (f *AggFunction) Trans(m *view.Model) view.Node {
+	if isArgMaxFunction(f.Name) {
+		return f.transArgMax(m)
+	}
 	var outFunc view.Function
 	if m.MetricsLevelFlag == view.MODEL_METRICS_LEVEL_FLAG_LAYERED && f.Name == view.FUNCTION_COUNT {
 		outFunc = &view.DefaultFunction{Name: view.FUNCTION_SUM}
 	} else {
 		outFunc = view.GetFunc(f.Name)
 	}
-	if len(f.Args) > 1 {
+	if len(f.Args) > 1 && !isLastFunction(f.Name) && f.Name != view.FUNCTION_AVG {
 		outFunc.SetArgs(f.Args[1:])
 	}
+	if m.MetricsLevelFlag != view.MODEL_METRICS_LEVEL_FLAG_LAYERED && (f.Name == view.FUNCTION_DELTA || isLastFunction(f.Name)) {
+		setArgTimeField(outFunc, m)
+	}
 	if m.MetricsLevelFlag == view.MODEL_METRICS_LEVEL_FLAG_LAYERED {
 		// When Avg is forced (due to the need for other metrics in the same statement)
 		// to use two layers of SQL calculation, the calculation logic of AAvg is directly used
@@ -670,10 +892,10 @@ func (f *AggFunction) Trans(m *view.Model) view.Node {
 		case metrics.METRICS_TYPE_DELAY, metrics.METRICS_TYPE_BOUNDED_GAUGE:
 			// 时延类和商值类，忽略0值
 			// When using avg, max, and min operators. The outer layer uses itself
-			if !slices.Contains([]string{view.FUNCTION_AVG, view.FUNCTION_MAX, view.FUNCTION_MIN}, f.Name) {
+			if !slices.Contains([]string{view.FUNCTION_AVG, view.FUNCTION_MAX, view.FUNCTION_MIN, view.FUNCTION_LAST, view.FUNCTION_FIRST}, f.Name) {
 				outFunc.SetIsGroupArray(true)
 			}
-			outFunc.SetIgnoreZero(true)
+			outFunc.SetIgnoreZero(!f.isIncludeZero())
 		case metrics.METRICS_TYPE_PERCENTAGE:
 			outFunc.SetFillNullAsZero(true)
 			outFunc.SetMath("*100")
@@ -681,6 +903,10 @@ func (f *AggFunction) Trans(m *view.Model) view.Node {
 			outFunc.SetIsGroupArray(true)
 		}
 		outFunc.SetFields([]view.Node{&view.Field{Value: innerAlias}})
+		if f.Name == view.FUNCTION_DELTA || isLastFunction(f.Name) {
+			// 外层按里层的_time取首尾值
+			outFunc.SetArgs([]string{"_time"})
+		}
 	} else if m.MetricsLevelFlag == view.MODEL_METRICS_LEVEL_FLAG_UNLAY {
 		switch f.Metrics.Type {
 		case metrics.METRICS_TYPE_COUNTER, metrics.METRICS_TYPE_GAUGE:
@@ -697,7 +923,7 @@ func (f *AggFunction) Trans(m *view.Model) view.Node {
 			if f.Name == view.FUNCTION_AVG {
 				outFunc = view.GetFunc(view.FUNCTION_DELAY_AVG)
 			}
-			outFunc.SetIgnoreZero(true)
+			outFunc.SetIgnoreZero(!f.isIncludeZero())
 		case metrics.METRICS_TYPE_QUOTIENT:
 			// Quotient type weighted average
 			if f.Name == view.FUNCTION_AVG {
@@ -728,11 +954,15 @@ func (f *AggFunction) Trans(m *view.Model) view.Node {
 		if f.Name == view.FUNCTION_COUNT {
 			field = "1"
 		}
-		if slices.Contains([]string{view.FUNCTION_MAX, view.FUNCTION_MIN}, f.Name) && field == "time" {
-			field = "toUnixTimestamp(time)"
+		if slices.Contains([]string{view.FUNCTION_MAX, view.FUNCTION_MIN}, f.Name) && field == chCommon.GetTimeColumn(m.DB) {
+			field = fmt.Sprintf("toUnixTimestamp(%s)", field)
 		}
 		outFunc.SetFields([]view.Node{&view.Field{Value: field}})
 	}
+	if isLastFunction(f.Name) {
+		// Last/First是否忽略0值只由参数'nonzero'决定
+		outFunc.SetIgnoreZero(f.isNonZero())
+	}
 	outFunc.SetFlag(view.METRICS_FLAG_OUTER)
 	outFunc.SetTime(m.Time)
 	outFunc.Init()
@@ -748,6 +978,11 @@ func (f *AggFunction) Trans(m *view.Model) view.Node {
 
 func (f *AggFunction) Format(m *view.Model) {
 	outFunc := f.Trans(m)
+	if f.FillNull != "" {
+		fillNullFunc := &view.FillNullFunction{Function: outFunc.(view.Function), Value: f.FillNull}
+		fillNullFunc.SetFlag(outFunc.(view.Function).GetFlag())
+		outFunc = fillNullFunc
+	}
 	if f.Alias != "" {
 		outFunc.(view.Function).SetAlias(f.Alias, false)
 	}
@@ -801,13 +1036,54 @@ type Time struct {
 	WindowSize int
 	Offset     int
 	Fill       string
+	Unit       string
+}
+
+// setArgTimeField argMax/argMin按数据库配置的时间列排序
+func setArgTimeField(function view.Function, m *view.Model) {
+	function.SetArgs([]string{chCommon.GetTimeColumn(m.DB)})
+}
+
+// unixTimestampField 时间桶仍按秒计算，毫秒输出时转为DateTime64，toUnixTimestamp64Milli不接受DateTime
+func unixTimestampField(m *view.Model, field string) string {
+	if m.TimestampMilli {
+		return fmt.Sprintf("toUnixTimestamp64Milli(toDateTime64(%s, 3))", field)
+	}
+	return fmt.Sprintf("toUnixTimestamp(%s)", field)
 }
 
 func (t *Time) Trans(m *view.Model) error {
+	// time(time, 60, 'align')：将时间范围对齐到数据源的时间粒度
+	args := []string{}
+	for _, arg := range t.Args {
+		if strings.ToLower(arg) == "'align'" {
+			m.Time.Align = true
+			continue
+		}
+		args = append(args, arg)
+	}
+	unitSeconds := 1
+	if len(args) > 2 && strings.HasPrefix(args[2], "'") {
+		unit := strings.ToLower(strings.Trim(args[2], "'"))
+		seconds, ok := TIME_UNIT_SECONDS[unit]
+		if !ok {
+			return fmt.Errorf("time unit %s not support", args[2])
+		}
+		// 天、周、月按日历对齐，秒、分、时直接换算为秒
+		if seconds >= INTERVAL_1D {
+			t.Unit = unit
+		}
+		unitSeconds = seconds
+		args = append(args[:2], args[3:]...)
+	}
+	t.Args = args
 	t.TimeField = strings.ReplaceAll(t.Args[0], "`", "")
 	floatInterval, err := strconv.ParseFloat(t.Args[1], 64)
-	intInterval := int(math.Ceil(floatInterval))
-	t.Interval = intInterval
+	if t.Unit != "" {
+		t.Interval = int(math.Ceil(floatInterval)) * unitSeconds
+	} else {
+		t.Interval = int(math.Ceil(floatInterval * float64(unitSeconds)))
+	}
 	if err != nil {
 		return err
 	}
@@ -836,6 +1112,7 @@ func (t *Time) Trans(m *view.Model) error {
 	m.Time.Fill = t.Fill
 	m.Time.Offset = t.Offset
 	m.Time.Alias = t.Alias
+	m.Time.Unit = t.Unit
 	return nil
 }
 
@@ -844,9 +1121,32 @@ func (t *Time) Format(m *view.Model) {
 	interval := m.Time.Interval
 	toDatasourceIntervalFunction := "toIntervalSecond"
 	datasourceInterval := m.Time.DatasourceInterval
-	if interval >= INTERVAL_1D {
-		toIntervalFunction = "toIntervalDay"
-		interval = interval / INTERVAL_1D
+	switch m.Time.Unit {
+	case TIME_UNIT_WEEK:
+		toIntervalFunction = "toIntervalWeek"
+		interval = interval / TIME_UNIT_SECONDS[TIME_UNIT_WEEK]
+	case TIME_UNIT_MONTH:
+		toIntervalFunction = "toIntervalMonth"
+		interval = interval / TIME_UNIT_SECONDS[TIME_UNIT_MONTH]
+	default:
+		if interval >= INTERVAL_1D {
+			toIntervalFunction = "toIntervalDay"
+			interval = interval / INTERVAL_1D
+		}
+	}
+	// 以天、周、月为单位且间隔为1时使用对应的toStartOf函数
+	startOfInterval := func(field string) string {
+		if interval == 1 {
+			switch m.Time.Unit {
+			case TIME_UNIT_DAY:
+				return fmt.Sprintf("toStartOfDay(%s)", field)
+			case TIME_UNIT_WEEK:
+				return fmt.Sprintf("toStartOfWeek(%s, 1)", field)
+			case TIME_UNIT_MONTH:
+				return fmt.Sprintf("toStartOfMonth(%s)", field)
+			}
+		}
+		return fmt.Sprintf("toStartOfInterval(%s, %s(%d))", field, toIntervalFunction, interval)
 	}
 	if datasourceInterval >= INTERVAL_1D {
 		toDatasourceIntervalFunction = "toIntervalDay"
@@ -858,6 +1158,11 @@ func (t *Time) Format(m *view.Model) {
 		w[i] = strconv.Itoa(i)
 	}
 	windows = strings.Join(w, ",")
+	// time(time, ...)使用数据库配置的时间列
+	timeColumn := t.TimeField
+	if timeColumn == chCommon.DEFAULT_TIME_COLUMN {
+		timeColumn = chCommon.GetTimeColumn(m.DB)
+	}
 	var innerTimeField string
 	if m.MetricsLevelFlag == view.MODEL_METRICS_LEVEL_FLAG_LAYERED {
 		innerTimeField = "_" + t.TimeField
@@ -866,19 +1171,19 @@ func (t *Time) Format(m *view.Model) {
 			offset := m.Time.Offset
 			if offset > 0 {
 				withValue = fmt.Sprintf(
-					"toStartOfInterval(time-%d, %s(%d)) + %s(arrayJoin([%s]) * %d) + %d",
-					offset, toIntervalFunction, interval, toIntervalFunction, windows, interval, offset,
+					"%s + %s(arrayJoin([%s]) * %d) + %d",
+					startOfInterval(fmt.Sprintf("%s-%d", timeColumn, offset)), toIntervalFunction, windows, interval, offset,
 				)
 			} else {
 				withValue = fmt.Sprintf(
-					"toStartOfInterval(time, %s(%d)) + %s(arrayJoin([%s]) * %d)",
-					toIntervalFunction, interval, toIntervalFunction, windows, interval,
+					"%s + %s(arrayJoin([%s]) * %d)",
+					startOfInterval(timeColumn), toIntervalFunction, windows, interval,
 				)
 			}
 		} else {
 			withValue = fmt.Sprintf(
 				"toStartOfInterval(%s, %s(%d))",
-				t.TimeField, toDatasourceIntervalFunction, datasourceInterval,
+				timeColumn, toDatasourceIntervalFunction, datasourceInterval,
 			)
 		}
 
@@ -887,36 +1192,85 @@ func (t *Time) Format(m *view.Model) {
 		m.AddTag(&view.Tag{Value: withAlias, Withs: withs, Flag: view.NODE_FLAG_METRICS_INNER})
 		m.AddGroup(&view.Group{Value: withAlias, Flag: view.GROUP_FLAG_METRICS_INNTER})
 	} else if m.MetricsLevelFlag == view.MODEL_METRICS_LEVEL_FLAG_UNLAY {
-		innerTimeField = t.TimeField
+		innerTimeField = timeColumn
 	}
 	offset := m.Time.Offset
 	withValue := ""
 	if offset > 0 {
 		withValue = fmt.Sprintf(
-			"toStartOfInterval(%s-%d, %s(%d)) + %s(arrayJoin([%s]) * %d) + %d",
-			innerTimeField, offset, toIntervalFunction, interval, toIntervalFunction, windows, interval, offset,
+			"%s + %s(arrayJoin([%s]) * %d) + %d",
+			startOfInterval(fmt.Sprintf("%s-%d", innerTimeField, offset)), toIntervalFunction, windows, interval, offset,
 		)
 	} else {
 		withValue = fmt.Sprintf(
-			"toStartOfInterval(%s, %s(%d)) + %s(arrayJoin([%s]) * %d)",
-			innerTimeField, toIntervalFunction, interval, toIntervalFunction, windows, interval,
+			"%s + %s(arrayJoin([%s]) * %d)",
+			startOfInterval(innerTimeField), toIntervalFunction, windows, interval,
 		)
 	}
 	withAlias := "_" + strings.Trim(t.Alias, "`")
 	withs := []view.Node{&view.With{Value: withValue, Alias: withAlias}}
-	tagField := fmt.Sprintf("toUnixTimestamp(`%s`)", withAlias)
+	tagField := unixTimestampField(m, fmt.Sprintf("`%s`", withAlias))
 	if m.IsDerivative {
-		tagField = fmt.Sprintf("toUnixTimestamp(`%s`)", innerTimeField)
+		tagField = unixTimestampField(m, fmt.Sprintf("`%s`", innerTimeField))
 		m.AddTag(&view.Tag{Value: tagField, Alias: t.Alias, Flag: view.NODE_FLAG_METRICS_OUTER})
 	} else {
 		m.AddTag(&view.Tag{Value: tagField, Alias: t.Alias, Flag: view.NODE_FLAG_METRICS_OUTER, Withs: withs})
 	}
 	m.AddGroup(&view.Group{Value: t.Alias, Flag: view.GROUP_FLAG_METRICS_OUTER})
-	if (m.Time.Fill == "0" || m.Time.Fill == "none" || m.Time.Fill == "null") && m.Time.Interval > 0 {
+	// 周、月的时间桶不等长，不支持按固定步长补点
+	isCalendarUnit := m.Time.Unit == TIME_UNIT_WEEK || m.Time.Unit == TIME_UNIT_MONTH
+	if (m.Time.Fill == "0" || m.Time.Fill == "none" || m.Time.Fill == "null") && m.Time.Interval > 0 && !isCalendarUnit {
 		m.AddCallback("time", TimeFill([]interface{}{m}))
 	}
 }
 
+// GetRawTagValue 返回枚举类tag在库中的原始取值，不做枚举翻译
+func GetRawTagValue(name, db, table string) string {
+	name = strings.Trim(name, "`")
+	tagDes, ok := tag.GetTag(name, db, table, "default")
+	if ok && tagDes.TagTranslator != "" {
+		return tagDes.TagTranslator
+	}
+	return name
+}
+
+// GetRawExpr 参数为字符串常量时，Raw('expr')将其中的ClickHouse表达式原样透传
+func GetRawExpr(arg string) (string, bool) {
+	if len(arg) < 2 || !strings.HasPrefix(arg, "'") || !strings.HasSuffix(arg, "'") {
+		return "", false
+	}
+	return unescapeSQLString(arg[1 : len(arg)-1]), true
+}
+
+// sqlparser输出字符串常量时会对引号等字符转义，这里还原
+func unescapeSQLString(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			builder.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case '0':
+			builder.WriteByte(0)
+		case 'b':
+			builder.WriteByte('\b')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'r':
+			builder.WriteByte('\r')
+		case 't':
+			builder.WriteByte('\t')
+		case 'Z':
+			builder.WriteByte(26)
+		default:
+			builder.WriteByte(value[i])
+		}
+	}
+	return builder.String()
+}
+
 type TagFunction struct {
 	Name   string
 	Args   []string
@@ -975,14 +1329,55 @@ func (f *TagFunction) Check() error {
 				return errors.New(fmt.Sprintf("function %s not support %s", f.Name, f.Args[0]))
 			}
 		}
+	case TAG_FUNCTION_RAW:
+		if _, isExpr := GetRawExpr(f.Args[0]); isExpr {
+			// 多租户场景下可关闭，避免任意表达式注入
+			if f.Engine == nil || !f.Engine.AllowRawExpr {
+				return errors.New(fmt.Sprintf("function %s with expression %s is disabled", f.Name, f.Args[0]))
+			}
+			return nil
+		}
+		// Raw只对枚举类tag有意义
+		_, ok := tag.GetTag(strings.Trim(f.Args[0], "`"), f.DB, f.Table, "enum")
+		if !ok {
+			return errors.New(fmt.Sprintf("function %s not support %s", f.Name, f.Args[0]))
+		}
 	case TAG_FUNCTION_FAST_FILTER:
 		if strings.Trim(f.Args[0], "`") != chCommon.TRACE_ID_TAG {
 			return errors.New(fmt.Sprintf("function %s not support %s", f.Name, f.Args[0]))
 		}
+	case TAG_FUNCTION_BUCKET:
+		if len(f.Args) != 2 {
+			return errors.New(fmt.Sprintf("function %s requires 2 arguments, got %d", f.Name, len(f.Args)))
+		}
+		if _, ok := f.getBucketField(); !ok {
+			return errors.New(fmt.Sprintf("function %s not support %s", f.Name, f.Args[0]))
+		}
+		width, err := strconv.Atoi(f.Args[1])
+		if err != nil || width <= 0 {
+			return errors.New(fmt.Sprintf("function %s width must be a positive integer, got %s", f.Name, f.Args[1]))
+		}
 	}
 	return nil
 }
 
+// getBucketField Bucket的第一个参数可以是metric或tag，返回其对应的数据库字段
+func (f *TagFunction) getBucketField() (string, bool) {
+	field := strings.Trim(f.Args[0], "`")
+	if f.Engine != nil {
+		if metricStruct, ok := metrics.GetMetrics(field, f.DB, f.Table, f.Engine.ORGID, f.Engine.NativeField, f.Engine.CustomMetrics); ok {
+			return metricStruct.DBField, true
+		}
+	}
+	if tagDes, ok := tag.GetTag(field, f.DB, f.Table, "default"); ok {
+		if tagDes.TagTranslator != "" {
+			return tagDes.TagTranslator, true
+		}
+		return field, true
+	}
+	return "", false
+}
+
 func (f *TagFunction) Trans(m *view.Model) view.Node {
 	fields := f.Args
 	switch f.Name {
@@ -1113,6 +1508,25 @@ func (f *TagFunction) Trans(m *view.Model) view.Node {
 		}
 		f.Withs = []view.Node{&view.With{Value: tagTranslator, Alias: f.Alias}}
 		return f.getViewNode()
+	case TAG_FUNCTION_RAW:
+		if f.Alias == "" {
+			f.Alias = fmt.Sprintf("Raw(%s)", f.Args[0])
+		}
+		value, isExpr := GetRawExpr(f.Args[0])
+		if !isExpr {
+			value = GetRawTagValue(f.Args[0], f.DB, f.Table)
+		}
+		f.Withs = []view.Node{&view.With{Value: value, Alias: f.Alias}}
+		return f.getViewNode()
+	case TAG_FUNCTION_BUCKET:
+		// Bucket(field, width)将数值按固定宽度分桶，取桶的下边界，可用于group by
+		if f.Alias == "" {
+			f.Alias = fmt.Sprintf("Bucket(%s, %s)", f.Args[0], f.Args[1])
+		}
+		field, _ := f.getBucketField()
+		value := fmt.Sprintf("intDiv(%s, %s) * %s", field, f.Args[1], f.Args[1])
+		f.Withs = []view.Node{&view.With{Value: value, Alias: f.Alias}}
+		return f.getViewNode()
 	}
 	values := make([]string, len(fields))
 	for i, field := range fields {
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"slices"
+
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/metrics"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+)
+
+// FunctionSignature 算子签名，ArgCount为包含指标量在内的参数个数，
+// Variadic为true时参数个数不固定，ArgCount为常用的参数个数
+type FunctionSignature struct {
+	Name        string `json:"name"`
+	ArgCount    int    `json:"arg_count"`
+	Variadic    bool   `json:"variadic"`
+	Description string `json:"description"`
+}
+
+// 不带指标量参数的算子
+var noMetricFunctions = []string{view.FUNCTION_ERROR_RATIO}
+
+// SupportedFunctions 按show metrics functions的顺序返回支持的算子
+func SupportedFunctions() []FunctionSignature {
+	signatures := make([]FunctionSignature, 0, len(metrics.METRICS_FUNCTIONS))
+	for _, name := range metrics.METRICS_FUNCTIONS {
+		function, ok := metrics.METRICS_FUNCTIONS_MAP[name]
+		if !ok {
+			continue
+		}
+		argCount := 1 + function.AdditionnalParamCount
+		if slices.Contains(noMetricFunctions, name) {
+			argCount = function.AdditionnalParamCount
+		}
+		signatures = append(signatures, FunctionSignature{
+			Name:     name,
+			ArgCount: argCount,
+			// Count()可省略参数
+			Variadic:    slices.Contains(variadicArgsFunctions, name) || name == view.FUNCTION_COUNT,
+			Description: function.Description,
+		})
+	}
+	return signatures
+}
+
+// GetFunctionSignature 返回算子的签名，未注册的算子返回false
+func GetFunctionSignature(name string) (FunctionSignature, bool) {
+	for _, signature := range SupportedFunctions() {
+		if signature.Name == name {
+			return signature, true
+		}
+	}
+	return FunctionSignature{}, false
+}
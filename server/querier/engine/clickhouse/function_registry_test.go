@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"testing"
+)
+
+func TestSupportedFunctions(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		argCount int
+		variadic bool
+	}{
+		{name: "Sum", argCount: 1},
+		{name: "Avg", argCount: 1},
+		{name: "Apdex", argCount: 2},
+		{name: "Spread", argCount: 1},
+		{name: "Percentile", argCount: 2},
+		{name: "Percentage", argCount: 2},
+		{name: "SmoothedPercentile", argCount: 3},
+		{name: "ErrorRatio", argCount: 0},
+		{name: "TopK", argCount: 2, variadic: true},
+		{name: "Last", argCount: 1, variadic: true},
+	} {
+		signature, ok := GetFunctionSignature(tc.name)
+		if !ok {
+			t.Errorf("%s: not found", tc.name)
+			continue
+		}
+		if signature.ArgCount != tc.argCount || signature.Variadic != tc.variadic {
+			t.Errorf("%s: get %d args (variadic: %v), want %d args (variadic: %v)", tc.name, signature.ArgCount, signature.Variadic, tc.argCount, tc.variadic)
+		}
+		if signature.Description == "" {
+			t.Errorf("%s: description is empty", tc.name)
+		}
+	}
+
+	if _, ok := GetFunctionSignature("Aveg"); ok {
+		t.Error("unknown function Aveg is found")
+	}
+	// 每个算子都有说明
+	for _, signature := range SupportedFunctions() {
+		if signature.Description == "" {
+			t.Errorf("%s: description is empty", signature.Name)
+		}
+	}
+}
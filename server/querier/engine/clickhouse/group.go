@@ -21,8 +21,11 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/xwb1989/sqlparser"
+
 	"github.com/deepflowio/deepflow/server/querier/common"
 	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/metrics"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/trans_prometheus"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
@@ -115,7 +118,12 @@ func GetGroup(name string, e *CHEngine) ([]Statement, error) {
 			}
 		} else if slices.Contains(tag.AUTO_CUSTOM_TAG_NAMES, strings.Trim(name, "`")) {
 			autoTagMap := tagItem.TagTranslatorMap
+			autoTagSlice := []string{}
 			for autoTagKey, _ := range autoTagMap {
+				autoTagSlice = append(autoTagSlice, autoTagKey)
+			}
+			slices.Sort(autoTagSlice)
+			for _, autoTagKey := range autoTagSlice {
 				stmts = append(stmts, &GroupTag{Value: "`" + autoTagKey + "`"})
 			}
 		} else if tagItem.GroupTranslator != "" {
@@ -159,6 +167,71 @@ func GetGroup(name string, e *CHEngine) ([]Statement, error) {
 	return stmts, nil
 }
 
+// Enum(tag)按翻译后的值分组；Raw(tag)按原始值分组，不生成翻译相关的group
+func GetTagFunctionGroup(name string, args []string, e *CHEngine) ([]Statement, error) {
+	tagFunction := TagFunction{Name: name, Args: args, DB: e.DB, Table: e.Table, Engine: e}
+	if err := tagFunction.Check(); err != nil {
+		return nil, err
+	}
+	tagFunction.Trans(e.Model)
+	return []Statement{&GroupTag{Value: fmt.Sprintf("`%s`", tagFunction.Alias), Withs: tagFunction.Withs}}, nil
+}
+
+// GROUP_EXPR_FUNCTIONS 可用于计算分组键的函数，如group by intDiv(server_port, 1000)
+var GROUP_EXPR_FUNCTIONS = []string{
+	"intDiv", "modulo", "abs", "floor", "ceil", "round",
+	"lower", "upper", "substring", "concat",
+}
+
+func isGroupExprFunction(name string) bool {
+	return slices.Contains(GROUP_EXPR_FUNCTIONS, name)
+}
+
+// transGroupExpr 将计算表达式中的tag及metric替换为数据库字段，参数只能是tag、metric、常量或嵌套的计算表达式
+func (e *CHEngine) transGroupExpr(expr *sqlparser.FuncExpr) (string, error) {
+	name := strings.Trim(sqlparser.String(expr.Name), "`")
+	args := make([]string, 0, len(expr.Exprs))
+	for _, arg := range expr.Exprs {
+		item, ok := arg.(*sqlparser.AliasedExpr)
+		if !ok {
+			return "", fmt.Errorf("function %s does not support argument %s", name, sqlparser.String(arg))
+		}
+		switch argExpr := item.Expr.(type) {
+		case *sqlparser.SQLVal, *sqlparser.UnaryExpr:
+			if argVal, ok := argExpr.(*sqlparser.SQLVal); !isNumericLiteral(argExpr) && (!ok || argVal.Type != sqlparser.StrVal) {
+				return "", fmt.Errorf("function %s does not support argument %s", name, sqlparser.String(argExpr))
+			}
+			args = append(args, sqlparser.String(argExpr))
+		case *sqlparser.ColName:
+			tagName := strings.Trim(chCommon.ParseAlias(argExpr), "`")
+			if !e.isKnownTag(tagName) {
+				return "", newValidateError(VALIDATE_ERROR_UNKNOWN_TAG, tagName, fmt.Sprintf("tag: %s not found in %s.%s", tagName, e.DB, e.Table))
+			}
+			// 与Bucket一致，参数也可以是metric
+			if tagItem, ok := tag.GetTag(tagName, e.DB, e.Table, "default"); ok && tagItem.TagTranslator != "" {
+				args = append(args, tagItem.TagTranslator)
+			} else if metricStruct, ok := metrics.GetMetrics(tagName, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics); ok {
+				args = append(args, metricStruct.DBField)
+			} else {
+				args = append(args, sqlparser.String(argExpr))
+			}
+		case *sqlparser.FuncExpr:
+			argName := strings.Trim(sqlparser.String(argExpr.Name), "`")
+			if !isGroupExprFunction(argName) {
+				return "", fmt.Errorf("function %s does not support argument %s", name, sqlparser.String(argExpr))
+			}
+			value, err := e.transGroupExpr(argExpr)
+			if err != nil {
+				return "", err
+			}
+			args = append(args, value)
+		default:
+			return "", fmt.Errorf("function %s does not support argument %s", name, sqlparser.String(argExpr))
+		}
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", ")), nil
+}
+
 func GetPrometheusGroup(name string, e *CHEngine) string {
 	table := e.Table
 	asTagMap := e.AsTagMap
@@ -345,8 +418,8 @@ func GetNotNullFilter(name string, e *CHEngine) (view.Node, bool) {
 func FormatInnerTime(m *view.Model) {
 	if m.DB != chCommon.DB_NAME_FLOW_LOG && m.Time.Interval == 0 && m.MetricsLevelFlag == view.MODEL_METRICS_LEVEL_FLAG_LAYERED && m.HasAggFunc == true {
 		withValue := fmt.Sprintf(
-			"toStartOfInterval(time, toIntervalSecond(%d))",
-			m.Time.DatasourceInterval,
+			"toStartOfInterval(%s, toIntervalSecond(%d))",
+			chCommon.GetTimeColumn(m.DB), m.Time.DatasourceInterval,
 		)
 		withAlias := "_time"
 		withs := []view.Node{&view.With{Value: withValue, Alias: withAlias}}
@@ -358,14 +431,29 @@ func FormatInnerTime(m *view.Model) {
 type GroupTag struct {
 	Value    string
 	Alias    string
+	IsExpr   bool // Value为计算表达式
 	Withs    []view.Node
 	AsTagMap map[string]string
 }
 
 func (g *GroupTag) Format(m *view.Model) {
 	if len(g.Withs) == 0 {
-		m.AddGroup(&view.Group{Value: g.Value, Alias: g.Alias})
+		m.AddGroup(&view.Group{Value: g.Value, Alias: g.Alias, IsExpr: g.IsExpr})
 	} else {
 		m.AddGroup(&view.Group{Value: g.Value, Withs: g.Withs})
 	}
 }
+
+type GroupingSets struct {
+	Sets [][]string
+}
+
+func (g *GroupingSets) Format(m *view.Model) {
+	m.AddGroupingSets(g.Sets)
+}
+
+type WithTotals struct{}
+
+func (g *WithTotals) Format(m *view.Model) {
+	m.SetWithTotals()
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+)
+
+const (
+	QUERY_STATUS_SUCCESS     = "success"
+	QUERY_STATUS_PARSE_ERROR = "parse_error"
+	QUERY_STATUS_QUERY_ERROR = "query_error"
+)
+
+const engineMetricsNamespace = "deepflow_querier_engine"
+
+// 解析失败时表名未确认，可能是用户输入的任意字符串
+const METRICS_TABLE_UNKNOWN = "unknown"
+
+var engineMetricsIns atomic.Pointer[EngineMetrics]
+
+// EngineMetrics 查询翻译及执行的prometheus指标，标签只使用db及table，不使用sql以控制基数
+type EngineMetrics struct {
+	Queries       *prometheus.CounterVec   // db, table, status
+	ParseDuration *prometheus.HistogramVec // db, table
+	SQLLength     *prometheus.HistogramVec // db, table
+	SubViewLevels *prometheus.HistogramVec // db, table
+	QueryDuration *prometheus.HistogramVec // db, table
+	ResultRows    *prometheus.HistogramVec // db, table
+}
+
+func NewEngineMetrics() *EngineMetrics {
+	labels := []string{"db", "table"}
+	return &EngineMetrics{
+		Queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: engineMetricsNamespace,
+			Name:      "queries_total",
+			Help:      "Number of queries by db, table and status.",
+		}, []string{"db", "table", "status"}),
+		ParseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: engineMetricsNamespace,
+			Name:      "parse_duration_seconds",
+			Help:      "Time spent translating queries to ClickHouse SQL.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, labels),
+		SQLLength: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: engineMetricsNamespace,
+			Name:      "sql_length_bytes",
+			Help:      "Length of the generated ClickHouse SQL.",
+			Buckets:   prometheus.ExponentialBuckets(128, 4, 7),
+		}, labels),
+		SubViewLevels: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: engineMetricsNamespace,
+			Name:      "sub_view_levels",
+			Help:      "Number of SubView layers of the generated ClickHouse SQL.",
+			Buckets:   prometheus.LinearBuckets(1, 1, 5),
+		}, labels),
+		QueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: engineMetricsNamespace,
+			Name:      "query_duration_seconds",
+			Help:      "Time spent executing the generated SQL in ClickHouse.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 8),
+		}, labels),
+		ResultRows: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: engineMetricsNamespace,
+			Name:      "result_rows",
+			Help:      "Number of rows returned by ClickHouse.",
+			Buckets:   prometheus.ExponentialBuckets(1, 10, 7),
+		}, labels),
+	}
+}
+
+func (m *EngineMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.Queries, m.ParseDuration, m.SQLLength, m.SubViewLevels, m.QueryDuration, m.ResultRows}
+}
+
+// Register 注册到调用方提供的registerer，由调用方决定暴露的endpoint
+func (m *EngineMetrics) Register(registerer prometheus.Registerer) error {
+	for _, collector := range m.collectors() {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterEngineMetrics 注册后ExecuteQuery默认使用该指标，未注册时不统计
+func RegisterEngineMetrics(registerer prometheus.Registerer) (*EngineMetrics, error) {
+	m := NewEngineMetrics()
+	if err := m.Register(registerer); err != nil {
+		return nil, err
+	}
+	engineMetricsIns.Store(m)
+	return m, nil
+}
+
+func GetEngineMetrics() *EngineMetrics {
+	return engineMetricsIns.Load()
+}
+
+// ObserveParse 记录翻译结果，sql为生成的ClickHouse sql，翻译失败时只计数
+func (m *EngineMetrics) ObserveParse(db, table string, duration time.Duration, sql string, subViewLevels int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.Queries.WithLabelValues(db, resolvedTable(db, table), QUERY_STATUS_PARSE_ERROR).Inc()
+		return
+	}
+	m.ParseDuration.WithLabelValues(db, table).Observe(duration.Seconds())
+	m.SQLLength.WithLabelValues(db, table).Observe(float64(len(sql)))
+	m.SubViewLevels.WithLabelValues(db, table).Observe(float64(subViewLevels))
+}
+
+// ObserveQuery 记录ClickHouse执行结果
+func (m *EngineMetrics) ObserveQuery(db, table string, duration time.Duration, rows int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.Queries.WithLabelValues(db, table, QUERY_STATUS_QUERY_ERROR).Inc()
+		return
+	}
+	m.Queries.WithLabelValues(db, table, QUERY_STATUS_SUCCESS).Inc()
+	m.QueryDuration.WithLabelValues(db, table).Observe(duration.Seconds())
+	m.ResultRows.WithLabelValues(db, table).Observe(float64(rows))
+}
+
+// resolvedTable 不是db中已知的表(可带数据源，如network.1m)时返回unknown，避免标签基数不受控制
+func resolvedTable(db, table string) string {
+	name, datasource, found := strings.Cut(table, ".")
+	if !slices.Contains(chCommon.DB_TABLE_MAP[db], name) {
+		return METRICS_TABLE_UNKNOWN
+	}
+	if _, ok := chCommon.DATASOURCE_NAME_INTERVAL_MAP[datasource]; found && !ok {
+		return METRICS_TABLE_UNKNOWN
+	}
+	return table
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bou.ke/monkey"
+	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/client"
+)
+
+func TestEngineMetrics(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	var c *client.Client
+	guard := monkey.PatchInstanceMethod(reflect.TypeOf(c), "DoQuery", func(*client.Client, *client.QueryParams) (*common.Result, error) {
+		return &common.Result{Values: []interface{}{[]interface{}{1}, []interface{}{2}}}, nil
+	})
+	defer guard.Unpatch()
+
+	registry := prometheus.NewRegistry()
+	m := NewEngineMetrics()
+	if err := m.Register(registry); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	// 重复注册返回错误
+	if err := m.Register(registry); err == nil {
+		t.Error("register twice: want error")
+	}
+
+	type key struct{ db, table, status string }
+	want := map[key]int{}
+	succeeded := 0
+	for _, pcase := range parseSQL {
+		// WITH、UNION、SLIMIT及SHOW不经过单条sql的翻译流程
+		input := strings.ToUpper(pcase.input)
+		if strings.HasPrefix(input, "WITH") || strings.HasPrefix(input, "SHOW") || strings.Contains(input, "UNION") || strings.Contains(input, "SLIMIT") {
+			continue
+		}
+		db := pcase.db
+		if db == "" {
+			db = "flow_log"
+		}
+		e := CHEngine{DB: db, Metrics: m}
+		e.Init()
+		_, _, err := e.ExecuteQuery(&common.QuerierParams{DB: db, Sql: pcase.input, Context: context.Background()})
+		status, table := QUERY_STATUS_SUCCESS, e.Table
+		if err != nil {
+			status, table = QUERY_STATUS_PARSE_ERROR, resolvedTable(db, e.Table)
+		} else {
+			succeeded++
+		}
+		want[key{db, table, status}]++
+	}
+	if succeeded == 0 {
+		t.Fatal("no query succeeded")
+	}
+
+	for k, count := range want {
+		if got := testutil.ToFloat64(m.Queries.WithLabelValues(k.db, k.table, k.status)); int(got) != count {
+			t.Errorf("queries_total{db=%q,table=%q,status=%q}: get %v, want %d", k.db, k.table, k.status, got, count)
+		}
+	}
+	// 标签只有db、table及status
+	if got := testutil.CollectAndCount(m.Queries); got != len(want) {
+		t.Errorf("queries_total: get %d series, want %d", got, len(want))
+	}
+
+	// 直方图按成功翻译及执行的查询数统计
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	histograms := 0
+	for _, family := range families {
+		if family.GetType() != dto.MetricType_HISTOGRAM {
+			continue
+		}
+		histograms++
+		count := uint64(0)
+		for _, metric := range family.GetMetric() {
+			count += metric.GetHistogram().GetSampleCount()
+			for _, label := range metric.GetLabel() {
+				if label.GetName() != "db" && label.GetName() != "table" {
+					t.Errorf("%s: unexpected label %s", family.GetName(), label.GetName())
+				}
+			}
+		}
+		if int(count) != succeeded {
+			t.Errorf("%s: get %d samples, want %d", family.GetName(), count, succeeded)
+		}
+	}
+	if histograms != 5 {
+		t.Errorf("get %d histograms, want 5", histograms)
+	}
+
+	// 解析失败时未知的表名不作为标签
+	for _, sql := range []string{"select Sum(byte) as sum_byte from not_a_table limit 1 settings readonly=0"} {
+		e := CHEngine{DB: "flow_log", Metrics: m}
+		e.Init()
+		before := testutil.ToFloat64(m.Queries.WithLabelValues("flow_log", METRICS_TABLE_UNKNOWN, QUERY_STATUS_PARSE_ERROR))
+		if _, _, err := e.ExecuteQuery(&common.QuerierParams{DB: "flow_log", Sql: sql, Context: context.Background()}); err == nil {
+			t.Fatalf("%s: want parse error", sql)
+		}
+		if got := testutil.ToFloat64(m.Queries.WithLabelValues("flow_log", METRICS_TABLE_UNKNOWN, QUERY_STATUS_PARSE_ERROR)); got != before+1 {
+			t.Errorf("%s: get %v unknown table parse errors, want %v", sql, got, before+1)
+		}
+	}
+
+	// 执行失败
+	guard.Unpatch()
+	guard = monkey.PatchInstanceMethod(reflect.TypeOf(c), "DoQuery", func(*client.Client, *client.QueryParams) (*common.Result, error) {
+		return nil, errors.New("code: 241, message: memory limit exceeded")
+	})
+	defer guard.Unpatch()
+	e := CHEngine{DB: "flow_log", Metrics: m}
+	e.Init()
+	if _, _, err := e.ExecuteQuery(&common.QuerierParams{DB: "flow_log", Sql: "select Sum(byte) as sum_byte from l4_flow_log limit 1", Context: context.Background()}); err == nil {
+		t.Fatal("query error: want error")
+	}
+	if got := testutil.ToFloat64(m.Queries.WithLabelValues("flow_log", "l4_flow_log", QUERY_STATUS_QUERY_ERROR)); got != 1 {
+		t.Errorf("query error: get %v, want 1", got)
+	}
+}
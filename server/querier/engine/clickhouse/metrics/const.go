@@ -65,10 +65,10 @@ const (
 
 // 指标量类型支持不用拆层的算子的集合
 var METRICS_TYPE_UNLAY_FUNCTIONS = map[int][]string{
-	METRICS_TYPE_COUNTER:       []string{view.FUNCTION_SUM, view.FUNCTION_AVG},
-	METRICS_TYPE_GAUGE:         []string{view.FUNCTION_AVG},
-	METRICS_TYPE_BOUNDED_GAUGE: []string{view.FUNCTION_AVG, view.FUNCTION_AAVG, view.FUNCTION_MAX, view.FUNCTION_MIN, view.FUNCTION_LAST, view.FUNCTION_PCTL, view.FUNCTION_PCTL_EXACT},
-	METRICS_TYPE_DELAY:         []string{view.FUNCTION_AVG, view.FUNCTION_AAVG, view.FUNCTION_MAX, view.FUNCTION_MIN, view.FUNCTION_LAST, view.FUNCTION_PCTL, view.FUNCTION_PCTL_EXACT},
+	METRICS_TYPE_COUNTER:       []string{view.FUNCTION_SUM, view.FUNCTION_AVG, view.FUNCTION_DELTA},
+	METRICS_TYPE_GAUGE:         []string{view.FUNCTION_AVG, view.FUNCTION_DELTA},
+	METRICS_TYPE_BOUNDED_GAUGE: []string{view.FUNCTION_AVG, view.FUNCTION_AAVG, view.FUNCTION_MAX, view.FUNCTION_MIN, view.FUNCTION_LAST, view.FUNCTION_FIRST, view.FUNCTION_PCTL, view.FUNCTION_PCTL_EXACT},
+	METRICS_TYPE_DELAY:         []string{view.FUNCTION_AVG, view.FUNCTION_AAVG, view.FUNCTION_MAX, view.FUNCTION_MIN, view.FUNCTION_LAST, view.FUNCTION_FIRST, view.FUNCTION_PCTL, view.FUNCTION_PCTL_EXACT},
 	METRICS_TYPE_PERCENTAGE:    []string{view.FUNCTION_AVG},
 	METRICS_TYPE_QUOTIENT:      []string{view.FUNCTION_AVG},
 	METRICS_TYPE_TAG:           []string{view.FUNCTION_UNIQ, view.FUNCTION_UNIQ_EXACT},
@@ -147,3 +147,4 @@ var FLOW_LOG_CLOSE_TYPE_ESTABLISH_EXCEPTION_SERVER, _ = json.Marshal([]int{
 })
 
 const COUNT_METRICS_NAME = "row"
+const LOG_COUNT_METRICS_NAME = "log_count"
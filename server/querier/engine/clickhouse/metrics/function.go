@@ -29,9 +29,10 @@ type Function struct {
 	AdditionnalParamCount   int    // 额外参数数量
 	IsSupportOtherOperators bool   // 是否支持前置或后置算子
 	ValueType               string // 指标量返回的数据类型
+	Description             string // 算子说明，用于前端补全
 }
 
-func NewFunction(name string, functionType int, supportMetricsTypes []int, unitOverwrite string, additionnalParamCount int, isSupportOtherOperators bool, valueType string) *Function {
+func NewFunction(name string, functionType int, supportMetricsTypes []int, unitOverwrite string, additionnalParamCount int, isSupportOtherOperators bool, valueType string, description string) *Function {
 	return &Function{
 		Name:                    name,
 		Type:                    functionType,
@@ -40,6 +41,7 @@ func NewFunction(name string, functionType int, supportMetricsTypes []int, unitO
 		AdditionnalParamCount:   additionnalParamCount,
 		IsSupportOtherOperators: isSupportOtherOperators,
 		ValueType:               valueType,
+		Description:             description,
 	}
 }
 
@@ -48,44 +50,57 @@ var METRICS_FUNCTIONS = []string{
 	view.FUNCTION_PCTL, view.FUNCTION_PCTL_EXACT, view.FUNCTION_SPREAD,
 	view.FUNCTION_RSPREAD, view.FUNCTION_STDDEV, view.FUNCTION_APDEX,
 	view.FUNCTION_UNIQ, view.FUNCTION_UNIQ_EXACT, view.FUNCTION_PERCENTAG,
-	view.FUNCTION_PERSECOND, view.FUNCTION_HISTOGRAM, view.FUNCTION_LAST, view.FUNCTION_COUNT,
-	view.FUNCTION_TOPK, view.FUNCTION_ANY,
+	view.FUNCTION_PERSECOND, view.FUNCTION_HISTOGRAM, view.FUNCTION_LAST, view.FUNCTION_FIRST, view.FUNCTION_COUNT,
+	view.FUNCTION_TOPK, view.FUNCTION_ANY, view.FUNCTION_DELTA, view.FUNCTION_GEOMEAN, view.FUNCTION_HARMMEAN, view.FUNCTION_ZSCORE,
+	view.FUNCTION_MOVING_AVG, view.FUNCTION_SMOOTHED_PCTL, view.FUNCTION_ERROR_RATIO, view.FUNCTION_ARGMAX, view.FUNCTION_ARGMIN,
+	view.FUNCTION_PERCENT_OF_TOTAL,
 }
 
 var METRICS_FUNCTIONS_MAP = map[string]*Function{
-	view.FUNCTION_COUNT:         NewFunction(view.FUNCTION_COUNT, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_OTHER}, "$unit", 0, true, "Number"),
-	view.FUNCTION_SUM:           NewFunction(view.FUNCTION_SUM, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER}, "$unit", 0, true, "Number"),
-	view.FUNCTION_AVG:           NewFunction(view.FUNCTION_AVG, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number"),
-	view.FUNCTION_AAVG:          NewFunction(view.FUNCTION_AAVG, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number"),
-	view.FUNCTION_MAX:           NewFunction(view.FUNCTION_MAX, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number"),
-	view.FUNCTION_MIN:           NewFunction(view.FUNCTION_MIN, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number"),
-	view.FUNCTION_STDDEV:        NewFunction(view.FUNCTION_STDDEV, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number"),
-	view.FUNCTION_SPREAD:        NewFunction(view.FUNCTION_SPREAD, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number"),
-	view.FUNCTION_RSPREAD:       NewFunction(view.FUNCTION_RSPREAD, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "", 0, true, "Number"),
-	view.FUNCTION_APDEX:         NewFunction(view.FUNCTION_APDEX, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_DELAY}, "%", 1, true, "Number"),
-	view.FUNCTION_PCTL:          NewFunction(view.FUNCTION_PCTL, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 1, true, "Number"),
-	view.FUNCTION_PCTL_EXACT:    NewFunction(view.FUNCTION_PCTL_EXACT, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 1, true, "Number"),
-	view.FUNCTION_UNIQ:          NewFunction(view.FUNCTION_UNIQ, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 0, false, "Number"),
-	view.FUNCTION_UNIQ_EXACT:    NewFunction(view.FUNCTION_UNIQ_EXACT, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 0, false, "Number"),
-	view.FUNCTION_PERCENTAG:     NewFunction(view.FUNCTION_PERCENTAG, FUNCTION_TYPE_MATH, nil, "%", 0, true, "Number"),
-	view.FUNCTION_PERSECOND:     NewFunction(view.FUNCTION_PERSECOND, FUNCTION_TYPE_MATH, nil, "$unit/s", 0, true, "Number"),
-	view.FUNCTION_HISTOGRAM:     NewFunction(view.FUNCTION_HISTOGRAM, FUNCTION_TYPE_MATH, nil, "", 1, true, "Number"),
-	view.FUNCTION_LAST:          NewFunction(view.FUNCTION_LAST, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "", 0, true, "Number"),
-	view.FUNCTION_TOPK:          NewFunction(view.FUNCTION_TOPK, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 1, false, "String"),
-	view.FUNCTION_ANY:           NewFunction(view.FUNCTION_ANY, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 0, false, "String"),
-	view.FUNCTION_DERIVATIVE:    NewFunction(view.FUNCTION_DERIVATIVE, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER}, "$unit", 0, true, "Number"),
-	view.FUNCTION_COUNTDISTINCT: NewFunction(view.FUNCTION_COUNTDISTINCT, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 0, false, "Number"),
+	view.FUNCTION_COUNT:            NewFunction(view.FUNCTION_COUNT, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_OTHER}, "$unit", 0, true, "Number", "Number of rows"),
+	view.FUNCTION_SUM:              NewFunction(view.FUNCTION_SUM, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER}, "$unit", 0, true, "Number", "Sum of the metric"),
+	view.FUNCTION_AVG:              NewFunction(view.FUNCTION_AVG, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number", "Average of the metric, weighted by time for counters, ignoring zero of delay metrics unless include_zero=true"),
+	view.FUNCTION_AAVG:             NewFunction(view.FUNCTION_AAVG, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number", "Arithmetic average of the metric"),
+	view.FUNCTION_MAX:              NewFunction(view.FUNCTION_MAX, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number", "Maximum of the metric"),
+	view.FUNCTION_MIN:              NewFunction(view.FUNCTION_MIN, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number", "Minimum of the metric"),
+	view.FUNCTION_STDDEV:           NewFunction(view.FUNCTION_STDDEV, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number", "Population standard deviation of the metric"),
+	view.FUNCTION_SPREAD:           NewFunction(view.FUNCTION_SPREAD, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number", "Difference between the maximum and minimum of the metric"),
+	view.FUNCTION_RSPREAD:          NewFunction(view.FUNCTION_RSPREAD, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "", 0, true, "Number", "Ratio of the maximum to the minimum of the metric"),
+	view.FUNCTION_APDEX:            NewFunction(view.FUNCTION_APDEX, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_DELAY}, "%", 1, true, "Number", "Apdex score of the delay metric with the given satisfied threshold"),
+	view.FUNCTION_PCTL:             NewFunction(view.FUNCTION_PCTL, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 1, true, "Number", "Approximate percentile of the metric, the second argument ranges from 0 to 1"),
+	view.FUNCTION_PCTL_EXACT:       NewFunction(view.FUNCTION_PCTL_EXACT, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 1, true, "Number", "Exact percentile of the metric, the second argument ranges from 0 to 1"), // quantileExact需要保存组内全部取值，内存开销随行数线性增长
+	view.FUNCTION_UNIQ:             NewFunction(view.FUNCTION_UNIQ, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 0, false, "Number", "Approximate number of distinct values of the tags"),
+	view.FUNCTION_UNIQ_EXACT:       NewFunction(view.FUNCTION_UNIQ_EXACT, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 0, false, "Number", "Exact number of distinct values of the tags"),
+	view.FUNCTION_PERCENTAG:        NewFunction(view.FUNCTION_PERCENTAG, FUNCTION_TYPE_MATH, nil, "%", 1, true, "Number", "Percentage of the first argument to the second argument"),
+	view.FUNCTION_PERSECOND:        NewFunction(view.FUNCTION_PERSECOND, FUNCTION_TYPE_MATH, nil, "$unit/s", 0, true, "Number", "Value per second over the time interval"),
+	view.FUNCTION_HISTOGRAM:        NewFunction(view.FUNCTION_HISTOGRAM, FUNCTION_TYPE_MATH, nil, "", 1, true, "Number", "Histogram of the metric with the given number of bins"),
+	view.FUNCTION_ZSCORE:           NewFunction(view.FUNCTION_ZSCORE, FUNCTION_TYPE_MATH, nil, "", 0, true, "Number", "Z-score of the metric over all groups"),
+	view.FUNCTION_PERCENT_OF_TOTAL: NewFunction(view.FUNCTION_PERCENT_OF_TOTAL, FUNCTION_TYPE_MATH, nil, "", 0, true, "Number", "Fraction of the metric in the total over all groups, ranging from 0 to 1"),
+	view.FUNCTION_MOVING_AVG:       NewFunction(view.FUNCTION_MOVING_AVG, FUNCTION_TYPE_MATH, nil, "$unit", 1, true, "Number", "Moving average of the metric over the given number of time points"),
+	view.FUNCTION_SMOOTHED_PCTL:    NewFunction(view.FUNCTION_SMOOTHED_PCTL, FUNCTION_TYPE_MATH, nil, "$unit", 2, true, "Number", "Percentile of the metric in each time point, then moving average over the given number of time points"),
+	view.FUNCTION_ERROR_RATIO:      NewFunction(view.FUNCTION_ERROR_RATIO, FUNCTION_TYPE_MATH, nil, "", 0, true, "Number", "Ratio of error logs to all logs, null when there is no log"),
+	view.FUNCTION_LAST:             NewFunction(view.FUNCTION_LAST, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "", 0, true, "Number", "Last value of the metric, optionally ignoring zero with 'nonzero'"),
+	view.FUNCTION_FIRST:            NewFunction(view.FUNCTION_FIRST, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "", 0, true, "Number", "First value of the metric, optionally ignoring zero with 'nonzero'"),
+	view.FUNCTION_DELTA:            NewFunction(view.FUNCTION_DELTA, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE}, "$unit", 0, true, "Number", "Difference between the last and first value of the metric"),
+	view.FUNCTION_GEOMEAN:          NewFunction(view.FUNCTION_GEOMEAN, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number", "Geometric mean of the metric"),
+	view.FUNCTION_HARMMEAN:         NewFunction(view.FUNCTION_HARMMEAN, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER, METRICS_TYPE_GAUGE, METRICS_TYPE_DELAY, METRICS_TYPE_PERCENTAGE, METRICS_TYPE_QUOTIENT, METRICS_TYPE_BOUNDED_GAUGE}, "$unit", 0, true, "Number", "Harmonic mean of the metric"),
+	view.FUNCTION_TOPK:             NewFunction(view.FUNCTION_TOPK, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 1, false, "String", "Most frequent values of the tags, the last argument is the count"),
+	view.FUNCTION_ANY:              NewFunction(view.FUNCTION_ANY, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 0, false, "String", "Any value of the tags"),
+	view.FUNCTION_ARGMAX:           NewFunction(view.FUNCTION_ARGMAX, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 1, false, "String", "Value of the tag at the maximum of the metric"), // 第二个参数为取最大值的指标
+	view.FUNCTION_ARGMIN:           NewFunction(view.FUNCTION_ARGMIN, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 1, false, "String", "Value of the tag at the minimum of the metric"),
+	view.FUNCTION_DERIVATIVE:       NewFunction(view.FUNCTION_DERIVATIVE, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_COUNTER}, "$unit", 0, true, "Number", "Non-negative derivative of the counter"),
+	view.FUNCTION_COUNTDISTINCT:    NewFunction(view.FUNCTION_COUNTDISTINCT, FUNCTION_TYPE_AGG, []int{METRICS_TYPE_TAG}, "$unit", 0, false, "Number", "Number of distinct values of the tags"),
 }
 
 func GetFunctionDescriptions() (*common.Result, error) {
 	columns := []interface{}{
-		"name", "type", "support_metric_types", "unit_overwrite", "additional_param_count", "is_support_other_operators", "value_type",
+		"name", "type", "support_metric_types", "unit_overwrite", "additional_param_count", "is_support_other_operators", "value_type", "description",
 	}
 	var values []interface{}
 	for _, name := range METRICS_FUNCTIONS {
 		f := METRICS_FUNCTIONS_MAP[name]
 		values = append(values, []interface{}{
-			f.Name, f.Type, f.SupportMetricsTypes, f.UnitOverwrite, f.AdditionnalParamCount, f.IsSupportOtherOperators, f.ValueType,
+			f.Name, f.Type, f.SupportMetricsTypes, f.UnitOverwrite, f.AdditionnalParamCount, f.IsSupportOtherOperators, f.ValueType, f.Description,
 		})
 	}
 	return &common.Result{
@@ -237,7 +237,7 @@ func GetMetrics(field, db, table, orgID string, nativeField map[string]*Metrics,
 	}
 	if field == "time" {
 		metric := NewMetrics(
-			0, "time", field, "时间", field, "", "", "", METRICS_TYPE_NAME_MAP["delay"],
+			0, ckcommon.GetTimeColumn(db), field, "时间", field, "", "", "", METRICS_TYPE_NAME_MAP["delay"],
 			"Tag", []bool{true, true, true}, "", table, "", "", "", "time", "time",
 		)
 		return metric, true
@@ -117,3 +117,23 @@ func TestCheckDBField(t *testing.T) {
 		t.Errorf("clickhouse not has metrics")
 	}
 }
+
+func TestPreAggregationTrans(t *testing.T) {
+	p := &PreAggregation{Avg: "byte/packet", Max: "byte_max"}
+	counter := &Metrics{DBField: "byte", Type: METRICS_TYPE_COUNTER}
+	delay := &Metrics{DBField: "rtt_sum/rtt_count", Type: METRICS_TYPE_DELAY}
+	// 计数类的Avg按商值类计算，不修改原指标量
+	if m := p.Trans("Avg", counter); m.DBField != "byte/packet" || m.Type != METRICS_TYPE_QUOTIENT || counter.Type != METRICS_TYPE_COUNTER {
+		t.Errorf("avg of counter: get %+v", m)
+	}
+	// 时延类保留原类型
+	if m := p.Trans("Avg", delay); m.DBField != "byte/packet" || m.Type != METRICS_TYPE_DELAY {
+		t.Errorf("avg of delay: get %+v", m)
+	}
+	if m := p.Trans("Max", counter); m.DBField != "byte_max" || m.Type != METRICS_TYPE_COUNTER {
+		t.Errorf("max: get %+v", m)
+	}
+	if m := p.Trans("Sum", counter); m != counter {
+		t.Errorf("sum: get %+v", m)
+	}
+}
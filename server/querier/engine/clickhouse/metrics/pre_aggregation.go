@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+)
+
+type PreAggregationKey struct {
+	DB     string
+	Table  string
+	Metric string
+}
+
+// PreAggregation 预聚合表（如flow_metrics）中指标量的聚合方式，未定义的算子按指标量类型聚合
+type PreAggregation struct {
+	Avg string // 加权平均的分子/分母，如byte/packet，Avg按sum(分子)/sum(分母)计算
+	Max string // 存储最大值的列，如rtt_max，Max直接使用该列
+}
+
+// 由db_descriptions/clickhouse/pre_aggregation加载
+var PRE_AGGREGATION_MAP = map[PreAggregationKey]*PreAggregation{}
+
+// GetPreAggregation 查找指标量在预聚合表中的聚合方式，table中的数据源（如network.1m中的1m）被忽略
+func GetPreAggregation(db, table, metric string) (*PreAggregation, bool) {
+	table, _, _ = strings.Cut(strings.Trim(table, "`"), ".")
+	preAggregation, ok := PRE_AGGREGATION_MAP[PreAggregationKey{DB: db, Table: table, Metric: strings.Trim(metric, "`")}]
+	return preAggregation, ok
+}
+
+// Trans 返回算子name使用的指标量，有定义时返回修改后的副本，不修改原指标量
+func (p *PreAggregation) Trans(name string, metric *Metrics) *Metrics {
+	switch {
+	case name == view.FUNCTION_AVG && p.Avg != "":
+		// 单层为sum(分子)/sum(分母)，分层时同AAvg；时延、百分比及商值类保留原类型，
+		// 如时延类的Avg默认忽略0值，include_zero的处理不变，其他类型按商值类计算
+		weighted := *metric
+		weighted.DBField = p.Avg
+		if metric.Type != METRICS_TYPE_DELAY && metric.Type != METRICS_TYPE_PERCENTAGE && metric.Type != METRICS_TYPE_QUOTIENT {
+			weighted.Type = METRICS_TYPE_QUOTIENT
+		}
+		return &weighted
+	case name == view.FUNCTION_MAX && p.Max != "":
+		stored := *metric
+		stored.DBField = p.Max
+		return &stored
+	}
+	return metric
+}
+
+// LoadPreAggregations 加载预聚合表中指标量的聚合方式，每行格式为: db, table, metric, function, expression
+func LoadPreAggregations(data [][]interface{}) error {
+	preAggregations := map[PreAggregationKey]*PreAggregation{}
+	for _, line := range data {
+		if len(line) != 5 {
+			return fmt.Errorf("pre aggregation description %v is invalid, should be 'db, table, metric, function, expression'", line)
+		}
+		key := PreAggregationKey{DB: line[0].(string), Table: line[1].(string), Metric: line[2].(string)}
+		function, expression := line[3].(string), line[4].(string)
+		if key.DB == "" || key.Table == "" || key.Metric == "" || expression == "" {
+			return fmt.Errorf("pre aggregation description %v is invalid, db, table, metric and expression can't be empty", line)
+		}
+		preAggregation, ok := preAggregations[key]
+		if !ok {
+			preAggregation = &PreAggregation{}
+			preAggregations[key] = preAggregation
+		}
+		switch function {
+		case view.FUNCTION_AVG:
+			numerator, denominator, ok := strings.Cut(expression, "/")
+			if !ok || numerator == "" || denominator == "" || strings.Contains(denominator, "/") {
+				return fmt.Errorf("pre aggregation description %v is invalid, expression of %s should be 'numerator/denominator'", line, function)
+			}
+			preAggregation.Avg = expression
+		case view.FUNCTION_MAX:
+			if strings.Contains(expression, "/") {
+				return fmt.Errorf("pre aggregation description %v is invalid, expression of %s should be a column", line, function)
+			}
+			preAggregation.Max = expression
+		default:
+			return fmt.Errorf("pre aggregation description %v is invalid, function should be %s or %s", line, view.FUNCTION_AVG, view.FUNCTION_MAX)
+		}
+	}
+	PRE_AGGREGATION_MAP = preAggregations
+	return nil
+}
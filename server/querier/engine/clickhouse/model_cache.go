@@ -0,0 +1,347 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xwb1989/sqlparser"
+
+	"github.com/deepflowio/deepflow/server/libs/lru"
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/config"
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+	"github.com/deepflowio/deepflow/server/querier/parse"
+)
+
+var (
+	modelCacheOnce sync.Once
+	modelCacheIns  *ModelCache
+)
+
+// ModelCache 缓存编译后的Model，key为time范围常量替换为占位符后的sql，
+// 仪表盘中只有时间范围不同的查询命中后只需代入time常量，不再重新解析及拆层
+type ModelCache struct {
+	ModelCache *lru.Cache[string, *CompiledModel]
+	Lock       sync.Mutex
+}
+
+// CompiledModel 编译结果，SQL中的time常量为timePlaceholder(i)，
+// SQL为空表示编译结果依赖时间范围(如未按时间聚合的PerSecond)，不能复用；
+// 只缓存生成的sql及回调，不缓存Model、View，命中的查询之间不共享可变状态
+type CompiledModel struct {
+	SQL           string
+	ColumnSchemas []*common.ColumnSchema
+	Callbacks     map[string]func(*common.Result) error
+	SubViewLevels int
+	Table         string
+	DataSource    string
+}
+
+func NewModelCache(maxEntries int) *ModelCache {
+	return &ModelCache{ModelCache: lru.NewCache[string, *CompiledModel](maxEntries)}
+}
+
+// GetModelCache model-cache-size为0时不开启缓存，返回nil
+func GetModelCache() *ModelCache {
+	if config.Cfg == nil || config.Cfg.ModelCacheSize <= 0 {
+		return nil
+	}
+	modelCacheOnce.Do(func() {
+		modelCacheIns = NewModelCache(config.Cfg.ModelCacheSize)
+	})
+	return modelCacheIns
+}
+
+func (c *ModelCache) Get(key string) (value *CompiledModel, ok bool) {
+	c.Lock.Lock()
+	value, ok = c.ModelCache.Get(key)
+	c.Lock.Unlock()
+	return
+}
+
+func (c *ModelCache) Add(key string, value *CompiledModel) {
+	c.Lock.Lock()
+	c.ModelCache.Add(key, value)
+	c.Lock.Unlock()
+}
+
+func (c *ModelCache) Len() int {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.ModelCache.Len()
+}
+
+// modelCacheKey 影响编译结果的全部输入，格式化后作为ModelCache的key
+type modelCacheKey struct {
+	DB                string
+	DataSource        string
+	ORGID             string
+	Options           CompileOptions
+	DictVersion       uint64
+	DatasourceVersion uint64
+	SQL               string // time范围常量替换为占位符后的sql
+	// 预处理时从sql中去掉的语法
+	Settings      map[string]string
+	WithTotals    bool
+	Finals        []bool
+	TableFunction parse.TableFunction
+	GroupingSets  [][]int
+}
+
+// ParseCachedSQL 与compileSQL结果相同，优先从ModelCache中取编译结果
+func (e *CHEngine) ParseCachedSQL(sql string) (string, error) {
+	if e.ModelCache == nil || e.DB == chCommon.DB_NAME_PROMETHEUS {
+		return e.compileSQL(sql)
+	}
+	// 与compileSQL相同，经parse预处理后再解析
+	stmt, pre, err := parse.Prepare(sql)
+	if err != nil {
+		return e.compileSQL(sql)
+	}
+	selectStmt, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return e.compileSQL(sql)
+	}
+	timeFilters := e.collectTimeFilters(selectStmt)
+	times := make([]string, len(timeFilters))
+	timeRange := view.NewTime()
+	for i, filter := range timeFilters {
+		timestamp, _, err := e.parseTimeValue(sqlparser.String(filter.Right))
+		if err != nil {
+			return e.compileSQL(sql)
+		}
+		if isTimeStartOperator(filter.Operator) {
+			timeRange.AddTimeStart(timestamp)
+		} else {
+			timeRange.AddTimeEnd(timestamp)
+		}
+		times[i] = strconv.FormatInt(timestamp, 10)
+		filter.Right = sqlparser.NewValArg([]byte(fmt.Sprintf(":time_%d", i)))
+	}
+	if err := checkTimeRange(timeRange); err != nil {
+		return "", err
+	}
+	// 占位符不会出现在正常的sql中，出现时不缓存
+	if strings.Contains(sql, TIME_PLACEHOLDER_MARK) {
+		return e.compileSQL(sql)
+	}
+	cacheKey := modelCacheKey{
+		DB: e.DB, DataSource: e.DataSource, ORGID: e.ORGID, Options: e.CompileOptions,
+		DictVersion: e.DictCache.GetVersion(), DatasourceVersion: e.DatasourceCache.GetVersion(), SQL: sqlparser.String(selectStmt),
+		Settings: pre.Settings, WithTotals: pre.WithTotals, Finals: pre.Finals, GroupingSets: pre.GroupingSets,
+	}
+	if function := pre.TableFunction(); function != nil {
+		cacheKey.TableFunction = *function
+	}
+	key := fmt.Sprintf("%+v", cacheKey)
+	compiled, ok := e.ModelCache.Get(key)
+	if !ok {
+		compiled = e.compileTemplate(sql)
+		e.ModelCache.Add(key, compiled)
+	}
+	if compiled.SQL == "" {
+		return e.compileSQL(sql)
+	}
+	// 使用新的Model，只带本次查询的时间范围及回调
+	e.Model = view.NewModel()
+	e.Model.DB = e.DB
+	e.Model.Time = timeRange
+	for column, callback := range compiled.Callbacks {
+		e.Model.Callbacks[column] = callback
+	}
+	e.View = view.NewView(e.Model)
+	e.modelCacheHit = true
+	e.cachedLevels = compiled.SubViewLevels
+	e.Table = compiled.Table
+	e.DataSource = compiled.DataSource
+	e.ColumnSchemas = make([]*common.ColumnSchema, 0, len(compiled.ColumnSchemas))
+	for _, columnSchema := range compiled.ColumnSchemas {
+		schema := *columnSchema
+		e.ColumnSchemas = append(e.ColumnSchemas, &schema)
+	}
+	replacements := make([]string, 0, 2*len(times))
+	for i, t := range times {
+		replacements = append(replacements, timePlaceholder(i), t)
+	}
+	// 缓存的sql不含注释，注释不影响编译结果
+	return e.withComment(strings.NewReplacer(replacements...).Replace(compiled.SQL)), nil
+}
+
+// compileSQL 解析sql并生成clickhouse-sql
+func (e *CHEngine) compileSQL(sql string) (string, error) {
+	parser := parse.Parser{Engine: e, Context: e.Context, DefaultGroupOrder: e.DefaultGroupOrder}
+	if err := parser.ParseSQL(sql); err != nil {
+		return "", err
+	}
+	return e.compileModel(), nil
+}
+
+// compileStmt 与compileSQL相同，解析已预处理的select语句
+func (e *CHEngine) compileStmt(stmt *sqlparser.Select, pre *parse.Preparsed) (string, error) {
+	parser := parse.Parser{Engine: e, Context: e.Context, DefaultGroupOrder: e.DefaultGroupOrder}
+	if err := parser.ParseStmt(stmt, pre); err != nil {
+		return "", err
+	}
+	return e.compileModel(), nil
+}
+
+func (e *CHEngine) compileModel() string {
+	e.adjustTimeInterval()
+	for _, stmt := range e.Statements {
+		stmt.Format(e.Model)
+	}
+	e.splitPreWhere()
+	FormatModel(e.Model)
+	// 使用Model生成View
+	e.View = view.NewView(e.Model)
+	e.View.NoPreWhere = e.NoPreWhere
+	return e.ToSQLString()
+}
+
+// compileTemplate 用两组不同的占位时间各编译一次，替换占位值后结果一致说明sql只在time常量处依赖时间范围；
+// 解析会修改语法树，每次编译都重新预处理及解析sql
+func (e *CHEngine) compileTemplate(sql string) *CompiledModel {
+	var (
+		compileEngine *CHEngine
+		sqls          [2]string
+		placeholders  [2][]string
+	)
+	for round := 0; round < 2; round++ {
+		stmt, pre, err := parse.Prepare(sql)
+		if err != nil {
+			return &CompiledModel{}
+		}
+		selectStmt, ok := stmt.(*sqlparser.Select)
+		if !ok {
+			return &CompiledModel{}
+		}
+		for i, filter := range e.collectTimeFilters(selectStmt) {
+			// 开始时间的占位值小于结束时间，且两轮的时间范围长度不同
+			placeholder := strconv.Itoa(3000000000 + round*100000000 + i)
+			if !isTimeStartOperator(filter.Operator) {
+				placeholder = strconv.Itoa(3900000000 - round*100000000 + i)
+			}
+			placeholders[round] = append(placeholders[round], placeholder)
+			filter.Right = sqlparser.NewIntVal([]byte(placeholder))
+		}
+		compileEngine = e.newSubEngine()
+		compileEngine.Init()
+		chSql, err := compileEngine.compileStmt(selectStmt, pre)
+		if err != nil {
+			return &CompiledModel{}
+		}
+		sqls[round] = chSql
+	}
+	for round := range sqls {
+		for i := range placeholders[round] {
+			// 只替换完整的数字，占位值被改写(如Derivative前移开始时间)时无法代入
+			var count int
+			sqls[round], count = replaceNumber(sqls[round], placeholders[round][i], timePlaceholder(i))
+			if count == 0 {
+				return &CompiledModel{}
+			}
+		}
+	}
+	// time fill回调执行时读取Model中的时间范围，对齐时间范围时time常量会被改写；
+	// sql中恰好有与占位值相同的常量时两轮结果不同，不能复用
+	timeRange := compileEngine.Model.Time
+	if _, ok := compileEngine.Model.Callbacks["time"]; ok || timeRange.RequestedTimeStart != 0 || timeRange.RequestedTimeEnd != 0 || timeRange.RequestedInterval != 0 || sqls[0] != sqls[1] {
+		return &CompiledModel{}
+	}
+	return &CompiledModel{
+		SQL:           sqls[0],
+		ColumnSchemas: compileEngine.ColumnSchemas,
+		Callbacks:     compileEngine.Model.Callbacks,
+		SubViewLevels: len(compileEngine.View.SubViewLevels),
+		Table:         compileEngine.Table,
+		DataSource:    compileEngine.DataSource,
+	}
+}
+
+// 缓存的sql中time常量的占位符，包含正常sql中不会出现的字符
+const TIME_PLACEHOLDER_MARK = "\x00"
+
+func timePlaceholder(i int) string {
+	return fmt.Sprintf("%stime_%d%s", TIME_PLACEHOLDER_MARK, i, TIME_PLACEHOLDER_MARK)
+}
+
+// replaceNumber 将sql中完整的数字number替换为replacement，返回替换的次数，
+// 前后是数字或小数点时为更长数字的一部分，如30000000001，不替换
+func replaceNumber(sql, number, replacement string) (string, int) {
+	isNumberChar := func(c byte) bool {
+		return (c >= '0' && c <= '9') || c == '.'
+	}
+	var buf strings.Builder
+	count := 0
+	for {
+		start := strings.Index(sql, number)
+		if start < 0 {
+			break
+		}
+		end := start + len(number)
+		if (start > 0 && isNumberChar(sql[start-1])) || (end < len(sql) && isNumberChar(sql[end])) {
+			buf.WriteString(sql[:end])
+		} else {
+			buf.WriteString(sql[:start])
+			buf.WriteString(replacement)
+			count++
+		}
+		sql = sql[end:]
+	}
+	buf.WriteString(sql)
+	return buf.String(), count
+}
+
+// collectTimeFilters 只收集where顶层AND中的time范围条件，OR、NOT中的time常量保留在key中；
+// 与where的解析一致，time及库配置的时间列都是时间条件
+func (e *CHEngine) collectTimeFilters(stmt *sqlparser.Select) []*sqlparser.ComparisonExpr {
+	timeFilters := []*sqlparser.ComparisonExpr{}
+	if stmt.Where != nil {
+		collectTimeFilters(stmt.Where.Expr, chCommon.GetTimeColumn(e.DB), &timeFilters)
+	}
+	return timeFilters
+}
+
+func collectTimeFilters(expr sqlparser.Expr, timeColumn string, timeFilters *[]*sqlparser.ComparisonExpr) {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		collectTimeFilters(expr.Left, timeColumn, timeFilters)
+		collectTimeFilters(expr.Right, timeColumn, timeFilters)
+	case *sqlparser.ParenExpr:
+		collectTimeFilters(expr.Expr, timeColumn, timeFilters)
+	case *sqlparser.ComparisonExpr:
+		if !isTimeStartOperator(expr.Operator) && expr.Operator != "<=" && expr.Operator != "<" {
+			return
+		}
+		colName, ok := expr.Left.(*sqlparser.ColName)
+		if !ok {
+			return
+		}
+		if name := strings.Trim(chCommon.ParseAlias(colName), "`"); name == chCommon.DEFAULT_TIME_COLUMN || name == timeColumn {
+			*timeFilters = append(*timeFilters, expr)
+		}
+	}
+}
+
+func isTimeStartOperator(operator string) bool {
+	return operator == ">=" || operator == ">"
+}
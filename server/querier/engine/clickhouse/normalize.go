@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/metrics"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
+)
+
+// 表名及tag名由用户定义、需区分大小写的库
+var caseSensitiveDBs = []string{
+	chCommon.DB_NAME_DEEPFLOW_ADMIN, chCommon.DB_NAME_DEEPFLOW_TENANT, chCommon.DB_NAME_EXT_METRICS, chCommon.DB_NAME_PROMETHEUS,
+}
+
+// normalizeTableName 将与定义仅大小写不同的表名改写为定义中的表名，如L4_FLOW_LOG改写为l4_flow_log，
+// network.1m等带数据源的表名只改写表名部分
+func normalizeTableName(db, table string) string {
+	if slices.Contains(caseSensitiveDBs, db) {
+		return table
+	}
+	name, suffix, hasSuffix := strings.Cut(table, ".")
+	for _, dbTable := range chCommon.DB_TABLE_MAP[db] {
+		if dbTable == name {
+			return table
+		}
+		if strings.EqualFold(dbTable, name) {
+			name = dbTable
+			break
+		}
+	}
+	if hasSuffix {
+		return name + "." + suffix
+	}
+	return name
+}
+
+// NormalizeTagNames 将sql中与定义仅大小写不同的tag及指标名改写为定义中的名称，如BYTE改写为byte，
+// 只改写列名，不改写字符串常量及select中的别名，引用别名的列名也不改写
+func (e *CHEngine) NormalizeTagNames(stmt *sqlparser.Select) {
+	if slices.Contains(caseSensitiveDBs, e.DB) {
+		return
+	}
+	aliases := []string{}
+	for _, expr := range stmt.SelectExprs {
+		if item, ok := expr.(*sqlparser.AliasedExpr); ok && !item.As.IsEmpty() {
+			aliases = append(aliases, strings.Trim(chCommon.ParseAlias(item.As), "`"))
+		}
+	}
+	var nameIndex map[string]string
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		col, ok := node.(*sqlparser.ColName)
+		if !ok || !col.Qualifier.IsEmpty() {
+			return true, nil
+		}
+		name := col.Name.String()
+		if slices.Contains(aliases, name) || e.isKnownTag(name) {
+			return true, nil
+		}
+		// 出现未知列名时才生成索引
+		if nameIndex == nil {
+			nameIndex = e.tagNameIndex()
+		}
+		if canonical, ok := nameIndex[strings.ToLower(name)]; ok {
+			col.Name = sqlparser.NewColIdent(canonical)
+		}
+		return true, nil
+	}, stmt)
+}
+
+// tagNameIndex 返回当前表中tag及指标名的小写形式到定义中名称的映射，仅大小写不同的多个名称无法区分，不加入索引
+func (e *CHEngine) tagNameIndex() map[string]string {
+	// network.1m等带数据源的表使用表名部分的定义
+	table, _, _ := strings.Cut(e.Table, ".")
+	names := []string{}
+	for _, key := range tag.TAG_DESCRIPTION_KEYS {
+		if key.DB != e.DB || key.Table != table {
+			continue
+		}
+		description := tag.TAG_DESCRIPTIONS[key]
+		names = append(names, description.Name, description.ClientName, description.ServerName)
+	}
+	for name := range metrics.GetMetricsByDBTableStatic(e.DB, table, e.CustomMetrics) {
+		names = append(names, name)
+	}
+	for name := range e.NativeField {
+		names = append(names, name)
+	}
+
+	index := map[string]string{}
+	ambiguous := map[string]bool{}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		lower := strings.ToLower(name)
+		if canonical, ok := index[lower]; ok && canonical != name {
+			ambiguous[lower] = true
+		}
+		index[lower] = name
+	}
+	for lower := range ambiguous {
+		delete(index, lower)
+	}
+	return index
+}
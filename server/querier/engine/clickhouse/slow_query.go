@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/libs/logger"
+	"github.com/deepflowio/deepflow/server/querier/config"
+)
+
+var (
+	slowQueryLogOnce sync.Once
+	slowQueryLogIns  *SlowQueryLog
+)
+
+var (
+	stringLiteralRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`)
+	numberLiteralRegexp = regexp.MustCompile(`\b\d+(?:\.\d+)?(?:[eE][+-]?\d+)?\b`)
+)
+
+// SlowQuery 一次查询的耗时明细，Sql为用户输入的sql，ChSql为生成的ClickHouse sql
+type SlowQuery struct {
+	Time      time.Time `json:"time"`
+	QueryUUID string    `json:"query_uuid"`
+	ORGID     string    `json:"org_id"`
+	DB        string    `json:"db"`
+	Sql       string    `json:"sql"`
+	ChSql     string    `json:"ch_sql"`
+	ParseTime float64   `json:"parse_time"` // 单位：秒
+	QueryTime float64   `json:"query_time"` // 单位：秒
+	Rows      int       `json:"rows"`
+	Bytes     int       `json:"bytes"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// SlowQueryLog 记录解析与执行总耗时超过阈值的查询，写入独立的慢查询日志，
+// 并保留最近的size条供API查询，超出后淘汰最早的记录
+type SlowQueryLog struct {
+	threshold time.Duration
+	redact    bool
+	writer    io.Writer
+
+	lock    sync.Mutex
+	entries []SlowQuery
+	next    int // 下一条记录写入的位置
+	full    bool
+}
+
+func NewSlowQueryLog(threshold time.Duration, size int, redact bool, writer io.Writer) *SlowQueryLog {
+	if size <= 0 {
+		size = 1
+	}
+	return &SlowQueryLog{
+		threshold: threshold,
+		redact:    redact,
+		writer:    writer,
+		entries:   make([]SlowQuery, size),
+	}
+}
+
+// GetSlowQueryLog slow-query.threshold为0时不记录慢查询，返回nil
+func GetSlowQueryLog() *SlowQueryLog {
+	if config.Cfg == nil || config.Cfg.SlowQuery.Threshold <= 0 {
+		return nil
+	}
+	slowQueryLogOnce.Do(func() {
+		cfg := config.Cfg.SlowQuery
+		var writer io.Writer
+		if cfg.LogFile != "" {
+			w, err := logger.NewSizeRotatingWriter(cfg.LogFile, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups, 0, false)
+			if err != nil {
+				log.Errorf("open slow query log %s failed: %s", cfg.LogFile, err)
+			} else {
+				writer = w
+			}
+		}
+		slowQueryLogIns = NewSlowQueryLog(time.Duration(cfg.Threshold)*time.Millisecond, cfg.BufferSize, cfg.Redact, writer)
+	})
+	return slowQueryLogIns
+}
+
+// Record 总耗时未超过阈值时忽略，返回是否记录
+func (l *SlowQueryLog) Record(query SlowQuery) bool {
+	if l == nil {
+		return false
+	}
+	if time.Duration((query.ParseTime+query.QueryTime)*float64(time.Second)) < l.threshold {
+		return false
+	}
+	if l.redact {
+		query.Sql = RedactSQL(query.Sql)
+		query.ChSql = RedactSQL(query.ChSql)
+	}
+
+	l.lock.Lock()
+	l.entries[l.next] = query
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.lock.Unlock()
+
+	if l.writer != nil {
+		line, err := json.Marshal(query)
+		if err == nil {
+			_, err = l.writer.Write(append(line, '\n'))
+		}
+		if err != nil {
+			log.Errorf("write slow query log failed: %s", err)
+		}
+	} else {
+		log.Warningf("slow query: query_uuid: %s, sql: %s, ch_sql: %s, parse %.3fs, query %.3fs, %d rows, %d bytes",
+			query.QueryUUID, query.Sql, query.ChSql, query.ParseTime, query.QueryTime, query.Rows, query.Bytes)
+	}
+	return true
+}
+
+// List 按时间倒序返回orgID的缓存慢查询
+func (l *SlowQueryLog) List(orgID string) []SlowQuery {
+	if l == nil {
+		return []SlowQuery{}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	result := []SlowQuery{}
+	for i := 1; i <= count; i++ {
+		query := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if query.ORGID == orgID {
+			result = append(result, query)
+		}
+	}
+	return result
+}
+
+// RedactSQL 将sql中的字符串及数值常量替换为?
+func RedactSQL(sql string) string {
+	sql = stringLiteralRegexp.ReplaceAllString(sql, "'?'")
+	return numberLiteralRegexp.ReplaceAllString(sql, "?")
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/client"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewSlowQueryLog(100*time.Millisecond, 2, false, &buf)
+
+	// 未超过阈值不记录
+	if l.Record(SlowQuery{Sql: "fast", ParseTime: 0.01, QueryTime: 0.05}) {
+		t.Error("query under threshold is recorded")
+	}
+	// 解析与执行总耗时超过阈值时记录
+	for _, sql := range []string{"slow_1", "slow_2", "slow_3"} {
+		if !l.Record(SlowQuery{Sql: sql, ParseTime: 0.05, QueryTime: 0.05}) {
+			t.Errorf("%s is not recorded", sql)
+		}
+	}
+
+	// 超出缓存大小时淘汰最早的记录
+	var sqls []string
+	for _, q := range l.List("") {
+		sqls = append(sqls, q.Sql)
+	}
+	if want := []string{"slow_3", "slow_2"}; !reflect.DeepEqual(sqls, want) {
+		t.Errorf("list: get %v, want %v", sqls, want)
+	}
+
+	// 每条慢查询都写入日志
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("log: get %d lines, want 3", len(lines))
+	}
+	var logged SlowQuery
+	if err := json.Unmarshal([]byte(lines[0]), &logged); err != nil || logged.Sql != "slow_1" {
+		t.Errorf("log: get %s, err %v", lines[0], err)
+	}
+
+	// 只返回本org的慢查询
+	l = NewSlowQueryLog(100*time.Millisecond, 10, false, nil)
+	l.Record(SlowQuery{ORGID: "1", Sql: "org_1", QueryTime: 1})
+	l.Record(SlowQuery{ORGID: "2", Sql: "org_2", QueryTime: 1})
+	if queries := l.List("2"); len(queries) != 1 || queries[0].Sql != "org_2" {
+		t.Errorf("list org 2: get %+v", queries)
+	}
+
+	var nilLog *SlowQueryLog
+	if nilLog.Record(SlowQuery{QueryTime: 10}) || len(nilLog.List("")) != 0 {
+		t.Error("nil slow query log should record nothing")
+	}
+}
+
+func TestRedactSQL(t *testing.T) {
+	for _, tc := range []struct {
+		input  string
+		output string
+	}{{
+		input:  "select Sum(byte) as sum_byte from l4_flow_log where ip_0='10.1.1.1' and time>=1700000000 limit 10",
+		output: "select Sum(byte) as sum_byte from l4_flow_log where ip_0='?' and time>=? limit ?",
+	}, {
+		input:  "SELECT region_0 FROM flow_metrics.`network.1m` WHERE rtt > 0.5 AND pod_ns = 'it''s'",
+		output: "SELECT region_0 FROM flow_metrics.`network.1m` WHERE rtt > ? AND pod_ns = '?'",
+	}} {
+		if got := RedactSQL(tc.input); got != tc.output {
+			t.Errorf("RedactSQL(%s):\nget:  %s\nwant: %s", tc.input, got, tc.output)
+		}
+	}
+}
+
+func TestSlowQueryRecord(t *testing.T) {
+	Load()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	mockDatasources()
+	mockNativeFields()
+
+	// 模拟ClickHouse执行耗时
+	latency := 0 * time.Millisecond
+	var c *client.Client
+	guard := monkey.PatchInstanceMethod(reflect.TypeOf(c), "DoQuery", func(c *client.Client, params *client.QueryParams) (*common.Result, error) {
+		time.Sleep(latency)
+		c.Debug.Rows, c.Debug.Bytes = 1, 16
+		return &common.Result{Columns: []interface{}{"sum_byte"}, Values: []interface{}{[]interface{}{1}}}, nil
+	})
+	defer guard.Unpatch()
+
+	slowLog := NewSlowQueryLog(50*time.Millisecond, 10, true, nil)
+	sql := "select Sum(byte) as sum_byte from l4_flow_log where byte>100 limit 1"
+	execute := func() {
+		e := CHEngine{DB: "flow_log", SlowQueryLog: slowLog}
+		e.Init()
+		if _, _, err := e.ExecuteQuery(&common.QuerierParams{DB: "flow_log", Sql: sql, Context: context.Background(), QueryUUID: "uuid"}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	execute()
+	if n := len(slowLog.List(common.DEFAULT_ORG_ID)); n != 0 {
+		t.Fatalf("fast query: get %d slow queries, want 0", n)
+	}
+
+	latency = 100 * time.Millisecond
+	execute()
+	queries := slowLog.List(common.DEFAULT_ORG_ID)
+	if len(queries) != 1 {
+		t.Fatalf("slow query: get %d slow queries, want 1", len(queries))
+	}
+	q := queries[0]
+	if q.QueryUUID != "uuid" || q.DB != "flow_log" || q.Rows != 1 || q.Bytes != 16 {
+		t.Errorf("slow query: get %+v", q)
+	}
+	if q.QueryTime < latency.Seconds() {
+		t.Errorf("slow query: query time %f less than latency %f", q.QueryTime, latency.Seconds())
+	}
+	// 开启脱敏时不保留常量
+	if want := RedactSQL(sql); q.Sql != want {
+		t.Errorf("slow query: get sql %s, want %s", q.Sql, want)
+	}
+	if q.ChSql == "" || strings.Contains(q.ChSql, "100") {
+		t.Errorf("slow query: ch sql %s is not redacted", q.ChSql)
+	}
+}
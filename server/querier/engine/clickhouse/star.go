@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
+)
+
+// expandStar 把select中的*展开为当前表db_descriptions中的所有tag，其余列保持原位置
+func (e *CHEngine) expandStar(tags sqlparser.SelectExprs) (sqlparser.SelectExprs, error) {
+	starIndex := slices.IndexFunc(tags, func(expr sqlparser.SelectExpr) bool {
+		_, ok := expr.(*sqlparser.StarExpr)
+		return ok
+	})
+	if starIndex < 0 {
+		return tags, nil
+	}
+	names := e.starTagNames()
+	if len(names) == 0 {
+		return nil, fmt.Errorf("select *: no tags of table %s.%s", e.DB, e.Table)
+	}
+	expanded := make(sqlparser.SelectExprs, 0, len(tags)+len(names))
+	for _, expr := range tags {
+		if _, ok := expr.(*sqlparser.StarExpr); !ok {
+			expanded = append(expanded, expr)
+			continue
+		}
+		for _, name := range names {
+			expanded = append(expanded, &sqlparser.AliasedExpr{Expr: &sqlparser.ColName{Name: sqlparser.NewColIdent(name)}})
+		}
+	}
+	return expanded, nil
+}
+
+// starTagNames 按db_descriptions中的顺序返回*展开的tag，区分客户端、服务端的tag展开为两列；
+// 已废弃、不支持select的tag（如同时匹配两端的tunnel_tx_ip）及需要指定key的map_item不展开
+func (e *CHEngine) starTagNames() []string {
+	// network.1m等带数据源的表使用表名部分的定义
+	table, _, _ := strings.Cut(strings.Trim(e.Table, "`"), ".")
+	names := []string{}
+	added := map[string]bool{}
+	add := func(name string) {
+		if name != "" && !added[name] {
+			added[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, key := range tag.TAG_DESCRIPTION_KEYS {
+		if key.DB != e.DB || key.Table != table {
+			continue
+		}
+		description := tag.TAG_DESCRIPTIONS[key]
+		if description.Deprecated || description.Type == "map_item" || slices.Contains(description.NotSupportedOperators, "select") {
+			continue
+		}
+		add(description.ClientName)
+		add(description.ServerName)
+	}
+	return names
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/client"
+)
+
+// ExecuteQueryStream 流式执行查询，结果按行交给handler，不在内存中保留完整结果，用于导出大量数据；
+// 未设置args.MaxRows时最多返回client.DEFAULT_STREAM_MAX_ROWS行，超过时返回*client.RowLimitExceededError。
+// show查询及time()补点的查询需要完整结果，不支持流式执行
+func (e *CHEngine) ExecuteQueryStream(args *common.QuerierParams, handler *client.RowHandler) (map[string]interface{}, error) {
+	sql, cancel, err := e.initQuery(args)
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+	if fields := strings.Fields(sql); len(fields) > 0 && strings.ToLower(fields[0]) == "show" {
+		return nil, errors.New("show sql does not support streaming")
+	}
+	parseStart := time.Now()
+	chSql, callbacks, columnSchemaMap, err := e.parseStreamSql(sql, args)
+	parseTime := time.Since(parseStart)
+	if err != nil {
+		log.Errorf("sql: %s; parse error: %s", sql, err)
+		return nil, err
+	}
+	if _, ok := callbacks["time"]; ok {
+		return nil, errors.New("time fill requires the full result and does not support streaming")
+	}
+
+	debug_info := &client.DebugInfo{}
+	debug := &client.Debug{
+		IP:        config.Cfg.Clickhouse.Host,
+		QueryUUID: args.QueryUUID,
+		Sql:       chSql,
+	}
+	chClient := client.Client{
+		Host:     config.Cfg.Clickhouse.Host,
+		Port:     config.Cfg.Clickhouse.Port,
+		UserName: config.Cfg.Clickhouse.User,
+		Password: config.Cfg.Clickhouse.Password,
+		DB:       e.DB,
+		Debug:    debug,
+		Context:  e.Context,
+	}
+	params := &client.QueryParams{
+		Sql:             chSql,
+		UseQueryCache:   args.UseQueryCache,
+		QueryCacheTTL:   args.QueryCacheTTL,
+		Callbacks:       callbacks,
+		QueryUUID:       args.QueryUUID,
+		ColumnSchemaMap: columnSchemaMap,
+		ORGID:           args.ORGID,
+		MaxRows:         args.MaxRows,
+	}
+	err = e.executeAndRecord(queryRecord{
+		db: e.DB, table: e.Table, queryUUID: args.QueryUUID,
+		sql: sql, chSql: chSql, parseStart: parseStart, parseTime: parseTime, debug: debug,
+	}, func() (int, error) {
+		err := chClient.DoQueryStream(params, handler)
+		return debug.Rows, err
+	})
+	debug_info.Debug = append(debug_info.Debug, *debug)
+	if err != nil {
+		log.Error(err)
+		return debug_info.Get(), err
+	}
+	return debug_info.Get(), nil
+}
+
+// parseStreamSql 依次按with、union、slimit及普通查询翻译sql，返回clickhouse-sql、回调及列的schema
+func (e *CHEngine) parseStreamSql(sql string, args *common.QuerierParams) (string, map[string]func(*common.Result) error, map[string]*common.ColumnSchema, error) {
+	parsers := []func(string) (string, map[string]func(*common.Result) error, map[string]*common.ColumnSchema, error){
+		e.ParseWithSql,
+		e.ParseUnionSql,
+		func(sql string) (string, map[string]func(*common.Result) error, map[string]*common.ColumnSchema, error) {
+			return e.ParseSlimitSql(sql, args)
+		},
+	}
+	for _, parser := range parsers {
+		chSql, callbacks, columnSchemaMap, err := parser(sql)
+		if err != nil || chSql != "" {
+			return chSql, callbacks, columnSchemaMap, err
+		}
+	}
+	chSql, err := e.ParseCachedSQL(sql)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	columnSchemaMap := make(map[string]*common.ColumnSchema)
+	for _, columnSchema := range e.ColumnSchemas {
+		columnSchemaMap[columnSchema.Name] = columnSchema
+	}
+	return chSql, e.View.GetCallbacks(), columnSchemaMap, nil
+}
@@ -226,7 +226,13 @@ func GetTagTranslator(name, alias string, e *CHEngine) ([]Statement, string, err
 			stmts = append(stmts, &SelectTag{Value: name})
 		} else if tagItem.TagTranslator != "" {
 			if name != "packet_batch" || table != "l4_packet" {
-				stmts = append(stmts, &SelectTag{Value: tagItem.TagTranslator, Alias: selectTag})
+				tagTranslator := tagItem.TagTranslator
+				if e.DictCache != nil && e.isDictCacheTag(name) {
+					if inlineTranslator, ok := e.DictCache.Translate(e.Context, e.ORGID, tagTranslator); ok {
+						tagTranslator = inlineTranslator
+					}
+				}
+				stmts = append(stmts, &SelectTag{Value: tagTranslator, Alias: selectTag})
 				stmts = GetMultiTag(stmts, name)
 			}
 		} else if alias != "" {
@@ -238,6 +244,22 @@ func GetTagTranslator(name, alias string, e *CHEngine) ([]Statement, string, err
 	return stmts, labelType, nil
 }
 
+// TransIDTag db_descriptions中标注了IDTranslation的资源，id tag查询及分组时使用资源名称的翻译，如region_id -> region
+func (e *CHEngine) TransIDTag(name string) string {
+	nameNoBackQuote := strings.Trim(name, "`")
+	nameTag := resourceNameTag(nameNoBackQuote)
+	if nameTag == nameNoBackQuote {
+		return name
+	}
+	// network.1m等按数据精度区分的表使用network的描述
+	table := strings.Split(e.Table, ".")[0]
+	description := e.getTagDescription(table, nameTag)
+	if description == nil || description.Type != "resource" || !description.IDTranslation {
+		return name
+	}
+	return nameTag
+}
+
 func GetPrometheusSingleTagTranslator(tag string, e *CHEngine) (string, string, error) {
 	table := e.Table
 	labelType := ""
@@ -317,18 +339,40 @@ func GetDefaultTag(name string, alias string) Statement {
 	return &SelectTag{Value: name, Alias: alias}
 }
 
+// SelectIndex 之后Format的列在select中的位置，为0时表示不是select的列
+type SelectIndex struct {
+	Index int
+}
+
+func (s *SelectIndex) Format(m *view.Model) {
+	m.SelectIndex = s.Index
+}
+
+// Distinct Raws为tag翻译前的原始列，为空时直接对select的结果去重
+type Distinct struct {
+	Raws []*GroupTag
+}
+
+func (d *Distinct) Format(m *view.Model) {
+	m.SetDistinct()
+	for _, raw := range d.Raws {
+		m.AddDistinctRaw(&view.Tag{Value: raw.Value, Alias: raw.Alias, Withs: raw.Withs})
+	}
+}
+
 type SelectTag struct {
-	Value string
-	Alias string
-	Flag  int
-	Withs []view.Node
+	Value     string
+	Alias     string
+	Flag      int
+	Withs     []view.Node
+	ArrayJoin bool
 }
 
 func (t *SelectTag) Format(m *view.Model) {
 	if slices.Contains(tag.AUTO_CUSTOM_TAG_NAMES, strings.Trim(t.Value, "`")) {
 		m.AddCallback(strings.Trim(t.Value, "`"), ColumnNameSwap([]interface{}{strings.Trim(t.Value, "`")}))
 	} else {
-		m.AddTag(&view.Tag{Value: t.Value, Alias: t.Alias, Flag: t.Flag, Withs: t.Withs})
+		m.AddTag(&view.Tag{Value: t.Value, Alias: t.Alias, Flag: t.Flag, Withs: t.Withs, ArrayJoin: t.ArrayJoin})
 		if common.IsValueInSliceString(t.Value, []string{"tap_port", "capture_nic", "mac_0", "mac_1", "tunnel_tx_mac_0", "tunnel_tx_mac_1", "tunnel_rx_mac_0", "tunnel_rx_mac_1"}) {
 			alias := t.Value
 			if t.Alias != "" {
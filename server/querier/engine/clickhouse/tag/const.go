@@ -56,6 +56,9 @@ var TAG_RESOURCE_TYPE_DEFAULT = []string{
 }
 var TAG_RESOURCE_TYPE_AUTO = []string{"auto_instance", "auto_service"}
 
+// 数组类型的tag，按其分组时在计算层内层通过arrayJoin展开为元素
+var TAG_ARRAY = []string{"acl_gids"}
+
 var AutoMap = map[string]int{
 	"chost":       VIF_DEVICE_TYPE_VM,
 	"router":      VIF_DEVICE_TYPE_VROUTER,
@@ -103,6 +103,9 @@ type TagDescription struct {
 	RelatedTag            string
 	Deprecated            bool
 	NotSupportedOperators []string
+	TranslationCache      bool   // 翻译结果可由querier缓存后内联
+	NullValue             string // 未知值的取值，如0或''，为空时使用NULL
+	IDTranslation         bool   // 资源的id tag显示为资源名称，如region_id显示为region
 	Table                 string
 }
 
@@ -186,7 +189,10 @@ func LoadTagDescriptions(tagData map[string]interface{}) error {
 				// 9 - RelatedTag
 				// 10 - Deprecated
 				// 11 - NotSupportedOperators
-				// 12 - Table
+				// 12 - TranslationCache
+				// 13 - NullValue
+				// 14 - Table
+				// 15 - IDTranslation
 
 				permissions, err := ckcommon.ParsePermission(tag[6])
 				if err != nil {
@@ -214,6 +220,12 @@ func LoadTagDescriptions(tagData map[string]interface{}) error {
 				if len(tag) >= 9 {
 					notSupportedOperators = ckcommon.ParseNotSupportedOperator(tag[8])
 				}
+				translationCache := len(tag) >= 10 && tag[9].(string) == "1"
+				nullValue := ""
+				if len(tag) >= 11 && strings.ToLower(tag[10].(string)) != "null" {
+					nullValue = tag[10].(string)
+				}
+				idTranslation := len(tag) >= 12 && tag[11].(string) == "1"
 				key := TagDescriptionKey{DB: db, Table: table, TagName: tag[0].(string)}
 				tagLanguage := dbTagData.(map[string]interface{})[table+"."+config.Cfg.Language].([][]interface{})[i]
 				tagLanguageZH := dbTagData.(map[string]interface{})[table+".ch"].([][]interface{})[i]
@@ -231,6 +243,9 @@ func LoadTagDescriptions(tagData map[string]interface{}) error {
 					tag[0].(string), tag[1].(string), tag[2].(string), displayName, displayNameZH, displayNameEN,
 					tag[3].(string), enumFile, tag[5].(string), permissions, des, desZH, desEN, "", deprecated, notSupportedOperators, table,
 				)
+				description.TranslationCache = translationCache
+				description.NullValue = nullValue
+				description.IDTranslation = idTranslation
 				TAG_DESCRIPTIONS[key] = description
 				enumFileToTagType[enumFile] = tag[3].(string)
 			}
@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/client"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
+)
+
+var (
+	tagDictCacheOnce sync.Once
+	tagDictCacheIns  *TagDictCache
+)
+
+// 可内联的字典翻译，如dictGet('flow_tag.region_map', 'name', (toUInt64(region_id_0)))
+var dictGetRegexp = regexp.MustCompile(`^dictGet\('flow_tag\.(\w+)', '(\w+)', \(toUInt64\((\w+)\)\)\)$`)
+
+// TagDictSource 读取字典的id及对应字段值，最多读取limit条
+type TagDictSource func(ctx context.Context, orgID, dictionary, field string, limit int) (map[uint64]string, error)
+
+type tagDictEntry struct {
+	Values   map[uint64]string // 为nil表示字典条数超过MaxEntries，不内联
+	LoadTime time.Time
+}
+
+// TagDictCache 缓存低基数字典(如region_map)的内容，翻译tag时以transform()常量映射替代dictGet，
+// 字典条数超过MaxEntries、读取失败或缓存过期后刷新失败时仍使用dictGet
+type TagDictCache struct {
+	Source      TagDictSource
+	TTL         time.Duration
+	MaxEntries  int
+	LoadTimeout time.Duration    // 读取字典的超时时间，为0时不设置超时
+	Now         func() time.Time // 为空时使用time.Now
+	Entries     map[string]*tagDictEntry
+	Version     uint64 // 重新读取或清理字典时递增，用于区分ModelCache中的编译结果
+	Lock        sync.Mutex
+	loads       singleflight.Group // 同一字典并发未命中时只读取一次
+}
+
+func NewTagDictCache(source TagDictSource, ttl time.Duration, maxEntries int, loadTimeout time.Duration) *TagDictCache {
+	return &TagDictCache{
+		Source:      source,
+		TTL:         ttl,
+		MaxEntries:  maxEntries,
+		LoadTimeout: loadTimeout,
+		Entries:     map[string]*tagDictEntry{},
+	}
+}
+
+// GetTagDictCache tag-dict-cache-ttl为0时不开启缓存，返回nil
+func GetTagDictCache() *TagDictCache {
+	if config.Cfg == nil || config.Cfg.TagDictCacheTTL <= 0 {
+		return nil
+	}
+	tagDictCacheOnce.Do(func() {
+		tagDictCacheIns = NewTagDictCache(
+			ClickhouseTagDictSource, time.Duration(config.Cfg.TagDictCacheTTL)*time.Second, config.Cfg.TagDictCacheMaxEntries,
+			time.Duration(config.Cfg.TagDictCacheLoadTimeout)*time.Second,
+		)
+	})
+	return tagDictCacheIns
+}
+
+func (c *TagDictCache) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// Get 返回字典内容，缓存过期时重新读取；同一字典并发未命中时只读取一次，
+// 各调用方按自己的ctx放弃等待，读取本身只受LoadTimeout限制，不因某个查询取消而失败
+func (c *TagDictCache) Get(ctx context.Context, orgID, dictionary, field string) (map[uint64]string, bool) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	key := orgID + "|" + dictionary + "|" + field
+	c.Lock.Lock()
+	entry, ok := c.Entries[key]
+	c.Lock.Unlock()
+	if ok && c.now().Sub(entry.LoadTime) < c.TTL {
+		return entry.Values, entry.Values != nil
+	}
+	loadCtx := context.WithoutCancel(ctx)
+	ch := c.loads.DoChan(key, func() (interface{}, error) {
+		return c.load(loadCtx, key, orgID, dictionary, field)
+	})
+	select {
+	case result := <-ch:
+		if result.Err != nil {
+			return nil, false
+		}
+		values := result.Val.(map[uint64]string)
+		return values, values != nil
+	case <-ctx.Done():
+		log.Warningf("load dictionary %s canceled: %s", dictionary, ctx.Err())
+		return nil, false
+	}
+}
+
+// load 读取字典并写入缓存，条数超过MaxEntries时缓存nil
+func (c *TagDictCache) load(ctx context.Context, key, orgID, dictionary, field string) (map[uint64]string, error) {
+	if c.LoadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.LoadTimeout)
+		defer cancel()
+	}
+	now := c.now()
+	values, err := c.Source(ctx, orgID, dictionary, field, c.MaxEntries+1)
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	if err != nil {
+		// 过期的内容不再使用
+		log.Warningf("load dictionary %s failed: %s", dictionary, err)
+		delete(c.Entries, key)
+		return nil, err
+	}
+	if len(values) > c.MaxEntries {
+		values = nil
+	}
+	c.Entries[key] = &tagDictEntry{Values: values, LoadTime: now}
+	c.Version++
+	return values, nil
+}
+
+// Translate 将dictGet翻译替换为transform()，不能内联时返回false
+func (c *TagDictCache) Translate(ctx context.Context, orgID, translator string) (string, bool) {
+	match := dictGetRegexp.FindStringSubmatch(translator)
+	if match == nil {
+		return "", false
+	}
+	values, ok := c.Get(ctx, orgID, match[1], match[2])
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	ids := make([]uint64, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	idStrs := make([]string, len(ids))
+	valueStrs := make([]string, len(ids))
+	for i, id := range ids {
+		idStrs[i] = strconv.FormatUint(id, 10)
+		valueStrs[i] = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(values[id]) + "'"
+	}
+	// dictGet查不到id时返回空字符串，transform的默认值与其一致
+	return fmt.Sprintf(
+		"transform(toUInt64(%s), [%s], [%s], '')", match[3], strings.Join(idStrs, ","), strings.Join(valueStrs, ","),
+	), true
+}
+
+// GetVersion 先清理过期的字典，使引用过期内容的编译结果不再命中，为nil时返回0
+func (c *TagDictCache) GetVersion() uint64 {
+	if c == nil {
+		return 0
+	}
+	now := c.now()
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	for key, entry := range c.Entries {
+		if now.Sub(entry.LoadTime) >= c.TTL {
+			delete(c.Entries, key)
+			c.Version++
+		}
+	}
+	return c.Version
+}
+
+// isDictCacheTag db_descriptions中TranslationCache为1的tag才允许内联
+func (e *CHEngine) isDictCacheTag(name string) bool {
+	description := e.getTagDescription(e.Table, name)
+	return description != nil && description.TranslationCache
+}
+
+func (e *CHEngine) getTagDescription(table, name string) *tag.TagDescription {
+	name = strings.Trim(name, "`")
+	for _, key := range tag.TAG_DESCRIPTION_KEYS {
+		if key.DB != e.DB || key.Table != table {
+			continue
+		}
+		description := tag.TAG_DESCRIPTIONS[key]
+		if name == description.Name || name == description.ClientName || name == description.ServerName {
+			return description
+		}
+	}
+	return nil
+}
+
+func ClickhouseTagDictSource(ctx context.Context, orgID, dictionary, field string, limit int) (map[uint64]string, error) {
+	chClient := client.Client{
+		Host:     config.Cfg.Clickhouse.Host,
+		Port:     config.Cfg.Clickhouse.Port,
+		UserName: config.Cfg.Clickhouse.User,
+		Password: config.Cfg.Clickhouse.Password,
+		DB:       "flow_tag",
+		Context:  ctx,
+	}
+	sql := fmt.Sprintf("SELECT toUInt64(id) AS id, toString(%s) AS value FROM flow_tag.%s LIMIT %d", field, dictionary, limit)
+	rst, err := chClient.DoQuery(&client.QueryParams{Sql: sql, ORGID: orgID})
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[uint64]string, len(rst.Values))
+	for _, v := range rst.Values {
+		row := v.([]interface{})
+		id, ok := row[0].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("dictionary %s id %v is not uint64", dictionary, row[0])
+		}
+		values[id], _ = row[1].(string)
+	}
+	return values, nil
+}
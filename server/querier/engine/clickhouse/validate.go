@@ -0,0 +1,330 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clickhouse
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/metrics"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+	"github.com/deepflowio/deepflow/server/querier/parse"
+)
+
+// Validate错误类型
+const (
+	VALIDATE_ERROR_SYNTAX           = "SYNTAX_ERROR"
+	VALIDATE_ERROR_UNKNOWN_TAG      = "UNKNOWN_TAG"
+	VALIDATE_ERROR_UNKNOWN_FUNCTION = "UNKNOWN_FUNCTION"
+	VALIDATE_ERROR_ARGUMENT_COUNT   = "INVALID_ARGUMENT_COUNT"
+	VALIDATE_ERROR_INVALID_SQL      = "INVALID_SQL"
+)
+
+// 参数个数不固定的算子，不校验参数个数
+var variadicArgsFunctions = []string{
+	view.FUNCTION_TOPK, view.FUNCTION_ANY, view.FUNCTION_UNIQ, view.FUNCTION_UNIQ_EXACT,
+	view.FUNCTION_COUNTDISTINCT, view.FUNCTION_DERIVATIVE, view.FUNCTION_LAST, view.FUNCTION_FIRST,
+}
+
+// map类型及动态tag的前缀，无法静态校验
+var dynamicTagPrefixes = []string{"tag.", "attribute.", "tag_string.", "tag_int.", "custom_tag."}
+
+type ValidateError struct {
+	Type    string // 错误类型
+	Name    string // 出错的tag或函数
+	Message string
+	Err     error // 语法错误时为*parse.ParseError，包含出错的位置
+}
+
+func (e *ValidateError) Error() string {
+	return e.Message
+}
+
+func (e *ValidateError) Unwrap() error {
+	return e.Err
+}
+
+func newValidateError(errType, name, message string) error {
+	return &ValidateError{Type: errType, Name: name, Message: message}
+}
+
+func newSyntaxError(err error) error {
+	return &ValidateError{Type: VALIDATE_ERROR_SYNTAX, Message: err.Error(), Err: err}
+}
+
+// 未注册的函数名，编辑距离足够小时给出拼写提示
+func newUnknownFunctionError(name string) error {
+	message := fmt.Sprintf("unknown function \"%s\"", name)
+	if suggestion := suggestFunctionName(name); suggestion != "" {
+		message += fmt.Sprintf("; did you mean \"%s\"?", suggestion)
+	}
+	return newValidateError(VALIDATE_ERROR_UNKNOWN_FUNCTION, name, message)
+}
+
+func registeredFunctionNames() []string {
+	names := []string{view.FUNCTION_DERIVATIVE}
+	for name := range metrics.METRICS_FUNCTIONS_MAP {
+		names = append(names, name)
+	}
+	names = append(names, TAG_FUNCTIONS...)
+	names = append(names, GROUP_EXPR_FUNCTIONS...)
+	for _, name := range view.MATH_FUNCTIONS {
+		// + - * / 等运算符不会以函数名的形式出现
+		if strings.ToUpper(name) != strings.ToLower(name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func isRegisteredFunction(name string) bool {
+	_, ok := slices.BinarySearch(registeredFunctionNames(), name)
+	return ok
+}
+
+func suggestFunctionName(name string) string {
+	suggestion := ""
+	// 最多允许2处编辑，且不超过函数名长度的一半
+	minDistance := min(2, len(name)/2) + 1
+	for _, registered := range registeredFunctionNames() {
+		distance := levenshteinDistance(strings.ToLower(name), strings.ToLower(registered))
+		if distance < minDistance {
+			suggestion, minDistance = registered, distance
+		}
+	}
+	return suggestion
+}
+
+func levenshteinDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// Validate 检查sql语法、函数及tag是否存在，只解析不生成可执行的sql
+func (e *CHEngine) Validate(sql string) error {
+	if e.Model == nil {
+		e.Init()
+	}
+	stmt, _, err := parse.Prepare(sql)
+	if err != nil {
+		var parseErr *parse.ParseError
+		if errors.As(err, &parseErr) {
+			return newSyntaxError(err)
+		}
+		return newValidateError(VALIDATE_ERROR_SYNTAX, "", err.Error())
+	}
+	selectStmt, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return newValidateError(VALIDATE_ERROR_SYNTAX, "", fmt.Sprintf("sql: '%s' is not a select statement", sql))
+	}
+	for _, expr := range selectStmt.SelectExprs {
+		if item, ok := expr.(*sqlparser.AliasedExpr); ok {
+			if err := validateFunctions(item.Expr); err != nil {
+				return err
+			}
+		}
+	}
+	parser := parse.Parser{Engine: e, Context: e.Context, DefaultGroupOrder: e.DefaultGroupOrder}
+	parseErr := parser.ParseSQL(sql)
+	// from解析后才能确定tag所属的表，未知的表由parseErr返回
+	if slices.Contains(chCommon.DB_TABLE_MAP[e.DB], e.Table) {
+		e.NormalizeTagNames(selectStmt)
+		if err := e.validateTags(selectStmt); err != nil {
+			return err
+		}
+	}
+	if parseErr != nil {
+		return newValidateError(VALIDATE_ERROR_INVALID_SQL, "", parseErr.Error())
+	}
+	return nil
+}
+
+func validateFunctions(expr sqlparser.Expr) error {
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return validateFunctions(expr.Expr)
+	case *sqlparser.BinaryExpr:
+		if err := validateFunctions(expr.Left); err != nil {
+			return err
+		}
+		return validateFunctions(expr.Right)
+	case *sqlparser.FuncExpr:
+		name := strings.Trim(sqlparser.String(expr.Name), "`")
+		// Derivative只作为聚合算子的参数，由parseFunction展开
+		if name == "Derivative" {
+			return nil
+		}
+		function, isMetricsFunction := metrics.METRICS_FUNCTIONS_MAP[name]
+		if !isMetricsFunction && !common.IsValueInSliceString(name, TAG_FUNCTIONS) && !common.IsValueInSliceString(name, view.MATH_FUNCTIONS) && !isGroupExprFunction(name) {
+			return newUnknownFunctionError(name)
+		}
+		// Count()及Count(distinct tag)由parseFunction改写为Sum(log_count)及Uniq(tag)
+		isCountAlias := name == view.FUNCTION_COUNT && (len(expr.Exprs) == 0 || expr.Distinct)
+		if isMetricsFunction && function.Type == metrics.FUNCTION_TYPE_AGG && !slices.Contains(variadicArgsFunctions, name) && !isCountAlias {
+			argCount := 1 + function.AdditionnalParamCount
+			if len(expr.Exprs) != argCount {
+				return newValidateError(
+					VALIDATE_ERROR_ARGUMENT_COUNT, name,
+					fmt.Sprintf("function [%s] requires %d argument(s), got %d", name, argCount, len(expr.Exprs)),
+				)
+			}
+		}
+		for _, arg := range expr.Exprs {
+			if item, ok := arg.(*sqlparser.AliasedExpr); ok {
+				if err := validateFunctions(item.Expr); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (e *CHEngine) validateTags(selectStmt *sqlparser.Select) error {
+	aliases := []string{}
+	for _, expr := range selectStmt.SelectExprs {
+		if item, ok := expr.(*sqlparser.AliasedExpr); ok && !item.As.IsEmpty() {
+			aliases = append(aliases, strings.Trim(chCommon.ParseAlias(item.As), "`"))
+		}
+	}
+	for _, expr := range selectStmt.SelectExprs {
+		if item, ok := expr.(*sqlparser.AliasedExpr); ok {
+			if err := e.validateSelectTags(item.Expr); err != nil {
+				return err
+			}
+		}
+	}
+	for _, group := range selectStmt.GroupBy {
+		colName, ok := group.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		name := strings.Trim(chCommon.ParseAlias(colName), "`")
+		if slices.Contains(aliases, name) {
+			continue
+		}
+		if !e.isKnownTag(name) {
+			return newValidateError(VALIDATE_ERROR_UNKNOWN_TAG, name, fmt.Sprintf("tag: %s not found in %s.%s", name, e.DB, e.Table))
+		}
+	}
+	return nil
+}
+
+func (e *CHEngine) validateSelectTags(expr sqlparser.Expr) error {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		name := strings.Trim(chCommon.ParseAlias(expr), "`")
+		if !e.isKnownTag(name) {
+			return newValidateError(VALIDATE_ERROR_UNKNOWN_TAG, name, fmt.Sprintf("tag: %s not found in %s.%s", name, e.DB, e.Table))
+		}
+	case *sqlparser.ParenExpr:
+		return e.validateSelectTags(expr.Expr)
+	case *sqlparser.BinaryExpr:
+		if err := e.validateSelectTags(expr.Left); err != nil {
+			return err
+		}
+		return e.validateSelectTags(expr.Right)
+	case *sqlparser.FuncExpr:
+		name := strings.Trim(sqlparser.String(expr.Name), "`")
+		function, ok := metrics.METRICS_FUNCTIONS_MAP[name]
+		if !ok || function.Type != metrics.FUNCTION_TYPE_AGG || slices.Contains(variadicArgsFunctions, name) || len(expr.Exprs) == 0 || expr.Distinct {
+			for _, arg := range expr.Exprs {
+				if item, ok := arg.(*sqlparser.AliasedExpr); ok {
+					if _, isColName := item.Expr.(*sqlparser.ColName); isColName {
+						// tag函数及数学运算的参数可能是常量或关键字，仅递归校验嵌套的函数
+						continue
+					}
+					if err := e.validateSelectTags(item.Expr); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+		// 聚合算子的第一个参数为指标量
+		item, ok := expr.Exprs[0].(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil
+		}
+		colName, ok := item.Expr.(*sqlparser.ColName)
+		if !ok {
+			return e.validateSelectTags(item.Expr)
+		}
+		metricName := strings.Trim(chCommon.ParseAlias(colName), "`")
+		if _, ok := metrics.GetAggMetrics(metricName, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics); !ok {
+			return newValidateError(VALIDATE_ERROR_UNKNOWN_TAG, metricName, fmt.Sprintf("metric: %s not found in %s.%s", metricName, e.DB, e.Table))
+		}
+	}
+	return nil
+}
+
+func (e *CHEngine) isKnownTag(name string) bool {
+	// native tag的库中tag是动态的
+	if slices.Contains([]string{chCommon.DB_NAME_DEEPFLOW_ADMIN, chCommon.DB_NAME_DEEPFLOW_TENANT, chCommon.DB_NAME_EXT_METRICS, chCommon.DB_NAME_PROMETHEUS}, e.DB) {
+		return true
+	}
+	if _, ok := tag.GetTag(name, e.DB, e.Table, "default"); ok {
+		return true
+	}
+	if _, ok := metrics.GetMetrics(name, e.DB, e.Table, e.ORGID, e.NativeField, e.CustomMetrics); ok {
+		return true
+	}
+	if _, _, transKey := common.TransMapItem(name, e.Table); transKey != "" {
+		return true
+	}
+	for _, prefix := range dynamicTagPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	if slices.Contains(tag.AUTO_CUSTOM_TAG_NAMES, name) {
+		return true
+	}
+	for _, key := range tag.TAG_DESCRIPTION_KEYS {
+		if key.DB != e.DB || key.Table != e.Table {
+			continue
+		}
+		description := tag.TAG_DESCRIPTIONS[key]
+		if name == description.Name || name == description.ClientName || name == description.ServerName {
+			return true
+		}
+	}
+	return false
+}
@@ -18,11 +18,14 @@ package view
 
 import (
 	"bytes"
+	"io"
 )
 
 type Filters struct {
-	Expr  Node
-	Withs []Node
+	Expr   Node
+	Withs  []Node
+	Params *QueryParams // 不为nil时条件中的常量输出为参数占位符
+	Global bool         // 为true时条件中的IN子查询输出为GLOBAL IN，由View在读取Distributed表的一层设置
 	NodeSetBase
 }
 
@@ -35,7 +38,10 @@ func (s *Filters) IsNull() bool {
 }
 
 func (s *Filters) GetWiths() []Node {
-	return s.Withs
+	if s.Expr == nil {
+		return s.Withs
+	}
+	return append(s.Withs[:len(s.Withs):len(s.Withs)], getWiths(s.Expr)...)
 }
 
 func (s *Filters) Append(f *Filters) {
@@ -59,8 +65,15 @@ func (s *Filters) ToString() string {
 	return buf.String()
 }
 
-func (s *Filters) WriteTo(buf *bytes.Buffer) {
-	s.Expr.WriteTo(buf)
+func (s *Filters) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	setGlobalIn(s.Expr, s.Global)
+	if s.Params != nil && s.Expr != nil {
+		buf.WriteString(s.Params.Bind(s.Expr.ToString()))
+		return buf.result()
+	}
+	buf.writeNode(s.Expr)
+	return buf.result()
 }
 
 // 括号
@@ -75,10 +88,48 @@ func (n *Nested) ToString() string {
 	return buf.String()
 }
 
-func (n *Nested) WriteTo(buf *bytes.Buffer) {
+func (n *Nested) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	buf.WriteString("(")
-	n.Expr.WriteTo(buf)
+	buf.writeNode(n.Expr)
+	buf.WriteString(")")
+	return buf.result()
+}
+
+func (n *Nested) GetWiths() []Node {
+	return getWiths(n.Expr)
+}
+
+// 条件中的子节点可能为nil
+func getWiths(n Node) []Node {
+	if n == nil {
+		return nil
+	}
+	return n.GetWiths()
+}
+
+// 条件组取反，NOT (...)
+type Not struct {
+	NodeBase
+	Expr Node
+}
+
+func (n *Not) ToString() string {
+	buf := bytes.Buffer{}
+	n.WriteTo(&buf)
+	return buf.String()
+}
+
+func (n *Not) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.WriteString("NOT (")
+	buf.writeNode(n.Expr)
 	buf.WriteString(")")
+	return buf.result()
+}
+
+func (n *Not) GetWiths() []Node {
+	return getWiths(n.Expr)
 }
 
 type BinaryExpr struct {
@@ -94,10 +145,16 @@ func (n *BinaryExpr) ToString() string {
 	return buf.String()
 }
 
-func (n *BinaryExpr) WriteTo(buf *bytes.Buffer) {
-	n.Left.WriteTo(buf)
-	n.Op.WriteTo(buf)
-	n.Right.WriteTo(buf)
+func (n *BinaryExpr) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(n.Left)
+	buf.writeNode(n.Op)
+	buf.writeNode(n.Right)
+	return buf.result()
+}
+
+func (n *BinaryExpr) GetWiths() []Node {
+	return append(getWiths(n.Left), getWiths(n.Right)...)
 }
 
 type UnaryExpr struct {
@@ -112,9 +169,15 @@ func (n *UnaryExpr) ToString() string {
 	return buf.String()
 }
 
-func (n *UnaryExpr) WriteTo(buf *bytes.Buffer) {
-	n.Op.WriteTo(buf)
-	n.Expr.WriteTo(buf)
+func (n *UnaryExpr) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(n.Op)
+	buf.writeNode(n.Expr)
+	return buf.result()
+}
+
+func (n *UnaryExpr) GetWiths() []Node {
+	return getWiths(n.Expr)
 }
 
 type Expr struct {
@@ -128,6 +191,88 @@ func (n *Expr) ToString() string {
 	return buf.String()
 }
 
-func (n *Expr) WriteTo(buf *bytes.Buffer) {
+func (n *Expr) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	buf.WriteString(n.Value)
+	return buf.result()
+}
+
+// IN子查询，expr IN (subquery) / expr NOT IN (subquery)，
+// Global为true时输出GLOBAL IN，查询Distributed表时子查询只在发起查询的节点执行一次，结果发送到各分片
+type InSubquery struct {
+	NodeBase
+	Expr     Node
+	Not      bool
+	Subquery string // 不含外层括号
+	Global   bool
+}
+
+func (n *InSubquery) ToString() string {
+	buf := bytes.Buffer{}
+	n.WriteTo(&buf)
+	return buf.String()
+}
+
+func (n *InSubquery) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(n.Expr)
+	if n.Global {
+		buf.WriteString(" GLOBAL")
+	}
+	if n.Not {
+		buf.WriteString(" NOT")
+	}
+	buf.WriteString(" IN (")
+	buf.WriteString(n.Subquery)
+	buf.WriteString(")")
+	return buf.result()
+}
+
+func (n *InSubquery) GetWiths() []Node {
+	return getWiths(n.Expr)
+}
+
+// setGlobalIn 设置条件中所有IN子查询是否输出为GLOBAL IN
+func setGlobalIn(node Node, global bool) {
+	switch n := node.(type) {
+	case *InSubquery:
+		n.Global = global
+	case *Nested:
+		setGlobalIn(n.Expr, global)
+	case *Not:
+		setGlobalIn(n.Expr, global)
+	case *UnaryExpr:
+		setGlobalIn(n.Expr, global)
+	case *BinaryExpr:
+		setGlobalIn(n.Left, global)
+		setGlobalIn(n.Right, global)
+	}
+}
+
+// 空值判断，expr IS NULL / expr IS NOT NULL
+type IsNullExpr struct {
+	NodeBase
+	Expr Node
+	Not  bool
+}
+
+func (n *IsNullExpr) ToString() string {
+	buf := bytes.Buffer{}
+	n.WriteTo(&buf)
+	return buf.String()
+}
+
+func (n *IsNullExpr) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(n.Expr)
+	if n.Not {
+		buf.WriteString(" IS NOT NULL")
+	} else {
+		buf.WriteString(" IS NULL")
+	}
+	return buf.result()
+}
+
+func (n *IsNullExpr) GetWiths() []Node {
+	return getWiths(n.Expr)
 }
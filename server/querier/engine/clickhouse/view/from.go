@@ -18,6 +18,7 @@ package view
 
 import (
 	"bytes"
+	"io"
 )
 
 // NodeSet Table结构体集合
@@ -36,20 +37,22 @@ func (t *Tables) ToString() string {
 	return buf.String()
 }
 
-func (t *Tables) WriteTo(buf *bytes.Buffer) {
+func (t *Tables) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	for i, table := range t.tables {
 		switch table.(type) {
 		case *Table:
-			table.WriteTo(buf)
+			buf.writeNode(table)
 		default:
 			buf.WriteString("(")
-			table.WriteTo(buf)
+			buf.writeNode(table)
 			buf.WriteString(")")
 		}
 		if i < len(t.tables)-1 {
 			buf.WriteString(", ")
 		}
 	}
+	return buf.result()
 }
 
 func (t *Tables) IsNull() bool {
@@ -66,13 +69,29 @@ func (t *Tables) Append(g Node) {
 
 type Table struct {
 	NodeBase
-	Value string
+	Value      string
+	Alias      string
+	Final      bool // 物理表查询时带FINAL
+	IsFunction bool // Value为表函数，如cluster('df', flow_log.`l4_flow_log`)
 }
 
 func (t *Table) ToString() string {
-	return t.Value
+	buf := bytes.Buffer{}
+	t.WriteTo(&buf)
+	return buf.String()
 }
 
-func (t *Table) WriteTo(buf *bytes.Buffer) {
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	buf.WriteString(t.Value)
+	// ClickHouse中别名需在FINAL之前
+	if t.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString(t.Alias)
+	}
+	// FINAL只作用于物理表
+	if t.Final && !t.IsFunction {
+		buf.WriteString(" FINAL")
+	}
+	return buf.result()
 }
@@ -19,49 +19,65 @@ package view
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 
 	ctlcommon "github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/querier/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
 )
 
 const (
-	FUNCTION_SUM           = "Sum"
-	FUNCTION_MAX           = "Max"
-	FUNCTION_MIN           = "Min"
-	FUNCTION_AVG           = "Avg"
-	FUNCTION_COUNTER_AVG   = "Counter_Avg"
-	FUNCTION_DELAY_AVG     = "Delay_Avg"
-	FUNCTION_AAVG          = "AAvg"
-	FUNCTION_PCTL          = "Percentile"
-	FUNCTION_PCTL_EXACT    = "PercentileExact"
-	FUNCTION_STDDEV        = "Stddev"
-	FUNCTION_SPREAD        = "Spread"
-	FUNCTION_RSPREAD       = "Rspread"
-	FUNCTION_APDEX         = "Apdex"
-	FUNCTION_GROUP_ARRAY   = "groupArray"
-	FUNCTION_DIV           = "/"
-	FUNCTION_PLUS          = "+"
-	FUNCTION_MINUS         = "-"
-	FUNCTION_MULTIPLY      = "*"
-	FUNCTION_COUNT         = "Count"
-	FUNCTION_UNIQ          = "Uniq"
-	FUNCTION_UNIQ_EXACT    = "UniqExact"
-	FUNCTION_PERSECOND     = "PerSecond"
-	FUNCTION_PERCENTAG     = "Percentage"
-	FUNCTION_HISTOGRAM     = "Histogram"
-	FUNCTION_LAST          = "Last"
-	FUNCTION_TOPK          = "TopK"
-	FUNCTION_ANY           = "Any"
-	FUNCTION_DERIVATIVE    = "nonNegativeDerivative"
-	FUNCTION_COUNTDISTINCT = "countDistinct"
+	FUNCTION_SUM              = "Sum"
+	FUNCTION_MAX              = "Max"
+	FUNCTION_MIN              = "Min"
+	FUNCTION_AVG              = "Avg"
+	FUNCTION_COUNTER_AVG      = "Counter_Avg"
+	FUNCTION_DELAY_AVG        = "Delay_Avg"
+	FUNCTION_AAVG             = "AAvg"
+	FUNCTION_PCTL             = "Percentile"
+	FUNCTION_PCTL_EXACT       = "PercentileExact"
+	FUNCTION_STDDEV           = "Stddev"
+	FUNCTION_SPREAD           = "Spread"
+	FUNCTION_RSPREAD          = "Rspread"
+	FUNCTION_APDEX            = "Apdex"
+	FUNCTION_GROUP_ARRAY      = "groupArray"
+	FUNCTION_DIV              = "/"
+	FUNCTION_PLUS             = "+"
+	FUNCTION_MINUS            = "-"
+	FUNCTION_MULTIPLY         = "*"
+	FUNCTION_COUNT            = "Count"
+	FUNCTION_UNIQ             = "Uniq"
+	FUNCTION_UNIQ_EXACT       = "UniqExact"
+	FUNCTION_PERSECOND        = "PerSecond"
+	FUNCTION_PERCENTAG        = "Percentage"
+	FUNCTION_HISTOGRAM        = "Histogram"
+	FUNCTION_LAST             = "Last"
+	FUNCTION_FIRST            = "First"
+	FUNCTION_ARGMAX           = "ArgMax"
+	FUNCTION_ARGMIN           = "ArgMin"
+	FUNCTION_DELTA            = "Delta"
+	FUNCTION_GEOMEAN          = "GeoMean"
+	FUNCTION_HARMMEAN         = "HarmonicMean"
+	FUNCTION_ZSCORE           = "ZScore"
+	FUNCTION_PERCENT_OF_TOTAL = "PercentOfTotal"
+	FUNCTION_MOVING_AVG       = "MovingAvg"
+	FUNCTION_SMOOTHED_PCTL    = "SmoothedPercentile"
+	FUNCTION_ERROR_RATIO      = "ErrorRatio"
+	FUNCTION_TOPK             = "TopK"
+	FUNCTION_ANY              = "Any"
+	FUNCTION_DERIVATIVE       = "nonNegativeDerivative"
+	FUNCTION_COUNTDISTINCT    = "countDistinct"
 )
 
 const (
 	TOPK_COUNTS_DEFAULT_LIMIT = "3"
 	TOPK_COUNTS_MODE_FLAG     = "'counts'"
+	LAST_NONZERO_FLAG         = "'nonzero'"
+	AVG_INCLUDE_ZERO_FLAG     = "include_zero = true"
+	AVG_EXCLUDE_ZERO_FLAG     = "include_zero = false"
 )
 
 // 对外提供的算子与数据库实际算子转换
@@ -81,21 +97,34 @@ var FUNC_NAME_MAP map[string]string = map[string]string{
 	FUNCTION_COUNT:       "COUNT",
 	FUNCTION_UNIQ:        "uniq",
 	FUNCTION_UNIQ_EXACT:  "uniqExact",
-	FUNCTION_LAST:        "last_value",
 	FUNCTION_TOPK:        "topK",
-	FUNCTION_ANY:         "any", // because need to set any to topK(1), and '(1)' may be appended after 'If' in func (f *DefaultFunction) WriteTo(buf *bytes.Buffer)
+	FUNCTION_ANY:         "any", // because need to set any to topK(1), and '(1)' may be appended after 'If' in func (f *DefaultFunction) WriteTo(w io.Writer)
 	FUNCTION_DERIVATIVE:  "nonNegativeDerivative",
 }
 
 var MATH_FUNCTIONS = []string{
 	FUNCTION_DIV, FUNCTION_PLUS, FUNCTION_MINUS, FUNCTION_MULTIPLY,
-	FUNCTION_PERCENTAG, FUNCTION_PERSECOND, FUNCTION_HISTOGRAM,
+	FUNCTION_PERCENTAG, FUNCTION_PERSECOND, FUNCTION_HISTOGRAM, FUNCTION_ZSCORE, FUNCTION_MOVING_AVG, FUNCTION_SMOOTHED_PCTL,
+	FUNCTION_ERROR_RATIO, FUNCTION_PERCENT_OF_TOTAL,
 }
 
+// 窗口函数，在计算层外的窗口层计算
+var WINDOW_FUNCTIONS = []string{FUNCTION_ZSCORE, FUNCTION_MOVING_AVG, FUNCTION_SMOOTHED_PCTL, FUNCTION_PERCENT_OF_TOTAL}
+
 func GetFunc(name string) Function {
 	switch name {
 	case FUNCTION_SPREAD:
 		return &SpreadFunction{DefaultFunction: DefaultFunction{Name: name}}
+	case FUNCTION_DELTA:
+		return &DeltaFunction{DefaultFunction: DefaultFunction{Name: name}}
+	case FUNCTION_LAST, FUNCTION_FIRST:
+		return &LastFunction{DefaultFunction: DefaultFunction{Name: name}}
+	case FUNCTION_ARGMAX, FUNCTION_ARGMIN:
+		return &ArgMaxFunction{DefaultFunction: DefaultFunction{Name: name}}
+	case FUNCTION_GEOMEAN:
+		return &GeoMeanFunction{DefaultFunction: DefaultFunction{Name: name}}
+	case FUNCTION_HARMMEAN:
+		return &HarmonicMeanFunction{DefaultFunction: DefaultFunction{Name: name}}
 	case FUNCTION_RSPREAD:
 		return &RspreadFunction{DefaultFunction: DefaultFunction{Name: name}}
 	case FUNCTION_APDEX:
@@ -110,6 +139,12 @@ func GetFunc(name string) Function {
 		return &PerSecondFunction{DefaultFunction: DefaultFunction{Name: name}}
 	case FUNCTION_HISTOGRAM:
 		return &HistogramFunction{DefaultFunction: DefaultFunction{Name: name}}
+	case FUNCTION_ZSCORE:
+		return &ZScoreFunction{DefaultFunction: DefaultFunction{Name: name}}
+	case FUNCTION_PERCENT_OF_TOTAL:
+		return &PercentOfTotalFunction{DefaultFunction: DefaultFunction{Name: name}}
+	case FUNCTION_MOVING_AVG:
+		return &MovingAvgFunction{DefaultFunction: DefaultFunction{Name: name}}
 	case FUNCTION_COUNTER_AVG:
 		return &CounterAvgFunction{DefaultFunction: DefaultFunction{Name: FUNC_NAME_MAP[FUNCTION_AAVG]}}
 	case FUNCTION_DELAY_AVG:
@@ -137,6 +172,7 @@ type Function interface {
 	SetMath(string)
 	GetFlag() int
 	GetName() string
+	GetAlias() string
 	GetFields() []Node
 	Init()
 }
@@ -178,6 +214,7 @@ type DefaultFunction struct {
 	IsLeast        bool // 是否限制最大值
 	Time           *Time
 	Math           string
+	SelectIndex    int // 在select中的位置，从1开始，为0时不是select的列
 	NodeBase
 }
 
@@ -203,6 +240,18 @@ func (f *DefaultFunction) GetName() string {
 	return f.Name
 }
 
+func (f *DefaultFunction) GetAlias() string {
+	return f.Alias
+}
+
+func (f *DefaultFunction) GetSelectIndex() int {
+	return f.SelectIndex
+}
+
+func (f *DefaultFunction) SetSelectIndex(index int) {
+	f.SelectIndex = index
+}
+
 func (f *DefaultFunction) GetWiths() []Node {
 	for _, field := range f.Fields {
 		f.Withs = append(f.Withs, field.GetWiths()...)
@@ -216,7 +265,8 @@ func (f *DefaultFunction) ToString() string {
 	return buf.String()
 }
 
-func (f *DefaultFunction) WriteTo(buf *bytes.Buffer) {
+func (f *DefaultFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	dbFuncName, ok := FUNC_NAME_MAP[f.Name]
 	if !ok {
 		dbFuncName = f.Name
@@ -243,7 +293,7 @@ func (f *DefaultFunction) WriteTo(buf *bytes.Buffer) {
 			buf.WriteString(fmt.Sprintf("_nonnegativederivative_%s", argsNoSuffixStr))
 			buf.WriteString("`")
 		}
-		return
+		return buf.result()
 	}
 
 	buf.WriteString(dbFuncName)
@@ -282,7 +332,7 @@ func (f *DefaultFunction) WriteTo(buf *bytes.Buffer) {
 
 	if !f.IsGroupArray {
 		for i, field := range f.Fields {
-			field.WriteTo(buf)
+			buf.writeNode(field)
 			if i < len(f.Fields)-1 || f.Condition != "" || f.IgnoreZero {
 				buf.WriteString(", ")
 			}
@@ -295,7 +345,7 @@ func (f *DefaultFunction) WriteTo(buf *bytes.Buffer) {
 				buf.WriteString(" AND ")
 			}
 			for i, field := range f.Fields {
-				field.WriteTo(buf)
+				buf.writeNode(field)
 				buf.WriteString(" > 0")
 				if i < len(f.Fields)-1 {
 					buf.WriteString(" AND ")
@@ -306,10 +356,10 @@ func (f *DefaultFunction) WriteTo(buf *bytes.Buffer) {
 		// Array后缀的算子处理0值无意义指标量：MAXArray(arrayFilter(x->x>0), _array)
 		for i, field := range f.Fields {
 			if !f.IgnoreZero {
-				field.WriteTo(buf)
+				buf.writeNode(field)
 			} else {
 				buf.WriteString("arrayFilter(x -> x>0, ")
-				field.WriteTo(buf)
+				buf.writeNode(field)
 				buf.WriteString(")")
 			}
 
@@ -327,7 +377,7 @@ func (f *DefaultFunction) WriteTo(buf *bytes.Buffer) {
 		buf.WriteString(strings.ReplaceAll(f.Alias, "`", ""))
 		buf.WriteString("`")
 	}
-
+	return buf.result()
 }
 
 func (f *DefaultFunction) GetDefaultAlias(inner bool) string {
@@ -425,8 +475,10 @@ type Field struct {
 	Withs []Node
 }
 
-func (f *Field) WriteTo(buf *bytes.Buffer) {
+func (f *Field) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	buf.WriteString(f.Value)
+	return buf.result()
 }
 
 func (f *Field) GetWiths() []Node {
@@ -474,14 +526,16 @@ func (f *SpreadFunction) Init() {
 	}
 }
 
-func (f *SpreadFunction) WriteTo(buf *bytes.Buffer) {
-	f.minusFunction.WriteTo(buf)
+func (f *SpreadFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(f.minusFunction)
 	if f.Alias != "" {
 		buf.WriteString(" AS ")
 		buf.WriteString("`")
 		buf.WriteString(strings.Trim(f.Alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (f *SpreadFunction) GetWiths() []Node {
@@ -489,6 +543,216 @@ func (f *SpreadFunction) GetWiths() []Node {
 	return f.Withs
 }
 
+// DeltaFunction 桶内最后一个值减去第一个值：minus(argMax(x, time), argMin(x, time))
+// 桶内只有一个样本时argMax与argMin相同，结果为0
+// Args[0]为排序使用的时间字段，由engine按数据库的时间列设置
+type DeltaFunction struct {
+	DefaultFunction
+}
+
+// argTimeField Args[0]为argMax/argMin排序使用的时间字段，未设置时使用默认时间列
+func argTimeField(f *DefaultFunction) string {
+	if len(f.Args) > 0 {
+		return f.Args[0]
+	}
+	return chCommon.DEFAULT_TIME_COLUMN
+}
+
+// writeArgTimeFunction 输出argMax(x, time)或argMin(x, time)，存在条件或忽略0值时使用If组合子
+func writeArgTimeFunction(buf *sqlWriter, f *DefaultFunction, name string) {
+	buf.WriteString(name)
+	if f.Condition != "" || f.IgnoreZero {
+		buf.WriteString("If")
+	}
+	buf.WriteString("(")
+	buf.writeNode(f.Fields[0])
+	buf.WriteString(", ")
+	buf.WriteString(argTimeField(f))
+	if f.Condition != "" {
+		buf.WriteString(", ")
+		buf.WriteString(f.Condition)
+	}
+	if f.IgnoreZero {
+		if f.Condition != "" {
+			buf.WriteString(" AND ")
+		} else {
+			buf.WriteString(", ")
+		}
+		buf.writeNode(f.Fields[0])
+		buf.WriteString(" > 0")
+	}
+	buf.WriteString(")")
+}
+
+func (f *DeltaFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *DeltaFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.WriteString("minus(")
+	writeArgTimeFunction(buf, &f.DefaultFunction, "argMax")
+	buf.WriteString(", ")
+	writeArgTimeFunction(buf, &f.DefaultFunction, "argMin")
+	buf.WriteString(")")
+	buf.WriteString(f.Math)
+	if !f.Nest && f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
+}
+
+// LastFunction 取时间最新(Last)或最旧(First)的值：argMax(x, time) / argMin(x, time)
+// 分层时里层按time取值，外层再按里层的_time取值，保证两层结果一致
+type LastFunction struct {
+	DefaultFunction
+}
+
+func (f *LastFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *LastFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	if f.Name == FUNCTION_FIRST {
+		writeArgTimeFunction(buf, &f.DefaultFunction, "argMin")
+	} else {
+		writeArgTimeFunction(buf, &f.DefaultFunction, "argMax")
+	}
+	buf.WriteString(f.Math)
+	if !f.Nest && f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
+}
+
+// ArgMaxFunction 取指标最大(ArgMax)或最小(ArgMin)的行对应的维度值：argMax(dim, metric) / argMin(dim, metric)
+// Fields[0]为维度，Args[0]为指标
+type ArgMaxFunction struct {
+	DefaultFunction
+}
+
+func (f *ArgMaxFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *ArgMaxFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	if f.Name == FUNCTION_ARGMIN {
+		writeArgTimeFunction(buf, &f.DefaultFunction, "argMin")
+	} else {
+		writeArgTimeFunction(buf, &f.DefaultFunction, "argMax")
+	}
+	buf.WriteString(f.Math)
+	if !f.Nest && f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
+}
+
+// GeoMeanFunction 几何平均：exp(avg(log(x)))
+// log对0和负数无意义，与时延的0值一样忽略x<=0的值，没有正数时结果为nan
+type GeoMeanFunction struct {
+	DefaultFunction
+}
+
+func (f *GeoMeanFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *GeoMeanFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.WriteString("exp(")
+	if f.IsGroupArray {
+		// 里层为groupArray：AVGArray(arrayMap(x -> log(x), arrayFilter(x -> x>0, _array)))
+		buf.WriteString("AVGArray(arrayMap(x -> log(x), arrayFilter(x -> x>0, ")
+		buf.writeNode(f.Fields[0])
+		buf.WriteString(")))")
+	} else {
+		buf.WriteString("AVGIf(log(")
+		buf.writeNode(f.Fields[0])
+		buf.WriteString("), ")
+		if f.Condition != "" {
+			buf.WriteString(f.Condition)
+			buf.WriteString(" AND ")
+		}
+		buf.writeNode(f.Fields[0])
+		buf.WriteString(" > 0)")
+	}
+	buf.WriteString(")")
+	buf.WriteString(f.Math)
+	if !f.Nest && f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
+}
+
+// HarmonicMeanFunction 调和平均：count(x)/sum(1/x)，适用于对速率求平均
+// 忽略x<=0的值避免除0，没有正数时结果为nan
+type HarmonicMeanFunction struct {
+	DefaultFunction
+}
+
+func (f *HarmonicMeanFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *HarmonicMeanFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	if f.IsGroupArray {
+		// 里层为groupArray：divide(length(arrayFilter(x -> x>0, _array)), arraySum(arrayMap(x -> 1/x, arrayFilter(x -> x>0, _array))))
+		buf.WriteString("divide(length(arrayFilter(x -> x>0, ")
+		buf.writeNode(f.Fields[0])
+		buf.WriteString(")), arraySum(arrayMap(x -> 1/x, arrayFilter(x -> x>0, ")
+		buf.writeNode(f.Fields[0])
+		buf.WriteString("))))")
+	} else {
+		condition := ""
+		if f.Condition != "" {
+			condition = f.Condition + " AND "
+		}
+		buf.WriteString("divide(COUNTIf(")
+		buf.WriteString(condition)
+		buf.writeNode(f.Fields[0])
+		buf.WriteString(" > 0), SUMIf(divide(1, ")
+		buf.writeNode(f.Fields[0])
+		buf.WriteString("), ")
+		buf.WriteString(condition)
+		buf.writeNode(f.Fields[0])
+		buf.WriteString(" > 0))")
+	}
+	buf.WriteString(f.Math)
+	if !f.Nest && f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
+}
+
 type RspreadFunction struct {
 	DefaultFunction
 	divFunction *DivFunction // rspread的实际算子是div
@@ -525,14 +789,16 @@ func (f *RspreadFunction) Init() {
 	}
 }
 
-func (f *RspreadFunction) WriteTo(buf *bytes.Buffer) {
-	f.divFunction.WriteTo(buf)
+func (f *RspreadFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(f.divFunction)
 	if f.Alias != "" {
 		buf.WriteString(" AS ")
 		buf.WriteString("`")
 		buf.WriteString(strings.Trim(f.Alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (f *RspreadFunction) GetWiths() []Node {
@@ -557,14 +823,16 @@ func (f *PercentageFunction) Init() {
 	}
 }
 
-func (f *PercentageFunction) WriteTo(buf *bytes.Buffer) {
-	f.divFunction.WriteTo(buf)
+func (f *PercentageFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(f.divFunction)
 	if f.Alias != "" {
 		buf.WriteString(" AS ")
 		buf.WriteString("`")
 		buf.WriteString(strings.Trim(f.Alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (f *PercentageFunction) GetWiths() []Node {
@@ -575,7 +843,8 @@ type HistogramFunction struct {
 	DefaultFunction
 }
 
-func (f *HistogramFunction) WriteTo(buf *bytes.Buffer) {
+func (f *HistogramFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	buf.WriteString("histogramIf(")
 	buf.WriteString(FormatField(f.Fields[1].ToString()))
 	buf.WriteString(")(")
@@ -589,6 +858,95 @@ func (f *HistogramFunction) WriteTo(buf *bytes.Buffer) {
 		buf.WriteString(strings.Trim(f.Alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
+}
+
+// ZScoreFunction 标准分：(x - avg(x) OVER ()) / stddevPop(x) OVER ()
+// 窗口函数作用于计算层的全部输出，需要在计算层外再增加一层
+type ZScoreFunction struct {
+	DefaultFunction
+}
+
+func (f *ZScoreFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *ZScoreFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	field := f.Fields[0].ToString()
+	buf.WriteString(fmt.Sprintf("divide(minus(%s, avg(%s) OVER ()), stddevPop(%s) OVER ())", field, field, field))
+	buf.WriteString(f.Math)
+	if f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
+}
+
+// PercentOfTotalFunction 占比：x / sum(x) OVER ()，总和为0时结果为NULL
+// 分母为计算层全部输出行的总和，有group by时为各分组之和，limit在窗口层之后生效，不影响总和
+type PercentOfTotalFunction struct {
+	DefaultFunction
+}
+
+func (f *PercentOfTotalFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *PercentOfTotalFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	field := f.Fields[0].ToString()
+	buf.WriteString(fmt.Sprintf("divide(%s, nullIf(sum(%s) OVER (), 0))", field, field))
+	buf.WriteString(f.Math)
+	if f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
+}
+
+// MovingAvgFunction 滑动平均：avg(x) OVER (PARTITION BY ... ORDER BY time ROWS BETWEEN N-1 PRECEDING AND CURRENT ROW)
+// Args[0]为窗口包含的时间桶数，OrderBy及PartitionBy在生成窗口层时按time()的别名及其余维度列设置
+type MovingAvgFunction struct {
+	DefaultFunction
+	OrderBy     string
+	PartitionBy []string
+}
+
+func (f *MovingAvgFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *MovingAvgFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	size, _ := strconv.Atoi(f.Args[0])
+	buf.WriteString("avg(")
+	buf.WriteString(f.Fields[0].ToString())
+	buf.WriteString(") OVER (")
+	if len(f.PartitionBy) > 0 {
+		buf.WriteString("PARTITION BY ")
+		buf.WriteString(strings.Join(f.PartitionBy, ", "))
+		buf.WriteString(" ")
+	}
+	buf.WriteString(fmt.Sprintf("ORDER BY %s ROWS BETWEEN %d PRECEDING AND CURRENT ROW)", f.OrderBy, size-1))
+	buf.WriteString(f.Math)
+	if f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
 }
 
 type PerSecondFunction struct {
@@ -628,14 +986,16 @@ func (f *PerSecondFunction) Init() {
 	}
 }
 
-func (f *PerSecondFunction) WriteTo(buf *bytes.Buffer) {
-	f.divFunction.WriteTo(buf)
+func (f *PerSecondFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(f.divFunction)
 	if f.Alias != "" {
 		buf.WriteString(" AS ")
 		buf.WriteString("`")
 		buf.WriteString(strings.Trim(f.Alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (f *PerSecondFunction) GetWiths() []Node {
@@ -748,14 +1108,16 @@ func (f *ApdexFunction) Init() {
 
 }
 
-func (f *ApdexFunction) WriteTo(buf *bytes.Buffer) {
-	f.divFunction.WriteTo(buf)
+func (f *ApdexFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.writeNode(f.divFunction)
 	if f.Alias != "" {
 		buf.WriteString(" AS ")
 		buf.WriteString("`")
 		buf.WriteString(strings.Trim(f.Alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (f *ApdexFunction) GetWiths() []Node {
@@ -767,33 +1129,49 @@ type DivFunction struct {
 	DivType int
 }
 
-func (f *DivFunction) writeField(buf *bytes.Buffer) {
+// 除法with的alias由除数和被除数的默认alias组成，Field(如常量)直接使用其值
+func divFieldAlias(field Node) string {
+	if function, ok := field.(Function); ok {
+		return FormatField(function.GetDefaultAlias(true))
+	}
+	return FormatField(field.ToString())
+}
+
+func (f *DivFunction) writeField(buf *sqlWriter) {
 	if f.DivType == FUNCTION_DIV_TYPE_DEFAULT {
 		buf.WriteString("divide(")
-		f.Fields[0].WriteTo(buf)
+		buf.writeNode(f.Fields[0])
 		buf.WriteString(", ")
-		f.Fields[1].WriteTo(buf)
+		buf.writeNode(f.Fields[1])
 		buf.WriteString(")")
 	} else if f.DivType == FUNCTION_DIV_TYPE_FILL_MINIMUM {
 		buf.WriteString("divide(")
-		f.Fields[0].WriteTo(buf)
+		buf.writeNode(f.Fields[0])
 		buf.WriteString("+1e-15, ")
-		f.Fields[1].WriteTo(buf)
+		buf.writeNode(f.Fields[1])
 		buf.WriteString("+1e-15)")
 	} else if f.DivType == FUNCTION_DIV_TYPE_0DIVIDER_AS_NULL {
 		buf.WriteString("`divide_0diveider_as_null")
-		buf.WriteString(FormatField(f.Fields[0].(Function).GetDefaultAlias(true)))
-		buf.WriteString(FormatField(f.Fields[1].(Function).GetDefaultAlias(true)))
+		buf.WriteString(divFieldAlias(f.Fields[0]))
+		buf.WriteString(divFieldAlias(f.Fields[1]))
 		buf.WriteString("`")
 	} else if f.DivType == FUNCTION_DIV_TYPE_0DIVIDER_AS_0 {
 		buf.WriteString("`divide_0diveider_as_0")
-		buf.WriteString(FormatField(f.Fields[0].(Function).GetDefaultAlias(true)))
-		buf.WriteString(FormatField(f.Fields[1].(Function).GetDefaultAlias(true)))
+		buf.WriteString(divFieldAlias(f.Fields[0]))
+		buf.WriteString(divFieldAlias(f.Fields[1]))
 		buf.WriteString("`")
 	}
 }
 
-func (f *DivFunction) WriteTo(buf *bytes.Buffer) {
+// 嵌套除法时外层with需要内层除法的完整表达式，不能使用DefaultFunction.ToString
+func (f *DivFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
+func (f *DivFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	if f.IsLeast {
 		buf.WriteString("if(")
 		f.writeField(buf)
@@ -810,6 +1188,7 @@ func (f *DivFunction) WriteTo(buf *bytes.Buffer) {
 		buf.WriteString(strings.Trim(f.Alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (f *DivFunction) GetWiths() []Node {
@@ -826,8 +1205,8 @@ func (f *DivFunction) GetWiths() []Node {
 		)
 		alias := FormatField(fmt.Sprintf(
 			"divide_0diveider_as_null%s%s",
-			FormatField(f.Fields[0].(Function).GetDefaultAlias(true)),
-			FormatField(f.Fields[1].(Function).GetDefaultAlias(true)),
+			divFieldAlias(f.Fields[0]),
+			divFieldAlias(f.Fields[1]),
 		))
 		f.Withs = append(f.Withs, &With{Value: with, Alias: alias})
 	} else if f.DivType == FUNCTION_DIV_TYPE_0DIVIDER_AS_0 {
@@ -837,8 +1216,8 @@ func (f *DivFunction) GetWiths() []Node {
 		)
 		alias := FormatField(fmt.Sprintf(
 			"divide_0diveider_as_0%s%s",
-			FormatField(f.Fields[0].(Function).GetDefaultAlias(true)),
-			FormatField(f.Fields[1].(Function).GetDefaultAlias(true)),
+			divFieldAlias(f.Fields[0]),
+			divFieldAlias(f.Fields[1]),
 		))
 		f.Withs = append(f.Withs, &With{Value: with, Alias: alias})
 	}
@@ -849,9 +1228,10 @@ type MinFunction struct {
 	DefaultFunction
 }
 
-func (f *MinFunction) WriteTo(buf *bytes.Buffer) {
+func (f *MinFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	if !f.FillNullAsZero {
-		f.DefaultFunction.WriteTo(buf)
+		buf.writeNode(&f.DefaultFunction)
 	} else {
 		buf.WriteString("`")
 		buf.WriteString("min_fillnullaszero_")
@@ -865,6 +1245,7 @@ func (f *MinFunction) WriteTo(buf *bytes.Buffer) {
 			buf.WriteString("`")
 		}
 	}
+	return buf.result()
 }
 
 func (f *MinFunction) GetWiths() []Node {
@@ -885,6 +1266,53 @@ func (f *MinFunction) GetWiths() []Node {
 	}
 }
 
+// FillNullFunction 算子结果为null时补为指定值，例：Sum(byte) fillnull(0)
+// 算子结果放入with，输出ifNull(`fillnull_sum_byte`, 0)
+type FillNullFunction struct {
+	DefaultFunction
+	Function Function
+	Value    string
+}
+
+func (f *FillNullFunction) withAlias() string {
+	alias := f.Alias
+	if alias == "" {
+		alias = f.Function.GetDefaultAlias(false)
+	}
+	return FormatField("fillnull_" + alias)
+}
+
+func (f *FillNullFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	buf.WriteString("ifNull(`")
+	buf.WriteString(f.withAlias())
+	buf.WriteString("`, ")
+	buf.WriteString(f.Value)
+	buf.WriteString(")")
+	if f.Alias != "" {
+		buf.WriteString(" AS ")
+		buf.WriteString("`")
+		buf.WriteString(strings.Trim(f.Alias, "`"))
+		buf.WriteString("`")
+	}
+	return buf.result()
+}
+
+func (f *FillNullFunction) GetWiths() []Node {
+	f.Withs = append(f.Withs, f.Function.GetWiths()...)
+	// 部分算子未实现ToString，使用WriteTo输出
+	value := bytes.Buffer{}
+	f.Function.WriteTo(&value)
+	f.Withs = append(f.Withs, &With{Value: value.String(), Alias: f.withAlias()})
+	return f.Withs
+}
+
+func (f *FillNullFunction) ToString() string {
+	buf := bytes.Buffer{}
+	f.WriteTo(&buf)
+	return buf.String()
+}
+
 type CounterAvgFunction struct {
 	DefaultFunction
 }
@@ -908,7 +1336,8 @@ func GetInterval(displayInterval, datasourceInterval, timeStart, timeEnd, window
 	interval = interval * windowSize
 	return interval
 }
-func (f *CounterAvgFunction) WriteTo(buf *bytes.Buffer) {
+func (f *CounterAvgFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	interval := GetInterval(f.Time.Interval, f.Time.DatasourceInterval, int(f.Time.TimeStart), int(f.Time.TimeEnd), f.Time.WindowSize)
 	buf.WriteString(fmt.Sprintf("sum(%s)/(%d/%d)", f.Fields[0].ToString(), interval, f.Time.DatasourceInterval))
 	buf.WriteString(f.Math)
@@ -918,6 +1347,7 @@ func (f *CounterAvgFunction) WriteTo(buf *bytes.Buffer) {
 		buf.WriteString(strings.Trim(f.Alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 type DelayAvgFunction struct {
@@ -969,14 +1399,15 @@ func (f *DelayAvgFunction) Init() {
 	}
 }
 
-func (f *DelayAvgFunction) WriteTo(buf *bytes.Buffer) {
+func (f *DelayAvgFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	if !strings.Contains(f.Fields[0].ToString(), "/") {
-		f.DefaultFunction.WriteTo(buf)
+		buf.writeNode(&f.DefaultFunction)
 	} else {
 		if f.minusFunction != nil {
-			f.minusFunction.WriteTo(buf)
+			buf.writeNode(f.minusFunction)
 		} else {
-			f.divFunction.WriteTo(buf)
+			buf.writeNode(f.divFunction)
 		}
 		if f.Alias != "" {
 			buf.WriteString(" AS ")
@@ -985,6 +1416,7 @@ func (f *DelayAvgFunction) WriteTo(buf *bytes.Buffer) {
 			buf.WriteString("`")
 		}
 	}
+	return buf.result()
 }
 
 func (f *DelayAvgFunction) GetWiths() []Node {
@@ -999,7 +1431,8 @@ type NonNegativeDerivativeFunction struct {
 	DefaultFunction
 }
 
-func (f *NonNegativeDerivativeFunction) WriteTo(buf *bytes.Buffer) {
+func (f *NonNegativeDerivativeFunction) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	partitionBy := ""
 	argsNoSuffixStr := ""
 	argsNoSuffix := []string{}
@@ -1020,4 +1453,5 @@ func (f *NonNegativeDerivativeFunction) WriteTo(buf *bytes.Buffer) {
 		buf.WriteString(fmt.Sprintf("_nonnegativederivative_%s", argsNoSuffixStr))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
@@ -18,12 +18,18 @@ package view
 
 import (
 	"bytes"
+	"io"
+	"slices"
 	"strings"
 )
 
 // NodeSet Group结构体集合
 type Groups struct {
 	groups []Node
+	// GROUPING SETS中的每个集合，元素为group的名称(有alias时为alias)
+	// 不在任何集合中的group会被加入每个集合
+	GroupingSets [][]string
+	WithTotals   bool // 在GROUP BY之后输出WITH TOTALS，额外返回一行汇总结果
 	NodeSetBase
 }
 
@@ -37,13 +43,70 @@ func (s *Groups) getList() []Node {
 	return s.groups
 }
 
-func (s *Groups) WriteTo(buf *bytes.Buffer) {
-	for i, tag := range s.groups {
-		tag.WriteTo(buf)
-		if i < len(s.groups)-1 {
+func (s *Groups) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	if s.GroupingSets != nil {
+		s.writeGroupingSets(buf)
+	} else {
+		for i, tag := range s.groups {
+			buf.writeNode(tag)
+			if i < len(s.groups)-1 {
+				buf.WriteString(", ")
+			}
+		}
+	}
+	if s.WithTotals {
+		buf.WriteString(" WITH TOTALS")
+	}
+	return buf.result()
+}
+
+func (s *Groups) writeGroupingSets(buf *sqlWriter) {
+	inSets := map[string]bool{}
+	for _, set := range s.GroupingSets {
+		for _, name := range set {
+			inSets[name] = true
+		}
+	}
+	buf.WriteString("GROUPING SETS (")
+	for i, set := range s.GroupingSets {
+		if i > 0 {
 			buf.WriteString(", ")
 		}
+		buf.WriteString("(")
+		count := 0
+		for _, node := range s.groups {
+			name := groupName(node)
+			if inSets[name] && !slices.Contains(set, name) {
+				continue
+			}
+			if count > 0 {
+				buf.WriteString(", ")
+			}
+			// GROUPING SETS中不能带AS，有alias时只引用alias
+			if group, ok := node.(*Group); ok && group.Alias != "" {
+				buf.WriteString("`")
+				buf.WriteString(name)
+				buf.WriteString("`")
+			} else {
+				buf.writeNode(node)
+			}
+			count++
+		}
+		buf.WriteString(")")
+	}
+	buf.WriteString(")")
+}
+
+func groupName(node Node) string {
+	group, ok := node.(*Group)
+	if !ok {
+		return strings.Trim(node.ToString(), "`")
+	}
+	if group.Alias != "" {
+		return strings.Trim(group.Alias, "`")
 	}
+	return strings.Trim(group.Value, "`")
 }
 
 func (s *Groups) IsNull() bool {
@@ -69,10 +132,11 @@ func (s *Groups) GetWiths() []Node {
 }
 
 type Group struct {
-	Value string
-	Alias string
-	Flag  int
-	Withs []Node
+	Value  string
+	Alias  string
+	IsExpr bool // Value为计算表达式，如intDiv(server_port, 1000)，在GROUP BY中原样输出
+	Flag   int
+	Withs  []Node
 	NodeBase
 }
 
@@ -82,7 +146,8 @@ func (n *Group) ToString() string {
 	return buf.String()
 }
 
-func (n *Group) WriteTo(buf *bytes.Buffer) {
+func (n *Group) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 
 	if n.Alias != "" {
 		buf.WriteString(n.Value)
@@ -90,13 +155,14 @@ func (n *Group) WriteTo(buf *bytes.Buffer) {
 		buf.WriteString("`")
 		buf.WriteString(strings.Trim(n.Alias, "`"))
 		buf.WriteString("`")
-	} else if strings.Contains(n.Value, ",") {
+	} else if n.IsExpr || strings.Contains(n.Value, ",") {
 		buf.WriteString(n.Value)
 	} else {
 		buf.WriteString("`")
 		buf.WriteString(strings.Trim(n.Value, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (n *Group) GetWiths() []Node {
@@ -17,7 +17,7 @@
 package view
 
 import (
-	"bytes"
+	"io"
 	"strings"
 )
 
@@ -38,6 +38,8 @@ const (
 	LT
 	IN
 	NIN
+	ILIKE
+	NILIKE
 )
 
 type Operator struct {
@@ -65,6 +67,10 @@ func (n *Operator) ToString() string {
 		return " LIKE "
 	case NLIKE:
 		return " NOT LIKE "
+	case ILIKE:
+		return " ILIKE "
+	case NILIKE:
+		return " NOT ILIKE "
 	case REGEXP:
 		return " MATCH "
 	case NREGEXP:
@@ -81,8 +87,10 @@ func (n *Operator) ToString() string {
 	return ""
 }
 
-func (n *Operator) WriteTo(buf *bytes.Buffer) {
+func (n *Operator) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	buf.WriteString(n.ToString())
+	return buf.result()
 }
 
 func GetOperator(op string) (*Operator, int) {
@@ -108,6 +116,10 @@ func GetOperator(op string) (*Operator, int) {
 		opType = LIKE
 	case "not like":
 		opType = NLIKE
+	case "ilike":
+		opType = ILIKE
+	case "not ilike":
+		opType = NILIKE
 	case "regexp":
 		opType = REGEXP
 	case "not regexp":
@@ -18,6 +18,8 @@ package view
 
 import (
 	"bytes"
+	"io"
+	"slices"
 	"strings"
 
 	"github.com/deepflowio/deepflow/server/querier/common"
@@ -42,13 +44,15 @@ func (s *Orders) Append(o Node) {
 	s.Orders = append(s.Orders, o)
 }
 
-func (s *Orders) WriteTo(buf *bytes.Buffer) {
+func (s *Orders) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	for i, order := range s.Orders {
-		order.WriteTo(buf)
+		buf.writeNode(order)
 		if i < len(s.Orders)-1 {
 			buf.WriteString(",")
 		}
 	}
+	return buf.result()
 }
 
 func (s *Orders) IsNull() bool {
@@ -61,9 +65,12 @@ func (s *Orders) IsNull() bool {
 
 type Order struct {
 	NodeBase
-	SortBy  string
-	OrderBy string
-	IsField bool
+	SortBy     string
+	OrderBy    string
+	IsField    bool
+	NullsFirst bool
+	NullsLast  bool
+	Collate    string // 字符串排序规则，如zh
 }
 
 func (n *Order) ToString() string {
@@ -72,7 +79,8 @@ func (n *Order) ToString() string {
 	return buf.String()
 }
 
-func (n *Order) WriteTo(buf *bytes.Buffer) {
+func (n *Order) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	if n.IsField {
 		buf.WriteString("`")
 		buf.WriteString(strings.Trim(n.SortBy, "`"))
@@ -86,6 +94,17 @@ func (n *Order) WriteTo(buf *bytes.Buffer) {
 	} else {
 		buf.WriteString(n.OrderBy)
 	}
+	if n.NullsFirst {
+		buf.WriteString(" NULLS FIRST")
+	} else if n.NullsLast {
+		buf.WriteString(" NULLS LAST")
+	}
+	if n.Collate != "" {
+		buf.WriteString(" COLLATE '")
+		buf.WriteString(n.Collate)
+		buf.WriteString("'")
+	}
+	return buf.result()
 }
 
 type Limit struct {
@@ -100,7 +119,8 @@ func (n *Limit) ToString() string {
 	return buf.String()
 }
 
-func (n *Limit) WriteTo(buf *bytes.Buffer) {
+func (n *Limit) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	if n.Limit != "" && n.Limit != common.NO_LIMIT {
 		buf.WriteString(" LIMIT ")
 		if n.Offset != "" {
@@ -109,4 +129,59 @@ func (n *Limit) WriteTo(buf *bytes.Buffer) {
 		}
 		buf.WriteString(n.Limit)
 	}
+	return buf.result()
+}
+
+// Settings 最外层sql末尾的SETTINGS k=v, ...，按key排序输出
+// Values为查询中指定的setting，Defaults为按库配置的默认setting，同名时Values优先
+type Settings struct {
+	NodeBase
+	Values   map[string]string
+	Defaults map[string]string
+}
+
+func (n *Settings) Set(key, value string) {
+	if n.Values == nil {
+		n.Values = map[string]string{}
+	}
+	n.Values[key] = value
+}
+
+func (n *Settings) IsNull() bool {
+	return len(n.Values) == 0 && len(n.Defaults) == 0
+}
+
+func (n *Settings) ToString() string {
+	buf := bytes.Buffer{}
+	n.WriteTo(&buf)
+	return buf.String()
+}
+
+func (n *Settings) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	if n.IsNull() {
+		return buf.result()
+	}
+	settings := map[string]string{}
+	for key, value := range n.Defaults {
+		settings[key] = value
+	}
+	for key, value := range n.Values {
+		settings[key] = value
+	}
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	buf.WriteString(" SETTINGS ")
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(key)
+		buf.WriteString("=")
+		buf.WriteString(settings[key])
+	}
+	return buf.result()
 }
@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package view
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 参数的ClickHouse类型
+const (
+	PARAM_TYPE_UINT64  = "UInt64"
+	PARAM_TYPE_FLOAT64 = "Float64"
+	PARAM_TYPE_STRING  = "String"
+)
+
+// QueryParams 参数化sql的参数，常量按出现顺序命名为p0、p1...，相同类型及取值的常量共用一个参数
+type QueryParams struct {
+	Values map[string]string // 参数名 -> 参数值，字符串为去掉引号及转义后的原始值
+	names  map[string]string // 类型及取值 -> 参数名
+}
+
+func NewQueryParams() *QueryParams {
+	return &QueryParams{Values: map[string]string{}, names: map[string]string{}}
+}
+
+// Bind 将sql片段中引号外的数值及字符串常量替换为{p0:UInt64}形式的占位符，反引号中的标识符不替换
+func (p *QueryParams) Bind(sql string) string {
+	buf := strings.Builder{}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '`':
+			end := strings.IndexByte(sql[i+1:], '`')
+			if end < 0 {
+				buf.WriteString(sql[i:])
+				return buf.String()
+			}
+			buf.WriteString(sql[i : i+end+2])
+			i += end + 2
+		case c == '\'':
+			value, end, ok := unquoteString(sql, i)
+			if !ok {
+				buf.WriteString(sql[i:])
+				return buf.String()
+			}
+			buf.WriteString(p.placeholder(PARAM_TYPE_STRING, value))
+			i = end
+		case isDigit(c) && (i == 0 || !isIdentChar(sql[i-1]) && sql[i-1] != '.'):
+			end := i
+			for end < len(sql) && (isDigit(sql[end]) || sql[end] == '.') {
+				end++
+			}
+			literal := sql[i:end]
+			// 1e10、0x1f等其他形式的常量保留原样
+			if end < len(sql) && isIdentChar(sql[end]) {
+				for end < len(sql) && isIdentChar(sql[end]) {
+					end++
+				}
+				buf.WriteString(sql[i:end])
+			} else if _, err := strconv.ParseUint(literal, 10, 64); err == nil {
+				buf.WriteString(p.placeholder(PARAM_TYPE_UINT64, literal))
+			} else if _, err := strconv.ParseFloat(literal, 64); err == nil {
+				buf.WriteString(p.placeholder(PARAM_TYPE_FLOAT64, literal))
+			} else {
+				buf.WriteString(literal)
+			}
+			i = end
+		case isIdentChar(c):
+			// 标识符中的数字，如ip4_0
+			end := i
+			for end < len(sql) && isIdentChar(sql[end]) {
+				end++
+			}
+			buf.WriteString(sql[i:end])
+			i = end
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String()
+}
+
+func (p *QueryParams) placeholder(paramType, value string) string {
+	key := paramType + ":" + value
+	name, ok := p.names[key]
+	if !ok {
+		name = fmt.Sprintf("p%d", len(p.names))
+		p.names[key] = name
+		p.Values[name] = value
+	}
+	return "{" + name + ":" + paramType + "}"
+}
+
+// unquoteString 解析start处的单引号字符串，返回去掉转义后的值及字符串结束后的下标，引号可用反斜杠或连续两个引号转义
+func unquoteString(sql string, start int) (string, int, bool) {
+	value := strings.Builder{}
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if i+1 >= len(sql) {
+				return "", 0, false
+			}
+			i++
+			switch sql[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			case '0':
+				value.WriteByte(0)
+			default:
+				value.WriteByte(sql[i])
+			}
+		case '\'':
+			if i+1 < len(sql) && sql[i+1] == '\'' {
+				value.WriteByte('\'')
+				i++
+				continue
+			}
+			return value.String(), i + 1, true
+		default:
+			value.WriteByte(sql[i])
+		}
+	}
+	return "", 0, false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c)
+}
@@ -18,12 +18,14 @@ package view
 
 import (
 	"bytes"
+	"io"
 	"strings"
 )
 
 // NodeSet Tag结构体集合
 type Tags struct {
-	tags []Node
+	tags     []Node
+	Distinct bool // 为true时输出SELECT DISTINCT
 	NodeSetBase
 }
 
@@ -49,7 +51,11 @@ func (s *Tags) Append(t Node) {
 	s.tags = append(s.tags, t)
 }
 
-func (s *Tags) WriteTo(buf *bytes.Buffer) {
+func (s *Tags) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	if s.Distinct {
+		buf.WriteString("DISTINCT ")
+	}
 	first := true
 	for _, tag := range s.tags {
 		node, ok := tag.(*Tag)
@@ -60,9 +66,10 @@ func (s *Tags) WriteTo(buf *bytes.Buffer) {
 		if !first {
 			buf.WriteString(", ")
 		}
-		tag.WriteTo(buf)
+		buf.writeNode(tag)
 		first = false
 	}
+	return buf.result()
 }
 
 func (s *Tags) GetWiths() []Node {
@@ -76,10 +83,12 @@ func (s *Tags) GetWiths() []Node {
 }
 
 type Tag struct {
-	Value string
-	Alias string
-	Flag  int
-	Withs []Node
+	Value       string
+	Alias       string
+	Flag        int
+	Withs       []Node
+	ArrayJoin   bool // 数组类型tag，在计算层内层通过arrayJoin展开为多行后再分组
+	SelectIndex int  // 在select中的位置，从1开始，为0时不是select的列
 	NodeBase
 }
 
@@ -89,16 +98,37 @@ func (n *Tag) ToString() string {
 	return buf.String()
 }
 
-func (n *Tag) WriteTo(buf *bytes.Buffer) {
-	buf.WriteString(n.Value)
-	if n.Alias != "" {
+func (n *Tag) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	alias := n.Alias
+	if n.ArrayJoin {
+		buf.WriteString("arrayJoin(")
+		buf.WriteString(n.Value)
+		buf.WriteString(")")
+		// 展开后的元素需要通过名字在group及外层中引用
+		if alias == "" {
+			alias = n.Value
+		}
+	} else {
+		buf.WriteString(n.Value)
+	}
+	if alias != "" {
 		buf.WriteString(" AS ")
 		buf.WriteString("`")
-		buf.WriteString(strings.Trim(n.Alias, "`"))
+		buf.WriteString(strings.Trim(alias, "`"))
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (n *Tag) GetWiths() []Node {
 	return n.Withs
 }
+
+func (n *Tag) GetSelectIndex() int {
+	return n.SelectIndex
+}
+
+func (n *Tag) SetSelectIndex(index int) {
+	n.SelectIndex = index
+}
@@ -18,6 +18,9 @@ package view
 
 import (
 	"bytes"
+	"cmp"
+	"io"
+	"math"
 	"slices"
 	"strings"
 
@@ -34,27 +37,44 @@ import (
 		NewModel() Model          初始化Model结构
 		Model.AddTag()
 		Model.AddTable()
+		Model.AddTableFinal()
+		Model.AddTableFunction()
 		Model.AddGroup()
+		Model.AddGroupingSets()
+		Model.SetWithTotals()
+		Model.SetDistinct()
+		Model.AddDistinctRaw()
 		Model.AddFilter()
+		Model.AddTopFilter()
+		Model.Settings.Set()
 		NewView(*Model) View      使用model初始化View结构
 		NewView.ToString() string 生成df-clickhouse-sql
 */
 type Model struct {
-	DB        string
-	Time      *Time
-	Tags      *Tags
-	Filters   *Filters
-	From      *Tables
-	Groups    *Groups
-	Havings   *Filters
-	Orders    *Orders
-	Limit     *Limit
-	Callbacks map[string]func(*common.Result) error
+	DB         string
+	Time       *Time
+	Tags       *Tags
+	Filters    *Filters
+	PreFilters *Filters // 简单的列比较，在读取表的层作为PREWHERE
+	From       *Tables
+	Groups     *Groups
+	Havings    *Filters
+	TopFilters *Filters // 引用窗口函数结果的条件，在窗口层作为WHERE
+	Orders     *Orders
+	Limit      *Limit
+	Settings   *Settings // 只输出在拼接后的最外层sql末尾
+	Callbacks  map[string]func(*common.Result) error
 	//Havings Havings
 	MetricsLevelFlag  int //Metrics是否需要拆层的标识
 	HasAggFunc        bool
 	IsDerivative      bool
 	DerivativeGroupBy []string
+	NoDivZeroGuard    bool   // 为true时用户除法不生成除0保护
+	TimestampMilli    bool   // 为true时time()输出毫秒时间戳
+	SelectIndex       int    // 当前添加的tag在select中的位置，从1开始，为0时不是select的列
+	DistinctRaws      []Node // DISTINCT时tag翻译前的原始列，不为空时先在里层对原始列去重再在外层翻译
+	DefaultLimit      string // 查询未指定LIMIT时使用，拆层时只作用于最外层
+	Cluster           bool   // 查询的表为Distributed表，读取表的一层中IN子查询输出为GLOBAL IN
 }
 
 func NewModel() *Model {
@@ -64,9 +84,12 @@ func NewModel() *Model {
 		Groups:     &Groups{},
 		From:       &Tables{},
 		Filters:    &Filters{},
+		PreFilters: &Filters{},
 		Havings:    &Filters{},
+		TopFilters: &Filters{},
 		Orders:     &Orders{},
 		Limit:      &Limit{},
+		Settings:   &Settings{},
 		Callbacks:  map[string]func(*common.Result) error{},
 		HasAggFunc: false,
 	}
@@ -80,6 +103,9 @@ func (m *Model) AddCallback(col string, f func(*common.Result) error) {
 }
 
 func (m *Model) AddTag(n Node) {
+	if node, ok := n.(SelectIndexNode); ok && m.SelectIndex > 0 && node.GetSelectIndex() == 0 {
+		node.SetSelectIndex(m.SelectIndex)
+	}
 	m.Tags.Append(n)
 }
 
@@ -91,14 +117,46 @@ func (m *Model) AddHaving(f *Filters) {
 	m.Havings.Append(f)
 }
 
+func (m *Model) AddTopFilter(f *Filters) {
+	m.TopFilters.Append(f)
+}
+
 func (m *Model) AddTable(value string) {
 	m.From.Append(&Table{Value: value})
 }
 
+func (m *Model) AddTableFinal(value string) {
+	m.From.Append(&Table{Value: value, Final: true})
+}
+
+func (m *Model) AddTableWithAlias(value string, alias string, final bool) {
+	m.From.Append(&Table{Value: value, Alias: alias, Final: final})
+}
+
+func (m *Model) AddTableFunction(value string, alias string) {
+	m.From.Append(&Table{Value: value, Alias: alias, IsFunction: true})
+}
+
 func (m *Model) AddGroup(g *Group) {
 	m.Groups.Append(g)
 }
 
+func (m *Model) AddGroupingSets(sets [][]string) {
+	m.Groups.GroupingSets = sets
+}
+
+func (m *Model) SetWithTotals() {
+	m.Groups.WithTotals = true
+}
+
+func (m *Model) SetDistinct() {
+	m.Tags.Distinct = true
+}
+
+func (m *Model) AddDistinctRaw(n Node) {
+	m.DistinctRaws = append(m.DistinctRaws, n)
+}
+
 type Time struct {
 	TimeStart          int64
 	TimeEnd            int64
@@ -110,6 +168,11 @@ type Time struct {
 	Alias              string
 	TimeStartOperator  string
 	TimeEndOperator    string
+	Unit               string
+	Align              bool  // 将time过滤条件对齐到DatasourceInterval的边界
+	RequestedTimeStart int64 // 对齐前的时间范围
+	RequestedTimeEnd   int64
+	RequestedInterval  int // 按max-points调大前的time()间隔
 }
 
 func (t *Time) AddTimeStart(timeStart int64) {
@@ -124,6 +187,18 @@ func (t *Time) AddTimeEnd(timeEnd int64) {
 	}
 }
 
+func (t *Time) AddRequestedTimeStart(timeStart int64) {
+	if timeStart > t.RequestedTimeStart {
+		t.RequestedTimeStart = timeStart
+	}
+}
+
+func (t *Time) AddRequestedTimeEnd(timeEnd int64) {
+	if t.RequestedTimeEnd == 0 || timeEnd < t.RequestedTimeEnd {
+		t.RequestedTimeEnd = timeEnd
+	}
+}
+
 func (t *Time) AddInterval(interval int) {
 	t.Interval = interval
 }
@@ -158,6 +233,10 @@ type View struct {
 	Model         *Model     //初始化view
 	SubViewLevels []*SubView //由RawView拆层
 	NoPreWhere    bool       // Whether to use prewhere
+	NoWithsSort   bool       // Whether to keep withs in collected order instead of sorting by alias
+	Unions        []*View    // 以UNION ALL拼接在当前View之后的View
+	UnionOrders   *Orders    // 作用于UNION ALL整体结果的排序
+	UnionLimit    *Limit     // 作用于UNION ALL整体结果的LIMIT
 }
 
 // 使用model初始化view
@@ -167,16 +246,78 @@ func NewView(m *Model) *View {
 
 func (v *View) ToString() string {
 	buf := bytes.Buffer{}
+	v.WriteTo(&buf)
+	return buf.String()
+}
+
+// WriteTo 将df-clickhouse-sql直接写入w，写入出错时返回该错误
+func (v *View) WriteTo(w io.Writer) (int64, error) {
+	if len(v.Unions) > 0 {
+		return v.writeUnion(w)
+	}
+	return v.writeSelect(w)
+}
+
+// writeUnion 各View加括号后以UNION ALL拼接，整体的ORDER BY/LIMIT需要在外层再包一层SELECT
+func (v *View) writeUnion(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
+	wrap := (v.UnionOrders != nil && !v.UnionOrders.IsNull()) || (v.UnionLimit != nil && v.UnionLimit.Limit != "")
+	if wrap {
+		buf.WriteString("SELECT * FROM (")
+	}
+	for i, view := range append([]*View{v}, v.Unions...) {
+		if i > 0 {
+			buf.WriteString(" UNION ALL ")
+		}
+		buf.WriteString("(")
+		buf.writeSelect(view)
+		buf.WriteString(")")
+	}
+	if wrap {
+		buf.WriteString(")")
+		if v.UnionOrders != nil && !v.UnionOrders.IsNull() {
+			buf.WriteString(" ORDER BY ")
+			buf.writeNode(v.UnionOrders)
+		}
+		if v.UnionLimit != nil {
+			buf.writeNode(v.UnionLimit)
+		}
+	}
+	return buf.result()
+}
+
+func (v *View) writeSelect(w io.Writer) (int64, error) {
 	v.trans()
+	v.applyDefaultLimit()
 	for i, view := range v.SubViewLevels {
 		if i > 0 {
 			// 将内层view作为外层view的From
 			view.From.Append(v.SubViewLevels[i-1])
+		} else {
+			// 只有最内层读取Distributed表，外层在发起查询的节点上执行，不需要GLOBAL
+			view.Filters.Global = v.Model.Cluster
+			view.Havings.Global = v.Model.Cluster
+			if view.PreFilters != nil {
+				view.PreFilters.Global = v.Model.Cluster
+			}
 		}
 	}
+	v.SubViewLevels[len(v.SubViewLevels)-1].Settings = v.Model.Settings
 	//从最外层View开始拼接sql
-	v.SubViewLevels[len(v.SubViewLevels)-1].WriteTo(&buf)
-	return buf.String()
+	return v.SubViewLevels[len(v.SubViewLevels)-1].WriteTo(w)
+}
+
+// applyDefaultLimit 查询未指定LIMIT时，将Model的LIMIT移到最外层并使用默认值
+func (v *View) applyDefaultLimit() {
+	if v.Model.Limit.Limit != "" || v.Model.DefaultLimit == "" {
+		return
+	}
+	for _, sv := range v.SubViewLevels {
+		if sv.Limit == v.Model.Limit {
+			sv.Limit = &Limit{}
+		}
+	}
+	v.SubViewLevels[len(v.SubViewLevels)-1].Limit = &Limit{Limit: v.Model.DefaultLimit, Offset: v.Model.Limit.Offset}
 }
 
 func (v *View) GetCallbacks() (callbacks map[string]func(*common.Result) error) {
@@ -184,6 +325,8 @@ func (v *View) GetCallbacks() (callbacks map[string]func(*common.Result) error)
 }
 
 func (v *View) trans() {
+	// 每次输出都重新拆层，避免多次输出时子查询重复
+	v.SubViewLevels = nil
 	var tagsLevelInner []Node
 	var tagsLevelMetrics []Node
 	var tagsLevelTop []Node
@@ -194,16 +337,16 @@ func (v *View) trans() {
 	var groupsLevelMetrics []Node
 	var tagsAliasInner []string
 	var groupsValueInner []string
-	hasLastFunction := false
 	// 遍历tags，解析至分层结构中
 	for _, tag := range v.Model.Tags.tags {
 		switch node := tag.(type) {
 		case *Tag:
-			if node.Flag == NODE_FLAG_METRICS {
+			// 数组tag需要在最内层展开，因此按NODE_FLAG_METRICS处理
+			if node.Flag == NODE_FLAG_METRICS || node.ArrayJoin {
 				// Tag在最内层中只保留value 去掉alias
 				tagsLevelInner = append(tagsLevelInner, tag)
 				// 外层tag
-				metricTag := &Tag{}
+				metricTag := &Tag{SelectIndex: node.SelectIndex}
 				if node.Alias != "" {
 					metricTag.Value = node.Alias
 				} else {
@@ -221,10 +364,6 @@ func (v *View) trans() {
 			}
 		case Function:
 			flag := node.GetFlag()
-			name := node.GetName()
-			if !hasLastFunction && strings.Contains(name, FUNCTION_LAST) {
-				hasLastFunction = true
-			}
 			node.SetTime(v.Model.Time)
 			node.Init()
 			if flag == METRICS_FLAG_INNER {
@@ -273,7 +412,7 @@ func (v *View) trans() {
 	for _, node := range v.Model.Tags.tags {
 		switch tag := node.(type) {
 		case *Tag:
-			if tag.Flag == NODE_FLAG_METRICS {
+			if tag.Flag == NODE_FLAG_METRICS || tag.ArrayJoin {
 				// outer group
 				if tag.Alias != "" && !slices.Contains(groupList, tag.Alias) {
 					groupsLevelMetrics = append(groupsLevelMetrics, &Group{Value: tag.Alias})
@@ -282,7 +421,42 @@ func (v *View) trans() {
 		}
 	}
 
-	if v.Model.MetricsLevelFlag == MODEL_METRICS_LEVEL_FLAG_UNLAY {
+	// 只有不包含算子时才输出DISTINCT
+	distinct := v.Model.Tags.Distinct && len(metricsLevelInner) == 0 && len(metricsLevelMetrics) == 0 && len(metricsLevelTop) == 0
+	if distinct && len(v.Model.DistinctRaws) > 0 {
+		// 需要翻译的tag先在里层对原始列去重，外层只对去重后的结果做字典翻译
+		svInner := SubView{
+			Tags:        &Tags{tags: v.Model.DistinctRaws, Distinct: true},
+			Groups:      v.Model.Groups,
+			From:        v.Model.From,
+			Filters:     v.Model.Filters,
+			PreFilters:  v.Model.PreFilters,
+			Havings:     v.Model.Havings,
+			Orders:      &Orders{},
+			Limit:       &Limit{},
+			NoPreWhere:  v.NoPreWhere,
+			NoWithsSort: v.NoWithsSort,
+		}
+		v.SubViewLevels = append(v.SubViewLevels, &svInner)
+		tagsOuter := []Node{}
+		for _, tagInner := range tagsLevelInner {
+			if _, ok := tagInner.(*Tag); ok {
+				tagsOuter = append(tagsOuter, tagInner)
+			}
+		}
+		svOuter := SubView{
+			Tags:        &Tags{tags: tagsOuter},
+			Groups:      &Groups{},
+			From:        &Tables{},
+			Filters:     &Filters{},
+			Havings:     &Filters{},
+			Orders:      v.Model.Orders,
+			Limit:       v.Model.Limit,
+			NoPreWhere:  v.NoPreWhere,
+			NoWithsSort: v.NoWithsSort,
+		}
+		v.SubViewLevels = append(v.SubViewLevels, &svOuter)
+	} else if v.Model.MetricsLevelFlag == MODEL_METRICS_LEVEL_FLAG_UNLAY {
 		// 计算层不拆层
 		// 里层tag+外层metric
 		newTagsInner := []Node{}
@@ -293,14 +467,16 @@ func (v *View) trans() {
 			}
 		}
 		sv := SubView{
-			Tags:       &Tags{tags: append(newTagsInner, metricsLevelMetrics...)},
-			Groups:     v.Model.Groups,
-			From:       v.Model.From,
-			Filters:    v.Model.Filters,
-			Havings:    v.Model.Havings,
-			Orders:     v.Model.Orders,
-			Limit:      v.Model.Limit,
-			NoPreWhere: v.NoPreWhere,
+			Tags:        &Tags{tags: append(newTagsInner, metricsLevelMetrics...), Distinct: distinct},
+			Groups:      v.Model.Groups,
+			From:        v.Model.From,
+			Filters:     v.Model.Filters,
+			PreFilters:  v.Model.PreFilters,
+			Havings:     v.Model.Havings,
+			Orders:      v.Model.Orders,
+			Limit:       v.Model.Limit,
+			NoPreWhere:  v.NoPreWhere,
+			NoWithsSort: v.NoWithsSort,
 		}
 		v.SubViewLevels = append(v.SubViewLevels, &sv)
 	} else if v.Model.MetricsLevelFlag == MODEL_METRICS_LEVEL_FLAG_LAYERED {
@@ -313,64 +489,189 @@ func (v *View) trans() {
 		// 计算层需要拆层
 		// 计算层里层
 		svInner := SubView{
-			Tags:       &Tags{tags: append(tagsLevelInner, metricsLevelInner...)}, // 计算层所有tag及里层算子
-			Groups:     &Groups{groups: groupsLevelInner},                         // group分层
-			From:       v.Model.From,                                              // 查询表
-			Filters:    v.Model.Filters,                                           // 所有filter
-			Havings:    &Filters{},
-			Orders:     &Orders{},
-			Limit:      &Limit{},
-			NoPreWhere: v.NoPreWhere,
+			Tags:        &Tags{tags: append(tagsLevelInner, metricsLevelInner...)}, // 计算层所有tag及里层算子
+			Groups:      &Groups{groups: groupsLevelInner},                         // group分层
+			From:        v.Model.From,                                              // 查询表
+			Filters:     v.Model.Filters,                                           // 所有filter
+			PreFilters:  v.Model.PreFilters,
+			Havings:     &Filters{},
+			Orders:      &Orders{},
+			Limit:       &Limit{},
+			NoPreWhere:  v.NoPreWhere,
+			NoWithsSort: v.NoWithsSort,
 		}
 		v.SubViewLevels = append(v.SubViewLevels, &svInner)
-		// last function add order by _time asc
-		if hasLastFunction {
-			svInner.Orders.Append(
-				&Order{
-					SortBy:  "_time",
-					OrderBy: "ASC",
-					IsField: true,
-				},
-			)
-		}
 		// 计算层外层
 		svMetrics := SubView{
-			Tags:       &Tags{tags: append(tagsLevelMetrics, metricsLevelMetrics...)}, // 计算层所有tag及外层算子
-			Groups:     &Groups{groups: groupsLevelMetrics},                           // group分层
-			From:       &Tables{},                                                     // 空table
-			Filters:    &Filters{},                                                    // 空filter
-			Havings:    v.Model.Havings,
-			Orders:     v.Model.Orders,
-			Limit:      v.Model.Limit,
-			NoPreWhere: v.NoPreWhere,
+			Tags:        &Tags{tags: append(tagsLevelMetrics, metricsLevelMetrics...)}, // 计算层所有tag及外层算子
+			Groups:      &Groups{groups: groupsLevelMetrics},                           // group分层
+			From:        &Tables{},                                                     // 空table
+			Filters:     &Filters{},                                                    // 空filter
+			Havings:     v.Model.Havings,
+			Orders:      v.Model.Orders,
+			Limit:       v.Model.Limit,
+			NoPreWhere:  v.NoPreWhere,
+			NoWithsSort: v.NoWithsSort,
 		}
+		// grouping sets及with totals只作用于计算层外层，里层仍按所有group聚合
+		svMetrics.Groups.GroupingSets = v.Model.Groups.GroupingSets
+		svMetrics.Groups.WithTotals = v.Model.Groups.WithTotals
+		svMetrics.Tags.Distinct = distinct
 		v.SubViewLevels = append(v.SubViewLevels, &svMetrics)
 	}
-	if metricsLevelTop != nil {
+	if windowFields := getWindowFields(metricsLevelTop); len(windowFields) > 0 && len(v.SubViewLevels) > 0 {
+		// 窗口层，窗口函数作用于计算层的全部输出
+		// 计算层的列原样透传，order及limit移到窗口层
+		svMetrics := v.SubViewLevels[len(v.SubViewLevels)-1]
+		setMovingAvgWindow(metricsLevelTop, svMetrics.Tags.tags, v.Model.Time.Alias)
+		svWindow := SubView{
+			Tags:        &Tags{tags: append(getPassThroughTags(svMetrics.Tags.tags, windowFields), metricsLevelTop...)},
+			Groups:      &Groups{},
+			From:        &Tables{},
+			Filters:     &Filters{},
+			Havings:     &Filters{},
+			Orders:      svMetrics.Orders,
+			Limit:       svMetrics.Limit,
+			NoPreWhere:  v.NoPreWhere,
+			NoWithsSort: v.NoWithsSort,
+		}
+		svMetrics.Orders = &Orders{}
+		svMetrics.Limit = &Limit{}
+		v.SubViewLevels = append(v.SubViewLevels, &svWindow)
+		if !v.Model.TopFilters.IsNull() {
+			// 窗口函数的结果不能用在同层的WHERE中，在窗口层外再增加一层过滤
+			svFilter := SubView{
+				Tags:        &Tags{tags: getPassThroughTags(svWindow.Tags.tags, nil)},
+				Groups:      &Groups{},
+				From:        &Tables{},
+				Filters:     v.Model.TopFilters,
+				Havings:     &Filters{},
+				Orders:      svWindow.Orders,
+				Limit:       svWindow.Limit,
+				NoPreWhere:  v.NoPreWhere,
+				NoWithsSort: v.NoWithsSort,
+			}
+			svWindow.Orders = &Orders{}
+			svWindow.Limit = &Limit{}
+			v.SubViewLevels = append(v.SubViewLevels, &svFilter)
+		}
+	} else if metricsLevelTop != nil {
 		// 顶层，只保留指定tag，比如histogram
 		svOuter := SubView{
-			Tags:       &Tags{tags: metricsLevelTop}, // 所有翻译层tag
-			Groups:     &Groups{},                    // 空group
-			From:       &Tables{},                    // 空table
-			Filters:    &Filters{},                   //空filter
-			Havings:    &Filters{},
-			Orders:     &Orders{},
-			Limit:      &Limit{},
-			NoPreWhere: v.NoPreWhere,
+			Tags:        &Tags{tags: metricsLevelTop}, // 所有翻译层tag
+			Groups:      &Groups{},                    // 空group
+			From:        &Tables{},                    // 空table
+			Filters:     &Filters{},                   //空filter
+			Havings:     &Filters{},
+			Orders:      &Orders{},
+			Limit:       &Limit{},
+			NoPreWhere:  v.NoPreWhere,
+			NoWithsSort: v.NoWithsSort,
 		}
 		v.SubViewLevels = append(v.SubViewLevels, &svOuter)
 	}
+	// 拆层后各层的tag顺序与select不同，最外层按select中的顺序输出，不在select中的列放在最后
+	if len(v.SubViewLevels) > 0 {
+		sortBySelectIndex(v.SubViewLevels[len(v.SubViewLevels)-1].Tags.tags)
+	}
+}
+
+// getWindowFields 返回窗口函数使用的计算层列名
+func getWindowFields(tags []Node) []string {
+	var fields []string
+	for _, tag := range tags {
+		switch f := tag.(type) {
+		case *ZScoreFunction:
+			fields = append(fields, f.Fields[0].ToString())
+		case *PercentOfTotalFunction:
+			fields = append(fields, f.Fields[0].ToString())
+		case *MovingAvgFunction:
+			fields = append(fields, f.Fields[0].ToString())
+		}
+	}
+	return fields
+}
+
+// setMovingAvgWindow 滑动平均按time()的别名排序，按计算层除时间外的维度列分区
+func setMovingAvgWindow(tags []Node, metricsTags []Node, timeAlias string) {
+	orderBy := "`" + strings.Trim(timeAlias, "`") + "`"
+	var partitionBy []string
+	for _, node := range metricsTags {
+		tag, ok := node.(*Tag)
+		if !ok {
+			continue
+		}
+		column := tag.Value
+		if tag.Alias != "" {
+			column = "`" + strings.Trim(tag.Alias, "`") + "`"
+		}
+		if column != orderBy {
+			partitionBy = append(partitionBy, column)
+		}
+	}
+	for _, tag := range tags {
+		if f, ok := tag.(*MovingAvgFunction); ok {
+			f.OrderBy = orderBy
+			f.PartitionBy = partitionBy
+		}
+	}
+}
+
+// getPassThroughTags 按内层输出的列名透传，excludes中的列不透传
+func getPassThroughTags(nodes []Node, excludes []string) []Node {
+	var tags []Node
+	for _, node := range nodes {
+		var tag *Tag
+		switch n := node.(type) {
+		case *Tag:
+			if n.Alias == "" {
+				tag = &Tag{Value: n.Value, SelectIndex: n.SelectIndex}
+			} else {
+				tag = &Tag{Value: "`" + strings.Trim(n.Alias, "`") + "`", SelectIndex: n.SelectIndex}
+			}
+		case Function:
+			if n.GetAlias() == "" {
+				continue
+			}
+			tag = &Tag{Value: "`" + strings.Trim(n.GetAlias(), "`") + "`"}
+			if indexNode, ok := n.(SelectIndexNode); ok {
+				tag.SelectIndex = indexNode.GetSelectIndex()
+			}
+		default:
+			continue
+		}
+		if slices.Contains(excludes, tag.Value) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func sortBySelectIndex(tags []Node) {
+	selectIndex := func(n Node) int {
+		if node, ok := n.(SelectIndexNode); ok && node.GetSelectIndex() > 0 {
+			return node.GetSelectIndex()
+		}
+		return math.MaxInt
+	}
+	slices.SortStableFunc(tags, func(a, b Node) int {
+		return cmp.Compare(selectIndex(a), selectIndex(b))
+	})
 }
 
 type SubView struct {
-	Tags       *Tags
-	Filters    *Filters
-	From       *Tables
-	Groups     *Groups
-	Orders     *Orders
-	Limit      *Limit
-	Havings    *Filters
-	NoPreWhere bool
+	Tags        *Tags
+	Filters     *Filters
+	PreFilters  *Filters // 为nil时不输出PREWHERE
+	From        *Tables
+	Groups      *Groups
+	Orders      *Orders
+	Limit       *Limit
+	Havings     *Filters
+	Settings    *Settings // 只有最外层的SubView有值
+	NoPreWhere  bool
+	NoWithsSort bool
 }
 
 func (sv *SubView) GetWiths() []Node {
@@ -381,6 +682,11 @@ func (sv *SubView) GetWiths() []Node {
 	if nodeWiths := sv.Filters.GetWiths(); nodeWiths != nil {
 		withs = append(withs, nodeWiths...)
 	}
+	if sv.PreFilters != nil {
+		if nodeWiths := sv.PreFilters.GetWiths(); nodeWiths != nil {
+			withs = append(withs, nodeWiths...)
+		}
+	}
 	if nodeWiths := sv.Groups.GetWiths(); nodeWiths != nil {
 		withs = append(withs, nodeWiths...)
 	}
@@ -404,9 +710,12 @@ func (sv *SubView) removeDup(ns NodeSet) []Node {
 	for _, node := range nodeList {
 		str := node.ToString()
 		postAs := ""
-		// x as y
-		// if the tag after as already exists, it is also considered duplicate​​​
-		if strings.Contains(str, " ") {
+		// with按alias去重，表达式中含空格时无法从字符串中拆出alias，保留最先出现的with
+		if with, ok := node.(*With); ok && with.getAlias() != "" {
+			postAs = with.getAlias()
+		} else if strings.Contains(str, " ") {
+			// x as y
+			// if the tag after as already exists, it is also considered duplicate​​​
 			strSlice := strings.Fields(str)
 			if len(strSlice) == 3 && strings.ToUpper(strSlice[1]) == "AS" {
 				postAs = strings.Trim(strSlice[2], "`")
@@ -428,55 +737,117 @@ func (sv *SubView) removeDup(ns NodeSet) []Node {
 	return targetList
 }
 
-func (sv *SubView) WriteTo(buf *bytes.Buffer) {
+func (sv *SubView) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	if nodeWiths := sv.GetWiths(); nodeWiths != nil {
 		withs := Withs{Withs: nodeWiths}
 		withs.Withs = sv.removeDup(&withs)
+		if !sv.NoWithsSort {
+			withs.Withs = sortWiths(withs.Withs)
+		}
 		buf.WriteString("WITH ")
-		withs.WriteTo(buf)
+		buf.writeNode(&withs)
 		buf.WriteString(" ")
 	}
 	if !sv.Tags.IsNull() {
 		sv.Tags.tags = sv.removeDup(sv.Tags)
 		buf.WriteString("SELECT ")
-		sv.Tags.WriteTo(buf)
+		buf.writeNode(sv.Tags)
 	}
 	if !sv.From.IsNull() {
 		buf.WriteString(" FROM ")
-		sv.From.WriteTo(buf)
+		buf.writeNode(sv.From)
+	}
+	if sv.PreFilters != nil && !sv.PreFilters.IsNull() {
+		buf.WriteString(" PREWHERE ")
+		buf.writeNode(sv.PreFilters)
 	}
 	if !sv.Filters.IsNull() {
 		buf.WriteString(" WHERE ")
-		sv.Filters.WriteTo(buf)
+		buf.writeNode(sv.Filters)
 	}
 	if !sv.Groups.IsNull() {
 		sv.Groups.groups = sv.removeDup(sv.Groups)
 		buf.WriteString(" GROUP BY ")
-		sv.Groups.WriteTo(buf)
+		buf.writeNode(sv.Groups)
 	}
 	if !sv.Havings.IsNull() {
 		buf.WriteString(" HAVING ")
-		sv.Havings.WriteTo(buf)
+		buf.writeNode(sv.Havings)
 	}
 	if !sv.Orders.IsNull() {
+		// 只有nulls first/last或collate不同的排序不是重复项
+		sv.Orders.Orders = sv.removeDup(sv.Orders)
 		buf.WriteString(" ORDER BY ")
-		sv.Orders.WriteTo(buf)
+		buf.writeNode(sv.Orders)
+	}
+	buf.writeNode(sv.Limit)
+	if sv.Settings != nil {
+		buf.writeNode(sv.Settings)
 	}
-	sv.Limit.WriteTo(buf)
+	return buf.result()
 }
 
 type Node interface {
 	ToString() string
-	WriteTo(*bytes.Buffer)
+	io.WriterTo
 	GetWiths() []Node
 }
 
+// SelectIndexNode 记录了在select中位置的节点
+type SelectIndexNode interface {
+	GetSelectIndex() int
+	SetSelectIndex(int)
+}
+
 type NodeSet interface {
 	Node
 	IsNull() bool
 	getList() []Node
 }
 
+// sqlWriter 记录写入的字节数及第一个写入错误，出错后不再继续写入
+type sqlWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func newSQLWriter(w io.Writer) *sqlWriter {
+	return &sqlWriter{w: w}
+}
+
+func (sw *sqlWriter) WriteString(s string) {
+	if sw.err != nil {
+		return
+	}
+	n, err := io.WriteString(sw.w, s)
+	sw.n += int64(n)
+	sw.err = err
+}
+
+func (sw *sqlWriter) writeNode(node Node) {
+	if sw.err != nil {
+		return
+	}
+	n, err := node.WriteTo(sw.w)
+	sw.n += n
+	sw.err = err
+}
+
+func (sw *sqlWriter) writeSelect(v *View) {
+	if sw.err != nil {
+		return
+	}
+	n, err := v.writeSelect(sw.w)
+	sw.n += n
+	sw.err = err
+}
+
+func (sw *sqlWriter) result() (int64, error) {
+	return sw.n, sw.err
+}
+
 type NodeBase struct{}
 
 func (n *NodeBase) GetWiths() []Node {
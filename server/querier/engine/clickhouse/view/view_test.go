@@ -0,0 +1,708 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package view
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newWithModel(tags ...Node) *Model {
+	m := NewModel()
+	m.AddTable("flow_log.`l4_flow_log`")
+	for _, tag := range tags {
+		m.AddTag(tag)
+	}
+	m.Limit.Limit = "1"
+	return m
+}
+
+func TestWithsSortByAliasAndDependency(t *testing.T) {
+	// `a_half` references `b_sum`, so it must be rendered after it even though it sorts first
+	m := newWithModel(
+		&Tag{Value: "`a_half`", Withs: []Node{&With{Value: "divide(`b_sum`, 2)", Alias: "a_half"}}},
+		&Tag{Value: "`b_sum`", Withs: []Node{&With{Value: "SUM(byte)", Alias: "b_sum"}}},
+		&Tag{Value: "`_c`", Withs: []Node{&With{Value: "MAX(rtt)", Alias: "_c"}}},
+	)
+	want := "WITH MAX(rtt) AS `_c`, SUM(byte) AS `b_sum`, divide(`b_sum`, 2) AS `a_half` SELECT `a_half`, `b_sum`, `_c` FROM flow_log.`l4_flow_log` LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestWithsSortIgnoreQuoted(t *testing.T) {
+	// 字符串常量中的'name'不是对`name`的引用，仍按alias排序
+	m := newWithModel(
+		&Tag{Value: "`name`", Withs: []Node{&With{Value: "SUM(byte)", Alias: "name"}}},
+		&Tag{Value: "`a_pod`", Withs: []Node{&With{Value: "dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id)))", Alias: "a_pod"}}},
+	)
+	want := "WITH dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id))) AS `a_pod`, SUM(byte) AS `name` SELECT `name`, `a_pod` FROM flow_log.`l4_flow_log` LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+
+	for _, tc := range []struct {
+		value string
+		want  bool
+	}{
+		{"divide(`b`, 2)", true},
+		{"concat('b', b)", true},
+		{"concat('it''s b', 'x\\' b')", false},
+		{"concat('it''s', b)", true},
+		{"concat('a\\\\', b)", true},
+		{"concat('unclosed b", false},
+		{"b_sum + ab", false},
+	} {
+		if got := (&With{Value: tc.value}).isReferenced("b"); got != tc.want {
+			t.Errorf("%s: get %t, want %t", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestWithsSortReorderedSelect(t *testing.T) {
+	// select中的位置相同，tag及with的收集顺序不同
+	tagA := func() Node {
+		return &Tag{Value: "`pod`", SelectIndex: 1, Withs: []Node{&With{Value: "dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id)))", Alias: "pod"}}}
+	}
+	tagB := func() Node {
+		return &Tag{Value: "`Enum(protocol)`", SelectIndex: 2, Withs: []Node{&With{Value: "dictGetOrDefault('flow_tag.int_enum_map', 'name_en', ('protocol',toUInt64(protocol)), protocol)", Alias: "Enum(protocol)"}}}
+	}
+	first := NewView(newWithModel(tagA(), tagB())).ToString()
+	second := NewView(newWithModel(tagB(), tagA())).ToString()
+	if first != second {
+		t.Errorf("sql differ:\n%s\n%s", first, second)
+	}
+}
+
+func TestWithsNoSort(t *testing.T) {
+	v := NewView(newWithModel(
+		&Tag{Value: "`b`", Withs: []Node{&With{Value: "SUM(byte)", Alias: "b"}}},
+		&Tag{Value: "`a`", Withs: []Node{&With{Value: "SUM(packet)", Alias: "a"}}},
+	))
+	v.NoWithsSort = true
+	want := "WITH SUM(byte) AS `b`, SUM(packet) AS `a` SELECT `b`, `a` FROM flow_log.`l4_flow_log` LIMIT 1"
+	if got := v.ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestWithsDedupByAlias(t *testing.T) {
+	// 表达式中含空格的with按alias去重，只保留最先出现的
+	tagA := func() Node {
+		return &Tag{Value: "`a_half`", Withs: []Node{&With{Value: "divide(`b_sum`, 2)", Alias: "a_half"}}}
+	}
+	tagB := func() Node {
+		return &Tag{Value: "`a_half` + 1", Alias: "a_half_1", Withs: []Node{&With{Value: "divide(`b_sum`, 2)", Alias: "a_half"}}}
+	}
+	want := "WITH divide(`b_sum`, 2) AS `a_half` SELECT `a_half`, `a_half` + 1 AS `a_half_1` FROM flow_log.`l4_flow_log` LIMIT 1"
+	if got := NewView(newWithModel(tagA(), tagB())).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestViewToStringStable(t *testing.T) {
+	newModel := func() *Model {
+		m := newWithModel(
+			&Tag{Value: "`pod`", Withs: []Node{&With{Value: "dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id)))", Alias: "pod"}}},
+			&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte"}}, Alias: "sum_byte"},
+			&DivFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_DIV, Fields: []Node{
+				&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte"}}},
+				&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "packet"}}},
+			}, Alias: "bpp"}},
+		)
+		m.AddGroup(&Group{Value: "`pod`"})
+		return m
+	}
+	// 相同的model多次输出的sql逐字节相同
+	first := NewView(newModel()).ToString()
+	for i := 0; i < 10; i++ {
+		if got := NewView(newModel()).ToString(); got != first {
+			t.Fatalf("new view %d:\nget:  %s\nwant: %s", i, got, first)
+		}
+	}
+}
+
+func newWriterModel() *Model {
+	return newWithModel(
+		&Tag{Value: "`pod`", Withs: []Node{&With{Value: "dictGet('flow_tag.pod_map', 'name', (toUInt64(pod_id)))", Alias: "pod"}}},
+		&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte"}}, Alias: "sum_byte"},
+	)
+}
+
+func TestViewWriteTo(t *testing.T) {
+	want := NewView(newWriterModel()).ToString()
+	buf := bytes.Buffer{}
+	n, err := NewView(newWriterModel()).WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if buf.String() != want {
+		t.Errorf("\nget:  %s\nwant: %s", buf.String(), want)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("written bytes %d, want %d", n, len(want))
+	}
+}
+
+// failWriter 写入limit字节后返回错误
+type failWriter struct {
+	limit int
+	n     int
+}
+
+var errWriterFull = errors.New("writer full")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) > w.limit {
+		return 0, errWriterFull
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func TestViewWriteToError(t *testing.T) {
+	w := &failWriter{limit: 10}
+	n, err := NewView(newWriterModel()).WriteTo(w)
+	if err != errWriterFull {
+		t.Fatalf("get error %v, want %v", err, errWriterFull)
+	}
+	if n != int64(w.n) {
+		t.Errorf("written bytes %d, want %d", n, w.n)
+	}
+}
+
+func TestGroupingSets(t *testing.T) {
+	m := newWithModel(&Tag{Value: "region_0"}, &Tag{Value: "az_0"}, &Tag{Value: "`xx`"})
+	m.AddGroup(&Group{Value: "xx"})
+	m.AddGroup(&Group{Value: "region_id_0"})
+	m.AddGroup(&Group{Value: "az_id_0"})
+	// `xx` is not in any set, so it is kept in every set
+	m.AddGroupingSets([][]string{{"region_0", "region_id_0"}, {"az_0", "az_id_0"}, {}})
+	want := "SELECT region_0, az_0, `xx` FROM flow_log.`l4_flow_log` GROUP BY GROUPING SETS ((`xx`, `region_id_0`), (`xx`, `az_id_0`), (`xx`)) LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestWithTotals(t *testing.T) {
+	m := newWithModel(&Tag{Value: "region_0"})
+	m.AddGroup(&Group{Value: "region_id_0"})
+	m.SetWithTotals()
+	want := "SELECT region_0 FROM flow_log.`l4_flow_log` GROUP BY `region_id_0` WITH TOTALS LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+
+	// 没有group by时不输出
+	m = newWithModel(&Tag{Value: "region_0"})
+	m.SetWithTotals()
+	want = "SELECT region_0 FROM flow_log.`l4_flow_log` LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestViewToStringTwice(t *testing.T) {
+	m := NewModel()
+	m.AddTable("flow_log.`l4_flow_log`")
+	m.AddTag(&Tag{Value: "region_0", Flag: NODE_FLAG_METRICS_OUTER})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "_sum_byte_tx", Flag: METRICS_FLAG_INNER})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_AVG, Fields: []Node{&Field{Value: "_sum_byte_tx"}}, Alias: "avg_byte_tx", Flag: METRICS_FLAG_OUTER})
+	m.AddGroup(&Group{Value: "region_0"})
+	m.MetricsLevelFlag = MODEL_METRICS_LEVEL_FLAG_LAYERED
+	m.DefaultLimit = "10000"
+	v := NewView(m)
+	// 同一个view多次输出(如ToParameterizedSQLString)时结果相同
+	first := v.ToString()
+	if got := v.ToString(); got != first {
+		t.Errorf("\nget:  %s\nwant: %s", got, first)
+	}
+	if len(v.SubViewLevels) != 2 {
+		t.Errorf("sub view levels: %d", len(v.SubViewLevels))
+	}
+}
+
+func TestWithTotalsLayered(t *testing.T) {
+	m := NewModel()
+	m.AddTable("flow_log.`l4_flow_log`")
+	m.AddTag(&Tag{Value: "region_0", Flag: NODE_FLAG_METRICS_OUTER})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "_sum_byte_tx", Flag: METRICS_FLAG_INNER})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_AVG, Fields: []Node{&Field{Value: "_sum_byte_tx"}}, Alias: "avg_byte_tx", Flag: METRICS_FLAG_OUTER})
+	m.AddGroup(&Group{Value: "region_0"})
+	m.MetricsLevelFlag = MODEL_METRICS_LEVEL_FLAG_LAYERED
+	m.SetWithTotals()
+	// 只在计算层外层输出
+	want := "SELECT region_0, Avg(_sum_byte_tx) AS `avg_byte_tx` FROM (SELECT region_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_log.`l4_flow_log` GROUP BY `region_0`) GROUP BY `region_0` WITH TOTALS"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestTableFinal(t *testing.T) {
+	m := NewModel()
+	m.AddTableFinal("flow_metrics.`network_map`")
+	m.AddTag(&Tag{Value: "region_id_0", Flag: NODE_FLAG_METRICS})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "_sum_byte_tx", Flag: METRICS_FLAG_INNER})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_AVG, Fields: []Node{&Field{Value: "_sum_byte_tx"}}, Alias: "avg_byte_tx", Flag: METRICS_FLAG_OUTER})
+	m.AddGroup(&Group{Value: "region_id_0"})
+	m.MetricsLevelFlag = MODEL_METRICS_LEVEL_FLAG_LAYERED
+	// FINAL is only rendered on the physical table, not on the nested subview
+	want := "SELECT region_id_0, Avg(_sum_byte_tx) AS `avg_byte_tx` FROM (SELECT region_id_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` FINAL GROUP BY `region_id_0`) GROUP BY `region_id_0`"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestTableAlias(t *testing.T) {
+	m := NewModel()
+	m.AddTableWithAlias("flow_log.`l4_flow_log`", "f", false)
+	m.AddTag(&Tag{Value: "ip4_0"})
+	m.Limit.Limit = "1"
+	want := "SELECT ip4_0 FROM flow_log.`l4_flow_log` AS f LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+	// the alias precedes FINAL
+	m = NewModel()
+	m.AddTableWithAlias("flow_log.`l4_flow_log`", "f", true)
+	m.AddTag(&Tag{Value: "ip4_0"})
+	m.Limit.Limit = "1"
+	want = "SELECT ip4_0 FROM flow_log.`l4_flow_log` AS f FINAL LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestUnionAll(t *testing.T) {
+	newUnionModel := func(table string) *Model {
+		m := NewModel()
+		m.AddTable(table)
+		m.AddTag(&Tag{Value: "ip4_0"})
+		m.Limit.Limit = "10"
+		return m
+	}
+	v := NewView(newUnionModel("flow_log.`l4_flow_log`"))
+	v.Unions = append(v.Unions, NewView(newUnionModel("flow_log.`l7_flow_log`")))
+	want := "(SELECT ip4_0 FROM flow_log.`l4_flow_log` LIMIT 10) UNION ALL (SELECT ip4_0 FROM flow_log.`l7_flow_log` LIMIT 10)"
+	if got := v.ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+	// the order and limit of the whole union are applied on an outer select
+	v = NewView(newUnionModel("flow_log.`l4_flow_log`"))
+	v.Unions = append(v.Unions, NewView(newUnionModel("flow_log.`l7_flow_log`")))
+	v.UnionOrders = &Orders{Orders: []Node{&Order{SortBy: "ip4_0", OrderBy: "desc", IsField: true}}}
+	v.UnionLimit = &Limit{Limit: "5"}
+	want = "SELECT * FROM ((SELECT ip4_0 FROM flow_log.`l4_flow_log` LIMIT 10) UNION ALL (SELECT ip4_0 FROM flow_log.`l7_flow_log` LIMIT 10)) ORDER BY `ip4_0` desc LIMIT 5"
+	if got := v.ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestTableFunction(t *testing.T) {
+	m := NewModel()
+	m.From.Append(&Table{Value: "cluster('df', flow_log.l4_flow_log)", IsFunction: true, Final: true})
+	m.AddTag(&Tag{Value: "ip4_0"})
+	m.Limit.Limit = "1"
+	want := "SELECT ip4_0 FROM cluster('df', flow_log.l4_flow_log) LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+	m = NewModel()
+	m.AddTableFunction("cluster('df', flow_log.l4_flow_log)", "f")
+	m.AddTag(&Tag{Value: "ip4_0"})
+	m.Limit.Limit = "1"
+	want = "SELECT ip4_0 FROM cluster('df', flow_log.l4_flow_log) AS f LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestArrayJoinTag(t *testing.T) {
+	m := newWithModel(&Tag{Value: "acl_gids", Alias: "acl_gid", ArrayJoin: true}, &DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "sum_byte_tx", Flag: METRICS_FLAG_OUTER})
+	m.AddGroup(&Group{Value: "acl_gid"})
+	want := "SELECT arrayJoin(acl_gids) AS `acl_gid`, SUM(byte_tx) AS `sum_byte_tx` FROM flow_log.`l4_flow_log` GROUP BY `acl_gid` LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestArrayJoinTagLayered(t *testing.T) {
+	m := NewModel()
+	m.AddTable("flow_log.`l4_flow_log`")
+	// the array tag is expanded in the inner layer even if it is flagged for the outer one
+	m.AddTag(&Tag{Value: "acl_gids", ArrayJoin: true, Flag: NODE_FLAG_METRICS_OUTER})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "_sum_byte_tx", Flag: METRICS_FLAG_INNER})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_AVG, Fields: []Node{&Field{Value: "_sum_byte_tx"}}, Alias: "avg_byte_tx", Flag: METRICS_FLAG_OUTER})
+	m.AddGroup(&Group{Value: "acl_gids"})
+	m.MetricsLevelFlag = MODEL_METRICS_LEVEL_FLAG_LAYERED
+	want := "SELECT acl_gids, Avg(_sum_byte_tx) AS `avg_byte_tx` FROM (SELECT arrayJoin(acl_gids) AS `acl_gids`, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_log.`l4_flow_log` GROUP BY `acl_gids`) GROUP BY `acl_gids`"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestDistinctTags(t *testing.T) {
+	m := newWithModel(&Tag{Value: "region", Flag: NODE_FLAG_METRICS}, &Tag{Value: "az", Flag: NODE_FLAG_METRICS})
+	m.SetDistinct()
+	want := "SELECT DISTINCT region, az FROM flow_log.`l4_flow_log` LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestDistinctRaws(t *testing.T) {
+	m := newWithModel(&Tag{Value: "dictGet('flow_tag.region_map', 'name', (toUInt64(region_id)))", Alias: "region", Flag: NODE_FLAG_METRICS})
+	m.SetDistinct()
+	m.AddDistinctRaw(&Tag{Value: "region_id"})
+	m.AddFilter(&Filters{Expr: &Expr{Value: "az_id = 1"}})
+	want := "SELECT dictGet('flow_tag.region_map', 'name', (toUInt64(region_id))) AS `region` FROM (SELECT DISTINCT region_id FROM flow_log.`l4_flow_log` WHERE az_id = 1) LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestDistinctIgnoredWithMetrics(t *testing.T) {
+	m := newWithModel(&Tag{Value: "region", Flag: NODE_FLAG_METRICS}, &DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "sum_byte_tx", Flag: METRICS_FLAG_OUTER})
+	m.AddGroup(&Group{Value: "region"})
+	m.SetDistinct()
+	want := "SELECT region, SUM(byte_tx) AS `sum_byte_tx` FROM flow_log.`l4_flow_log` GROUP BY `region` LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestZScoreWindowLayer(t *testing.T) {
+	newZScoreModel := func() *Model {
+		m := newWithModel(
+			&Tag{Value: "region", Flag: NODE_FLAG_METRICS, SelectIndex: 1},
+			&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "`_sum_byte_tx`", Flag: METRICS_FLAG_OUTER, SelectIndex: 2},
+			&ZScoreFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_ZSCORE, Fields: []Node{&Field{Value: "`_sum_byte_tx`"}}, Alias: "z", Flag: METRICS_FLAG_TOP, SelectIndex: 2}},
+		)
+		m.AddGroup(&Group{Value: "region"})
+		m.Orders.Append(&Order{SortBy: "z", OrderBy: "desc", IsField: true})
+		return m
+	}
+	m := newZScoreModel()
+	want := "SELECT region, divide(minus(`_sum_byte_tx`, avg(`_sum_byte_tx`) OVER ()), stddevPop(`_sum_byte_tx`) OVER ()) AS `z` FROM (SELECT region, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_log.`l4_flow_log` GROUP BY `region`) ORDER BY `z` desc LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+	// 引用窗口函数结果的条件在窗口层外过滤
+	m = newZScoreModel()
+	m.AddTopFilter(&Filters{Expr: &Expr{Value: "`z` > 3"}})
+	want = "SELECT region, `z` FROM (SELECT region, divide(minus(`_sum_byte_tx`, avg(`_sum_byte_tx`) OVER ()), stddevPop(`_sum_byte_tx`) OVER ()) AS `z` FROM (SELECT region, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_log.`l4_flow_log` GROUP BY `region`)) WHERE `z` > 3 ORDER BY `z` desc LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestPercentOfTotalWindowLayer(t *testing.T) {
+	m := newWithModel(
+		&Tag{Value: "region", Flag: NODE_FLAG_METRICS, SelectIndex: 1},
+		&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "`_sum_byte_tx`", Flag: METRICS_FLAG_OUTER, SelectIndex: 2},
+		&PercentOfTotalFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_PERCENT_OF_TOTAL, Fields: []Node{&Field{Value: "`_sum_byte_tx`"}}, Alias: "p", Flag: METRICS_FLAG_TOP, SelectIndex: 2}},
+	)
+	m.AddGroup(&Group{Value: "region"})
+	// limit在窗口层生效，总和包含所有分组
+	want := "SELECT region, divide(`_sum_byte_tx`, nullIf(sum(`_sum_byte_tx`) OVER (), 0)) AS `p` FROM (SELECT region, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_log.`l4_flow_log` GROUP BY `region`) LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestMovingAvgWindowLayer(t *testing.T) {
+	m := newWithModel(
+		&Tag{Value: "toUnixTimestamp(`_toi`)", Alias: "toi", Flag: NODE_FLAG_METRICS, SelectIndex: 1},
+		&Tag{Value: "region", Flag: NODE_FLAG_METRICS, SelectIndex: 2},
+		&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "`_sum_byte_tx`", Flag: METRICS_FLAG_OUTER, SelectIndex: 3},
+		&MovingAvgFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_MOVING_AVG, Fields: []Node{&Field{Value: "`_sum_byte_tx`"}}, Args: []string{"3"}, Alias: "ma", Flag: METRICS_FLAG_TOP, SelectIndex: 3}},
+	)
+	m.Time.Alias = "toi"
+	m.AddGroup(&Group{Value: "`toi`"})
+	m.AddGroup(&Group{Value: "region"})
+	// 按time()别名排序，其余维度列分区
+	want := "SELECT `toi`, region, avg(`_sum_byte_tx`) OVER (PARTITION BY region ORDER BY `toi` ROWS BETWEEN 2 PRECEDING AND CURRENT ROW) AS `ma` FROM (SELECT toUnixTimestamp(`_toi`) AS `toi`, region, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_log.`l4_flow_log` GROUP BY `toi`, `region`) LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestSettingsOnOuterView(t *testing.T) {
+	m := newWithModel(&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "sum_byte_tx", Flag: METRICS_FLAG_INNER}, &DefaultFunction{Name: FUNCTION_AVG, Fields: []Node{&Field{Value: "`sum_byte_tx`"}}, Alias: "avg_byte_tx", Flag: METRICS_FLAG_OUTER})
+	m.MetricsLevelFlag = MODEL_METRICS_LEVEL_FLAG_LAYERED
+	m.Settings.Defaults = map[string]string{"max_execution_time": "30", "max_threads": "8"}
+	m.Settings.Set("max_threads", "4")
+	want := "SELECT Avg(`sum_byte_tx`) AS `avg_byte_tx` FROM (SELECT SUM(byte_tx) AS `sum_byte_tx` FROM flow_log.`l4_flow_log`) LIMIT 1 SETTINGS max_execution_time=30, max_threads=4"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestDeltaFunction(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		function Function
+		want     string
+	}{{
+		name:     "default_time",
+		function: &DeltaFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_DELTA, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "delta_byte_tx"}},
+		want:     "minus(argMax(byte_tx, time), argMin(byte_tx, time)) AS `delta_byte_tx`",
+	}, {
+		name:     "inner_time",
+		function: &DeltaFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_DELTA, Fields: []Node{&Field{Value: "_sum_byte_tx"}}, Args: []string{"_time"}}},
+		want:     "minus(argMax(_sum_byte_tx, _time), argMin(_sum_byte_tx, _time))",
+	}, {
+		name:     "ignore_zero",
+		function: &DeltaFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_DELTA, Fields: []Node{&Field{Value: "rtt"}}, IgnoreZero: true}},
+		want:     "minus(argMaxIf(rtt, time, rtt > 0), argMinIf(rtt, time, rtt > 0))",
+	}} {
+		if got := tc.function.ToString(); got != tc.want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLastFunction(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		function Function
+		want     string
+	}{{
+		name:     "last",
+		function: &LastFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_LAST, Fields: []Node{&Field{Value: "rtt"}}, Alias: "last_rtt"}},
+		want:     "argMax(rtt, time) AS `last_rtt`",
+	}, {
+		name:     "first_nonzero",
+		function: &LastFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_FIRST, Fields: []Node{&Field{Value: "rtt"}}, IgnoreZero: true}},
+		want:     "argMinIf(rtt, time, rtt > 0)",
+	}, {
+		name:     "inner_time",
+		function: &LastFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_LAST, Fields: []Node{&Field{Value: "_sum_byte_tx"}}, Args: []string{"_time"}}},
+		want:     "argMax(_sum_byte_tx, _time)",
+	}} {
+		if got := tc.function.ToString(); got != tc.want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestArgMaxFunction(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		function Function
+		want     string
+	}{{
+		name:     "argmax",
+		function: &ArgMaxFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_ARGMAX, Fields: []Node{&Field{Value: "pod"}}, Args: []string{"byte"}, Alias: "top_pod"}},
+		want:     "argMax(pod, byte) AS `top_pod`",
+	}, {
+		name:     "argmin_condition",
+		function: &ArgMaxFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_ARGMIN, Fields: []Node{&Field{Value: "pod"}}, Args: []string{"rtt"}, Condition: "pod != '' AND rtt > 0"}},
+		want:     "argMinIf(pod, rtt, pod != '' AND rtt > 0)",
+	}, {
+		name:     "outer",
+		function: &ArgMaxFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_ARGMAX, Fields: []Node{&Field{Value: "`_argmax_pod_byte`"}}, Args: []string{"`_argmax_pod_byte_metric`"}}},
+		want:     "argMax(`_argmax_pod_byte`, `_argmax_pod_byte_metric`)",
+	}} {
+		if got := tc.function.ToString(); got != tc.want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFillNullFunction(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		function Function
+		want     string
+		withs    []string
+	}{{
+		name:     "without_fill",
+		function: &DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte"}}, Alias: "sum_byte"},
+		want:     "SUM(byte) AS `sum_byte`",
+	}, {
+		name:     "fill_zero",
+		function: &FillNullFunction{Function: &DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte"}}}, Value: "0", DefaultFunction: DefaultFunction{Alias: "`sum_byte`"}},
+		want:     "ifNull(`fillnull_sum_byte`, 0) AS `sum_byte`",
+		withs:    []string{"SUM(byte) AS `fillnull_sum_byte`"},
+	}, {
+		name: "fill_spread",
+		function: func() Function {
+			spread := &SpreadFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_SPREAD, Fields: []Node{&Field{Value: "byte"}}}}
+			spread.Init()
+			return &FillNullFunction{Function: spread, Value: "-1", DefaultFunction: DefaultFunction{Alias: "spread_byte"}}
+		}(),
+		want:  "ifNull(`fillnull_spread_byte`, -1) AS `spread_byte`",
+		withs: []string{"minus(MAX(byte), MIN(byte)) AS `fillnull_spread_byte`"},
+	}} {
+		if got := tc.function.ToString(); got != tc.want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, got, tc.want)
+		}
+		withs := []string{}
+		for _, with := range tc.function.GetWiths() {
+			withs = append(withs, with.ToString())
+		}
+		if len(withs) != len(tc.withs) || strings.Join(withs, ", ") != strings.Join(tc.withs, ", ") {
+			t.Errorf("%s withs:\nget:  %v\nwant: %v", tc.name, withs, tc.withs)
+		}
+	}
+}
+
+func TestGeoMeanFunction(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		function Function
+		want     string
+	}{{
+		name:     "filter_non_positive",
+		function: &GeoMeanFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_GEOMEAN, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "geomean_byte_tx"}},
+		want:     "exp(AVGIf(log(byte_tx), byte_tx > 0)) AS `geomean_byte_tx`",
+	}, {
+		name:     "condition",
+		function: &GeoMeanFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_GEOMEAN, Fields: []Node{&Field{Value: "rtt"}}, Condition: "protocol = 6"}},
+		want:     "exp(AVGIf(log(rtt), protocol = 6 AND rtt > 0))",
+	}, {
+		name:     "group_array",
+		function: &GeoMeanFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_GEOMEAN, Fields: []Node{&Field{Value: "`_grouparray_rtt`"}}, IsGroupArray: true, Math: "*100"}},
+		want:     "exp(AVGArray(arrayMap(x -> log(x), arrayFilter(x -> x>0, `_grouparray_rtt`))))*100",
+	}} {
+		if got := tc.function.ToString(); got != tc.want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHarmonicMeanFunction(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		function Function
+		want     string
+	}{{
+		name:     "filter_non_positive",
+		function: &HarmonicMeanFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_HARMMEAN, Fields: []Node{&Field{Value: "rtt"}}, Alias: "hm_rtt"}},
+		want:     "divide(COUNTIf(rtt > 0), SUMIf(divide(1, rtt), rtt > 0)) AS `hm_rtt`",
+	}, {
+		name:     "condition",
+		function: &HarmonicMeanFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_HARMMEAN, Fields: []Node{&Field{Value: "rtt"}}, Condition: "protocol = 6"}},
+		want:     "divide(COUNTIf(protocol = 6 AND rtt > 0), SUMIf(divide(1, rtt), protocol = 6 AND rtt > 0))",
+	}, {
+		name:     "group_array",
+		function: &HarmonicMeanFunction{DefaultFunction: DefaultFunction{Name: FUNCTION_HARMMEAN, Fields: []Node{&Field{Value: "`_grouparray_rtt`"}}, IsGroupArray: true}},
+		want:     "divide(length(arrayFilter(x -> x>0, `_grouparray_rtt`)), arraySum(arrayMap(x -> 1/x, arrayFilter(x -> x>0, `_grouparray_rtt`))))",
+	}} {
+		if got := tc.function.ToString(); got != tc.want {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNotFilters(t *testing.T) {
+	inner := &Filters{
+		Expr:  &Expr{Value: "(`_region` = 'a')"},
+		Withs: []Node{&With{Value: "dictGet('flow_tag.region_map', 'name', toUInt64(region_id))", Alias: "_region"}},
+	}
+	m := newWithModel(&Tag{Value: "region_id"})
+	m.AddFilter(&Filters{Expr: &BinaryExpr{
+		Left:  &Not{Expr: &BinaryExpr{Left: inner, Right: &Expr{Value: "(az_id = 1)"}, Op: &Operator{Type: AND}}},
+		Right: &Not{Expr: &Expr{Value: "(az_id = 2)"}},
+		Op:    &Operator{Type: OR},
+	}})
+	// the withs inside the negated group are still collected
+	want := "WITH dictGet('flow_tag.region_map', 'name', toUInt64(region_id)) AS `_region` SELECT region_id FROM flow_log.`l4_flow_log` WHERE NOT ((`_region` = 'a') AND (az_id = 1)) OR NOT ((az_id = 2)) LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestSelectIndexOrder(t *testing.T) {
+	m := NewModel()
+	m.AddTable("flow_metrics.`network_map`")
+	m.SelectIndex = 2
+	m.AddTag(&Tag{Value: "region_0", Flag: NODE_FLAG_METRICS})
+	m.SelectIndex = 1
+	m.AddTag(&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "_sum_byte_tx", Flag: METRICS_FLAG_INNER})
+	m.AddTag(&DefaultFunction{Name: FUNCTION_AVG, Fields: []Node{&Field{Value: "_sum_byte_tx"}}, Alias: "avg_byte_tx", Flag: METRICS_FLAG_OUTER})
+	m.SelectIndex = 3
+	m.AddTag(&Tag{Value: "toUnixTimestamp(`_time`)", Alias: "time_60", Flag: NODE_FLAG_METRICS_OUTER})
+	// columns added for grouping are not in the select list and are kept at the end
+	m.SelectIndex = 0
+	m.AddTag(&Tag{Value: "az_0", Flag: NODE_FLAG_METRICS})
+	m.AddGroup(&Group{Value: "region_0"})
+	m.AddGroup(&Group{Value: "az_0"})
+	m.MetricsLevelFlag = MODEL_METRICS_LEVEL_FLAG_LAYERED
+	want := "SELECT Avg(_sum_byte_tx) AS `avg_byte_tx`, region_0, toUnixTimestamp(`_time`) AS `time_60`, az_0 FROM (SELECT region_0, az_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_metrics.`network_map` GROUP BY `region_0`, `az_0`) GROUP BY `region_0`, `az_0`"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestParameterizedFilters(t *testing.T) {
+	m := newWithModel(&Tag{Value: "ip4_0"})
+	m.AddFilter(&Filters{Expr: &Expr{Value: "(`region_0` = 'a\\'b') AND ip4_0 > toIPv4OrNull('1.1.1.1') AND rtt > 1.5 AND server_port IN (80, 443, 80) AND byte > 1e10"}})
+	params := NewQueryParams()
+	m.Filters.Params = params
+	want := "SELECT ip4_0 FROM flow_log.`l4_flow_log` WHERE (`region_0` = {p0:String}) AND ip4_0 > toIPv4OrNull({p1:String}) AND rtt > {p2:Float64} AND server_port IN ({p3:UInt64}, {p4:UInt64}, {p3:UInt64}) AND byte > 1e10 LIMIT 1"
+	if got := NewView(m).ToString(); got != want {
+		t.Errorf("\nget:  %s\nwant: %s", got, want)
+	}
+	wantParams := map[string]string{"p0": "a'b", "p1": "1.1.1.1", "p2": "1.5", "p3": "80", "p4": "443"}
+	if !reflect.DeepEqual(params.Values, wantParams) {
+		t.Errorf("get params %v, want %v", params.Values, wantParams)
+	}
+}
+
+func TestClusterGlobalIn(t *testing.T) {
+	and := func(left, right Node) Node {
+		return &BinaryExpr{Left: left, Right: right, Op: &Operator{Type: AND}}
+	}
+	newModel := func(cluster bool) *Model {
+		m := NewModel()
+		m.AddTable("flow_log.`l4_flow_log`")
+		m.AddTag(&Tag{Value: "region_0", Flag: NODE_FLAG_METRICS_OUTER})
+		m.AddTag(&DefaultFunction{Name: FUNCTION_SUM, Fields: []Node{&Field{Value: "byte_tx"}}, Alias: "_sum_byte_tx", Flag: METRICS_FLAG_INNER})
+		m.AddTag(&DefaultFunction{Name: FUNCTION_AVG, Fields: []Node{&Field{Value: "_sum_byte_tx"}}, Alias: "avg_byte_tx", Flag: METRICS_FLAG_OUTER})
+		m.AddGroup(&Group{Value: "region_0"})
+		m.AddFilter(&Filters{Expr: and(and(
+			&Nested{Expr: &InSubquery{Expr: &Expr{Value: "toUInt64(pod_id_0)"}, Subquery: "SELECT id FROM flow_tag.pod_map WHERE name = 'in (select 1)'"}},
+			&Expr{Value: "protocol IN (6, 17)"}),
+			&Not{Expr: &InSubquery{Expr: &Expr{Value: "toUInt64(service_id_0)"}, Not: true, Subquery: "SELECT id FROM flow_tag.service_map"}},
+		)})
+		m.PreFilters.Append(&Filters{Expr: &InSubquery{Expr: &Expr{Value: "toUInt64(target_id)"}, Subquery: "SELECT target_id FROM flow_tag.target_label_live_view"}})
+		m.AddHaving(&Filters{Expr: &InSubquery{Expr: &Expr{Value: "region_0"}, Subquery: "SELECT name FROM flow_tag.region_map"}})
+		m.MetricsLevelFlag = MODEL_METRICS_LEVEL_FLAG_LAYERED
+		m.Cluster = cluster
+		return m
+	}
+	// 只有读取表的里层改为GLOBAL，常量列表及外层的HAVING不变
+	for _, c := range []struct {
+		cluster bool
+		want    string
+	}{{
+		cluster: false,
+		want:    "SELECT region_0, Avg(_sum_byte_tx) AS `avg_byte_tx` FROM (SELECT region_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_log.`l4_flow_log` PREWHERE toUInt64(target_id) IN (SELECT target_id FROM flow_tag.target_label_live_view) WHERE (toUInt64(pod_id_0) IN (SELECT id FROM flow_tag.pod_map WHERE name = 'in (select 1)')) AND protocol IN (6, 17) AND NOT (toUInt64(service_id_0) NOT IN (SELECT id FROM flow_tag.service_map)) GROUP BY `region_0`) GROUP BY `region_0` HAVING region_0 IN (SELECT name FROM flow_tag.region_map)",
+	}, {
+		cluster: true,
+		want:    "SELECT region_0, Avg(_sum_byte_tx) AS `avg_byte_tx` FROM (SELECT region_0, SUM(byte_tx) AS `_sum_byte_tx` FROM flow_log.`l4_flow_log` PREWHERE toUInt64(target_id) GLOBAL IN (SELECT target_id FROM flow_tag.target_label_live_view) WHERE (toUInt64(pod_id_0) GLOBAL IN (SELECT id FROM flow_tag.pod_map WHERE name = 'in (select 1)')) AND protocol IN (6, 17) AND NOT (toUInt64(service_id_0) GLOBAL NOT IN (SELECT id FROM flow_tag.service_map)) GROUP BY `region_0`) GROUP BY `region_0` HAVING region_0 IN (SELECT name FROM flow_tag.region_map)",
+	}} {
+		if get := NewView(newModel(c.cluster)).ToString(); get != c.want {
+			t.Errorf("cluster %v:\nget:  %s\nwant: %s", c.cluster, get, c.want)
+		}
+	}
+}
@@ -18,6 +18,8 @@ package view
 
 import (
 	"bytes"
+	"io"
+	"sort"
 	"strings"
 )
 
@@ -36,13 +38,15 @@ func (s *Withs) getList() []Node {
 	return s.Withs
 }
 
-func (s *Withs) WriteTo(buf *bytes.Buffer) {
+func (s *Withs) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	for i, tag := range s.Withs {
-		tag.WriteTo(buf)
+		buf.writeNode(tag)
 		if i < len(s.Withs)-1 {
 			buf.WriteString(", ")
 		}
 	}
+	return buf.result()
 }
 
 func (s *Withs) Append(w *With) {
@@ -67,7 +71,8 @@ type With struct {
 	NodeBase
 }
 
-func (n *With) WriteTo(buf *bytes.Buffer) {
+func (n *With) WriteTo(w io.Writer) (int64, error) {
+	buf := newSQLWriter(w)
 	buf.WriteString(n.Value)
 	if n.Alias != "" {
 		buf.WriteString(" AS ")
@@ -76,6 +81,7 @@ func (n *With) WriteTo(buf *bytes.Buffer) {
 		buf.WriteString(alias)
 		buf.WriteString("`")
 	}
+	return buf.result()
 }
 
 func (n *With) ToString() string {
@@ -83,3 +89,101 @@ func (n *With) ToString() string {
 	n.WriteTo(&buf)
 	return buf.String()
 }
+
+func (n *With) getAlias() string {
+	return strings.Trim(n.Alias, "`")
+}
+
+// isReferenced 判断with的表达式中是否引用了指定alias，字符串常量中的内容不是引用
+func (n *With) isReferenced(alias string) bool {
+	if alias == "" {
+		return false
+	}
+	value := n.Value
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\'' {
+			i = skipStringLiteral(value, i)
+			continue
+		}
+		end := i + len(alias)
+		if strings.HasPrefix(value[i:], alias) && (i == 0 || !isIdentifierByte(value[i-1])) && (end == len(value) || !isIdentifierByte(value[end])) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipStringLiteral 返回start处开始的字符串常量结束引号的位置，两个连续的引号及反斜杠转义的引号不结束字符串
+func skipStringLiteral(value string, start int) int {
+	for i := start + 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '\'':
+			if i+1 < len(value) && value[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(value)
+}
+
+func isIdentifierByte(b byte) bool {
+	return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func withSortKey(node Node) string {
+	if with, ok := node.(*With); ok && with.getAlias() != "" {
+		return with.getAlias()
+	}
+	return node.ToString()
+}
+
+// sortWiths 按alias对with排序，保证等价的查询生成相同的sql
+// 当with之间存在引用时，被引用的with始终排在前面
+func sortWiths(withs []Node) []Node {
+	sorted := make([]Node, len(withs))
+	copy(sorted, withs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return withSortKey(sorted[i]) < withSortKey(sorted[j])
+	})
+	result := make([]Node, 0, len(sorted))
+	emitted := make([]bool, len(sorted))
+	for len(result) < len(sorted) {
+		next := -1
+		for i, node := range sorted {
+			if emitted[i] {
+				continue
+			}
+			if next < 0 {
+				// 存在循环引用时退化为按alias排序
+				next = i
+			}
+			with, ok := node.(*With)
+			if !ok {
+				next = i
+				break
+			}
+			ready := true
+			for j, dep := range sorted {
+				depWith, ok := dep.(*With)
+				if i == j || emitted[j] || !ok {
+					continue
+				}
+				if with.isReferenced(depWith.getAlias()) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		emitted[next] = true
+		result = append(result, sorted[next])
+	}
+	return result
+}
@@ -25,11 +25,18 @@ type Engine interface {
 	TransSelect(sqlparser.SelectExprs) error
 	TransFrom(sqlparser.TableExprs) error
 	TransGroupBy(sqlparser.GroupBy) error
+	TransGroupingSets([]sqlparser.GroupBy) error
+	TransFinal() error
+	TransTableFunction(prefix, suffix string) error
+	TransWithTotals() error
+	TransDistinct() error
 	TransDerivativeGroupBy(sqlparser.GroupBy) error
 	TransWhere(*sqlparser.Where) error
 	TransHaving(*sqlparser.Where) error
 	TransOrderBy(sqlparser.OrderBy) error
 	TransLimit(*sqlparser.Limit) error
+	TransSettings(map[string]string) error
+	NormalizeTagNames(*sqlparser.Select)
 	ToSQLString() string
 	Init()
 	ExecuteQuery(*common.QuerierParams) (*common.Result, map[string]interface{}, error)
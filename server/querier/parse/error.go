@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sqlparser的错误信息，如syntax error at position 15 near 'from'
+var sqlparserErrorRegexp = regexp.MustCompile(`(?s)^(.*) at position (\d+)(?: near '(.*)')?$`)
+
+// 错误片段前后各保留的字节数
+const parseErrorSnippetContext = 20
+
+// 可由多个字符组成的操作符，如>=、!=
+const operatorChars = "=<>!+-*/%|&^~"
+
+// ParseError sql解析失败的位置，供前端标出出错的片段
+type ParseError struct {
+	Message  string // 如syntax error
+	Position int    // 出错的token在原始sql中的字节偏移，sql不完整时为sql的长度
+	Near     string // 出错的token，sql不完整时为空
+	Snippet  string // 出错位置前后的sql片段
+}
+
+func (e *ParseError) Error() string {
+	if e.Near == "" {
+		return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+	}
+	return fmt.Sprintf("%s at position %d near '%s'", e.Message, e.Position, e.Near)
+}
+
+// NewParseError 将sqlparser返回的错误转换为ParseError，rewritten为sql改写后实际交给sqlparser解析的sql，
+// 非sqlparser的错误原样返回
+func NewParseError(err error, sql, rewritten string) error {
+	match := sqlparserErrorRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	// sqlparser的position为出错token之后一个字节的下标
+	end, _ := strconv.Atoi(match[2])
+	end = min(max(end-1, 0), len(rewritten))
+	near := match[3]
+	start := end
+	if near != "" {
+		// 字符串的token不含引号，向前查找
+		if index := strings.LastIndex(rewritten[:end], near); index >= 0 {
+			start = index
+		}
+	} else {
+		// 操作符不在错误信息中，取出错位置前的操作符，sql不完整时为空
+		if start > 0 && strings.IndexByte("(),;", rewritten[start-1]) >= 0 {
+			start--
+		} else {
+			for start > 0 && strings.IndexByte(operatorChars, rewritten[start-1]) >= 0 {
+				start--
+			}
+		}
+		near = rewritten[start:end]
+	}
+	position := locateToken(sql, rewritten, start, near)
+	return &ParseError{
+		Message:  match[1],
+		Position: position,
+		Near:     near,
+		Snippet:  sql[max(position-parseErrorSnippetContext, 0):min(position+len(near)+parseErrorSnippetContext, len(sql))],
+	}
+}
+
+// locateToken sql经过改写时，按出错token在改写后的sql中是第几次出现，找到其在原始sql中的位置
+func locateToken(sql, rewritten string, start int, near string) int {
+	if sql == rewritten {
+		return start
+	}
+	if near == "" {
+		if start >= len(rewritten) {
+			return len(sql)
+		}
+		return min(start, len(sql))
+	}
+	count := strings.Count(rewritten[:min(start+len(near), len(rewritten))], near)
+	position, next := min(start, len(sql)), 0
+	for i := 0; i < count; i++ {
+		index := strings.Index(sql[next:], near)
+		if index < 0 {
+			return min(start, len(sql))
+		}
+		position = next + index
+		next = position + len(near)
+	}
+	return position
+}
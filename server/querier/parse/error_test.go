@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseErrorPosition(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		sql      string
+		position int
+		near     string
+		snippet  string
+	}{{
+		name:     "misspelled_keyword",
+		sql:      "selec region_0 from l4_flow_log",
+		position: 0,
+		near:     "selec",
+		snippet:  "selec region_0 from l4_fl",
+	}, {
+		name:     "trailing_comma",
+		sql:      "select region_0, from l4_flow_log limit 1",
+		position: 17,
+		near:     "from",
+		snippet:  "select region_0, from l4_flow_log limit 1",
+	}, {
+		name:     "incomplete",
+		sql:      "select Sum(byte) as sum_byte from l4_flow_log where",
+		position: 51,
+		snippet:  "om l4_flow_log where",
+	}, {
+		name:     "unterminated_string",
+		sql:      "select region_0 from l4_flow_log where region_0 = 'a",
+		position: 51,
+		near:     "a",
+		snippet:  "g where region_0 = 'a",
+	}, {
+		// ilike改写为like后，位置仍对应原始sql
+		name:     "after_rewrite",
+		sql:      "select region_0 from l4_flow_log where region_0 ilike 'a*' and byte = = 1",
+		position: 70,
+		near:     "=",
+		snippet:  "ike 'a*' and byte = = 1",
+	}, {
+		name:     "before_settings",
+		sql:      "select region_0 from l4_flow_log where byte > limit 1 settings max_threads=4",
+		position: 46,
+		near:     "limit",
+		snippet:  "ow_log where byte > limit 1 settings max_thre",
+	}, {
+		name:     "extra_paren",
+		sql:      "select region_0 from l4_flow_log where (byte > 1))",
+		position: 49,
+		near:     ")",
+		snippet:  "log where (byte > 1))",
+	}} {
+		p := Parser{}
+		err := p.ParseSQL(tc.sql)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: get %v, want ParseError", tc.name, err)
+			continue
+		}
+		if parseErr.Position != tc.position || parseErr.Near != tc.near || parseErr.Snippet != tc.snippet {
+			t.Errorf("%s: get position %d near '%s' snippet '%s', want position %d near '%s' snippet '%s'",
+				tc.name, parseErr.Position, parseErr.Near, parseErr.Snippet, tc.position, tc.near, tc.snippet)
+		}
+		if tc.near != "" && !strings.HasPrefix(tc.sql[parseErr.Position:], tc.near) {
+			t.Errorf("%s: sql at position %d is '%s', want '%s'", tc.name, parseErr.Position, tc.sql[parseErr.Position:], tc.near)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 查询时替换的宏，与Grafana模板中的变量同名
+const (
+	MACRO_FROM        = "$__from"
+	MACRO_TO          = "$__to"
+	MACRO_INTERVAL    = "$__interval"
+	MACRO_INTERVAL_MS = "$__interval_ms"
+)
+
+var SUPPORTED_MACROS = []string{MACRO_FROM, MACRO_TO, MACRO_INTERVAL, MACRO_INTERVAL_MS}
+
+var macroRegexp = regexp.MustCompile(`\$\w+`)
+
+// Macros 宏的取值，From、To为秒级时间戳，Interval为秒，为0时表示未设置
+type Macros struct {
+	From     int64
+	To       int64
+	Interval int
+}
+
+// SubstituteMacros 将引号外的宏替换为对应的数值，$__from、$__to用于时间过滤条件，$__interval用于time()的时间间隔
+func SubstituteMacros(sql string, macros Macros) (string, error) {
+	if !strings.Contains(sql, "$") {
+		return sql, nil
+	}
+	ts := tokenize(sql)
+	for i, t := range ts {
+		if t.kind != tokenWord || !strings.Contains(t.text, "$") {
+			continue
+		}
+		var err error
+		ts[i].text = macroRegexp.ReplaceAllStringFunc(t.text, func(name string) string {
+			value, valueErr := macros.value(name)
+			if valueErr != nil && err == nil {
+				err = valueErr
+			}
+			return value
+		})
+		if err != nil {
+			return sql, err
+		}
+	}
+	return ts.String(), nil
+}
+
+func (m Macros) value(name string) (string, error) {
+	var value int64
+	switch name {
+	case MACRO_FROM:
+		value = m.From
+	case MACRO_TO:
+		value = m.To
+	case MACRO_INTERVAL:
+		value = int64(m.Interval)
+	case MACRO_INTERVAL_MS:
+		value = int64(m.Interval) * 1000
+	default:
+		return "", fmt.Errorf("unknown macro %s, supported macros: %s", name, strings.Join(SUPPORTED_MACROS, ", "))
+	}
+	if value <= 0 {
+		return "", fmt.Errorf("macro %s is used but its value is not set", name)
+	}
+	return strconv.FormatInt(value, 10), nil
+}
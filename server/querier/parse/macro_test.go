@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"testing"
+)
+
+func TestSubstituteMacros(t *testing.T) {
+	macros := Macros{From: 1700000000, To: 1700003600, Interval: 60}
+	for _, tc := range []struct {
+		name    string
+		sql     string
+		output  string
+		wantErr string
+	}{{
+		name:   "where",
+		sql:    "select Sum(byte) as sum_byte from l4_flow_log where time >= $__from and time <= $__to limit 1",
+		output: "select Sum(byte) as sum_byte from l4_flow_log where time >= 1700000000 and time <= 1700003600 limit 1",
+	}, {
+		name:   "time",
+		sql:    "select time(time, $__interval) as toi, Sum(byte) as sum_byte from l4_flow_log group by toi limit 1",
+		output: "select time(time, 60) as toi, Sum(byte) as sum_byte from l4_flow_log group by toi limit 1",
+	}, {
+		name:   "arithmetic",
+		sql:    "select Sum(byte)/$__interval as bps, Sum(byte)*1000/$__interval_ms as bps_ms from l4_flow_log where time >= $__to-3600 limit 1",
+		output: "select Sum(byte)/60 as bps, Sum(byte)*1000/60000 as bps_ms from l4_flow_log where time >= 1700003600-3600 limit 1",
+	}, {
+		// 引号内的内容不替换
+		name:   "quoted",
+		sql:    "select region_0 as `$__from` from l4_flow_log where region_0 = '$__to' limit 1",
+		output: "select region_0 as `$__from` from l4_flow_log where region_0 = '$__to' limit 1",
+	}, {
+		// 转义的引号不结束字符串
+		name:   "escaped_quotes",
+		sql:    `select region_0 from l4_flow_log where region_0 = 'it''s $__to' and region_1 = 'a\' $__from' and time >= $__from limit 1`,
+		output: `select region_0 from l4_flow_log where region_0 = 'it''s $__to' and region_1 = 'a\' $__from' and time >= 1700000000 limit 1`,
+	}, {
+		name:    "unknown",
+		sql:     "select Sum(byte) as sum_byte from l4_flow_log where time >= $__timeFrom limit 1",
+		wantErr: "unknown macro $__timeFrom, supported macros: $__from, $__to, $__interval, $__interval_ms",
+	}} {
+		output, err := SubstituteMacros(tc.sql, macros)
+		if tc.wantErr != "" {
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("%s: get error %v, want %s", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		} else if output != tc.output {
+			t.Errorf("%s:\nget:  %s\nwant: %s", tc.name, output, tc.output)
+		}
+	}
+
+	// 未设置取值的宏
+	if _, err := SubstituteMacros("select time(time, $__interval) as toi from l4_flow_log", Macros{From: 1}); err == nil {
+		t.Error("unset macro: want error")
+	}
+}
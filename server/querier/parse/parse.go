@@ -17,13 +17,20 @@
 package parse
 
 import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/xwb1989/sqlparser"
 
 	"github.com/deepflowio/deepflow/server/querier/engine"
 )
 
 type Parser struct {
-	Engine engine.Engine
+	Engine            engine.Engine
+	Context           context.Context // 不为空时在各解析阶段之间检查是否已取消或超时
+	DefaultGroupOrder bool            // 未指定ORDER BY时按group排序，time()的列在前，均为升序
 }
 
 func NewParser() *Parser {
@@ -32,13 +39,42 @@ func NewParser() *Parser {
 
 // 解析入口，解析结果写入Model
 func (p *Parser) ParseSQL(sql string) error {
-	// sql解析
-	stmt, err := sqlparser.Parse(sql)
+	if err := p.checkContext(); err != nil {
+		return err
+	}
+	stmt, pre, err := Prepare(sql)
 	if err != nil {
 		return err
 	}
+	if err := p.checkContext(); err != nil {
+		return err
+	}
 
-	pStmt := stmt.(*sqlparser.Select)
+	pStmt, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		// union all由engine按分支解析，走到这里说明使用了分支中不支持的语法
+		if _, isUnion := stmt.(*sqlparser.Union); isUnion {
+			return fmt.Errorf("settings, grouping sets and with totals are not supported in union")
+		}
+		return fmt.Errorf("unsupported statement: %s", sqlparser.String(stmt))
+	}
+	return p.ParseStmt(pStmt, pre)
+}
+
+// checkContext 返回ctx已取消或超时的错误，用于在解析阶段之间中止异常耗时的解析
+func (p *Parser) checkContext() error {
+	if p.Context == nil {
+		return nil
+	}
+	return p.Context.Err()
+}
+
+// ParseStmt 解析已构造好的select语句，pre为预处理时去掉的语法，为空时表示没有
+func (p *Parser) ParseStmt(pStmt *sqlparser.Select, pre *Preparsed) error {
+	if pre == nil {
+		pre = &Preparsed{}
+	}
+	resolveTableAlias(pStmt)
 	// From解析
 	if pStmt.From != nil {
 		fromErr := p.Engine.TransFrom(pStmt.From)
@@ -46,6 +82,14 @@ func (p *Parser) ParseSQL(sql string) error {
 			return fromErr
 		}
 	}
+	// 表函数解析
+	if function := pre.TableFunction(); function != nil {
+		if err := p.Engine.TransTableFunction(function.Prefix, function.Suffix); err != nil {
+			return err
+		}
+	}
+	// from解析后才能确定tag所属的表，再统一tag名的大小写
+	p.Engine.NormalizeTagNames(pStmt)
 
 	// DerivativeGroupBy解析
 	if pStmt.GroupBy != nil {
@@ -55,6 +99,9 @@ func (p *Parser) ParseSQL(sql string) error {
 		}
 	}
 
+	if err := p.checkContext(); err != nil {
+		return err
+	}
 	// Select解析
 	var selectErr error
 	if pStmt.SelectExprs != nil {
@@ -64,6 +111,17 @@ func (p *Parser) ParseSQL(sql string) error {
 		}
 	}
 
+	// Distinct解析
+	if pStmt.Distinct != "" {
+		distinctErr := p.Engine.TransDistinct()
+		if distinctErr != nil {
+			return distinctErr
+		}
+	}
+
+	if err := p.checkContext(); err != nil {
+		return err
+	}
 	// Where 解析
 	if pStmt.Where != nil {
 		whereErr := p.Engine.TransWhere(pStmt.Where)
@@ -72,6 +130,9 @@ func (p *Parser) ParseSQL(sql string) error {
 		}
 	}
 
+	if err := p.checkContext(); err != nil {
+		return err
+	}
 	// GroupBy解析
 	if pStmt.GroupBy != nil {
 		groupErr := p.Engine.TransGroupBy(pStmt.GroupBy)
@@ -80,6 +141,27 @@ func (p *Parser) ParseSQL(sql string) error {
 		}
 	}
 
+	// GroupingSets解析
+	if pre.GroupingSets != nil && pStmt.GroupBy != nil {
+		sets := make([]sqlparser.GroupBy, 0, len(pre.GroupingSets))
+		for _, indexes := range pre.GroupingSets {
+			set := sqlparser.GroupBy{}
+			for _, index := range indexes {
+				if index < len(pStmt.GroupBy) {
+					set = append(set, pStmt.GroupBy[index])
+				}
+			}
+			sets = append(sets, set)
+		}
+		groupingSetsErr := p.Engine.TransGroupingSets(sets)
+		if groupingSetsErr != nil {
+			return groupingSetsErr
+		}
+	}
+
+	if err := p.checkContext(); err != nil {
+		return err
+	}
 	if pStmt.Having != nil {
 		havingErr := p.Engine.TransHaving(pStmt.Having)
 		if havingErr != nil {
@@ -87,9 +169,16 @@ func (p *Parser) ParseSQL(sql string) error {
 		}
 	}
 
+	if err := p.checkContext(); err != nil {
+		return err
+	}
 	// OrderBy解析
-	if pStmt.OrderBy != nil {
-		orderErr := p.Engine.TransOrderBy(pStmt.OrderBy)
+	orderBy := pStmt.OrderBy
+	if orderBy == nil && p.DefaultGroupOrder {
+		orderBy = defaultGroupOrderBy(pStmt)
+	}
+	if orderBy != nil {
+		orderErr := p.Engine.TransOrderBy(orderBy)
 		if orderErr != nil {
 			return orderErr
 		}
@@ -102,5 +191,68 @@ func (p *Parser) ParseSQL(sql string) error {
 			return limitErr
 		}
 	}
+	// Final解析
+	if slices.Contains(pre.Finals, true) {
+		if err := p.Engine.TransFinal(); err != nil {
+			return err
+		}
+	}
+	// With totals解析
+	if pre.WithTotals {
+		if err := p.Engine.TransWithTotals(); err != nil {
+			return err
+		}
+	}
+	// Settings解析
+	if pre.Settings != nil {
+		return p.Engine.TransSettings(pre.Settings)
+	}
 	return nil
 }
+
+// defaultGroupOrderBy 按group by的列升序排序，select中time()的别名排在最前，堆叠图按时间及维度的顺序输出
+func defaultGroupOrderBy(pStmt *sqlparser.Select) sqlparser.OrderBy {
+	timeAliases := map[string]bool{}
+	for _, expr := range pStmt.SelectExprs {
+		item, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok || item.As.IsEmpty() {
+			continue
+		}
+		if function, ok := item.Expr.(*sqlparser.FuncExpr); ok && function.Name.Lowered() == "time" {
+			timeAliases[strings.Trim(item.As.String(), "`")] = true
+		}
+	}
+	var timeOrders, tagOrders sqlparser.OrderBy
+	for _, group := range pStmt.GroupBy {
+		colName, ok := group.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		order := &sqlparser.Order{Expr: colName, Direction: sqlparser.AscScr}
+		if timeAliases[strings.Trim(colName.Name.String(), "`")] {
+			timeOrders = append(timeOrders, order)
+		} else {
+			tagOrders = append(tagOrders, order)
+		}
+	}
+	return append(timeOrders, tagOrders...)
+}
+
+// from <table> as <alias>时，去掉列中的别名限定符，如f.byte改写为byte
+func resolveTableAlias(stmt *sqlparser.Select) {
+	aliases := []string{}
+	for _, from := range stmt.From {
+		if table, ok := from.(*sqlparser.AliasedTableExpr); ok && !table.As.IsEmpty() {
+			aliases = append(aliases, table.As.String())
+		}
+	}
+	if len(aliases) == 0 {
+		return
+	}
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if col, ok := node.(*sqlparser.ColName); ok && col.Qualifier.Qualifier.IsEmpty() && slices.Contains(aliases, col.Qualifier.Name.String()) {
+			col.Qualifier = sqlparser.TableName{}
+		}
+		return true, nil
+	}, stmt)
+}
@@ -0,0 +1,638 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+var settingKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var settingValueRegexp = regexp.MustCompile(`^('[^'\\]*'|-?[A-Za-z0-9_.]+)$`)
+
+// 算子后的fillnull(0)改写为该函数包裹算子，如Sum(byte) fillnull(0)改写为FillNull(Sum(byte), 0)
+const FUNCTION_FILL_NULL = "FillNull"
+
+// 数组tag的过滤操作符，如tags hasAny ('a','b')
+const (
+	OPERATOR_HAS_ANY = "hasAny"
+	OPERATOR_HAS_ALL = "hasAll"
+)
+
+// from之后支持的表函数，表名需以db.table的形式作为一个参数，如cluster('df', flow_log.l4_flow_log)
+var TABLE_FUNCTIONS = []string{"cluster", "clusterAllReplicas", "remote", "remoteSecure"}
+
+// sqlparser不支持的操作符改写后的标记，解析后按标记所在的节点还原，不依赖遍历顺序
+const (
+	markerILike  = "__ilike"  // a ilike 'x'改写为a like __ilike('x')
+	markerHasAny = "__hasany" // tags hasAny ('a')改写为tags in (__hasany, 'a')
+	markerHasAll = "__hasall"
+)
+
+// Preparsed sql中sqlparser不支持的语法，预处理时去掉或改写，解析后还原到语法树中或交给engine
+type Preparsed struct {
+	SQL            string            // 实际交给sqlparser解析的sql
+	Settings       map[string]string // 末尾的settings k=v, ...
+	WithTotals     bool              // group by之后的with totals
+	Finals         []bool            // 按出现顺序每个from是否带final，union all中第i个from对应第i个分支
+	TableFunctions []*TableFunction  // 按出现顺序每个from的表函数，不是表函数时为空
+	GroupingSets   [][]int           // 每个集合中的group在改写后group by中的下标
+	orderModifiers []string          // 顶层order by每一项的nulls first/last及collate
+}
+
+// TableFunction from之后的表函数，预处理时只保留其中的表名，Prefix、Suffix为表名之前及之后的部分
+type TableFunction struct {
+	Prefix string // 如cluster('df',
+	Suffix string // 如)
+}
+
+// TableFunction 返回第一个from的表函数，没有时为空
+func (p *Preparsed) TableFunction() *TableFunction {
+	for _, function := range p.TableFunctions {
+		if function != nil {
+			return function
+		}
+	}
+	return nil
+}
+
+// Branch 返回union all中第i个分支的预处理结果，分支中只有final及表函数
+func (p *Preparsed) Branch(i int) *Preparsed {
+	branch := &Preparsed{}
+	if i < len(p.Finals) {
+		branch.Finals = p.Finals[i : i+1]
+		branch.TableFunctions = p.TableFunctions[i : i+1]
+	}
+	return branch
+}
+
+// Prepare 预处理sql后交给sqlparser解析，并将ilike、hasAny/hasAll及排序修饰还原到语法树中，
+// sqlparser的错误转换为对应原始sql位置的ParseError
+func Prepare(sql string) (sqlparser.Statement, *Preparsed, error) {
+	pre, err := preparse(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	stmt, err := sqlparser.Parse(pre.SQL)
+	if err != nil {
+		return nil, pre, NewParseError(err, sql, pre.SQL)
+	}
+	pre.restore(stmt)
+	return stmt, pre, nil
+}
+
+// preparse 对sql分词后只扫描一次：去掉settings、with totals、final及排序修饰，将grouping sets改写为普通group by，
+// 将fillnull改写为FillNull函数，将ilike、hasAny/hasAll改写为带标记的like、in
+func preparse(sql string) (*Preparsed, error) {
+	p := &Preparsed{}
+	ts := tokenize(sql)
+	out := make(tokens, 0, len(ts))
+	depth, orderIndex := 0, 0
+	// 顶层是否已出现group by，当前是否在顶层的order by中
+	groupBy, orderBy := false, false
+	for i := 0; i < len(ts); i++ {
+		t := ts[i]
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+			if j := ts.next(i); ts.at(j).is("fillnull") && ts.at(ts.next(j)).is("(") {
+				var err error
+				if out, i, err = rewriteFillNull(append(out, t), ts, i, ts.next(j)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		case depth == 0 && t.is(","):
+			if orderBy {
+				orderIndex++
+			}
+		case depth == 0 && t.is("settings"):
+			// settings只能是最后一个子句
+			settings, err := parseSettings(ts[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			p.Settings = settings
+			p.SQL = out.trim().String()
+			return p, nil
+		case depth == 0 && t.is("with") && ts.at(ts.next(i)).is("totals"):
+			if p.WithTotals {
+				return nil, fmt.Errorf("with totals can only be used once")
+			}
+			if !groupBy {
+				return nil, fmt.Errorf("with totals requires group by")
+			}
+			p.WithTotals = true
+			out = out.trim()
+			i = ts.next(i)
+			continue
+		case t.is("from"):
+			start := ts.next(i)
+			end := ts.tableEnd(start)
+			var function *TableFunction
+			if table, tableEnd, functionEnd, ok := ts.tableFunction(start); ok {
+				function = &TableFunction{Prefix: ts[start:table].String(), Suffix: ts[tableEnd+1 : functionEnd+1].String()}
+				out = append(out, ts[i:start]...)
+				out = append(out, ts[table:tableEnd+1]...)
+				end = functionEnd
+			} else if end < 0 {
+				// 子查询等不是表名
+				break
+			} else {
+				out = append(out, ts[i:end+1]...)
+			}
+			final := ts.final(end)
+			p.Finals = append(p.Finals, final >= 0)
+			p.TableFunctions = append(p.TableFunctions, function)
+			if final >= 0 {
+				out = append(out, ts[end+1:final]...).trim()
+				i = final
+			} else {
+				i = end
+			}
+			continue
+		case t.is("group") && ts.at(ts.next(i)).is("by"):
+			if depth == 0 {
+				groupBy = true
+			}
+			if p.GroupingSets != nil {
+				break
+			}
+			grouping := ts.next(ts.next(i))
+			sets := ts.next(grouping)
+			if !ts.at(grouping).is("grouping") || !ts.at(sets).is("sets") || !ts.at(ts.next(sets)).is("(") {
+				break
+			}
+			groups, end, err := p.parseGroupingSets(ts, ts.next(sets))
+			if err != nil {
+				return nil, err
+			}
+			if len(groups) > 0 {
+				out = append(out, ts[i:ts.next(i)+1]...)
+				out = append(out, token{tokenSpace, " "})
+				out = append(out, groups...)
+			} else {
+				// 只有空集合时不分组
+				out = out.trim()
+			}
+			i = end
+			continue
+		case depth == 0 && t.is("order") && ts.at(ts.next(i)).is("by"):
+			orderBy, orderIndex, p.orderModifiers = true, 0, nil
+		case depth == 0 && (t.is("limit") || t.is("slimit")):
+			orderBy = false
+		case orderBy && depth == 0 && t.is("nulls"):
+			j := ts.next(i)
+			if !ts.at(j).is("first") && !ts.at(j).is("last") {
+				break
+			}
+			p.addOrderModifier(orderIndex, "nulls "+strings.ToLower(ts[j].text))
+			out = out.trim()
+			i = j
+			continue
+		case orderBy && depth == 0 && t.is("collate"):
+			j := ts.next(i)
+			if ts.at(j).kind != tokenString {
+				break
+			}
+			p.addOrderModifier(orderIndex, "collate "+ts[j].text)
+			out = out.trim()
+			i = j
+			continue
+		case t.is("ilike"):
+			start := ts.next(i)
+			end := ts.operandEnd(start)
+			if end < 0 {
+				break
+			}
+			out = append(out, token{tokenWord, "like"})
+			out = append(out, ts[i+1:start]...)
+			out = append(out, token{tokenWord, markerILike}, token{tokenSymbol, "("})
+			out = append(out, ts[start:end+1]...)
+			out = append(out, token{tokenSymbol, ")"})
+			i = end
+			continue
+		case t.is("hasany") || t.is("hasall"):
+			j := ts.next(i)
+			if !ts.at(j).is("(") {
+				break
+			}
+			marker := markerHasAny
+			if t.is("hasall") {
+				marker = markerHasAll
+			}
+			out = append(out, token{tokenWord, "in"})
+			out = append(out, ts[i+1:j]...)
+			out = append(out, token{tokenSymbol, "("}, token{tokenWord, marker})
+			if !ts.at(ts.next(j)).is(")") {
+				out = append(out, token{tokenSymbol, ","}, token{tokenSpace, " "})
+			}
+			depth++
+			i = j
+			continue
+		}
+		out = append(out, t)
+	}
+	p.SQL = out.String()
+	return p, nil
+}
+
+// 解析settings之后的k=v, ...
+func parseSettings(ts tokens) (map[string]string, error) {
+	settings := map[string]string{}
+	for _, item := range ts.split() {
+		text := item.trim().String()
+		key, value, ok := strings.Cut(text, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || !ValidSetting(key, value) {
+			return nil, fmt.Errorf("settings item '%s' is invalid, it should be like key=value", text)
+		}
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// ValidSetting key为标识符，value为数字、标识符或单引号字符串
+func ValidSetting(key, value string) bool {
+	return settingKeyRegexp.MatchString(key) && settingValueRegexp.MatchString(value)
+}
+
+// parseGroupingSets 解析start处左括号内的grouping sets ((a), (a, b), ())，
+// 返回去重后逗号分隔的group及右括号的下标，并记录每个集合中的group在其中的下标
+func (p *Preparsed) parseGroupingSets(ts tokens, start int) (tokens, int, error) {
+	end := ts.match(start)
+	if end < 0 {
+		return nil, 0, fmt.Errorf("grouping sets parentheses mismatch: %s", ts[start:].String())
+	}
+	groups := []tokens{}
+	texts := []string{}
+	sets := [][]int{}
+	for _, item := range ts[start+1 : end].split() {
+		item = item.trim()
+		setGroups := []tokens{item}
+		if len(item) > 0 && item[0].is("(") && item.match(0) == len(item)-1 {
+			setGroups = item[1 : len(item)-1].split()
+		}
+		set := []int{}
+		for _, group := range setGroups {
+			group = group.trim()
+			if len(group) == 0 {
+				continue
+			}
+			index := slices.Index(texts, group.String())
+			if index < 0 {
+				groups = append(groups, group)
+				texts = append(texts, group.String())
+				index = len(groups) - 1
+			}
+			set = append(set, index)
+		}
+		sets = append(sets, set)
+	}
+	p.GroupingSets = sets
+	joined := tokens{}
+	for i, group := range groups {
+		if i > 0 {
+			joined = append(joined, token{tokenSymbol, ","}, token{tokenSpace, " "})
+		}
+		joined = append(joined, group...)
+	}
+	return joined, end, nil
+}
+
+func (p *Preparsed) addOrderModifier(index int, modifier string) {
+	for len(p.orderModifiers) <= index {
+		p.orderModifiers = append(p.orderModifiers, "")
+	}
+	p.orderModifiers[index] = strings.TrimSpace(p.orderModifiers[index] + " " + modifier)
+}
+
+// rewriteFillNull 将out末尾的func(x)改写为FillNull(func(x), 0)，args为fillnull左括号在ts中的下标，返回fillnull右括号的下标
+func rewriteFillNull(out tokens, ts tokens, i, args int) (tokens, int, error) {
+	end := ts.match(args)
+	open := out.matchBackward(len(out) - 1)
+	if end < 0 || open < 0 {
+		return nil, 0, fmt.Errorf("fillnull parentheses mismatch: %s", ts[i+1:].String())
+	}
+	name := open - 1
+	if name < 0 || (out[name].kind != tokenWord && out[name].kind != tokenQuoted) {
+		return nil, 0, fmt.Errorf("fillnull should follow a function: %s", ts[i+1:].String())
+	}
+	rewritten := append(tokens{{tokenWord, FUNCTION_FILL_NULL}, {tokenSymbol, "("}}, out[name:]...)
+	rewritten = append(rewritten, token{tokenSymbol, ","}, token{tokenSpace, " "})
+	rewritten = append(rewritten, ts[args+1:end].trim()...)
+	rewritten = append(rewritten, token{tokenSymbol, ")"})
+	return append(out[:name], rewritten...), end, nil
+}
+
+// restore 将带标记的like、in还原为ilike、hasAny/hasAll，并将排序修饰拼接到排序方向中
+func (p *Preparsed) restore(stmt sqlparser.Statement) {
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if expr, ok := node.(*sqlparser.ComparisonExpr); ok {
+			restoreMarker(expr)
+		}
+		return true, nil
+	}, stmt)
+	var orderBy sqlparser.OrderBy
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		orderBy = stmt.OrderBy
+	case *sqlparser.Union:
+		orderBy = stmt.OrderBy
+	}
+	for i, modifier := range p.orderModifiers {
+		if modifier != "" && i < len(orderBy) {
+			orderBy[i].Direction += " " + modifier
+		}
+	}
+}
+
+func restoreMarker(expr *sqlparser.ComparisonExpr) {
+	switch expr.Operator {
+	case sqlparser.LikeStr, sqlparser.NotLikeStr:
+		function, ok := expr.Right.(*sqlparser.FuncExpr)
+		if !ok || function.Name.Lowered() != markerILike || len(function.Exprs) != 1 {
+			return
+		}
+		if arg, ok := function.Exprs[0].(*sqlparser.AliasedExpr); ok {
+			expr.Operator = strings.Replace(expr.Operator, sqlparser.LikeStr, "ilike", 1)
+			expr.Right = arg.Expr
+		}
+	case sqlparser.InStr, sqlparser.NotInStr:
+		tuple, ok := expr.Right.(sqlparser.ValTuple)
+		if !ok || len(tuple) == 0 {
+			return
+		}
+		colName, ok := tuple[0].(*sqlparser.ColName)
+		if !ok || !colName.Qualifier.IsEmpty() {
+			return
+		}
+		operator := ""
+		switch colName.Name.Lowered() {
+		case markerHasAny:
+			operator = OPERATOR_HAS_ANY
+		case markerHasAll:
+			operator = OPERATOR_HAS_ALL
+		default:
+			return
+		}
+		if expr.Operator == sqlparser.NotInStr {
+			operator = "not " + operator
+		}
+		expr.Operator = operator
+		expr.Right = tuple[1:]
+	}
+}
+
+// token的类型
+const (
+	tokenSpace  = iota
+	tokenWord   // 关键字、标识符及数字，如from、l4_flow_log、1.5、$__from
+	tokenQuoted // 反引号包裹的标识符
+	tokenString // 单引号或双引号包裹的字符串
+	tokenSymbol // 括号、逗号及操作符，每个字符一个token
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+// is 判断是否为指定的关键字(忽略大小写)或符号
+func (t token) is(text string) bool {
+	return (t.kind == tokenWord || t.kind == tokenSymbol) && strings.EqualFold(t.text, text)
+}
+
+type tokens []token
+
+// tokenize 将sql切分为token，拼接所有token的text即为原始sql
+func tokenize(sql string) tokens {
+	ts := tokens{}
+	for i := 0; i < len(sql); {
+		start := i
+		kind := tokenSymbol
+		switch c := sql[i]; {
+		case isSpace(c):
+			kind = tokenSpace
+			for i < len(sql) && isSpace(sql[i]) {
+				i++
+			}
+		case c == '\'' || c == '"':
+			kind = tokenString
+			i = scanQuoted(sql, i)
+		case c == '`':
+			kind = tokenQuoted
+			i = scanQuoted(sql, i)
+		case isWordChar(c):
+			kind = tokenWord
+			for i < len(sql) && isWordChar(sql[i]) {
+				i++
+			}
+		default:
+			i++
+		}
+		ts = append(ts, token{kind, sql[start:i]})
+	}
+	return ts
+}
+
+// scanQuoted 返回start处的引号对应的结束引号之后的下标，未闭合时为sql的长度，
+// 与sqlparser一致，引号可以写两次表示引号本身，字符串中还可以用\转义
+func scanQuoted(sql string, start int) int {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (ts tokens) String() string {
+	var buf strings.Builder
+	for _, t := range ts {
+		buf.WriteString(t.text)
+	}
+	return buf.String()
+}
+
+// at 越界时返回空token
+func (ts tokens) at(i int) token {
+	if i < 0 || i >= len(ts) {
+		return token{}
+	}
+	return ts[i]
+}
+
+// next 返回i之后第一个非空白token的下标，没有时为len(ts)
+func (ts tokens) next(i int) int {
+	for i++; i < len(ts) && ts[i].kind == tokenSpace; i++ {
+	}
+	return i
+}
+
+// trim 去掉首尾的空白
+func (ts tokens) trim() tokens {
+	for len(ts) > 0 && ts[0].kind == tokenSpace {
+		ts = ts[1:]
+	}
+	for len(ts) > 0 && ts[len(ts)-1].kind == tokenSpace {
+		ts = ts[:len(ts)-1]
+	}
+	return ts
+}
+
+// match 返回与start处左括号匹配的右括号下标，没有时为-1
+func (ts tokens) match(start int) int {
+	depth := 0
+	for i := start; i < len(ts); i++ {
+		if ts[i].is("(") {
+			depth++
+		} else if ts[i].is(")") {
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// matchBackward 返回与end处右括号匹配的左括号下标，没有时为-1
+func (ts tokens) matchBackward(end int) int {
+	depth := 0
+	for i := end; i >= 0; i-- {
+		if ts[i].is(")") {
+			depth++
+		} else if ts[i].is("(") {
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// split 按最外层的逗号切分，括号内的逗号不切分
+func (ts tokens) split() []tokens {
+	items := []tokens{}
+	depth, last := 0, 0
+	for i, t := range ts {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case t.is(",") && depth == 0:
+			items = append(items, ts[last:i])
+			last = i + 1
+		}
+	}
+	return append(items, ts[last:])
+}
+
+// operandEnd 返回start处操作数的最后一个token的下标，操作数为括号、函数调用或单个值，其他情况为-1
+func (ts tokens) operandEnd(start int) int {
+	switch t := ts.at(start); {
+	case t.is("("):
+		return ts.match(start)
+	case t.kind == tokenWord || t.kind == tokenQuoted:
+		if ts.at(start + 1).is("(") {
+			return ts.match(start + 1)
+		}
+		return start
+	case t.kind == tokenString:
+		return start
+	}
+	return -1
+}
+
+// tableEnd 返回start处表名的最后一个token的下标，不是表名时为-1
+func (ts tokens) tableEnd(start int) int {
+	end := start
+	for end < len(ts) && (ts[end].kind == tokenWord || ts[end].kind == tokenQuoted) {
+		end++
+	}
+	return end - 1
+}
+
+// tableFunction 判断start处是否为表函数，返回作为表名的参数的起止下标及表函数的右括号下标
+func (ts tokens) tableFunction(start int) (int, int, int, bool) {
+	name := ts.at(start)
+	if name.kind != tokenWord || !slices.ContainsFunc(TABLE_FUNCTIONS, func(function string) bool { return name.is(function) }) {
+		return 0, 0, 0, false
+	}
+	open := ts.next(start)
+	if !ts.at(open).is("(") {
+		return 0, 0, 0, false
+	}
+	end := ts.match(open)
+	if end < 0 {
+		return 0, 0, 0, false
+	}
+	offset := open + 1
+	for _, arg := range ts[open+1 : end].split() {
+		trimmed := arg.trim()
+		lead := 0
+		for lead < len(arg) && arg[lead].kind == tokenSpace {
+			lead++
+		}
+		if len(trimmed) > 0 && offset+lead+len(trimmed)-1 == ts.tableEnd(offset+lead) {
+			return offset + lead, offset + lead + len(trimmed) - 1, end, true
+		}
+		offset += len(arg) + 1
+	}
+	return 0, 0, 0, false
+}
+
+// final 返回表名(及as <alias>)之后final的下标，end为表名的最后一个token，没有final时为-1
+func (ts tokens) final(end int) int {
+	next := ts.next(end)
+	if ts.at(next).is("as") {
+		if alias := ts.next(next); ts.at(alias).kind == tokenWord || ts.at(alias).kind == tokenQuoted {
+			next = ts.next(alias)
+		}
+	}
+	if ts.at(next).is("final") {
+		return next
+	}
+	return -1
+}
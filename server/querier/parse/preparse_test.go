@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+func TestPrepare(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		sql           string
+		output        string
+		settings      map[string]string
+		withTotals    bool
+		finals        []bool
+		tableFunction *TableFunction
+		groupingSets  [][]int
+		wantErr       string
+	}{{
+		name:   "ilike",
+		sql:    "select region_0 from l4_flow_log where region_0 like 'a%' and (region_1 ilike 'b*' or region_0 not ilike 'c*')",
+		output: "select region_0 from l4_flow_log where region_0 like 'a%' and (region_1 ilike 'b*' or region_0 not ilike 'c*')",
+	}, {
+		// 还原不依赖遍历顺序，in与hasAny交替出现
+		name:   "array_operators",
+		sql:    "select region_0 from l4_flow_log where tags hasAll ('a') and region_0 in ('x') and tags not hasAny ('b', 'c') and tags hasAny ()",
+		output: "select region_0 from l4_flow_log where tags hasAll ('a') and region_0 in ('x') and tags not hasAny ('b', 'c') and tags hasAny ()",
+	}, {
+		// 引号内用''及\'转义的引号不结束字符串，其中的关键字不改写
+		name:   "escaped_quotes",
+		sql:    `select region_0 from l4_flow_log where region_0 = 'it''s ilike final' and region_1 = 'a\' hasAny (1) settings x=1' and region_0 ilike 'b''c'`,
+		output: `select region_0 from l4_flow_log where region_0 = 'it\'s ilike final' and region_1 = 'a\' hasAny (1) settings x=1' and region_0 ilike 'b\'c'`,
+	}, {
+		name:   "escaped_backquote",
+		sql:    "select `a``final` from l4_flow_log final limit 1",
+		output: "select `a``final` from l4_flow_log limit 1",
+		finals: []bool{true},
+	}, {
+		name:     "settings",
+		sql:      "select region_0 from l4_flow_log where region_0 = 'settings a=b' limit 1 settings max_threads=4, log_comment='x'",
+		output:   "select region_0 from l4_flow_log where region_0 = 'settings a=b' limit 1",
+		settings: map[string]string{"max_threads": "4", "log_comment": "'x'"},
+	}, {
+		name:    "settings_invalid",
+		sql:     "select region_0 from l4_flow_log settings max_threads",
+		wantErr: "settings item 'max_threads' is invalid, it should be like key=value",
+	}, {
+		name:       "with_totals",
+		sql:        "select region_0, Sum(byte) as b from l4_flow_log group by region_0 with totals order by b desc nulls last collate 'zh' limit 1",
+		output:     "select region_0, Sum(byte) as b from l4_flow_log group by region_0 order by b desc nulls last collate 'zh' limit 1",
+		withTotals: true,
+	}, {
+		name:    "with_totals_without_group_by",
+		sql:     "select Sum(byte) as b from l4_flow_log with totals",
+		wantErr: "with totals requires group by",
+	}, {
+		name:   "final_with_alias",
+		sql:    "select f.region_0 from l4_flow_log as f final where f.region_0 = 'final'",
+		output: "select f.region_0 from l4_flow_log as f where f.region_0 = 'final'",
+		finals: []bool{true},
+	}, {
+		name:   "finals_of_union",
+		sql:    "select region_0 from l4_flow_log union all select region_0 from l7_flow_log final",
+		output: "select region_0 from l4_flow_log union all select region_0 from l7_flow_log",
+		finals: []bool{false, true},
+	}, {
+		name:          "table_function",
+		sql:           "select region_0 from cluster('df', flow_log.l4_flow_log) as f final where region_0 = 'cluster(a, b)'",
+		output:        "select region_0 from flow_log.l4_flow_log as f where region_0 = 'cluster(a, b)'",
+		finals:        []bool{true},
+		tableFunction: &TableFunction{Prefix: "cluster('df', ", Suffix: ")"},
+	}, {
+		name:         "grouping_sets",
+		sql:          "select region_0, region_1, Sum(byte) as b from l4_flow_log group by grouping sets ((region_0), (region_0, region_1), ()) limit 1",
+		output:       "select region_0, region_1, Sum(byte) as b from l4_flow_log group by region_0, region_1 limit 1",
+		groupingSets: [][]int{{0}, {0, 1}, {}},
+	}, {
+		name:   "fillnull",
+		sql:    "select Sum(byte) fillnull(0) as b, Avg(rtt) fillnull (1) as r from l4_flow_log",
+		output: "select FillNull(Sum(byte), 0) as b, FillNull(Avg(rtt), 1) as r from l4_flow_log",
+	}, {
+		name:    "fillnull_without_function",
+		sql:     "select (byte) fillnull(0) as b from l4_flow_log",
+		wantErr: "fillnull should follow a function:  fillnull(0) as b from l4_flow_log",
+	}} {
+		stmt, pre, err := Prepare(tc.sql)
+		if tc.wantErr != "" {
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("%s: get error %v, want %s", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if output := sqlparser.String(stmt); output != tc.output {
+			t.Errorf("%s:\nget  %s\nwant %s", tc.name, output, tc.output)
+		}
+		if !reflect.DeepEqual(pre.Settings, tc.settings) {
+			t.Errorf("%s: get settings %v, want %v", tc.name, pre.Settings, tc.settings)
+		}
+		if pre.WithTotals != tc.withTotals {
+			t.Errorf("%s: get with totals %t, want %t", tc.name, pre.WithTotals, tc.withTotals)
+		}
+		if tc.finals != nil && !reflect.DeepEqual(pre.Finals, tc.finals) {
+			t.Errorf("%s: get finals %v, want %v", tc.name, pre.Finals, tc.finals)
+		}
+		if !reflect.DeepEqual(pre.TableFunction(), tc.tableFunction) {
+			t.Errorf("%s: get table function %v, want %v", tc.name, pre.TableFunction(), tc.tableFunction)
+		}
+		if !reflect.DeepEqual(pre.GroupingSets, tc.groupingSets) {
+			t.Errorf("%s: get grouping sets %v, want %v", tc.name, pre.GroupingSets, tc.groupingSets)
+		}
+	}
+}
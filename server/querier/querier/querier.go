@@ -25,6 +25,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	logging "github.com/op/go-logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	yaml "gopkg.in/yaml.v2"
 
 	servercommon "github.com/deepflowio/deepflow/server/common"
@@ -36,6 +38,7 @@ import (
 	tracing_adapter "github.com/deepflowio/deepflow/server/querier/app/tracing-adapter/router"
 	"github.com/deepflowio/deepflow/server/querier/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/client"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/trans_prometheus"
 	profile_router "github.com/deepflowio/deepflow/server/querier/profile/router"
@@ -82,6 +85,11 @@ func Start(configPath, serverLogFile string, shared *servercommon.ControllerInge
 		os.Exit(0)
 	}
 
+	// engine的prometheus指标，通过/metrics暴露
+	if _, err := clickhouse.RegisterEngineMetrics(prometheus.DefaultRegisterer); err != nil {
+		log.Errorf("register engine metrics failed: %s", err)
+	}
+
 	// prometheus dict cache
 	go trans_prometheus.GeneratePrometheusMap()
 
@@ -104,6 +112,7 @@ func Start(configPath, serverLogFile string, shared *servercommon.ControllerInge
 	r.Use(StatdHandle())
 	r.Use(ErrHandle())
 	router.QueryRouter(r)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	profile_router.ProfileRouter(r, &cfg)
 	prometheus_router.PrometheusRouter(r)
 	tracing_adapter.TracingAdapterRouter(r)
@@ -17,6 +17,8 @@
 package router
 
 import (
+	"fmt"
+	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -28,6 +30,9 @@ import (
 
 func QueryRouter(e *gin.Engine) {
 	e.POST("/v1/query/", executeQuery())
+	e.POST("/v1/query/validate/", validateQuery())
+	e.GET("/v1/query/slow-queries/", slowQueries())
+	e.GET("/v1/query/functions/", supportedFunctions())
 
 	// api router for tempo
 	e.GET("/api/traces/:traceId", tempoTraceReader())
@@ -37,6 +42,44 @@ func QueryRouter(e *gin.Engine) {
 	e.GET("/api/search", tempoSearchReader())
 }
 
+func validateQuery() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		args := common.QuerierParams{}
+		args.Context = c.Request.Context()
+		args.ORGID = c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+		if args.ORGID == "" {
+			args.ORGID = common.DEFAULT_ORG_ID
+		}
+		args.DB = c.PostForm("db")
+		args.Sql = c.PostForm("sql")
+		args.DataSource = c.PostForm("data_precision")
+		if args.Sql == "" && args.DB == "" {
+			json := make(map[string]interface{})
+			c.BindJSON(&json)
+			args.DB, _ = json["db"].(string)
+			args.Sql, _ = json["sql"].(string)
+		}
+		result, err := service.Validate(&args)
+		JsonResponse(c, result, nil, err)
+	})
+}
+
+func slowQueries() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		orgID := c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+		if orgID == "" {
+			orgID = common.DEFAULT_ORG_ID
+		}
+		JsonResponse(c, service.SlowQueries(orgID), nil, nil)
+	})
+}
+
+func supportedFunctions() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		JsonResponse(c, service.SupportedFunctions(), nil, nil)
+	})
+}
+
 func executeQuery() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		args := common.QuerierParams{}
@@ -47,6 +90,17 @@ func executeQuery() gin.HandlerFunc {
 		args.QueryCacheTTL = c.Query("query_cache_ttl")
 		args.QueryUUID = c.Query("query_uuid")
 		args.NoPreWhere, _ = strconv.ParseBool(c.DefaultQuery("no_prewhere", "false"))
+		args.PreWhere, _ = strconv.ParseBool(c.DefaultQuery("prewhere", "false"))
+		args.NoDivZeroGuard, _ = strconv.ParseBool(c.DefaultQuery("no_div_zero_guard", "false"))
+		args.AlignTimeRange, _ = strconv.ParseBool(c.DefaultQuery("align_time_range", "false"))
+		args.TimestampMilli, _ = strconv.ParseBool(c.DefaultQuery("timestamp_milli", "false"))
+		args.DefaultGroupOrder, _ = strconv.ParseBool(c.DefaultQuery("default_group_order", "false"))
+		// Grafana模板中的$__from、$__to及$__interval
+		args.TimeFrom, _ = strconv.ParseInt(c.Query("from"), 10, 64)
+		args.TimeTo, _ = strconv.ParseInt(c.Query("to"), 10, 64)
+		args.Interval, _ = strconv.Atoi(c.Query("interval"))
+		args.MaxPoints, _ = strconv.Atoi(c.Query("max_points"))
+		args.Comment = c.Query("comment")
 		args.ORGID = c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
 		args.Language = c.Request.Header.Get(common.HEADER_KEY_LANGUAGE)
 		// if no org_id in header, set default org id
@@ -67,6 +121,36 @@ func executeQuery() gin.HandlerFunc {
 			args.Sql, _ = json["sql"].(string)
 		}
 
+		// 非json格式直接按行编码查询结果，出错时仍返回json
+		if format := c.Query("format"); format != "" && format != common.RESULT_FORMAT_JSON {
+			encoder, ok := common.GetResultEncoder(format)
+			if !ok {
+				BadRequestResponse(c, common.INVALID_PARAMETERS, fmt.Sprintf("unsupported format %s", format))
+				return
+			}
+			var result *common.Result
+			var debug map[string]interface{}
+			var err error
+			if args.SimpleSql {
+				result, debug, err = service.SimpleExecuteResult(&args)
+			} else {
+				result, debug, err = service.ExecuteResult(&args)
+			}
+			if err != nil {
+				JsonResponse(c, nil, debug, err)
+				return
+			}
+			if result == nil {
+				result = &common.Result{}
+			}
+			c.Header("Content-Type", encoder.ContentType())
+			c.Status(http.StatusOK)
+			if err := encoder.Encode(c.Writer, result); err != nil {
+				c.Error(err)
+			}
+			return
+		}
+
 		result := map[string]interface{}{}
 		debug := map[string]interface{}{}
 		var err error
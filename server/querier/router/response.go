@@ -55,6 +55,13 @@ func BadRequestResponse(c *gin.Context, optStatus string, description string) {
 	})
 }
 
+func TooManyRequestsResponse(c *gin.Context, optStatus string, description string) {
+	c.JSON(http.StatusTooManyRequests, Response{
+		OptStatus:   optStatus,
+		Description: description,
+	})
+}
+
 func InternalErrorResponse(c *gin.Context, data interface{}, debug interface{}, optStatus string, description string) {
 	c.JSON(http.StatusInternalServerError, Response{
 		OptStatus:   optStatus,
@@ -72,6 +79,8 @@ func JsonResponse(c *gin.Context, data interface{}, debug interface{}, err error
 			case common.RESOURCE_NOT_FOUND, common.INVALID_POST_DATA, common.RESOURCE_NUM_EXCEEDED,
 				common.SELECTED_RESOURCES_NUM_EXCEEDED:
 				BadRequestResponse(c, t.Status, t.Message)
+			case common.TOO_MANY_QUERIES:
+				TooManyRequestsResponse(c, t.Status, t.Message)
 			case common.SERVER_ERROR:
 				InternalErrorResponse(c, data, debug, t.Status, t.Message)
 			}
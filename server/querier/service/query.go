@@ -17,12 +17,24 @@
 package service
 
 import (
+	"errors"
+
 	"github.com/deepflowio/deepflow/server/querier/common"
 	"github.com/deepflowio/deepflow/server/querier/engine"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse"
+	"github.com/deepflowio/deepflow/server/querier/parse"
 )
 
 func Execute(args *common.QuerierParams) (jsonData map[string]interface{}, debug map[string]interface{}, err error) {
+	result, debug, err := ExecuteResult(args)
+	if result != nil {
+		jsonData = result.ToJson()
+	}
+	return jsonData, debug, err
+}
+
+// ExecuteResult 返回未转换为json的查询结果，供其他输出格式编码
+func ExecuteResult(args *common.QuerierParams) (*common.Result, map[string]interface{}, error) {
 	db := getDbBy()
 	var engine engine.Engine
 	switch db {
@@ -30,11 +42,7 @@ func Execute(args *common.QuerierParams) (jsonData map[string]interface{}, debug
 		engine = &clickhouse.CHEngine{DB: args.DB, DataSource: args.DataSource, Context: args.Context}
 		engine.Init()
 	}
-	result, debug, err := engine.ExecuteQuery(args)
-	if result != nil {
-		jsonData = result.ToJson()
-	}
-	return jsonData, debug, err
+	return engine.ExecuteQuery(args)
 }
 
 func getDbBy() string {
@@ -42,9 +50,51 @@ func getDbBy() string {
 }
 
 func SimpleExecute(args *common.QuerierParams) (jsonData map[string]interface{}, debug map[string]interface{}, err error) {
-	result, debug, err := clickhouse.SimpleExecute(args)
+	result, debug, err := SimpleExecuteResult(args)
 	if result != nil {
 		jsonData = result.ToJson()
 	}
 	return jsonData, debug, err
 }
+
+func SimpleExecuteResult(args *common.QuerierParams) (*common.Result, map[string]interface{}, error) {
+	return clickhouse.SimpleExecute(args)
+}
+
+// Validate 只检查sql是否合法，不执行查询
+func Validate(args *common.QuerierParams) (map[string]interface{}, error) {
+	e := &clickhouse.CHEngine{DB: args.DB, DataSource: args.DataSource, Context: args.Context, ORGID: args.ORGID}
+	e.Init()
+	err := e.Validate(args.Sql)
+	if err == nil {
+		return map[string]interface{}{"valid": true}, nil
+	}
+	var validateErr *clickhouse.ValidateError
+	if !errors.As(err, &validateErr) {
+		return nil, err
+	}
+	response := map[string]interface{}{
+		"valid":   false,
+		"type":    validateErr.Type,
+		"name":    validateErr.Name,
+		"message": validateErr.Message,
+	}
+	// 语法错误时返回出错的位置，供前端标出
+	var parseErr *parse.ParseError
+	if errors.As(err, &parseErr) {
+		response["position"] = parseErr.Position
+		response["near"] = parseErr.Near
+		response["snippet"] = parseErr.Snippet
+	}
+	return response, nil
+}
+
+// SlowQueries 返回内存中保留的orgID的最近慢查询，未开启慢查询日志时为空
+func SlowQueries(orgID string) []clickhouse.SlowQuery {
+	return clickhouse.GetSlowQueryLog().List(orgID)
+}
+
+// SupportedFunctions 返回支持的算子及其参数个数、说明，用于前端补全
+func SupportedFunctions() []clickhouse.FunctionSignature {
+	return clickhouse.SupportedFunctions()
+}